@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var ArchiveCommand = &cli.Command{
+	Name:      "archive",
+	Usage:     "soft-delete keystore entries, keeping them for recovery",
+	ArgsUsage: "out.jks in.jks [alias…]",
+	Description: "Copies in.jks to out.jks, renaming each given entry " +
+		"with a timestamped \"" + jks.ArchiveSuffix + "\" suffix " +
+		"instead of deleting it, so that it can be recovered after " +
+		"a rotation. Archived entries are ignored by coverage. With " +
+		"--purge-after, entries archived longer ago than that are " +
+		"removed for good.",
+	Action: Archive,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name: "purge-after",
+			Usage: "remove entries archived longer ago than " +
+				"this, e.g. 720h",
+		},
+	},
+}
+
+func init() {
+	ArchiveCommand.Flags = addJksOptsFlags(ArchiveCommand.Flags)
+}
+
+func Archive(c *cli.Context) error {
+	if c.NArg() < 2 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need output and input file names")
+	}
+	if c.NArg() == 2 && !c.IsSet("purge-after") {
+		return errors.New("need aliases to archive, or --purge-after")
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if opts.SkipVerifyDigest {
+		return errors.New("need --password to write output")
+	}
+
+	args := c.Args().Slice()
+	outFn, inFn, aliases := args[0], args[1], args[2:]
+	raw, err := readLocation(inFn)
+	if err != nil {
+		return err
+	}
+	ks, err := jks.Parse(raw, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", inFn, err)
+	}
+
+	now := time.Now()
+	var cs jks.ChangeSet
+	for _, alias := range aliases {
+		cs.Archive(alias, now)
+	}
+	if err := cs.Apply(ks, opts); err != nil {
+		return fmt.Errorf("%s: %v", inFn, err)
+	}
+	for _, alias := range aliases {
+		fmt.Printf("archived %q as %q\n", alias,
+			jks.ArchivedAlias(alias, now))
+	}
+	if c.IsSet("purge-after") {
+		for _, alias := range ks.PurgeArchived(
+			now.Add(-c.Duration("purge-after"))) {
+			fmt.Printf("purged %q\n", alias)
+		}
+	}
+
+	raw, err = ks.Pack(opts)
+	if err != nil {
+		return err
+	}
+	return writeLocation(outFn, raw, 0600)
+}
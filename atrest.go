@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/urfave/cli/v2"
+)
+
+// atRestFlags are global flags controlling encryption of the keystore files
+// that we read and write, so that they can be stored in e.g. Git.
+var atRestFlags = []cli.Flag{
+	&cli.StringSliceFlag{
+		Name:    "encrypt-to",
+		EnvVars: []string{"MINIJKS_ENCRYPT_TO"},
+		Usage: "encrypt keystore files written to this age " +
+			"recipient (age1…) or, for anything else, OpenPGP " +
+			"recipient using gpg; may be repeated",
+	},
+	&cli.StringSliceFlag{
+		Name:    "decrypt",
+		EnvVars: []string{"MINIJKS_DECRYPT"},
+		Usage: "decrypt age-encrypted keystore files using the " +
+			"identities in this file; may be repeated",
+	},
+}
+
+// atRest holds the settings from atRestFlags. It is set by atRestBefore,
+// before any command runs.
+var atRest struct {
+	ageRecipients []age.Recipient
+	gpgRecipients []string
+	identities    []age.Identity
+}
+
+// Headers which mark encrypted files. We always write armored output, but also
+// accept binary age files.
+const (
+	ageBinaryHeader = "age-encryption.org/v1\n"
+	pgpArmorHeader  = "-----BEGIN PGP MESSAGE-----"
+)
+
+// atRestBefore parses atRestFlags.
+func atRestBefore(c *cli.Context) error {
+	for _, r := range c.StringSlice("encrypt-to") {
+		if !strings.HasPrefix(r, "age1") {
+			atRest.gpgRecipients = append(atRest.gpgRecipients, r)
+			continue
+		}
+		rcpt, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return fmt.Errorf("--encrypt-to: %v", err)
+		}
+		atRest.ageRecipients = append(atRest.ageRecipients, rcpt)
+	}
+	if len(atRest.ageRecipients) > 0 && len(atRest.gpgRecipients) > 0 {
+		return errors.New("--encrypt-to: cannot mix age and OpenPGP " +
+			"recipients")
+	}
+
+	for _, fn := range c.StringSlice("decrypt") {
+		f, err := os.Open(fn)
+		if err != nil {
+			return fmt.Errorf("--decrypt: %v", err)
+		}
+		ids, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("--decrypt: %s: %v", fn, err)
+		}
+		atRest.identities = append(atRest.identities, ids...)
+	}
+	return nil
+}
+
+// seal encrypts keystore data for the --encrypt-to recipients, if any. It is
+// returned unchanged otherwise.
+func seal(raw []byte) ([]byte, error) {
+	switch {
+	case len(atRest.ageRecipients) > 0:
+		var buf bytes.Buffer
+		aw := armor.NewWriter(&buf)
+		w, err := age.Encrypt(aw, atRest.ageRecipients...)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(raw); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		if err = aw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case len(atRest.gpgRecipients) > 0:
+		args := []string{"--batch", "--yes", "--armor", "--encrypt"}
+		for _, r := range atRest.gpgRecipients {
+			args = append(args, "--recipient", r)
+		}
+		return runGPG(raw, args...)
+	}
+	return raw, nil
+}
+
+// unseal decrypts keystore data that was encrypted with age or OpenPGP. Data
+// which is not encrypted is returned unchanged.
+func unseal(raw []byte) ([]byte, error) {
+	trimmed := bytes.TrimLeft(raw, " \t\r\n")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte(armor.Header)),
+		bytes.HasPrefix(raw, []byte(ageBinaryHeader)):
+		if len(atRest.identities) == 0 {
+			return nil, errors.New("file is encrypted with age; " +
+				"need --decrypt identity file")
+		}
+		var r io.Reader = bytes.NewReader(raw)
+		if !bytes.HasPrefix(raw, []byte(ageBinaryHeader)) {
+			r = armor.NewReader(bytes.NewReader(trimmed))
+		}
+		dr, err := age.Decrypt(r, atRest.identities...)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(dr)
+
+	case bytes.HasPrefix(trimmed, []byte(pgpArmorHeader)):
+		return runGPG(raw, "--batch", "--quiet", "--decrypt")
+	}
+	return raw, nil
+}
+
+// runGPG runs gpg with the given arguments, passing in on stdin and returning
+// its stdout.
+func runGPG(in []byte, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf("gpg: %v", err)
+		}
+		return nil, fmt.Errorf("gpg: %v: %s", err, msg)
+	}
+	return stdout.Bytes(), nil
+}
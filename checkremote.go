@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var CheckRemoteCommand = &cli.Command{
+	Name:      "check-remote",
+	Usage:     "check that a server presents a keystore entry",
+	ArgsUsage: "host:port",
+	Description: "Connects to the host with TLS and compares the leaf " +
+		"certificate it serves against the given keystore entry, " +
+		"reporting both fingerprints and expiry dates. Exits with a " +
+		"non-zero status if they differ, so that rotation pipelines " +
+		"can confirm that a new certificate has been deployed.",
+	Action: CheckRemote,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "keystore",
+			Required: true,
+			Usage:    "keystore holding the expected certificate",
+		},
+		&cli.StringFlag{
+			Name:     "alias",
+			Required: true,
+			Usage: "alias of the keypair or trusted " +
+				"certificate entry",
+		},
+		&cli.StringFlag{
+			Name: "servername",
+			Usage: "server name to send with SNI (default: " +
+				"the host)",
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Value: 10 * time.Second,
+			Usage: "time limit for connecting and the TLS " +
+				"handshake",
+		},
+	},
+}
+
+func init() {
+	CheckRemoteCommand.Flags = addJksOptsFlags(CheckRemoteCommand.Flags)
+}
+
+func CheckRemote(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need host:port to connect to")
+	}
+	addr := c.Args().First()
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	filename, alias := c.String("keystore"), c.String("alias")
+	raw, err := readLocation(filename)
+	if err != nil {
+		return err
+	}
+	ks, err := jks.Parse(raw, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	want, err := entryCert(ks, alias)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+	got, err := remoteCert(ctx, addr, c.String("servername"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Keystore:\t%s (alias %q)\n", filename, alias)
+	fmt.Printf("\tSHA-256:\t%s\n", sha256Hex(want.Raw))
+	fmt.Printf("\tExpires:\t%s\n", want.NotAfter.Format(time.RFC3339))
+	fmt.Printf("Server:\t\t%s\n", addr)
+	fmt.Printf("\tSHA-256:\t%s\n", sha256Hex(got.Raw))
+	fmt.Printf("\tExpires:\t%s\n", got.NotAfter.Format(time.RFC3339))
+
+	if got.Equal(want) {
+		fmt.Println("OK: server presents the keystore certificate")
+		return nil
+	}
+	switch drift := got.NotAfter.Sub(want.NotAfter); {
+	case drift < 0:
+		fmt.Printf("MISMATCH: server certificate expires %s before "+
+			"the keystore's\n", -drift)
+	case drift > 0:
+		fmt.Printf("MISMATCH: server certificate expires %s after "+
+			"the keystore's\n", drift)
+	default:
+		fmt.Println("MISMATCH: server presents a different " +
+			"certificate")
+	}
+	return errors.New("server does not present the keystore certificate")
+}
+
+// entryCert returns the certificate of the trusted certificate entry with the
+// given alias, or the leaf certificate of the keypair entry.
+func entryCert(ks *jks.Keystore, alias string) (*x509.Certificate, error) {
+	cert, kp := ks.Lookup(alias)
+	switch {
+	case cert != nil:
+		if cert.Cert == nil {
+			return nil, fmt.Errorf("certificate %q: %v", alias,
+				cert.CertErr)
+		}
+		return cert.Cert, nil
+	case kp != nil:
+		if len(kp.CertChain) == 0 {
+			return nil, fmt.Errorf("key %q has no certificate",
+				alias)
+		}
+		if kp.CertChain[0].Cert == nil {
+			return nil, fmt.Errorf("key %q: %v", alias,
+				kp.CertChain[0].CertErr)
+		}
+		return kp.CertChain[0].Cert, nil
+	}
+	return nil, fmt.Errorf("no entry with alias %q", alias)
+}
+
+// remoteCert connects to addr and returns the leaf certificate presented in
+// the TLS handshake. The certificate is not verified, since the point is to
+// compare it against the keystore whether or not it is trusted.
+func remoteCert(ctx context.Context, addr, serverName string,
+) (*x509.Certificate, error) {
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+	d := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificate presented", addr)
+	}
+	return certs[0], nil
+}
+
+// sha256Hex returns the SHA-256 fingerprint of der in upper-case hex.
+func sha256Hex(der []byte) string {
+	sum := sha256.Sum256(der)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
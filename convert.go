@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var ConvertCommand = &cli.Command{
+	Name:      "convert",
+	Usage:     "convert a keystore between the JKS and PKCS#12 formats",
+	ArgsUsage: "in.jks out.p12",
+	Description: "Reads a JKS, JCEKS, PKCS#12 or BKS keystore and writes " +
+		"its entries to a new keystore, in the format given by " +
+		"--storetype or, by default, by the output file's extension " +
+		"(.p12 or .pfx for PKCS#12, otherwise JKS). Every private " +
+		"key must be decrypted, with --password or --key-password. " +
+		"The output is protected with --out-password (by default " +
+		"the same as --password), and each private key with its " +
+		"--out-key-password, or the output password.",
+	Action: Convert,
+	Flags: []cli.Flag{
+		compatFlag,
+		&cli.StringFlag{
+			Name:  "storetype",
+			Usage: "output format: jks or pkcs12",
+		},
+		&cli.StringFlag{
+			Name:  "out-password",
+			Usage: "password of the output keystore",
+		},
+		&cli.StringSliceFlag{
+			Name: "out-key-password",
+			Usage: "password for a given key in the output " +
+				"keystore, as 'alias:password'",
+		},
+	},
+}
+
+func init() {
+	ConvertCommand.Flags = addJksOptsFlags(ConvertCommand.Flags)
+}
+
+func Convert(c *cli.Context) error {
+	if c.NArg() != 2 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need input and output file names")
+	}
+	inFn, outFn := c.Args().Get(0), c.Args().Get(1)
+
+	compat, err := jks.ParseCompatibility(c.String("compat"))
+	if err != nil {
+		return err
+	}
+	format := jks.FormatJKS
+	switch {
+	case c.IsSet("storetype"):
+		if format, err = jks.ParseFormat(
+			c.String("storetype")); err != nil {
+			return err
+		}
+	case strings.EqualFold(filepath.Ext(outFn), ".p12"),
+		strings.EqualFold(filepath.Ext(outFn), ".pfx"):
+		format = jks.FormatPKCS12
+	}
+	if format != jks.FormatJKS && format != jks.FormatPKCS12 {
+		return fmt.Errorf("cannot write %s files", format)
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	outOpts := &jks.Options{
+		Password:      opts.Password,
+		KeyPasswords:  make(map[string]string),
+		Compatibility: compat,
+	}
+	switch {
+	case c.IsSet("out-password"):
+		outOpts.Password = c.String("out-password")
+	case opts.SkipVerifyDigest:
+		return errors.New("need --password or --out-password to " +
+			"write output")
+	}
+	for _, keypass := range c.StringSlice("out-key-password") {
+		p := strings.SplitN(keypass, ":", 2)
+		if len(p) != 2 {
+			return errors.New("invalid --out-key-password argument")
+		}
+		outOpts.KeyPasswords[p[0]] = p[1]
+	}
+
+	raw, err := readLocation(inFn)
+	if err != nil {
+		return err
+	}
+	ks, _, err := jks.ParseAny(raw, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", inFn, err)
+	}
+	for _, kp := range ks.Keypairs {
+		if kp.PrivKeyErr != nil {
+			return fmt.Errorf("%s: key %q: %v", inFn, kp.Alias,
+				kp.PrivKeyErr)
+		}
+	}
+
+	if format == jks.FormatPKCS12 {
+		raw, err = ks.PackPKCS12(outOpts)
+	} else {
+		raw, err = ks.Pack(outOpts)
+	}
+	if err != nil {
+		return err
+	}
+	return writeLocation(outFn, raw, 0600)
+}
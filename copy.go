@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var CopyCommand = &cli.Command{
+	Name:  "copy",
+	Usage: "copy a single entry from one keystore into another",
+	Description: "Copies the entry with the given alias from the --from " +
+		"keystore into the --to keystore, which is updated in place " +
+		"(or created, if it does not exist). The keystores may have " +
+		"different passwords: a keypair's private key is decrypted " +
+		"with --from-password (or --from-key-password) and " +
+		"encrypted afresh with --password (or --key-password, given " +
+		"for the alias the entry ends up with).",
+	Action: Copy,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Required: true,
+			Usage:    "keystore to copy the entry from",
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Required: true,
+			Usage:    "keystore to copy the entry into",
+		},
+		&cli.StringFlag{
+			Name:     "alias",
+			Required: true,
+			Usage:    "alias of the entry to copy",
+		},
+		&cli.StringFlag{
+			Name:  "from-password",
+			Usage: "password of the --from keystore",
+		},
+		&cli.StringSliceFlag{
+			Name: "from-key-password",
+			Usage: "password for a given key in the --from " +
+				"keystore, as 'alias:password'",
+		},
+		collisionFlag,
+	},
+}
+
+func init() {
+	CopyCommand.Flags = addJksOptsFlags(CopyCommand.Flags)
+}
+
+func Copy(c *cli.Context) error {
+	policy, err := jks.ParseCollisionPolicy(c.String("collision"))
+	if err != nil {
+		return err
+	}
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if opts.SkipVerifyDigest {
+		return errors.New("need --password to write output")
+	}
+
+	fromOpts := &jks.Options{
+		Password:     c.String("from-password"),
+		KeyPasswords: make(map[string]string),
+	}
+	if !c.IsSet("from-password") {
+		fromOpts.SkipVerifyDigest = true
+	}
+	for _, keypass := range c.StringSlice("from-key-password") {
+		p := strings.SplitN(keypass, ":", 2)
+		if len(p) != 2 {
+			return errors.New("invalid --from-key-password " +
+				"argument")
+		}
+		fromOpts.KeyPasswords[p[0]] = p[1]
+	}
+
+	fromFn, toFn, alias := c.String("from"), c.String("to"),
+		c.String("alias")
+	raw, err := readLocation(fromFn)
+	if err != nil {
+		return err
+	}
+	src, err := jks.Parse(raw, fromOpts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fromFn, err)
+	}
+
+	dst := new(jks.Keystore)
+	raw, err = readLocation(toFn)
+	switch {
+	case err == nil:
+		if dst, err = jks.Parse(raw, opts); err != nil {
+			return fmt.Errorf("%s: %v", toFn, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	newAlias, err := dst.CopyEntry(src, alias, policy)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%s: %v", fromFn, err)
+	case newAlias == "":
+		fmt.Printf("%q already exists; skipped\n", alias)
+		return nil
+	case newAlias != alias:
+		if pw, ok := opts.KeyPasswords[alias]; ok {
+			opts.KeyPasswords[newAlias] = pw
+		}
+		fmt.Printf("copied %q as %q\n", alias, newAlias)
+	}
+
+	if raw, err = dst.Pack(opts); err != nil {
+		return err
+	}
+	return replaceLocation(toFn, raw)
+}
+
+// replaceLocation stores keystore data at loc, replacing any existing content.
+// Local files are replaced atomically, keeping the mode of the file replaced
+// (so that a keystore shared through group permissions stays readable), or
+// created with mode 0600. As with writeLocation, the data is encrypted first if
+// --encrypt-to was given.
+func replaceLocation(loc string, data []byte) error {
+	if h, _ := lookupLocation(loc); h != nil {
+		return writeLocation(loc, data, 0600)
+	}
+	data, err := seal(data)
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0600)
+	if fi, err := os.Stat(loc); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	return writeFileAtomic(loc, data, perm)
+}
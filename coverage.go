@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var CoverageCommand = &cli.Command{
+	Name:      "coverage",
+	Usage:     "report which hostnames each keypair entry covers",
+	ArgsUsage: "keystore.jks [host...]",
+	Description: "Lists the DNS names and IP addresses covered by the " +
+		"certificate of each keypair entry. Given required hosts " +
+		"(as arguments or with --hosts-file), also shows which " +
+		"entries cover each one, flagging hosts covered by more " +
+		"than one entry (OVERLAP) or by none (GAP). Exits with a " +
+		"non-zero status if there are any gaps.",
+	Action: Coverage,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "hosts-file",
+			Usage: "read required hosts from this file, one per " +
+				"line",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "output format: text, json or yaml",
+		},
+	},
+}
+
+func init() {
+	CoverageCommand.Flags = addJksOptsFlags(CoverageCommand.Flags)
+}
+
+func Coverage(c *cli.Context) error {
+	if c.NArg() < 1 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need name of keystore file")
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+
+	format := c.String("format")
+	switch format {
+	case "text", "json", "yaml":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	hosts := c.Args().Tail()
+	if fn := c.String("hosts-file"); fn != "" {
+		more, err := readHostsFile(fn)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, more...)
+	}
+
+	filename := c.Args().First()
+	raw, err := readLocation(filename)
+	if err != nil {
+		return err
+	}
+	ks, err := jks.Parse(raw, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	r := ks.HostnameCoverage(hosts)
+	if err := writeCoverage(os.Stdout, r, format); err != nil {
+		return err
+	}
+	if gaps := r.Gaps(); len(gaps) != 0 {
+		return fmt.Errorf("%d required host(s) not covered: %s",
+			len(gaps), strings.Join(gaps, ", "))
+	}
+	return nil
+}
+
+// readHostsFile reads a list of hosts, one per line. Blank lines and lines
+// starting with '#' are ignored.
+func readHostsFile(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			hosts = append(hosts, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return hosts, nil
+}
+
+// writeCoverage writes a coverage report in the given format.
+func writeCoverage(w io.Writer, r *jks.CoverageReport, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+
+	for _, ec := range r.Entries {
+		names := append(append([]string(nil), ec.DNSNames...),
+			ec.IPAddresses...)
+		if len(names) == 0 {
+			names = []string{"(no names)"}
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", ec.Alias,
+			strings.Join(names, ", ")); err != nil {
+			return err
+		}
+	}
+	if len(r.Hosts) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	for _, hc := range r.Hosts {
+		var err error
+		switch {
+		case hc.Gap():
+			_, err = fmt.Fprintf(w, "%s\tGAP\n", hc.Host)
+		case hc.Overlap():
+			_, err = fmt.Fprintf(w, "%s\tOVERLAP: %s\n", hc.Host,
+				strings.Join(hc.Aliases, ", "))
+		default:
+			_, err = fmt.Fprintf(w, "%s\t%s\n", hc.Host,
+				hc.Aliases[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
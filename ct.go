@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ctClient looks up certificates in Certificate Transparency logs. Clients
+// are registered in ctClients, keyed by the name given to "verify --ct"; the
+// constructor is passed the value of --ct-endpoint, which is empty if the
+// client's default service should be used.
+type ctClient interface {
+	// Logged reports whether cert appears in any CT log known to the
+	// client.
+	Logged(ctx context.Context, cert *x509.Certificate) (bool, error)
+}
+
+var ctClients = map[string]func(endpoint string) ctClient{
+	"crtsh": newCrtsh,
+}
+
+// crtsh looks up certificates with the crt.sh search service.
+type crtsh struct {
+	endpoint string
+}
+
+func newCrtsh(endpoint string) ctClient {
+	if endpoint == "" {
+		endpoint = "https://crt.sh/"
+	}
+	return &crtsh{endpoint: endpoint}
+}
+
+// Logged searches crt.sh by the certificate's SHA-256 fingerprint. crt.sh
+// returns a JSON array with one element per matching log entry.
+func (c *crtsh) Logged(ctx context.Context, cert *x509.Certificate,
+) (bool, error) {
+	sum := sha256.Sum256(cert.Raw)
+	q := url.Values{
+		"sha256": {strings.ToUpper(hex.EncodeToString(sum[:]))},
+		"output": {"json"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	body, err := httpDo(req)
+	if err != nil {
+		return false, err
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return false, fmt.Errorf("unexpected response from %s: %v",
+			c.endpoint, err)
+	}
+	return len(entries) > 0, nil
+}
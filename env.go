@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var ExportEnvCommand = &cli.Command{
+	Name:      "export-env",
+	Usage:     "print a shell statement holding a keystore in a variable",
+	ArgsUsage: "NAME keystore.jks",
+	Description: "Prints \"export NAME=...\" with the keystore " +
+		"base64-encoded, for deployments that pass configuration " +
+		"through the environment rather than mounted files. Go " +
+		"programs can read it back with jks.ParseEnv.",
+	Action: ExportEnv,
+}
+
+func init() {
+	ExportEnvCommand.Flags = addJksOptsFlags(ExportEnvCommand.Flags)
+}
+
+func ExportEnv(c *cli.Context) error {
+	if c.NArg() != 2 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need variable name and keystore file")
+	}
+	name, filename := c.Args().Get(0), c.Args().Get(1)
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	raw, err := readLocation(filename)
+	if err != nil {
+		return err
+	}
+	// make sure we are exporting a usable keystore
+	if _, err = jks.Parse(raw, opts); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	stmt, err := jks.ExportEnv(name, raw)
+	if err == jks.ErrEnvTooLarge {
+		return fmt.Errorf("%s: %v (%d bytes encoded, limit %d)",
+			filename, err, (len(raw)+2)/3*4+len(name)+1,
+			jks.MaxEnvSize)
+	} else if err != nil {
+		return err
+	}
+	if size := len(strings.TrimPrefix(stmt, "export ")); size >
+		jks.MaxWindowsEnvSize {
+		fmt.Fprintf(os.Stderr, "warning: %d bytes exceeds the "+
+			"Windows environment limit of %d\n", size,
+			jks.MaxWindowsEnvSize)
+	}
+	fmt.Println(stmt)
+	return nil
+}
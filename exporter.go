@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var ExporterCommand = &cli.Command{
+	Name:  "exporter",
+	Usage: "serve Prometheus metrics about keystore files",
+	Description: "Serves /metrics over HTTP. Each scrape re-reads the " +
+		"keystores given by --keystore and reports whether they " +
+		"could be parsed, how many entries of each type they hold " +
+		"and when each entry's certificates expire.",
+	Action: Exporter,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Value: ":9464",
+			Usage: "address on which to serve HTTP",
+		},
+		&cli.StringSliceFlag{
+			Name:     "keystore",
+			Required: true,
+			Usage:    "keystore to monitor; may be repeated",
+		},
+	},
+}
+
+func init() {
+	ExporterCommand.Flags = addJksOptsFlags(ExporterCommand.Flags)
+}
+
+func Exporter(c *cli.Context) error {
+	if c.NArg() != 0 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("unexpected arguments")
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+
+	e := &exporter{
+		opts:        opts,
+		keystores:   c.StringSlice("keystore"),
+		lastSuccess: make(map[string]time.Time),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.serveMetrics)
+	srv := &http.Server{
+		Addr:              c.String("listen"),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(
+			context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving metrics on %s/metrics", srv.Addr)
+	if err = srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// exporter collects metrics about a set of keystore files.
+type exporter struct {
+	opts      *jks.Options
+	keystores []string
+
+	// lastSuccess records when each keystore was last parsed
+	// successfully.
+	mu          sync.Mutex
+	lastSuccess map[string]time.Time
+}
+
+// serveMetrics handles a scrape of /metrics.
+func (e *exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(e.collect())
+}
+
+// collect re-reads each keystore and returns the metrics in the Prometheus
+// text exposition format.
+func (e *exporter) collect() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	type result struct {
+		ks  *jks.Keystore
+		err error
+	}
+	results := make([]result, len(e.keystores))
+	now := time.Now()
+	for i, fn := range e.keystores {
+		raw, err := readLocation(fn)
+		if err == nil {
+			results[i].ks, err = jks.Parse(raw, e.opts)
+		}
+		results[i].err = err
+		if err != nil {
+			log.Printf("%s: %v", fn, err)
+		} else {
+			e.lastSuccess[fn] = now
+		}
+	}
+
+	var buf bytes.Buffer
+
+	promGauge(&buf, "minijks_keystore_parse_success",
+		"Whether the keystore was read and parsed on this scrape.")
+	for i, fn := range e.keystores {
+		success := 0
+		if results[i].err == nil {
+			success = 1
+		}
+		fmt.Fprintf(&buf, "minijks_keystore_parse_success"+
+			"{keystore=\"%s\"} %d\n", promEscape(fn), success)
+	}
+
+	promGauge(&buf, "minijks_keystore_last_success_timestamp_seconds",
+		"When the keystore was last parsed successfully.")
+	for _, fn := range e.keystores {
+		if t, ok := e.lastSuccess[fn]; ok {
+			fmt.Fprintf(&buf, "minijks_keystore_last_success_"+
+				"timestamp_seconds{keystore=\"%s\"} %d\n",
+				promEscape(fn), t.Unix())
+		}
+	}
+
+	promGauge(&buf, "minijks_keystore_entries",
+		"Number of entries in the keystore, by type.")
+	for i, fn := range e.keystores {
+		ks := results[i].ks
+		if ks == nil {
+			continue
+		}
+		fmt.Fprintf(&buf, "minijks_keystore_entries{keystore=\"%s\","+
+			"type=\"%s\"} %d\n", promEscape(fn),
+			jks.ManifestTrustedCert, len(ks.Certs))
+		fmt.Fprintf(&buf, "minijks_keystore_entries{keystore=\"%s\","+
+			"type=\"%s\"} %d\n", promEscape(fn),
+			jks.ManifestPrivateKey, len(ks.Keypairs))
+	}
+
+	promGauge(&buf, "minijks_entry_not_after_timestamp_seconds",
+		"When the first of the entry's certificates expires.")
+	for i, fn := range e.keystores {
+		ks := results[i].ks
+		if ks == nil {
+			continue
+		}
+		expiry := entryExpiry(ks)
+		for _, alias := range sortedAliases(expiry) {
+			fmt.Fprintf(&buf, "minijks_entry_not_after_timestamp_"+
+				"seconds{keystore=\"%s\",alias=\"%s\"} %d\n",
+				promEscape(fn), promEscape(alias),
+				expiry[alias].Unix())
+		}
+	}
+
+	return buf.Bytes()
+}
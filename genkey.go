@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var GenKeyCommand = &cli.Command{
+	Name:      "genkey",
+	Aliases:   []string{"genkeypair"},
+	Usage:     "generate a keypair with a self-signed certificate",
+	ArgsUsage: "out.jks alias",
+	Description: "Generates a new private key and self-signed " +
+		"certificate, and writes it to a new keystore under the " +
+		"given alias. With --in, the keypair is added to a copy of " +
+		"an existing keystore instead, and --issuer may name a CA " +
+		"keypair in that keystore to sign the certificate (e.g. " +
+		"to create an intermediate CA with --ca). For parity with " +
+		"keytool -genkeypair, the output file and alias may " +
+		"instead be given with --keystore and --alias, and " +
+		"--keyalg, --keysize, --dname and --validity are accepted.",
+	Action: GenKey,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "in",
+			Usage: "existing keystore to add the keypair to",
+		},
+		&cli.StringFlag{
+			Name: "keystore",
+			Usage: "keystore to write, instead of the first " +
+				"argument",
+		},
+		&cli.StringFlag{
+			Name: "alias",
+			Usage: "alias of the keypair, instead of the second " +
+				"argument",
+		},
+		&cli.StringFlag{
+			Name:    "key-type",
+			Aliases: []string{"keyalg"},
+			Value:   jks.KeyRSA.String(),
+			Usage:   "type of key: rsa, ec or ed25519",
+		},
+		&cli.IntFlag{
+			Name: "keysize",
+			Usage: "key size: sets --rsa-bits for RSA keys, or " +
+				"--curve for EC keys (256, 384 or 521)",
+		},
+		&cli.IntFlag{
+			Name:  "rsa-bits",
+			Value: jks.DefaultRSABits,
+			Usage: "RSA key size: 2048, 3072 or 4096",
+		},
+		&cli.StringFlag{
+			Name:  "curve",
+			Value: jks.DefaultCurve,
+			Usage: "curve for EC keys: P-256, P-384 or P-521",
+		},
+		&cli.IntFlag{
+			Name:    "days",
+			Aliases: []string{"validity"},
+			Value:   int(jks.DefaultValidity / (24 * time.Hour)),
+			Usage:   "number of days the certificate is valid for",
+		},
+		&cli.TimestampFlag{
+			Name:   "not-before",
+			Layout: time.RFC3339,
+			Usage: "start of the certificate's validity period " +
+				"(default: now)",
+		},
+		&cli.StringFlag{
+			Name:    "subject",
+			Aliases: []string{"dname"},
+			Usage: "certificate subject, e.g. " +
+				"\"CN=host,O=Org,C=GB\" (default: CN=alias)",
+		},
+		&cli.StringSliceFlag{
+			Name: "san",
+			Usage: "subject alternative name: a DNS name, IP " +
+				"address or email address; may be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name: "eku",
+			Usage: "extended key usage, e.g. serverAuth or " +
+				"clientAuth (default: both, or none with " +
+				"--ca); may be repeated",
+		},
+		&cli.BoolFlag{
+			Name: "ca",
+			Usage: "generate a CA certificate, for use with " +
+				"sign-csr",
+		},
+		&cli.StringFlag{
+			Name: "issuer",
+			Usage: "sign the certificate with this CA keypair " +
+				"from the --in keystore, rather than " +
+				"self-signing it",
+		},
+		&cli.IntFlag{
+			Name:  "max-path-len",
+			Value: -1,
+			Usage: "path length constraint for --ca (default: " +
+				"none)",
+		},
+		&cli.StringSliceFlag{
+			Name: "permitted-dns",
+			Usage: "name constraint for --ca: permitted DNS " +
+				"domain; may be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name: "excluded-dns",
+			Usage: "name constraint for --ca: excluded DNS " +
+				"domain; may be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name: "permitted-ip",
+			Usage: "name constraint for --ca: permitted IP " +
+				"range (CIDR); may be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name: "excluded-ip",
+			Usage: "name constraint for --ca: excluded IP range " +
+				"(CIDR); may be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "serial",
+			Value: jks.SerialRandom.String(),
+			Usage: "serial number strategy: random, timestamp or " +
+				"sequential",
+		},
+		compatFlag,
+	},
+}
+
+func init() {
+	GenKeyCommand.Flags = addJksOptsFlags(GenKeyCommand.Flags)
+	GenKeyCommand.Flags = addKeyPolicyFlags(GenKeyCommand.Flags)
+}
+
+func GenKey(c *cli.Context) error {
+	outFn, alias := c.String("keystore"), c.String("alias")
+	args := c.Args().Slice()
+	if outFn == "" && len(args) != 0 {
+		outFn, args = args[0], args[1:]
+	}
+	if alias == "" && len(args) != 0 {
+		alias, args = args[0], args[1:]
+	}
+	if outFn == "" || alias == "" || len(args) != 0 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need output file name and alias")
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if opts.SkipVerifyDigest {
+		return errors.New("need --password to write output")
+	}
+	opts.Compatibility, err = jks.ParseCompatibility(c.String("compat"))
+	if err != nil {
+		return err
+	}
+	keyPolicyFlags(c, opts)
+
+	params, err := keyGenFlags(c)
+	if err != nil {
+		return err
+	}
+
+	ks := new(jks.Keystore)
+	if inFn := c.String("in"); inFn != "" {
+		raw, err := readLocation(inFn)
+		if err != nil {
+			return err
+		}
+		if ks, err = jks.Parse(raw, opts); err != nil {
+			return fmt.Errorf("%s: %v", inFn, err)
+		}
+		// we can only write out keys that we managed to decrypt
+		for _, kp := range ks.Keypairs {
+			if kp.PrivKeyErr != nil {
+				return fmt.Errorf("%s: keypair %q: %v",
+					inFn, kp.Alias, kp.PrivKeyErr)
+			}
+		}
+	}
+
+	if issuer := c.String("issuer"); issuer != "" {
+		if !c.IsSet("in") {
+			return errors.New("--issuer needs --in")
+		}
+		_, err = ks.IssueKeypair(issuer, alias, params)
+	} else {
+		_, err = ks.GenerateKeypair(alias, params)
+	}
+	if err != nil {
+		return err
+	}
+	raw, err := ks.Pack(opts)
+	if err != nil {
+		return err
+	}
+	return writeLocation(outFn, raw, 0600)
+}
+
+// keyGenFlags returns the key generation parameters given by GenKey's flags.
+func keyGenFlags(c *cli.Context) (*jks.KeyGenParams, error) {
+	var (
+		params = &jks.KeyGenParams{
+			RSABits:  c.Int("rsa-bits"),
+			Curve:    c.String("curve"),
+			Validity: time.Duration(c.Int("days")) * 24 * time.Hour,
+			IsCA:     c.Bool("ca"),
+		}
+		err error
+	)
+	params.KeyType, err = jks.ParseKeyType(c.String("key-type"))
+	if err != nil {
+		return nil, err
+	}
+	params.Serial, err = jks.ParseSerialStrategy(c.String("serial"))
+	if err != nil {
+		return nil, err
+	}
+	if c.IsSet("keysize") {
+		switch {
+		case c.IsSet("rsa-bits") || c.IsSet("curve"):
+			return nil, errors.New("--keysize cannot be used " +
+				"with --rsa-bits or --curve")
+		case params.KeyType == jks.KeyRSA:
+			params.RSABits = c.Int("keysize")
+		case params.KeyType == jks.KeyEC:
+			params.Curve = fmt.Sprintf("P-%d", c.Int("keysize"))
+		default:
+			return nil, fmt.Errorf("--keysize does not apply to "+
+				"%s keys", params.KeyType)
+		}
+	}
+	if params.Validity <= 0 {
+		return nil, errors.New("--days must be positive")
+	}
+	if t := c.Timestamp("not-before"); t != nil {
+		params.NotBefore = *t
+	}
+	if params.Subject, err = parseSubject(c.String("subject")); err != nil {
+		return nil, fmt.Errorf("--subject: %v", err)
+	}
+
+	for _, san := range c.StringSlice("san") {
+		switch ip := net.ParseIP(san); {
+		case ip != nil:
+			params.IPAddresses = append(params.IPAddresses, ip)
+		case strings.Contains(san, "@"):
+			params.EmailAddresses = append(params.EmailAddresses,
+				san)
+		default:
+			params.DNSNames = append(params.DNSNames, san)
+		}
+	}
+
+	for _, name := range c.StringSlice("eku") {
+		eku, err := jks.ParseExtKeyUsage(name)
+		if err != nil {
+			return nil, err
+		}
+		params.ExtKeyUsage = append(params.ExtKeyUsage, eku)
+	}
+
+	if err = caConstraintFlags(c, params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// caConstraintFlags copies the path length and name constraint flags into
+// params. They are only accepted with --ca.
+func caConstraintFlags(c *cli.Context, params *jks.KeyGenParams) error {
+	for _, name := range []string{"max-path-len", "permitted-dns",
+		"excluded-dns", "permitted-ip", "excluded-ip"} {
+		if c.IsSet(name) && !params.IsCA {
+			return fmt.Errorf("--%s needs --ca", name)
+		}
+	}
+
+	switch n := c.Int("max-path-len"); {
+	case n == 0:
+		params.MaxPathLenZero = true
+	case n > 0:
+		params.MaxPathLen = n
+	}
+	params.PermittedDNSDomains = c.StringSlice("permitted-dns")
+	params.ExcludedDNSDomains = c.StringSlice("excluded-dns")
+
+	var err error
+	params.PermittedIPRanges, err = parseCIDRs(c.StringSlice(
+		"permitted-ip"))
+	if err != nil {
+		return fmt.Errorf("--permitted-ip: %v", err)
+	}
+	params.ExcludedIPRanges, err = parseCIDRs(c.StringSlice(
+		"excluded-ip"))
+	if err != nil {
+		return fmt.Errorf("--excluded-ip: %v", err)
+	}
+	return nil
+}
+
+// parseCIDRs parses IP ranges written in CIDR notation.
+func parseCIDRs(in []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range in {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ipnet)
+	}
+	return out, nil
+}
+
+// parseSubject parses a distinguished name written as comma-separated
+// attributes, e.g. "CN=host,O=Org,C=GB". A comma within a value may be escaped
+// with a backslash. Only the attributes held by pkix.Name are supported.
+func parseSubject(s string) (pkix.Name, error) {
+	var (
+		name  pkix.Name
+		attrs []string
+		cur   strings.Builder
+	)
+	if strings.TrimSpace(s) == "" {
+		return name, nil
+	}
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case s[i] == ',':
+			attrs = append(attrs, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(s[i])
+		}
+	}
+	attrs = append(attrs, cur.String())
+
+	for _, attr := range attrs {
+		p := strings.SplitN(attr, "=", 2)
+		if len(p) != 2 {
+			return name, fmt.Errorf("invalid attribute %q", attr)
+		}
+		key, value := strings.TrimSpace(p[0]), strings.TrimSpace(p[1])
+		switch strings.ToUpper(key) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(
+				name.OrganizationalUnit, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "STREET":
+			name.StreetAddress = append(name.StreetAddress, value)
+		case "POSTALCODE":
+			name.PostalCode = append(name.PostalCode, value)
+		case "SERIALNUMBER":
+			name.SerialNumber = value
+		default:
+			return name, fmt.Errorf("unsupported attribute %q", key)
+		}
+	}
+	return name, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var GetCommand = &cli.Command{
+	Name:      "get",
+	Usage:     "print fields of one keystore entry using a template",
+	ArgsUsage: "keystore.jks",
+	Description: "Executes a Go text/template against the entry with the " +
+		"given alias, so that scripts can extract a single value " +
+		"without parsing the full inspect output. The template sees " +
+		"the fields of the entry's manifest (Alias, Type, Timestamp, " +
+		"KeyError, Certificates) and of its first certificate " +
+		"(Subject, Issuer, Serial, NotBefore, NotAfter, " +
+		"KeyAlgorithm, KeySize, SignatureAlgorithm, DNSNames, " +
+		"IPAddresses, FingerprintSHA1, FingerprintSHA256, " +
+		"PinSHA256), as named in inspect's JSON output but " +
+		"capitalised. The function join (e.g. {{join .DNSNames " +
+		"\",\"}}) is also available.",
+	Action: Get,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "alias",
+			Required: true,
+			Usage:    "alias of the entry",
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Value: "{{.FingerprintSHA256}}",
+			Usage: "Go text/template to execute",
+		},
+	},
+}
+
+func init() {
+	GetCommand.Flags = addJksOptsFlags(GetCommand.Flags)
+}
+
+// getFields is the data passed to get's template: the entry's manifest, with
+// the fields of its first certificate promoted alongside.
+type getFields struct {
+	*jks.ManifestEntry
+	*jks.ManifestCert
+}
+
+func Get(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need name of keystore file")
+	}
+
+	tmpl, err := template.New("get").Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).Option("missingkey=error").Parse(c.String("template"))
+	if err != nil {
+		return fmt.Errorf("invalid --template: %v", err)
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	filename, alias := c.Args().First(), c.String("alias")
+	raw, err := readLocation(filename)
+	if err != nil {
+		return err
+	}
+	ks, err := jks.Parse(raw, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	// Lookup matches aliases as Java does; secret keys, which it does not
+	// cover, are matched ignoring case
+	want, norm := "", jks.NormalizeAlias(alias)
+	switch cert, kp := ks.Lookup(alias); {
+	case cert != nil:
+		want = cert.Alias
+	case kp != nil:
+		want = kp.Alias
+	}
+	for _, entry := range ks.Manifest().Entries {
+		switch {
+		case want != "" && entry.Alias != want,
+			want == "" && jks.NormalizeAlias(entry.Alias) != norm:
+			continue
+		}
+		fields := getFields{
+			ManifestEntry: entry,
+			ManifestCert:  new(jks.ManifestCert),
+		}
+		if len(entry.Certificates) != 0 {
+			fields.ManifestCert = entry.Certificates[0]
+		}
+		if err := tmpl.Execute(os.Stdout, fields); err != nil {
+			return err
+		}
+		fmt.Println()
+		return nil
+	}
+	return fmt.Errorf("%s: no entry with alias %q", filename, alias)
+}
@@ -1,10 +1,20 @@
 module github.com/lwithers/minijks
 
-go 1.17
+go 1.23.0
 
-require github.com/urfave/cli/v2 v2.3.0
+require (
+	filippo.io/age v1.2.1
+	github.com/fsnotify/fsnotify v1.8.0
+	github.com/urfave/cli/v2 v2.3.0
+	github.com/zalando/go-keyring v0.2.8
+	golang.org/x/crypto v0.41.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.1 // indirect
+	github.com/danieljoos/wincred v1.2.3 // indirect
+	github.com/godbus/dbus/v5 v5.2.2 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 )
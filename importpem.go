@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var ImportPEMDirCommand = &cli.Command{
+	Name:    "import-pem-dir",
+	Aliases: []string{"importpem"},
+	Usage:   "build or extend a truststore from a directory of PEM files",
+	Description: "Walks --dir and its subdirectories, adding each " +
+		"certificate found in a .pem, .crt or .cer file to --store " +
+		"as a trusted certificate entry. Each is given an alias " +
+		"derived from its file name (with -0, -1 and so on appended " +
+		"for files holding several certificates) or, with " +
+		"--alias-from cn, from its subject's common name. The " +
+		"keystore is created, as JKS, if it does not exist; " +
+		"otherwise it is read in any format and written back in " +
+		"the same one.",
+	Action: ImportPEMDir,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "store",
+			Required: true,
+			Usage:    "keystore to create or extend",
+		},
+		&cli.StringFlag{
+			Name:     "dir",
+			Required: true,
+			Usage:    "directory of PEM certificates to import",
+		},
+		&cli.StringFlag{
+			Name:  "alias-from",
+			Value: "filename",
+			Usage: "derive aliases from the file name (filename) " +
+				"or the certificate's common name (cn)",
+		},
+		collisionFlag,
+		compatFlag,
+	},
+}
+
+func init() {
+	ImportPEMDirCommand.Flags = addJksOptsFlags(ImportPEMDirCommand.Flags)
+}
+
+// pemExtensions are the file name extensions of the files that import-pem-dir
+// reads.
+var pemExtensions = []string{".pem", ".crt", ".cer"}
+
+func ImportPEMDir(c *cli.Context) error {
+	aliasFromCN := false
+	switch c.String("alias-from") {
+	case "filename":
+	case "cn":
+		aliasFromCN = true
+	default:
+		return fmt.Errorf("--alias-from: expected filename or cn but "+
+			"got %q", c.String("alias-from"))
+	}
+
+	policy, err := jks.ParseCollisionPolicy(c.String("collision"))
+	if err != nil {
+		return err
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if opts.SkipVerifyDigest {
+		return errors.New("need --password to write output")
+	}
+	opts.Compatibility, err = jks.ParseCompatibility(c.String("compat"))
+	if err != nil {
+		return err
+	}
+
+	store := c.String("store")
+	ks, format := new(jks.Keystore), jks.FormatJKS
+	raw, err := readLocation(store)
+	switch {
+	case err == nil:
+		if ks, format, err = jks.ParseAny(raw, opts); err != nil {
+			return fmt.Errorf("%s: %v", store, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	n, err := importPEMDir(ks, c.String("dir"), aliasFromCN, policy)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d certificates read from %s\n", n, c.String("dir"))
+
+	if raw, err = keytoolPack(ks, format, opts); err != nil {
+		return err
+	}
+	return replaceLocation(store, raw)
+}
+
+// importPEMDir adds the certificates in the PEM files under dir to ks, in
+// lexical order of their paths, returning how many were read. Hidden files
+// and directories are skipped.
+func importPEMDir(ks *jks.Keystore, dir string, aliasFromCN bool,
+	policy jks.CollisionPolicy,
+) (int, error) {
+	var n int
+	err := filepath.WalkDir(dir, func(fname string, d fs.DirEntry,
+		err error,
+	) error {
+		switch {
+		case err != nil:
+			return err
+		case strings.HasPrefix(d.Name(), ".") && fname != dir:
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case !d.Type().IsRegular():
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(fname))
+		if !slices.Contains(pemExtensions, ext) {
+			return nil
+		}
+
+		raw, err := os.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+		stem := strings.TrimSuffix(d.Name(), filepath.Ext(fname))
+		certs, err := jks.CertsFromPEM(strings.ToLower(stem), raw)
+		if jks.ErrorCode(err) == jks.CodeMissingData {
+			fmt.Fprintf(os.Stderr, "warning: skipping %q: %v\n",
+				fname, err)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", fname, err)
+		}
+		for _, cert := range certs {
+			if cn := cert.Cert.Subject.CommonName; aliasFromCN &&
+				cn != "" {
+				cert.Alias = strings.ToLower(cn)
+			}
+			if err = ks.AddCert(cert, policy); err != nil {
+				return fmt.Errorf("%s: %v", fname, err)
+			}
+			n++
+		}
+		return nil
+	})
+	if err == nil && n == 0 {
+		err = fmt.Errorf("%s: no PEM certificates found", dir)
+	}
+	return n, err
+}
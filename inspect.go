@@ -1,11 +1,13 @@
 package main
 
 import (
+	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 
@@ -16,13 +18,31 @@ import (
 func addJksOptsFlags(in []cli.Flag) []cli.Flag {
 	return append(in,
 		&cli.StringFlag{
-			Name:  "password",
-			Usage: "keystore password",
+			Name:    "password",
+			Aliases: []string{"storepass"},
+			Usage:   "keystore password",
 		},
 		&cli.StringSliceFlag{
 			Name:  "key-password",
 			Usage: "password for a given key, as 'alias:password'",
 		},
+		&cli.StringFlag{
+			Name: "storepass-keyring",
+			Usage: "read keystore password from the OS keyring, " +
+				"as 'service/account'",
+		},
+		&cli.StringSliceFlag{
+			Name: "keypass-keyring",
+			Usage: "read password for a given key from the OS " +
+				"keyring, as 'alias:service/account'",
+		},
+		&cli.StringFlag{
+			Name:  "password-encoding",
+			Value: jks.PasswordUTF16BE.String(),
+			Usage: "how JKS passwords are encoded: utf16be (as " +
+				"Java does) or widened-bytes (as some " +
+				"non-Java tools do)",
+		},
 	)
 }
 
@@ -30,9 +50,28 @@ func jksOptsFlags(c *cli.Context) (*jks.Options, error) {
 	opts := &jks.Options{
 		KeyPasswords: make(map[string]string),
 	}
-	if c.IsSet("password") {
+	var err error
+	opts.PasswordEncoding, err = jks.ParsePasswordEncoding(
+		c.String("password-encoding"))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.IsSet("password") && c.IsSet("storepass-keyring"):
+		return nil, errors.New("cannot use both --password and " +
+			"--storepass-keyring")
+
+	case c.IsSet("password"):
 		opts.Password = c.String("password")
-	} else {
+
+	case c.IsSet("storepass-keyring"):
+		opts.Password, err = keyringPassword(
+			c.String("storepass-keyring"))
+		if err != nil {
+			return nil, err
+		}
+
+	default:
 		opts.SkipVerifyDigest = true
 	}
 	for _, keypass := range c.StringSlice("key-password") {
@@ -42,6 +81,18 @@ func jksOptsFlags(c *cli.Context) (*jks.Options, error) {
 		}
 		opts.KeyPasswords[p[0]] = p[1]
 	}
+	for _, keypass := range c.StringSlice("keypass-keyring") {
+		p := strings.SplitN(keypass, ":", 2)
+		if len(p) != 2 {
+			return nil, errors.New("invalid --keypass-keyring " +
+				"argument")
+		}
+		passwd, err := keyringPassword(p[1])
+		if err != nil {
+			return nil, err
+		}
+		opts.KeyPasswords[p[0]] = passwd
+	}
 	return opts, nil
 }
 
@@ -50,6 +101,20 @@ var InspectCommand = &cli.Command{
 	Usage:     "inspect the contents of a keystore file",
 	ArgsUsage: "keystore.jks",
 	Action:    Inspect,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "output format: text, json, yaml, csv or cyclonedx",
+		},
+		certsOnMismatchFlag,
+	},
+}
+
+var certsOnMismatchFlag = &cli.BoolFlag{
+	Name: "certs-on-digest-mismatch",
+	Usage: "if the integrity digest does not match, still process " +
+		"the trusted certificate entries (but not keypairs)",
 }
 
 func init() {
@@ -73,19 +138,68 @@ func Inspect(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	return inspect(opts, c.Args().Get(0))
+	opts.CertsOnDigestMismatch = c.Bool("certs-on-digest-mismatch")
+
+	switch format := c.String("format"); format {
+	case "text":
+		return inspect(opts, c.Args().Get(0))
+	case "json", "yaml", "csv", "cyclonedx":
+		return inspectManifest(opts, c.Args().Get(0), format)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// inspectManifest writes a machine-readable manifest of the keystore.
+func inspectManifest(opts *jks.Options, filename, format string) error {
+	raw, err := readLocation(filename)
+	if err != nil {
+		return err
+	}
+	ks, _, err := jks.ParseAny(raw, opts)
+	// any error will be returned below, after printing anything from ks
+
+	if ks != nil {
+		m := ks.Manifest()
+		var werr error
+		switch format {
+		case "json":
+			werr = m.WriteJSON(os.Stdout)
+		case "yaml":
+			werr = m.WriteYAML(os.Stdout)
+		case "csv":
+			werr = m.WriteCSV(os.Stdout)
+		case "cyclonedx":
+			werr = m.WriteCycloneDX(os.Stdout)
+		}
+		if werr != nil {
+			return werr
+		}
+	}
+
+	return err // error from jks.ParseAny
 }
 
 func inspect(opts *jks.Options, filename string) error {
 	fmt.Printf("======== %s ========\n", filename)
 
-	raw, err := ioutil.ReadFile(filename)
+	raw, err := readLocation(filename)
 	if err != nil {
 		return err
 	}
-	ks, err := jks.Parse(raw, opts)
+	ks, format, err := jks.ParseAny(raw, opts)
 	// any error will be returned below, after printing anything from ks
 
+	if format != jks.FormatUnknown {
+		fmt.Printf("Format:\t\t%s\n", format)
+		if ks != nil {
+			fmt.Printf("Integrity:\t%s\n", ks.Integrity)
+		}
+		fmt.Println()
+	}
+	if errors.Is(err, jks.ErrDigestMismatch) {
+		printDigestMismatch(opts, err)
+	}
 	if ks != nil {
 		for i, cert := range ks.Certs {
 			fmt.Printf("---- certificate #%d ----\n", i+1)
@@ -98,9 +212,15 @@ func inspect(opts *jks.Options, filename string) error {
 			inspectKeypair(kp)
 			fmt.Println("")
 		}
+
+		for i, sk := range ks.SecretKeys {
+			fmt.Printf("---- secret key #%d ----\n", i+1)
+			inspectSecretKey(sk)
+			fmt.Println("")
+		}
 	}
 
-	return err // error from jks.Parse
+	return err // error from jks.ParseAny
 }
 
 func inspectCert(cert *jks.Cert) {
@@ -151,6 +271,13 @@ func inspectPublicKey(pfx string, pub interface{}) {
 		fmt.Printf("%s    Size:\t%d bits\n", pfx, pub.Params().BitSize)
 		fmt.Printf("%s    Curve:\t%s\n", pfx, pub.Params().Name)
 
+	case ed25519.PublicKey:
+		fmt.Printf("%s    Type:\tEd25519\n", pfx)
+
+	case *dsa.PublicKey:
+		fmt.Printf("%s    Type:\tDSA\n", pfx)
+		fmt.Printf("%s    Size:\t%d bits\n", pfx, pub.P.BitLen())
+
 	default:
 		fmt.Printf("%s    Unknown type:\t%T\n", pfx, pub)
 	}
@@ -162,6 +289,13 @@ func inspectKeypair(kp *jks.Keypair) {
 	if kp.PrivKeyErr != nil {
 		fmt.Println("Unable to parse private key (wrong password?):")
 		fmt.Printf("    Error:\t%v\n", kp.PrivKeyErr)
+		var aerr *jks.ASN1Error
+		if errors.As(kp.PrivKeyErr, &aerr) && len(aerr.Snippet) != 0 {
+			for _, line := range strings.Split(strings.TrimSuffix(
+				aerr.Hexdump(), "\n"), "\n") {
+				fmt.Printf("\t%s\n", line)
+			}
+		}
 		fmt.Printf("    Ciphertext:\t%d bytes\n", len(kp.EncryptedKey))
 		if len(kp.RawKey) == 0 {
 			fmt.Println("    Failed to decrypt ciphertext")
@@ -202,6 +336,20 @@ func inspectKeypair(kp *jks.Keypair) {
 	}
 }
 
+func inspectSecretKey(sk *jks.SecretKey) {
+	fmt.Printf("Alias:\t\t%q\n", sk.Alias)
+	fmt.Printf("Timestamp:\t%s\n", sk.Timestamp.Format(time.RFC3339Nano))
+	if sk.KeyErr != nil {
+		fmt.Println("Unable to unseal secret key (wrong password?):")
+		fmt.Printf("    Error:\t%v\n", sk.KeyErr)
+		fmt.Printf("    Sealed:\t%d bytes\n", len(sk.SealedKey))
+		return
+	}
+	fmt.Println("Secret key:")
+	fmt.Printf("    Algorithm:\t%s\n", sk.Algorithm)
+	fmt.Printf("    Size:\t%d bits\n", 8*len(sk.Key))
+}
+
 func inspectPrivateKey(priv interface{}) {
 	fmt.Println("Private key:")
 	switch priv := priv.(type) {
@@ -214,7 +362,36 @@ func inspectPrivateKey(priv interface{}) {
 		fmt.Printf("    Size:\t%d bits\n", priv.Params().BitSize)
 		fmt.Printf("    Curve:\t%s\n", priv.Params().Name)
 
+	case ed25519.PrivateKey:
+		fmt.Println("    Type:\tEd25519")
+
+	case *dsa.PrivateKey:
+		fmt.Println("    Type:\tDSA")
+		fmt.Printf("    Size:\t%d bits\n", priv.P.BitLen())
+
 	default:
 		fmt.Printf("    Unknown type:\t%T\n", priv)
 	}
 }
+
+// printDigestMismatch warns prominently that the entries which follow have not
+// been authenticated. err is the error from parsing the keystore.
+func printDigestMismatch(opts *jks.Options, err error) {
+	fmt.Fprintln(os.Stderr, "**** WARNING: INTEGRITY CHECK FAILED ****")
+	if errors.Is(err, jks.ErrBadStorePassword) {
+		fmt.Fprintln(os.Stderr, "The keystore digest does not match: "+
+			"the password is wrong, or the file has been tampered "+
+			"with.")
+	} else {
+		fmt.Fprintf(os.Stderr, "The keystore cannot be trusted: %v.\n",
+			err)
+	}
+	if opts.CertsOnDigestMismatch {
+		fmt.Fprintln(os.Stderr, "Only trusted certificate entries "+
+			"are used, and they must not be relied upon.")
+	} else {
+		fmt.Fprintln(os.Stderr, "The entries must not be relied "+
+			"upon.")
+	}
+	fmt.Fprintln(os.Stderr)
+}
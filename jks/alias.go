@@ -0,0 +1,162 @@
+package jks
+
+import "strings"
+
+// NormalizeAlias returns alias as Java's JKS, JCEKS and PKCS#12 keystores key
+// their entries: lowercased, as by String.toLowerCase(Locale.ENGLISH). To Java,
+// two aliases that normalize to the same string name the same entry, and
+// keytool lowercases the aliases it stores. (Go's case mapping differs from
+// Java's in a handful of context-dependent cases, such as a final capital
+// sigma, which makes no difference to comparisons.)
+func NormalizeAlias(alias string) string {
+	return strings.ToLower(alias)
+}
+
+// Lookup returns the trusted certificate or keypair entry with the given
+// alias, matched as Java does: an entry whose alias differs only in case is
+// found if there is no exact match. At most one of the results is non-nil;
+// both are nil if there is no such entry.
+func (ks *Keystore) Lookup(alias string) (*Cert, *Keypair) {
+	certIdx, kpIdx := ks.findAlias(alias)
+	switch {
+	case certIdx >= 0:
+		return ks.Certs[certIdx], nil
+	case kpIdx >= 0:
+		return nil, ks.Keypairs[kpIdx]
+	}
+	return nil, nil
+}
+
+// aliasIndex returns the index of the entry among n whose alias (as returned
+// by aliasAt) is alias, or failing that, the first whose alias normalizes to
+// the same string, or -1 if there is none.
+func aliasIndex(n int, aliasAt func(int) string, alias string) int {
+	idx, norm := -1, NormalizeAlias(alias)
+	for i := 0; i < n; i++ {
+		a := aliasAt(i)
+		switch {
+		case a == alias:
+			return i
+		case idx < 0 && NormalizeAlias(a) == norm:
+			idx = i
+		}
+	}
+	return idx
+}
+
+// packEntries returns the entries that Pack writes, in the order it writes
+// them (see packOrder), with entries whose aliases normalize to the same string
+// dealt with according to opts.DuplicateAliases, since Java would load only one
+// of them. With CollisionError, an error with CodeDuplicateAlias is returned.
+// ks is not modified: an entry to be renamed is copied first.
+func (ks *Keystore) packEntries(opts *Options) ([]Entry, error) {
+	entries := ks.packOrder(opts)
+	policy := opts.DuplicateAliases
+	taken := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		taken[NormalizeAlias(e.EntryAlias())] = true
+	}
+	isTaken := func(alias string) bool {
+		return taken[NormalizeAlias(alias)]
+	}
+
+	// seen maps each normalized alias to the index in out of the entry
+	// written under it
+	seen := make(map[string]int, len(entries))
+	out := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		alias := e.EntryAlias()
+		i, dup := seen[NormalizeAlias(alias)]
+		switch {
+		case !dup:
+
+		case policy == CollisionSkip:
+			continue
+
+		case policy == CollisionOverwrite:
+			out[i] = e
+			continue
+
+		case policy == CollisionSuffix:
+			e = renameEntry(e, suffixAlias(alias, isTaken))
+
+		case policy == CollisionFingerprint:
+			e = renameEntry(e, fingerprintAlias(alias,
+				entryDER(e), isTaken))
+
+		default:
+			prev := out[i].EntryAlias()
+			if prev == alias {
+				return nil, errorf(CodeDuplicateAlias,
+					"duplicate alias %q", alias)
+			}
+			return nil, errorf(CodeDuplicateAlias, "aliases %q "+
+				"and %q differ only in case", prev, alias)
+		}
+		alias = NormalizeAlias(e.EntryAlias())
+		taken[alias] = true
+		seen[alias] = len(out)
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// renameEntry returns a copy of e with the given alias.
+func renameEntry(e Entry, alias string) Entry {
+	switch e := e.(type) {
+	case *Cert:
+		c := *e
+		c.Alias = alias
+		return &c
+	case *Keypair:
+		k := *e
+		k.Alias = alias
+		return &k
+	case *SecretKey:
+		k := *e
+		k.Alias = alias
+		return &k
+	}
+	return e
+}
+
+// entryDER returns the certificate that CollisionFingerprint fingerprints an
+// entry by: a trusted certificate, or the first in a keypair's chain. It is
+// nil for an entry without one.
+func entryDER(e Entry) []byte {
+	switch e := e.(type) {
+	case *Cert:
+		return e.DER()
+	case *Keypair:
+		if len(e.CertChain) != 0 && e.CertChain[0] != nil {
+			return e.CertChain[0].DER()
+		}
+	}
+	return nil
+}
+
+// seenAlias records alias in seen, which maps normalized aliases to the alias
+// first seen, and returns an error with CodeDuplicateAlias if it clashes with
+// one seen before.
+func seenAlias(seen map[string]string, alias string) error {
+	norm := NormalizeAlias(alias)
+	prev, ok := seen[norm]
+	switch {
+	case !ok:
+		seen[norm] = alias
+		return nil
+	case prev == alias:
+		return errorf(CodeDuplicateAlias, "duplicate alias %q", alias)
+	}
+	return errorf(CodeDuplicateAlias, "aliases %q and %q differ only in "+
+		"case", prev, alias)
+}
+
+// packAlias returns alias as Pack writes it: normalized, unless
+// PreserveAliasCase is set.
+func (opts *Options) packAlias(alias string) string {
+	if opts.PreserveAliasCase {
+		return alias
+	}
+	return NormalizeAlias(alias)
+}
@@ -0,0 +1,154 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestLookup checks that Lookup prefers an exact match, but otherwise matches
+// aliases case-insensitively as Java does.
+func TestLookup(t *testing.T) {
+	ks := jkstest.New(t, "").CA("root").
+		ECKeypair("server", elliptic.P256()).Keystore()
+	ks.Keypairs = append(ks.Keypairs, &jks.Keypair{Alias: "Server"})
+
+	t.Run("exact", testLookup(ks, "Server", "Server", false))
+	t.Run("exact-lower", testLookup(ks, "server", "server", false))
+	t.Run("case", testLookup(ks, "SERVER", "server", false))
+	t.Run("cert", testLookup(ks, "Root", "root", true))
+	t.Run("missing", testLookup(ks, "client", "", false))
+}
+
+func testLookup(ks *jks.Keystore, alias, exp string, expCert bool,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		cert, kp := ks.Lookup(alias)
+		switch {
+		case exp == "":
+			if cert != nil || kp != nil {
+				t.Errorf("unexpected entry found")
+			}
+		case expCert:
+			if cert == nil || cert.Alias != exp {
+				t.Errorf("certificate %q not found", exp)
+			}
+		case kp == nil || kp.Alias != exp:
+			t.Errorf("keypair %q not found", exp)
+		}
+	}
+}
+
+// TestPackAliasCase checks that Pack lowercases aliases unless told not to,
+// and refuses aliases which differ only in case.
+func TestPackAliasCase(t *testing.T) {
+	b := jkstest.New(t, "password").ECKeypair("server", elliptic.P256())
+	ks := b.Keystore()
+	ks.Keypairs[0].Alias = "Server"
+
+	t.Run("normalize", testPackAliasCase(ks, false, "server"))
+	t.Run("preserve", testPackAliasCase(ks, true, "Server"))
+
+	dup := b.Keystore()
+	dup.Keypairs = append(dup.Keypairs, ks.Keypairs[0])
+	_, err := dup.Pack(b.Options())
+	if code := jks.ErrorCode(err); code != jks.CodeDuplicateAlias {
+		t.Errorf("Pack: error code %s ≠ expected %s (%v)", code,
+			jks.CodeDuplicateAlias, err)
+	}
+	_, err = dup.PackPKCS12(b.Options())
+	if code := jks.ErrorCode(err); code != jks.CodeDuplicateAlias {
+		t.Errorf("PackPKCS12: error code %s ≠ expected %s (%v)", code,
+			jks.CodeDuplicateAlias, err)
+	}
+}
+
+func testPackAliasCase(ks *jks.Keystore, preserve bool, exp string,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		raw, err := ks.Pack(&jks.Options{
+			Password:          "password",
+			PreserveAliasCase: preserve,
+		})
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		ks, err := jks.Parse(raw, &jks.Options{Password: "password"})
+		switch {
+		case err != nil:
+			t.Fatalf("Parse: %v", err)
+		case ks.Keypairs[0].Alias != exp:
+			t.Errorf("alias %q ≠ expected %q", ks.Keypairs[0].Alias,
+				exp)
+		}
+	}
+}
+
+// TestDuplicateAliases checks that Pack resolves aliases which are the same as
+// Java compares them according to Options.DuplicateAliases, without changing
+// the keystore.
+func TestDuplicateAliases(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").CA("Root").CA("other")
+	ks := b.Keystore()
+	sum := sha256.Sum256(ks.Certs[1].DER())
+	fp := "Root-" + hex.EncodeToString(sum[:4])
+
+	t.Run("error", testDuplicateAliases(ks, jks.CollisionError))
+	t.Run("skip", testDuplicateAliases(ks, jks.CollisionSkip,
+		"root=root", "other=other"))
+	t.Run("overwrite", testDuplicateAliases(ks, jks.CollisionOverwrite,
+		"Root=Root", "other=other"))
+	t.Run("suffix", testDuplicateAliases(ks, jks.CollisionSuffix,
+		"root=root", "Root.1=Root", "other=other"))
+	t.Run("fingerprint", testDuplicateAliases(ks, jks.CollisionFingerprint,
+		"root=root", fp+"=Root", "other=other"))
+	if ks.Certs[1].Alias != "Root" {
+		t.Errorf("keystore changed: alias %q", ks.Certs[1].Alias)
+	}
+}
+
+// testDuplicateAliases packs ks with the given policy and checks the entries
+// written, each given as "alias=CN", or that Pack fails if exp is empty. Where
+// Pack should succeed, Preflight is set to check that Validate allows for the
+// policy too.
+func testDuplicateAliases(ks *jks.Keystore, policy jks.CollisionPolicy,
+	exp ...string,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		opts := &jks.Options{
+			Password:          "password",
+			PreserveAliasCase: true,
+			DuplicateAliases:  policy,
+			Preflight:         exp != nil,
+		}
+		raw, err := ks.Pack(opts)
+		if exp == nil {
+			code := jks.ErrorCode(err)
+			if code != jks.CodeDuplicateAlias {
+				t.Errorf("error code %s ≠ expected %s (%v)",
+					code, jks.CodeDuplicateAlias, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		parsed, err := jks.Parse(raw, opts)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var got []string
+		for _, cert := range parsed.Certs {
+			got = append(got, cert.Alias+"="+
+				cert.Cert.Subject.CommonName)
+		}
+		if !slices.Equal(got, exp) {
+			t.Errorf("entries %q ≠ expected %q", got, exp)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package jks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveSuffix separates an archived entry's original alias from the time at
+// which it was archived. See ArchiveAlias.
+const ArchiveSuffix = ".archived-"
+
+// archiveTimeLayout formats the archive time in UTC. It is all digits, since
+// the JDK folds JKS aliases to lower case.
+const archiveTimeLayout = "20060102-150405"
+
+// ArchivedAlias returns the alias that ArchiveAlias gives an entry with the
+// given alias, archived at the given time.
+func ArchivedAlias(alias string, at time.Time) string {
+	return alias + ArchiveSuffix + at.UTC().Format(archiveTimeLayout)
+}
+
+// ParseArchivedAlias reports whether alias is that of an archived entry and,
+// if so, returns its original alias and the time at which it was archived.
+func ParseArchivedAlias(alias string) (original string, at time.Time,
+	ok bool,
+) {
+	i := strings.LastIndex(alias, ArchiveSuffix)
+	if i < 0 {
+		return "", time.Time{}, false
+	}
+	at, err := time.Parse(archiveTimeLayout, alias[i+len(ArchiveSuffix):])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return alias[:i], at, true
+}
+
+// ArchiveAlias is a soft delete: rather than removing the entry with the given
+// alias, it renames it to ArchivedAlias(alias, at), giving operators a window
+// in which to recover it after a rotation. Since a JKS file has nowhere else
+// to keep metadata, the new alias is itself the mark that the entry is
+// archived. Archived entries are ignored by HostnameCoverage, and may later be
+// removed with PurgeArchived. As with Merge, the entry is copied before being
+// renamed. The new alias is returned.
+//
+// A keypair's per-key password is looked up by alias, so the caller must move
+// any entry in Options.KeyPasswords to the new alias; ChangeSet.Archive does
+// this.
+func (ks *Keystore) ArchiveAlias(alias string, at time.Time) (string, error) {
+	if _, _, ok := ParseArchivedAlias(alias); ok {
+		return "", errorf(CodeInvalidArgument, "alias %q is already "+
+			"archived", alias)
+	}
+	archived := ArchivedAlias(alias, at)
+	if err := ks.RenameAlias(alias, archived); err != nil {
+		return "", err
+	}
+	return archived, nil
+}
+
+// PurgeArchived removes the entries which were archived before the given time,
+// and returns their aliases, sorted.
+func (ks *Keystore) PurgeArchived(before time.Time) []string {
+	var purged []string
+	for _, alias := range ks.aliases() {
+		if _, at, ok := ParseArchivedAlias(alias); ok &&
+			at.Before(before) {
+			ks.removeAlias(alias)
+			purged = append(purged, alias)
+		}
+	}
+	sort.Strings(purged)
+	return purged
+}
+
+// aliases returns the aliases of all entries, skipping any nil entries.
+func (ks *Keystore) aliases() []string {
+	var aliases []string
+	for _, cert := range ks.Certs {
+		if cert != nil {
+			aliases = append(aliases, cert.Alias)
+		}
+	}
+	for _, kp := range ks.Keypairs {
+		if kp != nil {
+			aliases = append(aliases, kp.Alias)
+		}
+	}
+	for _, sk := range ks.SecretKeys {
+		if sk != nil {
+			aliases = append(aliases, sk.Alias)
+		}
+	}
+	return aliases
+}
+
+// Archive queues the archiving of the entry with the given alias, as per
+// Keystore.ArchiveAlias. Any per-key password moves with it.
+func (cs *ChangeSet) Archive(alias string, at time.Time) {
+	cs.add(fmt.Sprintf("archive %q", alias),
+		func(ks *Keystore, opts *Options) error {
+			archived, err := ks.ArchiveAlias(alias, at)
+			if err != nil {
+				return err
+			}
+			if pw, ok := opts.KeyPasswords[alias]; ok {
+				delete(opts.KeyPasswords, alias)
+				opts.KeyPasswords[archived] = pw
+			}
+			return nil
+		})
+}
@@ -0,0 +1,86 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestArchive checks that archiving renames an entry (moving its key password),
+// hides it from HostnameCoverage, and that PurgeArchived removes it later.
+func TestArchive(t *testing.T) {
+	b := jkstest.New(t, "password").
+		CA("root").
+		ECKeypair("server", elliptic.P256()).
+		KeyPassword("server", "keypass")
+	ks, opts := b.Keystore(), b.Options()
+	orig := ks.Keypairs[0]
+
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	var cs jks.ChangeSet
+	cs.Archive("server", at)
+	if err := cs.Apply(ks, opts); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	exp := "server.archived-20260304-050607"
+	switch {
+	case orig.Alias != "server":
+		t.Error("Archive modified the original entry")
+	case ks.Keypairs[0].Alias != exp:
+		t.Errorf("alias %q ≠ expected %q", ks.Keypairs[0].Alias, exp)
+	case opts.KeyPasswords[exp] != "keypass":
+		t.Errorf("key password not moved: %v", opts.KeyPasswords)
+	}
+
+	alias, when, ok := jks.ParseArchivedAlias(exp)
+	if !ok || alias != "server" || !when.Equal(at) {
+		t.Errorf("ParseArchivedAlias: %q %v %v", alias, when, ok)
+	}
+	if _, _, ok := jks.ParseArchivedAlias("server.archived-x"); ok {
+		t.Error("ParseArchivedAlias accepted a bad timestamp")
+	}
+
+	r := ks.HostnameCoverage([]string{"server"})
+	if len(r.Entries) != 0 || !r.Hosts[0].Gap() {
+		t.Errorf("archived entry used for coverage: %+v", r.Entries)
+	}
+
+	if _, err := ks.ArchiveAlias(exp, at); err == nil {
+		t.Error("expected error archiving an archived entry")
+	}
+	if _, err := ks.ArchiveAlias("missing", at); err == nil {
+		t.Error("expected error archiving a missing entry")
+	}
+
+	if purged := ks.PurgeArchived(at); len(purged) != 0 {
+		t.Errorf("purged %v too early", purged)
+	}
+	purged := ks.PurgeArchived(at.Add(time.Second))
+	if len(purged) != 1 || purged[0] != exp || len(ks.Keypairs) != 0 ||
+		len(ks.Certs) != 1 {
+		t.Errorf("unexpected purge %v", purged)
+	}
+}
+
+// TestArchiveSecretKey checks that secret keys can be archived and purged.
+func TestArchiveSecretKey(t *testing.T) {
+	ks := &jks.Keystore{SecretKeys: []*jks.SecretKey{{Alias: "aes"}}}
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	archived, err := ks.ArchiveAlias("aes", at)
+	if err != nil {
+		t.Fatalf("ArchiveAlias: %v", err)
+	}
+	if ks.SecretKeys[0].Alias != archived {
+		t.Errorf("alias %q ≠ expected %q", ks.SecretKeys[0].Alias,
+			archived)
+	}
+	purged := ks.PurgeArchived(at.Add(time.Second))
+	if len(purged) != 1 || purged[0] != archived ||
+		len(ks.SecretKeys) != 0 {
+		t.Errorf("unexpected purge %v", purged)
+	}
+}
@@ -0,0 +1,205 @@
+package jks
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// ASN1Error is returned when a DER-encoded structure (such as a PKCS#8
+// EncryptedPrivateKeyInfo) is malformed. It records which structure was being
+// parsed, which field could not be read, and the byte offset of the problem
+// from the start of the structure, along with enough context to triage interop
+// problems with other keystore writers from a log message.
+type ASN1Error struct {
+	// Structure is the name of the ASN.1 type being parsed, e.g.
+	// "EncryptedPrivateKeyInfo".
+	Structure string
+
+	// Expected is a sketch of the ASN.1 definition of Structure.
+	Expected string
+
+	// Field describes what we were trying to read when we found the
+	// problem, e.g. "algorithm OID".
+	Field string
+
+	// Offset is the position (in bytes) from the start of the encoded
+	// structure at which the problem was found.
+	Offset int
+
+	// OID is the algorithm identifier found in the structure. It is nil
+	// if the problem was found before the algorithm could be read.
+	OID asn1.ObjectIdentifier
+
+	// Snippet holds up to 32 bytes of the encoded structure surrounding
+	// Offset. SnippetOffset is the position of Snippet[0] within the
+	// structure. Both are left empty for structures holding unencrypted
+	// key material, such as PrivateKeyInfo, so that keys do not leak into
+	// error messages or logs. Both are left empty for structures which hold
+	// unencrypted key material, such as PrivateKeyInfo, so that the key
+	// does not leak into error messages and logs.
+	Snippet       []byte
+	SnippetOffset int
+}
+
+func (e *ASN1Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "malformed %s: bad %s at offset %d",
+		e.Structure, e.Field, e.Offset)
+	if e.OID != nil {
+		fmt.Fprintf(&b, " (algorithm %v)", e.OID)
+	}
+	if e.Expected != "" {
+		fmt.Fprintf(&b, "; expected %s", e.Expected)
+	}
+	if len(e.Snippet) != 0 {
+		fmt.Fprintf(&b, "; data from offset %d: % X",
+			e.SnippetOffset, e.Snippet)
+	}
+	return b.String()
+}
+
+// ErrorCode returns CodeMalformed.
+func (e *ASN1Error) ErrorCode() string {
+	return CodeMalformed
+}
+
+// Hexdump returns a multi-line hex dump of Snippet, with each line labelled
+// by its offset within the structure.
+func (e *ASN1Error) Hexdump() string {
+	var b strings.Builder
+	for i := 0; i < len(e.Snippet); i += 16 {
+		end := i + 16
+		if end > len(e.Snippet) {
+			end = len(e.Snippet)
+		}
+		fmt.Fprintf(&b, "%08x  % x\n", e.SnippetOffset+i,
+			e.Snippet[i:end])
+	}
+	return b.String()
+}
+
+// derParser wraps a cryptobyte.String so that errors can report the offset at
+// which parsing failed. cryptobyte only ever reslices its input, so the offset
+// of any substring is the difference in capacity from the original input.
+type derParser struct {
+	full      []byte
+	structure string
+	expected  string
+	oid       asn1.ObjectIdentifier
+
+	// secret is set for structures holding unencrypted key material,
+	// whose bytes must not be copied into errors.
+	secret bool
+}
+
+func newDERParser(raw []byte, structure, expected string,
+) (*derParser, cryptobyte.String) {
+	p := &derParser{
+		full:      raw,
+		structure: structure,
+		expected:  expected,
+	}
+	return p, raw
+}
+
+// errorAt returns an *ASN1Error for a problem reading field at the start of s.
+func (p *derParser) errorAt(s cryptobyte.String, field string) error {
+	off := cap(p.full) - cap(s)
+	start, end := off-8, off+24
+	if start < 0 {
+		start = 0
+	}
+	if end > len(p.full) {
+		end = len(p.full)
+	}
+	if start > end {
+		start = end
+	}
+	err := &ASN1Error{
+		Structure: p.structure,
+		Expected:  p.expected,
+		Field:     field,
+		Offset:    off,
+		OID:       p.oid,
+	}
+	if !p.secret {
+		err.Snippet = p.full[start:end]
+		err.SnippetOffset = start
+	}
+	return err
+}
+
+// readAlgorithmIdentifier reads an AlgorithmIdentifier structure (RFC 5280
+// § 4.1.1.2) from s.
+func (p *derParser) readAlgorithmIdentifier(s *cryptobyte.String,
+) (pkix.AlgorithmIdentifier, error) {
+	var (
+		ai  pkix.AlgorithmIdentifier
+		seq cryptobyte.String
+	)
+	if !s.ReadASN1(&seq, casn1.SEQUENCE) {
+		return ai, p.errorAt(*s, "AlgorithmIdentifier sequence")
+	}
+	if !seq.ReadASN1ObjectIdentifier(&ai.Algorithm) {
+		return ai, p.errorAt(seq, "algorithm OID")
+	}
+	p.oid = ai.Algorithm
+	if seq.Empty() {
+		return ai, nil
+	}
+
+	var (
+		full, content cryptobyte.String
+		tag           casn1.Tag
+	)
+	start := seq
+	if !seq.ReadAnyASN1Element(&full, &tag) || !seq.Empty() {
+		return ai, p.errorAt(start, "algorithm parameters")
+	}
+	params := full
+	if !params.ReadAnyASN1(&content, &tag) {
+		return ai, p.errorAt(start, "algorithm parameters")
+	}
+	ai.Parameters = asn1.RawValue{
+		Class:      int(tag&0xC0) >> 6,
+		Tag:        int(tag & 0x1F),
+		IsCompound: tag&0x20 != 0,
+		Bytes:      content,
+		FullBytes:  full,
+	}
+	return ai, nil
+}
+
+// addAlgorithmIdentifier appends a DER-encoded AlgorithmIdentifier structure.
+func addAlgorithmIdentifier(b *cryptobyte.Builder,
+	ai pkix.AlgorithmIdentifier,
+) {
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(ai.Algorithm)
+		addRawValue(b, ai.Parameters)
+	})
+}
+
+// addRawValue appends a raw ASN.1 value. If FullBytes is set it is used
+// verbatim; otherwise the value is rebuilt from its tag and contents. A zero
+// RawValue is omitted entirely.
+func addRawValue(b *cryptobyte.Builder, rv asn1.RawValue) {
+	switch {
+	case len(rv.FullBytes) != 0:
+		b.AddBytes(rv.FullBytes)
+
+	case rv.Tag != 0 || rv.Class != 0 || len(rv.Bytes) != 0:
+		tag := casn1.Tag(rv.Class<<6 | rv.Tag)
+		if rv.IsCompound {
+			tag = tag.Constructed()
+		}
+		b.AddASN1(tag, func(b *cryptobyte.Builder) {
+			b.AddBytes(rv.Bytes)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package jks
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode"
+)
+
+// ParseBase64 decodes a base64-encoded keystore, as commonly found in
+// Kubernetes Secrets, Helm values and environment variables, and then parses
+// it as Parse does. Both the standard and the URL-safe alphabets are accepted,
+// with or without padding, and whitespace (including line breaks) is ignored.
+func ParseBase64(s string, opts *Options) (*Keystore, error) {
+	raw, err := DecodeBase64(s)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(raw, opts)
+}
+
+// DecodeBase64 decodes base64 data in the same lenient way as ParseBase64.
+func DecodeBase64(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, "=")
+
+	enc := base64.RawStdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.RawURLEncoding
+	}
+	raw, err := enc.DecodeString(s)
+	if err != nil {
+		return nil, errorf(CodeMalformed, "invalid base64: %v", err)
+	}
+	return raw, nil
+}
+
+// PackBase64 packs the keystore as Pack does, and returns it base64-encoded on
+// a single line using enc. If enc is nil then base64.StdEncoding is used.
+func (ks *Keystore) PackBase64(opts *Options, enc *base64.Encoding,
+) (string, error) {
+	raw, err := ks.Pack(opts)
+	if err != nil {
+		return "", err
+	}
+	if enc == nil {
+		enc = base64.StdEncoding
+	}
+	return enc.EncodeToString(raw), nil
+}
@@ -0,0 +1,61 @@
+package jks_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestBase64 checks that base64-wrapped keystores round trip in each of the
+// forms ParseBase64 accepts.
+func TestBase64(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").RSAKeypair("server", 2048)
+	raw := b.Bytes()
+
+	// wrap at 76 columns, as base64(1) and PEM do
+	wrap := func(s string) string {
+		var lines []string
+		for len(s) > 76 {
+			lines = append(lines, s[:76])
+			s = s[76:]
+		}
+		return strings.Join(append(lines, s), "\n") + "\n"
+	}
+
+	std := base64.StdEncoding.EncodeToString(raw)
+	url := base64.URLEncoding.EncodeToString(raw)
+	t.Run("standard", testBase64(b, std))
+	t.Run("URL-safe", testBase64(b, url))
+	t.Run("unpadded", testBase64(b, base64.RawURLEncoding.EncodeToString(
+		raw)))
+	t.Run("wrapped", testBase64(b, wrap(std)))
+	t.Run("indented", testBase64(b, "  "+strings.ReplaceAll(wrap(url),
+		"\n", "\n  ")))
+
+	packed, err := b.Keystore().PackBase64(b.Options(),
+		base64.URLEncoding)
+	if err != nil {
+		t.Fatalf("PackBase64: %v", err)
+	}
+	t.Run("PackBase64", testBase64(b, packed))
+
+	if _, err := jks.ParseBase64("not*base64", b.Options()); err == nil {
+		t.Error("expected error for invalid base64")
+	}
+}
+
+func testBase64(b *jkstest.Builder, s string) func(*testing.T) {
+	return func(t *testing.T) {
+		ks, err := jks.ParseBase64(s, b.Options())
+		if err != nil {
+			t.Fatalf("ParseBase64: %v", err)
+		}
+		if len(ks.Certs) != 1 || len(ks.Keypairs) != 1 ||
+			ks.Keypairs[0].PrivKeyErr != nil {
+			t.Errorf("unexpected keystore content")
+		}
+	}
+}
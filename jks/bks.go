@@ -0,0 +1,449 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"fmt"
+	"time"
+)
+
+// The BKS format is that of BouncyCastle's BcKeyStoreSpi, which Android used
+// for its keystores and truststores before PKCS#12, and which BouncyCastle
+// still writes. A file starts with a 32-bit version, 1 or 2, then a salt
+// (preceded by its 32-bit length) and a 32-bit iteration count. The entries
+// follow, each a type byte, an alias in modified UTF-8, a timestamp, and a
+// certificate chain (a 32-bit count of certificates, each a type string and
+// length-prefixed DER), then data that depends on the type. An entry type of
+// zero ends the entries, and a 20 byte HMAC-SHA1 of the entries ends the file.
+// The MAC key is derived from the password, salt and iteration count with the
+// PKCS#12 KDF; in version 1 files, through a bug in BouncyCastle, it is only
+// two bytes long.
+
+// BKS entry types.
+const (
+	bksEnd         = 0 // end of entries
+	bksCertificate = 1 // trusted certificate
+	bksKey         = 2 // unprotected key
+	bksSecret      = 3 // opaque secret, which BouncyCastle cannot return
+	bksSealed      = 4 // key encrypted with a password
+)
+
+// BKS key types, as recorded in a key entry.
+const (
+	bksKeyPrivate = 0
+	bksKeyPublic  = 1
+	bksKeySecret  = 2
+)
+
+// errBKSSecret is recorded for BKS secret entries, whose content BouncyCastle
+// stores but never returns.
+var errBKSSecret = newError(CodeUnsupported, "BKS secret entries hold "+
+	"opaque data which cannot be recovered")
+
+// bksMaxSaltSize is the largest salt we accept in a BKS file; BouncyCastle
+// writes 20 byte salts.
+const bksMaxSaltSize = 1024
+
+// ParseBKS parses a BouncyCastle BKS keystore, of version 1 or 2, onto the
+// same model as Parse: trusted certificate entries become Certs, private keys
+// with their certificate chains Keypairs, and secret keys SecretKeys. opts is
+// treated as for Parse: the file's HMAC is verified with opts.Password unless
+// SkipVerifyDigest is set, and a mismatch returns ErrDigestMismatch along with
+// a partial Keystore. Keys are decrypted with the password for their alias,
+// unless SkipKeyDecryption is set.
+//
+// BKS protects keys with its own scheme rather than PKCS#8, so a keypair's
+// EncryptedKey is not set: a key that is not decrypted has only PrivKeyErr,
+// and cannot be written out by Pack. Whether a sealed key is a private or a
+// secret key is only recorded inside the encryption, so one that is not
+// decrypted is taken to be a private key if it has a certificate chain. As
+// with Parse, errors decrypting or parsing an individual key or certificate
+// are stored within the returned Keystore; public key entries, and the opaque
+// secrets that BouncyCastle stores but cannot return, become SecretKeys with
+// KeyErr set.
+func ParseBKS(raw []byte, opts *Options) (*Keystore, error) {
+	opts, err := opts.parseOptions()
+	if err != nil {
+		return nil, err
+	}
+	defer opts.wipePasswords()
+
+	buf := &stream{r: bytes.NewReader(raw), size: int64(len(raw))}
+	version, _, err := readUint32(buf, "BKS version")
+	if err != nil {
+		return nil, err
+	}
+	if version != 1 && version != 2 {
+		return nil, errorf(CodeBadVersion, "found BKS version %d, "+
+			"but expected version 1 or 2", version)
+	}
+	salt, iterations, err := readBKSSalt(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &Keystore{ETag: ETag(raw)}
+	start := buf.off
+	for n := uint32(0); ; n++ {
+		pos := buf.off
+		etype, err := buf.read(1)
+		if err != nil {
+			return ks, eofError(err, "unexpected EOF at position "+
+				"%d while reading entry type", pos)
+		}
+		if etype[0] == bksEnd {
+			break
+		}
+		if max := opts.maxEntryCount(); n >= max {
+			return ks, tooLarge("number of entries", pos, n+1, max)
+		}
+		if err = ks.readBKSEntry(buf, etype[0], pos, opts); err != nil {
+			return ks, err
+		}
+	}
+	end := buf.off
+
+	stored, err := buf.read(sha1.Size)
+	if err != nil {
+		return ks, eofError(err, "malformed MAC at end of file")
+	}
+	if _, err = buf.read(1); err == nil {
+		return ks, newError(CodeMalformed, "malformed MAC at end of "+
+			"file")
+	} else if err != errShortRead {
+		return ks, err
+	}
+	if opts.SkipVerifyDigest {
+		return ks, nil
+	}
+
+	// BouncyCastle asks for a key of hMac.getMacSize() bits, rather than
+	// bytes, in version 1 files
+	keySize := sha1.Size
+	if version == 1 {
+		keySize = sha1.Size / 8
+	}
+	key := pkcs12KDF(sha1.New, 3, opts.password(), salt, iterations,
+		keySize)
+	mac := hmac.New(sha1.New, key)
+	clear(key)
+	mac.Write(raw[start:end])
+	if !hmac.Equal(mac.Sum(nil), stored) {
+		ks.Integrity = IntegrityMismatch
+		proven := ks.bksPasswordProven()
+		if opts.CertsOnDigestMismatch {
+			ks.Keypairs = nil
+			ks.SecretKeys = nil
+		}
+		return ks, digestMismatch(proven)
+	}
+	ks.Integrity = IntegrityVerified
+	return ks, nil
+}
+
+// bksPasswordProven reports whether any sealed key in ks was decrypted with
+// the keystore password. Unlike JKS key protection, BKS does not check the
+// password itself, but a key which decrypts with correct padding and parses
+// is good evidence.
+func (ks *Keystore) bksPasswordProven() bool {
+	for _, kp := range ks.Keypairs {
+		if kp.PrivKeyErr == nil {
+			return true
+		}
+	}
+	for _, sk := range ks.SecretKeys {
+		if sk.KeyErr == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readBKSSalt reads a salt, preceded by its length, and an iteration count, as
+// found at the start of a BKS file and of each sealed key.
+func readBKSSalt(buf *stream) (salt []byte, iterations int, err error) {
+	n, pos, err := readUint32(buf, "salt length")
+	if err != nil {
+		return nil, 0, err
+	}
+	if n == 0 || n > bksMaxSaltSize {
+		return nil, 0, errorf(CodeMalformed, "salt length %d at "+
+			"position %d out of range", n, pos)
+	}
+	if salt, err = buf.read(int64(n)); err != nil {
+		return nil, 0, eofError(err, "unexpected EOF at position %d "+
+			"while reading salt", pos)
+	}
+	count, pos, err := readUint32(buf, "iteration count")
+	if err != nil {
+		return nil, 0, err
+	}
+	if count < 1 || count > pbeMaxIterations {
+		return nil, 0, errorf(CodeMalformed, "iteration count %d at "+
+			"position %d out of range", count, pos)
+	}
+	return salt, int(count), nil
+}
+
+// readBKSEntry reads the rest of an entry whose type, read at position pos, is
+// etype, and adds it to ks unless it is skipped under Options.CertsOnly or
+// Options.Alias.
+func (ks *Keystore) readBKSEntry(buf *stream, etype byte, pos int64,
+	opts *Options,
+) error {
+	alias, _, err := readStr(buf, "entry alias")
+	if err != nil {
+		return err
+	}
+	ts, _, err := readTimestamp(buf)
+	if err != nil {
+		return err
+	}
+	ncerts, cpos, err := readUint32(buf, "length of certificate chain")
+	if err != nil {
+		return err
+	}
+	if max := opts.maxEntryCount(); ncerts > max {
+		return tooLarge(fmt.Sprintf("certificate chain length for %q",
+			alias), cpos, ncerts, max)
+	}
+	var chain []*KeypairCert
+	for n := uint32(0); n < ncerts; n++ {
+		c, err := readBKSCert(buf, fmt.Sprintf("certificate chain "+
+			"entry #%d for %q", n+1, alias), opts)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, c)
+	}
+	skip := opts.skipEntry(alias, etype != bksCertificate)
+
+	switch etype {
+	case bksCertificate:
+		c, err := readBKSCert(buf, fmt.Sprintf("certificate %q",
+			alias), opts)
+		if err != nil || skip {
+			return err
+		}
+		ks.Certs = append(ks.Certs, &Cert{
+			Alias:     alias,
+			Timestamp: ts,
+			Type:      c.Type,
+			Raw:       c.Raw,
+			Cert:      c.Cert,
+			CertErr:   c.CertErr,
+		})
+
+	case bksKey:
+		key, err := readBKSKey(buf, alias, opts)
+		if err != nil || skip {
+			return err
+		}
+		ks.addBKSKey(alias, ts, chain, key, nil, opts)
+
+	case bksSecret, bksSealed:
+		n, dpos, err := readUint32(buf, fmt.Sprintf("length of key %q",
+			alias))
+		if err != nil {
+			return err
+		}
+		if max := opts.maxKeySize(); n > max {
+			return tooLarge(fmt.Sprintf("length of key %q", alias),
+				dpos, n, max)
+		}
+		data, err := buf.read(int64(n))
+		if err != nil {
+			return eofError(err, "not enough data to read key %q "+
+				"at position %d (length %d bytes)", alias, dpos,
+				n)
+		}
+		if skip {
+			return nil
+		}
+		if etype == bksSecret {
+			ks.addBKSKey(alias, ts, chain, nil, errBKSSecret, opts)
+			break
+		}
+		key, err := unsealBKSKey(data, alias, opts)
+		ks.addBKSKey(alias, ts, chain, key, err, opts)
+
+	default:
+		return errorf(CodeMalformed, "unrecognised BKS entry type %d "+
+			"at file position %d", etype, pos)
+	}
+	ks.Order = append(ks.Order, alias)
+	return nil
+}
+
+// readBKSCert reads a certificate, described by desc in errors, as a chain
+// entry; its Type is empty if it is X.509.
+func readBKSCert(buf *stream, desc string, opts *Options,
+) (*KeypairCert, error) {
+	c := new(KeypairCert)
+	certType, offset, err := readStr(buf, "type of "+desc)
+	if err != nil {
+		return nil, err
+	}
+	if certType != CertType {
+		c.Type = certType
+	}
+	elen, pos, err := readUint32(buf, "length of "+desc)
+	if err != nil {
+		return nil, err
+	}
+	if max := opts.maxCertSize(); elen > max {
+		return nil, tooLarge("length of "+desc, pos, elen, max)
+	}
+	if c.Raw, err = buf.read(int64(elen)); err != nil {
+		return nil, eofError(err, "not enough data to read %s at "+
+			"position %d (length %d bytes)", desc, offset, elen)
+	}
+	c.Cert, c.CertErr = parseCert(c.Raw, c.Type)
+	return c, nil
+}
+
+// bksKeyData is a key as BouncyCastle encodes it: its type (bksKeyPrivate and
+// so on), the format and algorithm reported by Java's Key interface, and the
+// encoded key.
+type bksKeyData struct {
+	keyType   byte
+	format    string
+	algorithm string
+	encoded   []byte
+}
+
+// readBKSKey reads an encoded key, for the entry with the given alias.
+func readBKSKey(buf *stream, alias string, opts *Options,
+) (*bksKeyData, error) {
+	key := new(bksKeyData)
+	pos := buf.off
+	keyType, err := buf.read(1)
+	if err != nil {
+		return nil, eofError(err, "unexpected EOF at position %d "+
+			"while reading type of key %q", pos, alias)
+	}
+	key.keyType = keyType[0]
+	if key.format, _, err = readStr(buf, "key format"); err != nil {
+		return nil, err
+	}
+	if key.algorithm, _, err = readStr(buf, "key algorithm"); err != nil {
+		return nil, err
+	}
+	n, pos, err := readUint32(buf, "encoded key length")
+	if err != nil {
+		return nil, err
+	}
+	if max := opts.maxKeySize(); n > max {
+		return nil, tooLarge(fmt.Sprintf("length of key %q", alias),
+			pos, n, max)
+	}
+	if key.encoded, err = buf.read(int64(n)); err != nil {
+		return nil, eofError(err, "not enough data to read key %q at "+
+			"position %d (length %d bytes)", alias, pos, n)
+	}
+	return key, nil
+}
+
+// unsealBKSKey decrypts a sealed key entry's data with the password for its
+// alias. The data is a salt and iteration count, as for readBKSSalt, followed
+// by the key encoded as for readBKSKey and encrypted with
+// PBEWithSHAAnd3-KeyTripleDES-CBC (RFC 7292 appendix C).
+func unsealBKSKey(data []byte, alias string, opts *Options,
+) (*bksKeyData, error) {
+	if opts.SkipKeyDecryption {
+		return nil, ErrKeyNotDecrypted
+	}
+	passwd, err := opts.keyPassword(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &stream{r: bytes.NewReader(data), size: int64(len(data))}
+	salt, iterations, err := readBKSSalt(buf)
+	if err != nil {
+		return nil, errorf("", "sealed key %q: %v", alias, err)
+	}
+	ciphertext := data[buf.off:]
+	if len(ciphertext) == 0 || len(ciphertext)%des.BlockSize != 0 {
+		return nil, errorf(CodeMalformed, "sealed key %q is not a "+
+			"whole number of blocks", alias)
+	}
+	key := pkcs12KDF(sha1.New, 1, passwd, salt, iterations, 24)
+	iv := pkcs12KDF(sha1.New, 2, passwd, salt, iterations, 8)
+	block, err := des.NewTripleDESCipher(key)
+	clear(key)
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "%v", err)
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	plaintext, err = pkcs7Unpad(plaintext, des.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// with a wrong password, the padding is right about one time in 256;
+	// the key's structure then catches all but a vanishing few
+	buf = &stream{
+		r:    bytes.NewReader(plaintext),
+		size: int64(len(plaintext)),
+	}
+	k, err := readBKSKey(buf, alias, opts)
+	if err != nil || buf.off != buf.size {
+		clear(plaintext)
+		return nil, newError(CodeBadKeyPassword, "invalid password")
+	}
+	return k, nil
+}
+
+// addBKSKey adds the key entry with the given alias to ks: a Keypair if key is
+// a private key, or if key is nil (having failed to decrypt, with keyErr) and
+// there is a certificate chain; a SecretKey otherwise.
+func (ks *Keystore) addBKSKey(alias string, ts time.Time,
+	chain []*KeypairCert, key *bksKeyData, keyErr error, opts *Options,
+) {
+	if keyErr != nil && (keyErr == ErrKeyNotDecrypted ||
+		ErrorCode(keyErr) != CodeBadKeyPassword) {
+		keyErr = errorf("", "key %q: %v", alias, keyErr)
+	}
+	isPrivate := key != nil && key.keyType == bksKeyPrivate ||
+		key == nil && len(chain) != 0
+	if !isPrivate {
+		sk := &SecretKey{Alias: alias, Timestamp: ts, KeyErr: keyErr}
+		switch {
+		case key == nil:
+		case key.keyType != bksKeySecret || key.format != "RAW":
+			sk.KeyErr = errorf(CodeUnsupported, "key %q: cannot "+
+				"read %s key of type %d in format %q", alias,
+				key.algorithm, key.keyType, key.format)
+		default:
+			sk.Algorithm, sk.Key = key.algorithm, key.encoded
+		}
+		ks.SecretKeys = append(ks.SecretKeys, sk)
+		return
+	}
+
+	kp := &Keypair{
+		Alias:      alias,
+		Timestamp:  ts,
+		CertChain:  chain,
+		PrivKeyErr: keyErr,
+	}
+	switch {
+	case key == nil:
+	case key.format != "PKCS#8" && key.format != "PKCS8":
+		kp.PrivKeyErr = errorf(CodeUnsupported, "key %q: cannot read "+
+			"private key in format %q", alias, key.format)
+	default:
+		kp.RawKey = key.encoded
+		kp.parseRawKey()
+		if opts.Wipe && kp.PrivateKey != nil {
+			clear(kp.RawKey)
+			kp.RawKey = nil
+		}
+	}
+	ks.Keypairs = append(ks.Keypairs, kp)
+}
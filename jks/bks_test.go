@@ -0,0 +1,268 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bksBuilder builds BKS files for tests, as BouncyCastle's BcKeyStoreSpi
+// writes them.
+type bksBuilder struct {
+	t        *testing.T
+	b        []byte
+	salt     []byte
+	password string
+}
+
+func newBKSBuilder(t *testing.T, version uint32, password string,
+) *bksBuilder {
+	k := &bksBuilder{
+		t:        t,
+		salt:     bytes.Repeat([]byte{0x5A}, 20),
+		password: password,
+	}
+	k.b = appendUint32(k.b, version)
+	k.b = appendUint32(k.b, uint32(len(k.salt)))
+	k.b = append(k.b, k.salt...)
+	k.b = appendUint32(k.b, 1024)
+	return k
+}
+
+// entry starts an entry with the given type, alias and certificate chain.
+func (k *bksBuilder) entry(etype byte, alias string, chain ...[]byte) {
+	k.b = append(k.b, etype)
+	k.b, _ = appendStr(k.b, alias)
+	k.b = appendTimestamp(k.b, time.UnixMilli(1700000000123))
+	k.b = appendUint32(k.b, uint32(len(chain)))
+	for _, der := range chain {
+		k.b = appendBKSCert(k.b, der)
+	}
+}
+
+func appendBKSCert(b, der []byte) []byte {
+	b, _ = appendStr(b, CertType)
+	b = appendUint32(b, uint32(len(der)))
+	return append(b, der...)
+}
+
+func appendBKSKey(b []byte, keyType byte, format, algorithm string,
+	encoded []byte,
+) []byte {
+	b = append(b, keyType)
+	b, _ = appendStr(b, format)
+	b, _ = appendStr(b, algorithm)
+	b = appendUint32(b, uint32(len(encoded)))
+	return append(b, encoded...)
+}
+
+// seal encrypts an encoded key with PBEWithSHAAnd3-KeyTripleDES-CBC.
+func (k *bksBuilder) seal(key []byte, password string) []byte {
+	salt := bytes.Repeat([]byte{0xA5}, 20)
+	var b []byte
+	b = appendUint32(b, uint32(len(salt)))
+	b = append(b, salt...)
+	b = appendUint32(b, 1000)
+
+	dk := pkcs12KDF(sha1.New, 1, []byte(password), salt, 1000, 24)
+	iv := pkcs12KDF(sha1.New, 2, []byte(password), salt, 1000, 8)
+	block, err := des.NewTripleDESCipher(dk)
+	if err != nil {
+		k.t.Fatal(err)
+	}
+	n := des.BlockSize - len(key)%des.BlockSize
+	data := append(append([]byte(nil), key...),
+		bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return append(b, data...)
+}
+
+// data appends length-prefixed data, as held by secret and sealed entries.
+func (k *bksBuilder) data(data []byte) {
+	k.b = appendUint32(k.b, uint32(len(data)))
+	k.b = append(k.b, data...)
+}
+
+// bytes ends the file with its MAC, whose key is macSize bytes.
+func (k *bksBuilder) bytes(macSize int) []byte {
+	k.b = append(k.b, bksEnd)
+	start := 4 + 4 + len(k.salt) + 4
+	key := pkcs12KDF(sha1.New, 3, []byte(k.password), k.salt, 1024,
+		macSize)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(k.b[start:])
+	return mac.Sum(k.b)
+}
+
+// TestParseBKS builds BKS files of both versions, holding a trusted
+// certificate, unprotected and sealed private keys, a sealed AES key and an
+// opaque secret, and checks that ParseBKS recovers each of them.
+func TestParseBKS(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{}, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki, err := MarshalPKCS8(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesKey := bytes.Repeat([]byte{0x42}, 16)
+
+	build := func(version uint32, macSize int) []byte {
+		k := newBKSBuilder(t, version, "password")
+		k.entry(bksCertificate, "ca")
+		k.b = appendBKSCert(k.b, der)
+		k.entry(bksKey, "plain", der)
+		k.b = appendBKSKey(k.b, bksKeyPrivate, "PKCS#8", "EC", pki)
+		k.entry(bksSealed, "sealed", der)
+		k.data(k.seal(appendBKSKey(nil, bksKeyPrivate, "PKCS#8", "EC",
+			pki), "keypass"))
+		k.entry(bksSealed, "aes")
+		k.data(k.seal(appendBKSKey(nil, bksKeySecret, "RAW", "AES",
+			aesKey), "password"))
+		k.entry(bksSecret, "opaque")
+		k.data([]byte("secret"))
+		return k.bytes(macSize)
+	}
+	t.Run("v1", testParseBKS(build(1, 2), priv, aesKey))
+	t.Run("v2", testParseBKS(build(2, 20), priv, aesKey))
+
+	raw := build(2, 20)
+	ks, err := ParseBKS(raw, &Options{
+		Password:              "wrong",
+		CertsOnDigestMismatch: true,
+	})
+	switch {
+	case !errors.Is(err, ErrDigestMismatch):
+		t.Errorf("wrong password: expected ErrDigestMismatch but got "+
+			"%v", err)
+	case len(ks.Certs) != 1 || len(ks.Keypairs) != 0 ||
+		len(ks.SecretKeys) != 0:
+		t.Errorf("wrong password: got %d certs, %d keypairs, %d "+
+			"secret keys", len(ks.Certs), len(ks.Keypairs),
+			len(ks.SecretKeys))
+	}
+
+	// a v2 file checked as v1 must not verify
+	raw[3] = 1
+	if _, err = ParseBKS(raw, &Options{Password: "password"}); !errors.Is(
+		err, ErrDigestMismatch) {
+		t.Errorf("wrong MAC size: expected ErrDigestMismatch but got "+
+			"%v", err)
+	}
+	raw[3] = 2
+
+	if _, err = ParseBKS(raw[:len(raw)-1], nil); ErrorCode(err) !=
+		CodeTruncated {
+		t.Errorf("truncated: expected %s but got %v", CodeTruncated,
+			err)
+	}
+	if f := Sniff(raw); f != FormatBKS {
+		t.Errorf("Sniff: format %v ≠ expected bks", f)
+	}
+	ks, format, err := ParseAny(raw, &Options{
+		Password:          "password",
+		SkipKeyDecryption: true,
+	})
+	switch {
+	case err != nil:
+		t.Errorf("ParseAny: %v", err)
+	case format != FormatBKS:
+		t.Errorf("ParseAny: format %v ≠ expected bks", format)
+	case ks.Integrity != IntegrityVerified:
+		t.Errorf("ParseAny: integrity %v", ks.Integrity)
+	case !errors.Is(ks.Keypairs[1].PrivKeyErr, ErrKeyNotDecrypted):
+		t.Errorf("ParseAny: sealed key: %v", ks.Keypairs[1].PrivKeyErr)
+	}
+}
+
+func testParseBKS(raw []byte, priv *ecdsa.PrivateKey, aesKey []byte,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		ks, err := ParseBKS(raw, &Options{
+			Password:     "password",
+			KeyPasswords: map[string]string{"sealed": "keypass"},
+		})
+		if err != nil {
+			t.Fatalf("ParseBKS: %v", err)
+		}
+		if ks.Integrity != IntegrityVerified {
+			t.Errorf("integrity %v ≠ verified", ks.Integrity)
+		}
+		order := strings.Join(ks.Order, " ")
+		if exp := "ca plain sealed aes opaque"; order != exp {
+			t.Errorf("order %q ≠ %q", order, exp)
+		}
+		if len(ks.Certs) != 1 || ks.Certs[0].CertErr != nil ||
+			!ks.Certs[0].Timestamp.Equal(
+				time.UnixMilli(1700000000123)) {
+			t.Errorf("trusted certificate not recovered: %+v",
+				ks.Certs)
+		}
+		if len(ks.Keypairs) != 2 {
+			t.Fatalf("got %d keypairs ≠ expected 2",
+				len(ks.Keypairs))
+		}
+		for _, kp := range ks.Keypairs {
+			switch {
+			case kp.PrivKeyErr != nil:
+				t.Errorf("keypair %q: %v", kp.Alias,
+					kp.PrivKeyErr)
+			case !priv.Equal(kp.PrivateKey):
+				t.Errorf("keypair %q: private key mismatch",
+					kp.Alias)
+			case len(kp.CertChain) != 1 ||
+				kp.CertChain[0].Cert == nil:
+				t.Errorf("keypair %q: chain not recovered",
+					kp.Alias)
+			case kp.EncryptedKey != nil:
+				t.Errorf("keypair %q: EncryptedKey set",
+					kp.Alias)
+			}
+		}
+		if len(ks.SecretKeys) != 2 {
+			t.Fatalf("got %d secret keys ≠ expected 2",
+				len(ks.SecretKeys))
+		}
+		if sk := ks.SecretKeys[0]; sk.KeyErr != nil ||
+			sk.Algorithm != "AES" || !bytes.Equal(sk.Key, aesKey) {
+			t.Errorf("AES key not recovered: %+v", sk)
+		}
+		if sk := ks.SecretKeys[1]; ErrorCode(sk.KeyErr) !=
+			CodeUnsupported {
+			t.Errorf("opaque secret: expected %s but got %v",
+				CodeUnsupported, sk.KeyErr)
+		}
+
+		ks, err = ParseBKS(raw, &Options{Password: "password"})
+		if err != nil {
+			t.Fatalf("ParseBKS: %v", err)
+		}
+		if err := ks.Keypairs[1].PrivKeyErr; !errors.Is(err,
+			ErrBadKeyPassword) {
+			t.Errorf("wrong key password: expected "+
+				"ErrBadKeyPassword but got %v", err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package jks
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CertProfile controls the certificates issued by SignCSR. The subject and
+// subject alternative names are always taken from the request.
+type CertProfile struct {
+	// NotBefore is the start of the certificate's validity period. Zero
+	// means the time of issue.
+	NotBefore time.Time
+
+	// Validity is the length of the certificate's validity period. Zero
+	// means DefaultValidity. The period is cut short if necessary so that
+	// it does not extend beyond the expiry of the CA certificate.
+	Validity time.Duration
+
+	// ExtKeyUsage lists the certificate's extended key usages.
+	ExtKeyUsage []x509.ExtKeyUsage
+
+	// IsCA makes the certificate an intermediate CA certificate, with a
+	// path length constraint of MaxPathLen (or none, if negative).
+	IsCA       bool
+	MaxPathLen int
+
+	// Serial selects how the serial number is chosen. SerialSequential
+	// is not supported.
+	Serial SerialStrategy
+
+	// Clock, if not nil, is called instead of time.Now for the time of
+	// issue, as Options.Clock is by Pack.
+	Clock func() time.Time
+}
+
+// Certificate profiles for common uses, by name. These are what the
+// "sign-csr" command's --profile option selects.
+var CertProfiles = map[string]*CertProfile{
+	"tls-server": {
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	},
+	"tls-client": {
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	"tls": {
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	},
+	"code-signing": {
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	},
+	"intermediate-ca": {
+		IsCA:       true,
+		MaxPathLen: 0,
+	},
+}
+
+// SignCSR issues a certificate for the PKCS#10 request csr, signed by the CA
+// keypair entry with alias caAlias. The CA keypair's private key must have
+// been decrypted, and its certificate must be a CA certificate that may sign
+// other certificates and is valid at the time of issue. The request's
+// signature is checked, and its subject, public key and subject alternative
+// names are copied into the certificate; everything else is taken from profile
+// (which may be nil for the "tls-server" profile). The returned chain starts
+// with the new certificate, followed by the CA keypair's chain.
+func (ks *Keystore) SignCSR(caAlias string, csr *x509.CertificateRequest,
+	profile *CertProfile,
+) ([]*x509.Certificate, error) {
+	if profile == nil {
+		profile = CertProfiles["tls-server"]
+	}
+	if profile.Serial == SerialSequential {
+		return nil, errorf(CodeInvalidArgument, "%v serial numbers "+
+			"are not supported for issued certificates",
+			profile.Serial)
+	}
+
+	now := time.Now()
+	if profile.Clock != nil {
+		now = profile.Clock()
+	}
+	ca, signer, err := ks.caKeypair(caAlias, x509.KeyUsageCertSign, now)
+	if err != nil {
+		return nil, err
+	}
+	caCert := ca.CertChain[0].Cert
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errorf(CodeInvalidArgument, "invalid certificate "+
+			"request: %v", err)
+	}
+
+	serial, err := newSerial(profile.Serial, now, ks)
+	if err != nil {
+		return nil, err
+	}
+	notBefore := profile.NotBefore
+	if notBefore.IsZero() {
+		notBefore = now
+	}
+	validity := profile.Validity
+	if validity == 0 {
+		validity = DefaultValidity
+	}
+	notAfter := notBefore.Add(validity)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               csr.Subject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           profile.ExtKeyUsage,
+		DNSNames:              csr.DNSNames,
+		IPAddresses:           csr.IPAddresses,
+		EmailAddresses:        csr.EmailAddresses,
+		URIs:                  csr.URIs,
+	}
+	if profile.IsCA {
+		tmpl.IsCA = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		tmpl.MaxPathLen = profile.MaxPathLen
+		tmpl.MaxPathLenZero = profile.MaxPathLen == 0
+	} else if csr.PublicKeyAlgorithm == x509.RSA {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert,
+		csr.PublicKey, signer)
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "failed to create "+
+			"certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "failed to parse "+
+			"certificate: %v", err)
+	}
+
+	chain := []*x509.Certificate{cert}
+	for _, c := range ca.CertChain {
+		if c.Cert == nil {
+			return nil, newError(CodeMissingData, "CA certificate "+
+				"chain could not be parsed")
+		}
+		chain = append(chain, c.Cert)
+	}
+	return chain, nil
+}
+
+// caKeypair returns the CA keypair entry with the given alias and its private
+// key, or an error if it cannot be used to sign at time now: anything signed
+// outside the CA certificate's validity period could not be validated. usage
+// is the key usage needed (x509.KeyUsageCertSign or x509.KeyUsageCRLSign),
+// which is checked if the CA certificate has a key usage extension.
+func (ks *Keystore) caKeypair(alias string, usage x509.KeyUsage,
+	now time.Time,
+) (*Keypair, crypto.Signer, error) {
+	_, kpIdx := ks.findAlias(alias)
+	if kpIdx < 0 {
+		return nil, nil, errorf(CodeNoSuchAlias, "no keypair with "+
+			"alias %q", alias)
+	}
+	ca := ks.Keypairs[kpIdx]
+	switch {
+	case ca.PrivKeyErr != nil:
+		return nil, nil, errorf("", "key %q: %v", alias, ca.PrivKeyErr)
+	case len(ca.CertChain) == 0 || ca.CertChain[0].Cert == nil:
+		return nil, nil, errorf(CodeUnusableCA, "key %q has no usable "+
+			"certificate", alias)
+	}
+	signer, ok := ca.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errorf(CodeUnusableCA, "key %q: cannot sign "+
+			"with %T", alias, ca.PrivateKey)
+	}
+	caCert := ca.CertChain[0].Cert
+	switch {
+	case !caCert.IsCA:
+		return nil, nil, errorf(CodeUnusableCA, "key %q is not a CA",
+			alias)
+	case caCert.KeyUsage != 0 && caCert.KeyUsage&usage == 0:
+		return nil, nil, errorf(CodeUnusableCA, "key %q lacks the key "+
+			"usage needed to sign", alias)
+	case now.Before(caCert.NotBefore):
+		return nil, nil, errorf(CodeUnusableCA, "CA certificate of "+
+			"key %q is not valid until %s", alias,
+			caCert.NotBefore.Format(time.RFC3339))
+	case now.After(caCert.NotAfter):
+		return nil, nil, errorf(CodeUnusableCA, "CA certificate of "+
+			"key %q expired at %s", alias,
+			caCert.NotAfter.Format(time.RFC3339))
+	}
+	return ca, signer, nil
+}
+
+// CertProfileNames returns the names of the entries in CertProfiles, sorted
+// and separated by commas, for use in messages.
+func CertProfileNames() string {
+	names := make([]string, 0, len(CertProfiles))
+	for name := range CertProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
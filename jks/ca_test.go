@@ -0,0 +1,154 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestSignCSR checks that certificates issued by SignCSR chain to the CA
+// keypair and follow the request and profile.
+func TestSignCSR(t *testing.T) {
+	ks := new(jks.Keystore)
+	if _, err := ks.GenerateKeypair("ca", &jks.KeyGenParams{
+		KeyType:  jks.KeyEC,
+		IsCA:     true,
+		Validity: 30 * 24 * time.Hour,
+	}); err != nil {
+		t.Fatalf("GenerateKeypair: %v", err)
+	}
+	if _, err := ks.GenerateKeypair("leaf", nil); err != nil {
+		t.Fatalf("GenerateKeypair: %v", err)
+	}
+
+	key := jkstest.ECKey(t, elliptic.P256())
+	der, err := x509.CreateCertificateRequest(rand.Reader,
+		&x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: "app"},
+			DNSNames: []string{"app.example.org"},
+		}, key)
+	if err != nil {
+		t.Fatalf("CreateCertificateRequest: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("ParseCertificateRequest: %v", err)
+	}
+
+	chain, err := ks.SignCSR("ca", csr, jks.CertProfiles["tls-client"])
+	if err != nil {
+		t.Fatalf("SignCSR: %v", err)
+	}
+	cert, ca := chain[0], chain[len(chain)-1]
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	switch {
+	case cert.Subject.CommonName != "app":
+		t.Errorf("common name %q ≠ expected %q",
+			cert.Subject.CommonName, "app")
+	case len(cert.DNSNames) != 1:
+		t.Errorf("got %d DNS names ≠ expected 1", len(cert.DNSNames))
+	case cert.NotAfter.After(ca.NotAfter):
+		t.Errorf("certificate outlives its CA")
+	}
+
+	if _, err = ks.SignCSR("leaf", csr, nil); err == nil {
+		t.Error("expected error signing with a non-CA keypair")
+	}
+	if _, err = ks.SignCSR("missing", csr, nil); err == nil {
+		t.Error("expected error for missing alias")
+	}
+
+	// the CA certificate must be valid at the time of issue
+	for _, at := range []time.Time{
+		time.Now().Add(-time.Hour),
+		time.Now().Add(31 * 24 * time.Hour),
+	} {
+		profile := *jks.CertProfiles["tls-server"]
+		profile.Clock = func() time.Time { return at }
+		_, err = ks.SignCSR("ca", csr, &profile)
+		if code := jks.ErrorCode(err); code != jks.CodeUnusableCA {
+			t.Errorf("at %v: error code %s ≠ expected %s (%v)", at,
+				code, jks.CodeUnusableCA, err)
+		}
+	}
+
+	csr.Signature[0] ^= 1
+	if _, err = ks.SignCSR("ca", csr, nil); err == nil {
+		t.Error("expected error for bad request signature")
+	}
+}
+
+// TestGenerateIntermediateCA checks that a two-tier hierarchy built with
+// GenerateIntermediateCA and IssueKeypair verifies, and that the
+// intermediate's constraints are enforced.
+func TestGenerateIntermediateCA(t *testing.T) {
+	ks := new(jks.Keystore)
+	root, err := ks.GenerateKeypair("root", &jks.KeyGenParams{
+		KeyType: jks.KeyEC,
+		IsCA:    true,
+	})
+	if err != nil {
+		t.Fatalf("GenerateKeypair: %v", err)
+	}
+	sub, err := ks.GenerateIntermediateCA("root", "sub", &jks.KeyGenParams{
+		KeyType:             jks.KeyEC,
+		MaxPathLenZero:      true,
+		PermittedDNSDomains: []string{"example.org"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateIntermediateCA: %v", err)
+	}
+	if len(sub.CertChain) != 2 {
+		t.Fatalf("intermediate chain length %d ≠ expected 2",
+			len(sub.CertChain))
+	}
+	if cert := sub.CertChain[0].Cert; !cert.IsCA || cert.MaxPathLen != 0 ||
+		!cert.MaxPathLenZero {
+		t.Errorf("unexpected intermediate basic constraints")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.CertChain[0].Cert)
+	verify := func(alias, dnsName string) error {
+		kp, err := ks.IssueKeypair("sub", alias, &jks.KeyGenParams{
+			KeyType:  jks.KeyEC,
+			DNSNames: []string{dnsName},
+		})
+		if err != nil {
+			t.Fatalf("IssueKeypair: %v", err)
+		}
+		if len(kp.CertChain) != 3 {
+			t.Errorf("leaf chain length %d ≠ expected 3",
+				len(kp.CertChain))
+		}
+		inter := x509.NewCertPool()
+		inter.AddCert(kp.CertChain[1].Cert)
+		_, err = kp.CertChain[0].Cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: inter,
+		})
+		return err
+	}
+	if err = verify("ok", "www.example.org"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err = verify("bad", "www.example.com"); err == nil {
+		t.Error("expected name constraint violation")
+	}
+	if err = ks.CheckChains(nil); err != nil {
+		t.Errorf("CheckChains: %v", err)
+	}
+}
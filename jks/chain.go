@@ -0,0 +1,434 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// CheckChains checks the structure of each keypair's certificate chain,
+// returning a *ValidationError listing all problems found, or nil. For each
+// chain it checks that:
+//   - the chain is no longer than opts.MaxChainLength (if not zero);
+//   - each certificate was issued and signed by the next one in the chain;
+//   - each issuing certificate is marked as a CA by its basicConstraints and
+//     key usage, and its path length constraint is respected;
+//   - each certificate's extended key usages are permitted by its issuers.
+//
+// These are the rules the JVM applies when building a path for a TLS
+// handshake. Certificates that could not be parsed are skipped. Signatures
+// using algorithms that crypto/x509 considers insecure are not checked here;
+// see Options.DisabledAlgorithms.
+func (ks *Keystore) CheckChains(opts *Options) error {
+	opts, err := opts.normalize()
+	if err != nil {
+		return err
+	}
+	var problems []error
+	for _, kp := range ks.Keypairs {
+		if kp == nil {
+			continue
+		}
+		for _, err := range opts.checkChain(kp.CertChain) {
+			problems = append(problems,
+				errorf("", "key %q: %v", kp.Alias, err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// VerifyChains checks that the certificate chain of each keypair leads from its
+// leaf to one of the given roots, returning a *ValidationError listing the
+// keypairs for which it does not, or nil. The rest of each chain is offered as
+// intermediates, and the path is built and checked by x509.Certificate.Verify:
+// each certificate must be within its validity period now, each issuer must be
+// a CA whose basic constraints permit the path, and no extended key usage is
+// required. A path that leaves out some of the chain is accepted, since the
+// JVM too stops at the first trusted certificate.
+//
+// Unlike CheckChains, which only checks the chain against itself, this catches
+// a chain that is complete but issued by a CA that the servers or clients do
+// not trust, before the keystore is deployed. A keypair whose leaf could not
+// be parsed is a problem; one with no chain at all is left to Validate.
+// crypto/x509 does not verify SHA-1 signatures, so chains using them fail.
+func (ks *Keystore) VerifyChains(roots *x509.CertPool) error {
+	return ks.verifyChains(roots, time.Time{})
+}
+
+// verifyChains implements VerifyChains, checking validity at the time at, or
+// now if at is zero.
+func (ks *Keystore) verifyChains(roots *x509.CertPool, at time.Time) error {
+	var problems []error
+	for _, kp := range ks.Keypairs {
+		if kp == nil || len(kp.CertChain) == 0 {
+			continue
+		}
+		if err := verifyChain(kp.CertChain, roots, at); err != nil {
+			problems = append(problems, errorf(CodeValidation,
+				"key %q: %v", kp.Alias, err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// verifyChain verifies the leaf of chain against roots at the time at, with the
+// rest of the chain as intermediates.
+func verifyChain(chain []*KeypairCert, roots *x509.CertPool, at time.Time,
+) error {
+	if chain[0] == nil || chain[0].Cert == nil {
+		return errors.New("leaf certificate could not be parsed")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		if c != nil && c.Cert != nil {
+			intermediates.AddCert(c.Cert)
+		}
+	}
+	_, err := chain[0].Cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// OrderChain puts the keypair's certificate chain in the order that Java
+// expects, from the leaf to the root, whatever order the certificates were
+// supplied in, and fills any gaps from pool. The leaf is the certificate
+// matching the private key or, if the key has not been decrypted, the one
+// certificate which issued none of the others. Each certificate is followed by
+// its issuer, matched by name, taken from the chain if it is there and from
+// pool if not; the chain ends at a self-signed certificate, or when no issuer
+// can be found. Duplicate certificates are dropped. pool might hold the
+// keystore's trusted certificates, or a CA bundle.
+//
+// It is an error with CodeInvalidArgument if a certificate in the chain is not
+// part of the leaf's path, and with CodeKeyMismatch if no certificate matches
+// the private key. The chain is not changed if an error is returned.
+// CheckChains and VerifyChains may be used to check the result.
+func (kp *Keypair) OrderChain(pool []*x509.Certificate) error {
+	var certs []*KeypairCert
+	for _, c := range kp.CertChain {
+		if c != nil && !hasDER(certs, c.DER()) {
+			certs = append(certs, c)
+		}
+	}
+	if len(certs) == 0 {
+		return errorf(CodeMissingData, "key %q has no certificate "+
+			"chain", kp.Alias)
+	}
+	leaf, err := kp.chainLeaf(certs)
+	if err != nil {
+		return err
+	}
+
+	candidates := slices.Clone(certs)
+	for _, c := range pool {
+		if c != nil && !hasDER(candidates, c.Raw) {
+			candidates = append(candidates, &KeypairCert{
+				Raw:  c.Raw,
+				Cert: c,
+			})
+		}
+	}
+	chain := orderChain(leaf, candidates)
+	for _, c := range certs {
+		if !inChain(chain, c) {
+			return errorf(CodeInvalidArgument, "key %q: %q is not "+
+				"in the chain of %q", kp.Alias, certName(c),
+				certName(leaf))
+		}
+	}
+	kp.CertChain = chain
+	return nil
+}
+
+// chainLeaf returns the leaf among certs, as described for OrderChain.
+func (kp *Keypair) chainLeaf(certs []*KeypairCert) (*KeypairCert, error) {
+	var compared bool
+	for _, c := range certs {
+		if c.Cert == nil || kp.PrivateKey == nil {
+			continue
+		}
+		match, ok := keyMatches(kp.PrivateKey, c.Cert.PublicKey)
+		if match {
+			return c, nil
+		}
+		compared = compared || ok
+	}
+	if compared {
+		return nil, errorf(CodeKeyMismatch, "key %q does not match "+
+			"any certificate in its chain", kp.Alias)
+	}
+
+	// without the key, the leaf is the certificate which issued no other
+	var leaf *KeypairCert
+	for _, c := range certs {
+		if c.Cert == nil || slices.ContainsFunc(certs,
+			func(d *KeypairCert) bool {
+				return d != c && d.Cert != nil &&
+					bytes.Equal(d.Cert.RawIssuer,
+						c.Cert.RawSubject)
+			}) {
+			continue
+		}
+		if leaf != nil {
+			return nil, errorf(CodeInvalidArgument, "key %q: "+
+				"cannot tell whether %q or %q is the leaf",
+				kp.Alias, certName(leaf), certName(c))
+		}
+		leaf = c
+	}
+	if leaf == nil {
+		return nil, errorf(CodeInvalidArgument, "key %q: cannot "+
+			"find the leaf certificate", kp.Alias)
+	}
+	return leaf, nil
+}
+
+// ImportCertReply replaces the certificate chain of the keypair with the given
+// alias with certs, a CA's reply to a request made with
+// CreateCertificateRequest, as "keytool -importcert -alias" does when renewing
+// a key. certs may be the new leaf certificate alone or a chain in any order.
+// The leaf is the certificate holding the keypair's public key: it is checked
+// against the private key or, if that has not been decrypted, the current leaf
+// certificate, and an error with CodeKeyMismatch is returned if none matches.
+// The chain is then ordered as OrderChain does, taking missing issuers from
+// the keystore's trusted certificates and the keypair's current chain, and
+// each certificate's signature is checked against its issuer. The keypair's
+// timestamp is set to now.
+//
+// It is an error with CodeInvalidArgument if the reply's leaf is the current
+// leaf, or if a certificate in certs is not part of its chain. The keypair is
+// not changed if an error is returned. Whether the chain ends at a trusted
+// root is not checked; VerifyChains may be used for that.
+func (ks *Keystore) ImportCertReply(alias string, certs []*x509.Certificate,
+) error {
+	_, kpIdx := ks.findAlias(alias)
+	if kpIdx < 0 {
+		return errorf(CodeNoSuchAlias, "no keypair with alias %q",
+			alias)
+	}
+	kp := ks.Keypairs[kpIdx]
+
+	var reply []*KeypairCert
+	for _, c := range certs {
+		if c != nil && !hasDER(reply, c.Raw) {
+			reply = append(reply, &KeypairCert{Raw: c.Raw, Cert: c})
+		}
+	}
+	if len(reply) == 0 {
+		return errorf(CodeMissingData, "key %q: empty certificate "+
+			"reply", alias)
+	}
+	leaf, err := kp.replyLeaf(reply)
+	if err != nil {
+		return err
+	}
+	if len(kp.CertChain) != 0 && kp.CertChain[0] != nil &&
+		bytes.Equal(kp.CertChain[0].DER(), leaf.Raw) {
+		return errorf(CodeInvalidArgument, "key %q: certificate reply "+
+			"and certificate in keystore are identical", alias)
+	}
+
+	candidates := slices.Clone(reply)
+	add := func(c *x509.Certificate) {
+		if c != nil && !hasDER(candidates, c.Raw) {
+			candidates = append(candidates,
+				&KeypairCert{Raw: c.Raw, Cert: c})
+		}
+	}
+	for _, c := range ks.Certs {
+		add(c.Cert)
+	}
+	for i, c := range kp.CertChain {
+		if i > 0 && c != nil {
+			add(c.Cert)
+		}
+	}
+	chain := orderChain(leaf, candidates)
+	for _, c := range reply {
+		if !inChain(chain, c) {
+			return errorf(CodeInvalidArgument, "key %q: %q is not "+
+				"in the chain of %q", alias, certName(c),
+				certName(leaf))
+		}
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		err := chain[i].Cert.CheckSignatureFrom(chain[i+1].Cert)
+		if err != nil {
+			return errorf(CodeValidation, "key %q: %q is not "+
+				"signed by %q: %v", alias, certName(chain[i]),
+				certName(chain[i+1]), err)
+		}
+	}
+
+	kp.CertChain = chain
+	kp.Timestamp = time.Now()
+	return nil
+}
+
+// replyLeaf returns the certificate among reply holding the keypair's public
+// key, as described for ImportCertReply.
+func (kp *Keypair) replyLeaf(reply []*KeypairCert) (*KeypairCert, error) {
+	var old *x509.Certificate
+	if len(kp.CertChain) != 0 && kp.CertChain[0] != nil {
+		old = kp.CertChain[0].Cert
+	}
+	if kp.PrivateKey == nil && old == nil {
+		return nil, errorf(CodeKeyNotDecrypted, "key %q: cannot "+
+			"check the reply without the private key or a "+
+			"certificate", kp.Alias)
+	}
+	for _, c := range reply {
+		if kp.PrivateKey != nil {
+			if match, _ := keyMatches(kp.PrivateKey,
+				c.Cert.PublicKey); match {
+				return c, nil
+			}
+		} else if bytes.Equal(c.Cert.RawSubjectPublicKeyInfo,
+			old.RawSubjectPublicKeyInfo) {
+			return c, nil
+		}
+	}
+	return nil, errorf(CodeKeyMismatch, "key %q: public keys in reply "+
+		"and keystore don't match", kp.Alias)
+}
+
+// hasDER reports whether a certificate with the given DER is in certs.
+func hasDER(certs []*KeypairCert, der []byte) bool {
+	return slices.ContainsFunc(certs, func(c *KeypairCert) bool {
+		return bytes.Equal(c.DER(), der)
+	})
+}
+
+// checkChain returns the structural problems found in a single chain.
+func (opts *Options) checkChain(chain []*KeypairCert) []error {
+	var problems []error
+	problem := func(i int, format string, args ...interface{}) {
+		problems = append(problems, errorf(CodeValidation,
+			"certificate chain entry #%d: "+format,
+			append([]interface{}{i + 1}, args...)...))
+	}
+
+	if opts.MaxChainLength > 0 && len(chain) > opts.MaxChainLength {
+		problems = append(problems, errorf(CodeValidation,
+			"certificate chain has %d entries (maximum %d)",
+			len(chain), opts.MaxChainLength))
+	}
+
+	// entries which are nil, or could not be parsed, are skipped
+	certs := make([]*x509.Certificate, len(chain))
+	for i, c := range chain {
+		if c != nil {
+			certs[i] = c.Cert
+		}
+	}
+
+	for i := 1; i < len(chain); i++ {
+		cert, issuer := certs[i-1], certs[i]
+		if cert == nil || issuer == nil {
+			continue
+		}
+
+		// only check the signature if the names match, to avoid
+		// reporting the same misordering twice
+		var insecure x509.InsecureAlgorithmError
+		if !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+			problem(i-1, "issuer %q does not match subject of "+
+				"next certificate %q", cert.Issuer,
+				issuer.Subject)
+		} else if err := issuer.CheckSignature(cert.SignatureAlgorithm,
+			cert.RawTBSCertificate, cert.Signature); err != nil &&
+			!errors.As(err, &insecure) {
+			problem(i-1, "not signed by next certificate: %v", err)
+		}
+
+		if !issuer.BasicConstraintsValid || !issuer.IsCA {
+			problem(i, "issues certificates but is not a CA")
+		}
+		if issuer.KeyUsage != 0 &&
+			issuer.KeyUsage&x509.KeyUsageCertSign == 0 {
+			problem(i, "issues certificates but key usage "+
+				"does not include certSign")
+		}
+
+		// the path length constraint counts the intermediates below
+		// the issuer, not including the leaf
+		if below := i - 1; issuer.BasicConstraintsValid &&
+			(issuer.MaxPathLen > 0 || issuer.MaxPathLenZero) &&
+			below > issuer.MaxPathLen {
+			problem(i, "path length constraint %d exceeded by %d "+
+				"intermediate(s)", issuer.MaxPathLen, below)
+		}
+
+		// every issuer must permit the extended key usages of every
+		// certificate below it
+		for j := 0; j < i; j++ {
+			if certs[j] == nil {
+				continue
+			}
+			for _, eku := range ekuNotPermitted(certs[j], issuer) {
+				problem(j, "extended key usage %s not "+
+					"permitted by entry #%d", eku, i+1)
+			}
+		}
+	}
+	return problems
+}
+
+// ekuNotPermitted returns the names of any extended key usages of cert that
+// issuer does not permit. An issuer with no extended key usages, or with
+// anyExtendedKeyUsage, permits everything.
+func ekuNotPermitted(cert, issuer *x509.Certificate) []string {
+	if len(issuer.ExtKeyUsage) == 0 && len(issuer.UnknownExtKeyUsage) == 0 {
+		return nil
+	}
+	permitted := make(map[x509.ExtKeyUsage]bool)
+	for _, eku := range issuer.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageAny {
+			return nil
+		}
+		permitted[eku] = true
+	}
+
+	var names []string
+	for _, eku := range cert.ExtKeyUsage {
+		if eku != x509.ExtKeyUsageAny && !permitted[eku] {
+			names = append(names, ekuName(eku))
+		}
+	}
+	return names
+}
+
+// ekuNames gives the names used in RFC 5280 for the extended key usages known
+// to crypto/x509.
+var ekuNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "anyExtendedKeyUsage",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:  "ipsecEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:     "ipsecTunnel",
+	x509.ExtKeyUsageIPSECUser:       "ipsecUser",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+func ekuName(eku x509.ExtKeyUsage) string {
+	if name, ok := ekuNames[eku]; ok {
+		return name
+	}
+	return fmt.Sprintf("ExtKeyUsage(%d)", int(eku))
+}
@@ -0,0 +1,349 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestCheckChains checks that CheckChains reports structurally broken
+// keypair certificate chains.
+func TestCheckChains(t *testing.T) {
+	rootKey := jkstest.ECKey(t, elliptic.P256())
+	root := jkstest.SelfSigned(t, rootKey, "Root")
+	interKey := jkstest.ECKey(t, elliptic.P256())
+	inter := jkstest.Issue(t, interKey, "Intermediate", root, rootKey, true)
+	leafKey := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, leafKey, "leaf", inter, interKey, false)
+
+	// an intermediate without basicConstraints
+	notCA := chainCert(t, interKey, "Not a CA", root, rootKey,
+		func(c *x509.Certificate) {})
+	notCALeaf := jkstest.Issue(t, leafKey, "leaf", notCA, interKey, false)
+
+	// an intermediate restricted to clientAuth
+	clientOnly := chainCert(t, interKey, "Client CA", root, rootKey,
+		func(c *x509.Certificate) {
+			c.BasicConstraintsValid = true
+			c.IsCA = true
+			c.KeyUsage = x509.KeyUsageCertSign
+			c.ExtKeyUsage = []x509.ExtKeyUsage{
+				x509.ExtKeyUsageClientAuth,
+			}
+		})
+	clientOnlyLeaf := jkstest.Issue(t, leafKey, "leaf", clientOnly,
+		interKey, false)
+
+	// a root that forbids intermediates
+	pathLenRoot := chainCert(t, rootKey, "Root", nil, nil,
+		func(c *x509.Certificate) {
+			c.BasicConstraintsValid = true
+			c.IsCA = true
+			c.MaxPathLenZero = true
+			c.KeyUsage = x509.KeyUsageCertSign
+		})
+	pathLenInter := jkstest.Issue(t, interKey, "Intermediate",
+		pathLenRoot, rootKey, true)
+	pathLenLeaf := jkstest.Issue(t, leafKey, "leaf", pathLenInter,
+		interKey, false)
+
+	t.Run("valid", testCheckChains(nil, 0, leaf, inter, root))
+	t.Run("leaf only", testCheckChains(nil, 0, leaf))
+	t.Run("too long", testCheckChains(&jks.Options{MaxChainLength: 2},
+		1, leaf, inter, root))
+	t.Run("wrong order", testCheckChains(nil, 2, leaf, root, inter))
+	t.Run("wrong issuer", testCheckChains(nil, 1, leaf, root))
+	t.Run("issuer not CA", testCheckChains(nil, 1,
+		notCALeaf, notCA, root))
+	t.Run("EKU not permitted", testCheckChains(nil, 1,
+		clientOnlyLeaf, clientOnly, root))
+	t.Run("path length", testCheckChains(nil, 1,
+		pathLenLeaf, pathLenInter, pathLenRoot))
+}
+
+// chainCert issues a certificate for key from a minimal template, adjusted
+// by setup. If parent is nil then the certificate is self-signed.
+func chainCert(t *testing.T, key crypto.Signer, cn string,
+	parent *x509.Certificate, parentKey crypto.Signer,
+	setup func(*x509.Certificate),
+) *x509.Certificate {
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	setup(tmpl)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent,
+		key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func testCheckChains(opts *jks.Options, expProblems int,
+	chain ...*x509.Certificate,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		if opts == nil {
+			opts = new(jks.Options)
+		}
+		// without a private key, only the chain is checked
+		b := jkstest.New(t, "password").KeypairWithChain("kp", nil,
+			chain...)
+
+		var problems []error
+		var verr *jks.ValidationError
+		err := b.Keystore().CheckChains(opts)
+		if errors.As(err, &verr) {
+			problems = verr.Problems
+		} else if err != nil {
+			t.Fatalf("unexpected error type: %v", err)
+		}
+		if len(problems) != expProblems {
+			t.Errorf("problems %d ≠ expected %d (%v)",
+				len(problems), expProblems, err)
+		}
+
+		// Validate must report the same problems
+		err = b.Keystore().Validate(opts)
+		if (err == nil) != (expProblems == 0) {
+			t.Errorf("Validate: unexpected result %v", err)
+		}
+	}
+}
+
+// TestVerifyChains checks that VerifyChains, and Pack with Options.Roots, only
+// accept chains that lead to a trusted root and are valid at the time given.
+func TestVerifyChains(t *testing.T) {
+	rootKey := jkstest.ECKey(t, elliptic.P256())
+	root := jkstest.SelfSigned(t, rootKey, "Root")
+	interKey := jkstest.ECKey(t, elliptic.P256())
+	inter := jkstest.Issue(t, interKey, "Intermediate", root, rootKey, true)
+	leafKey := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, leafKey, "leaf", inter, interKey, false)
+	other := jkstest.SelfSigned(t, jkstest.ECKey(t, elliptic.P256()),
+		"Other Root")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	t.Run("valid", testVerifyChains(roots, true, leaf, inter, root))
+	t.Run("without root", testVerifyChains(roots, true, leaf, inter))
+	t.Run("missing intermediate", testVerifyChains(roots, false, leaf))
+	t.Run("untrusted", testVerifyChains(roots, false, other))
+
+	untrusted := x509.NewCertPool()
+	untrusted.AddCert(other)
+	t.Run("other root", testVerifyChains(untrusted, false,
+		leaf, inter, root))
+
+	// the chain is only valid for a day
+	ks := jkstest.New(t, "password").KeypairWithChain("kp", leafKey,
+		leaf, inter).Keystore()
+	opts := &jks.Options{Roots: roots}
+	at := time.Now().Add(12 * time.Hour)
+	if err := ks.ValidateAt(opts, at); err != nil {
+		t.Errorf("ValidateAt: %v", err)
+	}
+	if err := ks.ValidateAt(opts, at.Add(36*time.Hour)); err == nil {
+		t.Error("ValidateAt: expired chain accepted")
+	}
+}
+
+func testVerifyChains(roots *x509.CertPool, exp bool,
+	chain ...*x509.Certificate,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		b := jkstest.New(t, "password").KeypairWithChain("kp",
+			jkstest.ECKey(t, elliptic.P256()), chain...)
+		err := b.Keystore().VerifyChains(roots)
+		if (err == nil) != exp {
+			t.Errorf("VerifyChains: unexpected result %v", err)
+		}
+		if exp {
+			return
+		}
+
+		opts := b.Options()
+		opts.Roots = roots
+		if _, err := b.Keystore().Pack(opts); jks.ErrorCode(err) !=
+			jks.CodeValidation {
+			t.Errorf("Pack: unexpected error %v", err)
+		}
+		if _, err := b.Keystore().PackPKCS12(opts); err == nil {
+			t.Error("PackPKCS12: expected error")
+		}
+	}
+}
+
+// TestOrderChain checks that OrderChain puts a chain in leaf-first order,
+// completes it from the pool, and refuses certificates that are not part of
+// it.
+func TestOrderChain(t *testing.T) {
+	rootKey := jkstest.ECKey(t, elliptic.P256())
+	root := jkstest.SelfSigned(t, rootKey, "Root")
+	interKey := jkstest.ECKey(t, elliptic.P256())
+	inter := jkstest.Issue(t, interKey, "Intermediate", root, rootKey, true)
+	leafKey := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, leafKey, "leaf", inter, interKey, false)
+	otherKey := jkstest.ECKey(t, elliptic.P256())
+	other := jkstest.Issue(t, otherKey, "other", inter, interKey, false)
+
+	chain := func(certs ...*x509.Certificate) []*x509.Certificate {
+		return certs
+	}
+	t.Run("shuffled", testOrderChain(leafKey, chain(root, leaf, inter),
+		nil, chain(leaf, inter, root), ""))
+	t.Run("no key", testOrderChain(nil, chain(inter, root, leaf),
+		nil, chain(leaf, inter, root), ""))
+	t.Run("complete", testOrderChain(leafKey, chain(leaf),
+		chain(root, other, inter), chain(leaf, inter, root), ""))
+	t.Run("duplicates", testOrderChain(nil, chain(inter, leaf, inter),
+		nil, chain(leaf, inter), ""))
+	t.Run("not in chain", testOrderChain(leafKey,
+		chain(leaf, other, inter), nil, nil, jks.CodeInvalidArgument))
+	t.Run("two leaves", testOrderChain(nil, chain(leaf, other, inter),
+		nil, nil, jks.CodeInvalidArgument))
+	t.Run("wrong key", testOrderChain(rootKey, chain(leaf, inter),
+		nil, nil, jks.CodeKeyMismatch))
+}
+
+func testOrderChain(key crypto.Signer, chain, pool, exp []*x509.Certificate,
+	expCode string,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		kp := jkstest.New(t, "password").KeypairWithChain("kp", key,
+			chain...).Keystore().Keypairs[0]
+		err := kp.OrderChain(pool)
+		if code := jks.ErrorCode(err); code != expCode {
+			t.Fatalf("code %q ≠ expected %q (%v)", code, expCode,
+				err)
+		}
+		if err != nil {
+			if len(kp.CertChain) != len(chain) {
+				t.Error("chain modified despite error")
+			}
+			return
+		}
+		if len(kp.CertChain) != len(exp) {
+			t.Fatalf("chain length %d ≠ expected %d",
+				len(kp.CertChain), len(exp))
+		}
+		for i, c := range kp.CertChain {
+			if c.Cert != exp[i] {
+				t.Errorf("chain entry #%d: %q ≠ expected %q",
+					i+1, c.Cert.Subject.CommonName,
+					exp[i].Subject.CommonName)
+			}
+		}
+	}
+}
+
+// TestImportCertReply checks that ImportCertReply replaces a keypair's
+// self-signed certificate with a CA's reply, completing the chain from the
+// keystore, and rejects replies for other keys.
+func TestImportCertReply(t *testing.T) {
+	rootKey := jkstest.ECKey(t, elliptic.P256())
+	root := jkstest.SelfSigned(t, rootKey, "Root")
+	interKey := jkstest.ECKey(t, elliptic.P256())
+	inter := jkstest.Issue(t, interKey, "Intermediate", root, rootKey, true)
+	key := jkstest.ECKey(t, elliptic.P256())
+	selfSigned := jkstest.Issue(t, key, "server", nil, nil, false)
+	leaf := jkstest.Issue(t, key, "server", inter, interKey, false)
+	other := jkstest.Issue(t, jkstest.ECKey(t, elliptic.P256()), "other",
+		inter, interKey, false)
+
+	keystore := func() *jks.Keystore {
+		return jkstest.New(t, "password").
+			Cert("root", root).
+			KeypairWithChain("server", key, selfSigned).
+			Keystore()
+	}
+
+	t.Run("reply with intermediate", func(t *testing.T) {
+		ks := keystore()
+		err := ks.ImportCertReply("SERVER",
+			[]*x509.Certificate{inter, leaf})
+		if err != nil {
+			t.Fatalf("ImportCertReply: %v", err)
+		}
+		expectChain(t, ks.Keypairs[0], leaf, inter, root)
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		if err = ks.VerifyChains(roots); err != nil {
+			t.Errorf("VerifyChains: %v", err)
+		}
+	})
+
+	t.Run("key not decrypted", func(t *testing.T) {
+		ks := keystore()
+		ks.Keypairs[0].PrivateKey = nil
+		err := ks.ImportCertReply("server", []*x509.Certificate{leaf})
+		if err != nil {
+			t.Fatalf("ImportCertReply: %v", err)
+		}
+		expectChain(t, ks.Keypairs[0], leaf)
+	})
+
+	for _, tc := range []struct {
+		name  string
+		alias string
+		reply []*x509.Certificate
+		code  string
+	}{
+		{"no such alias", "root", []*x509.Certificate{leaf},
+			jks.CodeNoSuchAlias},
+		{"empty", "server", nil, jks.CodeMissingData},
+		{"other key", "server", []*x509.Certificate{other},
+			jks.CodeKeyMismatch},
+		{"identical", "server", []*x509.Certificate{selfSigned},
+			jks.CodeInvalidArgument},
+		{"unrelated", "server", []*x509.Certificate{leaf, other},
+			jks.CodeInvalidArgument},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ks := keystore()
+			err := ks.ImportCertReply(tc.alias, tc.reply)
+			if code := jks.ErrorCode(err); code != tc.code {
+				t.Errorf("error %v: code %q ≠ expected %q", err,
+					code, tc.code)
+			}
+			expectChain(t, ks.Keypairs[0], selfSigned)
+		})
+	}
+}
+
+// expectChain checks that the keypair's certificate chain is exp.
+func expectChain(t *testing.T, kp *jks.Keypair, exp ...*x509.Certificate) {
+	t.Helper()
+	if len(kp.CertChain) != len(exp) {
+		t.Fatalf("chain length %d ≠ expected %d", len(kp.CertChain),
+			len(exp))
+	}
+	for i, c := range kp.CertChain {
+		if !bytes.Equal(c.DER(), exp[i].Raw) {
+			t.Errorf("chain entry #%d: %q ≠ expected %q", i+1,
+				c.Cert.Subject.CommonName,
+				exp[i].Subject.CommonName)
+		}
+	}
+}
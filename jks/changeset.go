@@ -0,0 +1,150 @@
+package jks
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ChangeSet is a list of modifications to a keystore which are applied
+// together by Apply: either all of them take effect, or none do. The zero
+// value is an empty change set, ready to use.
+type ChangeSet struct {
+	changes []change
+}
+
+// change is a single modification. It is applied to a working copy of the
+// keystore and options.
+type change struct {
+	desc  string
+	apply func(ks *Keystore, opts *Options) error
+}
+
+func (cs *ChangeSet) add(desc string,
+	apply func(ks *Keystore, opts *Options) error,
+) {
+	cs.changes = append(cs.changes, change{desc: desc, apply: apply})
+}
+
+// Len returns the number of changes queued.
+func (cs *ChangeSet) Len() int {
+	return len(cs.changes)
+}
+
+// AddCert queues the addition of a trusted certificate. Its alias must not
+// already be in use.
+func (cs *ChangeSet) AddCert(cert *Cert) {
+	cs.add(fmt.Sprintf("add certificate %q", cert.Alias),
+		func(ks *Keystore, _ *Options) error {
+			return ks.AddCert(cert, CollisionError)
+		})
+}
+
+// AddKeypair queues the addition of a keypair. Its alias must not already be
+// in use.
+func (cs *ChangeSet) AddKeypair(kp *Keypair) {
+	cs.add(fmt.Sprintf("add keypair %q", kp.Alias),
+		func(ks *Keystore, _ *Options) error {
+			return ks.AddKeypair(kp, CollisionError)
+		})
+}
+
+// Delete queues the removal of the entry with the given alias, along with any
+// per-key password for it. The alias must exist.
+func (cs *ChangeSet) Delete(alias string) {
+	cs.add(fmt.Sprintf("delete %q", alias),
+		func(ks *Keystore, opts *Options) error {
+			if err := ks.DeleteEntry(alias); err != nil {
+				return err
+			}
+			delete(opts.KeyPasswords, alias)
+			return nil
+		})
+}
+
+// Rename queues a change of the entry with alias from to have alias to. Any
+// per-key password moves with it. from must exist and to must not. The entry
+// is copied before being renamed, so the original is never modified.
+func (cs *ChangeSet) Rename(from, to string) {
+	cs.add(fmt.Sprintf("rename %q to %q", from, to),
+		func(ks *Keystore, opts *Options) error {
+			if err := ks.RenameAlias(from, to); err != nil {
+				return err
+			}
+			if pw, ok := opts.KeyPasswords[from]; ok {
+				delete(opts.KeyPasswords, from)
+				opts.KeyPasswords[to] = pw
+			}
+			return nil
+		})
+}
+
+// SetPassword queues a change of the keystore password (Options.Password).
+func (cs *ChangeSet) SetPassword(password string) {
+	cs.add("set keystore password",
+		func(_ *Keystore, opts *Options) error {
+			opts.Password = password
+			return nil
+		})
+}
+
+// SetKeyPassword queues a change of the password protecting the private key
+// of the keypair with the given alias (an entry in Options.KeyPasswords). The
+// keypair must exist when the change is applied.
+func (cs *ChangeSet) SetKeyPassword(alias, password string) {
+	cs.add(fmt.Sprintf("set password for %q", alias),
+		func(ks *Keystore, opts *Options) error {
+			if _, kpIdx := ks.findAlias(alias); kpIdx < 0 {
+				return newError(CodeNoSuchAlias,
+					"no such keypair")
+			}
+			opts.KeyPasswords[alias] = password
+			return nil
+		})
+}
+
+// Apply makes the queued changes to ks and opts, in order. The changes are
+// first made to copies, and the result is checked by packing it with the new
+// options, so that any policy set in opts (compatibility, key sizes, signature
+// algorithms) is enforced and every private key is known to be available. Only
+// if every change succeeds and the result can be packed are ks and opts
+// updated; otherwise they are left untouched and the first error is returned.
+// Secret keys, which Pack cannot write, are carried over but left out of that
+// check.
+//
+// Entries themselves are not deep copied; as with Merge, an entry that is
+// renamed is copied first. opts.KeyPasswords is replaced with a new map.
+func (cs *ChangeSet) Apply(ks *Keystore, opts *Options) error {
+	if opts == nil {
+		return errNilOptions
+	}
+	// copy every field, and the slices the changes may modify in place
+	work := *ks
+	work.Certs = slices.Clone(ks.Certs)
+	work.Keypairs = slices.Clone(ks.Keypairs)
+	work.SecretKeys = slices.Clone(ks.SecretKeys)
+	work.UnknownEntries = slices.Clone(ks.UnknownEntries)
+	work.Order = slices.Clone(ks.Order)
+	workOpts := *opts
+	workOpts.KeyPasswords = make(map[string]string,
+		len(opts.KeyPasswords))
+	for alias, pw := range opts.KeyPasswords {
+		workOpts.KeyPasswords[alias] = pw
+	}
+
+	for i, c := range cs.changes {
+		if err := c.apply(&work, &workOpts); err != nil {
+			return errorf("", "change %d (%s): %v", i+1, c.desc,
+				err)
+		}
+	}
+
+	check := work
+	check.SecretKeys = nil
+	if _, err := check.Pack(&workOpts); err != nil {
+		return errorf("", "result cannot be packed: %v", err)
+	}
+
+	*ks = work
+	*opts = workOpts
+	return nil
+}
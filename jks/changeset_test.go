@@ -0,0 +1,104 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestChangeSet checks that a change set is applied in full, and that the
+// result can be packed and parsed with the new passwords.
+func TestChangeSet(t *testing.T) {
+	b := jkstest.New(t, "password").
+		CA("root").
+		ECKeypair("server", elliptic.P256()).
+		KeyPassword("server", "keypass")
+	ks, opts := b.Keystore(), b.Options()
+	orig := ks.Keypairs[0]
+
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "new root")
+
+	var cs jks.ChangeSet
+	cs.AddCert(&jks.Cert{Alias: "new", Timestamp: time.Now(), Cert: ca})
+	cs.Delete("root")
+	cs.Rename("server", "tls")
+	cs.SetKeyPassword("tls", "newkeypass")
+	cs.SetPassword("newpassword")
+	if err := cs.Apply(ks, opts); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	if orig.Alias != "server" {
+		t.Error("Rename modified the original entry")
+	}
+	raw, err := ks.Pack(opts)
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	got, err := jks.Parse(raw, &jks.Options{
+		Password:     "newpassword",
+		KeyPasswords: map[string]string{"tls": "newkeypass"},
+	})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(got.Certs) != 1 || got.Certs[0].Alias != "new" {
+		t.Errorf("unexpected certificates %+v", got.Certs)
+	}
+	if len(got.Keypairs) != 1 || got.Keypairs[0].Alias != "tls" ||
+		got.Keypairs[0].PrivKeyErr != nil {
+		t.Errorf("unexpected keypairs %+v", got.Keypairs)
+	}
+}
+
+// TestChangeSetAtomic checks that a failing change set leaves the keystore and
+// options untouched.
+func TestChangeSetAtomic(t *testing.T) {
+	t.Run("bad change", testChangeSetAtomic(func(cs *jks.ChangeSet) {
+		cs.Delete("root")
+		cs.Rename("missing", "other")
+	}, nil))
+	t.Run("duplicate alias", testChangeSetAtomic(func(cs *jks.ChangeSet) {
+		cs.SetPassword("changed")
+		cs.Rename("root", "server")
+	}, nil))
+	t.Run("policy violation", testChangeSetAtomic(func(cs *jks.ChangeSet) {
+		cs.SetPassword("changed")
+		cs.Delete("root")
+	}, func(opts *jks.Options) {
+		opts.MinECBits = 384
+	}))
+}
+
+func testChangeSetAtomic(queue func(*jks.ChangeSet),
+	policy func(*jks.Options),
+) func(*testing.T) {
+	return func(t *testing.T) {
+		b := jkstest.New(t, "password").
+			CA("root").
+			ECKeypair("server", elliptic.P256())
+		ks, opts := b.Keystore(), b.Options()
+		if policy != nil {
+			policy(opts)
+		}
+
+		var cs jks.ChangeSet
+		queue(&cs)
+		if err := cs.Apply(ks, opts); err == nil {
+			t.Fatal("expected error")
+		}
+
+		if len(ks.Certs) != 1 || ks.Certs[0].Alias != "root" ||
+			len(ks.Keypairs) != 1 ||
+			ks.Keypairs[0].Alias != "server" {
+			t.Error("keystore was modified")
+		}
+		if opts.Password != "password" {
+			t.Error("options were modified")
+		}
+	}
+}
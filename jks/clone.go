@@ -0,0 +1,126 @@
+package jks
+
+import (
+	"crypto/x509"
+	"slices"
+)
+
+// Clone returns a deep copy of ks, sharing no entries, byte slices or keys with
+// it, so that a keystore may be forked (for instance into a variant for each
+// tenant) and either changed and packed without affecting the other. See the
+// Clone method of each entry type for what is copied.
+func (ks *Keystore) Clone() *Keystore {
+	c := &Keystore{
+		ETag:      ks.ETag,
+		Integrity: ks.Integrity,
+		Order:     slices.Clone(ks.Order),
+	}
+	for _, cert := range ks.Certs {
+		c.Certs = append(c.Certs, cert.Clone())
+	}
+	for _, kp := range ks.Keypairs {
+		c.Keypairs = append(c.Keypairs, kp.Clone())
+	}
+	for _, sk := range ks.SecretKeys {
+		c.SecretKeys = append(c.SecretKeys, sk.Clone())
+	}
+	for _, u := range ks.UnknownEntries {
+		if u != nil {
+			u = &UnknownEntry{Tag: u.Tag, Raw: slices.Clone(u.Raw)}
+		}
+		c.UnknownEntries = append(c.UnknownEntries, u)
+	}
+	return c
+}
+
+// Clone returns a deep copy of the certificate entry. The parsed certificate
+// is parsed afresh from a copy of its DER, since x509.Certificate holds slices
+// of it. CertErr is shared, errors being immutable. Clone returns nil if c is
+// nil.
+func (c *Cert) Clone() *Cert {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	clone.Raw = slices.Clone(c.Raw)
+	clone.Cert = cloneCertificate(c.Cert)
+	clone.LocalKeyID = slices.Clone(c.LocalKeyID)
+	return &clone
+}
+
+// Clone returns a deep copy of the keypair entry, including its certificate
+// chain and its private key in each of the forms held. A private key of one of
+// the types that MarshalPKCS8 handles is copied by marshalling and parsing it;
+// any other key, such as a crypto.Signer backed by an HSM, is shared, as is
+// EncryptKeyFunc, since neither can be copied. Clone returns nil if kp is nil.
+func (kp *Keypair) Clone() *Keypair {
+	if kp == nil {
+		return nil
+	}
+	clone := *kp
+	clone.EncryptedKey = slices.Clone(kp.EncryptedKey)
+	clone.RawKey = slices.Clone(kp.RawKey)
+	clone.PrivateKey = clonePrivateKey(kp.PrivateKey)
+	clone.KeyAlgorithm = slices.Clone(kp.KeyAlgorithm)
+	clone.LocalKeyID = slices.Clone(kp.LocalKeyID)
+	clone.CertChain = nil
+	for _, c := range kp.CertChain {
+		clone.CertChain = append(clone.CertChain, c.Clone())
+	}
+	return &clone
+}
+
+// Clone returns a deep copy of the chain entry, as Cert.Clone does. It returns
+// nil if c is nil.
+func (c *KeypairCert) Clone() *KeypairCert {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	clone.Raw = slices.Clone(c.Raw)
+	clone.Cert = cloneCertificate(c.Cert)
+	return &clone
+}
+
+// Clone returns a deep copy of the secret key entry. It returns nil if sk is
+// nil.
+func (sk *SecretKey) Clone() *SecretKey {
+	if sk == nil {
+		return nil
+	}
+	clone := *sk
+	clone.SealedKey = slices.Clone(sk.SealedKey)
+	clone.Key = slices.Clone(sk.Key)
+	return &clone
+}
+
+// cloneCertificate parses a copy of cert's DER. If that fails, which it should
+// not since cert was parsed from the same DER, cert itself is returned.
+func cloneCertificate(cert *x509.Certificate) *x509.Certificate {
+	if cert == nil {
+		return nil
+	}
+	clone, err := x509.ParseCertificate(slices.Clone(cert.Raw))
+	if err != nil {
+		return cert
+	}
+	return clone
+}
+
+// clonePrivateKey copies a private key by marshalling it with MarshalPKCS8 and
+// parsing the result. A key which cannot be marshalled is returned as it is.
+func clonePrivateKey(key interface{}) interface{} {
+	if key == nil {
+		return nil
+	}
+	raw, err := MarshalPKCS8(key)
+	if err != nil {
+		return key
+	}
+	defer clear(raw)
+	clone, err := ParsePKCS8(raw)
+	if err != nil {
+		return key
+	}
+	return clone
+}
@@ -0,0 +1,69 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestClone checks that a cloned keystore packs identically to the original,
+// and that changing every part of the clone leaves the original as it was.
+func TestClone(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := jkstest.New(t, "password").
+		CA("root").
+		ECKeypair("ec", elliptic.P256()).
+		Keypair("ed", edKey)
+	opts := *b.Options()
+	opts.Deterministic = true
+	opts.Compatibility = jks.Java17
+	ks := b.Keystore()
+	orig, err := ks.Pack(&opts)
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+
+	clone := ks.Clone()
+	raw, err := clone.Pack(&opts)
+	switch {
+	case err != nil:
+		t.Fatalf("Pack clone: %v", err)
+	case !bytes.Equal(raw, orig):
+		t.Errorf("clone packs differently")
+	}
+
+	clone.Certs[0].Alias = "changed"
+	clone.Certs[0].Cert.Raw[len(clone.Certs[0].Cert.Raw)-1] ^= 1
+	ec := clone.Keypairs[0]
+	ec.PrivateKey.(*ecdsa.PrivateKey).D.SetInt64(1)
+	ec.CertChain[0].Cert.Raw[0] ^= 1
+	ed := clone.Keypairs[1]
+	clear(ed.PrivateKey.(ed25519.PrivateKey))
+	ed.CertChain = nil
+	if raw, err = ks.Pack(&opts); err != nil {
+		t.Fatalf("Pack after changing clone: %v", err)
+	}
+	if !bytes.Equal(raw, orig) {
+		t.Errorf("changing the clone changed the original")
+	}
+
+	sk := &jks.SecretKey{Alias: "aes", Key: []byte{1, 2, 3}}
+	ks = &jks.Keystore{SecretKeys: []*jks.SecretKey{sk, nil}}
+	clone = ks.Clone()
+	clone.SecretKeys[0].Key[0] = 0
+	switch {
+	case sk.Key[0] != 1:
+		t.Errorf("changing the clone's secret key changed the original")
+	case len(clone.SecretKeys) != 2 || clone.SecretKeys[1] != nil:
+		t.Errorf("nil entry not kept: %v", clone.SecretKeys)
+	}
+}
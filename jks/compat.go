@@ -0,0 +1,83 @@
+package jks
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+)
+
+// Compatibility identifies the oldest generation of Java runtime that must be
+// able to load the files we write. Algorithm choices made while packing (key
+// types, curves, key protection, digests) are constrained so that the output is
+// guaranteed to be loadable by that generation and everything after it. Each
+// profile therefore accepts everything that the one before it does: Java 16
+// removed the "legacy" elliptic curves, including P-224, so keys on those
+// curves are refused by every profile.
+//
+// The zero value is Java8, which is the most conservative choice.
+type Compatibility int
+
+const (
+	// Java8 restricts output to algorithms understood by every Java 8
+	// update.
+	Java8 Compatibility = iota
+
+	// Java11 restricts output to algorithms understood by Java 11.
+	Java11
+
+	// Java17 restricts output to algorithms understood by Java 17,
+	// which adds Ed25519 keys.
+	Java17
+)
+
+var compatibilityNames = []string{
+	Java8:  "java8",
+	Java11: "java11",
+	Java17: "java17",
+}
+
+// String returns the name of the profile, as accepted by ParseCompatibility.
+func (c Compatibility) String() string {
+	if c >= 0 && int(c) < len(compatibilityNames) {
+		return compatibilityNames[c]
+	}
+	return fmt.Sprintf("Compatibility(%d)", int(c))
+}
+
+// ParseCompatibility returns the profile with the given name (e.g. "java11").
+func ParseCompatibility(name string) (Compatibility, error) {
+	for c, n := range compatibilityNames {
+		if strings.EqualFold(n, name) {
+			return Compatibility(c), nil
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown compatibility profile "+
+		"%q (expected one of %s)", name,
+		strings.Join(compatibilityNames, ", "))
+}
+
+// checkPrivateKey returns an error if the target Java runtime cannot load the
+// given type of private key.
+func (c Compatibility) checkPrivateKey(key interface{}) error {
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		// JDK 16 removed the legacy curves from SunEC, leaving only
+		// the NIST P-256, P-384 and P-521 curves (JDK-8251547); since
+		// every later runtime must load the output, no profile allows
+		// the others
+		name := key.Params().Name
+		if name == "P-224" {
+			return errorf(CodeIncompatible, "curve %s is not "+
+				"supported by %v", name, c)
+		}
+
+	case ed25519.PrivateKey:
+		// EdDSA arrived in JDK 15 (JEP 339)
+		if c < Java17 {
+			return errorf(CodeIncompatible, "Ed25519 keys are not "+
+				"supported by %v", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package jks_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestCompatibilityKeys checks which key types Pack accepts under each
+// profile: P-224 is refused by all of them, since Java 16 and later cannot
+// load it, and Ed25519 only by Java17, since older runtimes cannot.
+func TestCompatibilityKeys(t *testing.T) {
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []struct {
+		name string
+		key  crypto.Signer
+		min  jks.Compatibility // -1 if no profile accepts it
+	}{
+		{"P-224", jkstest.ECKey(t, elliptic.P224()), -1},
+		{"P-256", jkstest.ECKey(t, elliptic.P256()), jks.Java8},
+		{"P-384", jkstest.ECKey(t, elliptic.P384()), jks.Java8},
+		{"P-521", jkstest.ECKey(t, elliptic.P521()), jks.Java8},
+		{"Ed25519", ed, jks.Java17},
+	}
+	for _, k := range keys {
+		b := jkstest.New(t, "password").Keypair("key", k.key)
+		for _, c := range []jks.Compatibility{
+			jks.Java8, jks.Java11, jks.Java17,
+		} {
+			opts := *b.Options()
+			opts.Compatibility = c
+			_, err := b.Keystore().Pack(&opts)
+			switch exp := k.min >= 0 && c >= k.min; {
+			case exp && err != nil:
+				t.Errorf("%s, %v: unexpected error: %v", k.name,
+					c, err)
+			case !exp && jks.ErrorCode(err) != jks.CodeIncompatible:
+				t.Errorf("%s, %v: expected %s but got %v",
+					k.name, c, jks.CodeIncompatible, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package jks
+
+import (
+	"crypto/x509"
+	"net"
+	"sort"
+	"strings"
+)
+
+// EntryCoverage lists the names covered by the leaf certificate of a keypair
+// entry.
+type EntryCoverage struct {
+	// Alias of the keypair entry.
+	Alias string `json:"alias" yaml:"alias"`
+
+	// DNSNames and IPAddresses are the certificate's subject alternative
+	// names. DNS names may include wildcards such as "*.example.com".
+	DNSNames    []string `json:"dnsNames,omitempty" yaml:"dnsNames,omitempty"`
+	IPAddresses []string `json:"ipAddresses,omitempty" yaml:"ipAddresses,omitempty"`
+}
+
+// HostCoverage lists the keypair entries whose certificates cover a required
+// hostname or IP address.
+type HostCoverage struct {
+	// Host is the required hostname or IP address.
+	Host string `json:"host" yaml:"host"`
+
+	// Aliases lists the covering keypair entries, sorted.
+	Aliases []string `json:"aliases" yaml:"aliases"`
+}
+
+// Gap reports whether no keypair entry covers the host.
+func (hc *HostCoverage) Gap() bool {
+	return len(hc.Aliases) == 0
+}
+
+// Overlap reports whether more than one keypair entry covers the host, so that
+// which certificate a server presents depends on how it selects entries.
+func (hc *HostCoverage) Overlap() bool {
+	return len(hc.Aliases) > 1
+}
+
+// CoverageReport is returned by HostnameCoverage.
+type CoverageReport struct {
+	// Entries lists the names covered by each keypair entry, sorted by
+	// alias.
+	Entries []*EntryCoverage `json:"entries" yaml:"entries"`
+
+	// Hosts lists the entries covering each required host, in the order
+	// the hosts were given.
+	Hosts []*HostCoverage `json:"hosts" yaml:"hosts"`
+}
+
+// Gaps returns the required hosts which no keypair entry covers.
+func (r *CoverageReport) Gaps() []string {
+	var gaps []string
+	for _, hc := range r.Hosts {
+		if hc.Gap() {
+			gaps = append(gaps, hc.Host)
+		}
+	}
+	return gaps
+}
+
+// Overlaps returns the required hosts which more than one keypair entry
+// covers.
+func (r *CoverageReport) Overlaps() []*HostCoverage {
+	var overlaps []*HostCoverage
+	for _, hc := range r.Hosts {
+		if hc.Overlap() {
+			overlaps = append(overlaps, hc)
+		}
+	}
+	return overlaps
+}
+
+// HostnameCoverage reports the names covered by the leaf certificate of each
+// keypair entry, and which entries cover each of the required hostnames (or IP
+// addresses). This helps when consolidating many virtual hosts' certificates
+// into one keystore: hosts left uncovered are gaps, and hosts covered by more
+// than one entry are overlaps.
+//
+// Names are matched as TLS clients do (RFC 6125): case-insensitively, ignoring
+// any trailing dot, with a wildcard such as "*.example.com" covering exactly
+// one leftmost label, so that it covers "www.example.com" but neither
+// "example.com" nor "a.b.example.com". A required host which is itself a
+// wildcard is only covered by the same wildcard. As in crypto/x509, the
+// subject common name is ignored. Archived keypairs (see ArchiveAlias), and
+// those whose leaf certificate could not be parsed, are skipped.
+func (ks *Keystore) HostnameCoverage(required []string) *CoverageReport {
+	r := new(CoverageReport)
+	var leaves []*x509.Certificate
+	for _, kp := range ks.Keypairs {
+		if len(kp.CertChain) == 0 || kp.CertChain[0].Cert == nil {
+			continue
+		}
+		if _, _, archived := ParseArchivedAlias(kp.Alias); archived {
+			continue
+		}
+		cert := kp.CertChain[0].Cert
+		ec := &EntryCoverage{
+			Alias:    kp.Alias,
+			DNSNames: cert.DNSNames,
+		}
+		for _, ip := range cert.IPAddresses {
+			ec.IPAddresses = append(ec.IPAddresses, ip.String())
+		}
+		r.Entries = append(r.Entries, ec)
+		leaves = append(leaves, cert)
+	}
+
+	for _, host := range required {
+		hc := &HostCoverage{Host: host, Aliases: []string{}}
+		for i, cert := range leaves {
+			if coversHost(cert, host) {
+				hc.Aliases = append(hc.Aliases,
+					r.Entries[i].Alias)
+			}
+		}
+		sort.Strings(hc.Aliases)
+		r.Hosts = append(r.Hosts, hc)
+	}
+
+	sort.SliceStable(r.Entries, func(i, j int) bool {
+		return r.Entries[i].Alias < r.Entries[j].Alias
+	})
+	return r
+}
+
+// coversHost reports whether cert's subject alternative names cover host, as
+// described for HostnameCoverage.
+func coversHost(cert *x509.Certificate, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, certIP := range cert.IPAddresses {
+			if certIP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, name := range cert.DNSNames {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name == host {
+			return true
+		}
+		if !strings.HasPrefix(name, "*.") ||
+			strings.HasPrefix(host, "*.") {
+			continue
+		}
+		if dot := strings.IndexByte(host, '.'); dot > 0 &&
+			host[dot:] == name[1:] {
+			return true
+		}
+	}
+	return false
+}
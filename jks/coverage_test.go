@@ -0,0 +1,65 @@
+package jks_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+)
+
+// TestHostnameCoverage checks wildcard matching, and the gaps and overlaps
+// reported against a list of required hosts.
+func TestHostnameCoverage(t *testing.T) {
+	ks := new(jks.Keystore)
+	for alias, names := range map[string][]string{
+		"wild": {"*.example.com"},
+		"www":  {"www.example.com", "example.com"},
+		"api":  {"api.example.org", "192.0.2.1"},
+	} {
+		params := &jks.KeyGenParams{KeyType: jks.KeyEC}
+		for _, name := range names {
+			if ip := net.ParseIP(name); ip != nil {
+				params.IPAddresses = append(params.IPAddresses,
+					ip)
+			} else {
+				params.DNSNames = append(params.DNSNames, name)
+			}
+		}
+		if _, err := ks.GenerateKeypair(alias, params); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := ks.HostnameCoverage([]string{
+		"WWW.example.com.",
+		"mail.example.com",
+		"example.com",
+		"a.b.example.com",
+		"*.example.com",
+		"192.0.2.1",
+		"192.0.2.2",
+	})
+
+	if len(r.Entries) != 3 || r.Entries[0].Alias != "api" ||
+		len(r.Entries[0].IPAddresses) != 1 {
+		t.Errorf("unexpected entries %+v", r.Entries)
+	}
+
+	exp := []string{"wild,www", "wild", "www", "", "wild", "api", ""}
+	for i, hc := range r.Hosts {
+		if got := strings.Join(hc.Aliases, ","); got != exp[i] {
+			t.Errorf("%s: covered by %q ≠ expected %q", hc.Host,
+				got, exp[i])
+		}
+	}
+
+	gaps := strings.Join(r.Gaps(), ",")
+	if gaps != "a.b.example.com,192.0.2.2" {
+		t.Errorf("gaps %q ≠ expected %q", gaps,
+			"a.b.example.com,192.0.2.2")
+	}
+	if o := r.Overlaps(); len(o) != 1 || o[0].Host != "WWW.example.com." {
+		t.Errorf("unexpected overlaps %+v", o)
+	}
+}
@@ -0,0 +1,202 @@
+package jks
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// RevocationReason is a CRL reason code, as defined in RFC 5280 section
+// 5.3.1.
+type RevocationReason int
+
+// Revocation reasons. Value 7 is not used.
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonRemoveFromCRL        RevocationReason = 8
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+var revocationReasonNames = []string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+// String returns the RFC 5280 name of the reason, as accepted by
+// ParseRevocationReason.
+func (r RevocationReason) String() string {
+	if r >= 0 && int(r) < len(revocationReasonNames) &&
+		revocationReasonNames[r] != "" {
+		return revocationReasonNames[r]
+	}
+	return fmt.Sprintf("RevocationReason(%d)", int(r))
+}
+
+// ParseRevocationReason returns the reason with the given RFC 5280 name (e.g.
+// "keyCompromise"). Names are matched case-insensitively.
+func ParseRevocationReason(name string) (RevocationReason, error) {
+	for r, n := range revocationReasonNames {
+		if n != "" && strings.EqualFold(n, name) {
+			return RevocationReason(r), nil
+		}
+	}
+	var names []string
+	for _, n := range revocationReasonNames {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown revocation reason %q "+
+		"(expected one of %s)", name, strings.Join(names, ", "))
+}
+
+// Revocation records a single revoked certificate.
+type Revocation struct {
+	Serial    *big.Int         `json:"serial"`
+	RevokedAt time.Time        `json:"revokedAt"`
+	Reason    RevocationReason `json:"reason"`
+}
+
+// RevocationList tracks the certificates revoked by a CA held in a keystore.
+// Since a JKS file can only hold certificates and keys, the list is kept
+// alongside the keystore, and is saved and loaded as JSON with WriteJSON and
+// ReadRevocationList.
+type RevocationList struct {
+	// Number is the CRL number of the most recent CRL generated from
+	// the list. GenerateCRL increments it, since each CRL issued by a CA
+	// must have a greater number than the last.
+	Number *big.Int `json:"number"`
+
+	// Revoked lists the revoked certificates, in order of revocation.
+	Revoked []Revocation `json:"revoked"`
+}
+
+// ReadRevocationList reads a revocation list written by WriteJSON.
+func ReadRevocationList(r io.Reader) (*RevocationList, error) {
+	rl := new(RevocationList)
+	if err := json.NewDecoder(r).Decode(rl); err != nil {
+		return nil, errorf(CodeMalformed, "invalid revocation list: %v",
+			err)
+	}
+	for i, rev := range rl.Revoked {
+		if rev.Serial == nil {
+			return nil, errorf(CodeMalformed, "invalid revocation "+
+				"list: entry #%d has no serial number", i+1)
+		}
+	}
+	return rl, nil
+}
+
+// WriteJSON writes the revocation list as indented JSON.
+func (rl *RevocationList) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rl)
+}
+
+// Revoke adds the certificate with the given serial number to the list. It
+// returns an error if the certificate has already been revoked, unless it was
+// on hold (ReasonCertificateHold), in which case the reason is updated.
+// ReasonRemoveFromCRL takes a certificate off hold, removing it from the list.
+func (rl *RevocationList) Revoke(serial *big.Int, reason RevocationReason,
+	at time.Time,
+) error {
+	for i, rev := range rl.Revoked {
+		if rev.Serial.Cmp(serial) != 0 {
+			continue
+		}
+		switch {
+		case rev.Reason != ReasonCertificateHold:
+			return errorf(CodeAlreadyRevoked, "serial %v is "+
+				"already revoked", serial)
+		case reason == ReasonRemoveFromCRL:
+			rl.Revoked = append(rl.Revoked[:i], rl.Revoked[i+1:]...)
+		default:
+			rl.Revoked[i].Reason = reason
+			rl.Revoked[i].RevokedAt = at
+		}
+		return nil
+	}
+	if reason == ReasonRemoveFromCRL {
+		return errorf(CodeNotOnHold, "serial %v is not on hold", serial)
+	}
+	rl.Revoked = append(rl.Revoked, Revocation{
+		Serial:    serial,
+		RevokedAt: at,
+		Reason:    reason,
+	})
+	return nil
+}
+
+// IsRevoked reports whether the certificate with the given serial number is on
+// the list.
+func (rl *RevocationList) IsRevoked(serial *big.Int) bool {
+	for _, rev := range rl.Revoked {
+		if rev.Serial.Cmp(serial) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateCRL returns a DER-encoded X.509 CRL listing the certificates in rl,
+// signed by the CA keypair entry with alias caAlias (see SignCSR). The CRL is
+// valid from now until nextUpdate has elapsed, and rl.Number is incremented
+// and used as its CRL number; the caller should save rl afterwards.
+func (ks *Keystore) GenerateCRL(caAlias string, rl *RevocationList,
+	nextUpdate time.Duration,
+) ([]byte, error) {
+	now := time.Now()
+	ca, signer, err := ks.caKeypair(caAlias, x509.KeyUsageCRLSign, now)
+	if err != nil {
+		return nil, err
+	}
+	caCert := ca.CertChain[0].Cert
+
+	entries := make([]x509.RevocationListEntry, len(rl.Revoked))
+	for i, rev := range rl.Revoked {
+		entries[i] = x509.RevocationListEntry{
+			SerialNumber:   rev.Serial,
+			RevocationTime: rev.RevokedAt,
+			ReasonCode:     int(rev.Reason),
+		}
+	}
+
+	number := big.NewInt(1)
+	if rl.Number != nil {
+		number.Add(number, rl.Number)
+	}
+	der, err := x509.CreateRevocationList(rand.Reader,
+		&x509.RevocationList{
+			RevokedCertificateEntries: entries,
+			Number:                    number,
+			ThisUpdate:                now,
+			NextUpdate:                now.Add(nextUpdate),
+		}, caCert, signer)
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "failed to create CRL: "+
+			"%v", err)
+	}
+	rl.Number = number
+	return der, nil
+}
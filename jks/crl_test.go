@@ -0,0 +1,91 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+)
+
+// TestGenerateCRL checks that revocations are listed in CRLs signed by the CA,
+// that CRL numbers increase, and that the list survives a JSON round trip.
+func TestGenerateCRL(t *testing.T) {
+	ks := new(jks.Keystore)
+	kp, err := ks.GenerateKeypair("ca", &jks.KeyGenParams{
+		KeyType: jks.KeyEC,
+		IsCA:    true,
+	})
+	if err != nil {
+		t.Fatalf("GenerateKeypair: %v", err)
+	}
+	ca := kp.CertChain[0].Cert
+
+	rl := new(jks.RevocationList)
+	now := time.Now().Truncate(time.Second)
+	if err = rl.Revoke(big.NewInt(42), jks.ReasonKeyCompromise,
+		now); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if err = rl.Revoke(big.NewInt(42), jks.ReasonSuperseded,
+		now); err == nil {
+		t.Error("expected error revoking twice")
+	}
+	if err = rl.Revoke(big.NewInt(43), jks.ReasonCertificateHold,
+		now); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if err = rl.Revoke(big.NewInt(43), jks.ReasonRemoveFromCRL,
+		now); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = rl.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	if rl, err = jks.ReadRevocationList(&buf); err != nil {
+		t.Fatalf("ReadRevocationList: %v", err)
+	}
+
+	for number := int64(1); number <= 2; number++ {
+		der, err := ks.GenerateCRL("ca", rl, 24*time.Hour)
+		if err != nil {
+			t.Fatalf("GenerateCRL: %v", err)
+		}
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			t.Fatalf("ParseRevocationList: %v", err)
+		}
+		if err = crl.CheckSignatureFrom(ca); err != nil {
+			t.Errorf("CheckSignatureFrom: %v", err)
+		}
+		if crl.Number.Int64() != number {
+			t.Errorf("CRL number %v ≠ expected %d", crl.Number,
+				number)
+		}
+		entries := crl.RevokedCertificateEntries
+		if len(entries) != 1 || entries[0].SerialNumber.Int64() != 42 ||
+			entries[0].ReasonCode != int(jks.ReasonKeyCompromise) {
+			t.Errorf("unexpected CRL entries %+v", entries)
+		}
+	}
+}
+
+// TestParseRevocationReason checks reason names round trip.
+func TestParseRevocationReason(t *testing.T) {
+	for _, r := range []jks.RevocationReason{
+		jks.ReasonUnspecified, jks.ReasonKeyCompromise,
+		jks.ReasonRemoveFromCRL, jks.ReasonAACompromise,
+	} {
+		got, err := jks.ParseRevocationReason(r.String())
+		if err != nil || got != r {
+			t.Errorf("%v: got %v, %v", r, got, err)
+		}
+	}
+	if _, err := jks.ParseRevocationReason("7"); err == nil {
+		t.Error("expected error for unknown reason")
+	}
+}
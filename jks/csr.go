@@ -0,0 +1,51 @@
+package jks
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+)
+
+// CreateCertificateRequest returns a PEM "CERTIFICATE REQUEST" for the
+// keypair's public key, signed with its private key, as "keytool -certreq"
+// does, so that the certificate can be renewed without the key leaving the
+// keystore. The request is for subject, or if subject is nil for the subject of
+// the leaf certificate, CertChain[0]. extensions are requested as given: for
+// instance, a subject alternative name extension taken from the leaf's
+// Extensions. The private key must have been decrypted.
+func (kp *Keypair) CreateCertificateRequest(subject *pkix.Name,
+	extensions []pkix.Extension,
+) ([]byte, error) {
+	if kp.PrivKeyErr != nil {
+		return nil, errorf("", "key %q: %v", kp.Alias, kp.PrivKeyErr)
+	}
+	key, ok := kp.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errorf(CodeMissingData, "key %q has no private "+
+			"key to sign with", kp.Alias)
+	}
+
+	tmpl := &x509.CertificateRequest{ExtraExtensions: extensions}
+	switch {
+	case subject != nil:
+		tmpl.Subject = *subject
+	case len(kp.CertChain) != 0 && kp.CertChain[0] != nil &&
+		kp.CertChain[0].Cert != nil:
+		tmpl.RawSubject = kp.CertChain[0].Cert.RawSubject
+	default:
+		return nil, errorf(CodeMissingData, "key %q has no "+
+			"certificate to take the subject from", kp.Alias)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "key %q: failed to "+
+			"create certificate request: %v", kp.Alias, err)
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: der,
+	}), nil
+}
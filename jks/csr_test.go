@@ -0,0 +1,95 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestCreateCertificateRequest checks that a request is signed by the
+// keypair's key and carries the given or inherited subject and extensions.
+func TestCreateCertificateRequest(t *testing.T) {
+	ks := jkstest.New(t, "password").
+		ECKeypair("server", elliptic.P256()).
+		Keystore()
+	kp := ks.Keypairs[0]
+	leaf := kp.CertChain[0].Cert
+
+	parse := func(t *testing.T, subject *pkix.Name,
+		exts []pkix.Extension,
+	) *x509.CertificateRequest {
+		raw, err := kp.CreateCertificateRequest(subject, exts)
+		if err != nil {
+			t.Fatalf("CreateCertificateRequest: %v", err)
+		}
+		block, _ := pem.Decode(raw)
+		if block == nil || block.Type != "CERTIFICATE REQUEST" {
+			t.Fatalf("unexpected PEM output %q", raw)
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			t.Fatalf("ParseCertificateRequest: %v", err)
+		}
+		if err = csr.CheckSignature(); err != nil {
+			t.Errorf("CheckSignature: %v", err)
+		}
+		if !bytes.Equal(csr.RawSubjectPublicKeyInfo,
+			leaf.RawSubjectPublicKeyInfo) {
+			t.Errorf("request public key ≠ keypair's")
+		}
+		return csr
+	}
+
+	t.Run("leaf subject", func(t *testing.T) {
+		csr := parse(t, nil, nil)
+		if string(csr.RawSubject) != string(leaf.RawSubject) {
+			t.Errorf("subject %v ≠ expected %v", csr.Subject,
+				leaf.Subject)
+		}
+	})
+
+	t.Run("given subject", func(t *testing.T) {
+		san, err := asn1.Marshal([]asn1.RawValue{{
+			Class: asn1.ClassContextSpecific,
+			Tag:   2,
+			Bytes: []byte("renewed.example.org"),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr := parse(t, &pkix.Name{CommonName: "renewed"},
+			[]pkix.Extension{{
+				Id:    asn1.ObjectIdentifier{2, 5, 29, 17},
+				Value: san,
+			}})
+		switch {
+		case csr.Subject.CommonName != "renewed":
+			t.Errorf("common name %q ≠ expected renewed",
+				csr.Subject.CommonName)
+		case len(csr.DNSNames) != 1 ||
+			csr.DNSNames[0] != "renewed.example.org":
+			t.Errorf("DNS names %q ≠ expected "+
+				"[renewed.example.org]", csr.DNSNames)
+		}
+	})
+
+	t.Run("not decrypted", func(t *testing.T) {
+		kp := &jks.Keypair{
+			Alias:      "locked",
+			PrivKeyErr: jks.ErrBadKeyPassword,
+		}
+		_, err := kp.CreateCertificateRequest(nil, nil)
+		if !errors.Is(err, jks.ErrBadKeyPassword) {
+			t.Errorf("error %v ≠ expected %v", err,
+				jks.ErrBadKeyPassword)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package jks
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// CycloneDX document structure. Only the fields we populate are described;
+// see https://cyclonedx.org/docs/1.6/json/ for the full schema, in particular
+// the "cryptographic-asset" component type used for CBOMs.
+
+type cdxBOM struct {
+	BOMFormat    string           `json:"bomFormat"`
+	SpecVersion  string           `json:"specVersion"`
+	SerialNumber string           `json:"serialNumber"`
+	Version      int              `json:"version"`
+	Metadata     cdxMetadata      `json:"metadata"`
+	Components   []*cdxComponent  `json:"components"`
+	Dependencies []*cdxDependency `json:"dependencies,omitempty"`
+}
+
+type cdxMetadata struct {
+	Tools cdxTools `json:"tools"`
+}
+
+type cdxTools struct {
+	Components []*cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type             string               `json:"type"`
+	BOMRef           string               `json:"bom-ref,omitempty"`
+	Name             string               `json:"name"`
+	Hashes           []cdxHash            `json:"hashes,omitempty"`
+	CryptoProperties *cdxCryptoProperties `json:"cryptoProperties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxCryptoProperties struct {
+	AssetType           string                  `json:"assetType"`
+	AlgorithmProperties *cdxAlgorithmProperties `json:"algorithmProperties,omitempty"`
+	CertificateProps    *cdxCertificateProps    `json:"certificateProperties,omitempty"`
+	RelatedCryptoProps  *cdxRelatedCryptoProps  `json:"relatedCryptoMaterialProperties,omitempty"`
+}
+
+type cdxAlgorithmProperties struct {
+	Primitive              string `json:"primitive"`
+	ParameterSetIdentifier string `json:"parameterSetIdentifier,omitempty"`
+}
+
+type cdxCertificateProps struct {
+	SubjectName           string     `json:"subjectName,omitempty"`
+	IssuerName            string     `json:"issuerName,omitempty"`
+	NotValidBefore        *time.Time `json:"notValidBefore,omitempty"`
+	NotValidAfter         *time.Time `json:"notValidAfter,omitempty"`
+	SignatureAlgorithmRef string     `json:"signatureAlgorithmRef,omitempty"`
+	CertificateFormat     string     `json:"certificateFormat"`
+}
+
+type cdxRelatedCryptoProps struct {
+	Type         string `json:"type"`
+	AlgorithmRef string `json:"algorithmRef,omitempty"`
+	Size         int    `json:"size,omitempty"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// WriteCycloneDX writes the manifest as a CycloneDX 1.6 JSON document in
+// which each certificate, each private key and each algorithm they use is a
+// "cryptographic-asset" component. Private keys depend on the certificates of
+// their chain. The document is deterministic: its serial number is derived
+// from the manifest content rather than generated randomly.
+func (m *Manifest) WriteCycloneDX(w io.Writer) error {
+	bom := &cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.6",
+		Version:     1,
+		Metadata: cdxMetadata{
+			Tools: cdxTools{
+				Components: []*cdxComponent{{
+					Type: "application",
+					Name: "minijks",
+				}},
+			},
+		},
+		Components: []*cdxComponent{},
+	}
+	seen := make(map[string]bool)
+	add := func(c *cdxComponent) {
+		if !seen[c.BOMRef] {
+			seen[c.BOMRef] = true
+			bom.Components = append(bom.Components, c)
+		}
+	}
+
+	for _, e := range m.Entries {
+		var certRefs []string
+		for i, mc := range e.Certificates {
+			name := mc.Subject
+			if i == 0 && e.Type == ManifestTrustedCert {
+				name = e.Alias
+			}
+			cert, algs := cdxCertificate(name, mc)
+			for _, alg := range algs {
+				add(alg)
+			}
+			add(cert)
+			certRefs = append(certRefs, cert.BOMRef)
+		}
+
+		if e.Type != ManifestPrivateKey {
+			continue
+		}
+		key := &cdxComponent{
+			Type:   "cryptographic-asset",
+			BOMRef: "key:" + e.Alias,
+			Name:   e.Alias,
+			CryptoProperties: &cdxCryptoProperties{
+				AssetType: "related-crypto-material",
+				RelatedCryptoProps: &cdxRelatedCryptoProps{
+					Type: "private-key",
+				},
+			},
+		}
+		if len(e.Certificates) > 0 && e.Certificates[0].Error == "" {
+			leaf := e.Certificates[0]
+			alg := cdxKeyAlgorithm(leaf)
+			add(alg)
+			key.CryptoProperties.RelatedCryptoProps.AlgorithmRef =
+				alg.BOMRef
+			key.CryptoProperties.RelatedCryptoProps.Size =
+				leaf.KeySize
+		}
+		add(key)
+		if len(certRefs) > 0 {
+			bom.Dependencies = append(bom.Dependencies,
+				&cdxDependency{Ref: key.BOMRef, DependsOn: certRefs})
+		}
+	}
+
+	h := sha256.New()
+	for _, c := range bom.Components {
+		fmt.Fprintln(h, c.BOMRef)
+	}
+	bom.SerialNumber = cdxSerial(h.Sum(nil))
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bom)
+}
+
+// cdxCertificate returns the component for a certificate, along with
+// components for the algorithms it references.
+func cdxCertificate(name string, mc *ManifestCert,
+) (*cdxComponent, []*cdxComponent) {
+	fp := strings.ToLower(strings.ReplaceAll(mc.FingerprintSHA256, ":", ""))
+	props := &cdxCertificateProps{
+		SubjectName:       mc.Subject,
+		IssuerName:        mc.Issuer,
+		CertificateFormat: "X.509",
+	}
+	cert := &cdxComponent{
+		Type:   "cryptographic-asset",
+		BOMRef: "cert:" + fp,
+		Name:   name,
+		Hashes: []cdxHash{{Alg: "SHA-256", Content: fp}},
+		CryptoProperties: &cdxCryptoProperties{
+			AssetType:        "certificate",
+			CertificateProps: props,
+		},
+	}
+	if name == "" {
+		cert.Name = fp
+	}
+	if mc.Error != "" {
+		return cert, nil
+	}
+
+	notBefore, notAfter := mc.NotBefore, mc.NotAfter
+	props.NotValidBefore, props.NotValidAfter = &notBefore, &notAfter
+	sigAlg := &cdxComponent{
+		Type:   "cryptographic-asset",
+		BOMRef: "alg:" + mc.SignatureAlgorithm,
+		Name:   mc.SignatureAlgorithm,
+		CryptoProperties: &cdxCryptoProperties{
+			AssetType: "algorithm",
+			AlgorithmProperties: &cdxAlgorithmProperties{
+				Primitive: "signature",
+			},
+		},
+	}
+	props.SignatureAlgorithmRef = sigAlg.BOMRef
+	return cert, []*cdxComponent{sigAlg, cdxKeyAlgorithm(mc)}
+}
+
+// cdxKeyAlgorithm returns the algorithm component for a certificate's public
+// key.
+func cdxKeyAlgorithm(mc *ManifestCert) *cdxComponent {
+	name := mc.KeyAlgorithm
+	props := &cdxAlgorithmProperties{Primitive: "signature"}
+	if mc.KeySize != 0 {
+		name = fmt.Sprintf("%s-%d", mc.KeyAlgorithm, mc.KeySize)
+		props.ParameterSetIdentifier = fmt.Sprint(mc.KeySize)
+	}
+	if mc.KeyAlgorithm == "RSA" {
+		props.Primitive = "pke"
+	}
+	return &cdxComponent{
+		Type:   "cryptographic-asset",
+		BOMRef: "alg:" + name,
+		Name:   name,
+		CryptoProperties: &cdxCryptoProperties{
+			AssetType:           "algorithm",
+			AlgorithmProperties: props,
+		},
+	}
+}
+
+// cdxSerial formats the first 16 bytes of sum as a version 5 style UUID URN.
+func cdxSerial(sum []byte) string {
+	u := make([]byte, 16)
+	copy(u, sum)
+	u[6] = u[6]&0x0F | 0x50
+	u[8] = u[8]&0x3F | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x",
+		u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,53 @@
+package jks
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// randReader returns the source of the salts and IVs used to protect data with
+// password: crypto/rand, unless opts.Deterministic is set, in which case the
+// bytes are derived from password and data with HKDF-SHA256. Deriving them
+// from the data as well as the password means that two different keys never
+// share a salt, which matters for JavaKeyEncryption1 (an XOR stream cipher);
+// the cost is that protecting the same data with the same password always
+// gives the same output, which is what Deterministic asks for.
+func (opts *Options) randReader(password []byte, data ...[]byte) io.Reader {
+	if !opts.Deterministic {
+		return rand.Reader
+	}
+	h := sha256.New()
+	for _, d := range data {
+		binary.Write(h, binary.BigEndian, uint64(len(d)))
+		h.Write(d)
+	}
+	return hkdf.New(sha256.New, password, nil, h.Sum(nil))
+}
+
+// timestamp returns the time to write for an entry whose Timestamp is ts: ts
+// itself, unless it is zero, in which case opts.Timestamp is used if it is set,
+// the Unix epoch if Deterministic is set, and the current time if not.
+func (opts *Options) timestamp(ts time.Time) time.Time {
+	switch {
+	case !ts.IsZero():
+		return ts
+	case !opts.Timestamp.IsZero():
+		return opts.Timestamp
+	case opts.Deterministic:
+		return time.Unix(0, 0)
+	}
+	return opts.now()
+}
+
+// now returns the current time, from opts.Clock if it is set.
+func (opts *Options) now() time.Time {
+	if opts.Clock != nil {
+		return opts.Clock()
+	}
+	return time.Now()
+}
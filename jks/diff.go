@@ -0,0 +1,129 @@
+package jks
+
+import (
+	"bytes"
+	"slices"
+	"time"
+)
+
+// KeystoreDiff lists the differences between two keystores, as returned by
+// Diff. Each list is sorted by alias.
+type KeystoreDiff struct {
+	// Added holds the entries that are only in the new keystore.
+	Added []*ManifestEntry
+
+	// Removed holds the entries that are only in the old keystore.
+	Removed []*ManifestEntry
+
+	// Changed describes the aliases that are in both keystores but whose
+	// entries differ.
+	Changed []*EntryChange
+}
+
+// Empty returns true if the keystores have the same entries.
+func (d *KeystoreDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// EntryChange describes an entry that differs between two keystores.
+type EntryChange struct {
+	// Alias is the entry's alias in the new keystore. Aliases are matched
+	// as Java does, so it may differ in case from Old.Alias.
+	Alias string
+
+	// Old and New describe the entry in each keystore. Their Type fields
+	// differ if, for example, a trusted certificate was replaced by a
+	// keypair.
+	Old, New *ManifestEntry
+
+	// OldFingerprint and NewFingerprint are the SHA-256 fingerprints of
+	// the trusted certificate, or the leaf of the keypair's chain, in the
+	// format of ManifestCert.FingerprintSHA256. They are empty for secret
+	// keys.
+	OldFingerprint, NewFingerprint string
+
+	// CertsChanged is set if the trusted certificate or any certificate in
+	// the keypair's chain differs.
+	CertsChanged bool
+
+	// KeyChanged is set if a secret key's material differs. It can only be
+	// detected if both keys were unsealed.
+	KeyChanged bool
+
+	// TimestampDelta is the new entry's timestamp less the old one's. It
+	// is positive when the entry has been replaced by a newer one.
+	TimestampDelta time.Duration
+}
+
+// Diff compares two keystores, such as the same keystore before and after a
+// rotation, and returns the entries added to and removed from a to give b, and
+// those whose content or timestamp changed. Entries are matched by alias, as
+// Java matches them, and described as they would be in a Manifest. Errors
+// decrypting keys are not treated as changes, so a keystore may be compared
+// with a copy parsed under different passwords.
+func Diff(a, b *Keystore) *KeystoreDiff {
+	d := new(KeystoreDiff)
+	oldEntries, newEntries := a.Manifest().Entries, b.Manifest().Entries
+	byAlias := make(map[string]*ManifestEntry, len(newEntries))
+	for _, e := range newEntries {
+		byAlias[NormalizeAlias(e.Alias)] = e
+	}
+
+	matched := make(map[*ManifestEntry]bool, len(newEntries))
+	for _, old := range oldEntries {
+		e := byAlias[NormalizeAlias(old.Alias)]
+		if e == nil || matched[e] {
+			d.Removed = append(d.Removed, old)
+			continue
+		}
+		matched[e] = true
+		if c := diffEntry(a, b, old, e); c != nil {
+			d.Changed = append(d.Changed, c)
+		}
+	}
+	for _, e := range newEntries {
+		if !matched[e] {
+			d.Added = append(d.Added, e)
+		}
+	}
+	return d
+}
+
+// diffEntry compares the entries old, in a, and e, in b, returning nil if they
+// are the same.
+func diffEntry(a, b *Keystore, old, e *ManifestEntry) *EntryChange {
+	c := &EntryChange{
+		Alias:          e.Alias,
+		Old:            old,
+		New:            e,
+		OldFingerprint: leafFingerprint(old),
+		NewFingerprint: leafFingerprint(e),
+		TimestampDelta: e.Timestamp.Sub(old.Timestamp),
+	}
+	c.CertsChanged = !slices.EqualFunc(old.Certificates, e.Certificates,
+		func(x, y *ManifestCert) bool {
+			return x.FingerprintSHA256 == y.FingerprintSHA256
+		})
+	if old.Type == ManifestSecretKey && e.Type == ManifestSecretKey {
+		x := a.SecretKeys[a.secretKeyIndex(old.Alias)]
+		y := b.SecretKeys[b.secretKeyIndex(e.Alias)]
+		c.KeyChanged = x.Key != nil && y.Key != nil &&
+			(x.Algorithm != y.Algorithm ||
+				!bytes.Equal(x.Key, y.Key))
+	}
+
+	if old.Type == e.Type && !c.CertsChanged && !c.KeyChanged &&
+		c.TimestampDelta == 0 {
+		return nil
+	}
+	return c
+}
+
+// leafFingerprint returns the SHA-256 fingerprint of the entry's first
+// certificate, or "" if it has none.
+func leafFingerprint(e *ManifestEntry) string {
+	if len(e.Certificates) == 0 {
+		return ""
+	}
+	return e.Certificates[0].FingerprintSHA256
+}
@@ -0,0 +1,78 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestDiff checks that Diff reports added, removed and changed entries across
+// a simulated rotation, and nothing for identical keystores.
+func TestDiff(t *testing.T) {
+	root := jkstest.SelfSigned(t, jkstest.ECKey(t, elliptic.P256()), "root")
+	old := jkstest.New(t, "password").
+		Cert("root", root).
+		ECKeypair("server", elliptic.P256()).
+		CA("retired").
+		Keystore()
+	rotated := jkstest.New(t, "password").
+		Cert("ROOT", root).
+		ECKeypair("server", elliptic.P256()).
+		CA("added").
+		Keystore()
+	rotated.Certs[0].Timestamp = old.Certs[0].Timestamp
+	rotated.Keypairs[0].Timestamp = old.Keypairs[0].Timestamp.
+		Add(time.Hour)
+
+	d := jks.Diff(old, rotated)
+	switch {
+	case len(d.Added) != 1 || d.Added[0].Alias != "added":
+		t.Errorf("unexpected added entries %+v", d.Added)
+	case len(d.Removed) != 1 || d.Removed[0].Alias != "retired":
+		t.Errorf("unexpected removed entries %+v", d.Removed)
+	case len(d.Changed) != 1:
+		t.Fatalf("unexpected changed entries %+v", d.Changed)
+	}
+	c := d.Changed[0]
+	switch {
+	case c.Alias != "server":
+		t.Errorf("changed alias %q ≠ expected server", c.Alias)
+	case !c.CertsChanged || c.KeyChanged:
+		t.Errorf("CertsChanged %t, KeyChanged %t ≠ expected true, "+
+			"false", c.CertsChanged, c.KeyChanged)
+	case c.OldFingerprint == c.NewFingerprint || c.NewFingerprint !=
+		c.New.Certificates[0].FingerprintSHA256:
+		t.Errorf("unexpected fingerprints %s → %s", c.OldFingerprint,
+			c.NewFingerprint)
+	case c.TimestampDelta != time.Hour:
+		t.Errorf("timestamp delta %v ≠ expected 1h", c.TimestampDelta)
+	}
+
+	if d := jks.Diff(old, old); !d.Empty() {
+		t.Errorf("keystore differs from itself: %+v", d)
+	}
+}
+
+// TestDiffSecretKeys checks that changes to secret key material are reported.
+func TestDiffSecretKeys(t *testing.T) {
+	ts := time.Now()
+	a := &jks.Keystore{SecretKeys: []*jks.SecretKey{
+		{Alias: "k", Timestamp: ts, Algorithm: "AES", Key: []byte{1}},
+	}}
+	b := &jks.Keystore{SecretKeys: []*jks.SecretKey{
+		{Alias: "k", Timestamp: ts, Algorithm: "AES", Key: []byte{2}},
+	}}
+	d := jks.Diff(a, b)
+	if len(d.Changed) != 1 || !d.Changed[0].KeyChanged {
+		t.Errorf("key change not reported: %+v", d.Changed)
+	}
+
+	// a key that could not be unsealed is not a change
+	b.SecretKeys[0].Key = nil
+	if d := jks.Diff(a, b); !d.Empty() {
+		t.Errorf("unexpected changes %+v", d.Changed)
+	}
+}
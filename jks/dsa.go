@@ -0,0 +1,139 @@
+package jks
+
+import (
+	"crypto/dsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// DSA keys are deprecated, and Go's x509 package no longer parses or marshals
+// them as private keys, but keystores created by older versions of keytool
+// (whose default key algorithm was DSA until Java 7) still hold them. We
+// handle their PKCS#8 encoding here, so that such keystores can be read,
+// rotated and written back. The encoding is that of RFC 3279 § 2.3.2: the
+// domain parameters are held in the AlgorithmIdentifier, and the private key
+// is the INTEGER x.
+
+// maxDSABits is the largest DSA prime we accept. FIPS 186-4 goes no larger
+// than 3072 bits; the limit keeps a hostile file from making us compute the
+// public key with an enormous modulus.
+const maxDSABits = 4096
+
+// ParsePKCS8 parses an (unencrypted) PKCS#8 PrivateKeyInfo structure, such as
+// DecryptPKCS8 returns. It accepts the keys x509.ParsePKCS8PrivateKey does,
+// and also DSA keys, which are returned as *dsa.PrivateKey. Errors describing
+// a malformed structure are of type *ASN1Error; a key of an unsupported
+// algorithm gives an error with CodeUnsupportedKeyAlg.
+func ParsePKCS8(raw []byte) (interface{}, error) {
+	pki, err := ParsePrivateKeyInfo(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(pki, raw)
+	if err != nil {
+		return nil, errorf(CodeUnsupportedKeyAlg, "private key "+
+			"algorithm %v: %v", pki.Algo.Algorithm, err)
+	}
+	return key, nil
+}
+
+// parsePrivateKey parses the key held in pki, which was parsed from raw.
+func parsePrivateKey(pki *PrivateKeyInfo, raw []byte) (interface{}, error) {
+	if pki.Algo.Algorithm.Equal(oidPublicKeyDSA) {
+		return parseDSAPrivateKey(pki)
+	}
+	return x509.ParsePKCS8PrivateKey(raw)
+}
+
+// parseDSAPrivateKey parses a DSA key from its PrivateKeyInfo, and computes its
+// public key.
+func parseDSAPrivateKey(pki *PrivateKeyInfo) (*dsa.PrivateKey, error) {
+	var (
+		key = &dsa.PrivateKey{
+			PublicKey: dsa.PublicKey{
+				Parameters: dsa.Parameters{
+					P: new(big.Int),
+					Q: new(big.Int),
+					G: new(big.Int),
+				},
+				Y: new(big.Int),
+			},
+			X: new(big.Int),
+		}
+		params = cryptobyte.String(pki.Algo.Parameters.FullBytes)
+		priv   = cryptobyte.String(pki.PrivateKey)
+		seq    cryptobyte.String
+	)
+	if !params.ReadASN1(&seq, casn1.SEQUENCE) || !params.Empty() ||
+		!seq.ReadASN1Integer(key.P) || !seq.ReadASN1Integer(key.Q) ||
+		!seq.ReadASN1Integer(key.G) || !seq.Empty() {
+		return nil, newError(CodeMalformed, "malformed DSA parameters")
+	}
+	if !priv.ReadASN1Integer(key.X) || !priv.Empty() {
+		return nil, newError(CodeMalformed, "malformed DSA private key")
+	}
+
+	one := big.NewInt(1)
+	switch {
+	case key.P.BitLen() > maxDSABits:
+		return nil, errorf(CodeUnsupported, "DSA prime of %d bits "+
+			"(at most %d are supported)", key.P.BitLen(),
+			maxDSABits)
+	case key.P.Cmp(one) <= 0 || key.Q.Sign() <= 0 ||
+		key.G.Cmp(one) <= 0 || key.G.Cmp(key.P) >= 0:
+		return nil, newError(CodeMalformed, "invalid DSA parameters")
+	case key.X.Sign() <= 0 || key.X.Cmp(key.Q) >= 0:
+		return nil, newError(CodeMalformed, "DSA private key out of "+
+			"range")
+	}
+	key.Y.Exp(key.G, key.X, key.P)
+	return key, nil
+}
+
+// marshalDSAPrivateKey returns the DER-encoded PrivateKeyInfo for a DSA key.
+func marshalDSAPrivateKey(key *dsa.PrivateKey) ([]byte, error) {
+	if key.P == nil || key.Q == nil || key.G == nil || key.X == nil {
+		return nil, newError(CodeInvalidArgument, "incomplete DSA "+
+			"private key")
+	}
+
+	var params, priv cryptobyte.Builder
+	params.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1BigInt(key.P)
+		b.AddASN1BigInt(key.Q)
+		b.AddASN1BigInt(key.G)
+	})
+	rawParams, err := params.Bytes()
+	if err != nil {
+		return nil, errorf(CodeInvalidArgument, "marshal DSA "+
+			"parameters: %v", err)
+	}
+	priv.AddASN1BigInt(key.X)
+	rawPriv, err := priv.Bytes()
+	if err != nil {
+		return nil, errorf(CodeInvalidArgument, "marshal DSA "+
+			"private key: %v", err)
+	}
+	defer clear(rawPriv)
+
+	pki := &PrivateKeyInfo{
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPublicKeyDSA,
+			Parameters: asn1.RawValue{FullBytes: rawParams},
+		},
+		PrivateKey: rawPriv,
+	}
+	return pki.Marshal()
+}
+
+// dsaKeyMatches reports whether pub is the public key for the DSA key priv.
+func dsaKeyMatches(priv *dsa.PrivateKey, pub interface{}) bool {
+	k, ok := pub.(*dsa.PublicKey)
+	return ok && k.Y.Cmp(priv.Y) == 0 && k.P.Cmp(priv.P) == 0 &&
+		k.Q.Cmp(priv.Q) == 0 && k.G.Cmp(priv.G) == 0
+}
@@ -0,0 +1,209 @@
+package jks
+
+import (
+	"iter"
+	"slices"
+	"time"
+)
+
+// Entry is a keystore entry: a trusted certificate (*Cert), a keypair
+// (*Keypair) or a secret key (*SecretKey). Callers should allow for other
+// types of entry being added in future.
+type Entry interface {
+	// EntryAlias returns the entry's alias.
+	EntryAlias() string
+
+	// EntryTimestamp returns the entry's creation time. For an entry read
+	// from a file it has the millisecond precision the file records.
+	EntryTimestamp() time.Time
+}
+
+// EntryAlias returns c.Alias.
+func (c *Cert) EntryAlias() string {
+	return c.Alias
+}
+
+// EntryTimestamp returns c.Timestamp.
+func (c *Cert) EntryTimestamp() time.Time {
+	return c.Timestamp
+}
+
+// EntryAlias returns kp.Alias.
+func (kp *Keypair) EntryAlias() string {
+	return kp.Alias
+}
+
+// EntryTimestamp returns kp.Timestamp.
+func (kp *Keypair) EntryTimestamp() time.Time {
+	return kp.Timestamp
+}
+
+// EntryAlias returns sk.Alias.
+func (sk *SecretKey) EntryAlias() string {
+	return sk.Alias
+}
+
+// EntryTimestamp returns sk.Timestamp.
+func (sk *SecretKey) EntryTimestamp() time.Time {
+	return sk.Timestamp
+}
+
+// Entries returns an iterator over every entry in ks, so that the keystore may
+// be walked without handling each slice separately. The entries come in the
+// order of the file they were parsed from (see Keystore.Order), which is also
+// the order in which Pack writes them; entries added since come after, with
+// trusted certificates before keypairs, and secret keys last. nil entries are
+// skipped.
+func (ks *Keystore) Entries() iter.Seq[Entry] {
+	return slices.Values(ks.entries())
+}
+
+// GetEntry returns the entry with the given alias, matched as for Lookup, or
+// an error with CodeNoSuchAlias if there is none.
+func (ks *Keystore) GetEntry(alias string) (Entry, error) {
+	certIdx, kpIdx, skIdx := ks.findEntry(alias)
+	switch {
+	case certIdx >= 0:
+		return ks.Certs[certIdx], nil
+	case kpIdx >= 0:
+		return ks.Keypairs[kpIdx], nil
+	case skIdx >= 0:
+		return ks.SecretKeys[skIdx], nil
+	}
+	return nil, errorf(CodeNoSuchAlias, "no entry with alias %q", alias)
+}
+
+// SetCert stores a trusted certificate entry under cert.Alias. A trusted
+// certificate entry with the same alias (matched as for Lookup) is replaced in
+// place; otherwise cert is appended to ks.Certs. As with Java's
+// KeyStore.setCertificateEntry, a keypair or secret key entry is not replaced:
+// that is an error with CodeDuplicateAlias.
+func (ks *Keystore) SetCert(cert *Cert) error {
+	if cert.Alias == "" {
+		return newError(CodeInvalidArgument, "empty alias")
+	}
+	certIdx, kpIdx := ks.findAlias(cert.Alias)
+	switch {
+	case kpIdx >= 0:
+		return errorf(CodeDuplicateAlias, "alias %q is in use by a "+
+			"keypair", cert.Alias)
+	case ks.secretKeyIndex(cert.Alias) >= 0:
+		return errorf(CodeDuplicateAlias, "alias %q is in use by a "+
+			"secret key", cert.Alias)
+	case certIdx >= 0:
+		ks.Certs[certIdx] = cert
+	default:
+		ks.Certs = append(ks.Certs, cert)
+	}
+	return nil
+}
+
+// SetKeypair stores a keypair entry under kp.Alias. A keypair entry with the
+// same alias (matched as for Lookup) is replaced in place; otherwise kp is
+// appended to ks.Keypairs. As with Java's KeyStore.setKeyEntry, a trusted
+// certificate or secret key entry with the same alias is removed.
+func (ks *Keystore) SetKeypair(kp *Keypair) error {
+	if kp.Alias == "" {
+		return newError(CodeInvalidArgument, "empty alias")
+	}
+	certIdx, kpIdx := ks.findAlias(kp.Alias)
+	if certIdx >= 0 {
+		ks.Certs = append(ks.Certs[:certIdx], ks.Certs[certIdx+1:]...)
+	}
+	if skIdx := ks.secretKeyIndex(kp.Alias); skIdx >= 0 {
+		ks.SecretKeys = append(ks.SecretKeys[:skIdx],
+			ks.SecretKeys[skIdx+1:]...)
+	}
+	if kpIdx >= 0 {
+		ks.Keypairs[kpIdx] = kp
+	} else {
+		ks.Keypairs = append(ks.Keypairs, kp)
+	}
+	return nil
+}
+
+// DeleteEntry removes the entry with the given alias, matched as for Lookup,
+// keeping the order of the others. The alias is also removed from ks.Order. It
+// returns an error with CodeNoSuchAlias if there is no such entry.
+func (ks *Keystore) DeleteEntry(alias string) error {
+	certIdx, kpIdx, skIdx := ks.findEntry(alias)
+	switch {
+	case certIdx >= 0:
+		ks.Certs = append(ks.Certs[:certIdx], ks.Certs[certIdx+1:]...)
+	case kpIdx >= 0:
+		ks.Keypairs = append(ks.Keypairs[:kpIdx],
+			ks.Keypairs[kpIdx+1:]...)
+	case skIdx >= 0:
+		ks.SecretKeys = append(ks.SecretKeys[:skIdx],
+			ks.SecretKeys[skIdx+1:]...)
+	default:
+		return errorf(CodeNoSuchAlias, "no entry with alias %q", alias)
+	}
+	ks.removeOrder(alias)
+	return nil
+}
+
+// RenameAlias gives the entry with alias from (matched as for Lookup) the
+// alias to, keeping its position in the slice and in ks.Order. It is an error
+// with CodeNoSuchAlias if there is no such entry, and with CodeDuplicateAlias
+// if to is already used by another entry; changing only the case of an
+// entry's alias is allowed. As with Merge, the entry is copied before being
+// renamed, so that a Keystore sharing it is not affected.
+//
+// A keypair's per-key password is looked up by alias, so the caller must move
+// any entry in Options.KeyPasswords to the new alias; ChangeSet.Rename does
+// this.
+func (ks *Keystore) RenameAlias(from, to string) error {
+	if to == "" {
+		return newError(CodeInvalidArgument, "empty alias")
+	}
+	certIdx, kpIdx, skIdx := ks.findEntry(from)
+	if certIdx < 0 && kpIdx < 0 && skIdx < 0 {
+		return errorf(CodeNoSuchAlias, "no entry with alias %q", from)
+	}
+
+	// to may only match the entry being renamed
+	toCertIdx, toKpIdx := ks.findAlias(to)
+	toSkIdx := ks.secretKeyIndex(to)
+	if (toCertIdx >= 0 && toCertIdx != certIdx) ||
+		(toKpIdx >= 0 && toKpIdx != kpIdx) ||
+		(toSkIdx >= 0 && toSkIdx != skIdx) {
+		return errorf(CodeDuplicateAlias, "duplicate alias %q", to)
+	}
+
+	switch {
+	case certIdx >= 0:
+		c := *ks.Certs[certIdx]
+		ks.renameOrder(c.Alias, to)
+		c.Alias = to
+		ks.Certs[certIdx] = &c
+	case kpIdx >= 0:
+		k := *ks.Keypairs[kpIdx]
+		ks.renameOrder(k.Alias, to)
+		k.Alias = to
+		ks.Keypairs[kpIdx] = &k
+	default:
+		sk := *ks.SecretKeys[skIdx]
+		ks.renameOrder(sk.Alias, to)
+		sk.Alias = to
+		ks.SecretKeys[skIdx] = &sk
+	}
+	return nil
+}
+
+// findEntry returns the index of the entry with the given alias in ks.Certs,
+// ks.Keypairs or ks.SecretKeys, in that order of preference, matching aliases
+// as Lookup does. The index for each slice which does not hold the entry is
+// -1.
+func (ks *Keystore) findEntry(alias string) (certIdx, kpIdx, skIdx int) {
+	certIdx, kpIdx, skIdx = -1, -1, -1
+	switch c, k := ks.findAlias(alias); {
+	case c >= 0:
+		certIdx = c
+	case k >= 0:
+		kpIdx = k
+	default:
+		skIdx = ks.secretKeyIndex(alias)
+	}
+	return
+}
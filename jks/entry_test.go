@@ -0,0 +1,224 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// entryAliases lists the aliases of ks's entries, certificates first, for
+// comparing against an expected order.
+func entryAliases(ks *jks.Keystore) string {
+	var aliases []string
+	for _, cert := range ks.Certs {
+		aliases = append(aliases, cert.Alias)
+	}
+	for _, kp := range ks.Keypairs {
+		aliases = append(aliases, kp.Alias)
+	}
+	return strings.Join(aliases, ",")
+}
+
+// TestEntryMutation exercises SetCert, SetKeypair, GetEntry, DeleteEntry and
+// RenameAlias, checking that they keep aliases unique and entries in order.
+func TestEntryMutation(t *testing.T) {
+	b := jkstest.New(t, "").CA("ca1").CA("ca2").CA("ca3").
+		ECKeypair("key1", elliptic.P256()).
+		ECKeypair("key2", elliptic.P256())
+	ks := b.Keystore()
+	orig := ks.Keypairs[1]
+	expect := func(step, exp string) {
+		t.Helper()
+		if got := entryAliases(ks); got != exp {
+			t.Errorf("%s: entries %s ≠ expected %s", step, got, exp)
+		}
+	}
+	expectCode := func(step string, err error, exp string) {
+		t.Helper()
+		if code := jks.ErrorCode(err); code != exp {
+			t.Errorf("%s: error code %s ≠ expected %s (%v)", step,
+				code, exp, err)
+		}
+	}
+
+	// replacing keeps the position; the alias match ignores case
+	if err := ks.SetCert(&jks.Cert{Alias: "CA2"}); err != nil {
+		t.Fatalf("SetCert: %v", err)
+	}
+	expect("SetCert replace", "ca1,CA2,ca3,key1,key2")
+	if err := ks.SetCert(&jks.Cert{Alias: "ca4"}); err != nil {
+		t.Fatalf("SetCert: %v", err)
+	}
+	expect("SetCert add", "ca1,CA2,ca3,ca4,key1,key2")
+	expectCode("SetCert over keypair",
+		ks.SetCert(&jks.Cert{Alias: "key1"}), jks.CodeDuplicateAlias)
+
+	// a keypair replaces a certificate
+	if err := ks.SetKeypair(&jks.Keypair{Alias: "ca3"}); err != nil {
+		t.Fatalf("SetKeypair: %v", err)
+	}
+	expect("SetKeypair over cert", "ca1,CA2,ca4,key1,key2,ca3")
+	if err := ks.SetKeypair(&jks.Keypair{Alias: "key1"}); err != nil {
+		t.Fatalf("SetKeypair: %v", err)
+	}
+	expect("SetKeypair replace", "ca1,CA2,ca4,key1,key2,ca3")
+
+	if err := ks.DeleteEntry("ca1"); err != nil {
+		t.Fatalf("DeleteEntry: %v", err)
+	}
+	expect("DeleteEntry", "CA2,ca4,key1,key2,ca3")
+	expectCode("DeleteEntry missing", ks.DeleteEntry("ca1"),
+		jks.CodeNoSuchAlias)
+
+	if err := ks.RenameAlias("key2", "server"); err != nil {
+		t.Fatalf("RenameAlias: %v", err)
+	}
+	expect("RenameAlias", "CA2,ca4,key1,server,ca3")
+	if err := ks.RenameAlias("server", "Server"); err != nil {
+		t.Fatalf("RenameAlias case only: %v", err)
+	}
+	expect("RenameAlias case only", "CA2,ca4,key1,Server,ca3")
+	expectCode("RenameAlias clash", ks.RenameAlias("ca4", "KEY1"),
+		jks.CodeDuplicateAlias)
+	expectCode("RenameAlias missing", ks.RenameAlias("nope", "x"),
+		jks.CodeNoSuchAlias)
+
+	if orig.Alias != "key2" {
+		t.Errorf("original entry modified")
+	}
+	entry, err := ks.GetEntry("server")
+	switch {
+	case err != nil:
+		t.Errorf("GetEntry: %v", err)
+	case entry.EntryAlias() != "Server":
+		t.Errorf("GetEntry: alias %q ≠ expected \"Server\"",
+			entry.EntryAlias())
+	}
+	if _, ok := entry.(*jks.Keypair); !ok {
+		t.Errorf("GetEntry: got %T, expected *jks.Keypair", entry)
+	}
+	_, err = ks.GetEntry("ca1")
+	expectCode("GetEntry missing", err, jks.CodeNoSuchAlias)
+}
+
+// TestEntryMutationSecretKeys checks that SetCert, SetKeypair, GetEntry,
+// DeleteEntry and RenameAlias treat secret keys as entries too.
+func TestEntryMutationSecretKeys(t *testing.T) {
+	ks := &jks.Keystore{SecretKeys: []*jks.SecretKey{
+		{Alias: "aes"}, {Alias: "hmac"}, {Alias: "des"},
+	}}
+	orig := ks.SecretKeys[0]
+	expectCode := func(step string, err error, exp string) {
+		t.Helper()
+		if code := jks.ErrorCode(err); code != exp {
+			t.Errorf("%s: error code %s ≠ expected %s (%v)", step,
+				code, exp, err)
+		}
+	}
+
+	entry, err := ks.GetEntry("AES")
+	if err != nil {
+		t.Fatalf("GetEntry: %v", err)
+	}
+	if entry != jks.Entry(orig) {
+		t.Errorf("GetEntry: got %T %q, expected secret key \"aes\"",
+			entry, entry.EntryAlias())
+	}
+
+	expectCode("SetCert over secret key",
+		ks.SetCert(&jks.Cert{Alias: "Aes"}), jks.CodeDuplicateAlias)
+	if len(ks.Certs) != 0 {
+		t.Error("SetCert added a duplicate alias")
+	}
+
+	expectCode("RenameAlias clash", ks.RenameAlias("aes", "HMAC"),
+		jks.CodeDuplicateAlias)
+	if err := ks.RenameAlias("aes", "key"); err != nil {
+		t.Fatalf("RenameAlias: %v", err)
+	}
+	if ks.SecretKeys[0].Alias != "key" || orig.Alias != "aes" {
+		t.Errorf("RenameAlias: alias %q, original %q",
+			ks.SecretKeys[0].Alias, orig.Alias)
+	}
+
+	if err := ks.DeleteEntry("HMAC"); err != nil {
+		t.Fatalf("DeleteEntry: %v", err)
+	}
+	if err := ks.SetKeypair(&jks.Keypair{Alias: "DES"}); err != nil {
+		t.Fatalf("SetKeypair: %v", err)
+	}
+	if len(ks.SecretKeys) != 1 || ks.SecretKeys[0].Alias != "key" ||
+		len(ks.Keypairs) != 1 {
+		t.Errorf("secret keys %d, keypairs %d after delete and "+
+			"replace", len(ks.SecretKeys), len(ks.Keypairs))
+	}
+	expectCode("DeleteEntry missing", ks.DeleteEntry("hmac"),
+		jks.CodeNoSuchAlias)
+}
+
+// TestDeleteEntryOrder checks that an entry deleted and then added again
+// under the same alias does not return to its old place in the file.
+func TestDeleteEntryOrder(t *testing.T) {
+	ks := jkstest.New(t, "").CA("ca1").CA("ca2").
+		ECKeypair("key1", elliptic.P256()).Keystore()
+	ks.Order = []string{"ca1", "key1", "ca2"}
+	order := ks.Order
+
+	if err := ks.DeleteEntry("CA1"); err != nil {
+		t.Fatalf("DeleteEntry: %v", err)
+	}
+	if err := ks.SetCert(&jks.Cert{Alias: "ca1"}); err != nil {
+		t.Fatalf("SetCert: %v", err)
+	}
+	var got []string
+	for entry := range ks.Entries() {
+		got = append(got, entry.EntryAlias())
+	}
+	if s, exp := strings.Join(got, ","), "key1,ca2,ca1"; s != exp {
+		t.Errorf("entries %s ≠ expected %s", s, exp)
+	}
+	if len(order) != 3 || order[0] != "ca1" {
+		t.Errorf("shared order modified: %q", order)
+	}
+}
+
+// TestEntries checks that Entries yields every entry in file order, with
+// entries not in Keystore.Order last, and that iteration can stop early.
+func TestEntries(t *testing.T) {
+	ks := jkstest.New(t, "").CA("ca1").CA("ca2").
+		ECKeypair("key1", elliptic.P256()).Keystore()
+	ks.SecretKeys = append(ks.SecretKeys, &jks.SecretKey{Alias: "aes"})
+	ks.Order = []string{"ca2", "key1", "ca1"}
+	ks.Certs = append(ks.Certs, nil)
+
+	var got []string
+	for entry := range ks.Entries() {
+		var kind string
+		switch entry.(type) {
+		case *jks.Cert:
+			kind = "cert"
+		case *jks.Keypair:
+			kind = "keypair"
+		case *jks.SecretKey:
+			kind = "secret"
+		}
+		got = append(got, kind+":"+entry.EntryAlias())
+	}
+	exp := "cert:ca2,keypair:key1,cert:ca1,secret:aes"
+	if s := strings.Join(got, ","); s != exp {
+		t.Errorf("entries %s ≠ expected %s", s, exp)
+	}
+
+	n := 0
+	for range ks.Entries() {
+		if n++; n == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Errorf("iteration did not stop")
+	}
+}
@@ -0,0 +1,199 @@
+package jks
+
+import (
+	"crypto/sha1"
+	"io"
+	"time"
+)
+
+// The functions in this file read and write a JKS or JCEKS file one record at
+// a time, for tools that process a keystore without building a whole
+// Keystore: splitting a large truststore into several, say, or rewriting one
+// entry and copying the rest through. A file is laid out as a FileHeader, then
+// the number of entries it gives, then the 20 byte digest (see NewDigest) of
+// everything before it. Positions in errors are counted from where r was when
+// the function was called.
+
+// FileHeader holds the fields which start a JKS or JCEKS file.
+type FileHeader struct {
+	// Magic is MagicNumber or JCEKSMagicNumber.
+	Magic uint32
+
+	// Version is the file format version, 1 or 2.
+	Version uint32
+
+	// Entries is the number of entries which follow.
+	Entries uint32
+}
+
+// ReadHeader reads a file header from r, checking its magic number and
+// version.
+func ReadHeader(r io.Reader) (FileHeader, error) {
+	h, _, err := readHeader(&stream{r: r, size: -1})
+	return h, err
+}
+
+// readHeader reads and checks a file header, returning also the position of
+// its entry count.
+func readHeader(buf *stream) (h FileHeader, pos int64, err error) {
+	if h.Magic, _, err = readUint32(buf, "magic header"); err != nil {
+		return h, 0, err
+	}
+	if h.Magic != MagicNumber && h.Magic != JCEKSMagicNumber {
+		return h, 0, errorf(CodeBadMagic, "invalid magic; expected "+
+			"0x%08X or 0x%08X but got 0x%08X", MagicNumber,
+			JCEKSMagicNumber, h.Magic)
+	}
+	if h.Version, _, err = readUint32(buf, "file version"); err != nil {
+		return h, 0, err
+	}
+	if h.Version != 1 && h.Version != 2 {
+		return h, 0, errorf(CodeBadVersion, "found version %d file, "+
+			"but expected version 1 or 2", h.Version)
+	}
+	h.Entries, pos, err = readUint32(buf, "number of entries")
+	return h, pos, err
+}
+
+// WriteHeader writes a file header to w.
+func WriteHeader(w io.Writer, h FileHeader) error {
+	var b []byte
+	b = appendUint32(b, h.Magic)
+	b = appendUint32(b, h.Version)
+	b = appendUint32(b, h.Entries)
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadEntry reads one entry from r, in a file of the given version, and
+// returns it as a *Cert, *Keypair or *SecretKey, just as Parse would with
+// opts: private and secret keys are decrypted with the passwords opts gives,
+// and problems with them or with certificates are recorded in the entry rather
+// than returned. If opts is nil, DefaultOptions is used.
+//
+// r is read no further than the end of the entry, so that the next may be read
+// from it, except that the length of a secret key entry can only be found by
+// decoding it. For such an entry r must be an io.Seeker, which is moved back
+// to the end of the entry; otherwise an error with CodeUnsupported is returned.
+// Entries of an unknown type also give an error, with CodeMalformed.
+func ReadEntry(r io.Reader, version uint32, opts *Options) (Entry, error) {
+	if opts != nil && opts.filtered() {
+		o := *opts
+		o.CertsOnly, o.Alias = false, ""
+		opts = &o
+	}
+	opts, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	defer opts.wipePasswords()
+
+	buf := &stream{r: r, size: -1}
+	etype, pos, err := readUint32(buf, "entry type")
+	if err != nil {
+		return nil, err
+	}
+	switch etype {
+	case 1:
+		kp, err := readKeypair(buf, opts, version)
+		if err != nil {
+			return nil, err
+		}
+		kp.unlock(opts)
+		return kp, nil
+
+	case 2:
+		return readCert(buf, opts, version)
+
+	case 3:
+		seeker, ok := r.(io.Seeker)
+		if !ok {
+			return nil, newError(CodeUnsupported, "reading a "+
+				"secret key entry needs an io.Seeker")
+		}
+		sk, err := readSecretKey(buf, opts)
+		if err != nil {
+			return nil, err
+		}
+		n := int64(len(buf.pending))
+		if _, err = seeker.Seek(-n, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return sk, nil
+	}
+	return nil, errorf(CodeMalformed, "unrecognised entry type %d at "+
+		"position %d", etype, pos)
+}
+
+// WriteEntry writes a *Cert or *Keypair entry to w as Pack would with opts, in
+// the file version that opts gives. Private keys are encrypted, or passed
+// through, as by Pack; secret key entries cannot be written. If opts is nil,
+// DefaultOptions is used.
+func WriteEntry(w io.Writer, e Entry, opts *Options) error {
+	opts, err := opts.normalize()
+	if err != nil {
+		return err
+	}
+	defer opts.wipePasswords()
+
+	var b []byte
+	switch e := e.(type) {
+	case *Cert:
+		b, err = appendCert(nil, e, opts)
+	case *Keypair:
+		b, err = appendKeypair(nil, e, opts)
+	case *SecretKey:
+		return errSecretKeys
+	default:
+		return errorf(CodeInvalidArgument, "cannot write entry of "+
+			"type %T", e)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadString reads a string as Java's DataInputStream.readUTF does: a 16-bit
+// big-endian length followed by that many bytes of modified UTF-8.
+func ReadString(r io.Reader) (string, error) {
+	s, _, err := readStr(&stream{r: r, size: -1}, "string")
+	return s, err
+}
+
+// WriteString writes a string as Java's DataOutputStream.writeUTF does: a
+// 16-bit big-endian length followed by the string in modified UTF-8. An error
+// with CodeMalformed is returned, and nothing written, if the encoded string
+// is longer than 65535 bytes.
+func WriteString(w io.Writer, s string) error {
+	b, err := appendStr(nil, s)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadTimestamp reads an entry's timestamp: a 64-bit big-endian count of
+// milliseconds since the Unix epoch.
+func ReadTimestamp(r io.Reader) (time.Time, error) {
+	ts, _, err := readTimestamp(&stream{r: r, size: -1})
+	return ts, err
+}
+
+// WriteTimestamp writes an entry's timestamp, as ReadTimestamp reads it.
+// Precision finer than a millisecond is lost.
+func WriteTimestamp(w io.Writer, ts time.Time) error {
+	_, err := w.Write(appendTimestamp(nil, ts))
+	return err
+}
+
+// ReadDigest reads the 20 byte digest which ends a file.
+func ReadDigest(r io.Reader) ([]byte, error) {
+	digest, err := (&stream{r: r, size: -1}).read(sha1.Size)
+	if err != nil {
+		return nil, eofError(err, "malformed digest at end of file")
+	}
+	return digest, nil
+}
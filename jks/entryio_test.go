@@ -0,0 +1,117 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestEntryIO splits the trusted certificates out of a keystore one entry at
+// a time, as a custom processor would, and checks the result parses.
+func TestEntryIO(t *testing.T) {
+	b := jkstest.New(t, "password").
+		CA("first").
+		ECKeypair("server", elliptic.P256()).
+		CA("second")
+	raw := b.Bytes()
+
+	// a reader that returns a byte at a time finds no data read ahead
+	r := iotest.OneByteReader(bytes.NewReader(raw))
+	h, err := jks.ReadHeader(r)
+	switch {
+	case err != nil:
+		t.Fatalf("ReadHeader: %v", err)
+	case h.Magic != jks.MagicNumber || h.Version != 2 || h.Entries != 3:
+		t.Fatalf("unexpected header %+v", h)
+	}
+	var certs []jks.Entry
+	for i := uint32(0); i < h.Entries; i++ {
+		e, err := jks.ReadEntry(r, h.Version, b.Options())
+		if err != nil {
+			t.Fatalf("ReadEntry #%d: %v", i+1, err)
+		}
+		switch e := e.(type) {
+		case *jks.Cert:
+			certs = append(certs, e)
+		case *jks.Keypair:
+			if e.PrivKeyErr != nil {
+				t.Errorf("keypair: %v", e.PrivKeyErr)
+			}
+		}
+	}
+	digest, err := jks.ReadDigest(r)
+	switch {
+	case err != nil:
+		t.Fatalf("ReadDigest: %v", err)
+	case !bytes.Equal(digest, jks.ComputeDigest(raw[:len(raw)-20],
+		"password")):
+		t.Errorf("digest mismatch")
+	}
+	if _, err = r.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("data left after digest (%v)", err)
+	}
+
+	var out bytes.Buffer
+	md := jks.NewDigest("password")
+	w := io.MultiWriter(&out, md)
+	h.Entries = uint32(len(certs))
+	if err = jks.WriteHeader(w, h); err != nil {
+		t.Fatalf("WriteHeader: %v", err)
+	}
+	for _, e := range certs {
+		if err = jks.WriteEntry(w, e, b.Options()); err != nil {
+			t.Fatalf("WriteEntry: %v", err)
+		}
+	}
+	out.Write(md.Sum(nil))
+	ks, err := jks.Parse(out.Bytes(), b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("Parse: %v", err)
+	case len(ks.Certs) != 2 || len(ks.Keypairs) != 0:
+		t.Errorf("unexpected entries %v", ks.Order)
+	case ks.Certs[0].Alias != "first" || ks.Certs[1].Alias != "second":
+		t.Errorf("unexpected aliases %v", ks.Order)
+	}
+}
+
+// TestEntryIOHelpers checks that strings and timestamps round trip.
+func TestEntryIOHelpers(t *testing.T) {
+	var buf bytes.Buffer
+	ts := time.UnixMilli(1700000000123)
+	if err := jks.WriteString(&buf, "café\x00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := jks.WriteTimestamp(&buf, ts); err != nil {
+		t.Fatal(err)
+	}
+	s, err := jks.ReadString(&buf)
+	switch {
+	case err != nil:
+		t.Fatalf("ReadString: %v", err)
+	case s != "café\x00":
+		t.Errorf("string %q ≠ expected %q", s, "café\x00")
+	}
+	got, err := jks.ReadTimestamp(&buf)
+	switch {
+	case err != nil:
+		t.Fatalf("ReadTimestamp: %v", err)
+	case !got.Equal(ts):
+		t.Errorf("timestamp %v ≠ expected %v", got, ts)
+	}
+
+	long := string(make([]byte, 0x10000))
+	if err = jks.WriteString(&buf, long); jks.ErrorCode(err) !=
+		jks.CodeMalformed {
+		t.Errorf("overlong string: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes written despite error", buf.Len())
+	}
+}
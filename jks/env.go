@@ -0,0 +1,60 @@
+package jks
+
+import (
+	"encoding/base64"
+	"os"
+	"regexp"
+)
+
+// Environment variable size limits. A keystore stored in an environment
+// variable must fit within these, or the process will fail to start.
+const (
+	// MaxEnvSize is the largest "NAME=value" string that Linux will pass
+	// to a new process (MAX_ARG_STRLEN, less the terminating NUL).
+	MaxEnvSize = 32*4096 - 1
+
+	// MaxWindowsEnvSize is the largest "NAME=value" string that Windows
+	// allows in a process environment.
+	MaxWindowsEnvSize = 32767
+)
+
+// ErrEnvTooLarge is returned by ExportEnv if the encoded keystore would not fit
+// in an environment variable.
+var ErrEnvTooLarge error = newError(CodeEnvTooLarge, "keystore too large for "+
+	"an environment variable")
+
+// envNameRE matches names that are valid POSIX shell variable names.
+var envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnv parses a base64-encoded keystore held in the named environment
+// variable, as ParseBase64 does.
+func ParseEnv(name string, opts *Options) (*Keystore, error) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, errorf(CodeMissingData, "environment variable %s "+
+			"not set", name)
+	}
+	ks, err := ParseBase64(s, opts)
+	if err != nil {
+		return ks, errorf("", "environment variable %s: %v", name, err)
+	}
+	return ks, nil
+}
+
+// ExportEnv returns a POSIX shell statement ("export NAME=...", without a
+// trailing newline) setting the named environment variable to the raw
+// keystore data, base64-encoded as ParseEnv expects. ErrEnvTooLarge is
+// returned if the variable would exceed MaxEnvSize; callers targeting Windows
+// should also check that the name and value fit within MaxWindowsEnvSize.
+func ExportEnv(name string, raw []byte) (string, error) {
+	if !envNameRE.MatchString(name) {
+		return "", errorf(CodeInvalidArgument, "invalid environment "+
+			"variable name %q", name)
+	}
+	value := base64.StdEncoding.EncodeToString(raw)
+	if len(name)+1+len(value) > MaxEnvSize {
+		return "", ErrEnvTooLarge
+	}
+	// the standard base64 alphabet needs no shell quoting
+	return "export " + name + "=" + value, nil
+}
@@ -0,0 +1,44 @@
+package jks_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestEnv checks that a keystore exported with ExportEnv can be read back
+// with ParseEnv, and that oversized keystores are refused.
+func TestEnv(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root")
+
+	stmt, err := jks.ExportEnv("KEYSTORE", b.Bytes())
+	if err != nil {
+		t.Fatalf("ExportEnv: %v", err)
+	}
+	value := strings.TrimPrefix(stmt, "export KEYSTORE=")
+	if value == stmt {
+		t.Fatalf("unexpected statement %q", stmt)
+	}
+
+	t.Setenv("KEYSTORE", value)
+	ks, err := jks.ParseEnv("KEYSTORE", b.Options())
+	if err != nil {
+		t.Fatalf("ParseEnv: %v", err)
+	}
+	if len(ks.Certs) != 1 {
+		t.Errorf("got %d certificates ≠ expected 1", len(ks.Certs))
+	}
+
+	if _, err = jks.ParseEnv("MINIJKS_UNSET", b.Options()); err == nil {
+		t.Error("expected error for unset variable")
+	}
+	if _, err = jks.ExportEnv("BAD NAME", b.Bytes()); err == nil {
+		t.Error("expected error for invalid name")
+	}
+	big := make([]byte, jks.MaxEnvSize*3/4)
+	if _, err = jks.ExportEnv("BIG", big); err != jks.ErrEnvTooLarge {
+		t.Errorf("error %v ≠ expected %v", err, jks.ErrEnvTooLarge)
+	}
+}
@@ -0,0 +1,166 @@
+package jks
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes. Each error returned by this package carries one of these
+// stable, machine-readable codes, which may be retrieved with ErrorCode. Unlike
+// the error messages, which may be reworded from one release to the next, the
+// codes will not change, so callers and tools consuming minijks's output can
+// match on them.
+const (
+	// CodeUnknown is reported for errors that did not originate in this
+	// package, such as I/O errors.
+	CodeUnknown = "JKS_UNKNOWN"
+
+	// Parsing.
+	CodeBadMagic       = "JKS_BAD_MAGIC"
+	CodeBadVersion     = "JKS_BAD_VERSION"
+	CodeTruncated      = "JKS_TRUNCATED"
+	CodeMalformed      = "JKS_MALFORMED"
+	CodeDigestMismatch = "JKS_DIGEST_MISMATCH"
+	CodeTooLarge       = "JKS_TOO_LARGE"
+
+	// Passwords.
+	CodeBadStorePassword = "JKS_BAD_STORE_PASSWORD"
+
+	// Private keys.
+	CodeUnsupportedKeyAlg = "JKS_UNSUPPORTED_KEY_ALG"
+	CodeBadKeyPassword    = "JKS_BAD_KEY_PASSWORD"
+	CodeKeyMismatch       = "JKS_KEY_MISMATCH"
+	CodeKeyNotDecrypted   = "JKS_KEY_NOT_DECRYPTED"
+
+	// Options and policy.
+	CodeInvalidOptions  = "JKS_INVALID_OPTIONS"
+	CodeInvalidArgument = "JKS_INVALID_ARGUMENT"
+	CodeIncompatible    = "JKS_INCOMPATIBLE"
+	CodeKeyPolicy       = "JKS_KEY_POLICY"
+	CodeValidation      = "JKS_VALIDATION_FAILED"
+
+	// Entries.
+	CodeDuplicateAlias = "JKS_DUPLICATE_ALIAS"
+	CodeNoSuchAlias    = "JKS_NO_SUCH_ALIAS"
+	CodeMissingData    = "JKS_MISSING_DATA"
+	CodeUnusableCA     = "JKS_UNUSABLE_CA"
+	CodeUnsupported    = "JKS_UNSUPPORTED"
+
+	// Storage and certificate authority state.
+	CodeModified       = "JKS_MODIFIED"
+	CodeEnvTooLarge    = "JKS_ENV_TOO_LARGE"
+	CodeSecretUnstable = "JKS_SECRET_UNSTABLE"
+	CodeAlreadyRevoked = "JKS_ALREADY_REVOKED"
+	CodeNotOnHold      = "JKS_NOT_ON_HOLD"
+	CodeCryptoFailure  = "JKS_CRYPTO_FAILURE"
+)
+
+// Error is the type of most errors returned by this package. It pairs a
+// human-readable message with one of the Code constants.
+type Error struct {
+	// Code is one of the Code constants.
+	Code string
+
+	// Msg is the error message.
+	Msg string
+
+	// Err is the underlying error, if any. Its message is already
+	// included in Msg.
+	Err error
+
+	// sentinel is set for the exported Err variables which match any
+	// error with the same code.
+	sentinel bool
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Unwrap returns the underlying error, so that errors.Is and errors.As see
+// through e.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is one of the sentinel errors below (such as
+// ErrTruncated) with the same code as e, so that errors.Is(err, ErrTruncated)
+// holds for every truncation error, whatever context it carries.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.sentinel && t.Code == e.Code
+}
+
+// ErrorCode returns e.Code.
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+// ErrorCode returns the code carried by err or by any error it wraps, or
+// CodeUnknown if there is none. It returns "" if err is nil.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return CodeUnknown
+}
+
+// Sentinel errors, for use with errors.Is. Each matches any error from this
+// package with the same code, so that, for instance, errors.Is(err,
+// ErrTruncated) holds for an error naming the alias or file offset at which
+// the data ran out. ErrDigestMismatch is declared with Parse.
+var (
+	// ErrBadStorePassword is matched by the error that Parse and
+	// ParsePKCS12 return if the file's digest does not match and nothing
+	// in the file shows the password to be right. A wrong password is by
+	// far the likelier cause, but a file whose digest alone is corrupt
+	// cannot be told apart. The error also matches ErrDigestMismatch.
+	ErrBadStorePassword error = newSentinel(CodeBadStorePassword,
+		"wrong keystore password")
+
+	// ErrBadKeyPassword matches errors decrypting a private or secret key
+	// with the wrong password. Parse records these as the entry's
+	// PrivKeyErr or KeyErr rather than returning them.
+	ErrBadKeyPassword error = newSentinel(CodeBadKeyPassword,
+		"wrong key password")
+
+	// ErrTruncated matches errors reporting that a file ended early.
+	ErrTruncated error = newSentinel(CodeTruncated, "unexpected end of "+
+		"data")
+)
+
+// newSentinel returns an *Error which matches any error with the same code.
+func newSentinel(code, msg string) *Error {
+	return &Error{Code: code, Msg: msg, sentinel: true}
+}
+
+// newError returns an *Error with a fixed message.
+func newError(code, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
+
+// errorf returns an *Error with a formatted message. The first error among
+// args, if any, becomes the underlying error. If code is empty, the code of
+// that underlying error is used, so that wrapping an error to add context
+// keeps its code.
+func errorf(code, format string, args ...interface{}) error {
+	e := &Error{Msg: fmt.Sprintf(format, args...)}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			e.Err = err
+			break
+		}
+	}
+	if code == "" {
+		code = ErrorCode(e.Err)
+		if code == "" {
+			code = CodeUnknown
+		}
+	}
+	e.Code = code
+	return e
+}
@@ -0,0 +1,139 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestErrorCode checks that errors from parsing and packing carry the
+// expected codes, including when wrapped.
+func TestErrorCode(t *testing.T) {
+	raw := jkstest.New(t, "password").CA("root").Bytes()
+	opts := &jks.Options{Password: "password"}
+
+	parse := func(raw []byte, opts *jks.Options) error {
+		_, err := jks.Parse(raw, opts)
+		return err
+	}
+	t.Run("bad magic", testErrorCode(jks.CodeBadMagic,
+		parse(jkstest.CorruptMagic(raw), opts)))
+	t.Run("digest mismatch", testErrorCode(jks.CodeDigestMismatch,
+		parse(jkstest.CorruptDigest(raw), opts)))
+	t.Run("truncated", testErrorCode(jks.CodeTruncated,
+		parse(jkstest.Truncate(raw, 10), opts)))
+	t.Run("invalid options", testErrorCode(jks.CodeInvalidOptions,
+		parse(raw, &jks.Options{MinRSABits: -1})))
+
+	_, err := jks.MarshalPKCS8("not a key")
+	t.Run("unsupported key", testErrorCode(jks.CodeUnsupportedKeyAlg, err))
+
+	b := jkstest.New(t, "password").ECKeypair("server", elliptic.P256())
+	b.Keystore().Keypairs[0].PrivateKey = "not a key"
+	_, err = b.Keystore().Pack(b.Options())
+	switch {
+	case !errors.Is(err, jks.ErrUnsupportedKeyAlgorithm):
+		t.Errorf("Pack: expected ErrUnsupportedKeyAlgorithm but got %v",
+			err)
+	case !strings.Contains(err.Error(), `"server"`):
+		t.Errorf("Pack: error %q does not name the alias", err)
+	}
+
+	ks := jkstest.New(t, "password").CA("root").Keystore()
+	err = ks.Merge(ks, jks.CollisionError)
+	t.Run("duplicate alias", testErrorCode(jks.CodeDuplicateAlias, err))
+
+	t.Run("wrapped", testErrorCode(jks.CodeDigestMismatch,
+		fmt.Errorf("context: %w", jks.ErrDigestMismatch)))
+	t.Run("foreign", testErrorCode(jks.CodeUnknown,
+		errors.New("not from jks")))
+	t.Run("nil", testErrorCode("", nil))
+}
+
+func testErrorCode(expected string, err error) func(*testing.T) {
+	return func(t *testing.T) {
+		if code := jks.ErrorCode(err); code != expected {
+			t.Errorf("code %q ≠ expected %q (error: %v)", code,
+				expected, err)
+		}
+	}
+}
+
+// TestSentinelErrors checks that errors.Is distinguishes a wrong password from
+// a corrupt file, whatever context the errors carry.
+func TestSentinelErrors(t *testing.T) {
+	trust := jkstest.New(t, "password").CA("root").Bytes()
+	keys := jkstest.New(t, "password").ECKeypair("server",
+		elliptic.P256()).Bytes()
+	wrong := &jks.Options{Password: "wrong"}
+	right := &jks.Options{Password: "password"}
+
+	parse := func(raw []byte, opts *jks.Options) error {
+		_, err := jks.Parse(raw, opts)
+		return err
+	}
+	t.Run("wrong password", testSentinel(parse(trust, wrong),
+		jks.ErrBadStorePassword, jks.ErrDigestMismatch))
+	t.Run("wrong password with keys", testSentinel(parse(keys, wrong),
+		jks.ErrBadStorePassword, jks.ErrDigestMismatch))
+	t.Run("tampered", testSentinel(
+		parse(jkstest.CorruptDigest(keys), right),
+		jks.ErrDigestMismatch))
+	t.Run("truncated", testSentinel(
+		parse(jkstest.Truncate(keys, 10), right), jks.ErrTruncated))
+
+	ks, err := jks.Parse(keys, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12, err := ks.PackPKCS12(right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jks.ParsePKCS12(p12, wrong)
+	t.Run("PKCS#12 wrong password", testSentinel(err,
+		jks.ErrBadStorePassword, jks.ErrDigestMismatch))
+
+	err = ks.Keypairs[0].Decrypt("wrong")
+	t.Run("wrong key password", testSentinel(err, jks.ErrBadKeyPassword))
+	if err != nil && !strings.Contains(err.Error(), `"server"`) {
+		t.Errorf("Decrypt: error %q does not name the alias", err)
+	}
+
+	ks, err = jks.Parse(keys, &jks.Options{
+		Password:     "password",
+		KeyPasswords: map[string]string{"server": "wrong"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("PrivKeyErr", testSentinel(ks.Keypairs[0].PrivKeyErr,
+		jks.ErrBadKeyPassword))
+}
+
+// testSentinel checks that err matches each of expected and none of the other
+// sentinel errors.
+func testSentinel(err error, expected ...error) func(*testing.T) {
+	return func(t *testing.T) {
+		for _, sentinel := range []error{
+			jks.ErrBadStorePassword,
+			jks.ErrBadKeyPassword,
+			jks.ErrDigestMismatch,
+			jks.ErrTruncated,
+		} {
+			exp := false
+			for _, e := range expected {
+				exp = exp || e == sentinel
+			}
+			if got := errors.Is(err, sentinel); got != exp {
+				t.Errorf("errors.Is(%v, %v) = %t ≠ expected %t",
+					err, sentinel, got, exp)
+			}
+		}
+	}
+}
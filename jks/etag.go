@@ -0,0 +1,97 @@
+package jks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrModified is returned by PackIfUnchanged if the file has been changed
+// since the keystore was read from it.
+var ErrModified error = newError(CodeModified, "keystore file modified since "+
+	"it was read")
+
+// ETag returns a content hash of raw keystore data, as recorded by Parse in
+// Keystore.ETag. It is the hex-encoded SHA-256 digest of the data.
+func ETag(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// PackIfUnchanged packs the keystore and writes it to path, but only if the
+// file's current content still matches ks.ETag; otherwise ErrModified is
+// returned and the file is left alone. This allows a load-modify-store cycle
+// to detect that another process changed the file in the meantime. If ks.ETag
+// is empty (for a keystore that was built rather than parsed) then path must
+// not exist.
+//
+// The file is replaced atomically, keeping its permissions (or using 0600 for
+// a new file), and ks.ETag is updated to match the new content. The check and
+// the replacement are not a single atomic operation, so this guards against
+// lost updates from slow read-modify-write cycles but is not a substitute for
+// a lock if writers may race within milliseconds of each other.
+func (ks *Keystore) PackIfUnchanged(path string, opts *Options) error {
+	mode := os.FileMode(0600)
+	cur, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if ETag(cur) != ks.ETag {
+			return ErrModified
+		}
+		if fi, err := os.Stat(path); err == nil {
+			mode = fi.Mode().Perm()
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if ks.ETag != "" {
+			return ErrModified
+		}
+	default:
+		return err
+	}
+
+	raw, err := ks.Pack(opts)
+	if err != nil {
+		return err
+	}
+	if err = replaceFile(path, raw, mode); err != nil {
+		return err
+	}
+	ks.ETag = ETag(raw)
+	return nil
+}
+
+// replaceFile atomically replaces path with data, by writing a temporary file
+// in the same directory, syncing it and renaming it into place. The directory
+// is then synced too, where the platform allows, so that the rename itself
+// survives a crash.
+func replaceFile(path string, data []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
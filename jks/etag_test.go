@@ -0,0 +1,65 @@
+package jks_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestPackIfUnchanged checks that a keystore is only written back if the file
+// has not changed since it was parsed.
+func TestPackIfUnchanged(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root")
+	fn := filepath.Join(t.TempDir(), "keystore.jks")
+
+	// a keystore that was not parsed may only create a new file
+	if err := b.Keystore().PackIfUnchanged(fn, b.Options()); err != nil {
+		t.Fatalf("initial write: %v", err)
+	}
+	other := jkstest.New(t, "password").CA("other")
+	err := other.Keystore().PackIfUnchanged(fn, other.Options())
+	if err != jks.ErrModified {
+		t.Errorf("overwrite: error %v ≠ expected %v", err,
+			jks.ErrModified)
+	}
+
+	load := func() *jks.Keystore {
+		raw, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ks, err := jks.Parse(raw, b.Options())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ks.ETag != jks.ETag(raw) {
+			t.Errorf("ETag %q ≠ expected %q", ks.ETag,
+				jks.ETag(raw))
+		}
+		return ks
+	}
+
+	// two agents read the same file; the first to write wins
+	first, second := load(), load()
+	first.Certs[0].Alias = "first"
+	second.Certs[0].Alias = "second"
+	if err := first.PackIfUnchanged(fn, b.Options()); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	err = second.PackIfUnchanged(fn, b.Options())
+	if err != jks.ErrModified {
+		t.Errorf("second write: error %v ≠ expected %v", err,
+			jks.ErrModified)
+	}
+
+	// the winner's ETag is updated, so it may write again
+	if err := first.PackIfUnchanged(fn, b.Options()); err != nil {
+		t.Errorf("repeated write: %v", err)
+	}
+	if got := load(); got.Certs[0].Alias != "first" {
+		t.Errorf("alias %q ≠ expected \"first\"", got.Certs[0].Alias)
+	}
+}
@@ -0,0 +1,90 @@
+package jks
+
+import (
+	"crypto/x509"
+	"slices"
+	"time"
+)
+
+// EntryCert identifies a certificate within a keystore entry.
+type EntryCert struct {
+	// Alias is the alias of the entry holding the certificate.
+	Alias string
+
+	// Entry is the trusted certificate (*Cert) or keypair (*Keypair)
+	// holding the certificate.
+	Entry Entry
+
+	// ChainIndex is the certificate's position in a keypair's chain, where
+	// 0 is the leaf. It is 0 for a trusted certificate.
+	ChainIndex int
+
+	// Cert is the certificate itself.
+	Cert *x509.Certificate
+}
+
+// ExpiringWithin returns the certificates in the keystore, whether trusted
+// certificates or part of a keypair's chain, which expire within d from now.
+// Certificates which have already expired are included. The results are sorted
+// by expiry time, soonest first; certificates that could not be parsed are
+// skipped.
+//
+// A keypair is reported if any certificate in its chain expires, not only the
+// leaf, since the JVM will refuse the chain either way.
+func (ks *Keystore) ExpiringWithin(d time.Duration) []*EntryCert {
+	deadline := time.Now().Add(d)
+	certs := ks.entryCerts(func(cert *x509.Certificate) bool {
+		return !cert.NotAfter.After(deadline)
+	})
+	slices.SortStableFunc(certs, func(a, b *EntryCert) int {
+		return a.Cert.NotAfter.Compare(b.Cert.NotAfter)
+	})
+	return certs
+}
+
+// NotYetValid returns the certificates in the keystore, whether trusted
+// certificates or part of a keypair's chain, whose validity period has not yet
+// started. This usually means a certificate was issued by a host with a fast
+// clock, or imported ahead of a planned rotation. The results are sorted by
+// the time they become valid, soonest first; certificates that could not be
+// parsed are skipped.
+func (ks *Keystore) NotYetValid() []*EntryCert {
+	now := time.Now()
+	certs := ks.entryCerts(func(cert *x509.Certificate) bool {
+		return now.Before(cert.NotBefore)
+	})
+	slices.SortStableFunc(certs, func(a, b *EntryCert) int {
+		return a.Cert.NotBefore.Compare(b.Cert.NotBefore)
+	})
+	return certs
+}
+
+// entryCerts returns the parsed certificates of the keystore's entries, in
+// file order, for which match returns true.
+func (ks *Keystore) entryCerts(match func(*x509.Certificate) bool,
+) []*EntryCert {
+	var certs []*EntryCert
+	add := func(e Entry, i int, cert *x509.Certificate) {
+		if cert != nil && match(cert) {
+			certs = append(certs, &EntryCert{
+				Alias:      e.EntryAlias(),
+				Entry:      e,
+				ChainIndex: i,
+				Cert:       cert,
+			})
+		}
+	}
+	for _, e := range ks.entries() {
+		switch e := e.(type) {
+		case *Cert:
+			add(e, 0, e.Cert)
+		case *Keypair:
+			for i, cert := range e.CertChain {
+				if cert != nil {
+					add(e, i, cert.Cert)
+				}
+			}
+		}
+	}
+	return certs
+}
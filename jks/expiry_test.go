@@ -0,0 +1,68 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestExpiry checks that ExpiringWithin and NotYetValid find certificates in
+// both trusted certificate entries and keypair chains, soonest first.
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	ks := jkstest.New(t, "password").
+		CA("root").
+		ECKeypair("server", elliptic.P256()).
+		Keystore()
+	ks.Certs = append(ks.Certs, &jks.Cert{
+		Alias: "expired",
+		Cert: &x509.Certificate{
+			NotBefore: now.Add(-48 * time.Hour),
+			NotAfter:  now.Add(-time.Hour),
+		},
+	}, &jks.Cert{
+		Alias: "future",
+		Cert: &x509.Certificate{
+			NotBefore: now.Add(time.Hour),
+			NotAfter:  now.Add(72 * time.Hour),
+		},
+	}, &jks.Cert{Alias: "unparsed"})
+
+	t.Run("hour", testExpiry(ks.ExpiringWithin(time.Hour), "expired"))
+	t.Run("two days", testExpiry(ks.ExpiringWithin(48*time.Hour),
+		"expired", "root", "server"))
+	t.Run("not yet valid", testExpiry(ks.NotYetValid(), "future"))
+
+	kp := ks.Keypairs[0]
+	kp.CertChain = append(kp.CertChain, &jks.KeypairCert{
+		Cert: ks.Certs[2].Cert,
+	})
+	for _, ec := range ks.ExpiringWithin(-30 * time.Minute) {
+		if ec.Alias != "expired" {
+			t.Errorf("unexpected entry %q", ec.Alias)
+		}
+	}
+	certs := ks.NotYetValid()
+	if len(certs) != 2 || certs[1].Entry != kp || certs[1].ChainIndex != 1 {
+		t.Errorf("chain certificate not found: %+v", certs)
+	}
+}
+
+func testExpiry(got []*jks.EntryCert, exp ...string) func(*testing.T) {
+	return func(t *testing.T) {
+		if len(got) != len(exp) {
+			t.Fatalf("%d certificates ≠ expected %d", len(got),
+				len(exp))
+		}
+		for i, ec := range got {
+			if ec.Alias != exp[i] {
+				t.Errorf("certificate %d: alias %q ≠ "+
+					"expected %q", i, ec.Alias, exp[i])
+			}
+		}
+	}
+}
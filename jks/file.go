@@ -0,0 +1,62 @@
+package jks
+
+import (
+	"io/fs"
+	"os"
+)
+
+// LoadFile reads the JKS or JCEKS file at path and parses it, as Parse does.
+// Keystore.ETag is set, so the keystore may be written back with
+// PackIfUnchanged.
+func LoadFile(path string, opts *Options) (*Keystore, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFile(path, raw, opts)
+}
+
+// LoadFS reads the JKS or JCEKS file with the given name from fsys and parses
+// it, as LoadFile does, so that keystores may be loaded from an embed.FS, a
+// zip archive (see archive/zip.Reader) or a testing/fstest.MapFS without
+// touching the operating system's filesystem. name is a slash-separated path,
+// as fs.ValidPath describes.
+func LoadFS(fsys fs.FS, name string, opts *Options) (*Keystore, error) {
+	raw, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return parseFile(name, raw, opts)
+}
+
+// parseFile parses raw, naming the file it was read from in any error.
+func parseFile(name string, raw []byte, opts *Options) (*Keystore, error) {
+	ks, err := Parse(raw, opts)
+	if err != nil {
+		return ks, errorf("", "%s: %v", name, err)
+	}
+	return ks, nil
+}
+
+// SaveFile packs the keystore, as Pack does, and writes it to path. The file
+// is replaced atomically: the data is written to a temporary file in the same
+// directory, synced to disk and renamed over path, so that a process which
+// dies part way leaves either the old keystore or the new one, never a
+// truncated file. The new file has the permissions perm, or 0600 if perm is
+// zero, since a keystore holds private keys. ks.ETag is updated to match the
+// new content.
+func (ks *Keystore) SaveFile(path string, opts *Options, perm os.FileMode,
+) error {
+	if perm == 0 {
+		perm = 0600
+	}
+	raw, err := ks.Pack(opts)
+	if err != nil {
+		return err
+	}
+	if err = replaceFile(path, raw, perm); err != nil {
+		return err
+	}
+	ks.ETag = ETag(raw)
+	return nil
+}
@@ -0,0 +1,77 @@
+package jks_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestSaveFile checks that SaveFile and LoadFile round trip, that the file
+// permissions default to 0600, and that a failed save leaves the old file in
+// place.
+func TestSaveFile(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "keystore.jks")
+	b := jkstest.New(t, "password").CA("root")
+
+	if err := b.Keystore().SaveFile(fn, b.Options(), 0); err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	if fi, err := os.Stat(fn); err != nil {
+		t.Fatal(err)
+	} else if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("permissions %o ≠ expected 600", perm)
+	}
+
+	ks, err := jks.LoadFile(fn, b.Options())
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if len(ks.Certs) != 1 || ks.Certs[0].Alias != "root" {
+		t.Errorf("unexpected entries after LoadFile")
+	}
+	if ks.ETag != b.Keystore().ETag {
+		t.Errorf("ETag %q ≠ expected %q", ks.ETag, b.Keystore().ETag)
+	}
+
+	// a keystore that cannot be packed must not replace the file
+	ks.Certs = append(ks.Certs, ks.Certs[0])
+	if err := ks.SaveFile(fn, b.Options(), 0644); err == nil {
+		t.Error("SaveFile: expected duplicate alias error")
+	}
+	if _, err := jks.LoadFile(fn, b.Options()); err != nil {
+		t.Errorf("LoadFile after failed save: %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	_, err = jks.LoadFile(fn, &jks.Options{Password: "wrong"})
+	if code := jks.ErrorCode(err); code != jks.CodeDigestMismatch {
+		t.Errorf("wrong password: code %q ≠ expected %q (%v)", code,
+			jks.CodeDigestMismatch, err)
+	}
+}
+
+// TestLoadFS checks that a keystore can be loaded from an fs.FS.
+func TestLoadFS(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root")
+	fsys := fstest.MapFS{
+		"certs/truststore.jks": &fstest.MapFile{Data: b.Bytes()},
+	}
+	ks, err := jks.LoadFS(fsys, "certs/truststore.jks", b.Options())
+	if err != nil {
+		t.Fatalf("LoadFS: %v", err)
+	}
+	if len(ks.Certs) != 1 || ks.Certs[0].Alias != "root" {
+		t.Errorf("unexpected entries after LoadFS")
+	}
+
+	if _, err := jks.LoadFS(fsys, "missing.jks", b.Options()); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -0,0 +1,80 @@
+package jks
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// Fingerprints holds a certificate's fingerprints, formatted as keytool prints
+// them: upper-case hex digits separated by colons.
+type Fingerprints struct {
+	SHA1   string
+	SHA256 string
+}
+
+// CertFingerprints returns the fingerprints of a certificate in DER form.
+func CertFingerprints(der []byte) Fingerprints {
+	sha1Sum := sha1.Sum(der)
+	sha256Sum := sha256.Sum256(der)
+	return Fingerprints{
+		SHA1:   colonHex(sha1Sum[:]),
+		SHA256: colonHex(sha256Sum[:]),
+	}
+}
+
+// Fingerprints returns the fingerprints of the certificate.
+func (c *Cert) Fingerprints() Fingerprints {
+	return CertFingerprints(c.DER())
+}
+
+// Fingerprints returns the fingerprints of the certificate.
+func (c *KeypairCert) Fingerprints() Fingerprints {
+	return CertFingerprints(c.DER())
+}
+
+// Fingerprints returns the fingerprints of the keypair's leaf certificate,
+// CertChain[0], which keytool -list prints for the entry. They are empty if
+// the keypair has no certificate chain.
+func (kp *Keypair) Fingerprints() Fingerprints {
+	if len(kp.CertChain) == 0 || kp.CertChain[0] == nil {
+		return Fingerprints{}
+	}
+	return kp.CertChain[0].Fingerprints()
+}
+
+// WriteFingerprints writes the alias, type and certificate fingerprints of
+// each entry, sorted by alias, in the layout of "keytool -list -v", so that
+// values may be compared against a runbook or the output of keytool on
+// another host. Only the fingerprints are written of the details keytool
+// prints for each certificate.
+func (ks *Keystore) WriteFingerprints(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	m := ks.Manifest()
+	fmt.Fprintf(bw, "Your keystore contains %d entries\n", len(m.Entries))
+	for _, e := range m.Entries {
+		fmt.Fprintf(bw, "\nAlias name: %s\n", e.Alias)
+		fmt.Fprintf(bw, "Entry type: %s\n", e.Type)
+		switch {
+		case e.Type == ManifestPrivateKey:
+			fmt.Fprintf(bw, "Certificate chain length: %d\n",
+				len(e.Certificates))
+			for i, c := range e.Certificates {
+				fmt.Fprintf(bw, "Certificate[%d]:\n", i+1)
+				writeFingerprints(bw, c)
+			}
+		case len(e.Certificates) != 0:
+			writeFingerprints(bw, e.Certificates[0])
+		}
+	}
+	return bw.Flush()
+}
+
+// writeFingerprints writes a certificate's fingerprints as keytool does.
+func writeFingerprints(w io.Writer, c *ManifestCert) {
+	fmt.Fprintf(w, "Certificate fingerprints:\n")
+	fmt.Fprintf(w, "\t SHA1: %s\n", c.FingerprintSHA1)
+	fmt.Fprintf(w, "\t SHA256: %s\n", c.FingerprintSHA256)
+}
@@ -0,0 +1,67 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestFingerprints checks the fingerprint format against keytool's, and that
+// WriteFingerprints lists every certificate.
+func TestFingerprints(t *testing.T) {
+	ks := jkstest.New(t, "password").
+		CA("root").
+		ECKeypair("server", elliptic.P256()).
+		Keystore()
+	der := ks.Certs[0].DER()
+
+	// keytool prints e.g. "SHA1: 0A:1B:..."
+	colonHex := func(b []byte) string {
+		var parts []string
+		for _, c := range b {
+			parts = append(parts, fmt.Sprintf("%02X", c))
+		}
+		return strings.Join(parts, ":")
+	}
+	sha1Sum, sha256Sum := sha1.Sum(der), sha256.Sum256(der)
+	exp := jks.Fingerprints{
+		SHA1:   colonHex(sha1Sum[:]),
+		SHA256: colonHex(sha256Sum[:]),
+	}
+	if fp := ks.Certs[0].Fingerprints(); fp != exp {
+		t.Errorf("fingerprints %+v ≠ expected %+v", fp, exp)
+	}
+
+	kp := ks.Keypairs[0]
+	if kp.Fingerprints() != kp.CertChain[0].Fingerprints() {
+		t.Error("keypair fingerprints are not those of its leaf")
+	}
+	if fp := (&jks.Keypair{}).Fingerprints(); fp != (jks.Fingerprints{}) {
+		t.Errorf("keypair without chain has fingerprints %+v", fp)
+	}
+
+	var buf bytes.Buffer
+	if err := ks.WriteFingerprints(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		"Your keystore contains 2 entries\n",
+		"Alias name: root\nEntry type: trustedCertEntry\n",
+		"\t SHA1: " + exp.SHA1 + "\n",
+		"\t SHA256: " + exp.SHA256 + "\n",
+		"Certificate chain length: 1\nCertificate[1]:\n",
+		"\t SHA256: " + kp.Fingerprints().SHA256 + "\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output does not contain %q:\n%s", s, out)
+		}
+	}
+}
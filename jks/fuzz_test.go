@@ -0,0 +1,97 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// Keystores are often uploaded by users and parsed server-side, so the parsers
+// must return an error, never panic, whatever the input. These fuzz targets
+// check that; run them with e.g. "go test -fuzz=FuzzParse". Without -fuzz,
+// only the seed corpus is run.
+
+// fuzzSeeds adds well-formed keystores, and some damaged copies, to the seed
+// corpus of f.
+func fuzzSeeds(f *testing.F, pkcs12 bool) {
+	b := jkstest.New(f, "password").CA("root").
+		ECKeypair("server", elliptic.P256())
+	raw := b.Bytes()
+	if pkcs12 {
+		var err error
+		if raw, err = b.Keystore().PackPKCS12(b.Options()); err != nil {
+			f.Fatal(err)
+		}
+	}
+	f.Add(raw)
+	f.Add(jkstest.Truncate(raw, len(raw)/2))
+	f.Add(jkstest.CorruptEntryCount(raw))
+	f.Add(jkstest.New(f, "").Bytes())
+	f.Add([]byte{})
+}
+
+// fuzzOptions are the options used by the fuzz targets. The password is right
+// for the seeds, so the fuzzer reaches key decryption, and the digest is not
+// verified, since otherwise almost every mutation would be rejected before
+// the entries were read.
+func fuzzOptions() *jks.Options {
+	return &jks.Options{
+		Password:         "password",
+		SkipVerifyDigest: true,
+		MaxEntryCount:    64,
+		MaxCertSize:      1 << 16,
+		MaxKeySize:       1 << 16,
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	fuzzSeeds(f, false)
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		jks.Parse(raw, fuzzOptions())
+		jks.Parse(raw, nil)
+	})
+}
+
+func FuzzParseFrom(f *testing.F) {
+	fuzzSeeds(f, false)
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		jks.ParseFrom(bytes.NewReader(raw), fuzzOptions())
+	})
+}
+
+func FuzzParsePKCS12(f *testing.F) {
+	fuzzSeeds(f, true)
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		jks.ParsePKCS12(raw, fuzzOptions())
+	})
+}
+
+func FuzzParseAny(f *testing.F) {
+	fuzzSeeds(f, false)
+	fuzzSeeds(f, true)
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		jks.ParseAny(raw, fuzzOptions())
+	})
+}
+
+func FuzzDecryptPKCS8(f *testing.F) {
+	ks := jkstest.New(f, "password").ECKeypair("server",
+		elliptic.P256()).Keystore()
+	raw, err := ks.Pack(&jks.Options{Password: "password"})
+	if err != nil {
+		f.Fatal(err)
+	}
+	ks, err = jks.Parse(raw, &jks.Options{Password: "password"})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(ks.Keypairs[0].EncryptedKey)
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		if key, err := jks.DecryptPKCS8(raw, "password"); err == nil {
+			jks.ParsePrivateKeyInfo(key)
+		}
+	})
+}
@@ -0,0 +1,424 @@
+package jks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// KeyType selects the type of key generated by GenerateKeypair.
+type KeyType int
+
+const (
+	// KeyRSA generates an RSA key of KeyGenParams.RSABits bits.
+	KeyRSA KeyType = iota
+
+	// KeyEC generates an ECDSA key on KeyGenParams.Curve.
+	KeyEC
+
+	// KeyEd25519 generates an Ed25519 key.
+	KeyEd25519
+)
+
+var keyTypeNames = []string{
+	KeyRSA:     "rsa",
+	KeyEC:      "ec",
+	KeyEd25519: "ed25519",
+}
+
+// String returns the name of the key type, as accepted by ParseKeyType.
+func (t KeyType) String() string {
+	if t >= 0 && int(t) < len(keyTypeNames) {
+		return keyTypeNames[t]
+	}
+	return fmt.Sprintf("KeyType(%d)", int(t))
+}
+
+// ParseKeyType returns the key type with the given name (e.g. "ec").
+func ParseKeyType(name string) (KeyType, error) {
+	for t, n := range keyTypeNames {
+		if strings.EqualFold(n, name) {
+			return KeyType(t), nil
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown key type %q (expected "+
+		"one of %s)", name, strings.Join(keyTypeNames, ", "))
+}
+
+// SerialStrategy determines how GenerateKeypair picks the serial number of the
+// certificate it issues.
+type SerialStrategy int
+
+const (
+	// SerialRandom uses a random 127-bit serial number, as recommended by
+	// the CA/Browser Forum baseline requirements.
+	SerialRandom SerialStrategy = iota
+
+	// SerialTimestamp uses the time of generation, in nanoseconds since
+	// the Unix epoch.
+	SerialTimestamp
+
+	// SerialSequential uses one more than the largest serial number of
+	// any certificate already in the keystore, starting at 1. It is only
+	// meaningful for Keystore.GenerateKeypair, since certificates issued
+	// by SignCSR are not kept in the keystore.
+	SerialSequential
+)
+
+var serialStrategyNames = []string{
+	SerialRandom:     "random",
+	SerialTimestamp:  "timestamp",
+	SerialSequential: "sequential",
+}
+
+// String returns the name of the strategy, as accepted by
+// ParseSerialStrategy.
+func (s SerialStrategy) String() string {
+	if s >= 0 && int(s) < len(serialStrategyNames) {
+		return serialStrategyNames[s]
+	}
+	return fmt.Sprintf("SerialStrategy(%d)", int(s))
+}
+
+// ParseSerialStrategy returns the strategy with the given name (e.g.
+// "sequential").
+func ParseSerialStrategy(name string) (SerialStrategy, error) {
+	for s, n := range serialStrategyNames {
+		if n == name {
+			return SerialStrategy(s), nil
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown serial strategy %q "+
+		"(expected one of %s)", name,
+		strings.Join(serialStrategyNames, ", "))
+}
+
+// ParseExtKeyUsage returns the extended key usage with the given RFC 5280 name
+// (e.g. "serverAuth"). Names are matched case-insensitively.
+func ParseExtKeyUsage(name string) (x509.ExtKeyUsage, error) {
+	for eku, n := range ekuNames {
+		if strings.EqualFold(n, name) {
+			return eku, nil
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown extended key usage %q",
+		name)
+}
+
+// Defaults used by GenerateKeypair for zero fields of KeyGenParams.
+const (
+	DefaultRSABits  = 3072
+	DefaultCurve    = "P-256"
+	DefaultValidity = 365 * 24 * time.Hour
+)
+
+// KeyGenParams controls the key and self-signed certificate generated by
+// GenerateKeypair. The zero value gives a 3072-bit RSA key with a certificate
+// for "CN=<alias>" that is valid for a year, usable for TLS servers and
+// clients, and has a random serial number.
+type KeyGenParams struct {
+	// KeyType selects the type of key to generate.
+	KeyType KeyType
+
+	// RSABits is the RSA modulus size: 2048, 3072 or 4096. Zero means
+	// DefaultRSABits.
+	RSABits int
+
+	// Curve names the elliptic curve for EC keys, as in
+	// elliptic.CurveParams (e.g. "P-384"). Empty means DefaultCurve.
+	Curve string
+
+	// NotBefore is the start of the certificate's validity period. Zero
+	// means the time of generation.
+	NotBefore time.Time
+
+	// Validity is the length of the certificate's validity period. Zero
+	// means DefaultValidity.
+	Validity time.Duration
+
+	// Subject is the certificate's subject (and issuer) name. If it has
+	// no common name, the alias is used.
+	Subject pkix.Name
+
+	// DNSNames, IPAddresses and EmailAddresses are the certificate's
+	// subject alternative names.
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+
+	// ExtKeyUsage lists the certificate's extended key usages. If empty,
+	// serverAuth and clientAuth are used, unless IsCA is set.
+	ExtKeyUsage []x509.ExtKeyUsage
+
+	// IsCA makes the certificate a CA certificate, which may be used with
+	// SignCSR or IssueKeypair to issue other certificates.
+	IsCA bool
+
+	// MaxPathLen and MaxPathLenZero set the path length constraint of a
+	// CA certificate, as for x509.Certificate: a MaxPathLen of zero means
+	// there is no constraint, unless MaxPathLenZero is set.
+	MaxPathLen     int
+	MaxPathLenZero bool
+
+	// PermittedDNSDomains, ExcludedDNSDomains, PermittedIPRanges and
+	// ExcludedIPRanges are the name constraints of a CA certificate. If
+	// any are set, the extension is marked critical.
+	PermittedDNSDomains []string
+	ExcludedDNSDomains  []string
+	PermittedIPRanges   []*net.IPNet
+	ExcludedIPRanges    []*net.IPNet
+
+	// Serial selects how the serial number is chosen.
+	Serial SerialStrategy
+}
+
+// GenerateKeypair generates a new private key and a self-signed certificate
+// for it, as described by params (which may be nil for the defaults). The
+// returned Keypair can be added to a Keystore; the key size and curve policy
+// in Options is applied when it is packed.
+func GenerateKeypair(alias string, params *KeyGenParams) (*Keypair, error) {
+	return generateKeypair(alias, params, nil, nil, nil)
+}
+
+// GenerateKeypair generates a keypair as the package-level GenerateKeypair
+// does, and adds it to the keystore. It returns an error if the alias is
+// already in use. This is the only way to use SerialSequential.
+func (ks *Keystore) GenerateKeypair(alias string, params *KeyGenParams,
+) (*Keypair, error) {
+	if ks.hasAlias(alias) {
+		return nil, errorf(CodeDuplicateAlias, "duplicate alias %q",
+			alias)
+	}
+	kp, err := generateKeypair(alias, params, ks, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	ks.Keypairs = append(ks.Keypairs, kp)
+	return kp, nil
+}
+
+// IssueKeypair generates a keypair as GenerateKeypair does, except that its
+// certificate is signed by the CA keypair entry with alias caAlias (see
+// SignCSR) rather than self-signed, and its chain continues with the CA's
+// chain. The new certificate does not outlive the CA certificate. The
+// keypair is added to the keystore.
+func (ks *Keystore) IssueKeypair(caAlias, alias string,
+	params *KeyGenParams,
+) (*Keypair, error) {
+	if ks.hasAlias(alias) {
+		return nil, errorf(CodeDuplicateAlias, "duplicate alias %q",
+			alias)
+	}
+	ca, signer, err := ks.caKeypair(caAlias, x509.KeyUsageCertSign,
+		time.Now())
+	if err != nil {
+		return nil, err
+	}
+	kp, err := generateKeypair(alias, params, ks, ca, signer)
+	if err != nil {
+		return nil, err
+	}
+	ks.Keypairs = append(ks.Keypairs, kp)
+	return kp, nil
+}
+
+// GenerateIntermediateCA issues an intermediate CA keypair, signed by the CA
+// keypair entry with alias caAlias, as IssueKeypair does. params.IsCA is
+// implied; the path length and name constraints are taken from params.
+func (ks *Keystore) GenerateIntermediateCA(caAlias, alias string,
+	params *KeyGenParams,
+) (*Keypair, error) {
+	p := KeyGenParams{}
+	if params != nil {
+		p = *params
+	}
+	p.IsCA = true
+	return ks.IssueKeypair(caAlias, alias, &p)
+}
+
+// generateKeypair does the work for GenerateKeypair and IssueKeypair. The
+// certificate is signed by issuer, or self-signed if issuer is nil.
+func generateKeypair(alias string, params *KeyGenParams, ks *Keystore,
+	issuer *Keypair, issuerKey crypto.Signer,
+) (*Keypair, error) {
+	if params == nil {
+		params = new(KeyGenParams)
+	}
+	now := time.Now()
+
+	var (
+		key      crypto.Signer
+		keyUsage = x509.KeyUsageDigitalSignature
+		err      error
+	)
+	switch params.KeyType {
+	case KeyRSA:
+		bits := params.RSABits
+		switch bits {
+		case 0:
+			bits = DefaultRSABits
+		case 2048, 3072, 4096:
+		default:
+			return nil, errorf(CodeInvalidArgument, "unsupported "+
+				"RSA key size %d (expected 2048, 3072 or 4096)",
+				bits)
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+		keyUsage |= x509.KeyUsageKeyEncipherment
+
+	case KeyEC:
+		name := params.Curve
+		if name == "" {
+			name = DefaultCurve
+		}
+		curve := curveByName(name)
+		if curve == nil {
+			return nil, errorf(CodeInvalidArgument, "unknown "+
+				"curve %q", name)
+		}
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+
+	case KeyEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+
+	default:
+		return nil, errorf(CodeInvalidArgument, "unknown key type %v",
+			params.KeyType)
+	}
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "failed to generate "+
+			"key: %v", err)
+	}
+
+	serial, err := newSerial(params.Serial, now, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := params.NotBefore
+	if notBefore.IsZero() {
+		notBefore = now
+	}
+	validity := params.Validity
+	if validity == 0 {
+		validity = DefaultValidity
+	}
+	subject := params.Subject
+	if subject.CommonName == "" {
+		subject.CommonName = alias
+	}
+	if params.IsCA {
+		keyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	eku := params.ExtKeyUsage
+	if len(eku) == 0 && !params.IsCA {
+		eku = []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		}
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		BasicConstraintsValid: true,
+		IsCA:                  params.IsCA,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           eku,
+		DNSNames:              params.DNSNames,
+		IPAddresses:           params.IPAddresses,
+		EmailAddresses:        params.EmailAddresses,
+	}
+	if params.IsCA {
+		tmpl.MaxPathLen = params.MaxPathLen
+		tmpl.MaxPathLenZero = params.MaxPathLenZero
+		tmpl.PermittedDNSDomains = params.PermittedDNSDomains
+		tmpl.ExcludedDNSDomains = params.ExcludedDNSDomains
+		tmpl.PermittedIPRanges = params.PermittedIPRanges
+		tmpl.ExcludedIPRanges = params.ExcludedIPRanges
+		tmpl.PermittedDNSDomainsCritical =
+			len(params.PermittedDNSDomains) > 0 ||
+				len(params.ExcludedDNSDomains) > 0 ||
+				len(params.PermittedIPRanges) > 0 ||
+				len(params.ExcludedIPRanges) > 0
+	}
+
+	parent, parentKey := tmpl, key
+	if issuer != nil {
+		parent, parentKey = issuer.CertChain[0].Cert, issuerKey
+		if tmpl.NotAfter.After(parent.NotAfter) {
+			tmpl.NotAfter = parent.NotAfter
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent,
+		key.Public(), parentKey)
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "failed to create "+
+			"certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "failed to parse "+
+			"certificate: %v", err)
+	}
+
+	kp := &Keypair{
+		Alias:      alias,
+		Timestamp:  now,
+		PrivateKey: key,
+		CertChain: []*KeypairCert{{
+			Raw:  der,
+			Cert: cert,
+		}},
+	}
+	if issuer != nil {
+		kp.CertChain = append(kp.CertChain, issuer.CertChain...)
+	}
+	return kp, nil
+}
+
+// newSerial returns a certificate serial number chosen by strategy. ks is nil
+// if there is no keystore to consult.
+func newSerial(strategy SerialStrategy, now time.Time, ks *Keystore,
+) (*big.Int, error) {
+	switch strategy {
+	case SerialRandom:
+		serial, err := rand.Int(rand.Reader,
+			new(big.Int).Lsh(big.NewInt(1), 127))
+		if err != nil {
+			return nil, errorf(CodeCryptoFailure, "failed to "+
+				"generate serial number: %v", err)
+		}
+		// serial numbers must be positive
+		return serial.Add(serial, big.NewInt(1)), nil
+
+	case SerialTimestamp:
+		return big.NewInt(now.UnixNano()), nil
+
+	case SerialSequential:
+		if ks == nil {
+			return nil, errorf(CodeInvalidArgument, "%v serial "+
+				"numbers need a keystore", strategy)
+		}
+		serial := new(big.Int)
+		ks.eachCert(func(_ certLocation, cert *x509.Certificate) {
+			if cert.SerialNumber.Cmp(serial) > 0 {
+				serial.Set(cert.SerialNumber)
+			}
+		})
+		return serial.Add(serial, big.NewInt(1)), nil
+	}
+	return nil, errorf(CodeInvalidArgument, "unknown serial strategy %v",
+		strategy)
+}
@@ -0,0 +1,129 @@
+package jks_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+)
+
+// TestGenerateKeypair checks that GenerateKeypair honours its parameters and
+// that the result can be packed and parsed back.
+func TestGenerateKeypair(t *testing.T) {
+	t.Run("defaults", testGenerateKeypair(nil, func(t *testing.T,
+		cert *x509.Certificate, key interface{}) {
+		if k, ok := key.(*rsa.PrivateKey); !ok ||
+			k.N.BitLen() != jks.DefaultRSABits {
+			t.Errorf("expected %d-bit RSA key", jks.DefaultRSABits)
+		}
+		if cert.Subject.CommonName != "test" {
+			t.Errorf("common name %q ≠ expected %q",
+				cert.Subject.CommonName, "test")
+		}
+		if len(cert.ExtKeyUsage) != 2 {
+			t.Errorf("got %d extended key usages ≠ expected 2",
+				len(cert.ExtKeyUsage))
+		}
+	}))
+
+	notBefore := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	t.Run("EC", testGenerateKeypair(&jks.KeyGenParams{
+		KeyType:     jks.KeyEC,
+		Curve:       "P-384",
+		NotBefore:   notBefore,
+		Validity:    48 * time.Hour,
+		Subject:     pkix.Name{CommonName: "svc"},
+		DNSNames:    []string{"svc.example.org"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		Serial:      jks.SerialTimestamp,
+	}, func(t *testing.T, cert *x509.Certificate, key interface{}) {
+		if k, ok := key.(*ecdsa.PrivateKey); !ok ||
+			k.Params().Name != "P-384" {
+			t.Errorf("expected P-384 key")
+		}
+		if !cert.NotBefore.Equal(notBefore) ||
+			!cert.NotAfter.Equal(notBefore.Add(48*time.Hour)) {
+			t.Errorf("unexpected validity %v–%v", cert.NotBefore,
+				cert.NotAfter)
+		}
+		if cert.Subject.CommonName != "svc" ||
+			len(cert.DNSNames) != 1 || len(cert.ExtKeyUsage) != 1 {
+			t.Errorf("unexpected certificate contents")
+		}
+	}))
+
+	t.Run("Ed25519", testGenerateKeypair(&jks.KeyGenParams{
+		KeyType: jks.KeyEd25519,
+	}, func(t *testing.T, cert *x509.Certificate, key interface{}) {
+		if _, ok := key.(ed25519.PrivateKey); !ok {
+			t.Errorf("expected Ed25519 key")
+		}
+		if cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+			t.Errorf("unexpected key encipherment usage")
+		}
+	}))
+
+	if _, err := jks.GenerateKeypair("bad", &jks.KeyGenParams{
+		RSABits: 1024,
+	}); err == nil {
+		t.Error("expected error for 1024-bit RSA key")
+	}
+}
+
+func testGenerateKeypair(params *jks.KeyGenParams, check func(*testing.T,
+	*x509.Certificate, interface{})) func(*testing.T) {
+	return func(t *testing.T) {
+		ks := new(jks.Keystore)
+		if _, err := ks.GenerateKeypair("test", params); err != nil {
+			t.Fatalf("GenerateKeypair: %v", err)
+		}
+		// Java17, since older runtimes cannot load Ed25519 keys
+		opts := &jks.Options{
+			Password:      "password",
+			Compatibility: jks.Java17,
+		}
+		raw, err := ks.Pack(opts)
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		if ks, err = jks.Parse(raw, opts); err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		kp := ks.Keypairs[0]
+		if kp.PrivKeyErr != nil {
+			t.Fatalf("private key: %v", kp.PrivKeyErr)
+		}
+		check(t, kp.CertChain[0].Cert, kp.PrivateKey)
+	}
+}
+
+// TestSerialSequential checks that sequential serial numbers follow on from
+// those already in the keystore.
+func TestSerialSequential(t *testing.T) {
+	ks := new(jks.Keystore)
+	params := &jks.KeyGenParams{
+		KeyType: jks.KeyEC,
+		Serial:  jks.SerialSequential,
+	}
+	for i, alias := range []string{"a", "b", "c"} {
+		kp, err := ks.GenerateKeypair(alias, params)
+		if err != nil {
+			t.Fatalf("GenerateKeypair: %v", err)
+		}
+		serial := kp.CertChain[0].Cert.SerialNumber.Int64()
+		if serial != int64(i+1) {
+			t.Errorf("serial %d ≠ expected %d", serial, i+1)
+		}
+	}
+	if _, err := ks.GenerateKeypair("a", params); err == nil {
+		t.Error("expected error for duplicate alias")
+	}
+	if _, err := jks.GenerateKeypair("d", params); err == nil {
+		t.Error("expected error without a keystore")
+	}
+}
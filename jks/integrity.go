@@ -0,0 +1,38 @@
+package jks
+
+import "fmt"
+
+// Integrity records whether the digest of the file a Keystore was parsed from
+// was checked, and whether it matched. It lets callers who parse without the
+// keystore password (see Options.SkipVerifyDigest), to list aliases or extract
+// the certificates, tell unauthenticated entries from verified ones.
+type Integrity int
+
+const (
+	// IntegrityUnchecked means that the digest was not checked: the
+	// keystore was not parsed, or was parsed with SkipVerifyDigest set
+	// (or with nil Options), or parsing failed before the digest was
+	// reached.
+	IntegrityUnchecked Integrity = iota
+
+	// IntegrityVerified means that the digest matched the password.
+	IntegrityVerified
+
+	// IntegrityMismatch means that the digest did not match, and Parse
+	// returned ErrDigestMismatch. The entries must not be relied upon.
+	IntegrityMismatch
+)
+
+var integrityNames = []string{
+	IntegrityUnchecked: "unchecked",
+	IntegrityVerified:  "verified",
+	IntegrityMismatch:  "mismatch",
+}
+
+// String returns a short description of i, such as "verified".
+func (i Integrity) String() string {
+	if i >= 0 && int(i) < len(integrityNames) {
+		return integrityNames[i]
+	}
+	return fmt.Sprintf("Integrity(%d)", int(i))
+}
@@ -0,0 +1,503 @@
+package jks
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Java object serialization stream constants, from the "Object Serialization
+// Stream Protocol" chapter of the Java Object Serialization Specification.
+const (
+	javaStreamMagic   = 0xACED
+	javaStreamVersion = 5
+
+	tcNull           = 0x70
+	tcReference      = 0x71
+	tcClassDesc      = 0x72
+	tcObject         = 0x73
+	tcString         = 0x74
+	tcArray          = 0x75
+	tcBlockData      = 0x77
+	tcEndBlockData   = 0x78
+	tcBlockDataLong  = 0x7A
+	tcLongString     = 0x7C
+	tcEnum           = 0x7E
+	javaBaseHandle   = 0x7E0000
+	javaMaxDepth     = 32
+	scWriteMethod    = 0x01
+	scSerializable   = 0x02
+	scExternalizable = 0x04
+)
+
+// javaObject is an object read from a Java serialization stream. Fields holds
+// the serialized fields of every class in its hierarchy, by name. Values are
+// nil, string (for strings and enum constants), []byte (for byte arrays),
+// []interface{} (for object arrays), *javaObject, or a primitive value widened
+// to int64 or float64.
+type javaObject struct {
+	Class  string
+	Fields map[string]interface{}
+}
+
+// javaClassDesc is a class descriptor read from a Java serialization stream.
+type javaClassDesc struct {
+	name   string
+	flags  byte
+	fields []javaField
+	super  *javaClassDesc
+}
+
+type javaField struct {
+	typeCode byte
+	name     string
+}
+
+// javaDecoder reads the subset of the Java object serialization protocol
+// needed for the sealed keys in JCEKS files: objects with default field
+// serialization, strings, arrays and enums, and back references to these.
+// Custom writeObject data is skipped, and externalizable objects, proxy
+// classes and exceptions are rejected.
+type javaDecoder struct {
+	data    []byte
+	pos     int
+	handles []interface{}
+	depth   int
+}
+
+// decodeJavaStream reads the stream header and the first object from data,
+// returning that object and the number of bytes consumed.
+func decodeJavaStream(data []byte) (interface{}, int, error) {
+	d := &javaDecoder{data: data}
+	magic, err := d.uint16()
+	if err != nil {
+		return nil, 0, err
+	}
+	version, err := d.uint16()
+	if err != nil {
+		return nil, 0, err
+	}
+	if magic != javaStreamMagic || version != javaStreamVersion {
+		return nil, 0, errorf(CodeMalformed, "java serialization: "+
+			"bad stream header %04X %04X", magic, version)
+	}
+	obj, err := d.content()
+	if err != nil {
+		return nil, 0, err
+	}
+	return obj, d.pos, nil
+}
+
+func (d *javaDecoder) errorf(format string, args ...interface{}) error {
+	return errorf(CodeMalformed, "java serialization: "+format+
+		" at offset %d", append(args, d.pos)...)
+}
+
+func (d *javaDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errorf(CodeTruncated, "java serialization: "+
+			"unexpected end of data at offset %d", d.pos)
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *javaDecoder) byte() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *javaDecoder) uint16() (uint16, error) {
+	b, err := d.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (d *javaDecoder) uint32() (uint32, error) {
+	b, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (d *javaDecoder) uint64() (uint64, error) {
+	b, err := d.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// utf reads a string in modified UTF-8 with a 16-bit length prefix.
+func (d *javaDecoder) utf() (string, error) {
+	n, err := d.uint16()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(int(n))
+	if err != nil {
+		return "", err
+	}
+	s, ok := decodeModifiedUTF8(b)
+	if !ok {
+		return "", d.errorf("invalid modified UTF-8 string")
+	}
+	return s, nil
+}
+
+func (d *javaDecoder) newHandle(v interface{}) int {
+	d.handles = append(d.handles, v)
+	return len(d.handles) - 1
+}
+
+func (d *javaDecoder) reference() (interface{}, error) {
+	h, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	i := int64(h) - javaBaseHandle
+	if i < 0 || i >= int64(len(d.handles)) {
+		return nil, d.errorf("invalid handle %08X", h)
+	}
+	return d.handles[i], nil
+}
+
+// content reads one object, string, array, enum constant, null or back
+// reference.
+func (d *javaDecoder) content() (interface{}, error) {
+	if d.depth++; d.depth > javaMaxDepth {
+		return nil, d.errorf("nesting too deep")
+	}
+	defer func() { d.depth-- }()
+
+	tc, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch tc {
+	case tcNull:
+		return nil, nil
+
+	case tcReference:
+		return d.reference()
+
+	case tcString:
+		s, err := d.utf()
+		if err != nil {
+			return nil, err
+		}
+		d.newHandle(s)
+		return s, nil
+
+	case tcLongString:
+		n, err := d.uint64()
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt32 {
+			return nil, d.errorf("string too long")
+		}
+		b, err := d.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		d.newHandle(string(b))
+		return string(b), nil
+
+	case tcObject:
+		return d.object()
+
+	case tcArray:
+		return d.array()
+
+	case tcEnum:
+		if _, err := d.classDesc(); err != nil {
+			return nil, err
+		}
+		h := d.newHandle(nil)
+		v, err := d.content()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := v.(string)
+		if !ok {
+			return nil, d.errorf("enum constant name is not a " +
+				"string")
+		}
+		d.handles[h] = name
+		return name, nil
+
+	default:
+		return nil, d.errorf("unsupported type code %02X", tc)
+	}
+}
+
+// classDesc reads a class descriptor, which may be null or a back reference.
+func (d *javaDecoder) classDesc() (*javaClassDesc, error) {
+	if d.depth++; d.depth > javaMaxDepth {
+		return nil, d.errorf("nesting too deep")
+	}
+	defer func() { d.depth-- }()
+
+	tc, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch tc {
+	case tcNull:
+		return nil, nil
+
+	case tcReference:
+		v, err := d.reference()
+		if err != nil {
+			return nil, err
+		}
+		desc, ok := v.(*javaClassDesc)
+		if !ok {
+			return nil, d.errorf("reference is not a class " +
+				"descriptor")
+		}
+		return desc, nil
+
+	case tcClassDesc:
+	default:
+		return nil, d.errorf("unsupported class descriptor type "+
+			"code %02X", tc)
+	}
+
+	desc := new(javaClassDesc)
+	if desc.name, err = d.utf(); err != nil {
+		return nil, err
+	}
+	if _, err = d.uint64(); err != nil { // serialVersionUID
+		return nil, err
+	}
+	d.newHandle(desc)
+	if desc.flags, err = d.byte(); err != nil {
+		return nil, err
+	}
+	n, err := d.uint16()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(n); i++ {
+		var f javaField
+		if f.typeCode, err = d.byte(); err != nil {
+			return nil, err
+		}
+		if f.name, err = d.utf(); err != nil {
+			return nil, err
+		}
+		if f.typeCode == '[' || f.typeCode == 'L' {
+			// the field's class name, as a string or reference
+			if _, err = d.content(); err != nil {
+				return nil, err
+			}
+		}
+		desc.fields = append(desc.fields, f)
+	}
+	if err = d.skipAnnotation(); err != nil {
+		return nil, err
+	}
+	if desc.super, err = d.classDesc(); err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// skipAnnotation skips block data and objects up to the end block marker.
+func (d *javaDecoder) skipAnnotation() error {
+	for {
+		if d.pos >= len(d.data) {
+			_, err := d.next(1)
+			return err
+		}
+		switch d.data[d.pos] {
+		case tcEndBlockData:
+			d.pos++
+			return nil
+
+		case tcBlockData:
+			d.pos++
+			n, err := d.byte()
+			if err != nil {
+				return err
+			}
+			if _, err = d.next(int(n)); err != nil {
+				return err
+			}
+
+		case tcBlockDataLong:
+			d.pos++
+			n, err := d.uint32()
+			if err != nil {
+				return err
+			}
+			if n > math.MaxInt32 {
+				return d.errorf("block data too long")
+			}
+			if _, err = d.next(int(n)); err != nil {
+				return err
+			}
+
+		default:
+			if _, err := d.content(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// object reads an object's class descriptor and field values.
+func (d *javaDecoder) object() (*javaObject, error) {
+	desc, err := d.classDesc()
+	if err != nil {
+		return nil, err
+	}
+	if desc == nil {
+		return nil, d.errorf("object has no class")
+	}
+	obj := &javaObject{
+		Class:  desc.name,
+		Fields: make(map[string]interface{}),
+	}
+	d.newHandle(obj)
+
+	// class data is written for the topmost superclass first; the depth
+	// limit also stops a back reference from making the hierarchy a loop
+	var hier []*javaClassDesc
+	for c := desc; c != nil; c = c.super {
+		if len(hier) == javaMaxDepth {
+			return nil, d.errorf("class hierarchy too deep")
+		}
+		hier = append(hier, c)
+	}
+	for i := len(hier) - 1; i >= 0; i-- {
+		c := hier[i]
+		if c.flags&scExternalizable != 0 {
+			return nil, d.errorf("externalizable class %s is not "+
+				"supported", c.name)
+		}
+		if c.flags&scSerializable == 0 {
+			continue
+		}
+		for _, f := range c.fields {
+			v, err := d.value(f.typeCode)
+			if err != nil {
+				return nil, err
+			}
+			obj.Fields[f.name] = v
+		}
+		if c.flags&scWriteMethod != 0 {
+			if err := d.skipAnnotation(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return obj, nil
+}
+
+// array reads an array. Byte arrays are returned as []byte, and arrays of
+// other primitive types are skipped.
+func (d *javaDecoder) array() (interface{}, error) {
+	desc, err := d.classDesc()
+	if err != nil {
+		return nil, err
+	}
+	if desc == nil || len(desc.name) < 2 || desc.name[0] != '[' {
+		return nil, d.errorf("array has no array class")
+	}
+	h := d.newHandle(nil)
+	n, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if n > math.MaxInt32 {
+		return nil, d.errorf("array too long")
+	}
+
+	elem := desc.name[1]
+	if elem == 'B' {
+		b, err := d.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		arr := append([]byte(nil), b...)
+		d.handles[h] = arr
+		return arr, nil
+	}
+	if size := javaPrimitiveSize(elem); size != 0 {
+		if int(n) > (len(d.data)-d.pos)/size {
+			_, err := d.next(len(d.data) - d.pos + 1)
+			return nil, err
+		}
+		d.pos += int(n) * size
+		return nil, nil
+	}
+
+	var arr []interface{}
+	for i := 0; i < int(n); i++ {
+		v, err := d.content()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	d.handles[h] = arr
+	return arr, nil
+}
+
+// value reads a field value with the given type code.
+func (d *javaDecoder) value(typeCode byte) (interface{}, error) {
+	if typeCode == '[' || typeCode == 'L' {
+		return d.content()
+	}
+	size := javaPrimitiveSize(typeCode)
+	if size == 0 {
+		return nil, d.errorf("unknown field type code %q", typeCode)
+	}
+	b, err := d.next(size)
+	if err != nil {
+		return nil, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	switch typeCode {
+	case 'F':
+		return float64(math.Float32frombits(uint32(u))), nil
+	case 'D':
+		return math.Float64frombits(u), nil
+	case 'B':
+		return int64(int8(u)), nil
+	case 'S':
+		return int64(int16(u)), nil
+	case 'I':
+		return int64(int32(u)), nil
+	default: // C, J, Z
+		return int64(u), nil
+	}
+}
+
+// javaPrimitiveSize returns the encoded size of a primitive type, or 0 if
+// typeCode is not that of a primitive type.
+func javaPrimitiveSize(typeCode byte) int {
+	switch typeCode {
+	case 'B', 'Z':
+		return 1
+	case 'C', 'S':
+		return 2
+	case 'I', 'F':
+		return 4
+	case 'J', 'D':
+		return 8
+	}
+	return 0
+}
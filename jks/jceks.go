@@ -0,0 +1,152 @@
+package jks
+
+import "time"
+
+// JCEKSMagicNumber is written at the start of each JCEKS file. JCEKS is the
+// format of the JDK's SunJCE provider: it is laid out like JKS, but protects
+// private keys with PBEWithMD5AndTripleDES and may also hold secret keys.
+const JCEKSMagicNumber uint32 = 0xCECECECE
+
+// sealAlgorithm is the only algorithm the JDK uses to seal secret keys in
+// JCEKS files.
+const sealAlgorithm = "PBEWithMD5AndTripleDES"
+
+// SecretKey holds a symmetric key (e.g. an AES or HMAC key) read from a JCEKS
+// file. JKS files cannot hold secret keys, so Pack refuses a Keystore which
+// has any.
+type SecretKey struct {
+	// Alias is a name used to refer to this key.
+	Alias string
+
+	// Timestamp records when this record was created, to the
+	// millisecond as for Cert.
+	Timestamp time.Time
+
+	// SealedKey is the key as stored in the file: a serialized Java
+	// javax.crypto.SealedObject.
+	SealedKey []byte
+
+	// Algorithm is the key's algorithm name (e.g. "AES" or "HmacSHA256").
+	// It will not have been set if unsealing failed.
+	Algorithm string
+
+	// Key is the raw key material. It will not have been set if unsealing
+	// failed.
+	Key []byte
+
+	// KeyErr is set if an error is encountered while decrypting or
+	// unmarshalling the sealed key.
+	KeyErr error
+}
+
+// readSecretKey reads a JCEKS secret key entry, and attempts to unseal it with
+// the password for its alias.
+func readSecretKey(buf *stream, opts *Options) (*SecretKey, error) {
+	var (
+		err error
+		sk  = new(SecretKey)
+	)
+
+	sk.Alias, _, err = readStr(buf, "secret key alias")
+	if err != nil {
+		return nil, err
+	}
+
+	sk.Timestamp, _, err = readTimestamp(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sealed key is a Java serialization stream, so the only way to
+	// find its length is to parse it; read ahead no further than the
+	// largest key we would accept
+	offset := buf.off
+	max := int64(opts.maxKeySize())
+	rest, err := buf.readAhead(max)
+	if err != nil {
+		return nil, err
+	}
+	sealed, n, err := decodeJavaStream(rest)
+	switch {
+	case err != nil && int64(len(rest)) == max:
+		return nil, errorf(CodeTooLarge, "secret key %q at position "+
+			"%d is malformed or beyond the maximum of %d bytes: %v",
+			sk.Alias, offset, max, err)
+	case err != nil:
+		return nil, errorf("", "secret key %q at position %d: %v",
+			sk.Alias, offset, err)
+	}
+	if sk.SealedKey, err = buf.read(int64(n)); err != nil {
+		return nil, err
+	}
+
+	if opts.skipEntry(sk.Alias, true) {
+		return sk, nil
+	}
+	passwd, err := opts.keyPassword(sk.Alias)
+	if err != nil {
+		sk.KeyErr = err
+		return sk, nil
+	}
+	sk.Algorithm, sk.Key, sk.KeyErr = unsealSecretKey(sealed, passwd)
+	return sk, nil
+}
+
+// unsealSecretKey decrypts a javax.crypto.SealedObject holding a secret key,
+// and returns the key's algorithm and raw material.
+func unsealSecretKey(sealed interface{}, password []byte) (string, []byte,
+	error,
+) {
+	obj, ok := sealed.(*javaObject)
+	if !ok {
+		return "", nil, newError(CodeMalformed, "sealed key is not "+
+			"an object")
+	}
+	sealAlg, _ := obj.Fields["sealAlg"].(string)
+	params, _ := obj.Fields["encodedParams"].([]byte)
+	content, _ := obj.Fields["encryptedContent"].([]byte)
+	if sealAlg != sealAlgorithm {
+		return "", nil, errorf(CodeUnsupportedKeyAlg, "unhandled "+
+			"seal algorithm %q", sealAlg)
+	}
+	salt, iterations, err := parsePBEParameter(params)
+	if err != nil {
+		return "", nil, err
+	}
+	plaintext, err := decryptJavaKeyEncryption2(content, password, salt,
+		iterations)
+	if err != nil {
+		return "", nil, err
+	}
+	defer clear(plaintext)
+
+	v, _, err := decodeJavaStream(plaintext)
+	if err != nil {
+		return "", nil, err
+	}
+	key, ok := v.(*javaObject)
+	if !ok {
+		return "", nil, newError(CodeMalformed, "sealed key does not "+
+			"hold an object")
+	}
+	alg, _ := key.Fields["algorithm"].(string)
+	switch key.Class {
+	case "javax.crypto.spec.SecretKeySpec":
+		raw, _ := key.Fields["key"].([]byte)
+		return alg, raw, nil
+
+	case "java.security.KeyRep":
+		// used by key classes which serialize via writeReplace
+		format, _ := key.Fields["format"].(string)
+		if format != "RAW" {
+			return "", nil, errorf(CodeUnsupportedKeyAlg,
+				"unhandled secret key format %q", format)
+		}
+		raw, _ := key.Fields["encoded"].([]byte)
+		return alg, raw, nil
+
+	default:
+		return "", nil, errorf(CodeUnsupportedKeyAlg, "unhandled "+
+			"secret key class %s", key.Class)
+	}
+}
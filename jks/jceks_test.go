@@ -0,0 +1,371 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// javaStream builds Java serialization streams for tests.
+type javaStream struct {
+	bytes.Buffer
+}
+
+func (j *javaStream) b(b ...byte) *javaStream {
+	j.Write(b)
+	return j
+}
+
+func (j *javaStream) utf(s string) *javaStream {
+	binary.Write(j, binary.BigEndian, uint16(len(s)))
+	j.WriteString(s)
+	return j
+}
+
+func (j *javaStream) u32(u uint32) *javaStream {
+	binary.Write(j, binary.BigEndian, u)
+	return j
+}
+
+func (j *javaStream) u64(u uint64) *javaStream {
+	binary.Write(j, binary.BigEndian, u)
+	return j
+}
+
+// byteArray writes a byte array with a new "[B" class descriptor.
+func (j *javaStream) byteArray(b []byte) *javaStream {
+	j.b(tcArray, tcClassDesc).utf("[B").u64(0xACF317F8060854E0).
+		b(scSerializable, 0, 0, tcEndBlockData, tcNull)
+	j.u32(uint32(len(b)))
+	j.Write(b)
+	return j
+}
+
+// sealedKeyStream returns a serialized SealedObjectForKeyProtector, as the JDK
+// writes for a JCEKS secret key entry. The second byte array and string use
+// back references to class descriptors and strings written earlier.
+func sealedKeyStream(params, content []byte) []byte {
+	var j javaStream
+	j.b(0xAC, 0xED, 0, 5, tcObject, tcClassDesc).
+		utf("com.sun.crypto.provider.SealedObjectForKeyProtector").
+		u64(0xCD57CA59E730BB53).b(scSerializable, 0, 0, tcEndBlockData)
+	j.b(tcClassDesc).utf("javax.crypto.SealedObject").
+		u64(0x3E363DA6C3B75470).b(scSerializable, 0, 4)
+	j.b('[').utf("encodedParams").b(tcString).utf("[B")
+	j.b('[').utf("encryptedContent").b(tcReference).u32(0x7E0002)
+	j.b('L').utf("paramsAlg").b(tcString).utf("Ljava/lang/String;")
+	j.b('L').utf("sealAlg").b(tcReference).u32(0x7E0003)
+	j.b(tcEndBlockData, tcNull)
+	// handle 4 is the object; 5 the [B class; 6 the first array
+	j.byteArray(params)
+	j.b(tcArray, tcReference).u32(0x7E0005).u32(uint32(len(content)))
+	j.Write(content)
+	j.b(tcString).utf(sealAlgorithm)
+	j.b(tcReference).u32(0x7E0008)
+	return j.Bytes()
+}
+
+// secretKeySpecStream returns a serialized javax.crypto.spec.SecretKeySpec.
+func secretKeySpecStream(alg string, key []byte) []byte {
+	var j javaStream
+	j.b(0xAC, 0xED, 0, 5, tcObject, tcClassDesc).
+		utf("javax.crypto.spec.SecretKeySpec").
+		u64(0x5B470B66E2305F4D).b(scSerializable, 0, 2)
+	j.b('L').utf("algorithm").b(tcString).utf("Ljava/lang/String;")
+	j.b('[').utf("key").b(tcString).utf("[B")
+	j.b(tcEndBlockData, tcNull)
+	j.b(tcString).utf(alg)
+	j.byteArray(key)
+	return j.Bytes()
+}
+
+// encryptJavaKeyEncryption2 is the inverse of DecryptJavaKeyEncryption2. It
+// returns the DER PBEParameter and the ciphertext.
+func encryptJavaKeyEncryption2(t *testing.T, plaintext []byte,
+	password string,
+) ([]byte, []byte) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatal(err)
+	}
+	params, err := asn1.Marshal(struct {
+		Salt       []byte
+		Iterations int
+	}{salt, 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, iv, err := javaKeyEncryption2Key([]byte(password), salt, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := des.BlockSize - len(plaintext)%des.BlockSize
+	data := append(append([]byte(nil), plaintext...),
+		bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return params, data
+}
+
+// TestParseJCEKS builds a JCEKS file holding a trusted certificate, a
+// private key protected with PBEWithMD5AndTripleDES and a sealed AES key, and
+// checks that Parse recovers each of them.
+func TestParseJCEKS(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{}, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki, err := MarshalPKCS8(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesKey := bytes.Repeat([]byte{0x42}, 32)
+	ts := time.Unix(1700000000, 0)
+
+	var b []byte
+	b = appendUint32(b, JCEKSMagicNumber)
+	b = appendUint32(b, 2)
+	b = appendUint32(b, 3)
+
+	b, err = appendCert(b, &Cert{
+		Alias: "ca", Timestamp: ts, Raw: der,
+	}, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, ciphertext := encryptJavaKeyEncryption2(t, pki, "keypass")
+	epki, err := (&EncryptedPrivateKeyInfo{
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm:  JavaKeyEncryptionOID2,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: ciphertext,
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = appendUint32(b, 1)
+	b, _ = appendStr(b, "server")
+	b = appendTimestamp(b, ts)
+	b = appendUint32(b, uint32(len(epki)))
+	b = append(b, epki...)
+	b = appendUint32(b, 1)
+	b, _ = appendStr(b, CertType)
+	b = appendUint32(b, uint32(len(der)))
+	b = append(b, der...)
+
+	params, ciphertext = encryptJavaKeyEncryption2(t,
+		secretKeySpecStream("AES", aesKey), "password")
+	sealed := sealedKeyStream(params, ciphertext)
+	b = appendUint32(b, 3)
+	b, _ = appendStr(b, "aes")
+	b = appendTimestamp(b, ts)
+	b = append(b, sealed...)
+
+	raw := append(b, ComputeDigest(b, "password")...)
+
+	opts := &Options{
+		Password:     "password",
+		KeyPasswords: map[string]string{"server": "keypass"},
+	}
+	ks, err := Parse(raw, opts)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	switch {
+	case len(ks.Certs) != 1 || ks.Certs[0].Cert == nil:
+		t.Errorf("certificate not parsed")
+	case len(ks.Keypairs) != 1 || ks.Keypairs[0].PrivKeyErr != nil:
+		t.Errorf("private key not parsed: %+v", ks.Keypairs)
+	case !priv.Equal(ks.Keypairs[0].PrivateKey):
+		t.Errorf("private key mismatch")
+	case len(ks.SecretKeys) != 1:
+		t.Fatalf("got %d secret keys ≠ expected 1",
+			len(ks.SecretKeys))
+	}
+
+	sk := ks.SecretKeys[0]
+	switch {
+	case sk.KeyErr != nil:
+		t.Errorf("secret key: %v", sk.KeyErr)
+	case sk.Alias != "aes" || !sk.Timestamp.Equal(ts):
+		t.Errorf("secret key alias %q, timestamp %v", sk.Alias,
+			sk.Timestamp)
+	case sk.Algorithm != "AES" || !bytes.Equal(sk.Key, aesKey):
+		t.Errorf("secret key %s %X ≠ expected AES %X", sk.Algorithm,
+			sk.Key, aesKey)
+	case !bytes.Equal(sk.SealedKey, sealed):
+		t.Errorf("sealed key not recorded")
+	}
+
+	if m := ks.Manifest(); m.Entries[0].Type != ManifestSecretKey {
+		t.Errorf("manifest entry type %q ≠ expected %q",
+			m.Entries[0].Type, ManifestSecretKey)
+	}
+	if _, err := ks.Pack(opts); !errors.Is(err, errSecretKeys) {
+		t.Errorf("Pack: expected errSecretKeys but got %v", err)
+	}
+
+	// ParseFrom must read ahead to find the end of the sealed key
+	ks, err = ParseFrom(iotest.OneByteReader(bytes.NewReader(raw)), opts)
+	switch {
+	case err != nil:
+		t.Errorf("ParseFrom: %v", err)
+	case len(ks.SecretKeys) != 1 ||
+		!bytes.Equal(ks.SecretKeys[0].Key, aesKey):
+		t.Errorf("ParseFrom: secret key not recovered")
+	}
+
+	// with the wrong key passwords, the entries are still read
+	ks, err = Parse(raw, &Options{Password: "password"})
+	switch {
+	case err != nil:
+		t.Errorf("Parse with wrong key password: %v", err)
+	case ks.Keypairs[0].PrivKeyErr == nil:
+		t.Errorf("expected private key error")
+	}
+	ks, err = Parse(raw, &Options{
+		Password:     "password",
+		KeyPasswords: map[string]string{"aes": "wrong"},
+	})
+	switch {
+	case err != nil:
+		t.Errorf("Parse with wrong secret key password: %v", err)
+	case ks.SecretKeys[0].KeyErr == nil:
+		t.Errorf("expected secret key error")
+	}
+
+	// deleting another entry must keep the secret key
+	if ks, err = Parse(raw, opts); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var cs ChangeSet
+	cs.Delete("ca")
+	if err = cs.Apply(ks, opts); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	switch {
+	case len(ks.Certs) != 0 || len(ks.Keypairs) != 1:
+		t.Errorf("after delete: %d certs, %d keypairs", len(ks.Certs),
+			len(ks.Keypairs))
+	case len(ks.SecretKeys) != 1 ||
+		!bytes.Equal(ks.SecretKeys[0].Key, aesKey):
+		t.Errorf("after delete: secret key lost")
+	}
+}
+
+// TestParseJCEKSLimit checks that a sealed secret key longer than MaxKeySize
+// is refused, without reading further ahead than the limit.
+func TestParseJCEKSLimit(t *testing.T) {
+	params, ciphertext := encryptJavaKeyEncryption2(t,
+		secretKeySpecStream("AES", make([]byte, 32)), "password")
+	sealed := sealedKeyStream(params, ciphertext)
+
+	var b []byte
+	b = appendUint32(b, JCEKSMagicNumber)
+	b = appendUint32(b, 2)
+	b = appendUint32(b, 1)
+	b = appendUint32(b, 3)
+	b, _ = appendStr(b, "aes")
+	b = appendTimestamp(b, time.Unix(1700000000, 0))
+	b = append(b, sealed...)
+	raw := append(b, ComputeDigest(b, "password")...)
+
+	opts := &Options{Password: "password", MaxKeySize: len(sealed)}
+	if _, err := ParseFrom(bytes.NewReader(raw), opts); err != nil {
+		t.Errorf("exact limit: %v", err)
+	}
+	opts.MaxKeySize--
+	if _, err := ParseFrom(bytes.NewReader(raw), opts); !errors.Is(err,
+		ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge but got %v", err)
+	}
+}
+
+// TestJavaKeyEncryption2Salt checks the JDK's treatment of a salt whose two
+// halves are equal, in which only the first three bytes of the first half are
+// rotated.
+func TestJavaKeyEncryption2Salt(t *testing.T) {
+	salt := []byte{1, 2, 3, 4, 1, 2, 3, 4}
+	key, iv, err := javaKeyEncryption2Key([]byte("pw"), salt, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, expIV, _ := javaKeyEncryption2Key([]byte("pw"),
+		[]byte{4, 1, 2, 4, 1, 2, 3, 4}, 1)
+	if !bytes.Equal(key, exp) || !bytes.Equal(iv, expIV) {
+		t.Errorf("key %X ≠ expected %X", key, exp)
+	}
+	if !bytes.Equal(salt, []byte{1, 2, 3, 4, 1, 2, 3, 4}) {
+		t.Errorf("salt modified")
+	}
+	_, _, err = javaKeyEncryption2Key([]byte("pässword"), salt, 1)
+	if err == nil {
+		t.Error("expected error for non-ASCII password")
+	}
+}
+
+// TestDecryptJavaKeyEncryption2Args checks that a bad salt or iteration count
+// is reported as an error rather than causing a panic.
+func TestDecryptJavaKeyEncryption2Args(t *testing.T) {
+	ciphertext := make([]byte, des.BlockSize)
+	for _, tc := range []struct {
+		name       string
+		salt       []byte
+		iterations int
+	}{
+		{"zero-iterations", make([]byte, 8), 0},
+		{"too-many-iterations", make([]byte, 8), pbeMaxIterations + 1},
+		{"short-salt", make([]byte, 3), 1},
+		{"long-salt", make([]byte, 9), 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecryptJavaKeyEncryption2(ciphertext, "pw",
+				tc.salt, tc.iterations)
+			if code := ErrorCode(err); code != CodeInvalidArgument {
+				t.Errorf("error code %s ≠ expected %s (%v)",
+					code, CodeInvalidArgument, err)
+			}
+		})
+	}
+}
+
+// FuzzDecodeJavaStream checks that decoding a Java serialization stream never
+// panics, since the stream is read from the keystore before the key password
+// is checked.
+func FuzzDecodeJavaStream(f *testing.F) {
+	f.Add(sealedKeyStream([]byte{4, 0}, make([]byte, 16)))
+	f.Add(secretKeySpecStream("AES", make([]byte, 16)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, n, err := decodeJavaStream(data)
+		if err == nil && (n <= 0 || n > len(data)) {
+			t.Errorf("consumed %d bytes of %d", n, len(data))
+		}
+	})
+}
@@ -6,8 +6,11 @@ package jks
 import (
 	"crypto/sha1"
 	"crypto/x509"
+	"encoding/asn1"
+	"hash"
 	"time"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
 const (
@@ -36,20 +39,75 @@ type Keystore struct {
 	// Keypairs is a list of private keys. Each key may have a certificate
 	// chain associated with it.
 	Keypairs []*Keypair
+
+	// SecretKeys is a list of symmetric keys. Only JCEKS files hold these;
+	// see SecretKey.
+	SecretKeys []*SecretKey
+
+	// UnknownEntries holds entries of types this package does not
+	// understand, which Pack writes back unchanged after the others; see
+	// UnknownEntry.
+	UnknownEntries []*UnknownEntry
+
+	// ETag is a content hash of the data the keystore was parsed from (see
+	// the ETag function), or empty if it was not parsed. It is set by Parse
+	// and used by PackIfUnchanged.
+	ETag string
+
+	// Integrity records whether the file's digest was verified by Parse,
+	// or its MAC by ParsePKCS12.
+	Integrity Integrity
+
+	// Order lists the aliases of the entries in the order in which Parse
+	// read them from the file. Pack writes the entries listed here in
+	// this order, and then any others, so that a keystore which is parsed,
+	// modified and packed again keeps its order and diffs stay small. It
+	// may be nil, in which case trusted certificates are written before
+	// keypairs.
+	Order []string
+
+	// Trace records where each entry was found in the file, if
+	// Options.Trace was set; see EntryTrace. Pack ignores it.
+	Trace []*EntryTrace
+}
+
+// UnknownEntry is an entry whose type tag is not one this package understands,
+// such as a vendor extension. Since the file format gives no entry's length,
+// such an entry can only be read if it is the last in the file, in which case
+// it runs up to the digest; otherwise Parse fails with CodeMalformed.
+type UnknownEntry struct {
+	// Tag is the entry's type tag.
+	Tag uint32
+
+	// Raw holds the entry's data following the tag.
+	Raw []byte
 }
 
 // Options for manipulating a keystore. These allow the caller to specify the
 // password(s) used, or to skip the digest verification if the password is
 // unknown.
 type Options struct {
-	// Password is used as part of a SHA-1 digest over the .jks file.
+	// Password is used as part of a SHA-1 digest over the .jks file. A Go
+	// string cannot be wiped from memory, so callers who need that should
+	// leave Password empty and supply the password as a []byte through
+	// Passwords (e.g. StaticPasswords) instead.
 	Password string
 
 	// SkipVerifyDigest can be set to skip digest verification when loading
 	// a keystore file. This will inhibit errors from Parse if you don't
-	// know the password.
+	// know the password: the trusted certificates, which are not
+	// encrypted, can still be listed and extracted. The Keystore's
+	// Integrity is then IntegrityUnchecked.
 	SkipVerifyDigest bool
 
+	// CertsOnDigestMismatch changes what Parse returns when the digest
+	// does not match: rather than every entry, the partial Keystore holds
+	// only the trusted certificate entries, which are not protected by
+	// the password and so can still be recovered. Parse still returns
+	// ErrDigestMismatch, and the certificates must be treated as
+	// unauthenticated.
+	CertsOnDigestMismatch bool
+
 	// KeyPasswords are used to generate the "encryption" keys for stored
 	// private keys. The map's key is the alias of the private key, and the
 	// value is the password. If there is no entry in the map for a given
@@ -57,6 +115,196 @@ type Options struct {
 	// interpreted as an empty password, so use delete() if you truly want
 	// to delete values.
 	KeyPasswords map[string]string
+
+	// KeyPasswordFunc, if not nil, is called for the password of each
+	// private or secret key that has no entry in KeyPasswords, so that
+	// passwords may be fetched on demand (from a prompt or a secret
+	// manager, say) rather than all up front. If it returns a nil slice,
+	// the top-level Password is used; an empty, non-nil slice is an empty
+	// password. An error is recorded as the key's PrivKeyErr by Parse,
+	// and returned by Pack. It is called at most once per key by each
+	// Parse or Pack, but not at all for keys left encrypted.
+	KeyPasswordFunc func(alias string) ([]byte, error)
+
+	// Passwords, if not nil, supplies the passwords in place of Password,
+	// and the key passwords after KeyPasswords and KeyPasswordFunc.
+	Passwords PasswordProvider
+
+	// NewKeyPasswords changes the passwords of private keys, as "keytool
+	// -keypasswd" does. The map's key is the alias of the private key, and
+	// the value is the password under which Pack and PackPKCS12 encrypt
+	// it, in place of the one given by KeyPasswords and the fields above.
+	// A key that was not decrypted is first decrypted with that password.
+	// Parse ignores it.
+	NewKeyPasswords map[string]string
+
+	// SkipKeyDecryption makes Parse leave private keys encrypted, for
+	// when only the aliases or certificates are needed, or the key
+	// passwords are not yet known. Each keypair keeps its EncryptedKey,
+	// and its PrivKeyErr is set to ErrKeyNotDecrypted until
+	// Keypair.Decrypt is called.
+	SkipKeyDecryption bool
+
+	// Trace makes Parse and ParseFrom record each entry's position,
+	// length, type and problems in Keystore.Trace, for triaging corrupt
+	// files. The trace is returned along with the partial Keystore if
+	// parsing fails, and its last entry then records the error.
+	Trace bool
+
+	// CertsOnly makes Parse, ParseFrom, ParsePKCS12 and ParseBKS skip
+	// private and secret key entries, for callers that need only the
+	// trusted certificates of a large keystore. Skipped entries are read
+	// past, so the digest is still verified, but their keys are not
+	// decrypted (nor their passwords fetched), and they are left out of
+	// the Keystore along with any UnknownEntries.
+	CertsOnly bool
+
+	// Alias, if not empty, makes the same functions skip every entry but
+	// the one with this alias, compared as by NormalizeAlias, in the same
+	// way as CertsOnly. Both may be set. ReadEntry ignores both.
+	Alias string
+
+	// Parallelism is how many private keys Parse and ParsePKCS12 may
+	// decrypt at once. Deriving the key that protects each one is
+	// CPU-bound, so a keystore holding many keys is parsed faster if
+	// they are decrypted on several goroutines once the whole file has
+	// been read. Keypairs are returned in file order either way, and key
+	// passwords are still fetched one at a time, in that order. Zero or
+	// one decrypts each key as it is read; a negative value uses
+	// runtime.GOMAXPROCS(0) goroutines.
+	Parallelism int
+
+	// Preflight makes Pack and PackPKCS12 call Keystore.Validate first,
+	// and write nothing if it finds any problem, rather than fail part
+	// way through or write a keystore that keytool cannot load.
+	Preflight bool
+
+	// Deterministic makes Pack and PackPKCS12 give byte-identical output
+	// for the same keystore and options, as reproducible builds and
+	// content-addressed caches need: entries are written sorted by alias,
+	// entries without a Timestamp are given the Timestamp below (or the
+	// Unix epoch) rather than the current time, and the salts and IVs
+	// with which keys and the file are protected are derived from the
+	// password and the data they protect, rather than being random.
+	Deterministic bool
+
+	// Timestamp, if not zero, is written for entries without one, in
+	// place of the current time. If it is zero, such entries are given
+	// the Unix epoch when Deterministic is set, and the time from Clock
+	// otherwise.
+	Timestamp time.Time
+
+	// Clock, if not nil, is called instead of time.Now wherever the
+	// current time is needed: for entries without a Timestamp that Pack
+	// writes, and for entries of a PKCS#12 file that record no creation
+	// time. Tests and pipelines may set it to control the timestamps
+	// written without setting Deterministic.
+	Clock func() time.Time
+
+	// PreserveAliasCase makes Pack write aliases as they are, rather than
+	// lowercased as keytool writes them. Java itself still treats aliases
+	// that differ only in case as the same.
+	PreserveAliasCase bool
+
+	// DuplicateAliases determines what Pack and PackPKCS12 do with entries
+	// whose aliases are the same as Java compares them (see
+	// NormalizeAlias), since Java would load only one of them. The
+	// default, CollisionError, is to fail with an error with
+	// CodeDuplicateAlias. CollisionSkip writes only the first of them and
+	// CollisionOverwrite only the last (in place of the first), in the
+	// order they are written; CollisionSuffix and CollisionFingerprint
+	// write them all, renaming each after the first as Merge would. The
+	// keystore itself is not changed. Per-key passwords are looked up by
+	// the alias written.
+	DuplicateAliases CollisionPolicy
+
+	// PasswordEncoding selects how passwords are encoded for the JKS
+	// digest and key protection. The zero value, PasswordUTF16BE, is what
+	// Java uses; see PasswordWidenedBytes for the alternative.
+	PasswordEncoding PasswordEncoding
+
+	// Wipe asks Parse and Pack to zero, once they have finished, the
+	// password slices returned by Passwords and KeyPasswordFunc (which
+	// must therefore return a fresh slice on each call, as StaticPasswords
+	// and EnvPasswords do), and makes Parse zero and discard each
+	// keypair's RawKey once PrivateKey has been unmarshalled from it.
+	// Buffers derived from passwords, such as their UTF-16 encodings and
+	// the keys derived from them, are always zeroed after use. See also
+	// Keystore.Destroy.
+	Wipe bool
+
+	// Version is the JKS file format version that Pack writes: 2 (used
+	// if Version is zero), or 1, which differs only in that the type of
+	// each certificate is not recorded. Only some very old software needs
+	// version 1. Parse reads either version, whatever this is set to.
+	Version int
+
+	// Compatibility selects the oldest Java runtime that must be able to
+	// load packed output. Pack returns an error rather than write an
+	// entry that the runtime could not load.
+	Compatibility Compatibility
+
+	// KeyProtection selects the algorithm with which Pack and PackPKCS12
+	// encrypt private keys. The default is chosen for compatibility with
+	// Java; see KeyProtectionPBES2 for a stronger one.
+	KeyProtection KeyProtection
+
+	// MinRSABits and MinECBits are the smallest RSA modulus and EC field
+	// sizes, in bits, that Pack will accept for keypair entries. Zero means
+	// there is no minimum.
+	MinRSABits int
+	MinECBits  int
+
+	// AllowedCurves, if not empty, lists the only elliptic curves that Pack
+	// will accept for EC keypair entries. Curves are named as in
+	// elliptic.CurveParams (e.g. "P-256").
+	AllowedCurves []string
+
+	// DisabledAlgorithms lists digest or signature algorithms (e.g. "MD5",
+	// "SHA1"; see DefaultDisabledAlgorithms) that Pack and Validate will
+	// refuse in certificate signatures, in the manner of the JDK's
+	// jdk.certpath.disabledAlgorithms security property. Names are matched
+	// case-insensitively against x509.SignatureAlgorithm.String() (e.g.
+	// "SHA1-RSA") and its components. Self-signed certificates are
+	// exempt.
+	DisabledAlgorithms []string
+
+	// WarnDisabledAlgorithms downgrades DisabledAlgorithms violations to
+	// warnings, which are passed to Warn.
+	WarnDisabledAlgorithms bool
+
+	// MaxChainLength, if not zero, is the largest number of certificates
+	// that CheckChains and Validate will accept in a keypair's chain.
+	MaxChainLength int
+
+	// Roots, if not nil, makes Pack, PackPKCS12 and Validate check with
+	// Keystore.VerifyChains that every keypair's certificate chain leads
+	// to one of these trusted roots, and is valid now.
+	Roots *x509.CertPool
+
+	// MaxEntryCount, MaxCertSize and MaxKeySize limit what Parse and
+	// ParseFrom will accept from a file, so that one crafted to claim a
+	// vast number of entries or a multi-gigabyte record cannot exhaust
+	// memory: MaxEntryCount bounds the number of entries and the length
+	// of each certificate chain, MaxCertSize the size of each encoded
+	// certificate, and MaxKeySize the size of each encrypted private key
+	// or sealed secret key, in bytes. Zero selects DefaultMaxEntryCount,
+	// DefaultMaxCertSize or DefaultMaxKeySize. A file exceeding a limit
+	// gives an error matching ErrTooLarge.
+	MaxEntryCount int
+	MaxCertSize   int
+	MaxKeySize    int
+
+	// Warn, if not nil, is called with any problems that are reported but
+	// do not cause an operation to fail.
+	Warn func(error)
+
+	// passwd is the keystore password fetched from Passwords, which is
+	// used in place of Password.
+	passwd []byte
+
+	// fetched holds the passwords to be zeroed if Wipe is set.
+	fetched [][]byte
 }
 
 // Cert holds a certificate to trust.
@@ -64,17 +312,47 @@ type Cert struct {
 	// Alias is a name used to refer to this certificate.
 	Alias string
 
-	// Timestamp records when this record was created.
+	// Timestamp records when this record was created. Files hold it to
+	// the millisecond, which Parse keeps and Pack writes; any finer
+	// precision is lost.
 	Timestamp time.Time
 
 	// Raw is the raw X.509 certificate marshalled in DER form.
 	Raw []byte
 
-	// CertErr is set if there is an error parsing the certificate.
+	// Type is the certificate type recorded in the keystore, if it is not
+	// CertType. Such certificates are kept, and written back with the
+	// same type, but are not parsed.
+	Type string
+
+	// CertErr is set if there is an error parsing the certificate. Such
+	// certificates are still kept (with Raw set, but Cert nil), since some
+	// keystores contain certificates with non-standard extensions that
+	// crypto/x509 rejects but the JVM accepts.
 	CertErr error
 
 	// Cert is the parsed X.509 certificate.
 	Cert *x509.Certificate
+
+	// FriendlyName and LocalKeyID hold the friendlyName and localKeyId
+	// attributes of the certificate's PKCS#12 bag, as read by
+	// ParsePKCS12; each is empty if the bag did not carry it. PackPKCS12
+	// writes them back: FriendlyName in place of Alias, so long as the
+	// two are the same as Java compares aliases (so that its case
+	// survives a trip through JKS, whose aliases are lowercased), and
+	// LocalKeyID if it is set. Pack ignores them.
+	FriendlyName string
+	LocalKeyID   []byte
+}
+
+// DER returns the certificate in DER form. It is taken from Cert if that is
+// set, and from Raw otherwise, so that certificates which could not be parsed
+// may still be packed or exported.
+func (c *Cert) DER() []byte {
+	if c.Cert != nil {
+		return c.Cert.Raw
+	}
+	return c.Raw
 }
 
 // Keypair holds a private key and an associated certificate chain.
@@ -82,11 +360,13 @@ type Keypair struct {
 	// Alias is a name used to refer to this keypair.
 	Alias string
 
-	// Timestamp records when this record was created.
+	// Timestamp records when this record was created, to the
+	// millisecond as for Cert.
 	Timestamp time.Time
 
 	// PrivKeyErr is set if an error is encountered during decryption or
-	// unmarshalling of the decrypted key.
+	// unmarshalling of the decrypted key, or to ErrKeyNotDecrypted if
+	// decryption was skipped (see Options.SkipKeyDecryption).
 	PrivKeyErr error
 
 	// EncryptedKey is the raw PKCS#8 marshalled EncryptedPrivateKeyInfo.
@@ -100,11 +380,37 @@ type Keypair struct {
 	// set if decryption failed or if unmarshalling failed.
 	PrivateKey interface{}
 
+	// KeyAlgorithm is the object identifier of the private key algorithm
+	// (e.g. RSA, EC or Ed25519), as recorded in the decrypted
+	// PrivateKeyInfo. It is set by Parse even if the key itself could not
+	// be unmarshalled, but not if decryption failed.
+	KeyAlgorithm asn1.ObjectIdentifier
+
+	// EncryptKeyFunc, if not nil, is called by Pack and PackPKCS12 in
+	// place of encrypting PrivateKey, for systems such as an HSM or KMS
+	// which never expose the plaintext key to this process. It is given
+	// the key's password and the encryption algorithms that the output
+	// format allows, the preferred one first, and must return a DER
+	// PKCS#8 EncryptedPrivateKeyInfo using one of them. A keypair may
+	// instead be given a fixed EncryptedKey, with PrivateKey nil.
+	EncryptKeyFunc func(password []byte,
+		algos []asn1.ObjectIdentifier) ([]byte, error)
+
 	// CertChain is a chain of certificates associated with the private key.
 	// The first entry in the chain (index 0) should correspond to
 	// PrivateKey; there should then follow any intermediate CAs. In
 	// general the root CA should not be part of the chain.
 	CertChain []*KeypairCert
+
+	// FriendlyName and LocalKeyID hold the friendlyName and localKeyId
+	// attributes of the keypair's PKCS#12 key bag, as for Cert. If
+	// LocalKeyID is set, PackPKCS12 writes it on the key bag and the
+	// leaf certificate's bag, to pair them up, in place of the SHA-1
+	// fingerprint of the leaf certificate; it should be unique within
+	// the keystore. keytool writes "Time " and the entry's
+	// creation time, which ParsePKCS12 reads back as the Timestamp.
+	FriendlyName string
+	LocalKeyID   []byte
 }
 
 // KeypairCert is an entry in the certificate chain associated with a Keypair.
@@ -112,16 +418,27 @@ type KeypairCert struct {
 	// Raw X.509 certificate data (in DER form).
 	Raw []byte
 
+	// Type is the certificate type recorded in the keystore, if it is not
+	// CertType. As with Cert.Type, such certificates are not parsed.
+	Type string
+
 	// Cert is the parsed X.509 certificate. It is nil if the certificate
 	// could not be parsed.
 	Cert *x509.Certificate
 
 	// CertErr records any error encountered while parsing a certificate.
+	// As with Cert.CertErr, the entry is still kept with Raw set.
 	CertErr error
 }
 
-var defaultOptions = Options{
-	SkipVerifyDigest: true,
+// DER returns the certificate in DER form. It is taken from Cert if that is
+// set, and from Raw otherwise, so that certificates which could not be parsed
+// may still be packed or exported.
+func (c *KeypairCert) DER() []byte {
+	if c.Cert != nil {
+		return c.Cert.Raw
+	}
+	return c.Raw
 }
 
 // ComputeDigest performs the custom hash function over the given file data.
@@ -131,20 +448,74 @@ var defaultOptions = Options{
 // is vulnerable to a length extension attack, which is actually exploitable if
 // the JKS reader code does not properly check the "number of entries" value.
 func ComputeDigest(raw []byte, passwd string) []byte {
-	// compute SHA-1 digest over the construct:
-	//  UTF-16(password) + UTF-8(DigestSeparator) + raw
-	md := sha1.New()
-	p := PasswordUTF16(passwd)
-	md.Write(p)
-	md.Write([]byte(DigestSeparator))
+	md := NewDigest(passwd)
 	md.Write(raw)
 	return md.Sum(nil)
 }
 
+// NewDigest returns a hash.Hash that computes the same digest as
+// ComputeDigest, but which allows the file data to be written incrementally.
+// This is useful when streaming a keystore to or from an io.Writer or
+// io.Reader. Calling Reset on the returned hash returns it to its initial
+// state, ready to hash another file with the same password.
+//
+// The same warning as ComputeDigest applies: DO NOT RE-USE THIS CODE for
+// anything other than Java keystores.
+func NewDigest(passwd string) hash.Hash {
+	return newDigest([]byte(passwd), PasswordUTF16BE)
+}
+
+// newDigest implements NewDigest for a password held in a byte slice, which
+// is encoded with enc.
+func newDigest(passwd []byte, enc PasswordEncoding) *digest {
+	// compute SHA-1 digest over the construct:
+	//  UTF-16(password) + UTF-8(DigestSeparator) + raw
+	// the encoded password has no room for the separator, so is copied
+	// rather than appended to, which would leave it behind uncleared
+	u := enc.encode(passwd)
+	prefix := make([]byte, 0, len(u)+len(DigestSeparator))
+	prefix = append(append(prefix, u...), DigestSeparator...)
+	clear(u)
+	d := &digest{
+		Hash:   sha1.New(),
+		prefix: prefix,
+	}
+	d.Reset()
+	return d
+}
+
+// digest wraps a SHA-1 hash so that Reset re-primes it with the password and
+// separator prefix.
+type digest struct {
+	hash.Hash
+	prefix []byte
+}
+
+func (d *digest) Reset() {
+	d.Hash.Reset()
+	d.Hash.Write(d.prefix)
+}
+
+// wipe zeroes the copy of the password held for Reset.
+func (d *digest) wipe() {
+	clear(d.prefix)
+}
+
 // PasswordUTF16 returns a password encoded in UTF-16, big-endian byte order.
 func PasswordUTF16(passwd string) []byte {
-	var u []byte
-	for _, r := range passwd {
+	return passwordUTF16([]byte(passwd))
+}
+
+// passwordUTF16 implements PasswordUTF16 for a password held in a byte slice,
+// which it decodes as UTF-8.
+func passwordUTF16(passwd []byte) []byte {
+	// no rune takes more bytes in UTF-16 than twice its UTF-8 length, so
+	// this never reallocates, which would leave a copy behind; the spare
+	// two bytes are for the NUL terminator that pkcs12KDF appends
+	u := make([]byte, 0, 2*len(passwd)+2)
+	for len(passwd) > 0 {
+		r, size := utf8.DecodeRune(passwd)
+		passwd = passwd[size:]
 		if r < 0x10000 {
 			u = append(u, byte((r>>8)&0xFF))
 			u = append(u, byte(r&0xFF))
@@ -68,3 +68,49 @@ func testPasswordUTF16(in string) func(*testing.T) {
 		}
 	}
 }
+
+// TestPasswordEncoding checks the two password encodings against each other:
+// they agree for ASCII, but not otherwise.
+func TestPasswordEncoding(t *testing.T) {
+	t.Run("ascii", testPasswordEncoding("ascii", PasswordUTF16BE,
+		[]byte{0, 'a', 0, 's', 0, 'c', 0, 'i', 0, 'i'}))
+	t.Run("ascii-widened", testPasswordEncoding("ascii",
+		PasswordWidenedBytes,
+		[]byte{0, 'a', 0, 's', 0, 'c', 0, 'i', 0, 'i'}))
+	t.Run("latin1", testPasswordEncoding("é", PasswordUTF16BE,
+		[]byte{0x00, 0xE9}))
+	t.Run("latin1-widened", testPasswordEncoding("é", PasswordWidenedBytes,
+		[]byte{0x00, 0xC3, 0x00, 0xA9}))
+	t.Run("surrogate", testPasswordEncoding("\U0001F511",
+		PasswordUTF16BE, []byte{0xD8, 0x3D, 0xDD, 0x11}))
+}
+
+func testPasswordEncoding(in string, enc PasswordEncoding, exp []byte,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		out := enc.encode([]byte(in))
+		if !bytes.Equal(out, exp) {
+			t.Errorf("output sequence ‘%X’ ≠ expected ‘%X’",
+				out, exp)
+		}
+	}
+}
+
+// TestNewDigest checks that the streaming digest matches ComputeDigest, both
+// on first use and after a Reset.
+func TestNewDigest(t *testing.T) {
+	const passwd = "password"
+	data := []byte("some input data, written in pieces")
+	exp := ComputeDigest(data, passwd)
+
+	md := NewDigest(passwd)
+	for i := 0; i < 2; i++ {
+		md.Write(data[:5])
+		md.Write(data[5:])
+		if out := md.Sum(nil); !bytes.Equal(out, exp) {
+			t.Errorf("pass %d: digest ‘%X’ ≠ expected ‘%X’",
+				i, out, exp)
+		}
+		md.Reset()
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Package jkstest provides helpers for building throwaway Java keystores in tests.
+
+Keys and certificates are generated on the fly, so tests exercising JKS
+handling do not need to commit binary fixtures. A typical use looks like:
+
+	b := jkstest.New(t, "password").
+		CA("root").
+		ECKeypair("server", elliptic.P256())
+	raw := b.Bytes()
+	ks, err := jks.Parse(raw, b.Options())
+
+All helpers take a testing.TB and fail the test immediately if anything goes
+wrong, so callers do not need to check errors.
+*/
+package jkstest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+)
+
+// RSAKey generates an RSA private key with a modulus of the given size.
+func RSAKey(tb testing.TB, bits int) *rsa.PrivateKey {
+	tb.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		tb.Fatalf("failed to generate RSA key: %v", err)
+	}
+	return key
+}
+
+// ECKey generates an ECDSA private key on the given curve.
+func ECKey(tb testing.TB, curve elliptic.Curve) *ecdsa.PrivateKey {
+	tb.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		tb.Fatalf("failed to generate EC key: %v", err)
+	}
+	return key
+}
+
+// SelfSigned returns a self-signed CA certificate for key, valid from an hour
+// ago until a day from now.
+func SelfSigned(tb testing.TB, key crypto.Signer, cn string,
+) *x509.Certificate {
+	tb.Helper()
+	return Issue(tb, key, cn, nil, nil, true)
+}
+
+// Issue returns a certificate for the public half of key, signed by the given
+// parent certificate and key. If parent is nil then the certificate is
+// self-signed. isCA controls whether the certificate may be used to sign other
+// certificates. The certificate is valid from an hour ago until a day from
+// now, and non-CA certificates carry cn as a DNS subject alternate name.
+func Issue(tb testing.TB, key crypto.Signer, cn string,
+	parent *x509.Certificate, parentKey crypto.Signer, isCA bool,
+) *x509.Certificate {
+	tb.Helper()
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		tb.Fatalf("failed to generate serial number: %v", err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+		}
+		tmpl.DNSNames = []string{cn}
+	}
+
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent,
+		key.Public(), parentKey)
+	if err != nil {
+		tb.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+// Builder incrementally assembles a Keystore and matching Options.
+type Builder struct {
+	tb   testing.TB
+	ks   jks.Keystore
+	opts jks.Options
+}
+
+// New returns a Builder for an empty keystore protected by password.
+func New(tb testing.TB, password string) *Builder {
+	return &Builder{
+		tb: tb,
+		opts: jks.Options{
+			Password:     password,
+			KeyPasswords: make(map[string]string),
+		},
+	}
+}
+
+// CA adds a trusted certificate entry holding a freshly-generated,
+// self-signed CA certificate with a P-256 key.
+func (b *Builder) CA(alias string) *Builder {
+	b.tb.Helper()
+	return b.Cert(alias, SelfSigned(b.tb, ECKey(b.tb, elliptic.P256()),
+		alias))
+}
+
+// Cert adds a trusted certificate entry holding cert.
+func (b *Builder) Cert(alias string, cert *x509.Certificate) *Builder {
+	b.ks.Certs = append(b.ks.Certs, &jks.Cert{
+		Alias:     alias,
+		Timestamp: time.Now(),
+		Raw:       cert.Raw,
+		Cert:      cert,
+	})
+	return b
+}
+
+// RSAKeypair adds a keypair entry with a freshly-generated RSA key of the
+// given size and a self-signed certificate.
+func (b *Builder) RSAKeypair(alias string, bits int) *Builder {
+	b.tb.Helper()
+	return b.Keypair(alias, RSAKey(b.tb, bits))
+}
+
+// ECKeypair adds a keypair entry with a freshly-generated ECDSA key on the
+// given curve and a self-signed certificate.
+func (b *Builder) ECKeypair(alias string, curve elliptic.Curve) *Builder {
+	b.tb.Helper()
+	return b.Keypair(alias, ECKey(b.tb, curve))
+}
+
+// Keypair adds a keypair entry holding key, with a certificate chain made up
+// of a single self-signed certificate whose common name is alias.
+func (b *Builder) Keypair(alias string, key crypto.Signer) *Builder {
+	b.tb.Helper()
+	cert := Issue(b.tb, key, alias, nil, nil, false)
+	return b.KeypairWithChain(alias, key, cert)
+}
+
+// KeypairWithChain adds a keypair entry holding key and the given certificate
+// chain, which should start with the certificate for key.
+func (b *Builder) KeypairWithChain(alias string, key crypto.Signer,
+	chain ...*x509.Certificate,
+) *Builder {
+	kp := &jks.Keypair{
+		Alias:      alias,
+		Timestamp:  time.Now(),
+		PrivateKey: key,
+	}
+	for _, cert := range chain {
+		kp.CertChain = append(kp.CertChain, &jks.KeypairCert{
+			Raw:  cert.Raw,
+			Cert: cert,
+		})
+	}
+	b.ks.Keypairs = append(b.ks.Keypairs, kp)
+	return b
+}
+
+// KeyPassword sets a specific password for the keypair with the given alias.
+func (b *Builder) KeyPassword(alias, password string) *Builder {
+	b.opts.KeyPasswords[alias] = password
+	return b
+}
+
+// Keystore returns the keystore built so far. It is not copied, so later
+// calls to the Builder will modify it.
+func (b *Builder) Keystore() *jks.Keystore {
+	return &b.ks
+}
+
+// Options returns options holding the store password and any per-key
+// passwords, suitable for passing to both Pack and Parse.
+func (b *Builder) Options() *jks.Options {
+	return &b.opts
+}
+
+// Bytes packs the keystore, returning the raw file data.
+func (b *Builder) Bytes() []byte {
+	b.tb.Helper()
+	raw, err := b.ks.Pack(&b.opts)
+	if err != nil {
+		b.tb.Fatalf("failed to pack keystore: %v", err)
+	}
+	return raw
+}
+
+// CorruptDigest returns a copy of the keystore file data with the trailing
+// integrity digest altered, so that digest verification fails while all of
+// the entries remain intact.
+func CorruptDigest(raw []byte) []byte {
+	out := append([]byte(nil), raw...)
+	if len(out) > 0 {
+		out[len(out)-1] ^= 0xFF
+	}
+	return out
+}
+
+// CorruptMagic returns a copy of the keystore file data with the magic number
+// at the start of the file altered.
+func CorruptMagic(raw []byte) []byte {
+	out := append([]byte(nil), raw...)
+	if len(out) > 0 {
+		out[0] ^= 0xFF
+	}
+	return out
+}
+
+// CorruptEntryCount returns a copy of the keystore file data with the entry
+// count in the header increased by one, so that the parser runs off the end of
+// the entries and into the digest.
+func CorruptEntryCount(raw []byte) []byte {
+	out := append([]byte(nil), raw...)
+	if len(out) >= 12 {
+		out[11]++
+	}
+	return out
+}
+
+// Truncate returns a copy of the keystore file data with the final n bytes
+// removed.
+func Truncate(raw []byte, n int) []byte {
+	if n > len(raw) {
+		n = len(raw)
+	}
+	return append([]byte(nil), raw[:len(raw)-n]...)
+}
@@ -0,0 +1,54 @@
+package jks
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyProtection selects the algorithm with which Pack and PackPKCS12 encrypt
+// private keys.
+type KeyProtection int
+
+const (
+	// KeyProtectionDefault uses the algorithm that every Java runtime
+	// selected by Options.Compatibility can read: in a JKS file, the JDK's
+	// own JavaKeyEncryption1 (a SHA-1 based XOR stream); in a PKCS#12 file,
+	// pbeWithSHAAnd3-KeyTripleDES-CBC for Java8 and PBES2 otherwise.
+	KeyProtectionDefault KeyProtection = iota
+
+	// KeyProtectionPBES2 always uses PBES2 (RFC 8018), with
+	// PBKDF2-HMAC-SHA256 and AES-256-CBC, which is much stronger than
+	// JavaKeyEncryption1. Beware that the JDK's JKS implementation accepts
+	// only its own algorithm, and reports "Unsupported key protection
+	// algorithm" for anything else, so a JKS file written with this
+	// setting can be read by minijks and other PKCS#8 aware tools, but not
+	// by keytool; convert it to PKCS#12 for Java. In a PKCS#12 file, it
+	// needs Java 8u301 or later, whatever Compatibility says.
+	KeyProtectionPBES2
+)
+
+var keyProtectionNames = []string{
+	KeyProtectionDefault: "default",
+	KeyProtectionPBES2:   "pbes2",
+}
+
+// String returns the name of the setting, as accepted by
+// ParseKeyProtection.
+func (p KeyProtection) String() string {
+	if p >= 0 && int(p) < len(keyProtectionNames) {
+		return keyProtectionNames[p]
+	}
+	return fmt.Sprintf("KeyProtection(%d)", int(p))
+}
+
+// ParseKeyProtection returns the setting with the given name (e.g. "pbes2").
+func ParseKeyProtection(name string) (KeyProtection, error) {
+	for p, n := range keyProtectionNames {
+		if strings.EqualFold(n, name) {
+			return KeyProtection(p), nil
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown key protection %q "+
+		"(expected one of %s)", name,
+		strings.Join(keyProtectionNames, ", "))
+}
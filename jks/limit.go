@@ -0,0 +1,112 @@
+package jks
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"time"
+)
+
+// ErrTooLarge is returned by ParseLimited if the input holds more data than
+// the permitted maximum. The errors returned by Parse when a file exceeds one
+// of the limits set in Options (such as MaxCertSize) also match it.
+var ErrTooLarge error = newSentinel(CodeTooLarge, "keystore exceeds maximum "+
+	"permitted size")
+
+// Default limits on what Parse will accept; see Options.MaxEntryCount. They
+// are far beyond what any real keystore needs: the JDK's cacerts holds some
+// 150 entries, and certificates and keys are a few kilobytes.
+const (
+	DefaultMaxEntryCount = 1 << 16
+	DefaultMaxCertSize   = 1 << 20
+	DefaultMaxKeySize    = 1 << 20
+)
+
+// maxEntryCount returns opts.MaxEntryCount, or its default.
+func (opts *Options) maxEntryCount() uint32 {
+	return limit(opts.MaxEntryCount, DefaultMaxEntryCount)
+}
+
+// maxCertSize returns opts.MaxCertSize, or its default.
+func (opts *Options) maxCertSize() uint32 {
+	return limit(opts.MaxCertSize, DefaultMaxCertSize)
+}
+
+// maxKeySize returns opts.MaxKeySize, or its default.
+func (opts *Options) maxKeySize() uint32 {
+	return limit(opts.MaxKeySize, DefaultMaxKeySize)
+}
+
+// limit returns n as a uint32 (saturating), or def if n is zero.
+func limit(n int, def uint32) uint32 {
+	switch {
+	case n == 0:
+		return def
+	case uint64(n) > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
+
+// tooLarge returns an error matching ErrTooLarge for a length field which
+// exceeds its limit.
+func tooLarge(what string, offset int64, n, max uint32) error {
+	return errorf(CodeTooLarge, "%s at position %d is %d, beyond the "+
+		"maximum of %d", what, offset, n, max)
+}
+
+// readDeadliner is implemented by net.Conn and similar types.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// ParseLimited reads a keystore from r and parses it as per Parse. It is
+// intended for untrusted sources, such as the body of an HTTP upload: no more
+// than maxSize bytes are ever read from r, regardless of any length the sender
+// may have claimed (e.g. in a Content-Length header), and if r holds more data
+// than that then ErrTooLarge is returned.
+//
+// If ctx has a deadline and r has a SetReadDeadline method (as net.Conn does),
+// the deadline is applied to r so that a slow sender cannot hold up the caller.
+// ctx is also checked between reads, and its error is returned if it has been
+// cancelled.
+func ParseLimited(ctx context.Context, r io.Reader, maxSize int64,
+	opts *Options,
+) (*Keystore, error) {
+	if rd, ok := r.(readDeadliner); ok {
+		if deadline, ok := ctx.Deadline(); ok {
+			if err := rd.SetReadDeadline(deadline); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// read up to one byte more than the limit, so we can tell the
+	// difference between a file of exactly maxSize bytes and one which is
+	// too large
+	var (
+		buf   bytes.Buffer
+		chunk = make([]byte, 32*1024)
+		lr    = io.LimitReader(r, maxSize+1)
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		n, err := lr.Read(chunk)
+		buf.Write(chunk[:n])
+		if int64(buf.Len()) > maxSize {
+			return nil, ErrTooLarge
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return Parse(buf.Bytes(), opts)
+}
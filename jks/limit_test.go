@@ -0,0 +1,105 @@
+package jks_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestParseLimited checks that input is accepted up to and including the
+// maximum size, that larger input is rejected, and that cancellation is
+// honoured.
+func TestParseLimited(t *testing.T) {
+	b := jkstest.New(t, "password").CA("ca")
+	raw := b.Bytes()
+	ctx := context.Background()
+
+	ks, err := jks.ParseLimited(ctx, bytes.NewReader(raw),
+		int64(len(raw)), b.Options())
+	if err != nil || len(ks.Certs) != 1 {
+		t.Errorf("exact size: unexpected result (err %v)", err)
+	}
+
+	_, err = jks.ParseLimited(ctx, bytes.NewReader(raw),
+		int64(len(raw)-1), b.Options())
+	if !errors.Is(err, jks.ErrTooLarge) {
+		t.Errorf("too large: expected ErrTooLarge but got %v", err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = jks.ParseLimited(cctx, bytes.NewReader(raw),
+		int64(len(raw)), b.Options())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: expected context.Canceled but got %v",
+			err)
+	}
+}
+
+// TestParseSizeLimits checks that Parse refuses files whose counts or lengths
+// exceed the limits in Options, before allocating for them.
+func TestParseSizeLimits(t *testing.T) {
+	b := jkstest.New(t, "password").CA("ca1").CA("ca2").
+		ECKeypair("server", elliptic.P256())
+	raw := b.Bytes()
+	ks := b.Keystore()
+	certSize := len(ks.Keypairs[0].CertChain[0].DER())
+	for _, cert := range ks.Certs {
+		certSize = max(certSize, len(cert.DER()))
+	}
+
+	limits := func(entries, certSize, keySize int) *jks.Options {
+		opts := b.Options()
+		opts.MaxEntryCount = entries
+		opts.MaxCertSize = certSize
+		opts.MaxKeySize = keySize
+		return opts
+	}
+	t.Run("defaults", testParseSizeLimit(raw, b.Options(), false))
+	t.Run("exact", testParseSizeLimit(raw, limits(3, certSize, 1024),
+		false))
+	t.Run("entries", testParseSizeLimit(raw, limits(2, 0, 0), true))
+	t.Run("cert", testParseSizeLimit(raw, limits(0, certSize-1, 0),
+		true))
+	t.Run("key", testParseSizeLimit(raw, limits(0, 0, 16), true))
+
+	// a trusted certificate entry claiming to be 4 GiB
+	var buf bytes.Buffer
+	for _, v := range []interface{}{
+		uint32(jks.MagicNumber), uint32(2), uint32(1), uint32(2),
+		uint16(1), []byte("a"), uint64(0),
+		uint16(len(jks.CertType)), []byte(jks.CertType),
+		uint32(0xFFFFFFFF), []byte("short"),
+	} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	t.Run("crafted", testParseSizeLimit(buf.Bytes(),
+		&jks.Options{SkipVerifyDigest: true}, true))
+
+	if _, err := jks.Parse(raw, limits(-1, 0, 0)); jks.ErrorCode(err) !=
+		jks.CodeInvalidOptions {
+		t.Errorf("negative limit: unexpected error %v", err)
+	}
+}
+
+func testParseSizeLimit(raw []byte, opts *jks.Options, expTooLarge bool,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		_, err := jks.Parse(raw, opts)
+		if errors.Is(err, jks.ErrTooLarge) != expTooLarge ||
+			(err != nil && !expTooLarge) {
+			t.Errorf("Parse: unexpected error %v", err)
+		}
+		_, err = jks.ParseFrom(bytes.NewReader(raw), opts)
+		if errors.Is(err, jks.ErrTooLarge) != expTooLarge ||
+			(err != nil && !expTooLarge) {
+			t.Errorf("ParseFrom: unexpected error %v", err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Entry type names used in manifests. These match the names printed by
+// "keytool -list".
+const (
+	ManifestTrustedCert = "trustedCertEntry"
+	ManifestPrivateKey  = "PrivateKeyEntry"
+	ManifestSecretKey   = "SecretKeyEntry"
+)
+
+// Manifest is an inventory of a keystore's content, suitable for audit and for
+// checking into configuration repositories. It never contains private key
+// material or passwords.
+//
+// Manifests are deterministic, so that diffs only show real changes: entries
+// are sorted by alias, certificate chains keep their keystore order (leaf
+// first), and every encoding writes fields in the order they are declared
+// here.
+type Manifest struct {
+	Entries []*ManifestEntry `json:"entries" yaml:"entries"`
+}
+
+// ManifestEntry describes one entry in a keystore.
+type ManifestEntry struct {
+	// Alias of the entry.
+	Alias string `json:"alias" yaml:"alias"`
+
+	// Type is ManifestTrustedCert, ManifestPrivateKey or
+	// ManifestSecretKey.
+	Type string `json:"type" yaml:"type"`
+
+	// Timestamp records when the entry was created.
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+
+	// KeyError is set for keypairs whose private key, or secret keys,
+	// could not be decrypted or parsed.
+	KeyError string `json:"keyError,omitempty" yaml:"keyError,omitempty"`
+
+	// Certificates holds the trusted certificate, or the keypair's
+	// certificate chain (leaf first). It is empty for secret keys.
+	Certificates []*ManifestCert `json:"certificates" yaml:"certificates"`
+}
+
+// ManifestCert describes a single certificate.
+type ManifestCert struct {
+	Subject            string    `json:"subject" yaml:"subject"`
+	Issuer             string    `json:"issuer" yaml:"issuer"`
+	Serial             string    `json:"serial" yaml:"serial"`
+	NotBefore          time.Time `json:"notBefore" yaml:"notBefore"`
+	NotAfter           time.Time `json:"notAfter" yaml:"notAfter"`
+	KeyAlgorithm       string    `json:"keyAlgorithm" yaml:"keyAlgorithm"`
+	KeySize            int       `json:"keySize,omitempty" yaml:"keySize,omitempty"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm" yaml:"signatureAlgorithm"`
+	DNSNames           []string  `json:"dnsNames,omitempty" yaml:"dnsNames,omitempty"`
+	IPAddresses        []string  `json:"ipAddresses,omitempty" yaml:"ipAddresses,omitempty"`
+	FingerprintSHA1    string    `json:"fingerprintSHA1" yaml:"fingerprintSHA1"`
+	FingerprintSHA256  string    `json:"fingerprintSHA256" yaml:"fingerprintSHA256"`
+	PinSHA256          string    `json:"pinSHA256,omitempty" yaml:"pinSHA256,omitempty"`
+
+	// Error is set if the certificate could not be parsed, in which case
+	// only the fingerprints are present.
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Manifest returns an inventory of the keystore's entries, sorted by alias.
+func (ks *Keystore) Manifest() *Manifest {
+	m := new(Manifest)
+	for _, cert := range ks.Certs {
+		m.Entries = append(m.Entries, &ManifestEntry{
+			Alias:     cert.Alias,
+			Type:      ManifestTrustedCert,
+			Timestamp: cert.Timestamp.UTC(),
+			Certificates: []*ManifestCert{
+				manifestCert(cert.DER(), cert.Cert,
+					cert.CertErr),
+			},
+		})
+	}
+	for _, kp := range ks.Keypairs {
+		e := &ManifestEntry{
+			Alias:        kp.Alias,
+			Type:         ManifestPrivateKey,
+			Timestamp:    kp.Timestamp.UTC(),
+			Certificates: []*ManifestCert{},
+		}
+		if kp.PrivKeyErr != nil {
+			e.KeyError = kp.PrivKeyErr.Error()
+		}
+		for _, cert := range kp.CertChain {
+			e.Certificates = append(e.Certificates,
+				manifestCert(cert.DER(), cert.Cert,
+					cert.CertErr))
+		}
+		m.Entries = append(m.Entries, e)
+	}
+	for _, sk := range ks.SecretKeys {
+		e := &ManifestEntry{
+			Alias:        sk.Alias,
+			Type:         ManifestSecretKey,
+			Timestamp:    sk.Timestamp.UTC(),
+			Certificates: []*ManifestCert{},
+		}
+		if sk.KeyErr != nil {
+			e.KeyError = sk.KeyErr.Error()
+		}
+		m.Entries = append(m.Entries, e)
+	}
+	sort.SliceStable(m.Entries, func(i, j int) bool {
+		return m.Entries[i].Alias < m.Entries[j].Alias
+	})
+	return m
+}
+
+// manifestCert summarises one certificate.
+func manifestCert(der []byte, cert *x509.Certificate, certErr error,
+) *ManifestCert {
+	fp := CertFingerprints(der)
+	mc := &ManifestCert{
+		FingerprintSHA1:   fp.SHA1,
+		FingerprintSHA256: fp.SHA256,
+	}
+	if cert == nil {
+		if certErr != nil {
+			mc.Error = certErr.Error()
+		} else {
+			mc.Error = "certificate not parsed"
+		}
+		return mc
+	}
+
+	mc.Subject = cert.Subject.String()
+	mc.Issuer = cert.Issuer.String()
+	mc.Serial = colonHex(cert.SerialNumber.Bytes())
+	mc.NotBefore = cert.NotBefore.UTC()
+	mc.NotAfter = cert.NotAfter.UTC()
+	mc.KeyAlgorithm, mc.KeySize = publicKeyInfo(cert.PublicKey)
+	mc.SignatureAlgorithm = cert.SignatureAlgorithm.String()
+	mc.PinSHA256 = SPKIPin(cert)
+	mc.DNSNames = cert.DNSNames
+	for _, ip := range cert.IPAddresses {
+		mc.IPAddresses = append(mc.IPAddresses, ip.String())
+	}
+	return mc
+}
+
+// publicKeyInfo returns the algorithm name and size (in bits) of a public key.
+func publicKeyInfo(pub interface{}) (algo string, bits int) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA", pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "EC", pub.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	case *dsa.PublicKey:
+		return "DSA", pub.P.BitLen()
+	default:
+		return fmt.Sprintf("%T", pub), 0
+	}
+}
+
+// colonHex formats a byte string as upper-case hex digits separated by colons,
+// as keytool does for fingerprints and serial numbers.
+func colonHex(b []byte) string {
+	var s strings.Builder
+	for i, c := range b {
+		if i > 0 {
+			s.WriteByte(':')
+		}
+		fmt.Fprintf(&s, "%02X", c)
+	}
+	return s.String()
+}
+
+// WriteJSON writes the manifest as indented JSON.
+func (m *Manifest) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// MarshalReport returns the keystore's Manifest as indented JSON: the alias,
+// type and timestamp of each entry, and the subject, issuer, serial number,
+// fingerprints and validity period of each certificate. As for Manifest, no
+// private key material is included, so the report may be passed to audit
+// pipelines as it stands.
+func (ks *Keystore) MarshalReport() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := ks.Manifest().WriteJSON(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteYAML writes the manifest as a YAML document.
+func (m *Manifest) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// manifestCSVHeader names the columns written by WriteCSV.
+var manifestCSVHeader = []string{
+	"alias", "type", "subject", "issuer", "serial", "notAfter",
+	"keyAlgorithm", "fingerprintSHA256",
+}
+
+// WriteCSV writes the manifest as CSV with a header row, one row per entry.
+// Only the entry's first certificate (the leaf, for keypairs) is described.
+// Aliases and distinguished names which a spreadsheet would take for a
+// formula are prefixed with a single quote.
+func (m *Manifest) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(manifestCSVHeader); err != nil {
+		return err
+	}
+	for _, e := range m.Entries {
+		row := []string{csvText(e.Alias), e.Type, "", "", "", "", "",
+			""}
+		if len(e.Certificates) > 0 {
+			c := e.Certificates[0]
+			row[2], row[3] = csvText(c.Subject), csvText(c.Issuer)
+			row[4] = c.Serial
+			if !c.NotAfter.IsZero() {
+				row[5] = c.NotAfter.Format(time.RFC3339)
+			}
+			row[6] = c.KeyAlgorithm
+			if c.KeySize != 0 {
+				row[6] = fmt.Sprintf("%s %d", c.KeyAlgorithm,
+					c.KeySize)
+			}
+			row[7] = c.FingerprintSHA256
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvText returns s, prefixed with a single quote if it starts with a
+// character that spreadsheets treat as the start of a formula (including tab
+// and carriage return, which some strip before looking).
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
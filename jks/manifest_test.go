@@ -0,0 +1,273 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+	"gopkg.in/yaml.v3"
+)
+
+// TestManifest checks the manifest content and that both the JSON and YAML
+// encodings decode back to the same thing.
+func TestManifest(t *testing.T) {
+	ks := jkstest.New(t, "password").
+		CA("root").
+		ECKeypair("server", elliptic.P256()).
+		Keystore()
+
+	m := ks.Manifest()
+	if len(m.Entries) != 2 {
+		t.Fatalf("got %d entries, expected 2", len(m.Entries))
+	}
+	e := m.Entries[1]
+	switch {
+	case e.Alias != "server", e.Type != jks.ManifestPrivateKey:
+		t.Errorf("unexpected entry %q of type %q", e.Alias, e.Type)
+	case len(e.Certificates) != 1:
+		t.Errorf("got %d certificates, expected 1",
+			len(e.Certificates))
+	case e.Certificates[0].KeyAlgorithm != "EC",
+		e.Certificates[0].KeySize != 256,
+		e.Certificates[0].Subject != "CN=server",
+		len(e.Certificates[0].FingerprintSHA256) != 32*3-1:
+		t.Errorf("unexpected certificate summary %+v",
+			e.Certificates[0])
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	if strings.Contains(buf.String(), "PRIVATE") {
+		t.Error("JSON output appears to contain private material")
+	}
+	var fromJSON jks.Manifest
+	if err := json.Unmarshal(buf.Bytes(), &fromJSON); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	buf.Reset()
+	if err := m.WriteYAML(&buf); err != nil {
+		t.Fatalf("WriteYAML: %v", err)
+	}
+	var fromYAML jks.Manifest
+	if err := yaml.Unmarshal(buf.Bytes(), &fromYAML); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+
+	for name, got := range map[string]*jks.Manifest{
+		"json": &fromJSON,
+		"yaml": &fromYAML,
+	} {
+		if len(got.Entries) != 2 ||
+			got.Entries[1].Certificates[0].FingerprintSHA256 !=
+				e.Certificates[0].FingerprintSHA256 ||
+			!got.Entries[1].Timestamp.Equal(e.Timestamp) {
+			t.Errorf("%s: manifest did not round trip", name)
+		}
+	}
+}
+
+// TestManifestCSV checks the CSV export has a header and one row per entry.
+func TestManifestCSV(t *testing.T) {
+	m := jkstest.New(t, "password").
+		CA("root").
+		RSAKeypair("server", 2048).
+		Keystore().
+		Manifest()
+
+	var buf bytes.Buffer
+	if err := m.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("csv.ReadAll: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, expected 3", len(rows))
+	}
+	if rows[0][0] != "alias" || rows[2][0] != "server" ||
+		rows[2][1] != jks.ManifestPrivateKey ||
+		rows[2][6] != "RSA 2048" {
+		t.Errorf("unexpected CSV rows %q", rows)
+	}
+}
+
+// TestManifestCSVFormula checks that aliases and names which a spreadsheet
+// would evaluate as formulas are escaped.
+func TestManifestCSVFormula(t *testing.T) {
+	m := &jks.Manifest{Entries: []*jks.ManifestEntry{{
+		Alias: "=cmd|' /C calc'!A0",
+		Type:  jks.ManifestTrustedCert,
+		Certificates: []*jks.ManifestCert{{
+			Subject: "@SUM(1+1)",
+			Issuer:  "CN=-1+1",
+			Serial:  "-1",
+		}},
+	}, {
+		Alias: "\t=1+1",
+		Type:  jks.ManifestTrustedCert,
+		Certificates: []*jks.ManifestCert{{
+			Subject: "\r=1+1",
+			Issuer:  "+1",
+		}},
+	}}}
+
+	var buf bytes.Buffer
+	if err := m.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("csv.ReadAll: %v", err)
+	}
+	exp := []string{"'=cmd|' /C calc'!A0", "'@SUM(1+1)", "CN=-1+1", "-1",
+		"'\t=1+1", "'\r=1+1", "'+1"}
+	got := []string{rows[1][0], rows[1][2], rows[1][3], rows[1][4],
+		rows[2][0], rows[2][2], rows[2][3]}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("fields %q ≠ expected %q", got, exp)
+	}
+}
+
+// TestMarshalReport checks that the JSON report decodes to the manifest and
+// holds no private key material.
+func TestMarshalReport(t *testing.T) {
+	ks := jkstest.New(t, "password").
+		CA("root").
+		ECKeypair("server", elliptic.P256()).
+		Keystore()
+	report, err := ks.MarshalReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m jks.Manifest
+	if err := json.Unmarshal(report, &m); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	exp := ks.Manifest()
+	if len(m.Entries) != 2 || m.Entries[1].Alias != "server" ||
+		m.Entries[1].Certificates[0].FingerprintSHA256 !=
+			exp.Entries[1].Certificates[0].FingerprintSHA256 ||
+		!m.Entries[1].Certificates[0].NotAfter.Equal(
+			exp.Entries[1].Certificates[0].NotAfter) {
+		t.Errorf("unexpected report %s", report)
+	}
+
+	raw, err := jks.MarshalPKCS8(ks.Keypairs[0].PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(report, raw) || bytes.Contains(report,
+		[]byte(base64.StdEncoding.EncodeToString(raw))) {
+		t.Error("report contains private key material")
+	}
+}
+
+// TestManifestCycloneDX checks the CBOM lists each certificate once and ties
+// private keys to their chain.
+func TestManifestCycloneDX(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "root")
+	key := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, key, "server", ca, caKey, false)
+	m := jkstest.New(t, "password").
+		Cert("root", ca).
+		KeypairWithChain("server", key, leaf, ca).
+		Keystore().
+		Manifest()
+
+	var buf bytes.Buffer
+	if err := m.WriteCycloneDX(&buf); err != nil {
+		t.Fatalf("WriteCycloneDX: %v", err)
+	}
+	var bom struct {
+		BOMFormat  string `json:"bomFormat"`
+		Components []struct {
+			BOMRef           string `json:"bom-ref"`
+			CryptoProperties struct {
+				AssetType string `json:"assetType"`
+			} `json:"cryptoProperties"`
+		} `json:"components"`
+		Dependencies []struct {
+			Ref       string   `json:"ref"`
+			DependsOn []string `json:"dependsOn"`
+		} `json:"dependencies"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &bom); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if bom.BOMFormat != "CycloneDX" {
+		t.Errorf("bomFormat %q ≠ CycloneDX", bom.BOMFormat)
+	}
+
+	counts := make(map[string]int)
+	for _, c := range bom.Components {
+		counts[c.CryptoProperties.AssetType]++
+	}
+	if counts["certificate"] != 2 || counts["related-crypto-material"] != 1 {
+		t.Errorf("unexpected component counts %v", counts)
+	}
+	if len(bom.Dependencies) != 1 ||
+		bom.Dependencies[0].Ref != "key:server" ||
+		len(bom.Dependencies[0].DependsOn) != 2 {
+		t.Errorf("unexpected dependencies %+v", bom.Dependencies)
+	}
+}
+
+// TestManifestOrder checks that manifests are sorted by alias, so that the
+// same content gives the same output whatever the keystore's entry order.
+func TestManifestOrder(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "root")
+	key := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, key, "server", ca, caKey, false)
+
+	ks := jkstest.New(t, "password").
+		Cert("zeta", ca).
+		KeypairWithChain("beta", key, leaf, ca).
+		Cert("alpha", ca).
+		Keystore()
+	m := ks.Manifest()
+	var aliases []string
+	for _, e := range m.Entries {
+		aliases = append(aliases, e.Alias)
+	}
+	if got := strings.Join(aliases, ","); got != "alpha,beta,zeta" {
+		t.Errorf("entry order %q ≠ expected alpha,beta,zeta", got)
+	}
+
+	// reverse the keystore's entry order and check for identical output
+	for i, j := 0, len(ks.Certs)-1; i < j; i, j = i+1, j-1 {
+		ks.Certs[i], ks.Certs[j] = ks.Certs[j], ks.Certs[i]
+	}
+	writers := map[string]func(*jks.Manifest, io.Writer) error{
+		"json":      (*jks.Manifest).WriteJSON,
+		"yaml":      (*jks.Manifest).WriteYAML,
+		"csv":       (*jks.Manifest).WriteCSV,
+		"cyclonedx": (*jks.Manifest).WriteCycloneDX,
+	}
+	for name, write := range writers {
+		var before, after bytes.Buffer
+		if err := write(m, &before); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := write(ks.Manifest(), &after); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(before.Bytes(), after.Bytes()) {
+			t.Errorf("%s: output depends on keystore order", name)
+		}
+	}
+}
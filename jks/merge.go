@@ -0,0 +1,399 @@
+package jks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// CollisionPolicy determines what happens when an entry is added to a keystore
+// which already holds an entry with the same alias.
+type CollisionPolicy int
+
+const (
+	// CollisionError causes the operation to fail with an error.
+	CollisionError CollisionPolicy = iota
+
+	// CollisionSkip keeps the existing entry and discards the new one.
+	CollisionSkip
+
+	// CollisionOverwrite replaces the existing entry with the new one.
+	CollisionOverwrite
+
+	// CollisionSuffix keeps both entries, adding the new one under an
+	// alias with a numeric suffix (".1", ".2" etc.) that makes it unique.
+	CollisionSuffix
+
+	// CollisionFingerprint keeps both entries, adding the new one under an
+	// alias suffixed with the first 8 hex digits of the SHA-256
+	// fingerprint of its certificate (e.g. "alias-1a2b3c4d"). This gives
+	// the same alias each time the same certificate is imported. If the
+	// fingerprinted alias is also taken, a numeric suffix is added too.
+	CollisionFingerprint
+)
+
+var collisionPolicyNames = []string{
+	CollisionError:       "error",
+	CollisionSkip:        "skip",
+	CollisionOverwrite:   "overwrite",
+	CollisionSuffix:      "suffix",
+	CollisionFingerprint: "fingerprint",
+}
+
+// String returns the name of the policy, as accepted by
+// ParseCollisionPolicy.
+func (p CollisionPolicy) String() string {
+	if p >= 0 && int(p) < len(collisionPolicyNames) {
+		return collisionPolicyNames[p]
+	}
+	return fmt.Sprintf("CollisionPolicy(%d)", int(p))
+}
+
+// ParseCollisionPolicy returns the policy with the given name (e.g. "skip").
+func ParseCollisionPolicy(name string) (CollisionPolicy, error) {
+	for p, n := range collisionPolicyNames {
+		if n == name {
+			return CollisionPolicy(p), nil
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown collision policy %q "+
+		"(expected one of %s)", name,
+		strings.Join(collisionPolicyNames, ", "))
+}
+
+// Merge adds all of the entries from other into ks, in the order of other's
+// file (see Entries). Any alias collisions are resolved according to policy:
+// CollisionSkip keeps the entry in ks, CollisionOverwrite replaces it, and
+// CollisionSuffix or CollisionFingerprint renames the new entry. If policy is
+// CollisionError then ks is left unmodified when a collision is found, whether
+// with an entry of ks or between two entries of other.
+//
+// If other records the order of its file, ks.Order is extended so that the
+// merged entries keep that order when packed, after the entries already in ks.
+//
+// Entries are not deep copied, but an entry that needs to be renamed (due to
+// CollisionSuffix or CollisionFingerprint) is copied first so that other is
+// never modified. Per-key passwords are looked up by alias, so a renamed
+// keypair will be packed using the top-level password unless the caller adds
+// an entry for its new alias to Options.KeyPasswords.
+func (ks *Keystore) Merge(other *Keystore, policy CollisionPolicy) error {
+	entries := other.entries()
+	if policy == CollisionError {
+		// other may itself hold aliases which differ only in case
+		seen := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			norm := NormalizeAlias(e.EntryAlias())
+			if seen[norm] || ks.hasAlias(e.EntryAlias()) {
+				return errorf(CodeDuplicateAlias, "duplicate "+
+					"alias %q", e.EntryAlias())
+			}
+			seen[norm] = true
+		}
+	}
+
+	// the existing entries must all be listed, or the merged ones would
+	// be packed ahead of those which are not
+	if len(other.Order) != 0 {
+		order := ks.entries()
+		ks.Order = make([]string, len(order))
+		for i, e := range order {
+			ks.Order[i] = e.EntryAlias()
+		}
+	}
+
+	for _, e := range entries {
+		alias, err := ks.addEntry(e, policy)
+		if err != nil {
+			return err
+		}
+		if len(other.Order) != 0 && alias != "" && !ks.inOrder(alias) {
+			ks.Order = append(ks.Order, alias)
+		}
+	}
+	return nil
+}
+
+// addEntry adds e to ks as AddCert, AddKeypair or AddSecretKey does. It
+// returns the alias that e was added under, or "" if policy is CollisionSkip
+// and the alias was already in use.
+func (ks *Keystore) addEntry(e Entry, policy CollisionPolicy) (string, error) {
+	collides := ks.hasAlias(e.EntryAlias())
+	var err error
+	switch e := e.(type) {
+	case *Cert:
+		err = ks.AddCert(e, policy)
+	case *Keypair:
+		err = ks.AddKeypair(e, policy)
+	case *SecretKey:
+		err = ks.AddSecretKey(e, policy)
+	default:
+		return "", errorf(CodeUnsupported, "unknown entry type %T", e)
+	}
+	switch {
+	case err != nil:
+		return "", err
+	case !collides || policy == CollisionOverwrite:
+		return e.EntryAlias(), nil
+	case policy == CollisionSkip:
+		return "", nil
+	}
+
+	// renamed; the copy was appended
+	switch e.(type) {
+	case *Cert:
+		return ks.Certs[len(ks.Certs)-1].Alias, nil
+	case *Keypair:
+		return ks.Keypairs[len(ks.Keypairs)-1].Alias, nil
+	}
+	return ks.SecretKeys[len(ks.SecretKeys)-1].Alias, nil
+}
+
+// CopyEntry copies the entry with the given alias from src into ks, resolving
+// any alias collision according to policy as AddCert, AddKeypair and
+// AddSecretKey do. It returns the alias of the copy in ks, or "" if policy is
+// CollisionSkip and the alias was already in use. The entry is copied, so that
+// src and ks never share entries.
+//
+// A keypair's private key must have been decrypted when src was parsed: Pack
+// encrypts it afresh with the passwords in the options it is given, which is
+// what lets an entry move between keystores with different passwords. Per-key
+// passwords are looked up by the new alias.
+func (ks *Keystore) CopyEntry(src *Keystore, alias string,
+	policy CollisionPolicy,
+) (string, error) {
+	certIdx, kpIdx := src.findAlias(alias)
+	switch {
+	case certIdx >= 0:
+		c := *src.Certs[certIdx]
+		return ks.addEntry(&c, policy)
+
+	case kpIdx >= 0:
+		kp := src.Keypairs[kpIdx]
+		if kp.PrivKeyErr != nil {
+			return "", errorf("", "key %q: %v", alias,
+				kp.PrivKeyErr)
+		}
+		k := *kp
+		k.CertChain = append([]*KeypairCert(nil), kp.CertChain...)
+		return ks.addEntry(&k, policy)
+	}
+	if skIdx := src.secretKeyIndex(alias); skIdx >= 0 {
+		sk := *src.SecretKeys[skIdx]
+		return ks.addEntry(&sk, policy)
+	}
+	return "", errorf(CodeNoSuchAlias, "no entry with alias %q", alias)
+}
+
+// hasAlias returns true if ks contains an entry (of any type) with the given
+// alias.
+func (ks *Keystore) hasAlias(alias string) bool {
+	certIdx, kpIdx := ks.findAlias(alias)
+	return certIdx >= 0 || kpIdx >= 0 || ks.secretKeyIndex(alias) >= 0
+}
+
+// secretKeyIndex returns the index of the entry in ks.SecretKeys with the given
+// alias, matched as for findAlias, or -1 if there is none.
+func (ks *Keystore) secretKeyIndex(alias string) int {
+	return aliasIndex(len(ks.SecretKeys), func(i int) string {
+		return ks.SecretKeys[i].Alias
+	}, alias)
+}
+
+// findAlias returns the index of the entry with the given alias in either
+// ks.Certs or ks.Keypairs, matching aliases as Lookup does. The index for the
+// slice which does not contain the alias is -1.
+func (ks *Keystore) findAlias(alias string) (certIdx, kpIdx int) {
+	certIdx = aliasIndex(len(ks.Certs), func(i int) string {
+		return ks.Certs[i].Alias
+	}, alias)
+	kpIdx = aliasIndex(len(ks.Keypairs), func(i int) string {
+		return ks.Keypairs[i].Alias
+	}, alias)
+	return
+}
+
+// removeAlias removes any entries whose alias normalizes to the same string as
+// the given alias.
+func (ks *Keystore) removeAlias(alias string) {
+	norm := NormalizeAlias(alias)
+	certs := ks.Certs[:0]
+	for _, cert := range ks.Certs {
+		if NormalizeAlias(cert.Alias) != norm {
+			certs = append(certs, cert)
+		}
+	}
+	ks.Certs = certs
+
+	kps := ks.Keypairs[:0]
+	for _, kp := range ks.Keypairs {
+		if NormalizeAlias(kp.Alias) != norm {
+			kps = append(kps, kp)
+		}
+	}
+	ks.Keypairs = kps
+
+	sks := ks.SecretKeys[:0]
+	for _, sk := range ks.SecretKeys {
+		if NormalizeAlias(sk.Alias) != norm {
+			sks = append(sks, sk)
+		}
+	}
+	ks.SecretKeys = sks
+}
+
+// fingerprintAlias returns alias suffixed with a short fingerprint of der,
+// falling back to a numeric suffix if that is not unique in ks.
+func (ks *Keystore) fingerprintAlias(alias string, der []byte) string {
+	return fingerprintAlias(alias, der, ks.hasAlias)
+}
+
+// uniqueAlias returns alias with the lowest numeric suffix that is not yet
+// used in ks.
+func (ks *Keystore) uniqueAlias(alias string) string {
+	return suffixAlias(alias, ks.hasAlias)
+}
+
+// fingerprintAlias returns alias suffixed with a short fingerprint of der, as
+// for CollisionFingerprint, falling back to a numeric suffix if taken reports
+// that alias to be in use.
+func fingerprintAlias(alias string, der []byte,
+	taken func(string) bool,
+) string {
+	if len(der) == 0 {
+		return suffixAlias(alias, taken)
+	}
+	sum := sha256.Sum256(der)
+	a := alias + "-" + hex.EncodeToString(sum[:4])
+	if !taken(a) {
+		return a
+	}
+	return suffixAlias(a, taken)
+}
+
+// suffixAlias returns alias with the lowest numeric suffix for which taken
+// returns false.
+func suffixAlias(alias string, taken func(string) bool) string {
+	for n := 1; ; n++ {
+		a := fmt.Sprintf("%s.%d", alias, n)
+		if !taken(a) {
+			return a
+		}
+	}
+}
+
+// AddCert adds a trusted certificate entry, resolving any alias collision
+// according to policy. As with Merge, cert is copied before being renamed.
+func (ks *Keystore) AddCert(cert *Cert, policy CollisionPolicy) error {
+	certIdx, kpIdx := ks.findAlias(cert.Alias)
+	skIdx := ks.secretKeyIndex(cert.Alias)
+	switch {
+	case certIdx < 0 && kpIdx < 0 && skIdx < 0:
+		// no collision
+
+	case policy == CollisionSkip:
+		return nil
+
+	case policy == CollisionOverwrite:
+		if certIdx >= 0 && kpIdx < 0 && skIdx < 0 {
+			ks.Certs[certIdx] = cert
+			return nil
+		}
+		ks.removeAlias(cert.Alias)
+
+	case policy == CollisionSuffix:
+		c := *cert
+		c.Alias = ks.uniqueAlias(cert.Alias)
+		cert = &c
+
+	case policy == CollisionFingerprint:
+		c := *cert
+		c.Alias = ks.fingerprintAlias(cert.Alias, cert.DER())
+		cert = &c
+
+	default:
+		return errorf(CodeDuplicateAlias, "duplicate alias %q",
+			cert.Alias)
+	}
+
+	ks.Certs = append(ks.Certs, cert)
+	return nil
+}
+
+// AddKeypair adds a keypair entry, resolving any alias collision according to
+// policy. As with Merge, kp is copied before being renamed. The fingerprint
+// used by CollisionFingerprint is that of the first certificate in the chain.
+func (ks *Keystore) AddKeypair(kp *Keypair, policy CollisionPolicy) error {
+	certIdx, kpIdx := ks.findAlias(kp.Alias)
+	skIdx := ks.secretKeyIndex(kp.Alias)
+	switch {
+	case certIdx < 0 && kpIdx < 0 && skIdx < 0:
+		// no collision
+
+	case policy == CollisionSkip:
+		return nil
+
+	case policy == CollisionOverwrite:
+		if kpIdx >= 0 && certIdx < 0 && skIdx < 0 {
+			ks.Keypairs[kpIdx] = kp
+			return nil
+		}
+		ks.removeAlias(kp.Alias)
+
+	case policy == CollisionSuffix:
+		k := *kp
+		k.Alias = ks.uniqueAlias(kp.Alias)
+		kp = &k
+
+	case policy == CollisionFingerprint:
+		var der []byte
+		if len(kp.CertChain) > 0 {
+			der = kp.CertChain[0].DER()
+		}
+		k := *kp
+		k.Alias = ks.fingerprintAlias(kp.Alias, der)
+		kp = &k
+
+	default:
+		return errorf(CodeDuplicateAlias, "duplicate alias %q",
+			kp.Alias)
+	}
+
+	ks.Keypairs = append(ks.Keypairs, kp)
+	return nil
+}
+
+// AddSecretKey adds a secret key entry, resolving any alias collision
+// according to policy. As with Merge, sk is copied before being renamed.
+// CollisionFingerprint falls back to a numeric suffix, since a secret key has
+// no certificate.
+func (ks *Keystore) AddSecretKey(sk *SecretKey, policy CollisionPolicy) error {
+	certIdx, kpIdx := ks.findAlias(sk.Alias)
+	skIdx := ks.secretKeyIndex(sk.Alias)
+	switch {
+	case certIdx < 0 && kpIdx < 0 && skIdx < 0:
+		// no collision
+
+	case policy == CollisionSkip:
+		return nil
+
+	case policy == CollisionOverwrite:
+		if skIdx >= 0 && certIdx < 0 && kpIdx < 0 {
+			ks.SecretKeys[skIdx] = sk
+			return nil
+		}
+		ks.removeAlias(sk.Alias)
+
+	case policy == CollisionSuffix, policy == CollisionFingerprint:
+		k := *sk
+		k.Alias = ks.uniqueAlias(sk.Alias)
+		sk = &k
+
+	default:
+		return errorf(CodeDuplicateAlias, "duplicate alias %q",
+			sk.Alias)
+	}
+
+	ks.SecretKeys = append(ks.SecretKeys, sk)
+	return nil
+}
@@ -0,0 +1,237 @@
+package jks
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestMerge exercises each of the collision policies.
+func TestMerge(t *testing.T) {
+	t.Run("error", testMerge(CollisionError, nil))
+	t.Run("skip", testMerge(CollisionSkip,
+		[]string{"c:a", "c:b", "k:x"}))
+	t.Run("overwrite", testMerge(CollisionOverwrite,
+		[]string{"c:a", "c:b", "k:x"}))
+	t.Run("suffix", testMerge(CollisionSuffix,
+		[]string{"c:a", "c:b", "c:a.1", "k:x", "k:x.1"}))
+
+	// the keypair has no certificate, so falls back to a numeric suffix
+	t.Run("fingerprint", testMerge(CollisionFingerprint,
+		[]string{"c:a", "c:b", "c:a-4bf5122f", "k:x", "k:x.1"}))
+}
+
+func testMerge(policy CollisionPolicy, exp []string) func(*testing.T) {
+	return func(t *testing.T) {
+		ks := &Keystore{
+			Certs:    []*Cert{{Alias: "a"}, {Alias: "b"}},
+			Keypairs: []*Keypair{{Alias: "x"}},
+		}
+		other := &Keystore{
+			Certs:    []*Cert{{Alias: "a", Raw: []byte{1}}},
+			Keypairs: []*Keypair{{Alias: "x", RawKey: []byte{1}}},
+		}
+
+		err := ks.Merge(other, policy)
+		if exp == nil {
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if len(ks.Certs) != 2 || len(ks.Keypairs) != 1 {
+				t.Error("keystore modified despite error")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, cert := range ks.Certs {
+			got = append(got, "c:"+cert.Alias)
+		}
+		for _, kp := range ks.Keypairs {
+			got = append(got, "k:"+kp.Alias)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("aliases %v ≠ expected %v", got, exp)
+		}
+
+		if policy == CollisionOverwrite && len(ks.Certs[0].Raw) == 0 {
+			t.Error("certificate was not overwritten")
+		}
+		if other.Certs[0].Alias != "a" {
+			t.Error("merge modified source keystore")
+		}
+	}
+}
+
+// TestMergeSecretKeys checks that secret keys are merged, and collide with
+// entries of other types.
+func TestMergeSecretKeys(t *testing.T) {
+	ks := &Keystore{
+		Certs:      []*Cert{{Alias: "a"}},
+		SecretKeys: []*SecretKey{{Alias: "s"}},
+	}
+	other := &Keystore{
+		SecretKeys: []*SecretKey{{Alias: "a"}, {Alias: "S"}},
+	}
+	if err := ks.Merge(other, CollisionError); err == nil {
+		t.Error("expected duplicate alias error")
+	}
+	if err := ks.Merge(other, CollisionSuffix); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, sk := range ks.SecretKeys {
+		got = append(got, sk.Alias)
+	}
+	if exp := []string{"s", "a.1", "S.1"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("aliases %v ≠ expected %v", got, exp)
+	}
+
+	if err := ks.Merge(other, CollisionOverwrite); err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.Certs) != 0 || ks.SecretKeys[0] != other.SecretKeys[1] {
+		t.Error("entries were not overwritten")
+	}
+}
+
+// TestMergeDuplicateSource checks that CollisionError also rejects two entries
+// of the source keystore which share an alias, leaving ks unmodified.
+func TestMergeDuplicateSource(t *testing.T) {
+	ks := &Keystore{Certs: []*Cert{{Alias: "a"}}}
+	other := &Keystore{
+		Certs:    []*Cert{{Alias: "b"}},
+		Keypairs: []*Keypair{{Alias: "B"}},
+	}
+	err := ks.Merge(other, CollisionError)
+	if code := ErrorCode(err); code != CodeDuplicateAlias {
+		t.Fatalf("error code %s ≠ expected %s (%v)", code,
+			CodeDuplicateAlias, err)
+	}
+	if len(ks.Certs) != 1 || len(ks.Keypairs) != 0 {
+		t.Error("keystore modified despite error")
+	}
+}
+
+// TestMergeOrder checks that merged entries keep the order of the file they
+// came from, after the entries already present.
+func TestMergeOrder(t *testing.T) {
+	ks := &Keystore{
+		Certs:    []*Cert{{Alias: "a"}},
+		Keypairs: []*Keypair{{Alias: "x"}},
+		Order:    []string{"x", "a"},
+	}
+	other := &Keystore{
+		Certs:    []*Cert{{Alias: "b"}, {Alias: "x"}},
+		Keypairs: []*Keypair{{Alias: "y"}},
+		Order:    []string{"y", "x", "b"},
+	}
+	if err := ks.Merge(other, CollisionSuffix); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for e := range ks.Entries() {
+		got = append(got, e.EntryAlias())
+	}
+	exp := []string{"x", "a", "y", "x.1", "b"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("order %v ≠ expected %v", got, exp)
+	}
+	if len(other.Order) != 3 {
+		t.Error("merge modified source keystore")
+	}
+}
+
+// TestParseCollisionPolicy checks that policy names round-trip.
+func TestParseCollisionPolicy(t *testing.T) {
+	for _, p := range []CollisionPolicy{CollisionError, CollisionSkip,
+		CollisionOverwrite, CollisionSuffix, CollisionFingerprint} {
+		q, err := ParseCollisionPolicy(p.String())
+		if err != nil || q != p {
+			t.Errorf("%v: round trip gave %v (err %v)", p, q, err)
+		}
+	}
+	if _, err := ParseCollisionPolicy("bogus"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+// TestCopyEntry checks that a keypair can be copied between keystores with
+// different passwords, and the alias returned under each collision policy.
+func TestCopyEntry(t *testing.T) {
+	src := new(Keystore)
+	if _, err := src.GenerateKeypair("x", &KeyGenParams{
+		KeyType: KeyEC,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := src.Pack(&Options{Password: "one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src, err = Parse(raw, &Options{Password: "one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &Keystore{Certs: []*Cert{{
+		Alias: "a",
+		Raw:   src.Keypairs[0].CertChain[0].Raw,
+	}}}
+	for _, tc := range []struct {
+		alias  string
+		policy CollisionPolicy
+		exp    string
+	}{
+		{"x", CollisionError, "x"},
+		{"x", CollisionSkip, ""},
+		{"x", CollisionSuffix, "x.1"},
+		{"x", CollisionOverwrite, "x"},
+	} {
+		got, err := dst.CopyEntry(src, tc.alias, tc.policy)
+		if err != nil || got != tc.exp {
+			t.Errorf("%v: alias %q ≠ expected %q (err %v)",
+				tc.policy, got, tc.exp, err)
+		}
+	}
+	if _, err := dst.CopyEntry(src, "x", CollisionError); err == nil {
+		t.Error("expected duplicate alias error")
+	}
+	if _, err := dst.CopyEntry(src, "missing", CollisionError); err == nil {
+		t.Error("expected error for missing alias")
+	}
+	if dst.Keypairs[0] == src.Keypairs[0] {
+		t.Error("entry was not copied")
+	}
+
+	opts := &Options{Password: "two"}
+	if raw, err = dst.Pack(opts); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	got, err := Parse(raw, opts)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, kp := range got.Keypairs {
+		if kp.PrivKeyErr != nil {
+			t.Errorf("key %q: %v", kp.Alias, kp.PrivKeyErr)
+		}
+	}
+
+	src.SecretKeys = []*SecretKey{{Alias: "aes"}}
+	got2, err := dst.CopyEntry(src, "AES", CollisionError)
+	switch {
+	case err != nil:
+		t.Errorf("CopyEntry secret key: %v", err)
+	case got2 != "aes" || len(dst.SecretKeys) != 1:
+		t.Errorf("CopyEntry secret key: alias %q", got2)
+	case dst.SecretKeys[0] == src.SecretKeys[0]:
+		t.Error("secret key was not copied")
+	}
+
+	src.Keypairs[0].PrivKeyErr = ErrDigestMismatch
+	if _, err := dst.CopyEntry(src, "x", CollisionSuffix); err == nil {
+		t.Error("expected error for undecrypted key")
+	}
+}
@@ -0,0 +1,108 @@
+package jks
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Strings in JKS and JCEKS files (aliases and certificate types), and in Java
+// serialization streams, are written by DataOutputStream.writeUTF in Java's
+// "modified UTF-8". It differs from standard UTF-8 in two ways: NUL is written
+// as the two bytes 0xC0 0x80, so that no zero byte appears; and characters
+// outside the Basic Multilingual Plane are written as their two UTF-16
+// surrogates, each encoded on its own in three bytes (as in CESU-8), rather
+// than in four bytes.
+
+// encodeModifiedUTF8 returns s in Java's modified UTF-8. Invalid UTF-8 in s is
+// written as U+FFFD, since Java strings cannot hold it.
+func encodeModifiedUTF8(s string) []byte {
+	return appendModifiedUTF8(make([]byte, 0, len(s)), s)
+}
+
+// appendModifiedUTF8 appends s in Java's modified UTF-8 to out, as
+// encodeModifiedUTF8 does, and returns the extended slice.
+func appendModifiedUTF8(out []byte, s string) []byte {
+	put := func(r rune) {
+		switch {
+		case r == 0:
+			out = append(out, 0xC0, 0x80)
+		case r < 0x80:
+			out = append(out, byte(r))
+		case r < 0x800:
+			out = append(out, 0xC0|byte(r>>6), 0x80|byte(r&0x3F))
+		default:
+			out = append(out, 0xE0|byte(r>>12),
+				0x80|byte((r>>6)&0x3F), 0x80|byte(r&0x3F))
+		}
+	}
+	for _, r := range s {
+		if r >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(r)
+			put(r1)
+			put(r2)
+		} else {
+			put(r)
+		}
+	}
+	return out
+}
+
+// decodeModifiedUTF8 decodes a string written in Java's modified UTF-8,
+// following DataInputStream.readUTF. A surrogate pair is combined into a
+// single character, while an unpaired surrogate, which Go strings cannot hold,
+// becomes U+FFFD. Since earlier versions of this package wrote standard UTF-8,
+// a plain zero byte and four-byte sequences are also accepted. It returns
+// false if raw is malformed.
+func decodeModifiedUTF8(raw []byte) (string, bool) {
+	// pure ASCII (by far the most common case) needs no decoding
+	ascii := true
+	for _, c := range raw {
+		if c >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return string(raw), true
+	}
+
+	units := make([]uint16, 0, len(raw))
+	for len(raw) > 0 {
+		c := raw[0]
+		switch {
+		case c < 0x80:
+			units = append(units, uint16(c))
+			raw = raw[1:]
+
+		case c&0xE0 == 0xC0:
+			if len(raw) < 2 || raw[1]&0xC0 != 0x80 {
+				return "", false
+			}
+			units = append(units, uint16(c&0x1F)<<6|
+				uint16(raw[1]&0x3F))
+			raw = raw[2:]
+
+		case c&0xF0 == 0xE0:
+			if len(raw) < 3 || raw[1]&0xC0 != 0x80 ||
+				raw[2]&0xC0 != 0x80 {
+				return "", false
+			}
+			units = append(units, uint16(c&0x0F)<<12|
+				uint16(raw[1]&0x3F)<<6|uint16(raw[2]&0x3F))
+			raw = raw[3:]
+
+		case c&0xF8 == 0xF0:
+			r, size := utf8.DecodeRune(raw)
+			if r == utf8.RuneError {
+				return "", false
+			}
+			r1, r2 := utf16.EncodeRune(r)
+			units = append(units, uint16(r1), uint16(r2))
+			raw = raw[size:]
+
+		default:
+			return "", false
+		}
+	}
+	return string(utf16.Decode(units)), true
+}
@@ -0,0 +1,64 @@
+package jks
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestModifiedUTF8 checks encoding and decoding against the bytes Java's
+// DataOutputStream.writeUTF produces.
+func TestModifiedUTF8(t *testing.T) {
+	t.Run("empty", testModifiedUTF8("", nil))
+	t.Run("ascii", testModifiedUTF8("key", []byte("key")))
+	t.Run("nul", testModifiedUTF8("a\x00b", []byte{'a', 0xC0, 0x80, 'b'}))
+	t.Run("two-byte", testModifiedUTF8("é", []byte{0xC3, 0xA9}))
+	t.Run("three-byte", testModifiedUTF8("€", []byte{0xE2, 0x82, 0xAC}))
+	t.Run("surrogates", testModifiedUTF8("\U0001F511", []byte{
+		0xED, 0xA0, 0xBD, 0xED, 0xB4, 0x91,
+	}))
+}
+
+func testModifiedUTF8(s string, exp []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		enc := encodeModifiedUTF8(s)
+		if !bytes.Equal(enc, exp) {
+			t.Errorf("encoding ‘%X’ ≠ expected ‘%X’", enc, exp)
+		}
+		dec, ok := decodeModifiedUTF8(exp)
+		switch {
+		case !ok:
+			t.Errorf("failed to decode ‘%X’", exp)
+		case dec != s:
+			t.Errorf("decoded %q ≠ expected %q", dec, s)
+		}
+	}
+}
+
+// TestDecodeModifiedUTF8 checks the decoder's handling of input that Java
+// would not write.
+func TestDecodeModifiedUTF8(t *testing.T) {
+	t.Run("standard-nul", testDecodeModifiedUTF8([]byte{'a', 0, 'b'},
+		"a\x00b", true))
+	t.Run("standard-four-byte", testDecodeModifiedUTF8(
+		[]byte("\U0001F511"), "\U0001F511", true))
+	t.Run("lone-surrogate", testDecodeModifiedUTF8(
+		[]byte{0xED, 0xA0, 0xBD, 'x'}, "�x", true))
+	t.Run("truncated", testDecodeModifiedUTF8([]byte{'a', 0xE2, 0x82},
+		"", false))
+	t.Run("bad-continuation", testDecodeModifiedUTF8([]byte{0xC3, 'a'},
+		"", false))
+	t.Run("bad-lead", testDecodeModifiedUTF8([]byte{0xFF}, "", false))
+}
+
+func testDecodeModifiedUTF8(raw []byte, exp string, expOK bool,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		dec, ok := decodeModifiedUTF8(raw)
+		switch {
+		case ok != expOK:
+			t.Errorf("ok %t ≠ expected %t", ok, expOK)
+		case dec != exp:
+			t.Errorf("decoded %q ≠ expected %q", dec, exp)
+		}
+	}
+}
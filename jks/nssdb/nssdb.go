@@ -0,0 +1,216 @@
+// Package nssdb reads certificates and their trust settings from a Mozilla
+// NSS certificate database (cert9.db), as used by Firefox, Thunderbird and
+// the system NSS database on RHEL and Fedora.
+//
+// Only the SQLite-based "sql:" database format is supported, not the legacy
+// Berkeley DB cert8.db. Built-in roots supplied by the libnssckbi module are
+// not stored in cert9.db and so are not returned.
+package nssdb
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// PKCS#11 object classes, attribute types and NSS trust values. In cert9.db,
+// each attribute is stored in a column named "a" followed by the attribute
+// type in lower-case hex, and CK_ULONG values are stored as 4-byte big-endian
+// integers.
+const (
+	ckoCertificate = 0x00000001
+	ckoNSSTrust    = 0xCE534353
+
+	ckaClass               = 0x00000000
+	ckaLabel               = 0x00000003
+	ckaValue               = 0x00000011
+	ckaIssuer              = 0x00000081
+	ckaSerialNumber        = 0x00000082
+	ckaTrustServerAuth     = 0xCE536358
+	ckaTrustClientAuth     = 0xCE536359
+	ckaTrustCodeSigning    = 0xCE53635A
+	ckaTrustEmailProtect   = 0xCE53635B
+	ckaTrustCertSHA1Hash   = 0xCE5363B4
+	cktNSSTrustedDelegator = 0xCE534352
+)
+
+// Purpose identifies a use for which a certificate may be trusted as a CA.
+type Purpose int
+
+const (
+	ServerAuth Purpose = iota
+	ClientAuth
+	EmailProtection
+	CodeSigning
+)
+
+// String returns the name of the purpose, as accepted by ParsePurpose.
+func (p Purpose) String() string {
+	switch p {
+	case ServerAuth:
+		return "serverAuth"
+	case ClientAuth:
+		return "clientAuth"
+	case EmailProtection:
+		return "emailProtection"
+	case CodeSigning:
+		return "codeSigning"
+	default:
+		return fmt.Sprintf("Purpose(%d)", int(p))
+	}
+}
+
+// ParsePurpose returns the Purpose with the given name.
+func ParsePurpose(s string) (Purpose, error) {
+	for _, p := range []Purpose{
+		ServerAuth, ClientAuth, EmailProtection, CodeSigning,
+	} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown purpose %q (expected serverAuth, "+
+		"clientAuth, emailProtection or codeSigning)", s)
+}
+
+var purposeAttrs = map[Purpose]uint32{
+	ServerAuth:      ckaTrustServerAuth,
+	ClientAuth:      ckaTrustClientAuth,
+	EmailProtection: ckaTrustEmailProtect,
+	CodeSigning:     ckaTrustCodeSigning,
+}
+
+// Certificate is a certificate held in an NSS database.
+type Certificate struct {
+	// Label is the certificate's nickname.
+	Label string
+
+	// Raw holds the DER-encoded certificate.
+	Raw []byte
+
+	// Cert is the parsed certificate, or nil if parsing failed (in which
+	// case CertErr is set).
+	Cert    *x509.Certificate
+	CertErr error
+
+	// TrustedCA records the purposes for which the certificate is trusted
+	// as an issuer of other certificates.
+	TrustedCA map[Purpose]bool
+}
+
+// ReadFile reads the NSS database at path, which may name either the
+// cert9.db file itself or the directory holding it (with or without a "sql:"
+// prefix).
+func ReadFile(path string) ([]*Certificate, error) {
+	if len(path) > 4 && path[:4] == "sql:" {
+		path = path[4:]
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, "cert9.db")
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return certs, nil
+}
+
+// Parse reads the certificates and trust settings from the content of a
+// cert9.db file.
+func Parse(raw []byte) ([]*Certificate, error) {
+	db, err := openSQLite(raw)
+	if err != nil {
+		return nil, err
+	}
+	cols, rows, err := db.table("nssPublic")
+	if err != nil {
+		return nil, err
+	}
+	colIdx := make(map[string]int, len(cols))
+	for i, col := range cols {
+		colIdx[col] = i
+	}
+	attr := func(row []interface{}, typ uint32) []byte {
+		i, ok := colIdx[fmt.Sprintf("a%x", typ)]
+		if !ok || i >= len(row) {
+			return nil
+		}
+		b, _ := row[i].([]byte)
+		if bytes.Equal(b, nssNull) {
+			return nil
+		}
+		return b
+	}
+	ulong := func(row []interface{}, typ uint32) (uint32, bool) {
+		b := attr(row, typ)
+		if len(b) != 4 {
+			return 0, false
+		}
+		return binary.BigEndian.Uint32(b), true
+	}
+
+	var (
+		certs   []*Certificate
+		bySHA1  = make(map[[sha1.Size]byte]*Certificate)
+		byIssSN = make(map[string]*Certificate)
+		trusts  [][]interface{}
+	)
+	for _, row := range rows {
+		class, _ := ulong(row, ckaClass)
+		switch class {
+		case ckoCertificate:
+			value := attr(row, ckaValue)
+			if value == nil {
+				continue
+			}
+			c := &Certificate{
+				Label:     string(attr(row, ckaLabel)),
+				Raw:       value,
+				TrustedCA: make(map[Purpose]bool),
+			}
+			c.Cert, c.CertErr = x509.ParseCertificate(value)
+			certs = append(certs, c)
+			bySHA1[sha1.Sum(value)] = c
+			byIssSN[string(attr(row, ckaIssuer))+"\x00"+
+				string(attr(row, ckaSerialNumber))] = c
+
+		case ckoNSSTrust:
+			trusts = append(trusts, row)
+		}
+	}
+
+	for _, row := range trusts {
+		var c *Certificate
+		if h := attr(row, ckaTrustCertSHA1Hash); len(h) == sha1.Size {
+			var sum [sha1.Size]byte
+			copy(sum[:], h)
+			c = bySHA1[sum]
+		}
+		if c == nil {
+			c = byIssSN[string(attr(row, ckaIssuer))+"\x00"+
+				string(attr(row, ckaSerialNumber))]
+		}
+		if c == nil {
+			continue
+		}
+		for purpose, typ := range purposeAttrs {
+			if v, ok := ulong(row, typ); ok &&
+				v == cktNSSTrustedDelegator {
+				c.TrustedCA[purpose] = true
+			}
+		}
+	}
+	return certs, nil
+}
+
+// nssNull is the value NSS stores in place of an empty attribute.
+var nssNull = []byte{0xA5, 0x00, 0x5A}
@@ -0,0 +1,145 @@
+package nssdb
+
+import (
+	"crypto/elliptic"
+	"crypto/sha1"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestReadFile builds a cert9.db-like database with the sqlite3 command line
+// tool and checks that certificates and their trust settings are read back.
+// A small page size is used so that the table spans several B-tree pages and
+// certificates spill onto overflow pages.
+func TestReadFile(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not available")
+	}
+
+	const n = 40
+	var sql strings.Builder
+	sql.WriteString("PRAGMA page_size=512;\n" +
+		"CREATE TABLE nssPublic (id PRIMARY KEY UNIQUE ON CONFLICT " +
+		"ABORT, a0, a3, a11, a81, a82, ace536358, ace53635b, " +
+		"ace5363b4);\n")
+	key := jkstest.ECKey(t, elliptic.P256())
+	for i := 0; i < n; i++ {
+		cert := jkstest.SelfSigned(t, key, fmt.Sprintf("ca%d", i))
+		fmt.Fprintf(&sql, "INSERT INTO nssPublic (id, a0, a3, a11, "+
+			"a81, a82) VALUES (%d, X'00000001', X'%x', X'%x', "+
+			"X'%x', X'%x');\n", 2*i, fmt.Sprintf("CA %d", i),
+			cert.Raw, cert.RawIssuer, cert.SerialNumber.Bytes())
+
+		// every third certificate is trusted for TLS, and every
+		// fifth for email; alternate between matching the trust
+		// object by hash and by issuer and serial number
+		server, email := "ce534350", "ce534350"
+		if i%3 == 0 {
+			server = "ce534352"
+		}
+		if i%5 == 0 {
+			email = "ce534352"
+		}
+		if i%2 == 0 {
+			sum := sha1.Sum(cert.Raw)
+			fmt.Fprintf(&sql, "INSERT INTO nssPublic (id, a0, "+
+				"ace536358, ace53635b, ace5363b4) VALUES (%d, "+
+				"X'ce534353', X'%s', X'%s', X'%x');\n",
+				2*i+1, server, email, sum[:])
+		} else {
+			fmt.Fprintf(&sql, "INSERT INTO nssPublic (id, a0, "+
+				"a81, a82, ace536358, ace53635b, ace5363b4) "+
+				"VALUES (%d, X'ce534353', X'%x', X'%x', "+
+				"X'%s', X'%s', X'a5005a');\n", 2*i+1,
+				cert.RawIssuer, cert.SerialNumber.Bytes(),
+				server, email)
+		}
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(sqlite3, filepath.Join(dir, "cert9.db"))
+	cmd.Stdin = strings.NewReader(sql.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, out)
+	}
+
+	certs, err := ReadFile("sql:" + dir)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(certs) != n {
+		t.Fatalf("got %d certificates, expected %d", len(certs), n)
+	}
+	seen := make(map[string]bool)
+	for _, c := range certs {
+		var i int
+		if _, err := fmt.Sscanf(c.Label, "CA %d", &i); err != nil {
+			t.Errorf("unexpected label %q", c.Label)
+			continue
+		}
+		seen[c.Label] = true
+		if c.Cert == nil ||
+			c.Cert.Subject.CommonName != fmt.Sprintf("ca%d", i) {
+			t.Errorf("%s: wrong or unparsed certificate", c.Label)
+		}
+		if c.TrustedCA[ServerAuth] != (i%3 == 0) {
+			t.Errorf("%s: serverAuth trust %v ≠ %v", c.Label,
+				c.TrustedCA[ServerAuth], i%3 == 0)
+		}
+		if c.TrustedCA[EmailProtection] != (i%5 == 0) {
+			t.Errorf("%s: emailProtection trust %v ≠ %v", c.Label,
+				c.TrustedCA[EmailProtection], i%5 == 0)
+		}
+		if c.TrustedCA[CodeSigning] {
+			t.Errorf("%s: unexpected codeSigning trust", c.Label)
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("got %d distinct labels, expected %d", len(seen), n)
+	}
+}
+
+// TestParseNotSQLite checks that other files are rejected.
+func TestParseNotSQLite(t *testing.T) {
+	if _, err := Parse([]byte("not a database")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// TestSQLiteRecordMalformed checks that corrupt records are rejected rather
+// than causing a panic.
+func TestSQLiteRecordMalformed(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		rec  []byte
+	}{
+		{"empty", nil},
+		{"header length zero", []byte{0x00, 0x01}},
+		{"header beyond record", []byte{0x05, 0x01}},
+		{"truncated varint", []byte{0x03, 0x81}},
+		{"bad serial type", []byte{0x02, 0x0A}},
+		{"value beyond record", []byte{0x02, 0x06, 0x01}},
+		{"huge blob", []byte{0x0A, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+			0xFF, 0xFF, 0xFF, 0xFF}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := sqliteRecord(c.rec); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func FuzzSQLiteRecord(f *testing.F) {
+	f.Add([]byte{0x03, 0x01, 0x13, 0x2A, 'x', 'y', 'z'})
+	f.Add([]byte{0x00, 0x01})
+	f.Fuzz(func(t *testing.T, rec []byte) {
+		sqliteRecord(rec)
+	})
+}
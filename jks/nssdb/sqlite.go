@@ -0,0 +1,312 @@
+package nssdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// This file holds a minimal, read-only SQLite reader: just enough to walk the
+// rows of a table in a database file. It does not understand indexes, WAL
+// files or anything other than UTF-8 databases.
+//
+// Reference: https://www.sqlite.org/fileformat.html
+
+const sqliteMagic = "SQLite format 3\x00"
+
+// sqliteDB is an SQLite database file held in memory.
+type sqliteDB struct {
+	raw      []byte
+	pageSize int
+	usable   int
+}
+
+func openSQLite(raw []byte) (*sqliteDB, error) {
+	if len(raw) < 100 || string(raw[:16]) != sqliteMagic {
+		return nil, errors.New("not an SQLite database")
+	}
+	db := &sqliteDB{raw: raw}
+	db.pageSize = int(binary.BigEndian.Uint16(raw[16:]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid page size %d", db.pageSize)
+	}
+	db.usable = db.pageSize - int(raw[20])
+	if enc := binary.BigEndian.Uint32(raw[56:]); enc != 0 && enc != 1 {
+		return nil, fmt.Errorf("unsupported text encoding %d", enc)
+	}
+	return db, nil
+}
+
+// page returns the content of page n (numbered from 1).
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	start := (int(n) - 1) * db.pageSize
+	if n == 0 || start+db.pageSize > len(db.raw) {
+		return nil, fmt.Errorf("page %d out of range", n)
+	}
+	return db.raw[start : start+db.pageSize], nil
+}
+
+// table returns the column names and rows of the named table. Each row holds
+// one value per column: nil, int64, float64, string or []byte.
+func (db *sqliteDB) table(name string) ([]string, [][]interface{}, error) {
+	// sqlite_master: type, name, tbl_name, rootpage, sql
+	master, err := db.rows(1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sqlite_master: %v", err)
+	}
+	for _, row := range master {
+		if len(row) < 5 || row[0] != "table" || row[1] != name {
+			continue
+		}
+		root, ok := row[3].(int64)
+		sql, _ := row[4].(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("table %s: bad root page",
+				name)
+		}
+		cols, err := sqliteColumns(sql)
+		if err != nil {
+			return nil, nil, fmt.Errorf("table %s: %v", name, err)
+		}
+		rows, err := db.rows(uint32(root))
+		if err != nil {
+			return nil, nil, fmt.Errorf("table %s: %v", name, err)
+		}
+		return cols, rows, nil
+	}
+	return nil, nil, fmt.Errorf("no table %s", name)
+}
+
+// rows walks the table B-tree rooted at page root, returning every record.
+func (db *sqliteDB) rows(root uint32) ([][]interface{}, error) {
+	var out [][]interface{}
+	visited := make(map[uint32]bool)
+	var walk func(n uint32) error
+	walk = func(n uint32) error {
+		if visited[n] {
+			return fmt.Errorf("page %d: loop in B-tree", n)
+		}
+		visited[n] = true
+
+		pg, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		hdr := pg
+		if n == 1 {
+			hdr = pg[100:]
+		}
+		if len(hdr) < 8 {
+			return fmt.Errorf("page %d: truncated", n)
+		}
+		ncells := int(binary.BigEndian.Uint16(hdr[3:]))
+		switch hdr[0] {
+		case 0x0D: // leaf table page
+			ptrs := hdr[8:]
+			if len(ptrs) < 2*ncells {
+				return fmt.Errorf("page %d: truncated", n)
+			}
+			for i := 0; i < ncells; i++ {
+				off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+				rec, err := db.leafCell(pg, off)
+				if err != nil {
+					return fmt.Errorf("page %d: %v", n, err)
+				}
+				out = append(out, rec)
+			}
+			return nil
+
+		case 0x05: // interior table page
+			if len(hdr) < 12+2*ncells {
+				return fmt.Errorf("page %d: truncated", n)
+			}
+			ptrs := hdr[12:]
+			for i := 0; i < ncells; i++ {
+				off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+				if off+4 > len(pg) {
+					return fmt.Errorf("page %d: bad cell",
+						n)
+				}
+				err := walk(binary.BigEndian.Uint32(pg[off:]))
+				if err != nil {
+					return err
+				}
+			}
+			return walk(binary.BigEndian.Uint32(hdr[8:]))
+
+		default:
+			return fmt.Errorf("page %d: unexpected page type "+
+				"%#x", n, hdr[0])
+		}
+	}
+	return out, walk(root)
+}
+
+// leafCell decodes the record held in the table leaf cell at offset off of pg,
+// following overflow pages as necessary.
+func (db *sqliteDB) leafCell(pg []byte, off int) ([]interface{}, error) {
+	if off >= len(pg) {
+		return nil, errors.New("bad cell offset")
+	}
+	cell := pg[off:]
+	size, n := sqliteVarint(cell)
+	if n == 0 {
+		return nil, errors.New("bad cell")
+	}
+	cell = cell[n:]
+	if _, n = sqliteVarint(cell); n == 0 { // rowid
+		return nil, errors.New("bad cell")
+	}
+	cell = cell[n:]
+	if size > uint64(len(db.raw)) {
+		return nil, errors.New("bad payload size")
+	}
+
+	// work out how much of the payload is stored on this page
+	p, u := int(size), db.usable
+	local := p
+	if x := u - 35; p > x {
+		m := (u-12)*32/255 - 23
+		local = m + (p-m)%(u-4)
+		if local > x {
+			local = m
+		}
+	}
+	if local > len(cell) {
+		return nil, errors.New("truncated cell")
+	}
+	payload := append([]byte(nil), cell[:local]...)
+
+	if local < p {
+		if local+4 > len(cell) {
+			return nil, errors.New("truncated cell")
+		}
+		next := binary.BigEndian.Uint32(cell[local:])
+		for len(payload) < p {
+			ovf, err := db.page(next)
+			if err != nil {
+				return nil, fmt.Errorf("overflow: %v", err)
+			}
+			chunk := ovf[4:u]
+			if rem := p - len(payload); len(chunk) > rem {
+				chunk = chunk[:rem]
+			}
+			payload = append(payload, chunk...)
+			next = binary.BigEndian.Uint32(ovf)
+		}
+	}
+	return sqliteRecord(payload)
+}
+
+// sqliteRecord decodes a record in the SQLite record format.
+func sqliteRecord(rec []byte) ([]interface{}, error) {
+	hdrLen, n := sqliteVarint(rec)
+	if n == 0 || hdrLen < uint64(n) || hdrLen > uint64(len(rec)) {
+		return nil, errors.New("bad record header")
+	}
+	hdr, body := rec[n:hdrLen], rec[hdrLen:]
+
+	var out []interface{}
+	for len(hdr) > 0 {
+		st, n := sqliteVarint(hdr)
+		if n == 0 {
+			return nil, errors.New("bad record header")
+		}
+		hdr = hdr[n:]
+
+		var size int
+		switch {
+		case st <= 4:
+			size = int(st)
+		case st == 5:
+			size = 6
+		case st == 6, st == 7:
+			size = 8
+		case st == 8, st == 9:
+			size = 0
+		case st >= 12 && (st-12)/2 <= uint64(len(body)):
+			size = int((st - 12) / 2)
+		case st >= 12:
+			return nil, errors.New("truncated record")
+		default:
+			return nil, fmt.Errorf("bad serial type %d", st)
+		}
+		if size > len(body) {
+			return nil, errors.New("truncated record")
+		}
+		v := body[:size]
+		body = body[size:]
+
+		switch {
+		case st == 0:
+			out = append(out, nil)
+		case st <= 6:
+			var i int64
+			if v[0]&0x80 != 0 {
+				i = -1
+			}
+			for _, c := range v {
+				i = i<<8 | int64(c)
+			}
+			out = append(out, i)
+		case st == 7:
+			out = append(out, math.Float64frombits(
+				binary.BigEndian.Uint64(v)))
+		case st == 8:
+			out = append(out, int64(0))
+		case st == 9:
+			out = append(out, int64(1))
+		case st%2 == 0:
+			out = append(out, append([]byte(nil), v...))
+		default:
+			out = append(out, string(v))
+		}
+	}
+	return out, nil
+}
+
+// sqliteVarint decodes an SQLite variable-length integer, returning the value
+// and the number of bytes consumed (0 if b is too short).
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7F)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0 // not reached
+}
+
+// sqliteColumns extracts the column names from a CREATE TABLE statement. It
+// handles the simple statements NSS uses, not the full SQL grammar.
+func sqliteColumns(sql string) ([]string, error) {
+	open, end := strings.IndexByte(sql, '('), strings.LastIndexByte(sql, ')')
+	if open < 0 || end < open {
+		return nil, errors.New("cannot parse table definition")
+	}
+	var cols []string
+	for _, def := range strings.Split(sql[open+1:end], ",") {
+		f := strings.Fields(def)
+		if len(f) == 0 {
+			return nil, errors.New("cannot parse table definition")
+		}
+		switch strings.ToUpper(f[0]) {
+		case "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT":
+			continue // table constraint
+		}
+		cols = append(cols, strings.Trim(f[0], "\"`[]"))
+	}
+	return cols, nil
+}
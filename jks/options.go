@@ -0,0 +1,226 @@
+package jks
+
+import (
+	"crypto/elliptic"
+)
+
+// DefaultOptions returns a new Options with the default settings: an empty
+// password which is used to verify the digest, Java8 compatibility, and no key
+// size, curve, signature algorithm or chain length policy. It is what Pack and
+// the other functions taking an *Options use when passed nil; Parse differs
+// only in that it skips digest verification. The caller may modify the result
+// freely.
+func DefaultOptions() *Options {
+	return &Options{
+		KeyPasswords: make(map[string]string),
+	}
+}
+
+// Validate checks opts for settings that are out of range or that contradict
+// each other, such as a curve in AllowedCurves that is smaller than
+// MinECBits. It returns a *ValidationError listing all problems found, or nil.
+// Parse, Pack and the other functions taking an *Options call Validate first
+// and refuse to run if it fails.
+func (opts *Options) Validate() error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems,
+			errorf(CodeInvalidOptions, format, args...))
+	}
+
+	if opts.Compatibility < 0 ||
+		int(opts.Compatibility) >= len(compatibilityNames) {
+		problem("unknown compatibility profile %v", opts.Compatibility)
+	}
+	if opts.KeyProtection < 0 ||
+		int(opts.KeyProtection) >= len(keyProtectionNames) {
+		problem("unknown key protection %v", opts.KeyProtection)
+	}
+	if opts.PasswordEncoding < 0 ||
+		int(opts.PasswordEncoding) >= len(passwordEncodingNames) {
+		problem("unknown password encoding %v", opts.PasswordEncoding)
+	}
+	if opts.Version < 0 || opts.Version > 2 {
+		problem("unknown file format version %d", opts.Version)
+	}
+	if opts.MinRSABits < 0 {
+		problem("MinRSABits %d is negative", opts.MinRSABits)
+	}
+	if opts.MinECBits < 0 {
+		problem("MinECBits %d is negative", opts.MinECBits)
+	}
+	if opts.MaxChainLength < 0 {
+		problem("MaxChainLength %d is negative", opts.MaxChainLength)
+	}
+	if opts.MaxEntryCount < 0 {
+		problem("MaxEntryCount %d is negative", opts.MaxEntryCount)
+	}
+	if opts.MaxCertSize < 0 {
+		problem("MaxCertSize %d is negative", opts.MaxCertSize)
+	}
+	if opts.MaxKeySize < 0 {
+		problem("MaxKeySize %d is negative", opts.MaxKeySize)
+	}
+
+	for _, name := range opts.AllowedCurves {
+		curve := curveByName(name)
+		switch {
+		case curve == nil:
+			problem("unknown curve %q in AllowedCurves", name)
+		case curve.Params().BitSize < opts.MinECBits:
+			problem("allowed curve %s is below the minimum of %d "+
+				"bits", name, opts.MinECBits)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// curveByName returns the curve named as in elliptic.CurveParams, or nil if
+// the name is not one we know of.
+func curveByName(name string) elliptic.Curve {
+	for _, curve := range []elliptic.Curve{
+		elliptic.P224(), elliptic.P256(), elliptic.P384(),
+		elliptic.P521(),
+	} {
+		if curve.Params().Name == name {
+			return curve
+		}
+	}
+	return nil
+}
+
+// keyPassword returns the password for the private or secret key with the
+// given alias: its entry in KeyPasswords if there is one, or else the result
+// of KeyPasswordFunc, or else the keystore password.
+func (opts *Options) keyPassword(alias string) ([]byte, error) {
+	if passwd, ok := opts.KeyPasswords[alias]; ok {
+		return []byte(passwd), nil
+	}
+	if opts.KeyPasswordFunc != nil {
+		passwd, err := opts.KeyPasswordFunc(alias)
+		if err != nil {
+			return nil, errorf("", "key %q: failed to get "+
+				"password: %v", alias, err)
+		}
+		if passwd != nil {
+			if opts.Wipe {
+				opts.fetched = append(opts.fetched, passwd)
+			}
+			return passwd, nil
+		}
+	}
+	return opts.password(), nil
+}
+
+// skipEntry reports whether the entry with the given alias is to be skipped
+// under CertsOnly and Alias; key is set for private and secret key entries.
+func (opts *Options) skipEntry(alias string, key bool) bool {
+	if key && opts.CertsOnly {
+		return true
+	}
+	return opts.Alias != "" &&
+		NormalizeAlias(alias) != NormalizeAlias(opts.Alias)
+}
+
+// filtered reports whether CertsOnly or Alias is set.
+func (opts *Options) filtered() bool {
+	return opts.CertsOnly || opts.Alias != ""
+}
+
+// packKeyPassword returns the password under which Pack encrypts the private
+// key with the given alias: its entry in NewKeyPasswords if there is one, or
+// else its password as for keyPassword.
+func (opts *Options) packKeyPassword(alias string) ([]byte, error) {
+	if passwd, ok := opts.NewKeyPasswords[alias]; ok {
+		return []byte(passwd), nil
+	}
+	return opts.keyPassword(alias)
+}
+
+// rekey returns kp, or, if kp's private key was not decrypted but
+// NewKeyPasswords gives it a new password, a copy of kp whose key has been
+// decrypted with its current password so that it may be encrypted afresh.
+func (opts *Options) rekey(kp *Keypair) (*Keypair, error) {
+	if _, ok := opts.NewKeyPasswords[kp.Alias]; !ok ||
+		kp.PrivateKey != nil || len(kp.EncryptedKey) == 0 {
+		return kp, nil
+	}
+	passwd, err := opts.keyPassword(kp.Alias)
+	if err != nil {
+		return nil, err
+	}
+	rekeyed := *kp
+	rekeyed.RawKey, err = decryptPKCS8(kp.EncryptedKey, passwd,
+		opts.PasswordEncoding)
+	if err != nil {
+		return nil, errorf("", "key %q: %v", kp.Alias, err)
+	}
+	defer clear(rekeyed.RawKey)
+	rekeyed.PrivKeyErr = nil
+	if rekeyed.parseRawKey(); rekeyed.PrivKeyErr != nil {
+		return nil, errorf("", "key %q: %v", kp.Alias,
+			rekeyed.PrivKeyErr)
+	}
+	return &rekeyed, nil
+}
+
+// password returns the keystore password: the one fetched from Passwords if
+// there was one, or else Password.
+func (opts *Options) password() []byte {
+	if opts.passwd != nil {
+		return opts.passwd
+	}
+	return []byte(opts.Password)
+}
+
+// wipePasswords zeroes the passwords fetched from Passwords and
+// KeyPasswordFunc, if Wipe is set. opts must have come from normalize.
+func (opts *Options) wipePasswords() {
+	if !opts.Wipe {
+		return
+	}
+	for _, passwd := range opts.fetched {
+		clear(passwd)
+	}
+	opts.fetched = nil
+}
+
+// normalize returns opts, or DefaultOptions() if opts is nil, after checking
+// it with Validate and fetching any passwords from opts.Passwords.
+func (opts *Options) normalize() (*Options, error) {
+	if opts == nil {
+		return DefaultOptions(), nil
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, errorf(CodeInvalidOptions, "invalid options: %v",
+			err)
+	}
+	return opts.resolvePasswords()
+}
+
+// parseOptions is normalize for the parsing functions, which skip digest
+// verification if opts is nil.
+func (opts *Options) parseOptions() (*Options, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+		opts.SkipVerifyDigest = true
+		return opts, nil
+	}
+	return opts.normalize()
+}
+
+// version returns the file format version that Pack writes.
+func (opts *Options) version() uint32 {
+	if opts.Version == 1 {
+		return 1
+	}
+	return 2
+}
+
+// errNilOptions is returned by functions which must update the caller's
+// options, and so cannot substitute DefaultOptions for nil.
+var errNilOptions = newError(CodeInvalidOptions, "options must not be nil")
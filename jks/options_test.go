@@ -0,0 +1,146 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestNilOptions checks that nil options behave as DefaultOptions.
+func TestNilOptions(t *testing.T) {
+	b := jkstest.New(t, "").CA("root").RSAKeypair("server", 2048)
+
+	raw, err := b.Keystore().Pack(nil)
+	if err != nil {
+		t.Fatalf("Pack(nil): %v", err)
+	}
+	if _, err = jks.Parse(raw, jks.DefaultOptions()); err != nil {
+		t.Fatalf("Parse with DefaultOptions: %v", err)
+	}
+	ks, err := jks.Parse(raw, nil)
+	switch {
+	case err != nil:
+		t.Fatalf("Parse(nil): %v", err)
+	case len(ks.Keypairs) != 1 || ks.Keypairs[0].PrivKeyErr != nil:
+		t.Errorf("unexpected keystore content")
+	}
+	if err = ks.Validate(nil); err != nil {
+		t.Errorf("Validate(nil): %v", err)
+	}
+}
+
+// TestOptionsValidate checks that contradictory options are refused.
+func TestOptionsValidate(t *testing.T) {
+	t.Run("default", testOptionsValidate(jks.DefaultOptions(), true))
+	t.Run("negative", testOptionsValidate(&jks.Options{
+		MinRSABits: -1,
+	}, false))
+	t.Run("compatibility", testOptionsValidate(&jks.Options{
+		Compatibility: jks.Java17 + 1,
+	}, false))
+	t.Run("version", testOptionsValidate(&jks.Options{
+		Version: 3,
+	}, false))
+	t.Run("unknown curve", testOptionsValidate(&jks.Options{
+		AllowedCurves: []string{"p-256"},
+	}, false))
+	t.Run("curve too small", testOptionsValidate(&jks.Options{
+		AllowedCurves: []string{"P-256", "P-384"},
+		MinECBits:     384,
+	}, false))
+}
+
+func testOptionsValidate(opts *jks.Options, valid bool) func(*testing.T) {
+	return func(t *testing.T) {
+		err := opts.Validate()
+		if (err == nil) != valid {
+			t.Fatalf("Validate: %v (expected valid=%t)", err, valid)
+		}
+		_, err = (&jks.Keystore{}).Pack(opts)
+		if (err == nil) != valid {
+			t.Errorf("Pack: %v (expected valid=%t)", err, valid)
+		}
+	}
+}
+
+// TestKeyPasswordFunc checks that KeyPasswordFunc supplies the passwords of
+// keys without an entry in KeyPasswords, when packing and when parsing, and
+// that its errors are reported.
+func TestKeyPasswordFunc(t *testing.T) {
+	var asked []string
+	passwords := func(alias string) ([]byte, error) {
+		asked = append(asked, alias)
+		switch alias {
+		case "a":
+			return []byte("password a"), nil
+		case "broken":
+			return nil, errors.New("no such secret")
+		}
+		return nil, nil
+	}
+
+	b := jkstest.New(t, "password").ECKeypair("a", elliptic.P256()).
+		ECKeypair("b", elliptic.P256()).
+		ECKeypair("c", elliptic.P256())
+	opts := &jks.Options{
+		Password:        "password",
+		KeyPasswords:    map[string]string{"c": "password c"},
+		KeyPasswordFunc: passwords,
+	}
+	raw, err := b.Keystore().Pack(opts)
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	if exp := "a,b"; strings.Join(asked, ",") != exp {
+		t.Errorf("Pack asked for %q ≠ expected %q", asked, exp)
+	}
+
+	ks, err := jks.Parse(raw, &jks.Options{
+		Password: "password",
+		KeyPasswords: map[string]string{
+			"a": "password a",
+			"c": "password c",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, kp := range ks.Keypairs {
+		if kp.PrivKeyErr != nil {
+			t.Errorf("key %q: %v", kp.Alias, kp.PrivKeyErr)
+		}
+	}
+
+	asked = nil
+	if ks, err = jks.Parse(raw, opts); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, kp := range ks.Keypairs {
+		if kp.PrivKeyErr != nil {
+			t.Errorf("key %q: %v", kp.Alias, kp.PrivKeyErr)
+		}
+	}
+	if exp := "a,b"; strings.Join(asked, ",") != exp {
+		t.Errorf("Parse asked for %q ≠ expected %q", asked, exp)
+	}
+
+	b = jkstest.New(t, "password").ECKeypair("broken", elliptic.P256())
+	_, err = b.Keystore().Pack(opts)
+	if err == nil || !strings.Contains(err.Error(), "no such secret") {
+		t.Errorf("Pack: expected password error but got %v", err)
+	}
+	ks, err = jks.Parse(b.Bytes(), opts)
+	switch {
+	case err != nil:
+		t.Errorf("Parse: %v", err)
+	case ks.Keypairs[0].PrivKeyErr == nil ||
+		!strings.Contains(ks.Keypairs[0].PrivKeyErr.Error(),
+			"no such secret"):
+		t.Errorf("expected password error but got %v",
+			ks.Keypairs[0].PrivKeyErr)
+	}
+}
@@ -0,0 +1,101 @@
+package jks
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// packOrder returns the entries of ks in the order that Pack writes them: as
+// for entries, unless opts.Deterministic is set, in which case they are
+// sorted by alias as written. ks is not modified.
+func (ks *Keystore) packOrder(opts *Options) []Entry {
+	entries := ks.entries()
+	if opts.Deterministic {
+		slices.SortStableFunc(entries, func(a, b Entry) int {
+			return strings.Compare(opts.packAlias(a.EntryAlias()),
+				opts.packAlias(b.EntryAlias()))
+		})
+	}
+	return entries
+}
+
+// entries returns the entries of ks in file order: those whose aliases are
+// listed in ks.Order come first, in that order, so that a keystore which is
+// parsed, modified and packed again keeps the order of its file; they are
+// followed by the trusted certificates, keypairs and secret keys which are
+// not listed, each in slice order. nil entries are skipped.
+func (ks *Keystore) entries() []Entry {
+	entries := make([]Entry, 0,
+		len(ks.Certs)+len(ks.Keypairs)+len(ks.SecretKeys))
+	for _, cert := range ks.Certs {
+		if cert != nil {
+			entries = append(entries, cert)
+		}
+	}
+	for _, kp := range ks.Keypairs {
+		if kp != nil {
+			entries = append(entries, kp)
+		}
+	}
+	for _, sk := range ks.SecretKeys {
+		if sk != nil {
+			entries = append(entries, sk)
+		}
+	}
+
+	if len(ks.Order) != 0 {
+		rank := make(map[string]int, len(ks.Order))
+		for i, alias := range ks.Order {
+			alias = NormalizeAlias(alias)
+			if _, ok := rank[alias]; !ok {
+				rank[alias] = i
+			}
+		}
+		pos := func(e Entry) int {
+			if i, ok := rank[NormalizeAlias(e.EntryAlias())]; ok {
+				return i
+			}
+			return len(ks.Order)
+		}
+		slices.SortStableFunc(entries, func(a, b Entry) int {
+			return cmp.Compare(pos(a), pos(b))
+		})
+	}
+	return entries
+}
+
+// renameOrder replaces from with to in ks.Order, so that a renamed entry keeps
+// its place. The slice is copied first, since it may be shared with another
+// Keystore.
+func (ks *Keystore) renameOrder(from, to string) {
+	from = NormalizeAlias(from)
+	i := slices.IndexFunc(ks.Order, func(alias string) bool {
+		return NormalizeAlias(alias) == from
+	})
+	if i >= 0 {
+		ks.Order = slices.Clone(ks.Order)
+		ks.Order[i] = to
+	}
+}
+
+// removeOrder removes alias from ks.Order, so that an entry added later under
+// the same alias does not take the deleted entry's place. As for renameOrder,
+// the slice is copied first.
+func (ks *Keystore) removeOrder(alias string) {
+	alias = NormalizeAlias(alias)
+	match := func(a string) bool {
+		return NormalizeAlias(a) == alias
+	}
+	if slices.ContainsFunc(ks.Order, match) {
+		ks.Order = slices.DeleteFunc(slices.Clone(ks.Order), match)
+	}
+}
+
+// inOrder returns true if alias is listed in ks.Order.
+func (ks *Keystore) inOrder(alias string) bool {
+	alias = NormalizeAlias(alias)
+	return slices.ContainsFunc(ks.Order, func(a string) bool {
+		return NormalizeAlias(a) == alias
+	})
+}
@@ -0,0 +1,244 @@
+package jks
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PasswordProvider supplies the keystore password and private key passwords,
+// so that they may be fetched from a secret manager (Vault, a KMS, SOPS and the
+// like) by setting Options.Passwords, rather than by every caller. If
+// Options.Wipe is set, the slices returned are zeroed once they have been used,
+// so each call should return a fresh slice.
+type PasswordProvider interface {
+	// StorePassword returns the keystore password.
+	StorePassword() ([]byte, error)
+
+	// KeyPassword returns the password for the private or secret key with
+	// the given alias, or nil if the keystore password should be used.
+	KeyPassword(alias string) ([]byte, error)
+}
+
+// StaticPasswords is a PasswordProvider holding fixed passwords.
+type StaticPasswords struct {
+	// Store is the keystore password.
+	Store []byte
+
+	// Keys maps aliases to key passwords. Keys without an entry use the
+	// keystore password.
+	Keys map[string][]byte
+}
+
+// StorePassword returns a copy of sp.Store.
+func (sp *StaticPasswords) StorePassword() ([]byte, error) {
+	return bytes.Clone(sp.Store), nil
+}
+
+// KeyPassword returns a copy of the entry for alias in sp.Keys, or nil.
+func (sp *StaticPasswords) KeyPassword(alias string) ([]byte, error) {
+	return bytes.Clone(sp.Keys[alias]), nil
+}
+
+// EnvPasswords is a PasswordProvider that reads passwords from environment
+// variables, as is usual for containers whose secrets are injected by the
+// orchestrator.
+type EnvPasswords struct {
+	// Store names the variable holding the keystore password. It must be
+	// set.
+	Store string
+
+	// KeyPrefix, if not empty, is the prefix of the variables holding key
+	// passwords. The rest of the name is the alias in upper case, with any
+	// character other than a letter or digit replaced by an underscore:
+	// with KeyPrefix "KEYPASS_", the password for alias "my-key" is read
+	// from KEYPASS_MY_KEY. Keys whose variable is not set use the keystore
+	// password.
+	KeyPrefix string
+}
+
+// StorePassword returns the value of the variable named by ep.Store, or an
+// error with CodeMissingData if it is not set.
+func (ep *EnvPasswords) StorePassword() ([]byte, error) {
+	passwd, ok := os.LookupEnv(ep.Store)
+	if !ok {
+		return nil, errorf(CodeMissingData, "environment variable %s "+
+			"not set", ep.Store)
+	}
+	return []byte(passwd), nil
+}
+
+// KeyPassword returns the value of the variable for alias, or nil if it is
+// not set.
+func (ep *EnvPasswords) KeyPassword(alias string) ([]byte, error) {
+	if ep.KeyPrefix == "" {
+		return nil, nil
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, alias)
+	passwd, ok := os.LookupEnv(ep.KeyPrefix + name)
+	if !ok {
+		return nil, nil
+	}
+	return []byte(passwd), nil
+}
+
+// resolvePasswords returns opts, or a copy of it if opts.Passwords is set or
+// opts.Wipe is, so that the passwords fetched may be recorded. The copy's
+// keystore password is fetched from opts.Passwords, and its KeyPasswordFunc
+// falls back to opts.Passwords.
+func (opts *Options) resolvePasswords() (*Options, error) {
+	if opts.Passwords == nil && !opts.Wipe {
+		return opts, nil
+	}
+	resolved := *opts
+	resolved.fetched = nil
+	if opts.Passwords == nil {
+		return &resolved, nil
+	}
+
+	passwd, err := opts.Passwords.StorePassword()
+	if err != nil {
+		return nil, errorf("", "failed to get keystore password: %v",
+			err)
+	}
+	if passwd == nil {
+		passwd = []byte{}
+	}
+	resolved.passwd = passwd
+	resolved.fetched = [][]byte{passwd}
+	resolved.Passwords = nil
+	resolved.KeyPasswordFunc = func(alias string) ([]byte, error) {
+		if opts.KeyPasswordFunc != nil {
+			passwd, err := opts.KeyPasswordFunc(alias)
+			if passwd != nil || err != nil {
+				return passwd, err
+			}
+		}
+		return opts.Passwords.KeyPassword(alias)
+	}
+	return &resolved, nil
+}
+
+// PasswordEncoding selects how passwords are converted to bytes for the JKS
+// file digest and for the JKS private key protection algorithm. PKCS#12 and
+// JCEKS files are not affected.
+type PasswordEncoding int
+
+const (
+	// PasswordUTF16BE encodes the password as UTF-16 code units in
+	// big-endian byte order, with characters outside the Basic
+	// Multilingual Plane written as surrogate pairs. This is how Java
+	// encodes the char[] it is given, so it is the only encoding that
+	// interoperates with keytool for passwords that are not plain ASCII.
+	PasswordUTF16BE PasswordEncoding = iota
+
+	// PasswordWidenedBytes widens each byte of the password's UTF-8
+	// encoding into a 16-bit unit, as some non-Java tools do. It is only
+	// needed to read or update a keystore written by such a tool with a
+	// non-ASCII password: for ASCII passwords, both encodings agree.
+	PasswordWidenedBytes
+)
+
+var passwordEncodingNames = []string{
+	PasswordUTF16BE:      "utf16be",
+	PasswordWidenedBytes: "widened-bytes",
+}
+
+// String returns the name of the encoding, as accepted by
+// ParsePasswordEncoding.
+func (e PasswordEncoding) String() string {
+	if e >= 0 && int(e) < len(passwordEncodingNames) {
+		return passwordEncodingNames[e]
+	}
+	return fmt.Sprintf("PasswordEncoding(%d)", int(e))
+}
+
+// ParsePasswordEncoding returns the encoding with the given name (e.g.
+// "utf16be").
+func ParsePasswordEncoding(name string) (PasswordEncoding, error) {
+	for e, n := range passwordEncodingNames {
+		if strings.EqualFold(n, name) {
+			return PasswordEncoding(e), nil
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown password encoding %q "+
+		"(expected one of %s)", name,
+		strings.Join(passwordEncodingNames, ", "))
+}
+
+// encode returns passwd, which is UTF-8, in encoding e.
+func (e PasswordEncoding) encode(passwd []byte) []byte {
+	if e != PasswordWidenedBytes {
+		return passwordUTF16(passwd)
+	}
+	u := make([]byte, 0, 2*len(passwd))
+	for _, c := range passwd {
+		u = append(u, 0, c)
+	}
+	return u
+}
+
+// ChangePassword changes the keystore password of a JKS or JCEKS file, as
+// "keytool -storepasswd" does, returning the file packed as per Pack with
+// opts. The file's digest must match oldPassword. Private keys protected by
+// the keystore password are decrypted and re-encrypted under newPassword;
+// those with passwords of their own, from opts.KeyPasswords or
+// opts.KeyPasswordFunc, keep them and are written back without being
+// decrypted. opts.Password and opts.Passwords are ignored.
+func ChangePassword(raw []byte, oldPassword, newPassword string,
+	opts *Options,
+) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	oldOpts := *opts
+	oldOpts.Password, oldOpts.Passwords = oldPassword, nil
+	oldOpts.SkipVerifyDigest, oldOpts.SkipKeyDecryption = false, true
+	ks, err := Parse(raw, &oldOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	keyOpts, err := oldOpts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	defer keyOpts.wipePasswords()
+
+	newOpts := *opts
+	newOpts.Password, newOpts.Passwords = newPassword, nil
+	newOpts.KeyPasswords = make(map[string]string, len(ks.Keypairs))
+	for alias, passwd := range opts.KeyPasswords {
+		newOpts.KeyPasswords[alias] = passwd
+	}
+	for _, kp := range ks.Keypairs {
+		passwd, err := keyOpts.keyPassword(kp.Alias)
+		if err != nil {
+			return nil, err
+		}
+		if string(passwd) != oldPassword {
+			continue
+		}
+		kp.RawKey, err = decryptPKCS8(kp.EncryptedKey, passwd,
+			opts.PasswordEncoding)
+		if err != nil {
+			return nil, errorf("", "key %q: %v", kp.Alias, err)
+		}
+		kp.PrivKeyErr = nil
+		if kp.parseRawKey(); kp.PrivKeyErr != nil {
+			return nil, errorf("", "key %q: %v", kp.Alias,
+				kp.PrivKeyErr)
+		}
+		newOpts.KeyPasswords[kp.Alias] = newPassword
+	}
+	return ks.Pack(&newOpts)
+}
@@ -0,0 +1,298 @@
+package jks_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestPasswordProvider packs and parses a keystore with passwords supplied by
+// each of the included providers.
+func TestPasswordProvider(t *testing.T) {
+	b := jkstest.New(t, "store pass").CA("root").
+		ECKeypair("my-key", elliptic.P256()).
+		ECKeypair("other", elliptic.P256()).
+		KeyPassword("my-key", "key pass")
+
+	t.Setenv("TEST_STOREPASS", "store pass")
+	t.Setenv("TEST_KEYPASS_MY_KEY", "key pass")
+	t.Run("env", testPasswordProvider(b, &jks.EnvPasswords{
+		Store:     "TEST_STOREPASS",
+		KeyPrefix: "TEST_KEYPASS_",
+	}))
+	t.Run("static", testPasswordProvider(b, &jks.StaticPasswords{
+		Store: []byte("store pass"),
+		Keys:  map[string][]byte{"my-key": []byte("key pass")},
+	}))
+
+	_, err := jks.Parse(b.Bytes(), &jks.Options{
+		Passwords: &jks.EnvPasswords{Store: "TEST_UNSET"},
+	})
+	if code := jks.ErrorCode(err); code != jks.CodeMissingData {
+		t.Errorf("unset variable: error code %s ≠ expected %s (%v)",
+			code, jks.CodeMissingData, err)
+	}
+}
+
+func testPasswordProvider(b *jkstest.Builder, pp jks.PasswordProvider,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		opts := &jks.Options{Passwords: pp}
+		ks, err := jks.Parse(b.Bytes(), opts)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		for _, kp := range ks.Keypairs {
+			if kp.PrivKeyErr != nil {
+				t.Errorf("key %q: %v", kp.Alias, kp.PrivKeyErr)
+			}
+		}
+
+		raw, err := ks.Pack(opts)
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		ks, err = jks.Parse(raw, b.Options())
+		switch {
+		case err != nil:
+			t.Errorf("Parse with Builder options: %v", err)
+		case ks.Keypairs[0].PrivKeyErr != nil:
+			t.Errorf("key %q: %v", ks.Keypairs[0].Alias,
+				ks.Keypairs[0].PrivKeyErr)
+		}
+	}
+}
+
+// TestWipe checks that Options.Wipe zeroes the passwords handed over by
+// KeyPasswordFunc and drops RawKey, and that Keystore.Destroy leaves a keystore
+// which can still be packed and decrypted again.
+func TestWipe(t *testing.T) {
+	b := jkstest.New(t, "password").ECKeypair("key", elliptic.P256()).
+		KeyPassword("key", "key pass")
+
+	var handed [][]byte
+	opts := &jks.Options{
+		Password: "password",
+		KeyPasswordFunc: func(string) ([]byte, error) {
+			passwd := []byte("key pass")
+			handed = append(handed, passwd)
+			return passwd, nil
+		},
+		Wipe: true,
+	}
+	ks, err := jks.Parse(b.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	kp := ks.Keypairs[0]
+	switch {
+	case kp.PrivKeyErr != nil:
+		t.Fatalf("PrivKeyErr: %v", kp.PrivKeyErr)
+	case kp.RawKey != nil:
+		t.Errorf("RawKey kept despite Wipe")
+	}
+	for _, passwd := range handed {
+		if string(passwd) != "\x00\x00\x00\x00\x00\x00\x00\x00" {
+			t.Errorf("password %q not wiped", passwd)
+		}
+	}
+
+	key := kp.PrivateKey.(*ecdsa.PrivateKey)
+	pub := key.PublicKey
+	ks.Destroy()
+	switch {
+	case key.D.Sign() != 0:
+		t.Errorf("private key not wiped")
+	case kp.PrivateKey != nil:
+		t.Errorf("PrivateKey kept by Destroy")
+	case !errors.Is(kp.PrivKeyErr, jks.ErrKeyNotDecrypted):
+		t.Errorf("PrivKeyErr %v ≠ expected %v", kp.PrivKeyErr,
+			jks.ErrKeyNotDecrypted)
+	}
+
+	raw, err := ks.Pack(b.Options())
+	if err != nil {
+		t.Fatalf("Pack after Destroy: %v", err)
+	}
+	ks, err = jks.Parse(raw, b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("Parse after Destroy: %v", err)
+	case ks.Keypairs[0].PrivKeyErr != nil:
+		t.Fatalf("PrivKeyErr after Destroy: %v",
+			ks.Keypairs[0].PrivKeyErr)
+	}
+	key = ks.Keypairs[0].PrivateKey.(*ecdsa.PrivateKey)
+	if !key.PublicKey.Equal(&pub) {
+		t.Errorf("key changed by Destroy and Pack")
+	}
+}
+
+// TestPasswordEncodingRoundTrip checks that a keystore with a non-ASCII
+// password can only be read with the encoding it was written with.
+func TestPasswordEncodingRoundTrip(t *testing.T) {
+	const passwd = "pässwörd \U0001F511"
+	ks := jkstest.New(t, passwd).ECKeypair("key", elliptic.P256()).
+		Keystore()
+	for _, enc := range []jks.PasswordEncoding{
+		jks.PasswordUTF16BE, jks.PasswordWidenedBytes,
+	} {
+		t.Run(enc.String(), testPasswordEncodingRoundTrip(ks, passwd,
+			enc))
+	}
+
+	if _, err := jks.ParsePasswordEncoding("utf-8"); err == nil {
+		t.Errorf("ParsePasswordEncoding: expected error")
+	}
+}
+
+func testPasswordEncodingRoundTrip(ks *jks.Keystore, passwd string,
+	enc jks.PasswordEncoding,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		other := jks.PasswordUTF16BE
+		if enc == other {
+			other = jks.PasswordWidenedBytes
+		}
+		raw, err := ks.Pack(&jks.Options{
+			Password:         passwd,
+			PasswordEncoding: enc,
+		})
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+
+		ks, err := jks.Parse(raw, &jks.Options{
+			Password:         passwd,
+			PasswordEncoding: enc,
+		})
+		switch {
+		case err != nil:
+			t.Errorf("Parse: %v", err)
+		case ks.Keypairs[0].PrivKeyErr != nil:
+			t.Errorf("PrivKeyErr: %v", ks.Keypairs[0].PrivKeyErr)
+		}
+
+		_, err = jks.Parse(raw, &jks.Options{
+			Password:         passwd,
+			PasswordEncoding: other,
+		})
+		if code := jks.ErrorCode(err); code != jks.CodeDigestMismatch {
+			t.Errorf("Parse with %v: error code %s ≠ expected %s",
+				other, code, jks.CodeDigestMismatch)
+		}
+	}
+}
+
+// TestChangePassword checks that ChangePassword re-encrypts the keys protected
+// by the keystore password, and leaves those with their own passwords alone.
+func TestChangePassword(t *testing.T) {
+	b := jkstest.New(t, "old pass").CA("root").
+		ECKeypair("store-key", elliptic.P256()).
+		ECKeypair("own-key", elliptic.P256()).
+		KeyPassword("own-key", "key pass")
+	keyPasswords := map[string]string{"own-key": "key pass"}
+
+	raw, err := jks.ChangePassword(b.Bytes(), "old pass", "new pass",
+		&jks.Options{KeyPasswords: keyPasswords})
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	ks, err := jks.Parse(raw, &jks.Options{
+		Password:     "new pass",
+		KeyPasswords: keyPasswords,
+	})
+	switch {
+	case err != nil:
+		t.Fatalf("Parse: %v", err)
+	case len(ks.Certs) != 1 || len(ks.Keypairs) != 2:
+		t.Fatalf("entries lost")
+	}
+	for _, kp := range ks.Keypairs {
+		if kp.PrivKeyErr != nil {
+			t.Errorf("key %q: %v", kp.Alias, kp.PrivKeyErr)
+		}
+	}
+
+	_, err = jks.ChangePassword(b.Bytes(), "wrong", "new pass", nil)
+	if !errors.Is(err, jks.ErrBadStorePassword) {
+		t.Errorf("expected ErrBadStorePassword but got %v", err)
+	}
+}
+
+// TestChangeKeyPassword checks that a key's password may be changed with
+// Keypair.ChangePassword, or with Options.NewKeyPasswords whether or not the
+// key was decrypted. A key re-encrypted by Keypair.ChangePassword is protected
+// with JavaKeyEncryption1, which cannot be written to a PKCS#12 file.
+func TestChangeKeyPassword(t *testing.T) {
+	b := jkstest.New(t, "store pass").ECKeypair("key", elliptic.P256())
+	t.Run("Keypair", testChangeKeyPassword(b, false, func(ks *jks.Keystore,
+		opts *jks.Options,
+	) error {
+		kp := ks.Keypairs[0]
+		err := kp.ChangePassword("wrong", "new pass")
+		if !errors.Is(err, jks.ErrBadKeyPassword) {
+			t.Errorf("expected ErrBadKeyPassword but got %v", err)
+		}
+		return kp.ChangePassword("store pass", "new pass")
+	}))
+	t.Run("Options", testChangeKeyPassword(b, true, func(_ *jks.Keystore,
+		opts *jks.Options,
+	) error {
+		opts.NewKeyPasswords = map[string]string{"key": "new pass"}
+		return nil
+	}))
+}
+
+func testChangeKeyPassword(b *jkstest.Builder, pkcs12 bool,
+	change func(ks *jks.Keystore, opts *jks.Options) error,
+) func(t *testing.T) {
+	return func(t *testing.T) {
+		for _, skip := range []bool{false, true} {
+			ks, err := jks.Parse(b.Bytes(), &jks.Options{
+				Password:          "store pass",
+				SkipKeyDecryption: skip,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := *b.Options()
+			if err = change(ks, &opts); err != nil {
+				t.Fatalf("changing password: %v", err)
+			}
+			raw, err := ks.Pack(&opts)
+			if err != nil {
+				t.Fatalf("Pack: %v", err)
+			}
+			files := [][]byte{raw}
+			if pkcs12 {
+				p12, err := ks.PackPKCS12(&opts)
+				if err != nil {
+					t.Fatalf("PackPKCS12: %v", err)
+				}
+				files = append(files, p12)
+			}
+
+			for _, raw := range files {
+				ks, _, err := jks.ParseAny(raw, &jks.Options{
+					Password: "store pass",
+					KeyPasswords: map[string]string{
+						"key": "new pass",
+					},
+				})
+				switch {
+				case err != nil:
+					t.Errorf("parsing: %v", err)
+				case ks.Keypairs[0].PrivKeyErr != nil:
+					t.Errorf("key not decrypted with new "+
+						"password: %v",
+						ks.Keypairs[0].PrivKeyErr)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"hash"
+
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	// RFC 7292 appendix C
+	oidPBEWithSHA1And128BitRC2 = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 12, 1, 5,
+	}
+	oidPBEWithSHA1And40BitRC2 = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 12, 1, 6,
+	}
+
+	// RFC 8018 appendix A.3 (PBES1)
+	oidPBEWithMD5AndDES  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 3}
+	oidPBEWithMD5AndRC2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 6}
+	oidPBEWithSHA1AndDES = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 5, 10,
+	}
+	oidPBEWithSHA1AndRC2 = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 5, 11,
+	}
+
+	// RFC 8018 appendix B
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidDESCBC         = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 7}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+)
+
+// pbeMaxIterations is the largest iteration count we accept for password-based
+// encryption. It matches the JDK's limit, and stops a hostile file from making
+// us spin.
+const pbeMaxIterations = 5000000
+
+// decryptPBE decrypts data encrypted with one of the password-based encryption
+// schemes found in PKCS#12 files and in keystores written by tools other than
+// keytool: the PKCS#12 schemes using Triple DES or RC2 (RFC 7292 appendix C),
+// PBES1 with MD5 or SHA-1 and DES or RC2, and PBES2 with PBKDF2 and AES, DES
+// or Triple DES (RFC 8018). A wrong password is reported as CodeBadKeyPassword
+// where it can be detected.
+func decryptPBE(algo pkix.AlgorithmIdentifier, data, password []byte,
+) ([]byte, error) {
+	var (
+		block      cipher.Block
+		iv, salt   []byte
+		iterations int
+		err        error
+	)
+	switch {
+	case algo.Algorithm.Equal(oidPBEWithSHA1And3DES),
+		algo.Algorithm.Equal(oidPBEWithSHA1And128BitRC2),
+		algo.Algorithm.Equal(oidPBEWithSHA1And40BitRC2):
+		salt, iterations, err = parsePKCS12PBEParams(
+			algo.Parameters.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		derive := func(id byte, size int) []byte {
+			return pkcs12KDF(sha1.New, id, password, salt,
+				iterations, size)
+		}
+		iv = derive(2, 8)
+		defer clear(iv)
+		var key []byte
+		switch {
+		case algo.Algorithm.Equal(oidPBEWithSHA1And3DES):
+			key = derive(1, 24)
+			block, err = des.NewTripleDESCipher(key)
+		case algo.Algorithm.Equal(oidPBEWithSHA1And128BitRC2):
+			key = derive(1, 16)
+			block = newRC2Cipher(key, 128)
+		default:
+			key = derive(1, 5)
+			block = newRC2Cipher(key, 40)
+		}
+		clear(key)
+
+	case algo.Algorithm.Equal(oidPBEWithMD5AndDES),
+		algo.Algorithm.Equal(oidPBEWithMD5AndRC2),
+		algo.Algorithm.Equal(oidPBEWithSHA1AndDES),
+		algo.Algorithm.Equal(oidPBEWithSHA1AndRC2):
+		block, iv, err = pbes1Cipher(algo, password)
+
+	case algo.Algorithm.Equal(oidPBES2):
+		block, iv, err = pbes2Cipher(algo.Parameters.FullBytes,
+			password)
+
+	default:
+		return nil, errorf(CodeUnsupportedKeyAlg, "unhandled "+
+			"encryption algorithm %v", algo.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bs := block.BlockSize()
+	if len(data) == 0 || len(data)%bs != 0 {
+		return nil, newError(CodeMalformed, "ciphertext is not a "+
+			"whole number of blocks")
+	}
+	plaintext := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, data)
+	return pkcs7Unpad(plaintext, bs)
+}
+
+// pkcs7Unpad removes PKCS#7 padding from decrypted data. Since a wrong
+// password shows up as bad padding, that is reported as CodeBadKeyPassword.
+func pkcs7Unpad(plaintext []byte, blockSize int) ([]byte, error) {
+	n := int(plaintext[len(plaintext)-1])
+	if n == 0 || n > blockSize {
+		return nil, newError(CodeBadKeyPassword, "invalid password")
+	}
+	for _, b := range plaintext[len(plaintext)-n:] {
+		if int(b) != n {
+			return nil, newError(CodeBadKeyPassword,
+				"invalid password")
+		}
+	}
+	return plaintext[:len(plaintext)-n], nil
+}
+
+// parsePKCS12PBEParams parses the pkcs-12PbeParams structure (RFC 7292
+// appendix C).
+func parsePKCS12PBEParams(raw []byte) (salt []byte, iterations int,
+	err error,
+) {
+	var (
+		seq   cryptobyte.String
+		input = cryptobyte.String(raw)
+	)
+	if !input.ReadASN1(&seq, casn1.SEQUENCE) || !input.Empty() ||
+		!seq.ReadASN1Bytes(&salt, casn1.OCTET_STRING) ||
+		!seq.ReadASN1Integer(&iterations) || !seq.Empty() {
+		return nil, 0, newError(CodeMalformed, "malformed PKCS#12 "+
+			"PBE parameters")
+	}
+	if iterations < 1 || iterations > pbeMaxIterations {
+		return nil, 0, errorf(CodeMalformed, "PBE iteration count %d "+
+			"out of range", iterations)
+	}
+	return salt, iterations, nil
+}
+
+// pbes1Cipher parses the PBEParameter for one of the PBES1 schemes (RFC 8018
+// § 6.1), derives the key and IV with PBKDF1, and returns the block cipher and
+// IV.
+func pbes1Cipher(algo pkix.AlgorithmIdentifier, password []byte,
+) (cipher.Block, []byte, error) {
+	salt, iterations, err := parsePBEParameter(algo.Parameters.FullBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	newHash := md5.New
+	if algo.Algorithm.Equal(oidPBEWithSHA1AndDES) ||
+		algo.Algorithm.Equal(oidPBEWithSHA1AndRC2) {
+		newHash = sha1.New
+	}
+
+	// PBKDF1: T_1 = Hash(P || S), T_i = Hash(T_{i-1}); the first half of
+	// T_c is the key, and the second half the IV
+	h := newHash()
+	h.Write(password)
+	h.Write(salt)
+	dk := h.Sum(nil)
+	defer clear(dk)
+	for i := 1; i < iterations; i++ {
+		h.Reset()
+		h.Write(dk)
+		dk = h.Sum(dk[:0])
+	}
+	key, iv := dk[:8], bytes.Clone(dk[8:16])
+
+	if algo.Algorithm.Equal(oidPBEWithMD5AndDES) ||
+		algo.Algorithm.Equal(oidPBEWithSHA1AndDES) {
+		block, err := des.NewCipher(key)
+		if err != nil {
+			return nil, nil, errorf(CodeCryptoFailure, "%v", err)
+		}
+		return block, iv, nil
+	}
+	return newRC2Cipher(key, 64), iv, nil
+}
+
+// pbes2Cipher parses PBES2-params (RFC 8018 appendix A.4), derives the key
+// with PBKDF2, and returns the block cipher and IV.
+func pbes2Cipher(raw, password []byte) (cipher.Block, []byte, error) {
+	var (
+		seq, kdf, kdfParams, enc cryptobyte.String
+		kdfOID, encOID, prfOID   asn1.ObjectIdentifier
+		salt, iv                 []byte
+		iterations, keyLen       int
+		input                    = cryptobyte.String(raw)
+	)
+	if !input.ReadASN1(&seq, casn1.SEQUENCE) || !input.Empty() ||
+		!seq.ReadASN1(&kdf, casn1.SEQUENCE) ||
+		!seq.ReadASN1(&enc, casn1.SEQUENCE) || !seq.Empty() ||
+		!kdf.ReadASN1ObjectIdentifier(&kdfOID) ||
+		!kdf.ReadASN1(&kdfParams, casn1.SEQUENCE) ||
+		!enc.ReadASN1ObjectIdentifier(&encOID) ||
+		!enc.ReadASN1Bytes(&iv, casn1.OCTET_STRING) {
+		return nil, nil, newError(CodeMalformed, "malformed PBES2 "+
+			"parameters")
+	}
+	if !kdfOID.Equal(oidPBKDF2) {
+		return nil, nil, errorf(CodeUnsupportedKeyAlg, "unhandled "+
+			"PBES2 key derivation function %v", kdfOID)
+	}
+
+	// PBKDF2-params: the PRF defaults to HMAC-SHA1
+	prfOID = oidHMACWithSHA1
+	if !kdfParams.ReadASN1Bytes(&salt, casn1.OCTET_STRING) ||
+		!kdfParams.ReadASN1Integer(&iterations) ||
+		(kdfParams.PeekASN1Tag(casn1.INTEGER) &&
+			!kdfParams.ReadASN1Integer(&keyLen)) {
+		return nil, nil, newError(CodeMalformed, "malformed PBKDF2 "+
+			"parameters")
+	}
+	if !kdfParams.Empty() {
+		var prf cryptobyte.String
+		if !kdfParams.ReadASN1(&prf, casn1.SEQUENCE) ||
+			!prf.ReadASN1ObjectIdentifier(&prfOID) {
+			return nil, nil, newError(CodeMalformed, "malformed "+
+				"PBKDF2 PRF")
+		}
+	}
+	if iterations < 1 || iterations > pbeMaxIterations {
+		return nil, nil, errorf(CodeMalformed, "PBKDF2 iteration "+
+			"count %d out of range", iterations)
+	}
+	var prf func() hash.Hash
+	switch {
+	case prfOID.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case prfOID.Equal(oidHMACWithSHA224):
+		prf = sha256.New224
+	case prfOID.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case prfOID.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case prfOID.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, nil, errorf(CodeUnsupportedKeyAlg, "unhandled "+
+			"PBKDF2 PRF %v", prfOID)
+	}
+
+	var (
+		size    int
+		factory func([]byte) (cipher.Block, error)
+	)
+	switch {
+	case encOID.Equal(oidAES128CBC):
+		size, factory = 16, aes.NewCipher
+	case encOID.Equal(oidAES192CBC):
+		size, factory = 24, aes.NewCipher
+	case encOID.Equal(oidAES256CBC):
+		size, factory = 32, aes.NewCipher
+	case encOID.Equal(oidDESEDE3CBC):
+		size, factory = 24, des.NewTripleDESCipher
+	case encOID.Equal(oidDESCBC):
+		size, factory = 8, des.NewCipher
+	default:
+		return nil, nil, errorf(CodeUnsupportedKeyAlg, "unhandled "+
+			"PBES2 encryption scheme %v", encOID)
+	}
+	if keyLen != 0 && keyLen != size {
+		return nil, nil, errorf(CodeMalformed, "PBKDF2 key length %d "+
+			"does not match cipher", keyLen)
+	}
+	key := pbkdf2.Key(password, salt, iterations, size, prf)
+	block, err := factory(key)
+	clear(key)
+	if err != nil {
+		return nil, nil, errorf(CodeCryptoFailure, "%v", err)
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, nil, errorf(CodeMalformed, "PBES2 IV length %d "+
+			"does not match cipher", len(iv))
+	}
+	return block, iv, nil
+}
@@ -0,0 +1,272 @@
+package jks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+)
+
+// AddFromPEM adds an entry built from a PEM bundle, such as the files written
+// by openssl or used to configure nginx. If the bundle holds a private key, a
+// keypair entry is added: its chain starts with the certificate matching the
+// key, followed by the certificate for each issuer in turn, whatever order the
+// certificates appear in. Otherwise, each certificate is added as a trusted
+// certificate entry; the first takes alias, and any others alias with a
+// numeric suffix (".1", ".2" etc.).
+//
+// The private key may be in PKCS#1, SEC 1 or PKCS#8 form. If it is encrypted,
+// either as an "ENCRYPTED PRIVATE KEY" block or with the legacy OpenSSL
+// Proc-Type header, it is decrypted with password. The bundle must hold no
+// more than one private key, and every certificate must be part of its chain.
+// An error with CodeDuplicateAlias is returned if alias is already in use, and
+// ks is not modified on error.
+func (ks *Keystore) AddFromPEM(alias string, pemBytes []byte,
+	password string,
+) error {
+	if ks.hasAlias(alias) {
+		return errorf(CodeDuplicateAlias, "duplicate alias %q", alias)
+	}
+
+	var (
+		key   crypto.Signer
+		certs []*KeypairCert
+		rest  = pemBytes
+	)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			kpc := &KeypairCert{Raw: block.Bytes}
+			kpc.Cert, kpc.CertErr = x509.ParseCertificate(
+				block.Bytes)
+			certs = append(certs, kpc)
+
+		case "EC PARAMETERS":
+			// written by openssl ecparam -genkey; ignored
+
+		default:
+			if key != nil {
+				return newError(CodeInvalidArgument, "PEM "+
+					"bundle holds more than one private "+
+					"key")
+			}
+			k, err := parsePEMPrivateKey(block, password)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			if key, ok = k.(crypto.Signer); !ok {
+				return errorf(CodeUnsupportedKeyAlg,
+					"unsupported private key type %T", k)
+			}
+		}
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return newError(CodeMalformed, "PEM bundle has trailing data")
+	}
+	if len(certs) == 0 {
+		return newError(CodeMissingData, "PEM bundle holds no "+
+			"certificates")
+	}
+
+	now := time.Now()
+	if key == nil {
+		for i, c := range certs {
+			a := alias
+			if i > 0 {
+				a = ks.uniqueAlias(alias)
+			}
+			ks.Certs = append(ks.Certs, &Cert{
+				Alias:     a,
+				Timestamp: now,
+				Raw:       c.Raw,
+				Cert:      c.Cert,
+				CertErr:   c.CertErr,
+			})
+		}
+		return nil
+	}
+
+	var leaf *KeypairCert
+	for _, c := range certs {
+		if c.Cert == nil {
+			continue
+		}
+		if match, _ := keyMatches(key, c.Cert.PublicKey); match {
+			leaf = c
+			break
+		}
+	}
+	if leaf == nil {
+		return newError(CodeKeyMismatch, "no certificate in PEM "+
+			"bundle matches the private key")
+	}
+	chain := orderChain(leaf, certs)
+	if len(chain) != len(certs) {
+		for _, c := range certs {
+			if !inChain(chain, c) {
+				return errorf(CodeInvalidArgument, "%q is "+
+					"not in the private key's chain",
+					certName(c))
+			}
+		}
+	}
+
+	ks.Keypairs = append(ks.Keypairs, &Keypair{
+		Alias:      alias,
+		Timestamp:  now,
+		PrivateKey: key,
+		CertChain:  chain,
+	})
+	return nil
+}
+
+// parsePEMPrivateKey parses a PEM block holding a private key, decrypting it
+// with password if need be.
+func parsePEMPrivateKey(block *pem.Block, password string,
+) (interface{}, error) {
+	der := block.Bytes
+	//lint:ignore SA1019 legacy encrypted PEM is still common
+	if x509.IsEncryptedPEMBlock(block) {
+		var err error
+		//lint:ignore SA1019 legacy encrypted PEM is still common
+		der, err = x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return nil, errorf(CodeBadKeyPassword, "failed to "+
+				"decrypt %s: %v", block.Type, err)
+		}
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		key, err = ParsePKCS8(der)
+	case "ENCRYPTED PRIVATE KEY":
+		if der, err = DecryptPKCS8(der, password); err != nil {
+			return nil, err
+		}
+		key, err = ParsePKCS8(der)
+	default:
+		return nil, errorf(CodeInvalidArgument, "unexpected PEM "+
+			"block %q", block.Type)
+	}
+	if err != nil {
+		return nil, errorf(CodeMalformed, "failed to parse %s: %v",
+			block.Type, err)
+	}
+	return key, nil
+}
+
+// orderChain returns the certificate chain starting at leaf, found by
+// repeatedly looking in certs for the certificate whose subject matches the
+// issuer of the last. The chain stops at a self-signed certificate, or when no
+// issuer is found.
+func orderChain(leaf *KeypairCert, certs []*KeypairCert) []*KeypairCert {
+	chain := []*KeypairCert{leaf}
+	for cur := leaf.Cert; cur != nil && len(chain) <= len(certs); {
+		if bytes.Equal(cur.RawIssuer, cur.RawSubject) {
+			break
+		}
+		var next *KeypairCert
+		for _, c := range certs {
+			if c.Cert != nil && !inChain(chain, c) &&
+				bytes.Equal(c.Cert.RawSubject, cur.RawIssuer) {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		chain = append(chain, next)
+		cur = next.Cert
+	}
+	return chain
+}
+
+// certName returns the subject common name of a certificate, for use in error
+// messages.
+func certName(c *KeypairCert) string {
+	if c.Cert != nil {
+		return c.Cert.Subject.CommonName
+	}
+	return "(unparseable)"
+}
+
+// inChain reports whether cert is already part of chain.
+func inChain(chain []*KeypairCert, cert *KeypairCert) bool {
+	for _, c := range chain {
+		if c == cert {
+			return true
+		}
+	}
+	return false
+}
+
+// ToPEM returns the certificate as a PEM "CERTIFICATE" block.
+func (c *Cert) ToPEM() []byte {
+	return certPEM(c.DER())
+}
+
+// ToPEM returns the private key as a PKCS#8 PEM block, followed by a
+// "CERTIFICATE" block for each entry in the certificate chain, in the form
+// expected by openssl, nginx and the like. If password is not empty, the key
+// is written as an "ENCRYPTED PRIVATE KEY" block, encrypted with PBES2 using
+// PBKDF2-HMAC-SHA256 and AES-256-CBC; otherwise it is written unencrypted as
+// a "PRIVATE KEY" block.
+//
+// If PrivateKey is not set but RawKey is (as for a key whose algorithm could
+// not be parsed), RawKey is written as is. If neither is set, the key could
+// not be decrypted and PrivKeyErr is returned.
+func (kp *Keypair) ToPEM(password string) ([]byte, error) {
+	var (
+		raw = kp.RawKey
+		err error
+	)
+	switch {
+	case kp.PrivateKey != nil:
+		if raw, err = MarshalPKCS8(kp.PrivateKey); err != nil {
+			return nil, errorf("", "key %q: %v", kp.Alias, err)
+		}
+	case raw == nil && kp.PrivKeyErr != nil:
+		return nil, errorf("", "key %q: %v", kp.Alias, kp.PrivKeyErr)
+	case raw == nil:
+		return nil, errorf(CodeMissingData, "key %q: no private key",
+			kp.Alias)
+	}
+
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: raw}
+	if password != "" {
+		block.Type = "ENCRYPTED PRIVATE KEY"
+		if block.Bytes, err = EncryptPKCS8(raw, password); err != nil {
+			return nil, errorf("", "key %q: %v", kp.Alias, err)
+		}
+	}
+
+	out := pem.EncodeToMemory(block)
+	for _, c := range kp.CertChain {
+		out = append(out, certPEM(c.DER())...)
+	}
+	return out, nil
+}
+
+// certPEM encodes a DER certificate as a PEM "CERTIFICATE" block.
+func certPEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	})
+}
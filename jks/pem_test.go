@@ -0,0 +1,198 @@
+package jks_test
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestAddFromPEM checks that a keypair is built from a PEM bundle whatever
+// the order of its blocks, that a bundle of certificates becomes trusted
+// certificate entries, and that bad bundles are rejected.
+func TestAddFromPEM(t *testing.T) {
+	rootKey := jkstest.ECKey(t, elliptic.P256())
+	root := jkstest.SelfSigned(t, rootKey, "root")
+	interKey := jkstest.ECKey(t, elliptic.P256())
+	inter := jkstest.Issue(t, interKey, "inter", root, rootKey, true)
+	key := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, key, "server", inter, interKey, false)
+	other := jkstest.SelfSigned(t, jkstest.ECKey(t, elliptic.P256()),
+		"other")
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	})
+
+	// a JKS keystore holds an EncryptedPrivateKeyInfo, which we can borrow
+	ks, err := jks.Parse(jkstest.New(t, "password").
+		KeypairWithChain("server", key, leaf).
+		KeyPassword("server", "secret").Bytes(), nil)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	encPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "ENCRYPTED PRIVATE KEY",
+		Bytes: ks.Keypairs[0].EncryptedKey,
+	})
+
+	t.Run("key first", testAddFromPEMKeypair(
+		bundle(keyPEM, leaf, inter, root), "", key, leaf, inter, root))
+	t.Run("unordered", testAddFromPEMKeypair(
+		bundle(nil, root, leaf, inter, keyPEM), "",
+		key, leaf, inter, root))
+	t.Run("encrypted", testAddFromPEMKeypair(
+		bundle(encPEM, leaf), "secret", key, leaf))
+	t.Run("certs only", testAddFromPEMCerts(bundle(nil, root, inter)))
+
+	t.Run("wrong password", testAddFromPEMError(
+		bundle(encPEM, leaf), "wrong", jks.CodeBadKeyPassword))
+	t.Run("mismatched key", testAddFromPEMError(
+		bundle(keyPEM, inter, root), "", jks.CodeKeyMismatch))
+	t.Run("unrelated cert", testAddFromPEMError(
+		bundle(keyPEM, leaf, other), "", jks.CodeInvalidArgument))
+	t.Run("two keys", testAddFromPEMError(
+		bundle(keyPEM, leaf, keyPEM), "", jks.CodeInvalidArgument))
+	t.Run("no certs", testAddFromPEMError(
+		keyPEM, "", jks.CodeMissingData))
+}
+
+// bundle concatenates its arguments, each either PEM data or a certificate to
+// encode as PEM.
+func bundle(data []byte, items ...interface{}) []byte {
+	out := append([]byte(nil), data...)
+	for _, c := range items {
+		switch c := c.(type) {
+		case []byte:
+			out = append(out, c...)
+		case *x509.Certificate:
+			out = append(out, pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: c.Raw,
+			})...)
+		}
+	}
+	return out
+}
+
+func testAddFromPEMKeypair(data []byte, password string,
+	key interface{ Equal(crypto.PrivateKey) bool },
+	chain ...*x509.Certificate,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		ks := new(jks.Keystore)
+		if err := ks.AddFromPEM("server", data, password); err != nil {
+			t.Fatalf("AddFromPEM: %v", err)
+		}
+		if len(ks.Keypairs) != 1 || len(ks.Certs) != 0 {
+			t.Fatalf("got %d keypairs, %d certs ≠ expected 1, 0",
+				len(ks.Keypairs), len(ks.Certs))
+		}
+		kp := ks.Keypairs[0]
+		switch {
+		case kp.Alias != "server":
+			t.Errorf("alias %q ≠ expected \"server\"", kp.Alias)
+		case !key.Equal(kp.PrivateKey):
+			t.Errorf("private key mismatch")
+		case len(kp.CertChain) != len(chain):
+			t.Fatalf("chain length %d ≠ expected %d",
+				len(kp.CertChain), len(chain))
+		}
+		for i, c := range chain {
+			if !kp.CertChain[i].Cert.Equal(c) {
+				t.Errorf("chain entry #%d: %s ≠ expected %s",
+					i, kp.CertChain[i].Cert.Subject,
+					c.Subject)
+			}
+		}
+		if _, err := ks.Pack(&jks.Options{Password: "p"}); err != nil {
+			t.Errorf("Pack: %v", err)
+		}
+	}
+}
+
+func testAddFromPEMCerts(data []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		ks := new(jks.Keystore)
+		if err := ks.AddFromPEM("ca", data, ""); err != nil {
+			t.Fatalf("AddFromPEM: %v", err)
+		}
+		if len(ks.Keypairs) != 0 || len(ks.Certs) != 2 {
+			t.Fatalf("got %d keypairs, %d certs ≠ expected 0, 2",
+				len(ks.Keypairs), len(ks.Certs))
+		}
+		for i, exp := range []string{"ca", "ca.1"} {
+			if a := ks.Certs[i].Alias; a != exp {
+				t.Errorf("alias %q ≠ expected %q", a, exp)
+			}
+		}
+
+		err := ks.AddFromPEM("ca", data, "")
+		if code := jks.ErrorCode(err); code != jks.CodeDuplicateAlias {
+			t.Errorf("duplicate alias: error code %s ≠ "+
+				"expected %s (%v)", code,
+				jks.CodeDuplicateAlias, err)
+		}
+	}
+}
+
+func testAddFromPEMError(data []byte, password, code string,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		ks := new(jks.Keystore)
+		err := ks.AddFromPEM("server", data, password)
+		if c := jks.ErrorCode(err); c != code {
+			t.Errorf("error code %s ≠ expected %s (%v)", c, code,
+				err)
+		}
+		if len(ks.Keypairs) != 0 || len(ks.Certs) != 0 {
+			t.Errorf("keystore modified on error")
+		}
+	}
+}
+
+// TestToPEM checks that entries exported with ToPEM can be read back with
+// AddFromPEM, with and without a password on the private key.
+func TestToPEM(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "ca")
+	key := jkstest.RSAKey(t, 2048)
+	leaf := jkstest.Issue(t, key, "server", ca, caKey, false)
+	b := jkstest.New(t, "password").CA("root").
+		KeypairWithChain("server", key, leaf, ca)
+
+	cert := b.Keystore().Certs[0]
+	ks := new(jks.Keystore)
+	if err := ks.AddFromPEM("root", cert.ToPEM(), ""); err != nil {
+		t.Fatalf("AddFromPEM: %v", err)
+	}
+	if len(ks.Certs) != 1 || !ks.Certs[0].Cert.Equal(cert.Cert) {
+		t.Errorf("certificate did not round trip")
+	}
+
+	kp := b.Keystore().Keypairs[0]
+	for _, password := range []string{"", "secret"} {
+		data, err := kp.ToPEM(password)
+		if err != nil {
+			t.Fatalf("ToPEM(%q): %v", password, err)
+		}
+		t.Run(fmt.Sprintf("password %q", password),
+			testAddFromPEMKeypair(data, password, key, leaf, ca))
+	}
+
+	_, err := (&jks.Keypair{Alias: "server"}).ToPEM("")
+	if code := jks.ErrorCode(err); code != jks.CodeMissingData {
+		t.Errorf("no key: error code %s ≠ expected %s (%v)", code,
+			jks.CodeMissingData, err)
+	}
+}
@@ -0,0 +1,67 @@
+package jks
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"sort"
+)
+
+// SPKIPin returns the SubjectPublicKeyInfo pin of cert: the base64-encoded
+// SHA-256 digest of its DER-encoded public key, as used by HPKP's pin-sha256
+// directive, Android's network security config and most mobile pinning
+// libraries. Because the pin covers only the public key, it survives
+// certificate renewal with the same key.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// PinSet holds the SPKI pins for one keystore entry.
+type PinSet struct {
+	// Alias of the entry.
+	Alias string `json:"alias" yaml:"alias"`
+
+	// Pins holds the pin of a trusted certificate, or of each certificate
+	// in a keypair's chain (leaf first), without duplicates.
+	Pins []string `json:"pins" yaml:"pins"`
+}
+
+// PinSets returns the SPKI pins of each entry in the keystore, sorted by alias.
+// Certificates which could not be parsed are skipped, as are entries left with
+// no pins.
+func (ks *Keystore) PinSets() []*PinSet {
+	var sets []*PinSet
+	add := func(alias string, certs ...*x509.Certificate) {
+		ps := &PinSet{Alias: alias}
+		seen := make(map[string]bool)
+		for _, cert := range certs {
+			if cert == nil {
+				continue
+			}
+			pin := SPKIPin(cert)
+			if !seen[pin] {
+				seen[pin] = true
+				ps.Pins = append(ps.Pins, pin)
+			}
+		}
+		if len(ps.Pins) > 0 {
+			sets = append(sets, ps)
+		}
+	}
+
+	for _, cert := range ks.Certs {
+		add(cert.Alias, cert.Cert)
+	}
+	for _, kp := range ks.Keypairs {
+		certs := make([]*x509.Certificate, len(kp.CertChain))
+		for i, cert := range kp.CertChain {
+			certs[i] = cert.Cert
+		}
+		add(kp.Alias, certs...)
+	}
+	sort.SliceStable(sets, func(i, j int) bool {
+		return sets[i].Alias < sets[j].Alias
+	})
+	return sets
+}
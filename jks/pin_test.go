@@ -0,0 +1,62 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestPinSets checks the SPKI pins computed for trusted certificates and
+// keypair chains.
+func TestPinSets(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "CA")
+	key := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, key, "leaf", ca, caKey, false)
+
+	// a certificate renewed with the same key must have the same pin
+	renewed := jkstest.Issue(t, key, "leaf", ca, caKey, false)
+
+	ks := jkstest.New(t, "password").Cert("ca", ca).
+		KeypairWithChain("leaf", key, leaf, renewed, ca).Keystore()
+	sets := ks.PinSets()
+
+	expPin := func(cert *x509.Certificate) string {
+		der, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(der)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	exp := []*jks.PinSet{
+		{Alias: "ca", Pins: []string{expPin(ca)}},
+		{Alias: "leaf", Pins: []string{expPin(leaf), expPin(ca)}},
+	}
+	if len(sets) != len(exp) {
+		t.Fatalf("got %d pin sets ≠ expected %d", len(sets), len(exp))
+	}
+	for i, ps := range sets {
+		if ps.Alias != exp[i].Alias {
+			t.Errorf("set %d: alias %q ≠ expected %q", i,
+				ps.Alias, exp[i].Alias)
+		}
+		if len(ps.Pins) != len(exp[i].Pins) {
+			t.Errorf("set %d: pins %v ≠ expected %v", i, ps.Pins,
+				exp[i].Pins)
+			continue
+		}
+		for j, pin := range ps.Pins {
+			if pin != exp[i].Pins[j] {
+				t.Errorf("set %d: pin %d %q ≠ expected %q", i,
+					j, pin, exp[i].Pins[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,1089 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	// RFC 7292 and RFC 2985
+	oidDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidKeyBag          = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 12, 10, 1, 1,
+	}
+	oidCertBag = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 12, 10, 1, 3,
+	}
+	oidX509Certificate = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 9, 22, 1,
+	}
+	oidShroudedKeyBag = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 12, 10, 1, 2,
+	}
+	oidFriendlyName = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+
+	// RFC 7292 appendix C and RFC 8018
+	oidPBEWithSHA1And3DES = asn1.ObjectIdentifier{
+		1, 2, 840, 113549, 1, 12, 1, 3,
+	}
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{
+		2, 16, 840, 1, 101, 3, 4, 1, 42,
+	}
+
+	// OracleTrustedKeyUsageOID is the bag attribute the JDK uses to mark a
+	// certificate in a PKCS#12 file as a trust anchor. Its value is the set
+	// of extended key usages for which the certificate is trusted. Without
+	// it, the JDK treats certificates in a PKCS#12 file as mere chain
+	// members and the file cannot act as a truststore.
+	OracleTrustedKeyUsageOID = asn1.ObjectIdentifier{
+		2, 16, 840, 1, 113894, 746875, 1, 1,
+	}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+
+	// PBES2OID and PBEWithSHA1And3DESOID identify the private key
+	// encryption algorithms that may be offered to
+	// Keypair.EncryptKeyFunc, along with JavaKeyEncryptionOID1.
+	PBES2OID              = oidPBES2
+	PBEWithSHA1And3DESOID = oidPBEWithSHA1And3DES
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+const (
+	// pkcs12MacIterations is the iteration count for the integrity MAC,
+	// matching the JDK's default.
+	pkcs12MacIterations = 10000
+
+	// pkcs12KeyIterations is the iteration count for private key
+	// encryption, matching the JDK's default.
+	pkcs12KeyIterations = 10000
+)
+
+// PackPKCS12 writes the keystore as a PKCS#12 file (RFC 7292) holding the same
+// entries as Pack would, so that one Keystore can be handed to both older and
+// newer JVMs. Each trusted certificate entry becomes a certificate bag
+// carrying its alias as the friendly name and the Oracle trusted key usage
+// attribute (OracleTrustedKeyUsageOID).
+//
+// Each keypair entry becomes a shrouded key bag, holding the private key
+// encrypted with its key password (as for Pack), followed by a certificate bag
+// for each certificate in its chain. The key bag and the first certificate bag
+// carry the alias as the friendly name and share a localKeyId attribute (by
+// default the SHA-1 fingerprint of the certificate), which is how the JDK pairs
+// them up. Friendly names keep their case, as the JDK's do, but as with Pack,
+// aliases which differ only in case are refused. Entries read by ParsePKCS12
+// keep the friendlyName and localKeyId attributes they were read with; see
+// Keypair.FriendlyName and Keypair.LocalKeyID. Keys are encrypted with
+// PBEWithSHA1AndDESede for Java8 compatibility and with PBES2
+// (PBKDF2-HMAC-SHA256 and AES-256-CBC) otherwise.
+//
+// Certificates are written from Raw where they have not been parsed, as for
+// Pack. Since PKCS#12 does not record the order of a chain, though, a chain
+// certificate that crypto/x509 cannot parse is read back by ParsePKCS12 as a
+// trusted certificate rather than as part of the chain.
+//
+// Certificate bags are not encrypted. The file is protected by an HMAC keyed
+// from opts.Password, using SHA-1 for Java8 compatibility and SHA-256
+// otherwise.
+func (ks *Keystore) PackPKCS12(opts *Options) ([]byte, error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	defer opts.wipePasswords()
+	if len(ks.SecretKeys) != 0 {
+		return nil, errSecretKeys
+	}
+	if len(ks.UnknownEntries) != 0 {
+		return nil, errUnknownEntries
+	}
+	if opts.Preflight {
+		if err := ks.Validate(opts); err != nil {
+			return nil, err
+		}
+	} else if opts.Roots != nil {
+		if err := ks.VerifyChains(opts.Roots); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := ks.packEntries(opts)
+	if err != nil {
+		return nil, err
+	}
+	problems := ks.checkSignatureAlgorithms(opts)
+	problems = append(problems, ks.checkKeyMatches()...)
+	if problems != nil {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	var safe cryptobyte.Builder
+	safe.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for _, entry := range entries {
+			switch entry := entry.(type) {
+			case *Cert:
+				addTrustedCertBag(b, entry)
+			case *Keypair:
+				addKeypairBags(b, entry, opts)
+			}
+		}
+	})
+	safeContents, err := safe.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var auth cryptobyte.Builder
+	auth.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addDataContentInfo(b, safeContents)
+	})
+	authSafe, err := auth.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	macOID, newHash := oidSHA256, sha256.New
+	if opts.Compatibility < Java11 {
+		macOID, newHash = oidSHA1, sha1.New
+	}
+	salt := make([]byte, 20)
+	_, err = io.ReadFull(opts.randReader(opts.password(), authSafe), salt)
+	if err != nil {
+		return nil, err
+	}
+	key := pkcs12KDF(newHash, 3, opts.password(), salt,
+		pkcs12MacIterations, newHash().Size())
+	mac := hmac.New(newHash, key)
+	clear(key)
+	mac.Write(authSafe)
+
+	var pfx cryptobyte.Builder
+	pfx.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1Int64(3) // version
+		addDataContentInfo(b, authSafe)
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1(casn1.SEQUENCE,
+					func(b *cryptobyte.Builder) {
+						b.AddASN1ObjectIdentifier(macOID)
+						b.AddASN1NULL()
+					})
+				b.AddASN1OctetString(mac.Sum(nil))
+			})
+			b.AddASN1OctetString(salt)
+			b.AddASN1Int64(pkcs12MacIterations)
+		})
+	})
+	return pfx.Bytes()
+}
+
+// addDataContentInfo appends a PKCS#7 ContentInfo of type data holding
+// content.
+func addDataContentInfo(b *cryptobyte.Builder, content []byte) {
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidDataContentType)
+		b.AddASN1(casn1.Tag(0).ContextSpecific().Constructed(),
+			func(b *cryptobyte.Builder) {
+				b.AddASN1OctetString(content)
+			})
+	})
+}
+
+// addTrustedCertBag appends a SafeBag holding a trusted certificate.
+func addTrustedCertBag(b *cryptobyte.Builder, cert *Cert) {
+	der := cert.DER()
+	if len(der) == 0 {
+		b.SetError(newError(CodeMissingData, "certificate "+cert.Alias+
+			" has no data"))
+		return
+	}
+	if err := checkPKCS12CertType(cert.Type); err != nil {
+		b.SetError(errorf("", "certificate %q: %v", cert.Alias, err))
+		return
+	}
+
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidCertBag)
+		b.AddASN1(casn1.Tag(0).ContextSpecific().Constructed(),
+			func(b *cryptobyte.Builder) {
+				addCertBag(b, der)
+			})
+		b.AddASN1(casn1.SET, func(b *cryptobyte.Builder) {
+			addFriendlyName(b, friendlyName(cert.Alias,
+				cert.FriendlyName))
+			if cert.LocalKeyID != nil {
+				addLocalKeyID(b, cert.LocalKeyID)
+			}
+			b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1ObjectIdentifier(
+					OracleTrustedKeyUsageOID)
+				b.AddASN1(casn1.SET,
+					func(b *cryptobyte.Builder) {
+						b.AddASN1ObjectIdentifier(
+							oidAnyExtendedKeyUsage)
+					})
+			})
+		})
+	})
+}
+
+// checkPKCS12CertType returns an error if a certificate of type typ cannot be
+// written to a PKCS#12 file, whose certificate bags we write only as X.509.
+func checkPKCS12CertType(typ string) error {
+	if typ != "" && typ != CertType {
+		return errorf(CodeIncompatible, "certificate type %q cannot "+
+			"be written to a PKCS#12 file", typ)
+	}
+	return nil
+}
+
+// addKeypairBags appends a shrouded key bag holding kp's private key and a
+// certificate bag for each certificate in its chain.
+func addKeypairBags(b *cryptobyte.Builder, kp *Keypair, opts *Options) {
+	keyInfo, err := encryptPKCS12Key(kp, opts)
+	if err != nil {
+		b.SetError(err)
+		return
+	}
+	if len(kp.CertChain) == 0 {
+		b.SetError(errorf(CodeMissingData, "key %q: no certificate "+
+			"chain", kp.Alias))
+		return
+	}
+	for i, cert := range kp.CertChain {
+		if len(cert.DER()) == 0 {
+			b.SetError(errorf(CodeMissingData, "key %q: "+
+				"certificate chain entry #%d has no data",
+				kp.Alias, i+1))
+			return
+		}
+		if err := checkPKCS12CertType(cert.Type); err != nil {
+			b.SetError(errorf("", "key %q: certificate chain "+
+				"entry #%d: %v", kp.Alias, i+1, err))
+			return
+		}
+	}
+	localKeyID := kp.LocalKeyID
+	if localKeyID == nil {
+		fp := sha1.Sum(kp.CertChain[0].DER())
+		localKeyID = fp[:]
+	}
+	attrs := func(b *cryptobyte.Builder) {
+		addFriendlyName(b, friendlyName(kp.Alias, kp.FriendlyName))
+		addLocalKeyID(b, localKeyID)
+	}
+
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidShroudedKeyBag)
+		b.AddASN1(casn1.Tag(0).ContextSpecific().Constructed(),
+			func(b *cryptobyte.Builder) {
+				b.AddBytes(keyInfo)
+			})
+		b.AddASN1(casn1.SET, attrs)
+	})
+	for i, cert := range kp.CertChain {
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(oidCertBag)
+			b.AddASN1(casn1.Tag(0).ContextSpecific().Constructed(),
+				func(b *cryptobyte.Builder) {
+					addCertBag(b, cert.DER())
+				})
+			if i == 0 {
+				b.AddASN1(casn1.SET, attrs)
+			}
+		})
+	}
+}
+
+// encryptPKCS12Key returns kp's private key, marshalled as for Pack and then
+// encrypted, as a DER EncryptedPrivateKeyInfo. A key that was never decrypted
+// is passed through unchanged, as by Pack.
+func encryptPKCS12Key(kp *Keypair, opts *Options) ([]byte, error) {
+	kp, err := opts.rekey(kp)
+	if err != nil {
+		return nil, err
+	}
+	algos := []asn1.ObjectIdentifier{oidPBES2, oidPBEWithSHA1And3DES}
+	if opts.Compatibility < Java11 &&
+		opts.KeyProtection != KeyProtectionPBES2 {
+		algos[0], algos[1] = algos[1], algos[0]
+	}
+	raw, err := passThroughKey(kp, opts, algos...)
+	if raw != nil || err != nil {
+		return raw, err
+	}
+	if raw, err = marshalKeypairKey(kp, opts); err != nil {
+		return nil, err
+	}
+
+	defer clear(raw)
+	passwd, err := opts.packKeyPassword(kp.Alias)
+	if err != nil {
+		return nil, err
+	}
+	var keyInfo *EncryptedPrivateKeyInfo
+	if opts.Compatibility < Java11 &&
+		opts.KeyProtection != KeyProtectionPBES2 {
+		keyInfo, err = encryptPBEWithSHA1And3DES(raw, passwd,
+			opts.randReader(passwd, raw))
+	} else {
+		keyInfo, err = encryptPBES2(raw, passwd,
+			opts.randReader(passwd, raw))
+	}
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "key %q: failed to "+
+			"encrypt private key: %v", kp.Alias, err)
+	}
+	return keyInfo.Marshal()
+}
+
+// encryptPBEWithSHA1And3DES encrypts a marshalled PrivateKeyInfo using
+// pbeWithSHAAnd3-KeyTripleDES-CBC (RFC 7292 appendix C), which every JDK can
+// read.
+func encryptPBEWithSHA1And3DES(raw, password []byte, rnd io.Reader,
+) (*EncryptedPrivateKeyInfo, error) {
+	salt := make([]byte, 20)
+	if _, err := io.ReadFull(rnd, salt); err != nil {
+		return nil, err
+	}
+	key := pkcs12KDF(sha1.New, 1, password, salt, pkcs12KeyIterations, 24)
+	iv := pkcs12KDF(sha1.New, 2, password, salt, pkcs12KeyIterations, 8)
+	block, err := des.NewTripleDESCipher(key)
+	clear(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var params cryptobyte.Builder
+	params.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1OctetString(salt)
+		b.AddASN1Int64(pkcs12KeyIterations)
+	})
+	return encryptCBC(block, iv, raw, oidPBEWithSHA1And3DES, &params)
+}
+
+// encryptPBES2 encrypts a marshalled PrivateKeyInfo using PBES2 (RFC 8018)
+// with PBKDF2-HMAC-SHA256 and AES-256-CBC, as the JDK has done by default
+// since JDK 12.
+func encryptPBES2(raw, password []byte, rnd io.Reader,
+) (*EncryptedPrivateKeyInfo, error) {
+	salt, iv := make([]byte, 16), make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rnd, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rnd, iv); err != nil {
+		return nil, err
+	}
+	key := pbkdf2.Key(password, salt, pkcs12KeyIterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	clear(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var params cryptobyte.Builder
+	params.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(oidPBKDF2)
+			b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1OctetString(salt)
+				b.AddASN1Int64(pkcs12KeyIterations)
+				b.AddASN1(casn1.SEQUENCE,
+					func(b *cryptobyte.Builder) {
+						b.AddASN1ObjectIdentifier(
+							oidHMACWithSHA256)
+						b.AddASN1NULL()
+					})
+			})
+		})
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(oidAES256CBC)
+			b.AddASN1OctetString(iv)
+		})
+	})
+	return encryptCBC(block, iv, raw, oidPBES2, &params)
+}
+
+// encryptCBC pads raw as per PKCS#7 and encrypts it in CBC mode, returning an
+// EncryptedPrivateKeyInfo with the given algorithm and parameters.
+func encryptCBC(block cipher.Block, iv, raw []byte,
+	algo asn1.ObjectIdentifier, params *cryptobyte.Builder,
+) (*EncryptedPrivateKeyInfo, error) {
+	der, err := params.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	n := block.BlockSize() - len(raw)%block.BlockSize()
+	data := make([]byte, len(raw), len(raw)+n)
+	copy(data, raw)
+	data = append(data, bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return &EncryptedPrivateKeyInfo{
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm:  algo,
+			Parameters: asn1.RawValue{FullBytes: der},
+		},
+		EncryptedData: data,
+	}, nil
+}
+
+// addCertBag appends a CertBag holding an X.509 certificate.
+func addCertBag(b *cryptobyte.Builder, der []byte) {
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidX509Certificate)
+		b.AddASN1(casn1.Tag(0).ContextSpecific().Constructed(),
+			func(b *cryptobyte.Builder) {
+				b.AddASN1OctetString(der)
+			})
+	})
+}
+
+// addFriendlyName appends a friendlyName attribute.
+func addFriendlyName(b *cryptobyte.Builder, name string) {
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidFriendlyName)
+		b.AddASN1(casn1.SET, func(b *cryptobyte.Builder) {
+			b.AddASN1(casn1.Tag(30), func(b *cryptobyte.Builder) {
+				b.AddBytes(PasswordUTF16(name)) // BMPString
+			})
+		})
+	})
+}
+
+// addLocalKeyID appends a localKeyId attribute.
+func addLocalKeyID(b *cryptobyte.Builder, id []byte) {
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidLocalKeyID)
+		b.AddASN1(casn1.SET, func(b *cryptobyte.Builder) {
+			b.AddASN1OctetString(id)
+		})
+	})
+}
+
+// friendlyName returns the friendly name to write for an entry with the given
+// alias: the friendly name it was read with, if the alias has not changed
+// other than in case, and otherwise the alias.
+func friendlyName(alias, name string) string {
+	if name != "" && NormalizeAlias(name) == NormalizeAlias(alias) {
+		return name
+	}
+	return alias
+}
+
+// pkcs12KDF derives key material from a password as described in RFC 7292
+// appendix B.2. id selects the purpose (1 for encryption keys, 2 for IVs and
+// 3 for MAC keys).
+func pkcs12KDF(newHash func() hash.Hash, id byte, password, salt []byte,
+	iterations, size int,
+) []byte {
+	v := newHash().BlockSize()
+
+	// the password is a NUL-terminated BMPString
+	pass := append(passwordUTF16(password), 0, 0)
+	defer clear(pass)
+
+	fill := func(src []byte) []byte {
+		if len(src) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(src)+v-1)/v))
+		for i := range out {
+			out[i] = src[i%len(src)]
+		}
+		return out
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	I := append(fill(salt), fill(pass)...)
+	defer clear(I)
+
+	var out []byte
+	one := big.NewInt(1)
+	for len(out) < size {
+		h := newHash()
+		h.Write(d)
+		h.Write(I)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+
+		// I_j = (I_j + B + 1) mod 2^(8v) for each block of I
+		bInt := new(big.Int).SetBytes(fill(a))
+		bInt.Add(bInt, one)
+		for j := 0; j < len(I); j += v {
+			ij := new(big.Int).SetBytes(I[j : j+v])
+			ij.Add(ij, bInt)
+			buf := ij.Bytes()
+			if len(buf) > v {
+				buf = buf[len(buf)-v:]
+			}
+			blk := I[j : j+v]
+			for k := range blk {
+				blk[k] = 0
+			}
+			copy(blk[v-len(buf):], buf)
+		}
+	}
+	return out[:size]
+}
+
+// ParsePKCS12 parses a PKCS#12 file (RFC 7292), such as those written by
+// PackPKCS12, keytool or OpenSSL. opts is treated as for Parse: the file's
+// integrity MAC is verified with opts.Password unless SkipVerifyDigest is set,
+// and a mismatch returns ErrDigestMismatch along with a partial Keystore. The
+// error also matches ErrBadStorePassword unless an encrypted safe could be
+// decrypted with opts.Password. Encrypted certificate bags are decrypted with
+// opts.Password, and private keys with the password for their alias, which is
+// taken from the bag's friendlyName attribute.
+//
+// Each private key is paired with the certificate carrying the same
+// localKeyId attribute (or, failing that, the same friendlyName), and its
+// chain is built by matching each certificate's issuer to the subject of
+// another certificate in the file. Certificates which carry the Oracle trusted
+// key usage attribute, or which are not part of any chain, become trusted
+// certificate entries. Entries without a friendlyName are given an alias
+// derived from their fingerprint. Each entry's FriendlyName and LocalKeyID
+// record the attributes as read, so that PackPKCS12 can write them back. As
+// with Parse, errors decrypting or parsing an individual key or certificate
+// are stored within the returned Keystore.
+func ParsePKCS12(raw []byte, opts *Options) (*Keystore, error) {
+	opts, err := opts.parseOptions()
+	if err != nil {
+		return nil, err
+	}
+	defer opts.wipePasswords()
+
+	var (
+		pfx, macData cryptobyte.String
+		version      int
+		input        = cryptobyte.String(raw)
+	)
+	if !input.ReadASN1(&pfx, casn1.SEQUENCE) || !input.Empty() ||
+		!pfx.ReadASN1Integer(&version) {
+		return nil, newError(CodeMalformed, "malformed PKCS#12 PFX")
+	}
+	if version != 3 {
+		return nil, errorf(CodeBadVersion, "found PKCS#12 version %d, "+
+			"but expected version 3", version)
+	}
+	content, err := readDataContentInfo(&pfx)
+	if err != nil {
+		return nil, err
+	}
+	if !pfx.Empty() && (!pfx.ReadASN1(&macData, casn1.SEQUENCE) ||
+		!pfx.Empty()) {
+		return nil, newError(CodeMalformed, "malformed PKCS#12 MAC")
+	}
+
+	ks := &Keystore{ETag: ETag(raw)}
+	var macErr error
+	switch {
+	case opts.SkipVerifyDigest:
+	case macData == nil:
+		macErr = newError(CodeDigestMismatch, "PKCS#12 file has no "+
+			"MAC, so its integrity cannot be verified")
+	default:
+		if macErr = verifyPKCS12MAC(macData, content,
+			opts.password()); macErr != nil && !errors.Is(macErr,
+			ErrDigestMismatch) {
+			return nil, macErr
+		}
+		ks.Integrity = IntegrityVerified
+	}
+	if macErr != nil {
+		ks.Integrity = IntegrityMismatch
+	}
+
+	var (
+		bags     pkcs12Bags
+		authSafe cryptobyte.String
+		rest     = content
+		proven   bool
+	)
+	if !rest.ReadASN1(&authSafe, casn1.SEQUENCE) || !rest.Empty() {
+		return nil, newError(CodeMalformed, "malformed PKCS#12 "+
+			"AuthenticatedSafe")
+	}
+	for !authSafe.Empty() {
+		var (
+			ci          cryptobyte.String
+			contentType asn1.ObjectIdentifier
+			safe        []byte
+		)
+		if !authSafe.ReadASN1(&ci, casn1.SEQUENCE) ||
+			!ci.ReadASN1ObjectIdentifier(&contentType) {
+			return nil, newError(CodeMalformed, "malformed "+
+				"PKCS#12 ContentInfo")
+		}
+		switch {
+		case contentType.Equal(oidDataContentType):
+			var data cryptobyte.String
+			if !ci.ReadASN1(&data, casn1.Tag(0).ContextSpecific().
+				Constructed()) || !data.ReadASN1Bytes(&safe,
+				casn1.OCTET_STRING) {
+				return nil, newError(CodeMalformed,
+					"malformed PKCS#12 data ContentInfo")
+			}
+
+		case contentType.Equal(oidEncryptedData):
+			safe, err = decryptPKCS12Safe(ci, opts.password())
+			if err != nil {
+				if macErr != nil {
+					// most likely the wrong password
+					continue
+				}
+				return ks, err
+			}
+			proven = true
+
+		default:
+			// e.g. public-key enveloped data, which we cannot read
+			continue
+		}
+		if err := bags.parse(safe); err != nil {
+			return ks, err
+		}
+	}
+
+	bags.assemble(ks, opts)
+	if macErr != nil {
+		if opts.CertsOnDigestMismatch {
+			ks.Keypairs = nil
+		}
+		if macErr == ErrDigestMismatch {
+			macErr = digestMismatch(proven)
+		}
+		return ks, macErr
+	}
+	return ks, nil
+}
+
+// readDataContentInfo reads a PKCS#7 ContentInfo of type data, returning its
+// content.
+func readDataContentInfo(s *cryptobyte.String) (cryptobyte.String, error) {
+	var (
+		ci, data, content cryptobyte.String
+		contentType       asn1.ObjectIdentifier
+	)
+	if !s.ReadASN1(&ci, casn1.SEQUENCE) ||
+		!ci.ReadASN1ObjectIdentifier(&contentType) {
+		return nil, newError(CodeMalformed, "malformed PKCS#12 "+
+			"ContentInfo")
+	}
+	if !contentType.Equal(oidDataContentType) {
+		return nil, errorf(CodeUnsupported, "unhandled PKCS#12 "+
+			"content type %v", contentType)
+	}
+	if !ci.ReadASN1(&data, casn1.Tag(0).ContextSpecific().Constructed()) ||
+		!data.ReadASN1(&content, casn1.OCTET_STRING) {
+		return nil, newError(CodeMalformed, "malformed PKCS#12 data "+
+			"ContentInfo")
+	}
+	return content, nil
+}
+
+// verifyPKCS12MAC checks the MacData structure (RFC 7292 § 4) against the
+// AuthenticatedSafe content, returning ErrDigestMismatch if it does not match.
+func verifyPKCS12MAC(macData, content cryptobyte.String, password []byte,
+) error {
+	var (
+		digestInfo, algo cryptobyte.String
+		macOID           asn1.ObjectIdentifier
+		digest, salt     []byte
+		iterations       int
+	)
+	if !macData.ReadASN1(&digestInfo, casn1.SEQUENCE) ||
+		!digestInfo.ReadASN1(&algo, casn1.SEQUENCE) ||
+		!algo.ReadASN1ObjectIdentifier(&macOID) ||
+		!digestInfo.ReadASN1Bytes(&digest, casn1.OCTET_STRING) ||
+		!macData.ReadASN1Bytes(&salt, casn1.OCTET_STRING) {
+		return newError(CodeMalformed, "malformed PKCS#12 MAC")
+	}
+	iterations = 1 // DEFAULT 1
+	if !macData.Empty() && (!macData.ReadASN1Integer(&iterations) ||
+		!macData.Empty()) {
+		return newError(CodeMalformed, "malformed PKCS#12 MAC")
+	}
+	if iterations < 1 || iterations > pbeMaxIterations {
+		return errorf(CodeMalformed, "PKCS#12 MAC iteration count %d "+
+			"out of range", iterations)
+	}
+
+	var newHash func() hash.Hash
+	switch {
+	case macOID.Equal(oidSHA1):
+		newHash = sha1.New
+	case macOID.Equal(oidSHA256):
+		newHash = sha256.New
+	case macOID.Equal(oidSHA384):
+		newHash = sha512.New384
+	case macOID.Equal(oidSHA512):
+		newHash = sha512.New
+	default:
+		return errorf(CodeUnsupported, "unhandled PKCS#12 MAC "+
+			"algorithm %v", macOID)
+	}
+	key := pkcs12KDF(newHash, 3, password, salt, iterations,
+		newHash().Size())
+	mac := hmac.New(newHash, key)
+	clear(key)
+	mac.Write(content)
+	if !hmac.Equal(mac.Sum(nil), digest) {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// decryptPKCS12Safe decrypts the content of an encryptedData ContentInfo,
+// returning the SafeContents it holds.
+func decryptPKCS12Safe(ci cryptobyte.String, password []byte,
+) ([]byte, error) {
+	var (
+		wrapper, ed, eci cryptobyte.String
+		contentType      asn1.ObjectIdentifier
+		algo             pkix.AlgorithmIdentifier
+		version          int
+		data             []byte
+		algoDER          cryptobyte.String
+	)
+	if !ci.ReadASN1(&wrapper, casn1.Tag(0).ContextSpecific().
+		Constructed()) || !wrapper.ReadASN1(&ed, casn1.SEQUENCE) ||
+		!ed.ReadASN1Integer(&version) ||
+		!ed.ReadASN1(&eci, casn1.SEQUENCE) ||
+		!eci.ReadASN1ObjectIdentifier(&contentType) ||
+		!eci.ReadASN1Element(&algoDER, casn1.SEQUENCE) {
+		return nil, newError(CodeMalformed, "malformed PKCS#12 "+
+			"encryptedData ContentInfo")
+	}
+	if _, err := asn1.Unmarshal(algoDER, &algo); err != nil {
+		return nil, errorf(CodeMalformed, "malformed PKCS#12 "+
+			"encryption algorithm: %v", err)
+	}
+
+	// the content is [0] IMPLICIT OCTET STRING, which BER permits to be
+	// split into a constructed sequence of OCTET STRINGs
+	switch {
+	case eci.PeekASN1Tag(casn1.Tag(0).ContextSpecific()):
+		var s cryptobyte.String
+		eci.ReadASN1(&s, casn1.Tag(0).ContextSpecific())
+		data = s
+	case eci.PeekASN1Tag(casn1.Tag(0).ContextSpecific().Constructed()):
+		var s cryptobyte.String
+		eci.ReadASN1(&s, casn1.Tag(0).ContextSpecific().Constructed())
+		for !s.Empty() {
+			var part []byte
+			if !s.ReadASN1Bytes(&part, casn1.OCTET_STRING) {
+				return nil, newError(CodeMalformed,
+					"malformed PKCS#12 encrypted content")
+			}
+			data = append(data, part...)
+		}
+	default:
+		return nil, newError(CodeMissingData, "PKCS#12 encryptedData "+
+			"has no content")
+	}
+
+	safe, err := decryptPBE(algo, data, password)
+	if err != nil {
+		return nil, errorf("", "failed to decrypt PKCS#12 "+
+			"certificates: %v", err)
+	}
+	return safe, nil
+}
+
+// pkcs12Bag holds a key or certificate bag, along with its attributes, while a
+// PKCS#12 file is being parsed.
+type pkcs12Bag struct {
+	friendlyName string
+	localKeyID   []byte
+	trusted      bool
+
+	// for key bags: the PrivateKeyInfo, or the EncryptedPrivateKeyInfo
+	// if encrypted is set
+	key       []byte
+	encrypted bool
+
+	// for certificate bags
+	cert *KeypairCert
+	used bool
+}
+
+// pkcs12Bags collects the key and certificate bags of a PKCS#12 file.
+type pkcs12Bags struct {
+	keys  []*pkcs12Bag
+	certs []*pkcs12Bag
+}
+
+// parse reads the bags in a SafeContents structure. Bags of types other than
+// key, shrouded key and certificate bags are skipped.
+func (bags *pkcs12Bags) parse(raw []byte) error {
+	var (
+		safe  cryptobyte.String
+		input = cryptobyte.String(raw)
+	)
+	if !input.ReadASN1(&safe, casn1.SEQUENCE) || !input.Empty() {
+		return newError(CodeMalformed, "malformed PKCS#12 "+
+			"SafeContents")
+	}
+	for !safe.Empty() {
+		var (
+			sb, value cryptobyte.String
+			bagID     asn1.ObjectIdentifier
+			bag       = new(pkcs12Bag)
+		)
+		if !safe.ReadASN1(&sb, casn1.SEQUENCE) ||
+			!sb.ReadASN1ObjectIdentifier(&bagID) ||
+			!sb.ReadASN1(&value, casn1.Tag(0).ContextSpecific().
+				Constructed()) {
+			return newError(CodeMalformed, "malformed PKCS#12 "+
+				"SafeBag")
+		}
+		if err := bag.parseAttributes(sb); err != nil {
+			return err
+		}
+
+		switch {
+		case bagID.Equal(oidKeyBag), bagID.Equal(oidShroudedKeyBag):
+			var key cryptobyte.String
+			if !value.ReadASN1Element(&key, casn1.SEQUENCE) {
+				return newError(CodeMalformed, "malformed "+
+					"PKCS#12 key bag")
+			}
+			bag.key = key
+			bag.encrypted = bagID.Equal(oidShroudedKeyBag)
+			bags.keys = append(bags.keys, bag)
+
+		case bagID.Equal(oidCertBag):
+			var (
+				cb, wrapper cryptobyte.String
+				certID      asn1.ObjectIdentifier
+				der         []byte
+			)
+			if !value.ReadASN1(&cb, casn1.SEQUENCE) ||
+				!cb.ReadASN1ObjectIdentifier(&certID) {
+				return newError(CodeMalformed, "malformed "+
+					"PKCS#12 certificate bag")
+			}
+			if !certID.Equal(oidX509Certificate) {
+				continue // e.g. an SDSI certificate
+			}
+			if !cb.ReadASN1(&wrapper, casn1.Tag(0).
+				ContextSpecific().Constructed()) ||
+				!wrapper.ReadASN1Bytes(&der,
+					casn1.OCTET_STRING) {
+				return newError(CodeMalformed, "malformed "+
+					"PKCS#12 certificate bag")
+			}
+			bag.cert = &KeypairCert{Raw: der}
+			bag.cert.Cert, bag.cert.CertErr = x509.ParseCertificate(
+				der)
+			bags.certs = append(bags.certs, bag)
+		}
+	}
+	return nil
+}
+
+// parseAttributes reads the optional bag attributes following a SafeBag's
+// value, recording those we understand.
+func (bag *pkcs12Bag) parseAttributes(sb cryptobyte.String) error {
+	var attrs cryptobyte.String
+	if !sb.ReadOptionalASN1(&attrs, nil, casn1.SET) || !sb.Empty() {
+		return newError(CodeMalformed, "malformed PKCS#12 SafeBag")
+	}
+	for !attrs.Empty() {
+		var (
+			attr, values cryptobyte.String
+			attrID       asn1.ObjectIdentifier
+		)
+		if !attrs.ReadASN1(&attr, casn1.SEQUENCE) ||
+			!attr.ReadASN1ObjectIdentifier(&attrID) ||
+			!attr.ReadASN1(&values, casn1.SET) {
+			return newError(CodeMalformed, "malformed PKCS#12 bag "+
+				"attribute")
+		}
+
+		switch {
+		case attrID.Equal(oidFriendlyName):
+			var name cryptobyte.String
+			if !values.ReadASN1(&name, casn1.Tag(30)) ||
+				len(name)%2 != 0 {
+				return newError(CodeMalformed, "malformed "+
+					"PKCS#12 friendlyName attribute")
+			}
+			u := make([]uint16, len(name)/2)
+			for i := range u {
+				u[i] = binary.BigEndian.Uint16(name[2*i:])
+			}
+			bag.friendlyName = string(utf16.Decode(u))
+
+		case attrID.Equal(oidLocalKeyID):
+			if !values.ReadASN1Bytes(&bag.localKeyID,
+				casn1.OCTET_STRING) {
+				return newError(CodeMalformed, "malformed "+
+					"PKCS#12 localKeyId attribute")
+			}
+
+		case attrID.Equal(OracleTrustedKeyUsageOID):
+			bag.trusted = true
+		}
+	}
+	return nil
+}
+
+// timestamp returns the creation time the JDK records in the localKeyId
+// attribute of the bags it writes for a keypair, as "Time " followed by the
+// time in milliseconds since the epoch. Lacking that, it returns now, as the
+// JDK does.
+func (bag *pkcs12Bag) timestamp(now time.Time) time.Time {
+	id := string(bag.localKeyID)
+	if !strings.HasPrefix(id, "Time ") {
+		return now
+	}
+	ms, err := strconv.ParseInt(id[5:], 10, 64)
+	if err != nil {
+		return now
+	}
+	return time.UnixMilli(ms)
+}
+
+// assemble builds ks's entries from the bags, pairing each key with its
+// certificate chain. Entries skipped under Options.CertsOnly and Options.Alias
+// are dropped once every alias is known, and their keys are not decrypted.
+func (bags *pkcs12Bags) assemble(ks *Keystore, opts *Options) {
+	now := opts.now()
+	keys := newKeyUnlocker(opts)
+	defer keys.wait()
+	for _, bag := range bags.keys {
+		kp := &Keypair{
+			Alias:        bag.friendlyName,
+			Timestamp:    bag.timestamp(now),
+			FriendlyName: bag.friendlyName,
+			LocalKeyID:   bag.localKeyID,
+		}
+		if leaf := bags.leaf(bag); leaf != nil {
+			kp.CertChain = bags.chain(leaf)
+		}
+		if kp.Alias == "" {
+			var der []byte
+			if len(kp.CertChain) != 0 {
+				der = kp.CertChain[0].DER()
+			}
+			kp.Alias = ks.fingerprintAlias("key", der)
+		}
+		switch {
+		case opts.skipEntry(kp.Alias, true):
+		case bag.encrypted:
+			kp.EncryptedKey = bag.key
+			keys.add(kp)
+		default:
+			kp.RawKey = bag.key
+			kp.parseRawKey()
+		}
+		ks.Keypairs = append(ks.Keypairs, kp)
+	}
+
+	for _, bag := range bags.certs {
+		if bag.used && !bag.trusted {
+			continue
+		}
+		cert := &Cert{
+			Alias:        bag.friendlyName,
+			Timestamp:    bag.timestamp(now),
+			Raw:          bag.cert.Raw,
+			Cert:         bag.cert.Cert,
+			CertErr:      bag.cert.CertErr,
+			FriendlyName: bag.friendlyName,
+			LocalKeyID:   bag.localKeyID,
+		}
+		if cert.Alias == "" || ks.hasAlias(cert.Alias) {
+			if cert.Alias == "" {
+				cert.Alias = "cert"
+			}
+			cert.Alias = ks.fingerprintAlias(cert.Alias, cert.Raw)
+		}
+		ks.Certs = append(ks.Certs, cert)
+	}
+
+	if opts.filtered() {
+		ks.Keypairs = slices.DeleteFunc(ks.Keypairs,
+			func(kp *Keypair) bool {
+				return opts.skipEntry(kp.Alias, true)
+			})
+		ks.Certs = slices.DeleteFunc(ks.Certs, func(cert *Cert) bool {
+			return opts.skipEntry(cert.Alias, false)
+		})
+	}
+}
+
+// leaf returns the certificate bag which matches the key bag, by localKeyId
+// or by friendlyName, or nil if there is none.
+func (bags *pkcs12Bags) leaf(key *pkcs12Bag) *pkcs12Bag {
+	if len(key.localKeyID) != 0 {
+		for _, bag := range bags.certs {
+			if bytes.Equal(bag.localKeyID, key.localKeyID) {
+				return bag
+			}
+		}
+	}
+	if key.friendlyName != "" {
+		for _, bag := range bags.certs {
+			if bag.friendlyName == key.friendlyName {
+				return bag
+			}
+		}
+	}
+	return nil
+}
+
+// chain returns the certificate chain starting at leaf (see orderChain), and
+// marks each certificate bag in it as used.
+func (bags *pkcs12Bags) chain(leaf *pkcs12Bag) []*KeypairCert {
+	certs := make([]*KeypairCert, len(bags.certs))
+	for i, bag := range bags.certs {
+		certs[i] = bag.cert
+	}
+	chain := orderChain(leaf.cert, certs)
+	leaf.used = true
+	for _, bag := range bags.certs {
+		if inChain(chain, bag.cert) {
+			bag.used = true
+		}
+	}
+	return chain
+}
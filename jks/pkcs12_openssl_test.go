@@ -0,0 +1,226 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestPackPKCS12 checks a PKCS#12 file with the openssl command line tool,
+// which verifies the MAC, decrypts the private keys and lists the bag
+// attributes.
+func TestPackPKCS12(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not available")
+	}
+
+	for _, compat := range []jks.Compatibility{jks.Java8, jks.Java17} {
+		b := jkstest.New(t, "password").CA("root").CA("other").
+			ECKeypair("server", elliptic.P256())
+		opts := b.Options()
+		opts.Compatibility = compat
+		raw, err := b.Keystore().PackPKCS12(opts)
+		if err != nil {
+			t.Fatalf("%v: PackPKCS12: %v", compat, err)
+		}
+
+		fn := filepath.Join(t.TempDir(), "trust.p12")
+		if err = ioutil.WriteFile(fn, raw, 0600); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(openssl, "pkcs12", "-in", fn,
+			"-passin", "pass:password", "-info", "-nodes",
+		).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: openssl: %v\n%s", compat, err, out)
+		}
+		for _, exp := range []string{
+			"friendlyName: root",
+			"friendlyName: other",
+			"friendlyName: server",
+			"localKeyID:",
+			"BEGIN PRIVATE KEY",
+			jks.OracleTrustedKeyUsageOID.String(),
+		} {
+			if !strings.Contains(string(out), exp) {
+				t.Errorf("%v: openssl output lacks %q:\n%s",
+					compat, exp, out)
+			}
+		}
+	}
+}
+
+// TestParsePKCS12OpenSSL checks that ParseAny reads PKCS#12 files written by
+// the openssl command line tool, both with its current defaults (PBES2 with
+// AES-256) and with the legacy algorithms (40-bit RC2 for certificates and
+// Triple DES for keys) still found in many files.
+func TestParsePKCS12OpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not available")
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) error {
+		out, err := exec.Command(openssl, args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("openssl %s: %v\n%s", args[0], err,
+				out)
+		}
+		return nil
+	}
+	fn := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	if err := run("req", "-x509", "-newkey", "ec", "-pkeyopt",
+		"ec_paramgen_curve:P-256", "-nodes", "-subj", "/CN=ca",
+		"-keyout", fn("ca.key"), "-out", fn("ca.pem"), "-days", "1",
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("req", "-newkey", "rsa:2048", "-nodes", "-subj",
+		"/CN=server", "-keyout", fn("server.key"), "-out",
+		fn("server.csr"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("x509", "-req", "-in", fn("server.csr"), "-CA",
+		fn("ca.pem"), "-CAkey", fn("ca.key"), "-CAcreateserial",
+		"-out", fn("server.pem"), "-days", "1",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, legacy := range []bool{false, true} {
+		args := []string{"pkcs12", "-export", "-in", fn("server.pem"),
+			"-inkey", fn("server.key"), "-certfile", fn("ca.pem"),
+			"-name", "server", "-passout", "pass:password",
+			"-out", fn("server.p12")}
+		if legacy {
+			args = append(args, "-legacy")
+		}
+		if err := run(args...); err != nil {
+			if legacy {
+				t.Skipf("legacy algorithms unavailable: %v",
+					err)
+			}
+			t.Fatal(err)
+		}
+		raw, err := ioutil.ReadFile(fn("server.p12"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ks, format, err := jks.ParseAny(raw, &jks.Options{
+			Password: "password",
+		})
+		switch {
+		case err != nil:
+			t.Fatalf("legacy=%t: ParseAny: %v", legacy, err)
+		case format != jks.FormatPKCS12:
+			t.Errorf("legacy=%t: format %v ≠ expected pkcs12",
+				legacy, format)
+		case len(ks.Keypairs) != 1:
+			t.Fatalf("legacy=%t: got %d keypairs ≠ expected 1",
+				legacy, len(ks.Keypairs))
+		case len(ks.Certs) != 0:
+			t.Errorf("legacy=%t: got %d trusted certificates ≠ "+
+				"expected 0", legacy, len(ks.Certs))
+		}
+		kp := ks.Keypairs[0]
+		switch {
+		case kp.Alias != "server":
+			t.Errorf("legacy=%t: alias %q ≠ expected server",
+				legacy, kp.Alias)
+		case kp.PrivKeyErr != nil:
+			t.Errorf("legacy=%t: %v", legacy, kp.PrivKeyErr)
+		case len(kp.CertChain) != 2 ||
+			kp.CertChain[1].Cert.Subject.CommonName != "ca":
+			t.Errorf("legacy=%t: chain not recovered", legacy)
+		}
+	}
+}
+
+// TestToPEMOpenSSL checks that openssl can decrypt a private key exported by
+// Keypair.ToPEM with a password.
+func TestToPEMOpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not available")
+	}
+
+	b := jkstest.New(t, "password").ECKeypair("server", elliptic.P256())
+	data, err := b.Keystore().Keypairs[0].ToPEM("secret")
+	if err != nil {
+		t.Fatalf("ToPEM: %v", err)
+	}
+	fn := filepath.Join(t.TempDir(), "server.pem")
+	if err = ioutil.WriteFile(fn, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(openssl, "pkey", "-in", fn,
+		"-passin", "pass:secret", "-noout",
+	).CombinedOutput()
+	if err != nil {
+		t.Errorf("openssl: %v\n%s", err, out)
+	}
+}
+
+// TestDecryptPKCS8OpenSSL checks that DecryptPKCS8 decrypts keys encrypted by
+// the openssl command line tool with each of the PBES1 schemes, and with PBES2
+// using other ciphers and PRFs than those PackPKCS12 writes.
+func TestDecryptPKCS8OpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl not available")
+	}
+	raw, err := jks.MarshalPKCS8(jkstest.ECKey(t, elliptic.P256()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := filepath.Join(t.TempDir(), "key.der")
+	if err = ioutil.WriteFile(in, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scheme := range []string{
+		"-v1 PBE-MD5-DES",
+		"-v1 PBE-SHA1-DES",
+		"-v1 PBE-MD5-RC2-64",
+		"-v1 PBE-SHA1-RC2-64",
+		"-v2 des-cbc -v2prf hmacWithSHA512",
+		"-v2 aes-128-cbc -v2prf hmacWithSHA384",
+		"-v2 des-ede3-cbc -v2prf hmacWithSHA224",
+	} {
+		args := append([]string{"pkcs8", "-topk8", "-inform", "DER",
+			"-in", in, "-outform", "DER", "-passout",
+			"pass:password"}, strings.Fields(scheme)...)
+		if strings.HasPrefix(scheme, "-v1") ||
+			strings.Contains(scheme, "des-cbc") {
+			// DES and RC2 are in OpenSSL 3's legacy provider
+			args = append(args, "-provider", "legacy",
+				"-provider", "default")
+		}
+		enc, err := exec.Command(openssl, args...).Output()
+		if err != nil {
+			t.Logf("%s: openssl: %v (skipped)", scheme, err)
+			continue
+		}
+		dec, err := jks.DecryptPKCS8(enc, "password")
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", scheme, err)
+		case !bytes.Equal(dec, raw):
+			t.Errorf("%s: decrypted key does not match", scheme)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package jks
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+)
+
+// TestPKCS12KDF checks the PKCS#12 key derivation function against known
+// test vectors.
+func TestPKCS12KDF(t *testing.T) {
+	t.Run("encryption key", testPKCS12KDF(1, "smeg", "0A58CF64530D823F",
+		"8AAAE6297B6CB04642AB5B077851284EB7128F1A2A7FBCA3"))
+	t.Run("MAC key", testPKCS12KDF(3, "smeg", "3D83C0E4546AC140",
+		"8D967D88F6CAA9D714800AB3D48051D63F73A312"))
+}
+
+func testPKCS12KDF(id byte, password, salt, exp string) func(*testing.T) {
+	return func(t *testing.T) {
+		s, _ := hex.DecodeString(salt)
+		e, _ := hex.DecodeString(exp)
+		key := pkcs12KDF(sha1.New, id, []byte(password), s, 1, len(e))
+		if !bytes.Equal(key, e) {
+			t.Errorf("key %X ≠ expected %X", key, e)
+		}
+	}
+}
+
+// TestRC2 checks RC2 decryption against test vectors from RFC 2268 § 5.
+func TestRC2(t *testing.T) {
+	t.Run("8 byte key, 63 bits", testRC2("0000000000000000", 63,
+		"0000000000000000", "ebb773f993278eff"))
+	t.Run("8 byte key, 64 bits", testRC2("ffffffffffffffff", 64,
+		"ffffffffffffffff", "278b27e42e2f0d49"))
+	t.Run("16 byte key, 128 bits", testRC2(
+		"88bca90e90875a7f0f79c384627bafb2", 128, "0000000000000000",
+		"2269552ab0f85ca6"))
+}
+
+func testRC2(key string, bits int, plaintext, ciphertext string,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		k, _ := hex.DecodeString(key)
+		c, _ := hex.DecodeString(ciphertext)
+		exp, _ := hex.DecodeString(plaintext)
+		out := make([]byte, 8)
+		newRC2Cipher(k, bits).Decrypt(out, c)
+		if !bytes.Equal(out, exp) {
+			t.Errorf("plaintext %X ≠ expected %X", out, exp)
+		}
+	}
+}
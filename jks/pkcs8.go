@@ -2,15 +2,22 @@ package jks
 
 import (
 	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
-	"errors"
-	"fmt"
+	"io"
+
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
 )
 
 var (
@@ -28,6 +35,7 @@ var (
 
 	// RFC 3279 § 2.3
 	oidPublicKeyRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidPublicKeyDSA = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
 
 	// RFC 5480 § 2.1.1
 	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
@@ -36,6 +44,9 @@ var (
 	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
 	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
 
+	// RFC 8410 § 3
+	oidPublicKeyEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
 	// Java appears to want unused parameters structures encoded as an
 	// ASN.1 NULL type.
 	asn1NULL = asn1.RawValue{
@@ -70,106 +81,195 @@ type PrivateKeyInfo struct {
 	PrivateKey []byte
 }
 
+const (
+	expectedEncryptedPrivateKeyInfo = "SEQUENCE { " +
+		"AlgorithmIdentifier, OCTET STRING }"
+	expectedPrivateKeyInfo = "SEQUENCE { INTEGER, " +
+		"AlgorithmIdentifier, OCTET STRING, [0] Attributes OPTIONAL }"
+)
+
+// ParseEncryptedPrivateKeyInfo parses a DER-encoded EncryptedPrivateKeyInfo
+// structure. Any trailing data is treated as an error. Errors describing a
+// malformed structure are of type *ASN1Error.
+func ParseEncryptedPrivateKeyInfo(raw []byte,
+) (*EncryptedPrivateKeyInfo, error) {
+	var (
+		epki    EncryptedPrivateKeyInfo
+		seq     cryptobyte.String
+		err     error
+		p, data = newDERParser(raw, "EncryptedPrivateKeyInfo",
+			expectedEncryptedPrivateKeyInfo)
+	)
+	if !data.ReadASN1(&seq, casn1.SEQUENCE) {
+		return nil, p.errorAt(data, "outer sequence")
+	}
+	if !data.Empty() {
+		return nil, p.errorAt(data, "trailing data")
+	}
+	if epki.Algo, err = p.readAlgorithmIdentifier(&seq); err != nil {
+		return nil, err
+	}
+	if !seq.ReadASN1Bytes(&epki.EncryptedData, casn1.OCTET_STRING) {
+		return nil, p.errorAt(seq, "encrypted data")
+	}
+	if !seq.Empty() {
+		return nil, p.errorAt(seq, "trailing data in sequence")
+	}
+	return &epki, nil
+}
+
+// Marshal returns the DER encoding of the structure.
+func (epki *EncryptedPrivateKeyInfo) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addAlgorithmIdentifier(b, epki.Algo)
+		b.AddASN1OctetString(epki.EncryptedData)
+	})
+	return b.Bytes()
+}
+
+// ParsePrivateKeyInfo parses a DER-encoded PrivateKeyInfo structure. Any
+// trailing data is treated as an error, but the optional attributes and public
+// key fields (which Java does not use) are skipped over. Errors describing a
+// malformed structure are of type *ASN1Error, without a Snippet.
+func ParsePrivateKeyInfo(raw []byte) (*PrivateKeyInfo, error) {
+	var (
+		pki     PrivateKeyInfo
+		seq     cryptobyte.String
+		err     error
+		p, data = newDERParser(raw, "PrivateKeyInfo",
+			expectedPrivateKeyInfo)
+	)
+	p.secret = true
+	if !data.ReadASN1(&seq, casn1.SEQUENCE) {
+		return nil, p.errorAt(data, "outer sequence")
+	}
+	if !data.Empty() {
+		return nil, p.errorAt(data, "trailing data")
+	}
+	if !seq.ReadASN1Integer(&pki.Version) {
+		return nil, p.errorAt(seq, "version")
+	}
+	if pki.Version != 0 && pki.Version != 1 {
+		return nil, p.errorAt(seq, "version (must be 0 or 1)")
+	}
+	if pki.Algo, err = p.readAlgorithmIdentifier(&seq); err != nil {
+		return nil, err
+	}
+	if !seq.ReadASN1Bytes(&pki.PrivateKey, casn1.OCTET_STRING) {
+		return nil, p.errorAt(seq, "private key")
+	}
+	if !seq.SkipOptionalASN1(casn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, p.errorAt(seq, "attributes")
+	}
+	if !seq.SkipOptionalASN1(casn1.Tag(1).ContextSpecific()) {
+		return nil, p.errorAt(seq, "public key")
+	}
+	if !seq.Empty() {
+		return nil, p.errorAt(seq, "trailing data in sequence")
+	}
+	return &pki, nil
+}
+
+// Marshal returns the DER encoding of the structure.
+func (pki *PrivateKeyInfo) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1Int64(int64(pki.Version))
+		addAlgorithmIdentifier(b, pki.Algo)
+		b.AddASN1OctetString(pki.PrivateKey)
+	})
+	return b.Bytes()
+}
+
 // DecryptPKCS8 decrypts a PKCS#8 EncryptedPrivateKeyInfo, presumably returning
-// a marshalled PrivateKeyInfo structure. It only knows how to handle the two
-// encryption algorithms that are used by the Java keytool program.
+// a marshalled PrivateKeyInfo structure. It handles the two encryption
+// algorithms used in JKS and JCEKS files by the Java keytool program, those
+// found in PKCS#12 files, and the PBES1 and PBES2 schemes (such as
+// PBEWithMD5AndDES) with which other tools protect keys.
 func DecryptPKCS8(raw []byte, password string) ([]byte, error) {
-	// unmarshal the ASN.1 structure, ensure there's no trailing data
-	var keyInfo EncryptedPrivateKeyInfo
-	rest, err := asn1.Unmarshal(raw, &keyInfo)
+	return decryptPKCS8(raw, []byte(password), PasswordUTF16BE)
+}
+
+// EncryptPKCS8 encrypts a marshalled PrivateKeyInfo structure, such as
+// MarshalPKCS8 returns, with password, returning a DER-encoded
+// EncryptedPrivateKeyInfo. It uses PBES2 with PBKDF2-HMAC-SHA256 and
+// AES-256-CBC, which openssl, HAProxy and Java all read, and which
+// DecryptPKCS8 reverses. The password must not be empty.
+func EncryptPKCS8(raw []byte, password string) ([]byte, error) {
+	if password == "" {
+		return nil, newError(CodeInvalidArgument, "cannot encrypt a "+
+			"private key with an empty password")
+	}
+	keyInfo, err := encryptPBES2(raw, []byte(password), rand.Reader)
 	if err != nil {
-		// asn1 package errors are not actually that helpful
-		return nil, errors.New("malformed PKCS#8 private key structure")
+		return nil, errorf(CodeCryptoFailure, "failed to encrypt "+
+			"private key: %v", err)
 	}
-	if len(rest) != 0 {
-		return nil, errors.New("trailing data after PKCS#8 private key")
+	return keyInfo.Marshal()
+}
+
+// decryptPKCS8 implements DecryptPKCS8 for a password held in a byte slice.
+// enc is the encoding used for the first Java key encryption algorithm.
+func decryptPKCS8(raw, password []byte, enc PasswordEncoding,
+) ([]byte, error) {
+	// unmarshal the ASN.1 structure, ensure there's no trailing data
+	keyInfo, err := ParseEncryptedPrivateKeyInfo(raw)
+	if err != nil {
+		return nil, err
 	}
 
 	switch {
 	case keyInfo.Algo.Algorithm.Equal(JavaKeyEncryptionOID1):
 		// this algorithm doesn't have any parameters
 		if len(keyInfo.Algo.Parameters.Bytes) != 0 {
-			return nil, errors.New("unexpected algorithm " +
-				"params present")
+			return nil, newError(CodeMalformed, "unexpected "+
+				"algorithm params present")
 		}
-		return DecryptJavaKeyEncryption1(keyInfo.EncryptedData,
-			password)
+		return decryptJavaKeyEncryption1(keyInfo.EncryptedData,
+			password, enc)
 
 	case keyInfo.Algo.Algorithm.Equal(JavaKeyEncryptionOID2):
-		// TODO: need to implement this
-		return nil, errors.New("not implemented yet")
+		salt, iterations, err := parsePBEParameter(
+			keyInfo.Algo.Parameters.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		return decryptJavaKeyEncryption2(keyInfo.EncryptedData,
+			password, salt, iterations)
 
 	default:
-		return nil, fmt.Errorf("unhandled encryption algorithm %v",
-			keyInfo.Algo.Algorithm)
+		return decryptPBE(keyInfo.Algo, keyInfo.EncryptedData,
+			password)
 	}
 }
 
-// MarshalPKCS8 marshals an RSA or EC private key into an (unencrypted)
-// PKCS#8 PrivateKeyInfo structure. It returns the DER-encoded structure.
+// ErrUnsupportedKeyAlgorithm is returned (wrapped, with the key's alias) by
+// Pack when a keypair's PrivateKey is not of a type that MarshalPKCS8 handles.
+var ErrUnsupportedKeyAlgorithm error = newError(CodeUnsupportedKeyAlg,
+	"unsupported private key algorithm")
+
+// MarshalPKCS8 marshals an RSA, EC, Ed25519 or DSA private key into an
+// (unencrypted) PKCS#8 PrivateKeyInfo structure, using
+// x509.MarshalPKCS8PrivateKey for all but DSA. It returns the DER-encoded
+// structure.
 func MarshalPKCS8(key interface{}) ([]byte, error) {
-	var ki PrivateKeyInfo
 	switch key := key.(type) {
-	case *rsa.PrivateKey:
-		// we simply put the PKCS#1-encoded key into a wrapper that
-		// says it's an RSA key
-		ki.Algo = pkix.AlgorithmIdentifier{
-			Algorithm:  oidPublicKeyRSA,
-			Parameters: asn1NULL,
-		}
-		ki.PrivateKey = x509.MarshalPKCS1PrivateKey(key)
-
-	case *ecdsa.PrivateKey:
-		// the PKCS#8 wrapper (PrivateKeyInfo) has algorithm set to
-		// identify the elliptic curve key, but needs a parameter to
-		// state the curve.
-		c, err := oidFromNamedCurve(key)
-		if err != nil {
-			return nil, err
-		}
-
-		ki.Algo = pkix.AlgorithmIdentifier{
-			Algorithm: oidPublicKeyECDSA,
-		}
-		ki.Algo.Parameters.FullBytes, err = asn1.Marshal(c)
-		if err != nil {
-			return nil, fmt.Errorf("marshal EC private key "+
-				"params: %v", err)
-		}
-
-		ki.PrivateKey, err = x509.MarshalECPrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("marshal EC private key: %v",
-				err)
-		}
-
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+	case *dsa.PrivateKey:
+		return marshalDSAPrivateKey(key)
 	default:
-		return nil, fmt.Errorf("unhandled private key type %T", key)
+		return nil, errorf("", "%v: %T", ErrUnsupportedKeyAlgorithm,
+			key)
 	}
-
-	raw, err := asn1.Marshal(ki)
+	raw, err := x509.MarshalPKCS8PrivateKey(key)
 	if err != nil {
-		return nil, fmt.Errorf("marshal PrivateKeyInfo: %v", err)
+		return nil, errorf(CodeUnsupportedKeyAlg, "marshal "+
+			"PrivateKeyInfo: %v", err)
 	}
 	return raw, nil
 }
 
-// oidFromNamedCurve returns an OID which identifies the curve used in the
-// given key.
-func oidFromNamedCurve(key *ecdsa.PrivateKey) (asn1.ObjectIdentifier, error) {
-	switch key.Params().Name {
-	case "P-224":
-		return oidNamedCurveP224, nil
-	case "P-256":
-		return oidNamedCurveP256, nil
-	case "P-384":
-		return oidNamedCurveP384, nil
-	case "P-521":
-		return oidNamedCurveP521, nil
-	}
-	return nil, fmt.Errorf("unknown named curve %q", key.Params().Name)
-}
-
 // DecryptJavaKeyEncryption1 decrypts ciphertext encrypted with one of the Java
 // key encryption algorithms.
 //
@@ -178,10 +278,20 @@ func oidFromNamedCurve(key *ecdsa.PrivateKey) (asn1.ObjectIdentifier, error) {
 // or a file with a password, then see the password-encrypt example at:
 //  https://github.com/lwithers/go-crypto-examples
 func DecryptJavaKeyEncryption1(ciphertext []byte, password string,
+) ([]byte, error) {
+	return decryptJavaKeyEncryption1(ciphertext, []byte(password),
+		PasswordUTF16BE)
+}
+
+// decryptJavaKeyEncryption1 implements DecryptJavaKeyEncryption1 for a
+// password held in a byte slice, which is encoded with enc.
+func decryptJavaKeyEncryption1(ciphertext, password []byte,
+	enc PasswordEncoding,
 ) ([]byte, error) {
 	// split the blob into salt:ciphertext:digest
 	if len(ciphertext) <= 40 {
-		return nil, errors.New("not enough data for encryption type 1")
+		return nil, newError(CodeTruncated, "not enough data for "+
+			"encryption type 1")
 	}
 	salt := ciphertext[:20]
 	digest := ciphertext[len(ciphertext)-20:]
@@ -189,9 +299,11 @@ func DecryptJavaKeyEncryption1(ciphertext []byte, password string,
 
 	// XOR the SHA-1-derived bytestream with the "ciphertext" to recover
 	// the plaintext
-	passwd := PasswordUTF16(password)
+	passwd := enc.encode(password)
+	defer clear(passwd)
 	xorStream := xorStreamForJavaKeyEncryption1(len(ciphertext),
 		passwd, salt)
+	defer clear(xorStream)
 	plaintext := make([]byte, len(ciphertext))
 	for i := range ciphertext {
 		plaintext[i] = ciphertext[i] ^ xorStream[i]
@@ -204,7 +316,8 @@ func DecryptJavaKeyEncryption1(ciphertext []byte, password string,
 	md.Write(plaintext)
 	computed := md.Sum(nil)
 	if !bytes.Equal(computed, digest) {
-		return nil, errors.New("invalid password")
+		clear(plaintext)
+		return nil, newError(CodeBadKeyPassword, "invalid password")
 	}
 
 	return plaintext, nil
@@ -218,18 +331,30 @@ func DecryptJavaKeyEncryption1(ciphertext []byte, password string,
 // or a file with a password, then see the password-encrypt example at:
 //  https://github.com/lwithers/go-crypto-examples
 func EncryptJavaKeyEncryption1(plaintext []byte, password string,
+) ([]byte, error) {
+	return encryptJavaKeyEncryption1(plaintext, []byte(password),
+		PasswordUTF16BE, rand.Reader)
+}
+
+// encryptJavaKeyEncryption1 implements EncryptJavaKeyEncryption1 for a
+// password held in a byte slice, which is encoded with enc. The salt is read
+// from rnd.
+func encryptJavaKeyEncryption1(plaintext, password []byte,
+	enc PasswordEncoding, rnd io.Reader,
 ) ([]byte, error) {
 	// generate a salt
 	var salt [20]byte
-	if _, err := rand.Read(salt[:]); err != nil {
+	if _, err := io.ReadFull(rnd, salt[:]); err != nil {
 		return nil, err
 	}
 
 	// XOR the SHA-1-derived bytestream with the plaintext to derive the
 	// "ciphertext"
-	passwd := PasswordUTF16(password)
+	passwd := enc.encode(password)
+	defer clear(passwd)
 	xorStream := xorStreamForJavaKeyEncryption1(len(plaintext),
 		passwd, salt[:])
+	defer clear(xorStream)
 	ciphertext := make([]byte, len(plaintext))
 	for i := range ciphertext {
 		ciphertext[i] = plaintext[i] ^ xorStream[i]
@@ -249,6 +374,115 @@ func EncryptJavaKeyEncryption1(plaintext []byte, password string,
 	return result, nil
 }
 
+// parsePBEParameter parses the PKCS#5 PBEParameter structure (RFC 8018
+// appendix A.3) used with JavaKeyEncryptionOID2.
+func parsePBEParameter(raw []byte) (salt []byte, iterations int,
+	err error,
+) {
+	var (
+		seq   cryptobyte.String
+		input = cryptobyte.String(raw)
+	)
+	if !input.ReadASN1(&seq, casn1.SEQUENCE) || !input.Empty() ||
+		!seq.ReadASN1Bytes(&salt, casn1.OCTET_STRING) ||
+		!seq.ReadASN1Integer(&iterations) || !seq.Empty() {
+		return nil, 0, newError(CodeMalformed, "malformed "+
+			"PBEParameter")
+	}
+	if len(salt) != 8 {
+		return nil, 0, errorf(CodeMalformed, "PBEParameter salt "+
+			"length %d (expected 8)", len(salt))
+	}
+	if iterations < 1 || iterations > pbeMaxIterations {
+		return nil, 0, errorf(CodeMalformed, "PBEParameter "+
+			"iteration count %d out of range", iterations)
+	}
+	return salt, iterations, nil
+}
+
+// DecryptJavaKeyEncryption2 decrypts ciphertext encrypted with
+// PBEWithMD5AndTripleDES, the second of the Java key encryption algorithms,
+// which is used by JCEKS files. salt and iterations come from the algorithm
+// parameters.
+//
+// PLEASE NOTE: this is a proprietary variant of PKCS#5 PBES1. DO NOT RE-USE
+// THIS CODE for anything other than reading existing keystores.
+func DecryptJavaKeyEncryption2(ciphertext []byte, password string,
+	salt []byte, iterations int,
+) ([]byte, error) {
+	return decryptJavaKeyEncryption2(ciphertext, []byte(password), salt,
+		iterations)
+}
+
+// decryptJavaKeyEncryption2 implements DecryptJavaKeyEncryption2 for a
+// password held in a byte slice.
+func decryptJavaKeyEncryption2(ciphertext, password, salt []byte,
+	iterations int,
+) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%des.BlockSize != 0 {
+		return nil, newError(CodeMalformed, "ciphertext for "+
+			"encryption type 2 is not a whole number of blocks")
+	}
+	key, iv, err := javaKeyEncryption2Key(password, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(iv)
+	defer clear(key)
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, errorf(CodeCryptoFailure, "%v", err)
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return pkcs7Unpad(plaintext, des.BlockSize)
+}
+
+// javaKeyEncryption2Key derives the Triple DES key and IV for
+// PBEWithMD5AndTripleDES, following com.sun.crypto.provider.PBES1Core. Each
+// half of the salt is hashed repeatedly with the password, giving 16 bytes of
+// key material per half; the first 24 bytes are the key and the last 8 the
+// IV. As in the JDK, the password must be printable ASCII.
+func javaKeyEncryption2Key(passwd, salt []byte, iterations int,
+) (key, iv []byte, err error) {
+	if len(salt) != 8 {
+		return nil, nil, errorf(CodeInvalidArgument, "salt length %d "+
+			"for encryption type 2 (expected 8)", len(salt))
+	}
+	if iterations < 1 || iterations > pbeMaxIterations {
+		return nil, nil, errorf(CodeInvalidArgument, "iteration count "+
+			"%d for encryption type 2 out of range", iterations)
+	}
+	for _, c := range passwd {
+		if c < 0x20 || c > 0x7E {
+			return nil, nil, newError(CodeInvalidArgument,
+				"password for encryption type 2 is not "+
+					"printable ASCII")
+		}
+	}
+
+	// if the two halves of the salt are the same, the JDK means to
+	// reverse the first, but a typo (salt[3-1] for salt[3-i]) makes it
+	// rotate the first three bytes instead
+	s := append([]byte(nil), salt...)
+	if bytes.Equal(s[:4], s[4:]) {
+		s[0], s[1], s[2] = s[3], s[0], s[1]
+	}
+
+	var out []byte
+	for half := 0; half < 2; half++ {
+		h := s[half*4 : half*4+4]
+		for i := 0; i < iterations; i++ {
+			md := md5.New()
+			md.Write(h)
+			md.Write(passwd)
+			h = md.Sum(nil)
+		}
+		out = append(out, h...)
+	}
+	return out[:24], out[24:], nil
+}
+
 // xorStreamForJavaKeyEncryption1 returns a stream of bytes that is XORed with
 // the plaintext to produce the ciphertext.  We iteratively use a SHA-1 hash
 // over (passwd+lastHash) to produce a stream of bytes we then XOR with the
@@ -1,36 +1,213 @@
 package jks
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
+	"errors"
 	"testing"
 )
 
-// TestOIDFromNamedCurve ensures that we return the correct OID identifying the
-// curve for ECDSA private keys.
-func TestOIDFromNamedCurve(t *testing.T) {
-	t.Run("P-224", testOIDFromNamedCurve(oidNamedCurveP224, elliptic.P224()))
-	t.Run("P-256", testOIDFromNamedCurve(oidNamedCurveP256, elliptic.P256()))
-	t.Run("P-384", testOIDFromNamedCurve(oidNamedCurveP384, elliptic.P384()))
-	t.Run("P-521", testOIDFromNamedCurve(oidNamedCurveP521, elliptic.P521()))
+// TestMarshalPKCS8 ensures that each supported type of private key marshals
+// to a PrivateKeyInfo that we can parse, with the correct algorithm OID and
+// (for ECDSA keys) the OID identifying the curve.
+func TestMarshalPKCS8(t *testing.T) {
+	t.Run("RSA", testMarshalPKCS8(oidPublicKeyRSA, nil, genRSA))
+	t.Run("P-224", testMarshalPKCS8(oidPublicKeyECDSA, oidNamedCurveP224,
+		genEC(elliptic.P224())))
+	t.Run("P-256", testMarshalPKCS8(oidPublicKeyECDSA, oidNamedCurveP256,
+		genEC(elliptic.P256())))
+	t.Run("P-384", testMarshalPKCS8(oidPublicKeyECDSA, oidNamedCurveP384,
+		genEC(elliptic.P384())))
+	t.Run("P-521", testMarshalPKCS8(oidPublicKeyECDSA, oidNamedCurveP521,
+		genEC(elliptic.P521())))
+	t.Run("Ed25519", testMarshalPKCS8(oidPublicKeyEd25519, nil, genEd25519))
+
+	if _, err := MarshalPKCS8("not a key"); err == nil {
+		t.Error("expected error marshalling unsupported key type")
+	}
 }
 
-func testOIDFromNamedCurve(exp asn1.ObjectIdentifier, curve elliptic.Curve,
+// TestEncryptPKCS8 checks that a key encrypted by EncryptPKCS8 uses PBES2 and
+// decrypts again with DecryptPKCS8, and that an empty password is refused.
+func TestEncryptPKCS8(t *testing.T) {
+	k, err := genEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := MarshalPKCS8(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := EncryptPKCS8(raw, "s3cret")
+	if err != nil {
+		t.Fatalf("EncryptPKCS8: %v", err)
+	}
+	keyInfo, err := ParseEncryptedPrivateKeyInfo(enc)
+	if err != nil {
+		t.Fatalf("ParseEncryptedPrivateKeyInfo: %v", err)
+	}
+	if !keyInfo.Algo.Algorithm.Equal(oidPBES2) {
+		t.Errorf("algorithm %v ≠ PBES2", keyInfo.Algo.Algorithm)
+	}
+	dec, err := DecryptPKCS8(enc, "s3cret")
+	switch {
+	case err != nil:
+		t.Errorf("DecryptPKCS8: %v", err)
+	case !bytes.Equal(dec, raw):
+		t.Errorf("decrypted key differs")
+	}
+
+	if _, err = EncryptPKCS8(raw, ""); ErrorCode(err) !=
+		CodeInvalidArgument {
+		t.Errorf("empty password: expected %s but got %v",
+			CodeInvalidArgument, err)
+	}
+}
+
+func genRSA() (interface{}, error) {
+	return rsa.GenerateKey(rand.Reader, 1024)
+}
+
+func genEC(curve elliptic.Curve) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	}
+}
+
+func genEd25519() (interface{}, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+func testMarshalPKCS8(expAlgo, expCurve asn1.ObjectIdentifier,
+	gen func() (interface{}, error),
 ) func(*testing.T) {
 	return func(t *testing.T) {
-		k, err := ecdsa.GenerateKey(curve, rand.Reader)
+		k, err := gen()
 		if err != nil {
 			t.Fatalf("failed to generate key: %v", err)
 		}
 
-		oid, err := oidFromNamedCurve(k)
+		raw, err := MarshalPKCS8(k)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		pki, err := ParsePrivateKeyInfo(raw)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if !pki.Algo.Algorithm.Equal(expAlgo) {
+			t.Errorf("algorithm %v ≠ expected %v",
+				pki.Algo.Algorithm, expAlgo)
+		}
+		if expCurve != nil {
+			var curve asn1.ObjectIdentifier
+			_, err := asn1.Unmarshal(pki.Algo.Parameters.FullBytes,
+				&curve)
+			switch {
+			case err != nil:
+				t.Errorf("could not parse curve OID: %v", err)
+			case !curve.Equal(expCurve):
+				t.Errorf("OID %v ≠ expected %v", curve,
+					expCurve)
+			}
+		}
+
+		out, err := x509.ParsePKCS8PrivateKey(raw)
 		switch {
 		case err != nil:
-			t.Errorf("could not find OID: %v", err)
-		case !oid.Equal(exp):
-			t.Errorf("OID %v ≠ expected %v", oid, exp)
+			t.Errorf("x509.ParsePKCS8PrivateKey: %v", err)
+		case !out.(interface{ Equal(crypto.PrivateKey) bool }).Equal(k):
+			t.Error("parsed key does not match original")
 		}
 	}
 }
+
+// TestEncryptedPrivateKeyInfo checks that our marshalling matches that of
+// encoding/asn1, that parsing round trips, and that malformed input is
+// reported with the correct offset.
+func TestEncryptedPrivateKeyInfo(t *testing.T) {
+	epki := EncryptedPrivateKeyInfo{
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm:  JavaKeyEncryptionOID1,
+			Parameters: asn1NULL,
+		},
+		EncryptedData: []byte("ciphertext"),
+	}
+	raw, err := epki.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	exp, err := asn1.Marshal(epki)
+	if err != nil {
+		t.Fatalf("asn1.Marshal: %v", err)
+	}
+	if !bytes.Equal(raw, exp) {
+		t.Errorf("marshalled ‘%X’ ≠ expected ‘%X’", raw, exp)
+	}
+
+	out, err := ParseEncryptedPrivateKeyInfo(raw)
+	switch {
+	case err != nil:
+		t.Fatalf("parse: %v", err)
+	case !out.Algo.Algorithm.Equal(epki.Algo.Algorithm),
+		!bytes.Equal(out.Algo.Parameters.FullBytes, asn1NULL.FullBytes),
+		!bytes.Equal(out.EncryptedData, epki.EncryptedData):
+		t.Errorf("parse result %+v ≠ expected %+v", out, epki)
+	}
+
+	// corrupt the OCTET STRING tag, which follows the 2-byte outer
+	// sequence header and the AlgorithmIdentifier
+	off := 2 + 2 + int(raw[3])
+	raw[off] = 0xFF
+	_, err = ParseEncryptedPrivateKeyInfo(raw)
+	var aerr *ASN1Error
+	switch {
+	case !errors.As(err, &aerr):
+		t.Errorf("expected *ASN1Error but got %v", err)
+	case aerr.Offset != off:
+		t.Errorf("error offset %d ≠ expected %d", aerr.Offset, off)
+	case !aerr.OID.Equal(JavaKeyEncryptionOID1):
+		t.Errorf("error OID %v ≠ expected %v",
+			aerr.OID, JavaKeyEncryptionOID1)
+	case !bytes.Contains(aerr.Snippet, []byte{0xFF}):
+		t.Errorf("error snippet ‘%X’ does not contain bad tag",
+			aerr.Snippet)
+	}
+}
+
+// TestPrivateKeyInfoErrorRedacted checks that errors from parsing a malformed
+// PrivateKeyInfo carry no bytes of the key.
+func TestPrivateKeyInfoErrorRedacted(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := MarshalPKCS8(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = append(raw, 0x00) // trailing data
+	_, err = ParsePrivateKeyInfo(raw)
+	var aerr *ASN1Error
+	switch {
+	case !errors.As(err, &aerr):
+		t.Fatalf("expected *ASN1Error but got %v", err)
+	case aerr.Offset != len(raw)-1:
+		t.Errorf("error offset %d ≠ expected %d", aerr.Offset,
+			len(raw)-1)
+	case len(aerr.Snippet) != 0 || aerr.Hexdump() != "":
+		t.Errorf("error snippet ‘%X’ holds key material",
+			aerr.Snippet)
+	case bytes.Contains([]byte(err.Error()), []byte("data from")):
+		t.Errorf("error message holds key material: %v", err)
+	}
+}
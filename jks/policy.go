@@ -0,0 +1,40 @@
+package jks
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"strings"
+)
+
+// checkKeyPolicy returns an error if key is weaker than the minimum sizes set
+// in opts, or uses a curve that is not in opts.AllowedCurves.
+func (opts *Options) checkKeyPolicy(key interface{}) error {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if bits := key.N.BitLen(); bits < opts.MinRSABits {
+			return errorf(CodeKeyPolicy, "RSA key size %d is "+
+				"below the minimum of %d bits", bits,
+				opts.MinRSABits)
+		}
+
+	case *ecdsa.PrivateKey:
+		params := key.Params()
+		if params.BitSize < opts.MinECBits {
+			return errorf(CodeKeyPolicy, "EC key size %d is below "+
+				"the minimum of %d bits", params.BitSize,
+				opts.MinECBits)
+		}
+		if len(opts.AllowedCurves) == 0 {
+			return nil
+		}
+		for _, name := range opts.AllowedCurves {
+			if name == params.Name {
+				return nil
+			}
+		}
+		return errorf(CodeKeyPolicy, "curve %s is not allowed "+
+			"(expected one of %s)", params.Name,
+			strings.Join(opts.AllowedCurves, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestKeyPolicy checks that Pack enforces minimum key sizes and the curve
+// allowlist.
+func TestKeyPolicy(t *testing.T) {
+	rsa := jkstest.New(t, "password").RSAKeypair("rsa", 2048)
+	p256 := jkstest.New(t, "password").ECKeypair("ec", elliptic.P256())
+
+	t.Run("no policy", testKeyPolicy(rsa, jks.Options{}, true))
+	t.Run("RSA at minimum", testKeyPolicy(rsa,
+		jks.Options{MinRSABits: 2048}, true))
+	t.Run("RSA below minimum", testKeyPolicy(rsa,
+		jks.Options{MinRSABits: 3072}, false))
+	t.Run("RSA ignores EC policy", testKeyPolicy(rsa,
+		jks.Options{MinECBits: 384, AllowedCurves: []string{"P-384"}},
+		true))
+	t.Run("EC at minimum", testKeyPolicy(p256,
+		jks.Options{MinECBits: 256}, true))
+	t.Run("EC below minimum", testKeyPolicy(p256,
+		jks.Options{MinECBits: 384}, false))
+	t.Run("curve allowed", testKeyPolicy(p256,
+		jks.Options{AllowedCurves: []string{"P-384", "P-256"}}, true))
+	t.Run("curve not allowed", testKeyPolicy(p256,
+		jks.Options{AllowedCurves: []string{"P-384"}}, false))
+}
+
+func testKeyPolicy(b *jkstest.Builder, policy jks.Options, ok bool,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		opts := b.Options()
+		opts.MinRSABits = policy.MinRSABits
+		opts.MinECBits = policy.MinECBits
+		opts.AllowedCurves = policy.AllowedCurves
+
+		_, err := b.Keystore().Pack(opts)
+		switch {
+		case ok && err != nil:
+			t.Errorf("unexpected error: %v", err)
+		case !ok && err == nil:
+			t.Error("expected error")
+		}
+	}
+}
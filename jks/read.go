@@ -6,161 +6,458 @@ package jks
 import (
 	"bytes"
 	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"runtime"
+	"sync"
 	"time"
 )
 
-// Parse a JKS file. If desired, opts may be specified to provide more control
-// over the parsing. If nil, then we will use an empty password when attempting
-// to decrypt keys and will not attempt to verify the digest stored in the file.
+// ErrDigestMismatch is returned by Parse if the keystore's integrity digest
+// does not match, because either the password is wrong or the file has been
+// tampered with. The error returned also matches ErrBadStorePassword unless
+// the password was shown to be right by decrypting a private key with it.
+var ErrDigestMismatch error = newSentinel(CodeDigestMismatch, "digest "+
+	"mismatch (wrong password, or keystore has been tampered with)")
+
+// digestMismatch returns the error for a file whose digest does not match. It
+// also matches ErrBadStorePassword unless proven is set, because some part of
+// the file was decrypted with the keystore password.
+func digestMismatch(proven bool) error {
+	if proven {
+		return newError(CodeDigestMismatch, "digest mismatch "+
+			"(keystore has been tampered with, although the "+
+			"password is right)")
+	}
+	return &Error{
+		Code: CodeDigestMismatch,
+		Msg:  ErrDigestMismatch.Error(),
+		Err:  ErrBadStorePassword,
+	}
+}
+
+// Parse a JKS or JCEKS file, of either version 1 or version 2. If desired,
+// opts may be specified to provide more control over the parsing; they are
+// checked first with Options.Validate. If nil, then we will use DefaultOptions
+// (an empty password when attempting to decrypt keys), but will not attempt to
+// verify the digest stored in the file.
 //
 // Errors encountered when parsing a certificate, or decrypting or parsing a
 // private key, are stored within the returned Keystore structure. These do not
 // lead to the parse failing and will not be returned as an error by the top
 // level function. Unrecoverable errors (i.e. malformed file) will result in the
 // Parse function returning an error. If digest verification is requested and
-// the password or the digest is incorrect, an error will also be returned. If
-// any useful data has been extracted it will be returned as a partial Keystore.
+// the password or the digest is incorrect, ErrDigestMismatch will be returned.
+// If any useful data has been extracted it will be returned as a partial
+// Keystore. See Options.CertsOnDigestMismatch for a safer way to recover the
+// trusted certificates from a keystore whose digest does not match.
+//
+// Secret key entries, which only JCEKS files hold, are unsealed with the
+// password for their alias (as for private keys) and stored in SecretKeys.
+// Options.CertsOnly and Options.Alias limit which entries are returned, so
+// that the others need not be decrypted.
 func Parse(raw []byte, opts *Options) (*Keystore, error) {
-	if opts == nil {
-		opts = &defaultOptions
+	ks, err := parse(&stream{
+		r:    bytes.NewReader(raw),
+		size: int64(len(raw)),
+	}, opts)
+	if ks != nil {
+		ks.ETag = ETag(raw)
 	}
+	return ks, err
+}
 
-	buf := bytes.NewReader(raw)
-	ks := new(Keystore)
+// ParseFrom reads a JKS or JCEKS file from r and parses it as per Parse, but
+// without first reading the whole file into memory: each record is decoded as
+// it arrives, and the digest (if opts asks for it to be verified) is computed
+// as the data goes past. The returned Keystore's ETag is set only if the whole
+// file was read.
+//
+// The one exception is a JCEKS secret key entry, whose length can only be
+// found by decoding it; up to Options.MaxKeySize bytes of the stream are read
+// into memory when one is met.
+func ParseFrom(r io.Reader, opts *Options) (*Keystore, error) {
+	etag := sha256.New()
+	ks, err := parse(&stream{
+		r:    io.TeeReader(r, etag),
+		size: -1,
+	}, opts)
+	if err == nil {
+		ks.ETag = hex.EncodeToString(etag.Sum(nil))
+	}
+	return ks, err
+}
 
-	// read file header
-	magic, _, err := readUint32(buf, "magic header")
-	if err != nil {
-		return nil, err
+// Verify checks the digest at the end of a JKS or JCEKS file against
+// password, without parsing or decrypting any of the entries. It is much
+// cheaper than Parse, so suits health checks and password validation. It
+// returns nil if the digest matches, or else an error which matches both
+// ErrDigestMismatch and ErrBadStorePassword (since no entry is decrypted, a
+// file whose contents have been tampered with cannot be told apart from a
+// wrong password). Files that are too short to hold a header and digest, or
+// which do not start with a JKS or JCEKS magic number, give an error with
+// CodeTruncated or CodeBadMagic.
+func Verify(raw []byte, password string) error {
+	return verifyDigest(raw, []byte(password), PasswordUTF16BE)
+}
+
+// FindPassword returns the index of the first of candidates that matches the
+// digest of a JKS or JCEKS file, as per Verify but with the passwords encoded
+// with enc. This helps with keystores whose password is known only to be "one
+// of these". If none matches, it returns an error matching
+// ErrBadStorePassword; errors in the file itself are returned as by Verify.
+func FindPassword(raw []byte, candidates []string, enc PasswordEncoding,
+) (int, error) {
+	for i, password := range candidates {
+		err := verifyDigest(raw, []byte(password), enc)
+		switch {
+		case err == nil:
+			return i, nil
+		case !errors.Is(err, ErrBadStorePassword):
+			return -1, err
+		}
 	}
-	if magic != MagicNumber {
-		return nil, fmt.Errorf("invalid magic; expected 0x%08X "+
-			"but got 0x%08X", MagicNumber, magic)
+	return -1, errorf(CodeDigestMismatch, "none of the %d candidate "+
+		"passwords matches the keystore digest: %v", len(candidates),
+		ErrBadStorePassword)
+}
+
+// verifyDigest implements Verify for a password encoded with enc.
+func verifyDigest(raw, password []byte, enc PasswordEncoding) error {
+	const header = 12 // magic, version and number of entries
+	if len(raw) < header+sha1.Size {
+		return errorf(CodeTruncated, "keystore of %d bytes is too "+
+			"short to hold a digest", len(raw))
+	}
+	magic := binary.BigEndian.Uint32(raw)
+	if magic != MagicNumber && magic != JCEKSMagicNumber {
+		return errorf(CodeBadMagic, "invalid magic; expected "+
+			"0x%08X or 0x%08X but got 0x%08X", MagicNumber,
+			JCEKSMagicNumber, magic)
 	}
 
-	version, _, err := readUint32(buf, "file version")
+	body, stored := raw[:len(raw)-sha1.Size], raw[len(raw)-sha1.Size:]
+	md := newDigest(password, enc)
+	defer md.wipe()
+	md.Write(body)
+	if !hmac.Equal(md.Sum(nil), stored) {
+		return digestMismatch(false)
+	}
+	return nil
+}
+
+// parse implements Parse and ParseFrom.
+func parse(buf *stream, opts *Options) (*Keystore, error) {
+	opts, err := opts.parseOptions()
 	if err != nil {
 		return nil, err
 	}
-	if version != 2 {
-		return nil, fmt.Errorf("found version %d file, but expected "+
-			"version 2", version)
+	defer opts.wipePasswords()
+	if !opts.SkipVerifyDigest {
+		md := newDigest(opts.password(), opts.PasswordEncoding)
+		defer md.wipe()
+		buf.md = md
 	}
+	ks := new(Keystore)
+	if opts.Trace {
+		// runs after keys.wait, once every key has been unlocked
+		defer ks.traceWarnings()
+	}
+	keys := newKeyUnlocker(opts)
+	defer keys.wait()
 
-	numEnts, _, err := readUint32(buf, "number of entries")
+	// read file header
+	header, pos, err := readHeader(buf)
 	if err != nil {
 		return nil, err
 	}
+	magic, version, numEnts := header.Magic, header.Version, header.Entries
+	if max := opts.maxEntryCount(); numEnts > max {
+		return nil, tooLarge("number of entries", pos, numEnts, max)
+	}
 
 	// read each entry in turn
 	for n := uint32(0); n < numEnts; n++ {
-		etype, pos, err := readUint32(buf, "entry type")
+		start := buf.off
+		etype, e, err := ks.readEntry(buf, opts, keys, magic, version,
+			n == numEnts-1)
+		if opts.Trace {
+			ks.Trace = append(ks.Trace, &EntryTrace{
+				Index:  int(n),
+				Offset: start,
+				Length: buf.off - start,
+				Tag:    etype,
+				entry:  e,
+				Err:    err,
+			})
+		}
 		if err != nil {
 			return ks, err
 		}
-		switch etype {
-		case 1:
-			// it's a private key + cert chain
-			kp, err := readKeypair(buf, opts)
-			if err != nil {
-				return ks, err
-			}
-			ks.Keypairs = append(ks.Keypairs, kp)
+	}
 
-		case 2:
-			// it's a certificate
-			cert, err := readCert(buf)
-			if err != nil {
-				return ks, err
-			}
-			ks.Certs = append(ks.Certs, cert)
+	// there should be exactly 20 bytes left
+	var digest []byte
+	if buf.md != nil {
+		digest = buf.md.Sum(nil)
+		buf.md = nil
+	}
+	stored, err := buf.read(20)
+	if err != nil {
+		return ks, eofError(err, "malformed digest at end of file")
+	}
+	if _, err = buf.read(1); err == nil {
+		return ks, newError(CodeMalformed, "malformed digest at end "+
+			"of file")
+	} else if err != errShortRead {
+		return ks, err
+	}
 
-		default:
-			return nil, fmt.Errorf("unrecognised entry type %d "+
-				"at file position %d", etype, pos)
+	if digest != nil && !hmac.Equal(digest, stored) {
+		ks.Integrity = IntegrityMismatch
+		if opts.CertsOnDigestMismatch {
+			ks.Keypairs = nil
+			ks.SecretKeys = nil
 		}
+		return ks, digestMismatch(ks.storePasswordProven(opts))
+	}
+	if digest != nil {
+		ks.Integrity = IntegrityVerified
 	}
+	return ks, nil
+}
 
-	switch {
-	// there should be exactly 20 bytes left
-	case buf.Len() != 20:
-		return ks, errors.New("malformed digest at end of file")
+// readEntry reads the next entry of a file with the given magic number and
+// version, adds it to ks, and returns its type tag and the entry. An entry of
+// an unrecognised type may be read only if it is the last, in which case it
+// is returned as nil, as is an entry skipped under Options.CertsOnly or
+// Options.Alias.
+func (ks *Keystore) readEntry(buf *stream, opts *Options, keys *keyUnlocker,
+	magic, version uint32, last bool,
+) (uint32, Entry, error) {
+	etype, pos, err := readUint32(buf, "entry type")
+	if err != nil {
+		return 0, nil, err
+	}
+	switch etype {
+	case 1:
+		// it's a private key + cert chain
+		kp, err := readKeypair(buf, opts, version)
+		if err != nil || opts.skipEntry(kp.Alias, true) {
+			return etype, nil, err
+		}
+		keys.add(kp)
+		ks.Keypairs = append(ks.Keypairs, kp)
+		ks.Order = append(ks.Order, kp.Alias)
+		return etype, kp, nil
+
+	case 2:
+		// it's a certificate
+		cert, err := readCert(buf, opts, version)
+		if err != nil || opts.skipEntry(cert.Alias, false) {
+			return etype, nil, err
+		}
+		ks.Certs = append(ks.Certs, cert)
+		ks.Order = append(ks.Order, cert.Alias)
+		return etype, cert, nil
+
+	case 3:
+		if magic != JCEKSMagicNumber {
+			return etype, nil, errorf(CodeMalformed, "secret key "+
+				"entry at file position %d in JKS file", pos)
+		}
+		sk, err := readSecretKey(buf, opts)
+		if err != nil || opts.skipEntry(sk.Alias, true) {
+			return etype, nil, err
+		}
+		ks.SecretKeys = append(ks.SecretKeys, sk)
+		ks.Order = append(ks.Order, sk.Alias)
+		return etype, sk, nil
+	}
+
+	if !last {
+		return etype, nil, errorf(CodeMalformed, "unrecognised entry "+
+			"type %d at file position %d", etype, pos)
+	}
+	raw, err := readUnknownEntry(buf, opts, etype, pos)
+	if err != nil || opts.filtered() {
+		return etype, nil, err
+	}
+	ks.UnknownEntries = append(ks.UnknownEntries,
+		&UnknownEntry{Tag: etype, Raw: raw})
+	return etype, nil, nil
+}
+
+// storePasswordProven reports whether any private key in ks decrypts with the
+// keystore password, which shows the password to be right: the JKS key
+// protection algorithm checks the password, and the result must also parse.
+func (ks *Keystore) storePasswordProven(opts *Options) bool {
+	for _, kp := range ks.Keypairs {
+		raw, err := decryptPKCS8(kp.EncryptedKey, opts.password(),
+			opts.PasswordEncoding)
+		if err != nil {
+			continue
+		}
+		_, err = ParsePrivateKeyInfo(raw)
+		clear(raw)
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// stream is the input to the record readers: either a whole file held in
+// memory, or an io.Reader whose length is not known.
+type stream struct {
+	r io.Reader
+
+	// off is the position in the file of the next byte to be consumed.
+	off int64
+
+	// size is the length of the file, or -1 if not known.
+	size int64
+
+	// pending holds data read ahead by readAhead, but not yet consumed.
+	pending []byte
+
+	// md, if not nil, hashes the data as it is consumed.
+	md hash.Hash
+}
+
+// errShortRead is returned by stream.read if the data runs out. Callers
+// replace it with a CodeTruncated error describing what they were reading.
+var errShortRead = errors.New("short read")
+
+// streamChunk is the largest read that stream.read allocates for up front.
+// Longer reads grow their buffer as the data arrives, so that a corrupt length
+// field cannot make us allocate more memory than the data that is present.
+const streamChunk = 64 * 1024
 
-	case opts.SkipVerifyDigest:
-		return ks, nil
+// read consumes the next n bytes.
+func (s *stream) read(n int64) ([]byte, error) {
+	if s.size >= 0 && n > s.size-s.off {
+		return nil, errShortRead
+	}
 
+	var (
+		data []byte
+		err  error
+	)
+	if n <= int64(len(s.pending)) {
+		data = s.pending[:n:n]
+		s.pending = s.pending[n:]
+	} else if n <= streamChunk {
+		data = make([]byte, n)
+		k := copy(data, s.pending)
+		s.pending = nil
+		_, err = io.ReadFull(s.r, data[k:])
+	} else {
+		b := bytes.NewBuffer(s.pending)
+		s.pending = nil
+		_, err = io.CopyN(b, s.r, n-int64(b.Len()))
+		data = b.Bytes()
+	}
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		return nil, errShortRead
 	default:
-		digest := ComputeDigest(raw[:len(raw)-20], opts.Password)
-		if !hmac.Equal(digest, raw[len(raw)-20:]) {
-			return ks, errors.New("digest mismatch")
+		return nil, err
+	}
+
+	s.off += n
+	if s.md != nil {
+		s.md.Write(data)
+	}
+	return data, nil
+}
+
+// readAhead returns up to n bytes of the data that follows, fewer only if the
+// data runs out, without consuming them.
+func (s *stream) readAhead(n int64) ([]byte, error) {
+	if want := n - int64(len(s.pending)); want > 0 {
+		rest, err := io.ReadAll(io.LimitReader(s.r, want))
+		s.pending = append(s.pending, rest...)
+		if err != nil {
+			return nil, err
 		}
-		return ks, nil
 	}
+	return s.pending[:min(n, int64(len(s.pending)))], nil
 }
 
-func readUint32(buf *bytes.Reader, desc string,
-) (value uint32, offset int64, err error) {
-	offset, _ = buf.Seek(0, io.SeekCurrent)
-	if buf.Len() < 4 {
-		return 0, offset, fmt.Errorf("unexpected EOF at position %d "+
-			"while reading %s", offset, desc)
+// eofError returns err, unless it is errShortRead, in which case it returns a
+// CodeTruncated error with the given message.
+func eofError(err error, format string, args ...interface{}) error {
+	if err == errShortRead {
+		return errorf(CodeTruncated, format, args...)
 	}
+	return err
+}
 
-	var raw [4]byte
-	_, _ = buf.Read(raw[:])
-	return binary.BigEndian.Uint32(raw[:]), offset, nil
+func readUint32(buf *stream, desc string,
+) (value uint32, offset int64, err error) {
+	offset = buf.off
+	raw, err := buf.read(4)
+	if err != nil {
+		return 0, offset, eofError(err, "unexpected EOF at "+
+			"position %d while reading %s", offset, desc)
+	}
+	return binary.BigEndian.Uint32(raw), offset, nil
 }
 
-func readUint64(buf *bytes.Reader, desc string,
+func readUint64(buf *stream, desc string,
 ) (value uint64, offset int64, err error) {
-	offset, _ = buf.Seek(0, io.SeekCurrent)
-	if buf.Len() < 8 {
-		return 0, offset, fmt.Errorf("unexpected EOF at position %d "+
-			"while reading %s", offset, desc)
+	offset = buf.off
+	raw, err := buf.read(8)
+	if err != nil {
+		return 0, offset, eofError(err, "unexpected EOF at "+
+			"position %d while reading %s", offset, desc)
 	}
-
-	var raw [8]byte
-	_, _ = buf.Read(raw[:])
-	return binary.BigEndian.Uint64(raw[:]), offset, nil
+	return binary.BigEndian.Uint64(raw), offset, nil
 }
 
-func readTimestamp(buf *bytes.Reader) (ts time.Time, offset int64, err error) {
+func readTimestamp(buf *stream) (ts time.Time, offset int64, err error) {
 	ums, offset, err := readUint64(buf, "timestamp")
 	if err != nil {
 		return time.Time{}, offset, err
 	}
-	ms := int64(ums)
-	return time.Unix(ms/1000, (ms%1000)*1e6), offset, nil
+	return time.UnixMilli(int64(ums)), offset, nil
 }
 
-func readStr(buf *bytes.Reader, desc string,
+func readStr(buf *stream, desc string,
 ) (value string, offset int64, err error) {
-	offset, _ = buf.Seek(0, io.SeekCurrent)
-	if buf.Len() < 2 {
-		return "", offset, fmt.Errorf("unexpected EOF at position %d "+
-			"while reading %s", offset, desc)
-	}
-
-	var raw [2]byte
-	_, _ = buf.Read(raw[:])
-	strlen := binary.BigEndian.Uint16(raw[:])
-	if buf.Len() < 2 {
-		return "", offset, fmt.Errorf("unexpected EOF at position %d "+
-			"while reading %s (stored length %d)",
+	offset = buf.off
+	raw, err := buf.read(2)
+	if err != nil {
+		return "", offset, eofError(err, "unexpected EOF at "+
+			"position %d while reading %s", offset, desc)
+	}
+	strlen := binary.BigEndian.Uint16(raw)
+	str, err := buf.read(int64(strlen))
+	if err != nil {
+		return "", offset, eofError(err, "unexpected EOF at "+
+			"position %d while reading %s (stored length %d)",
 			offset, desc, strlen)
 	}
-
-	str := make([]byte, strlen)
-	_, _ = buf.Read(str)
-	return string(str), offset, nil
+	value, ok := decodeModifiedUTF8(str)
+	if !ok {
+		return "", offset, errorf(CodeMalformed, "invalid modified "+
+			"UTF-8 at position %d in %s", offset, desc)
+	}
+	return value, offset, nil
 }
 
-func readCert(buf *bytes.Reader) (*Cert, error) {
+// readCert reads a trusted certificate record. Version 1 files do not record
+// the certificate type, which is always X.509.
+func readCert(buf *stream, opts *Options, version uint32) (*Cert, error) {
 	var (
 		offset int64
 		err    error
@@ -177,32 +474,79 @@ func readCert(buf *bytes.Reader) (*Cert, error) {
 		return nil, err
 	}
 
-	certType, _, err := readStr(buf, "certificate type")
-	if certType != CertType {
-		return nil, fmt.Errorf("unexpected certificate type at "+
-			"position %d; found %q, expected %q",
-			offset, certType, CertType)
+	if version != 1 {
+		certType, _, err := readStr(buf, "certificate type")
+		if err != nil {
+			return nil, err
+		}
+		if certType != CertType {
+			cert.Type = certType
+		}
 	}
 
-	elen, _, err := readUint32(buf, "encoded certificate length")
+	elen, pos, err := readUint32(buf, "encoded certificate length")
 	if err != nil {
 		return nil, err
 	}
+	if max := opts.maxCertSize(); elen > max {
+		return nil, tooLarge(fmt.Sprintf("length of certificate %q",
+			cert.Alias), pos, elen, max)
+	}
 
-	if buf.Len() < int(elen) {
-		return nil, fmt.Errorf("not enough data to read "+
+	if cert.Raw, err = buf.read(int64(elen)); err != nil {
+		return nil, eofError(err, "not enough data to read "+
 			"certificate %q at position %d (length %d bytes)",
 			cert.Alias, offset, elen)
 	}
 
-	cert.Raw = make([]byte, elen)
-	_, _ = buf.Read(cert.Raw)
-
-	cert.Cert, cert.CertErr = x509.ParseCertificate(cert.Raw)
+	if !opts.skipEntry(cert.Alias, false) {
+		cert.Cert, cert.CertErr = parseCert(cert.Raw, cert.Type)
+	}
 	return cert, nil
 }
 
-func readKeypair(buf *bytes.Reader, opts *Options) (*Keypair, error) {
+// readUnknownEntry reads the data of the final entry in the file, whose type
+// tag etype was read at position pos, up to the digest. Its size is limited to
+// opts.MaxKeySize, since we cannot tell whether it holds key material.
+func readUnknownEntry(buf *stream, opts *Options, etype uint32, pos int64,
+) ([]byte, error) {
+	max := opts.maxKeySize()
+	rest, err := buf.readAhead(int64(max) + sha1.Size + 1)
+	if err != nil {
+		return nil, err
+	}
+	n := len(rest) - sha1.Size
+	switch {
+	case n > int(max):
+		return nil, errorf(CodeTooLarge, "unrecognised entry type %d "+
+			"at file position %d is more than %d bytes long", etype,
+			pos, max)
+	case n < 0:
+		return nil, errorf(CodeTruncated, "unexpected EOF at position "+
+			"%d while reading unrecognised entry type %d", buf.off,
+			etype)
+	}
+	raw, err := buf.read(int64(n))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(raw), nil
+}
+
+// parseCert parses a certificate read from a keystore. Certificates of a type
+// other than X.509 are not parsed.
+func parseCert(der []byte, typ string) (*x509.Certificate, error) {
+	if typ != "" && typ != CertType {
+		return nil, errorf(CodeUnsupported, "certificate type %q is "+
+			"not supported", typ)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// readKeypair reads a private key record and its certificate chain. As with
+// readCert, version 1 files do not record the type of each certificate.
+func readKeypair(buf *stream, opts *Options, version uint32,
+) (*Keypair, error) {
 	var (
 		offset   int64
 		err      error
@@ -210,83 +554,244 @@ func readKeypair(buf *bytes.Reader, opts *Options) (*Keypair, error) {
 		kp       = new(Keypair)
 	)
 
-	// retrive the key's alias, and use this to search for a password
 	kp.Alias, offset, err = readStr(buf, "certificate alias")
 	if err != nil {
 		return nil, err
 	}
-	passwd, ok := opts.KeyPasswords[kp.Alias]
-	if !ok {
-		// no specific password for this alias, so use the file password
-		passwd = opts.Password
-	}
+	skip := opts.skipEntry(kp.Alias, true)
 
 	kp.Timestamp, _, err = readTimestamp(buf)
 	if err != nil {
 		return nil, err
 	}
 
-	elen, _, err := readUint32(buf, "encrypted private key length")
+	elen, pos, err := readUint32(buf, "encrypted private key length")
 	if err != nil {
 		return nil, err
 	}
+	if max := opts.maxKeySize(); elen > max {
+		return nil, tooLarge(fmt.Sprintf("length of private key %q",
+			kp.Alias), pos, elen, max)
+	}
 
-	if buf.Len() < int(elen) {
-		return nil, fmt.Errorf("not enough data to read "+
+	if kp.EncryptedKey, err = buf.read(int64(elen)); err != nil {
+		return nil, eofError(err, "not enough data to read "+
 			"private key %q at position %d (length %d bytes)",
 			kp.Alias, offset, elen)
 	}
 
-	kp.EncryptedKey = make([]byte, elen)
-	_, _ = buf.Read(kp.EncryptedKey)
-	kp.RawKey, kp.PrivKeyErr = DecryptPKCS8(kp.EncryptedKey, passwd)
-	if kp.PrivKeyErr == nil {
-		// we should now have a PKCS#8 PrivateKeyInfo, which Go can
-		// parse for us
-		kp.PrivateKey, kp.PrivKeyErr = x509.ParsePKCS8PrivateKey(
-			kp.RawKey)
-	}
-
-	ncerts, _, err := readUint32(buf, "length of certificate chain")
+	ncerts, pos, err := readUint32(buf, "length of certificate chain")
 	if err != nil {
 		return nil, err
 	}
+	if max := opts.maxEntryCount(); ncerts > max {
+		return nil, tooLarge(fmt.Sprintf("certificate chain length "+
+			"for %q", kp.Alias), pos, ncerts, max)
+	}
 
 	for n := uint32(0); n < ncerts; n++ {
-		certType, offset, err = readStr(buf, fmt.Sprintf(
-			"certificate type (chain entry #%d for %q)",
-			n+1, kp.Alias))
-		if err != nil {
-			return nil, err
-		}
-		if certType != CertType {
-			return nil, fmt.Errorf("unexpected certificate type "+
-				"%q (expected %q at position %d for chain "+
-				"entry #%d for %q)",
-				certType, CertType, offset, n+1, kp.Alias)
+		kpc := new(KeypairCert)
+		if version == 1 {
+			offset = buf.off
+		} else {
+			certType, offset, err = readStr(buf, fmt.Sprintf(
+				"certificate type (chain entry #%d for %q)",
+				n+1, kp.Alias))
+			if err != nil {
+				return nil, err
+			}
+			if certType != CertType {
+				kpc.Type = certType
+			}
 		}
 
-		elen, _, err = readUint32(buf, fmt.Sprintf(
+		elen, pos, err = readUint32(buf, fmt.Sprintf(
 			"encoded certificate length (chain entry #%d for %q)",
 			n+1, kp.Alias))
 		if err != nil {
 			return nil, err
 		}
-
-		if buf.Len() < int(elen) {
-			return nil, fmt.Errorf("not enough data to read "+
-				"certificate chain entry #%d for %q at "+
-				"position %d (length %d bytes)",
-				n+1, kp.Alias, offset, elen)
+		if max := opts.maxCertSize(); elen > max {
+			return nil, tooLarge(fmt.Sprintf("length of "+
+				"certificate chain entry #%d for %q", n+1,
+				kp.Alias), pos, elen, max)
 		}
 
-		kpc := new(KeypairCert)
-		kpc.Raw = make([]byte, elen)
-		_, _ = buf.Read(kpc.Raw)
-		kpc.Cert, kpc.CertErr = x509.ParseCertificate(kpc.Raw)
+		if kpc.Raw, err = buf.read(int64(elen)); err != nil {
+			return nil, eofError(err, "not enough data to "+
+				"read certificate chain entry #%d for %q at "+
+				"position %d (length %d bytes)", n+1, kp.Alias,
+				offset, elen)
+		}
+		if !skip {
+			kpc.Cert, kpc.CertErr = parseCert(kpc.Raw, kpc.Type)
+		}
 
 		kp.CertChain = append(kp.CertChain, kpc)
 	}
 
 	return kp, nil
 }
+
+// ErrKeyNotDecrypted is the PrivKeyErr of each keypair read with
+// Options.SkipKeyDecryption set, or given a new password by
+// Keypair.ChangePassword, until Keypair.Decrypt is called.
+var ErrKeyNotDecrypted error = newError(CodeKeyNotDecrypted, "private key "+
+	"not decrypted")
+
+// Decrypt decrypts EncryptedKey with password, and sets RawKey, PrivateKey and
+// KeyAlgorithm (or PrivKeyErr, if the decrypted key cannot be parsed). It is
+// used to unlock a keypair read with Options.SkipKeyDecryption, or to retry
+// one whose password was wrong. If the password is wrong, an error with
+// CodeBadKeyPassword is returned and kp is not changed. The password is
+// encoded as Java does (see PasswordUTF16BE).
+func (kp *Keypair) Decrypt(password string) error {
+	if len(kp.EncryptedKey) == 0 {
+		return errorf(CodeMissingData, "key %q has no encrypted key",
+			kp.Alias)
+	}
+	raw, err := decryptPKCS8(kp.EncryptedKey, []byte(password),
+		PasswordUTF16BE)
+	if err != nil {
+		return errorf("", "key %q: %v", kp.Alias, err)
+	}
+	kp.RawKey, kp.PrivKeyErr = raw, nil
+	kp.PrivateKey, kp.KeyAlgorithm = nil, nil
+	kp.parseRawKey()
+	return kp.PrivKeyErr
+}
+
+// ChangePassword re-encrypts the keypair's private key under password new, as
+// "keytool -keypasswd" does. EncryptedKey is first decrypted with old, and an
+// error with CodeBadKeyPassword is returned if that is wrong. The key is then
+// held only as the new EncryptedKey, as if read with
+// Options.SkipKeyDecryption, so that Pack writes it out unchanged whatever the
+// key passwords in its options; Decrypt with new recovers it. A key protected
+// with PBES2 stays so, and others are re-encrypted with JavaKeyEncryption1.
+func (kp *Keypair) ChangePassword(old, new string) error {
+	if err := kp.Decrypt(old); err != nil {
+		return err
+	}
+	opts := DefaultOptions()
+	keyInfo, err := ParseEncryptedPrivateKeyInfo(kp.EncryptedKey)
+	if err == nil && keyInfo.Algo.Algorithm.Equal(oidPBES2) {
+		opts.KeyProtection = KeyProtectionPBES2
+	}
+	raw, err := protectJKSKey(kp.Alias, kp.RawKey, []byte(new), opts)
+	if err != nil {
+		return err
+	}
+	clear(kp.RawKey)
+	kp.EncryptedKey, kp.RawKey = raw, nil
+	kp.PrivateKey, kp.PrivKeyErr = nil, ErrKeyNotDecrypted
+	return nil
+}
+
+// unlock decrypts EncryptedKey with the password for its alias as Parse does,
+// unless opts.SkipKeyDecryption is set. If opts.Wipe is set, RawKey is zeroed
+// and discarded once PrivateKey has been unmarshalled from it.
+func (kp *Keypair) unlock(opts *Options) {
+	if passwd, ok := kp.unlockPassword(opts); ok {
+		kp.unlockWith(passwd, opts)
+	}
+}
+
+// unlockPassword returns the password with which unlock decrypts the key, or
+// false, having set PrivKeyErr, if the key is not to be decrypted.
+func (kp *Keypair) unlockPassword(opts *Options) ([]byte, bool) {
+	if opts.SkipKeyDecryption {
+		kp.PrivKeyErr = ErrKeyNotDecrypted
+		return nil, false
+	}
+	passwd, err := opts.keyPassword(kp.Alias)
+	if err != nil {
+		kp.PrivKeyErr = err
+		return nil, false
+	}
+	return passwd, true
+}
+
+// unlockWith decrypts EncryptedKey with passwd, as unlock does. It only reads
+// opts, and changes nothing but kp, so keys may be unlocked concurrently.
+func (kp *Keypair) unlockWith(passwd []byte, opts *Options) {
+	kp.RawKey, kp.PrivKeyErr = decryptPKCS8(kp.EncryptedKey, passwd,
+		opts.PasswordEncoding)
+	if kp.PrivKeyErr == nil {
+		kp.parseRawKey()
+	}
+	if opts.Wipe && kp.PrivateKey != nil {
+		clear(kp.RawKey)
+		kp.RawKey = nil
+	}
+}
+
+// keyUnlocker unlocks the keypairs read by Parse and ParsePKCS12: each as it is
+// added or, if opts.Parallelism allows, all together on several goroutines
+// when wait is called.
+type keyUnlocker struct {
+	opts    *Options
+	workers int
+	keys    []*Keypair
+	passwds [][]byte
+}
+
+func newKeyUnlocker(opts *Options) *keyUnlocker {
+	workers := opts.Parallelism
+	if workers < 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &keyUnlocker{opts: opts, workers: workers}
+}
+
+// add unlocks kp, or fetches its password and queues it to be unlocked by
+// wait.
+func (u *keyUnlocker) add(kp *Keypair) {
+	if u.workers <= 1 {
+		kp.unlock(u.opts)
+		return
+	}
+	if passwd, ok := kp.unlockPassword(u.opts); ok {
+		u.keys = append(u.keys, kp)
+		u.passwds = append(u.passwds, passwd)
+	}
+}
+
+// wait unlocks the queued keypairs and returns once all are done.
+func (u *keyUnlocker) wait() {
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(u.workers, len(u.keys)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				u.keys[i].unlockWith(u.passwds[i], u.opts)
+			}
+		}()
+	}
+	for i := range u.keys {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	u.keys, u.passwds = nil, nil
+}
+
+// parseRawKey sets PrivateKey and KeyAlgorithm from RawKey, or PrivKeyErr if
+// it cannot be parsed.
+func (kp *Keypair) parseRawKey() {
+	// we should now have a PKCS#8 PrivateKeyInfo; check its structure
+	// ourselves for better error reporting, then let Go parse the key for
+	// us
+	pki, err := ParsePrivateKeyInfo(kp.RawKey)
+	if err != nil {
+		kp.PrivKeyErr = err
+		return
+	}
+	kp.KeyAlgorithm = pki.Algo.Algorithm
+	kp.PrivateKey, err = parsePrivateKey(pki, kp.RawKey)
+	if err != nil {
+		kp.PrivKeyErr = errorf(CodeUnsupportedKeyAlg, "private key "+
+			"algorithm %v: %v", pki.Algo.Algorithm, err)
+	}
+}
@@ -0,0 +1,157 @@
+package jks
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSReloader serves the keypairs of a keystore file to crypto/tls, and picks
+// up a new keystore when the file is replaced, as happens when a mounted
+// Kubernetes secret is rotated. Its GetCertificate and GetClientCertificate
+// methods are meant to be plugged into a tls.Config:
+//
+//	r, err := jks.NewTLSReloader("/etc/tls/keystore.jks", "", opts)
+//	if err != nil {
+//		return err
+//	}
+//	go r.Run(ctx, time.Minute)
+//	srv.TLSConfig = &tls.Config{GetCertificate: r.GetCertificate}
+//
+// The file is checked by polling its modification time, size and identity,
+// which follows symlinks and so sees Kubernetes' atomic symlink swap, and
+// then its content. If a new keystore cannot be read or converted, for
+// instance because it is only partly written, the previous certificates are
+// kept and the error is passed to opts.Warn.
+type TLSReloader struct {
+	path  string
+	alias string
+	opts  *Options
+
+	mu    sync.RWMutex
+	fi    os.FileInfo
+	etag  string
+	certs []tls.Certificate
+}
+
+// NewTLSReloader reads the keystore at path, in any format that ParseAny
+// understands, using opts as for ParseAny. If alias is empty, every keypair is
+// served; otherwise only the keypair with that alias. It is an error if the
+// initial keystore cannot be read or holds no usable keypair.
+func NewTLSReloader(path, alias string, opts *Options,
+) (*TLSReloader, error) {
+	r := &TLSReloader{
+		path:  path,
+		alias: alias,
+		opts:  opts,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload rereads the keystore if the file has changed since it was last
+// read. On error, the previous certificates are kept.
+func (r *TLSReloader) Reload() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	prev, etag := r.fi, r.etag
+	r.mu.RUnlock()
+	if prev != nil && os.SameFile(prev, fi) &&
+		prev.ModTime().Equal(fi.ModTime()) && prev.Size() == fi.Size() {
+		return nil
+	}
+
+	raw, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	tag := ETag(raw)
+	if tag == etag {
+		r.mu.Lock()
+		r.fi = fi
+		r.mu.Unlock()
+		return nil
+	}
+
+	ks, _, err := ParseAny(raw, r.opts)
+	if err != nil {
+		return errorf("", "%s: %v", r.path, err)
+	}
+	var certs []tls.Certificate
+	if r.alias == "" {
+		certs, err = ks.TLSCertificates()
+	} else {
+		var cert tls.Certificate
+		cert, err = ks.TLSCertificate(r.alias)
+		certs = []tls.Certificate{cert}
+	}
+	switch {
+	case err != nil:
+		return errorf("", "%s: %v", r.path, err)
+	case len(certs) == 0:
+		return errorf(CodeMissingData, "%s: no keypairs", r.path)
+	}
+
+	r.mu.Lock()
+	r.fi, r.etag, r.certs = fi, tag, certs
+	r.mu.Unlock()
+	return nil
+}
+
+// Run calls Reload every interval until ctx is cancelled, passing any errors
+// to opts.Warn.
+func (r *TLSReloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				r.opts.warn(err)
+			}
+		}
+	}
+}
+
+// Certificates returns the certificates currently being served.
+func (r *TLSReloader) Certificates() []tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certs
+}
+
+// GetCertificate is for use as tls.Config.GetCertificate. It returns the first
+// keypair that the client supports, or the first keypair if none is.
+func (r *TLSReloader) GetCertificate(hello *tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	certs := r.Certificates()
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
+
+// GetClientCertificate is for use as tls.Config.GetClientCertificate. It
+// returns the first keypair acceptable to the server, or the first keypair if
+// none is.
+func (r *TLSReloader) GetClientCertificate(cri *tls.CertificateRequestInfo,
+) (*tls.Certificate, error) {
+	certs := r.Certificates()
+	for i := range certs {
+		if cri.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
@@ -0,0 +1,87 @@
+package jks_test
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestTLSReloader checks that a TLSReloader serves the keystore's keypair,
+// picks up a replacement file, and keeps the old keypair if the replacement
+// cannot be read.
+func TestTLSReloader(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "keystore.jks")
+	opts := &jks.Options{Password: "password"}
+	write := func(raw []byte, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(fn, raw, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fn, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keystore := func(cn string) []byte {
+		return jkstest.New(t, "password").
+			ECKeypair(cn, elliptic.P256()).Bytes()
+	}
+	serving := func(r *jks.TLSReloader) string {
+		t.Helper()
+		cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("GetCertificate: %v", err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+
+	now := time.Now()
+	write(keystore("first"), now.Add(-time.Hour))
+	r, err := jks.NewTLSReloader(fn, "", opts)
+	if err != nil {
+		t.Fatalf("NewTLSReloader: %v", err)
+	}
+	if cn := serving(r); cn != "first" {
+		t.Errorf("serving %q ≠ expected \"first\"", cn)
+	}
+
+	write(keystore("second"), now)
+	if err = r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if cn := serving(r); cn != "second" {
+		t.Errorf("serving %q ≠ expected \"second\"", cn)
+	}
+
+	write([]byte("partial"), now.Add(time.Hour))
+	if err = r.Reload(); err == nil {
+		t.Errorf("Reload: expected error for corrupt keystore")
+	}
+	if cn := serving(r); cn != "second" {
+		t.Errorf("after failed reload: serving %q ≠ expected "+
+			"\"second\"", cn)
+	}
+
+	// Run should pick up the next good keystore by itself
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, 10*time.Millisecond)
+	write(keystore("third"), now.Add(2*time.Hour))
+	deadline := time.Now().Add(5 * time.Second)
+	for serving(r) != "third" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Run did not reload the keystore")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err = jks.NewTLSReloader(fn, "missing", opts); err == nil {
+		t.Errorf("NewTLSReloader: expected error for missing alias")
+	}
+}
@@ -0,0 +1,1265 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestRoundTrip packs a keystore holding each supported type of entry and
+// checks that parsing it gives back the same content.
+func TestRoundTrip(t *testing.T) {
+	b := jkstest.New(t, "store password").
+		CA("root").
+		RSAKeypair("rsa", 2048).
+		ECKeypair("ec", elliptic.P384()).
+		KeyPassword("ec", "key password")
+	orig := b.Keystore()
+
+	ks, err := jks.Parse(b.Bytes(), b.Options())
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	if len(ks.Certs) != 1 || ks.Certs[0].Alias != "root" ||
+		!ks.Certs[0].Cert.Equal(orig.Certs[0].Cert) {
+		t.Errorf("certificate did not round trip")
+	}
+
+	if len(ks.Keypairs) != len(orig.Keypairs) {
+		t.Fatalf("got %d keypairs, expected %d",
+			len(ks.Keypairs), len(orig.Keypairs))
+	}
+	for i, kp := range ks.Keypairs {
+		exp := orig.Keypairs[i]
+		if kp.Alias != exp.Alias {
+			t.Errorf("keypair %d: alias %q ≠ expected %q",
+				i, kp.Alias, exp.Alias)
+		}
+		if kp.PrivKeyErr != nil {
+			t.Errorf("keypair %q: %v", kp.Alias, kp.PrivKeyErr)
+			continue
+		}
+		type equaler interface {
+			Equal(x crypto.PrivateKey) bool
+		}
+		if !kp.PrivateKey.(equaler).Equal(exp.PrivateKey) {
+			t.Errorf("keypair %q: private key mismatch", kp.Alias)
+		}
+		if len(kp.CertChain) != 1 ||
+			!kp.CertChain[0].Cert.Equal(exp.CertChain[0].Cert) {
+			t.Errorf("keypair %q: chain mismatch", kp.Alias)
+		}
+	}
+}
+
+// TestUnicodeAlias checks that aliases outside ASCII, including characters
+// that need UTF-16 surrogate pairs, survive a round trip.
+func TestUnicodeAlias(t *testing.T) {
+	const alias = "ключ \U0001F511"
+	b := jkstest.New(t, "password").ECKeypair("key", elliptic.P256())
+	ks := b.Keystore()
+	ks.Keypairs[0].Alias = alias
+	raw, err := ks.Pack(b.Options())
+	if err != nil {
+		t.Fatalf("failed to pack: %v", err)
+	}
+	ks, err = jks.Parse(raw, b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("failed to parse: %v", err)
+	case ks.Keypairs[0].Alias != alias:
+		t.Errorf("alias %q ≠ expected %q", ks.Keypairs[0].Alias, alias)
+	}
+}
+
+// TestVersion1 packs a version 1 keystore, which lacks the certificate type
+// strings, and checks that it parses back to the same content.
+func TestVersion1(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").RSAKeypair("server", 2048)
+	opts := b.Options()
+	opts.Version = 1
+	raw, err := b.Keystore().Pack(opts)
+	switch {
+	case err != nil:
+		t.Fatalf("Pack: %v", err)
+	case raw[7] != 1:
+		t.Errorf("file version %d ≠ expected 1", raw[7])
+	case bytes.Contains(raw, []byte(jks.CertType)):
+		t.Errorf("version 1 file contains certificate type")
+	}
+
+	ks, err := jks.Parse(raw, opts)
+	switch {
+	case err != nil:
+		t.Fatalf("Parse: %v", err)
+	case len(ks.Certs) != 1 || ks.Certs[0].CertErr != nil ||
+		!ks.Certs[0].Cert.Equal(b.Keystore().Certs[0].Cert):
+		t.Errorf("certificate did not round trip")
+	case len(ks.Keypairs) != 1 || ks.Keypairs[0].PrivKeyErr != nil ||
+		len(ks.Keypairs[0].CertChain) != 1 ||
+		ks.Keypairs[0].CertChain[0].CertErr != nil:
+		t.Errorf("keypair did not round trip")
+	}
+}
+
+// TestPackTo checks that a keystore streamed with PackTo parses back with a
+// valid digest, and that an error from the writer is returned.
+func TestPackTo(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").
+		ECKeypair("server", elliptic.P256())
+	var buf bytes.Buffer
+	n, err := b.Keystore().PackTo(&buf, b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("PackTo: %v", err)
+	case n != int64(buf.Len()):
+		t.Errorf("PackTo returned %d ≠ %d bytes written", n, buf.Len())
+	}
+	if _, err = jks.Parse(buf.Bytes(), b.Options()); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+
+	fail := &limitedWriter{remain: 100}
+	n, err = b.Keystore().PackTo(fail, b.Options())
+	switch {
+	case !errors.Is(err, errShortWrite):
+		t.Errorf("expected errShortWrite but got %v", err)
+	case n != 100:
+		t.Errorf("PackTo returned %d ≠ 100 bytes written", n)
+	}
+}
+
+// limitedWriter accepts remain bytes and then fails with errShortWrite.
+type limitedWriter struct {
+	remain int
+}
+
+var errShortWrite = errors.New("short write")
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remain {
+		n := w.remain
+		w.remain = 0
+		return n, errShortWrite
+	}
+	w.remain -= len(p)
+	return len(p), nil
+}
+
+// TestParseFrom checks that ParseFrom reads a keystore from a stream that
+// returns a byte at a time, verifying its digest, and reports truncated and
+// corrupt streams as Parse does.
+func TestParseFrom(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").
+		ECKeypair("server", elliptic.P256())
+	raw := b.Bytes()
+	parse := func(raw []byte) (*jks.Keystore, error) {
+		return jks.ParseFrom(iotest.OneByteReader(bytes.NewReader(raw)),
+			b.Options())
+	}
+
+	ks, err := parse(raw)
+	switch {
+	case err != nil:
+		t.Fatalf("ParseFrom: %v", err)
+	case len(ks.Certs) != 1 || len(ks.Keypairs) != 1:
+		t.Errorf("got %d certs, %d keypairs ≠ expected 1, 1",
+			len(ks.Certs), len(ks.Keypairs))
+	case ks.Keypairs[0].PrivKeyErr != nil:
+		t.Errorf("keypair: %v", ks.Keypairs[0].PrivKeyErr)
+	case ks.ETag != jks.ETag(raw):
+		t.Errorf("ETag %s ≠ expected %s", ks.ETag, jks.ETag(raw))
+	}
+
+	for _, n := range []int{10, 100, len(raw) - 10} {
+		_, err = parse(jkstest.Truncate(raw, n))
+		if code := jks.ErrorCode(err); code != jks.CodeTruncated {
+			t.Errorf("truncated by %d bytes: error code %s ≠ "+
+				"expected %s (%v)", n, code, jks.CodeTruncated,
+				err)
+		}
+	}
+	_, err = parse(append(raw[:len(raw):len(raw)], 0))
+	if code := jks.ErrorCode(err); code != jks.CodeMalformed {
+		t.Errorf("trailing data: error code %s ≠ expected %s (%v)",
+			code, jks.CodeMalformed, err)
+	}
+	if _, err = parse(jkstest.CorruptDigest(raw)); !errors.Is(err,
+		jks.ErrDigestMismatch) {
+		t.Errorf("expected ErrDigestMismatch but got %v", err)
+	}
+
+	r := iotest.TimeoutReader(bytes.NewReader(raw))
+	if _, err = jks.ParseFrom(r, b.Options()); !errors.Is(err,
+		iotest.ErrTimeout) {
+		t.Errorf("expected reader error but got %v", err)
+	}
+}
+
+// TestSkipKeyDecryption checks that keys left encrypted by Parse and
+// ParsePKCS12 can be unlocked later with Keypair.Decrypt.
+func TestSkipKeyDecryption(t *testing.T) {
+	key := jkstest.ECKey(t, elliptic.P256())
+	b := jkstest.New(t, "password").Keypair("server", key).
+		KeyPassword("server", "key password")
+	p12, err := b.Keystore().PackPKCS12(b.Options())
+	if err != nil {
+		t.Fatalf("PackPKCS12: %v", err)
+	}
+	opts := &jks.Options{
+		Password:          "password",
+		SkipKeyDecryption: true,
+	}
+
+	for name, raw := range map[string][]byte{
+		"jks":    b.Bytes(),
+		"pkcs12": p12,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ks, _, err := jks.ParseAny(raw, opts)
+			if err != nil {
+				t.Fatalf("ParseAny: %v", err)
+			}
+			kp := ks.Keypairs[0]
+			switch {
+			case !errors.Is(kp.PrivKeyErr, jks.ErrKeyNotDecrypted):
+				t.Errorf("PrivKeyErr %v ≠ expected "+
+					"ErrKeyNotDecrypted", kp.PrivKeyErr)
+			case kp.PrivateKey != nil || kp.RawKey != nil:
+				t.Errorf("key decrypted despite option")
+			}
+
+			err = kp.Decrypt("wrong")
+			switch {
+			case jks.ErrorCode(err) != jks.CodeBadKeyPassword:
+				t.Errorf("Decrypt with wrong password: %v", err)
+			case kp.PrivKeyErr != jks.ErrKeyNotDecrypted:
+				t.Errorf("keypair changed by failed Decrypt")
+			}
+			switch err = kp.Decrypt("key password"); {
+			case err != nil:
+				t.Errorf("Decrypt: %v", err)
+			case kp.PrivKeyErr != nil:
+				t.Errorf("PrivKeyErr still set: %v",
+					kp.PrivKeyErr)
+			case !key.Equal(kp.PrivateKey):
+				t.Errorf("private key mismatch")
+			}
+		})
+	}
+}
+
+// TestParallelism checks that keys decrypted on several goroutines come back
+// in file order and as they would one at a time, including a key whose
+// password is not known.
+func TestParallelism(t *testing.T) {
+	b := jkstest.New(t, "password")
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < 6; i++ {
+		keys = append(keys, jkstest.ECKey(t, elliptic.P256()))
+		b.Keypair(fmt.Sprintf("k%d", i), keys[i])
+	}
+	b.KeyPassword("k3", "other")
+	p12, err := b.Keystore().PackPKCS12(b.Options())
+	if err != nil {
+		t.Fatalf("PackPKCS12: %v", err)
+	}
+
+	for name, raw := range map[string][]byte{
+		"jks":    b.Bytes(),
+		"pkcs12": p12,
+	} {
+		for _, n := range []int{4, -1} {
+			t.Run(fmt.Sprintf("%s/%d", name, n),
+				testParallelism(raw, n, keys))
+		}
+	}
+}
+
+func testParallelism(raw []byte, parallelism int, keys []*ecdsa.PrivateKey,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		ks, _, err := jks.ParseAny(raw, &jks.Options{
+			Password:    "password",
+			Parallelism: parallelism,
+		})
+		if err != nil {
+			t.Fatalf("ParseAny: %v", err)
+		}
+		if len(ks.Keypairs) != len(keys) {
+			t.Fatalf("keypairs %d ≠ expected %d",
+				len(ks.Keypairs), len(keys))
+		}
+		for i, kp := range ks.Keypairs {
+			switch alias := fmt.Sprintf("k%d", i); {
+			case kp.Alias != alias:
+				t.Errorf("keypair #%d alias %q ≠ expected %q",
+					i, kp.Alias, alias)
+			case i == 3:
+				code := jks.ErrorCode(kp.PrivKeyErr)
+				if code != jks.CodeBadKeyPassword {
+					t.Errorf("%s: PrivKeyErr %v ≠ "+
+						"expected bad password", alias,
+						kp.PrivKeyErr)
+				}
+			case kp.PrivKeyErr != nil:
+				t.Errorf("%s: %v", alias, kp.PrivKeyErr)
+			case !keys[i].Equal(kp.PrivateKey):
+				t.Errorf("%s: private key mismatch", alias)
+			}
+		}
+	}
+}
+
+// TestPassThroughKey checks that a keystore whose key password is not known
+// can still have entries added and be packed again, with the key left as it
+// was, and that a key cannot be passed through to a format which would not
+// accept its encryption.
+func TestPassThroughKey(t *testing.T) {
+	key := jkstest.ECKey(t, elliptic.P256())
+	b := jkstest.New(t, "password").Keypair("server", key).
+		KeyPassword("server", "key password")
+	p12, err := b.Keystore().PackPKCS12(b.Options())
+	if err != nil {
+		t.Fatalf("PackPKCS12: %v", err)
+	}
+	opts := &jks.Options{Password: "password"}
+	extra := jkstest.SelfSigned(t, jkstest.ECKey(t, elliptic.P256()),
+		"extra")
+
+	for name, raw := range map[string][]byte{
+		"jks":    b.Bytes(),
+		"pkcs12": p12,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ks, format, err := jks.ParseAny(raw, opts)
+			switch {
+			case err != nil:
+				t.Fatalf("ParseAny: %v", err)
+			case ks.Keypairs[0].PrivKeyErr == nil:
+				t.Fatalf("key decrypted with wrong password")
+			}
+			ks.Certs = append(ks.Certs, &jks.Cert{
+				Alias: "extra",
+				Raw:   extra.Raw,
+				Cert:  extra,
+			})
+
+			pack, other := ks.Pack, ks.PackPKCS12
+			if format == jks.FormatPKCS12 {
+				pack, other = other, pack
+			}
+			if raw, err = pack(opts); err != nil {
+				t.Fatalf("Pack: %v", err)
+			}
+			ks, _, err = jks.ParseAny(raw, &jks.Options{
+				Password: "password",
+				KeyPasswords: map[string]string{
+					"server": "key password",
+				},
+			})
+			switch {
+			case err != nil:
+				t.Fatalf("ParseAny: %v", err)
+			case len(ks.Certs) != 1:
+				t.Errorf("added certificate not written")
+			case ks.Keypairs[0].PrivKeyErr != nil:
+				t.Errorf("keypair: %v",
+					ks.Keypairs[0].PrivKeyErr)
+			case !key.Equal(ks.Keypairs[0].PrivateKey):
+				t.Errorf("private key mismatch")
+			}
+
+			_, err = other(opts)
+			if jks.ErrorCode(err) != jks.CodeIncompatible {
+				t.Errorf("packing to the other format: "+
+					"expected %s error but got %v",
+					jks.CodeIncompatible, err)
+			}
+		})
+	}
+}
+
+// TestEncryptKeyFunc checks that a keypair's key may be encrypted by its
+// EncryptKeyFunc, without PrivateKey being set, and that an encryption the
+// output format does not allow is refused.
+func TestEncryptKeyFunc(t *testing.T) {
+	key := jkstest.ECKey(t, elliptic.P256())
+	b := jkstest.New(t, "password").Keypair("server", key).
+		KeyPassword("server", "key password")
+	kp := b.Keystore().Keypairs[0]
+	kp.PrivateKey = nil
+	var offered []asn1.ObjectIdentifier
+	kp.EncryptKeyFunc = func(password []byte,
+		algos []asn1.ObjectIdentifier,
+	) ([]byte, error) {
+		offered = algos
+		plain, err := jks.MarshalPKCS8(key)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, err := jks.EncryptJavaKeyEncryption1(plain,
+			string(password))
+		if err != nil {
+			return nil, err
+		}
+		return (&jks.EncryptedPrivateKeyInfo{
+			Algo: pkix.AlgorithmIdentifier{
+				Algorithm:  jks.JavaKeyEncryptionOID1,
+				Parameters: asn1.NullRawValue,
+			},
+			EncryptedData: ciphertext,
+		}).Marshal()
+	}
+
+	ks, err := jks.Parse(b.Bytes(), b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("Parse: %v", err)
+	case len(offered) == 0 || !offered[0].Equal(jks.JavaKeyEncryptionOID1):
+		t.Errorf("unexpected algorithms offered: %v", offered)
+	case ks.Keypairs[0].PrivKeyErr != nil:
+		t.Errorf("keypair: %v", ks.Keypairs[0].PrivKeyErr)
+	case !key.Equal(ks.Keypairs[0].PrivateKey):
+		t.Errorf("private key mismatch")
+	}
+
+	_, err = b.Keystore().PackPKCS12(b.Options())
+	switch {
+	case jks.ErrorCode(err) != jks.CodeIncompatible:
+		t.Errorf("PackPKCS12: expected %s error but got %v",
+			jks.CodeIncompatible, err)
+	case len(offered) != 2 ||
+		!offered[0].Equal(jks.PBEWithSHA1And3DESOID):
+		t.Errorf("unexpected algorithms offered: %v", offered)
+	}
+}
+
+// TestKeyProtection checks that KeyProtectionPBES2 encrypts keys with PBES2 in
+// both formats, that they can be read back, and that such a key can only be
+// passed through a JKS file if PBES2 is allowed.
+func TestKeyProtection(t *testing.T) {
+	const oidPBES2 = "1.2.840.113549.1.5.13"
+	key := jkstest.ECKey(t, elliptic.P256())
+	b := jkstest.New(t, "password").Keypair("server", key)
+	protection, err := jks.ParseKeyProtection("PBES2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &jks.Options{Password: "password", KeyProtection: protection}
+
+	for name, pack := range map[string]func(*jks.Options) ([]byte,
+		error){
+		"jks":    b.Keystore().Pack,
+		"pkcs12": b.Keystore().PackPKCS12,
+	} {
+		raw, err := pack(opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ks, _, err := jks.ParseAny(raw, b.Options())
+		if err != nil {
+			t.Fatalf("%s: ParseAny: %v", name, err)
+		}
+		kp := ks.Keypairs[0]
+		keyInfo, err := jks.ParseEncryptedPrivateKeyInfo(
+			kp.EncryptedKey)
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", name, err)
+		case keyInfo.Algo.Algorithm.String() != oidPBES2:
+			t.Errorf("%s: key encrypted with %v ≠ expected %s",
+				name, keyInfo.Algo.Algorithm, oidPBES2)
+		case !key.Equal(kp.PrivateKey):
+			t.Errorf("%s: private key mismatch (%v)", name,
+				kp.PrivKeyErr)
+		}
+	}
+
+	raw, err := b.Keystore().Pack(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := jks.Parse(raw, &jks.Options{
+		Password:          "password",
+		SkipKeyDecryption: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Pack(opts); err != nil {
+		t.Errorf("Pack with PBES2 key passed through: %v", err)
+	}
+	_, err = ks.Pack(&jks.Options{Password: "password"})
+	if code := jks.ErrorCode(err); code != jks.CodeIncompatible {
+		t.Errorf("Pack with default protection: code %q ≠ expected %q",
+			code, jks.CodeIncompatible)
+	}
+}
+
+// TestDeterministic checks that with Options.Deterministic set, Pack and
+// PackPKCS12 write the same bytes for the same entries, whatever their order,
+// and that entries without a timestamp are given Options.Timestamp.
+func TestDeterministic(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").CA("other").
+		ECKeypair("server", elliptic.P256()).
+		ECKeypair("client", elliptic.P256())
+	ks := b.Keystore()
+	for _, cert := range ks.Certs {
+		cert.Timestamp = time.Time{}
+	}
+	reversed := &jks.Keystore{
+		Certs:    []*jks.Cert{ks.Certs[1], ks.Certs[0]},
+		Keypairs: []*jks.Keypair{ks.Keypairs[1], ks.Keypairs[0]},
+	}
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, protection := range []jks.KeyProtection{
+		jks.KeyProtectionDefault, jks.KeyProtectionPBES2,
+	} {
+		opts := &jks.Options{
+			Password:      "password",
+			KeyProtection: protection,
+			Deterministic: true,
+			Timestamp:     ts,
+		}
+		first, err := ks.Pack(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := reversed.Pack(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("Pack with %v: output differs", protection)
+		}
+
+		first, err = ks.PackPKCS12(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err = reversed.PackPKCS12(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("PackPKCS12 with %v: output differs",
+				protection)
+		}
+	}
+
+	raw, err := ks.Pack(&jks.Options{
+		Password:      "password",
+		Deterministic: true,
+		Timestamp:     ts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := jks.Parse(raw, b.Options())
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case parsed.Certs[0].Alias != "other" ||
+		parsed.Keypairs[0].Alias != "client":
+		t.Errorf("entries not sorted by alias")
+	case !parsed.Certs[0].Timestamp.Equal(ts):
+		t.Errorf("timestamp %v ≠ expected %v",
+			parsed.Certs[0].Timestamp, ts)
+	case parsed.Keypairs[0].Timestamp.UnixMilli() !=
+		ks.Keypairs[1].Timestamp.UnixMilli():
+		t.Errorf("keypair timestamp not kept")
+	}
+
+	again, err := ks.Pack(b.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := ks.Pack(b.Options()); bytes.Equal(again, other) {
+		t.Errorf("output is deterministic without Deterministic set")
+	}
+}
+
+// TestTimestamps checks that entry timestamps survive a round trip to the
+// millisecond, even outside the range of time.Time.UnixNano, and where entries
+// without one get their timestamp from.
+func TestTimestamps(t *testing.T) {
+	b := jkstest.New(t, "password").CA("ms").CA("future").CA("none")
+	ks := b.Keystore()
+	ms := time.UnixMilli(1234567890123)
+	future := time.Date(3000, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	ks.Certs[0].Timestamp = ms
+	ks.Certs[1].Timestamp = future
+	ks.Certs[2].Timestamp = time.Time{}
+
+	clock := time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)
+	fixed := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := *b.Options()
+	opts.Clock = func() time.Time { return clock }
+	t.Run("clock", testTimestamps(ks, &opts, ms, future, clock))
+	opts.Timestamp = fixed
+	t.Run("fixed", testTimestamps(ks, &opts, ms, future, fixed))
+	opts.Timestamp = time.Time{}
+	opts.Deterministic = true
+	t.Run("deterministic", testTimestamps(ks, &opts, ms, future,
+		time.Unix(0, 0)))
+}
+
+func testTimestamps(ks *jks.Keystore, opts *jks.Options,
+	exp ...time.Time,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		raw, err := ks.Pack(opts)
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		parsed, err := jks.Parse(raw, opts)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		byAlias := make(map[string]time.Time)
+		for e := range parsed.Entries() {
+			byAlias[e.EntryAlias()] = e.EntryTimestamp()
+		}
+		for i, alias := range []string{"ms", "future", "none"} {
+			if !byAlias[alias].Equal(exp[i]) {
+				t.Errorf("%s: timestamp %v ≠ %v", alias,
+					byAlias[alias], exp[i])
+			}
+		}
+	}
+}
+
+// TestPreserveOrder checks that a keystore whose trusted certificates and
+// keypairs are interleaved keeps its order when it is parsed, modified and
+// packed again.
+func TestPreserveOrder(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").CA("other").
+		ECKeypair("server", elliptic.P256()).
+		ECKeypair("client", elliptic.P256())
+	ks := b.Keystore()
+	ks.Order = []string{"server", "root", "client", "other"}
+
+	roundTrip := func(ks *jks.Keystore) *jks.Keystore {
+		raw, err := ks.Pack(b.Options())
+		if err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		ks, err = jks.Parse(raw, b.Options())
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		return ks
+	}
+	checkOrder := func(ks *jks.Keystore, expected ...string) {
+		t.Helper()
+		if !slices.Equal(ks.Order, expected) {
+			t.Errorf("order %q ≠ expected %q", ks.Order, expected)
+		}
+	}
+
+	ks = roundTrip(ks)
+	checkOrder(ks, "server", "root", "client", "other")
+
+	if err := ks.RenameAlias("root", "new-root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.DeleteEntry("client"); err != nil {
+		t.Fatal(err)
+	}
+	extra := jkstest.SelfSigned(t, jkstest.ECKey(t, elliptic.P256()),
+		"extra")
+	err := ks.SetCert(&jks.Cert{Alias: "extra", Raw: extra.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(roundTrip(ks), "server", "new-root", "other", "extra")
+}
+
+// TestKeyAlgorithm checks that Parse unmarshals RSA, EC and Ed25519 keys and
+// records the algorithm OID from each PrivateKeyInfo.
+func TestKeyAlgorithm(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	b := jkstest.New(t, "password").
+		RSAKeypair("rsa", 2048).
+		ECKeypair("ec", elliptic.P256()).
+		Keypair("ed", edKey)
+	b.Options().Compatibility = jks.Java17
+
+	ks, err := jks.Parse(b.Bytes(), b.Options())
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	exp := []asn1.ObjectIdentifier{
+		{1, 2, 840, 113549, 1, 1, 1},
+		{1, 2, 840, 10045, 2, 1},
+		{1, 3, 101, 112},
+	}
+	for i, kp := range ks.Keypairs {
+		if !kp.KeyAlgorithm.Equal(exp[i]) {
+			t.Errorf("keypair %q: algorithm %v ≠ expected %v",
+				kp.Alias, kp.KeyAlgorithm, exp[i])
+		}
+	}
+	if _, ok := ks.Keypairs[2].PrivateKey.(ed25519.PrivateKey); !ok {
+		t.Errorf("keypair \"ed\": got %T, expected Ed25519 key",
+			ks.Keypairs[2].PrivateKey)
+	}
+}
+
+// TestParseCorrupt checks that deliberately-corrupted keystores are rejected.
+func TestParseCorrupt(t *testing.T) {
+	b := jkstest.New(t, "password").CA("a").CA("b")
+	raw := b.Bytes()
+
+	for name, corrupt := range map[string][]byte{
+		"digest":    jkstest.CorruptDigest(raw),
+		"magic":     jkstest.CorruptMagic(raw),
+		"count":     jkstest.CorruptEntryCount(raw),
+		"truncated": jkstest.Truncate(raw, 1),
+	} {
+		if _, err := jks.Parse(corrupt, b.Options()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// TestTrace checks that Options.Trace records where each entry lies and its
+// problems, and the entry at which a truncated file fails.
+func TestTrace(t *testing.T) {
+	b := jkstest.New(t, "password").CA("a").
+		ECKeypair("key", elliptic.P256()).
+		CA("b")
+	raw := b.Bytes()
+	opts := *b.Options()
+	opts.KeyPasswords = map[string]string{"key": "wrong"}
+	opts.Trace = true
+	ks, err := jks.Parse(raw, &opts)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(ks.Trace) != 3 {
+		t.Fatalf("got %d trace entries ≠ expected 3", len(ks.Trace))
+	}
+	end := int64(12) // the header
+	for i, exp := range []struct {
+		tag      uint32
+		alias    string
+		warnings int
+	}{{2, "a", 0}, {2, "b", 0}, {1, "key", 1}} {
+		tr := ks.Trace[i]
+		switch {
+		case tr.Index != i || tr.Offset != end:
+			t.Errorf("entry %d: found as #%d at offset %d ≠ %d", i,
+				tr.Index, tr.Offset, end)
+		case tr.Tag != exp.tag || tr.Alias != exp.alias:
+			t.Errorf("entry %d: tag %d alias %q ≠ %d %q", i, tr.Tag,
+				tr.Alias, exp.tag, exp.alias)
+		case len(tr.Warnings) != exp.warnings || tr.Err != nil:
+			t.Errorf("entry %d: warnings %v, error %v", i,
+				tr.Warnings, tr.Err)
+		}
+		end = tr.Offset + tr.Length
+	}
+	if end != int64(len(raw)-20) {
+		t.Errorf("entries end at %d ≠ %d", end, len(raw)-20)
+	}
+	if w := ks.Trace[2].Warnings; len(w) == 1 &&
+		!errors.Is(w[0], jks.ErrBadKeyPassword) {
+		t.Errorf("keypair warning: %v", w[0])
+	}
+
+	// cut the keypair off after its tag, alias and one byte more
+	last := ks.Trace[2]
+	ks, err = jks.Parse(raw[:last.Offset+10], &opts)
+	switch {
+	case jks.ErrorCode(err) != jks.CodeTruncated:
+		t.Errorf("truncated: expected %s but got %v", jks.CodeTruncated,
+			err)
+	case len(ks.Trace) != 3 || ks.Trace[2].Err != err ||
+		ks.Trace[2].Length != 9:
+		t.Errorf("truncated: last trace entry %v", ks.Trace[2])
+	}
+	exp := fmt.Sprintf("entry 2 at offset 0x%X (9 bytes): keypair; "+
+		"error: ", last.Offset)
+	if s := ks.Trace[2].String(); !strings.HasPrefix(s, exp) {
+		t.Errorf("String: %q does not start %q", s, exp)
+	}
+
+	opts.Trace = false
+	if ks, _ = jks.Parse(raw, &opts); ks.Trace != nil {
+		t.Errorf("trace recorded without Options.Trace")
+	}
+}
+
+// TestPartialRead checks that Options.CertsOnly and Options.Alias leave out
+// the other entries, without fetching their key passwords, in JKS and PKCS#12
+// files alike.
+func TestPartialRead(t *testing.T) {
+	b := jkstest.New(t, "password").CA("a").
+		ECKeypair("key", elliptic.P256()).
+		CA("b")
+	raw := b.Bytes()
+	p12, err := b.Keystore().PackPKCS12(b.Options())
+	if err != nil {
+		t.Fatalf("PackPKCS12: %v", err)
+	}
+
+	for _, c := range []struct {
+		name      string
+		certsOnly bool
+		alias     string
+		exp       string
+		fetched   int
+	}{
+		{"all", false, "", "a b key", 1},
+		{"certs only", true, "", "a b", 0},
+		{"key alias", false, "KEY", "key", 1},
+		{"cert alias", false, "b", "b", 0},
+		{"both", true, "key", "", 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var fetched int
+			opts := *b.Options()
+			opts.KeyPasswordFunc = func(string) ([]byte, error) {
+				fetched++
+				return nil, nil
+			}
+			opts.CertsOnly, opts.Alias = c.certsOnly, c.alias
+
+			ks, err := jks.Parse(raw, &opts)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if ks.Integrity != jks.IntegrityVerified {
+				t.Errorf("integrity %v ≠ verified",
+					ks.Integrity)
+			}
+			order := strings.Join(ks.Order, " ")
+			if order != c.exp {
+				t.Errorf("order %q ≠ %q", order, c.exp)
+			}
+			if aliases := partialAliases(ks); aliases != c.exp {
+				t.Errorf("entries %q ≠ %q", aliases, c.exp)
+			}
+			for _, kp := range ks.Keypairs {
+				if kp.PrivKeyErr != nil {
+					t.Errorf("keypair: %v", kp.PrivKeyErr)
+				}
+			}
+			if fetched != c.fetched {
+				t.Errorf("%d key passwords fetched ≠ %d",
+					fetched, c.fetched)
+			}
+
+			fetched = 0
+			if ks, err = jks.ParsePKCS12(p12, &opts); err != nil {
+				t.Fatalf("ParsePKCS12: %v", err)
+			}
+			if aliases := partialAliases(ks); aliases != c.exp {
+				t.Errorf("PKCS#12 entries %q ≠ %q", aliases,
+					c.exp)
+			}
+			if fetched != c.fetched {
+				t.Errorf("PKCS#12: %d key passwords fetched ≠ "+
+					"%d", fetched, c.fetched)
+			}
+		})
+	}
+}
+
+// partialAliases returns the aliases of ks's certificate and keypair entries,
+// in that order and separated by spaces.
+func partialAliases(ks *jks.Keystore) string {
+	var aliases []string
+	for _, cert := range ks.Certs {
+		aliases = append(aliases, cert.Alias)
+	}
+	for _, kp := range ks.Keypairs {
+		aliases = append(aliases, kp.Alias)
+	}
+	return strings.Join(aliases, " ")
+}
+
+// TestDigestMismatch checks that a wrong password is reported as
+// ErrDigestMismatch, and that CertsOnDigestMismatch drops the keypairs.
+func TestDigestMismatch(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").RSAKeypair("server", 2048)
+	raw := b.Bytes()
+
+	opts := &jks.Options{Password: "wrong"}
+	ks, err := jks.Parse(raw, opts)
+	switch {
+	case !errors.Is(err, jks.ErrDigestMismatch):
+		t.Fatalf("error %v ≠ expected %v", err, jks.ErrDigestMismatch)
+	case len(ks.Certs) != 1 || len(ks.Keypairs) != 1:
+		t.Errorf("unexpected keystore content")
+	}
+
+	opts.CertsOnDigestMismatch = true
+	ks, err = jks.Parse(raw, opts)
+	switch {
+	case !errors.Is(err, jks.ErrDigestMismatch):
+		t.Fatalf("error %v ≠ expected %v", err, jks.ErrDigestMismatch)
+	case len(ks.Certs) != 1 || len(ks.Keypairs) != 0:
+		t.Errorf("expected trusted certificates only")
+	}
+}
+
+// TestVerify checks that Verify accepts the right password, and distinguishes
+// a wrong password or a corrupt digest from data that is not a keystore.
+func TestVerify(t *testing.T) {
+	raw := jkstest.New(t, "password").CA("root").
+		ECKeypair("server", elliptic.P256()).Bytes()
+
+	if err := jks.Verify(raw, "password"); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	for name, err := range map[string]error{
+		"wrong password": jks.Verify(raw, "wrong"),
+		"corrupt digest": jks.Verify(jkstest.CorruptDigest(raw),
+			"password"),
+	} {
+		if !errors.Is(err, jks.ErrBadStorePassword) ||
+			!errors.Is(err, jks.ErrDigestMismatch) {
+			t.Errorf("%s: error %v ≠ expected %v", name, err,
+				jks.ErrBadStorePassword)
+		}
+	}
+	for name, test := range map[string]struct {
+		raw  []byte
+		code string
+	}{
+		"bad magic": {jkstest.CorruptMagic(raw), jks.CodeBadMagic},
+		"truncated": {raw[:20], jks.CodeTruncated},
+	} {
+		err := jks.Verify(test.raw, "password")
+		if code := jks.ErrorCode(err); code != test.code {
+			t.Errorf("%s: code %q ≠ expected %q (error: %v)", name,
+				code, test.code, err)
+		}
+	}
+}
+
+// TestFindPassword checks that FindPassword picks out the matching candidate
+// password, or reports a wrong password if there is none.
+func TestFindPassword(t *testing.T) {
+	raw := jkstest.New(t, "second").CA("root").Bytes()
+
+	i, err := jks.FindPassword(raw, []string{"first", "second", "third"},
+		jks.PasswordUTF16BE)
+	if err != nil || i != 1 {
+		t.Errorf("FindPassword: got %d (error %v) ≠ expected 1", i, err)
+	}
+	_, err = jks.FindPassword(raw, []string{"first", "third"},
+		jks.PasswordUTF16BE)
+	if !errors.Is(err, jks.ErrBadStorePassword) {
+		t.Errorf("no match: error %v ≠ expected %v", err,
+			jks.ErrBadStorePassword)
+	}
+	_, err = jks.FindPassword(jkstest.CorruptMagic(raw),
+		[]string{"second"}, jks.PasswordUTF16BE)
+	if code := jks.ErrorCode(err); code != jks.CodeBadMagic {
+		t.Errorf("bad magic: code %q ≠ expected %q", code,
+			jks.CodeBadMagic)
+	}
+}
+
+// TestIntegrity checks that a keystore can be read without its password, and
+// that Integrity records whether the digest was verified.
+func TestIntegrity(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").
+		ECKeypair("server", elliptic.P256())
+	raw := b.Bytes()
+	p12, err := b.Keystore().PackPKCS12(b.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		pkcs12   bool
+		opts     *jks.Options
+		expected jks.Integrity
+	}{
+		{"nil options", false, nil, jks.IntegrityUnchecked},
+		{"skip", false, &jks.Options{
+			Password:         "wrong",
+			SkipVerifyDigest: true,
+		}, jks.IntegrityUnchecked},
+		{"verified", false, b.Options(), jks.IntegrityVerified},
+		{"mismatch", false, &jks.Options{Password: "wrong"},
+			jks.IntegrityMismatch},
+		{"PKCS#12 verified", true, b.Options(), jks.IntegrityVerified},
+		{"PKCS#12 mismatch", true, &jks.Options{Password: "wrong"},
+			jks.IntegrityMismatch},
+	} {
+		parse, data := jks.Parse, raw
+		if test.pkcs12 {
+			parse, data = jks.ParsePKCS12, p12
+		}
+		ks, err := parse(data, test.opts)
+		switch {
+		case ks == nil:
+			t.Errorf("%s: %v", test.name, err)
+		case ks.Integrity != test.expected:
+			t.Errorf("%s: integrity %v ≠ expected %v", test.name,
+				ks.Integrity, test.expected)
+		case (err == nil) != (test.expected != jks.IntegrityMismatch):
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case len(ks.Certs) != 1 || ks.Certs[0].Cert == nil:
+			t.Errorf("%s: trusted certificate not read", test.name)
+		}
+	}
+}
+
+// TestUnparseableCert checks that certificates which crypto/x509 cannot parse
+// are kept as raw DER and can still be packed.
+func TestUnparseableCert(t *testing.T) {
+	bogus := []byte{0x30, 0x03, 0x02, 0x01, 0x01} // SEQUENCE { INTEGER 1 }
+	ks := &jks.Keystore{
+		Certs: []*jks.Cert{{Alias: "bogus", Raw: bogus}},
+	}
+	opts := &jks.Options{Password: "password"}
+	raw, err := ks.Pack(opts)
+	if err != nil {
+		t.Fatalf("failed to pack: %v", err)
+	}
+
+	ks, err = jks.Parse(raw, opts)
+	switch {
+	case err != nil:
+		t.Fatalf("failed to parse: %v", err)
+	case len(ks.Certs) != 1:
+		t.Fatalf("got %d certificates, expected 1", len(ks.Certs))
+	case ks.Certs[0].CertErr == nil:
+		t.Error("expected certificate parse error")
+	case !bytes.Equal(ks.Certs[0].DER(), bogus):
+		t.Errorf("raw certificate ‘%X’ ≠ expected ‘%X’",
+			ks.Certs[0].DER(), bogus)
+	}
+}
+
+// TestRawChain checks that a keypair whose chain is given only as raw DER,
+// without parsed certificates, can be packed as JKS and PKCS#12 and parses back
+// with the same DER, even if the DER is not a certificate crypto/x509 accepts.
+// PKCS#12 files do not record the order of a chain, so an unparseable
+// certificate comes back from one as a trusted certificate instead.
+func TestRawChain(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").
+		ECKeypair("server", elliptic.P256())
+	ks := b.Keystore()
+	kp := ks.Keypairs[0]
+	var chain [][]byte
+	for i, cert := range kp.CertChain {
+		chain = append(chain, cert.DER())
+		kp.CertChain[i] = &jks.KeypairCert{Raw: cert.DER()}
+	}
+	bogus := []byte{0x30, 0x03, 0x02, 0x01, 0x01} // SEQUENCE { INTEGER 1 }
+	chain = append(chain, bogus)
+	kp.CertChain = append(kp.CertChain, &jks.KeypairCert{Raw: bogus})
+	ks.Certs[0] = &jks.Cert{
+		Alias:     ks.Certs[0].Alias,
+		Timestamp: ks.Certs[0].Timestamp,
+		Raw:       ks.Certs[0].DER(),
+	}
+
+	raw, err := ks.Pack(b.Options())
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	p12, err := ks.PackPKCS12(b.Options())
+	if err != nil {
+		t.Fatalf("PackPKCS12: %v", err)
+	}
+	parsed, err := jks.Parse(raw, b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("Parse: %v", err)
+	case !slices.EqualFunc(parsed.Keypairs[0].CertChain, chain,
+		func(c *jks.KeypairCert, der []byte) bool {
+			return bytes.Equal(c.DER(), der)
+		}):
+		t.Errorf("JKS certificate chain did not round trip")
+	case parsed.Keypairs[0].CertChain[0].Cert == nil:
+		t.Errorf("leaf certificate not parsed")
+	case parsed.Keypairs[0].CertChain[len(chain)-1].CertErr == nil:
+		t.Errorf("expected certificate parse error")
+	}
+
+	parsed, err = jks.ParsePKCS12(p12, b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("ParsePKCS12: %v", err)
+	case !slices.EqualFunc(parsed.Keypairs[0].CertChain,
+		chain[:len(chain)-1],
+		func(c *jks.KeypairCert, der []byte) bool {
+			return bytes.Equal(c.DER(), der)
+		}):
+		t.Errorf("PKCS#12 certificate chain did not round trip")
+	case !slices.ContainsFunc(parsed.Certs, func(c *jks.Cert) bool {
+		return c.CertErr != nil && bytes.Equal(c.DER(), bogus)
+	}):
+		t.Errorf("unparseable certificate not kept from PKCS#12")
+	}
+}
+
+// TestCertType checks that certificates of a type other than X.509 are kept,
+// unparsed, and written back with the same type, and that they are refused by
+// formats which cannot record the type.
+func TestCertType(t *testing.T) {
+	b := jkstest.New(t, "password").ECKeypair("server", elliptic.P256())
+	ks := b.Keystore()
+	ks.Certs = append(ks.Certs, &jks.Cert{
+		Alias:     "pgp",
+		Timestamp: ks.Keypairs[0].Timestamp,
+		Raw:       []byte("not a certificate"),
+		Type:      "PGP",
+	})
+	ks.Keypairs[0].CertChain = append(ks.Keypairs[0].CertChain,
+		&jks.KeypairCert{Raw: []byte("nor this"), Type: "PGP"})
+
+	raw, err := ks.Pack(b.Options())
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	// the key is written back as it was read, so the output is the same
+	parsed, err := jks.Parse(raw, &jks.Options{
+		Password:          "password",
+		SkipKeyDecryption: true,
+	})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	chain := parsed.Keypairs[0].CertChain
+	switch {
+	case len(parsed.Certs) != 1 || parsed.Certs[0].Type != "PGP" ||
+		jks.ErrorCode(parsed.Certs[0].CertErr) != jks.CodeUnsupported:
+		t.Errorf("certificate type not kept: %+v", parsed.Certs)
+	case len(chain) != 2 || chain[0].Type != "" || chain[1].Type != "PGP":
+		t.Errorf("chain certificate types not kept")
+	}
+	if repacked, err := parsed.Pack(b.Options()); err != nil {
+		t.Errorf("repacking: %v", err)
+	} else if !bytes.Equal(repacked, raw) {
+		t.Errorf("repacked keystore differs")
+	}
+
+	opts := b.Options()
+	opts.Version = 1
+	if _, err = ks.Pack(opts); jks.ErrorCode(err) != jks.CodeIncompatible {
+		t.Errorf("version 1: expected %s but got %v",
+			jks.CodeIncompatible, err)
+	}
+	_, err = ks.PackPKCS12(b.Options())
+	if jks.ErrorCode(err) != jks.CodeIncompatible {
+		t.Errorf("PKCS#12: expected %s but got %v",
+			jks.CodeIncompatible, err)
+	}
+}
+
+// TestUnknownEntry checks that an entry of an unknown type at the end of the
+// file is kept and written back, and that one elsewhere is refused.
+func TestUnknownEntry(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root")
+	raw := b.Bytes()
+
+	// append entries with tag 99 and redo the digest
+	withUnknown := func(n int) []byte {
+		out := bytes.Clone(raw[:len(raw)-sha1.Size])
+		binary.BigEndian.PutUint32(out[8:],
+			binary.BigEndian.Uint32(out[8:])+uint32(n))
+		for i := 0; i < n; i++ {
+			out = binary.BigEndian.AppendUint32(out, 99)
+			out = append(out, "vendor data"...)
+		}
+		return append(out, jks.ComputeDigest(out, "password")...)
+	}
+
+	ks, err := jks.Parse(withUnknown(1), b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("Parse: %v", err)
+	case len(ks.UnknownEntries) != 1 || ks.UnknownEntries[0].Tag != 99 ||
+		string(ks.UnknownEntries[0].Raw) != "vendor data":
+		t.Fatalf("unexpected unknown entries %+v", ks.UnknownEntries)
+	}
+
+	// editing the keystore keeps the entry
+	ks.Certs = append(ks.Certs, &jks.Cert{
+		Alias: "copy",
+		Raw:   ks.Certs[0].DER(),
+	})
+	repacked, err := ks.Pack(b.Options())
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	ks, err = jks.Parse(repacked, b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("Parse: %v", err)
+	case len(ks.Certs) != 2 || len(ks.UnknownEntries) != 1 ||
+		string(ks.UnknownEntries[0].Raw) != "vendor data":
+		t.Errorf("unknown entry not kept")
+	}
+
+	// so does a ChangeSet, along with the trace
+	opts := *b.Options()
+	opts.Trace = true
+	if ks, err = jks.Parse(withUnknown(1), &opts); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var cs jks.ChangeSet
+	cs.Rename("root", "renamed")
+	if err = cs.Apply(ks, &opts); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(ks.UnknownEntries) != 1 || len(ks.Trace) != 2 {
+		t.Errorf("Apply: %d unknown entries, %d trace entries ≠ 1, 2",
+			len(ks.UnknownEntries), len(ks.Trace))
+	}
+	if repacked, err = ks.Pack(&opts); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	ks, err = jks.Parse(repacked, b.Options())
+	switch {
+	case err != nil:
+		t.Fatalf("Parse: %v", err)
+	case len(ks.Certs) != 1 || ks.Certs[0].Alias != "renamed" ||
+		len(ks.UnknownEntries) != 1 ||
+		string(ks.UnknownEntries[0].Raw) != "vendor data":
+		t.Errorf("unknown entry not kept by ChangeSet")
+	}
+
+	_, err = jks.Parse(withUnknown(2), b.Options())
+	if jks.ErrorCode(err) != jks.CodeMalformed {
+		t.Errorf("expected %s but got %v", jks.CodeMalformed, err)
+	}
+	_, err = ks.PackPKCS12(b.Options())
+	if jks.ErrorCode(err) != jks.CodeUnsupported {
+		t.Errorf("PKCS#12: expected %s but got %v", jks.CodeUnsupported,
+			err)
+	}
+}
@@ -0,0 +1,231 @@
+package jks
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Standard file names within a Kubernetes TLS secret.
+const (
+	SecretCertFile = "tls.crt"
+	SecretKeyFile  = "tls.key"
+	SecretCAFile   = "ca.crt"
+)
+
+// SecretDir is the content of a mounted Kubernetes TLS secret.
+type SecretDir struct {
+	// Keystore holds a single keypair built from tls.crt and tls.key, or
+	// is nil if the secret has no tls.crt.
+	Keystore *Keystore
+
+	// Truststore holds a trusted certificate entry for each certificate
+	// in ca.crt, or is nil if the secret has no ca.crt.
+	Truststore *Keystore
+
+	// Version identifies the revision of the secret that was read: the
+	// target of the "..data" symlink, or empty if the directory does not
+	// use one.
+	Version string
+}
+
+// secretDirRetries bounds how many times LoadSecretDir will retry when the
+// secret is updated while being read.
+const secretDirRetries = 5
+
+// LoadSecretDir reads a mounted Kubernetes secret holding tls.crt, tls.key
+// and/or ca.crt, and returns a keystore whose keypair has the given alias and
+// a truststore.
+//
+// Kubernetes updates mounted secrets by writing the new files to a fresh
+// directory and then atomically replacing the "..data" symlink to point to
+// it. LoadSecretDir resolves that symlink once and reads every file from the
+// directory it names, so that the certificate and key always come from the
+// same revision, and retries if the symlink changes underneath it.
+func LoadSecretDir(dir, alias string) (*SecretDir, error) {
+	dataLink := filepath.Join(dir, "..data")
+	for i := 0; i < secretDirRetries; i++ {
+		version, err := os.Readlink(dataLink)
+		switch {
+		case os.IsNotExist(err):
+			return loadSecretFiles(dir, alias, "")
+		case err != nil:
+			return nil, err
+		}
+
+		target := version
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		sd, err := loadSecretFiles(target, alias, version)
+
+		// if the secret was updated while we read it, the old
+		// directory may have been removed; try again
+		if now, lerr := os.Readlink(dataLink); lerr == nil &&
+			now != version {
+			continue
+		}
+		return sd, err
+	}
+	return nil, errorf(CodeSecretUnstable, "%s: secret changed during "+
+		"each of %d attempts to read it", dir, secretDirRetries)
+}
+
+// loadSecretFiles reads the secret's files from dir.
+func loadSecretFiles(dir, alias, version string) (*SecretDir, error) {
+	read := func(name string) ([]byte, error) {
+		raw, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return raw, err
+	}
+	certPEM, err := read(SecretCertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := read(SecretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := read(SecretCAFile)
+	if err != nil {
+		return nil, err
+	}
+	if certPEM == nil && caPEM == nil {
+		return nil, errorf(CodeMissingData, "%s: neither %s nor %s "+
+			"found", dir, SecretCertFile, SecretCAFile)
+	}
+
+	sd := &SecretDir{Version: version}
+	if certPEM != nil {
+		if keyPEM == nil {
+			return nil, errorf(CodeMissingData, "%s: %s without %s",
+				dir, SecretCertFile, SecretKeyFile)
+		}
+		kp, err := KeypairFromPEM(alias, certPEM, keyPEM)
+		if err != nil {
+			return nil, errorf("", "%s: %v", dir, err)
+		}
+		sd.Keystore = &Keystore{Keypairs: []*Keypair{kp}}
+	}
+	if caPEM != nil {
+		certs, err := CertsFromPEM("ca", caPEM)
+		if err != nil {
+			return nil, errorf("", "%s: %s: %v", dir, SecretCAFile,
+				err)
+		}
+		sd.Truststore = &Keystore{Certs: certs}
+	}
+	return sd, nil
+}
+
+// KeypairFromPEM builds a keypair entry from a PEM certificate chain (leaf
+// first) and a PEM private key in PKCS#1, SEC 1 or PKCS#8 form. It is an error
+// if the key does not match the leaf certificate.
+func KeypairFromPEM(alias string, certPEM, keyPEM []byte) (*Keypair, error) {
+	chain, err := pemCerts(certPEM)
+	if err != nil {
+		return nil, errorf("", "certificate: %v", err)
+	}
+	if len(chain) == 0 {
+		return nil, newError(CodeMissingData, "certificate: no "+
+			"certificates found")
+	}
+	key, err := pemPrivateKey(keyPEM)
+	if err != nil {
+		return nil, errorf("", "private key: %v", err)
+	}
+	match, ok := keyMatches(key, chain[0].PublicKey)
+	if !ok {
+		return nil, newError(CodeUnsupportedKeyAlg, "private key: "+
+			"unsupported key type")
+	}
+	if !match {
+		return nil, newError(CodeKeyMismatch, "private key does not "+
+			"match certificate")
+	}
+
+	kp := &Keypair{
+		Alias:      alias,
+		Timestamp:  time.Now(),
+		PrivateKey: key,
+	}
+	for _, cert := range chain {
+		kp.CertChain = append(kp.CertChain, &KeypairCert{
+			Raw:  cert.Raw,
+			Cert: cert,
+		})
+	}
+	return kp, nil
+}
+
+// CertsFromPEM builds a trusted certificate entry for each certificate in a
+// PEM bundle. A lone certificate is given the alias prefix; otherwise the
+// aliases are prefix-0, prefix-1 and so on.
+func CertsFromPEM(prefix string, bundle []byte) ([]*Cert, error) {
+	certs, err := pemCerts(bundle)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, newError(CodeMissingData, "no certificates found")
+	}
+
+	now := time.Now()
+	var out []*Cert
+	for i, cert := range certs {
+		alias := prefix
+		if len(certs) > 1 {
+			alias = fmt.Sprintf("%s-%d", prefix, i)
+		}
+		out = append(out, &Cert{
+			Alias:     alias,
+			Timestamp: now,
+			Raw:       cert.Raw,
+			Cert:      cert,
+		})
+	}
+	return out, nil
+}
+
+// pemCerts parses every CERTIFICATE block in raw.
+func pemCerts(raw []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// pemPrivateKey parses the first private key block in raw.
+func pemPrivateKey(raw []byte) (crypto.PrivateKey, error) {
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return nil, newError(CodeMissingData, "no private key "+
+				"found")
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+			return parsePEMPrivateKey(block, "")
+		}
+	}
+}
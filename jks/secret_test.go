@@ -0,0 +1,135 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestLoadSecretDir checks loading both a plain directory and one laid out
+// the way Kubernetes mounts secrets, including after an update.
+func TestLoadSecretDir(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "ca")
+
+	// writeSecret writes a secret issued for cn into dir.
+	writeSecret := func(dir, cn string) *x509.Certificate {
+		key := jkstest.ECKey(t, elliptic.P256())
+		leaf := jkstest.Issue(t, key, cn, ca, caKey, false)
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, block := range map[string]*pem.Block{
+			jks.SecretCertFile: {
+				Type: "CERTIFICATE", Bytes: leaf.Raw,
+			},
+			jks.SecretKeyFile: {
+				Type: "PRIVATE KEY", Bytes: keyDER,
+			},
+			jks.SecretCAFile: {
+				Type: "CERTIFICATE", Bytes: ca.Raw,
+			},
+		} {
+			err := ioutil.WriteFile(filepath.Join(dir, name),
+				pem.EncodeToMemory(block), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return leaf
+	}
+
+	check := func(name string, sd *jks.SecretDir, err error,
+		leaf *x509.Certificate, version string,
+	) {
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", name, err)
+		case sd.Keystore == nil || len(sd.Keystore.Keypairs) != 1:
+			t.Errorf("%s: expected one keypair", name)
+		case !sd.Keystore.Keypairs[0].CertChain[0].Cert.Equal(leaf):
+			t.Errorf("%s: wrong leaf certificate", name)
+		case sd.Keystore.Keypairs[0].Alias != "tls":
+			t.Errorf("%s: alias %q ≠ tls", name,
+				sd.Keystore.Keypairs[0].Alias)
+		case sd.Truststore == nil || len(sd.Truststore.Certs) != 1 ||
+			sd.Truststore.Certs[0].Alias != "ca":
+			t.Errorf("%s: expected one CA certificate", name)
+		case sd.Version != version:
+			t.Errorf("%s: version %q ≠ %q", name, sd.Version,
+				version)
+		}
+	}
+
+	// plain directory
+	plain := t.TempDir()
+	leaf := writeSecret(plain, "plain")
+	sd, err := jks.LoadSecretDir(plain, "tls")
+	check("plain", sd, err, leaf, "")
+
+	// Kubernetes layout: files are symlinks via ..data
+	k8s := t.TempDir()
+	for _, v := range []string{"..v1", "..v2"} {
+		if err := os.Mkdir(filepath.Join(k8s, v), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leaf1 := writeSecret(filepath.Join(k8s, "..v1"), "v1")
+	leaf2 := writeSecret(filepath.Join(k8s, "..v2"), "v2")
+	if err := os.Symlink("..v1", filepath.Join(k8s, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		jks.SecretCertFile, jks.SecretKeyFile, jks.SecretCAFile,
+	} {
+		err := os.Symlink(filepath.Join("..data", name),
+			filepath.Join(k8s, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sd, err = jks.LoadSecretDir(k8s, "tls")
+	check("k8s", sd, err, leaf1, "..v1")
+
+	// atomically swap ..data, as the kubelet does
+	tmp := filepath.Join(k8s, "..data_tmp")
+	if err := os.Symlink("..v2", tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(k8s, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	sd, err = jks.LoadSecretDir(k8s, "tls")
+	check("k8s updated", sd, err, leaf2, "..v2")
+}
+
+// TestKeypairFromPEMMismatch checks that a key which does not match the
+// certificate is rejected.
+func TestKeypairFromPEMMismatch(t *testing.T) {
+	key := jkstest.ECKey(t, elliptic.P256())
+	other := jkstest.ECKey(t, elliptic.P256())
+	cert := jkstest.SelfSigned(t, key, "test")
+	keyDER, err := x509.MarshalECPrivateKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = jks.KeypairFromPEM("test",
+		pem.EncodeToMemory(&pem.Block{
+			Type: "CERTIFICATE", Bytes: cert.Raw,
+		}),
+		pem.EncodeToMemory(&pem.Block{
+			Type: "EC PRIVATE KEY", Bytes: keyDER,
+		}))
+	if err == nil {
+		t.Error("expected error for mismatched key")
+	}
+}
@@ -0,0 +1,129 @@
+package jks
+
+import (
+	"crypto/rand"
+)
+
+// SplitSecret splits secret (such as a keystore password) into n shares using
+// Shamir's secret sharing, so that any k of them can be combined with
+// CombineShares to recover it, while fewer than k reveal nothing about the
+// secret other than its length. This allows a high-value keystore to be opened
+// only with a quorum of share holders. We need 2 ≤ k ≤ n ≤ 255.
+//
+// Each share is one byte holding its x-coordinate (1 to n), followed by one
+// byte per byte of secret. Each byte of the secret is split independently,
+// using a random polynomial of degree k-1 over GF(2⁸).
+func SplitSecret(secret []byte, n, k int) ([][]byte, error) {
+	switch {
+	case k < 2:
+		return nil, errorf(CodeInvalidArgument, "threshold %d is "+
+			"below the minimum of 2", k)
+	case n < k:
+		return nil, errorf(CodeInvalidArgument, "%d shares is fewer "+
+			"than the threshold of %d", n, k)
+	case n > 255:
+		return nil, errorf(CodeInvalidArgument, "%d shares is above "+
+			"the maximum of 255", n)
+	case len(secret) == 0:
+		return nil, newError(CodeInvalidArgument, "secret is empty")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 1+len(secret))
+		shares[i][0] = byte(i + 1)
+	}
+
+	// coeffs[0] is the secret byte; the rest are random
+	coeffs := make([]byte, k)
+	for pos, b := range secret {
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, errorf(CodeCryptoFailure, "failed to "+
+				"generate coefficients: %v", err)
+		}
+		coeffs[0] = b
+		for _, share := range shares {
+			share[1+pos] = gfPoly(coeffs, share[0])
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares recovers a secret from shares produced by SplitSecret. At
+// least the threshold number of shares must be given; with fewer, a wrong
+// secret is returned, since shares carry no means of detecting this. The
+// caller should therefore check the result, for instance by verifying a
+// keystore's digest with it.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, newError(CodeInvalidArgument, "need at least 2 "+
+			"shares")
+	}
+	size := len(shares[0])
+	seen := make(map[byte]bool)
+	for i, share := range shares {
+		switch {
+		case len(share) < 2:
+			return nil, errorf(CodeMalformed, "share #%d is too "+
+				"short", i+1)
+		case len(share) != size:
+			return nil, errorf(CodeMalformed, "share #%d has a "+
+				"different length to the others", i+1)
+		case share[0] == 0:
+			return nil, errorf(CodeMalformed, "share #%d has "+
+				"invalid index 0", i+1)
+		case seen[share[0]]:
+			return nil, errorf(CodeInvalidArgument, "share #%d "+
+				"duplicates index %d", i+1, share[0])
+		}
+		seen[share[0]] = true
+	}
+
+	// Lagrange interpolation at x=0; in GF(2⁸) subtraction is XOR
+	secret := make([]byte, size-1)
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(sj[0], sj[0]^si[0]))
+			}
+		}
+		for pos := range secret {
+			secret[pos] ^= gfMul(si[1+pos], basis)
+		}
+	}
+	return secret, nil
+}
+
+// gfPoly evaluates the polynomial with the given coefficients (constant term
+// first) at x, in GF(2⁸).
+func gfPoly(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// gfMul multiplies in GF(2⁸), using the AES reduction polynomial
+// x⁸ + x⁴ + x³ + x + 1. It takes constant time.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		carry := a >> 7
+		a = a<<1 ^ 0x1b&-carry
+		b >>= 1
+	}
+	return p
+}
+
+// gfDiv divides a by b (which must not be zero) in GF(2⁸), multiplying by the
+// inverse of b, which is b²⁵⁴.
+func gfDiv(a, b byte) byte {
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = gfMul(inv, b)
+	}
+	return gfMul(a, inv)
+}
@@ -0,0 +1,52 @@
+package jks_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+)
+
+// TestShamir checks that any threshold-sized subset of shares recovers the
+// secret, and that fewer shares do not.
+func TestShamir(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := jks.SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 || len(shares[0]) != len(secret)+1 {
+		t.Fatalf("unexpected shares %v", shares)
+	}
+
+	t.Run("first three", testShamir(secret, true,
+		shares[0], shares[1], shares[2]))
+	t.Run("last three", testShamir(secret, true,
+		shares[4], shares[2], shares[3]))
+	t.Run("all", testShamir(secret, true, shares...))
+	t.Run("two", testShamir(secret, false, shares[1], shares[3]))
+
+	_, err = jks.CombineShares([][]byte{shares[0], shares[0]})
+	if err == nil {
+		t.Errorf("expected error for duplicate shares")
+	}
+	for _, nk := range [][2]int{{3, 1}, {2, 3}, {256, 2}} {
+		if _, err := jks.SplitSecret(secret, nk[0], nk[1]); err == nil {
+			t.Errorf("n=%d k=%d: expected error", nk[0], nk[1])
+		}
+	}
+}
+
+func testShamir(secret []byte, match bool, shares ...[]byte,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		got, err := jks.CombineShares(shares)
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case bytes.Equal(got, secret) != match:
+			t.Errorf("recovered %q (expected match: %v)", got,
+				match)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package jks
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Format identifies a keystore file format.
+type Format int
+
+const (
+	// FormatUnknown is returned by Sniff for data in none of the formats
+	// we can parse.
+	FormatUnknown Format = iota
+
+	// FormatJKS is the JDK's original JKS format, read by Parse.
+	FormatJKS
+
+	// FormatJCEKS is the SunJCE provider's JCEKS format, also read by
+	// Parse.
+	FormatJCEKS
+
+	// FormatPKCS12 is PKCS#12 (RFC 7292), read by ParsePKCS12.
+	FormatPKCS12
+
+	// FormatBKS is BouncyCastle's BKS format, read by ParseBKS.
+	FormatBKS
+)
+
+var formatNames = []string{
+	FormatUnknown: "unknown",
+	FormatJKS:     "jks",
+	FormatJCEKS:   "jceks",
+	FormatPKCS12:  "pkcs12",
+	FormatBKS:     "bks",
+}
+
+// String returns the name of the format, as accepted by ParseFormat.
+func (f Format) String() string {
+	if f >= 0 && int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat returns the format with the given name (e.g. "pkcs12").
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if strings.EqualFold(n, name) {
+			return Format(f), nil
+		}
+	}
+	return 0, errorf(CodeInvalidArgument, "unknown format %q (expected "+
+		"one of %s)", name, strings.Join(formatNames, ", "))
+}
+
+// Sniff identifies the format of a keystore file from its first few bytes:
+// the magic number of a JKS or JCEKS file (whatever its version), or the outer
+// DER SEQUENCE and version 3 INTEGER of a PKCS#12 PFX. BKS files have no magic
+// number, so are recognised, less surely, by a version of 1 or 2 followed by a
+// plausible salt length. It does not check that the rest of the data is well
+// formed.
+func Sniff(raw []byte) Format {
+	if len(raw) >= 4 {
+		switch binary.BigEndian.Uint32(raw) {
+		case MagicNumber:
+			return FormatJKS
+		case JCEKSMagicNumber:
+			return FormatJCEKS
+		case 1, 2:
+			if len(raw) < 8 {
+				return FormatUnknown
+			}
+			n := binary.BigEndian.Uint32(raw[4:])
+			if n > 0 && n <= bksMaxSaltSize {
+				return FormatBKS
+			}
+			return FormatUnknown
+		}
+	}
+
+	// PFX ::= SEQUENCE { version INTEGER {v3(3)}, ... }
+	if len(raw) < 2 || raw[0] != 0x30 {
+		return FormatUnknown
+	}
+	hdr := 2
+	if raw[1] > 0x80 && raw[1] <= 0x84 {
+		hdr += int(raw[1] & 0x7F)
+	} else if raw[1] >= 0x80 {
+		return FormatUnknown
+	}
+	if len(raw) >= hdr+3 && raw[hdr] == 0x02 && raw[hdr+1] == 0x01 &&
+		raw[hdr+2] == 0x03 {
+		return FormatPKCS12
+	}
+	return FormatUnknown
+}
+
+// ParseAny parses a keystore file of any format we can read, as identified by
+// Sniff, returning the detected format along with the result of Parse,
+// ParsePKCS12 or ParseBKS. This is useful for files whose format is not known
+// in advance.
+func ParseAny(raw []byte, opts *Options) (*Keystore, Format, error) {
+	format := Sniff(raw)
+	switch format {
+	case FormatJKS, FormatJCEKS:
+		ks, err := Parse(raw, opts)
+		return ks, format, err
+	case FormatPKCS12:
+		ks, err := ParsePKCS12(raw, opts)
+		return ks, format, err
+	case FormatBKS:
+		ks, err := ParseBKS(raw, opts)
+		return ks, format, err
+	default:
+		return nil, format, newError(CodeBadMagic, "unrecognised "+
+			"keystore format (expected JKS, JCEKS, PKCS#12 or BKS)")
+	}
+}
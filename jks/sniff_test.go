@@ -0,0 +1,199 @@
+package jks_test
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestSniff checks format detection for each format we can read, and that
+// unrecognised or truncated data is reported as FormatUnknown.
+func TestSniff(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root")
+	p12, err := b.Keystore().PackPKCS12(b.Options())
+	if err != nil {
+		t.Fatalf("PackPKCS12: %v", err)
+	}
+	jceks := b.Bytes()
+	binary.BigEndian.PutUint32(jceks, jks.JCEKSMagicNumber)
+
+	t.Run("jks", testSniff(b.Bytes(), jks.FormatJKS))
+	t.Run("jks v1", testSniff([]byte{0xFE, 0xED, 0xFE, 0xED, 0, 0, 0, 1},
+		jks.FormatJKS))
+	t.Run("jceks", testSniff(jceks, jks.FormatJCEKS))
+	t.Run("pkcs12", testSniff(p12, jks.FormatPKCS12))
+	t.Run("pkcs12 prefix", testSniff(p12[:8], jks.FormatPKCS12))
+	t.Run("other DER", testSniff([]byte{0x30, 0x03, 0x02, 0x01, 0x00},
+		jks.FormatUnknown))
+	t.Run("PEM", testSniff([]byte("-----BEGIN CERTIFICATE-----"),
+		jks.FormatUnknown))
+	t.Run("empty", testSniff(nil, jks.FormatUnknown))
+}
+
+func testSniff(raw []byte, exp jks.Format) func(*testing.T) {
+	return func(t *testing.T) {
+		if f := jks.Sniff(raw); f != exp {
+			t.Errorf("format %v ≠ expected %v", f, exp)
+		}
+	}
+}
+
+// TestParseAnyPKCS12 packs a keystore as PKCS#12 for each compatibility
+// profile and checks that ParseAny detects the format and recovers every
+// entry, including a keypair's chain and a key with its own password.
+func TestParseAnyPKCS12(t *testing.T) {
+	for _, compat := range []jks.Compatibility{jks.Java8, jks.Java17} {
+		t.Run(compat.String(), testParseAnyPKCS12(compat))
+	}
+}
+
+func testParseAnyPKCS12(compat jks.Compatibility) func(*testing.T) {
+	return func(t *testing.T) {
+		caKey := jkstest.ECKey(t, elliptic.P256())
+		ca := jkstest.SelfSigned(t, caKey, "ca")
+		key := jkstest.ECKey(t, elliptic.P256())
+		leaf := jkstest.Issue(t, key, "server", ca, caKey, false)
+
+		b := jkstest.New(t, "password").CA("root").
+			KeypairWithChain("server", key, leaf, ca).
+			RSAKeypair("rsa", 2048).
+			KeyPassword("rsa", "key password")
+		opts := b.Options()
+		opts.Compatibility = compat
+		raw, err := b.Keystore().PackPKCS12(opts)
+		if err != nil {
+			t.Fatalf("PackPKCS12: %v", err)
+		}
+
+		ks, format, err := jks.ParseAny(raw, opts)
+		switch {
+		case err != nil:
+			t.Fatalf("ParseAny: %v", err)
+		case format != jks.FormatPKCS12:
+			t.Errorf("format %v ≠ expected pkcs12", format)
+		case len(ks.Certs) != 1 || ks.Certs[0].Alias != "root":
+			t.Errorf("trusted certificates not recovered: %+v",
+				ks.Certs)
+		case len(ks.Keypairs) != 2:
+			t.Fatalf("got %d keypairs ≠ expected 2",
+				len(ks.Keypairs))
+		}
+		for i, kp := range ks.Keypairs {
+			exp := b.Keystore().Keypairs[i]
+			switch {
+			case kp.Alias != exp.Alias:
+				t.Errorf("alias %q ≠ expected %q", kp.Alias,
+					exp.Alias)
+			case kp.PrivKeyErr != nil:
+				t.Errorf("keypair %q: %v", kp.Alias,
+					kp.PrivKeyErr)
+			case len(kp.CertChain) != len(exp.CertChain):
+				t.Errorf("keypair %q: chain length %d ≠ "+
+					"expected %d", kp.Alias,
+					len(kp.CertChain), len(exp.CertChain))
+			case !kp.CertChain[0].Cert.Equal(exp.CertChain[0].Cert):
+				t.Errorf("keypair %q: wrong leaf", kp.Alias)
+			}
+		}
+		if ks.Keypairs[0].PrivKeyErr == nil &&
+			!key.Equal(ks.Keypairs[0].PrivateKey) {
+			t.Errorf("private key mismatch")
+		}
+
+		ks, err = jks.ParsePKCS12(raw, &jks.Options{
+			Password:              "wrong",
+			CertsOnDigestMismatch: true,
+		})
+		switch {
+		case !errors.Is(err, jks.ErrDigestMismatch):
+			t.Errorf("wrong password: expected ErrDigestMismatch "+
+				"but got %v", err)
+		case len(ks.Certs) != 1 || len(ks.Keypairs) != 0:
+			t.Errorf("wrong password: got %d certs, %d keypairs",
+				len(ks.Certs), len(ks.Keypairs))
+		}
+	}
+}
+
+// TestPKCS12Attributes checks that the friendlyName and localKeyId attributes
+// read by ParsePKCS12 are written back by PackPKCS12, and that a friendly name
+// gives way to an alias that has been changed.
+func TestPKCS12Attributes(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").
+		ECKeypair("server", elliptic.P256()).
+		ECKeypair("other", elliptic.P256())
+	ks := b.Keystore()
+	fp := sha1.Sum(ks.Keypairs[0].CertChain[0].DER())
+	otherFP := sha1.Sum(ks.Keypairs[1].CertChain[0].DER())
+	ks = pkcs12RoundTrip(t, ks, b.Options())
+	switch kp := ks.Keypairs[0]; {
+	case kp.FriendlyName != "server":
+		t.Errorf("friendly name %q ≠ server", kp.FriendlyName)
+	case !bytes.Equal(kp.LocalKeyID, fp[:]):
+		t.Errorf("local key ID %x ≠ fingerprint %x", kp.LocalKeyID,
+			fp)
+	}
+
+	created := time.UnixMilli(1700000000123)
+	ks.Certs[0].FriendlyName = "Root"
+	ks.Certs[0].LocalKeyID = []byte{1, 2, 3}
+	ks.Keypairs[0].FriendlyName = "Server"
+	ks.Keypairs[0].LocalKeyID = []byte("Time 1700000000123")
+	ks.Keypairs[1].Alias = "renamed"
+	ks = pkcs12RoundTrip(t, ks, b.Options())
+	for _, c := range []struct {
+		alias, expAlias string
+		id, expID       []byte
+	}{
+		{ks.Certs[0].Alias, "Root", ks.Certs[0].LocalKeyID,
+			[]byte{1, 2, 3}},
+		{ks.Keypairs[0].Alias, "Server", ks.Keypairs[0].LocalKeyID,
+			[]byte("Time 1700000000123")},
+		{ks.Keypairs[1].Alias, "renamed", ks.Keypairs[1].LocalKeyID,
+			otherFP[:]},
+	} {
+		if c.alias != c.expAlias {
+			t.Errorf("alias %q ≠ %q", c.alias, c.expAlias)
+		}
+		if !bytes.Equal(c.id, c.expID) {
+			t.Errorf("%s: local key ID %q ≠ %q", c.expAlias, c.id,
+				c.expID)
+		}
+	}
+	if ts := ks.Keypairs[0].Timestamp; !ts.Equal(created) {
+		t.Errorf("timestamp %v ≠ %v", ts, created)
+	}
+	for _, kp := range ks.Keypairs {
+		if kp.PrivKeyErr != nil || len(kp.CertChain) != 1 {
+			t.Errorf("%s: keypair not recovered", kp.Alias)
+		}
+	}
+
+	clone := ks.Keypairs[0].Clone()
+	clone.LocalKeyID[0] = 'X'
+	if ks.Keypairs[0].LocalKeyID[0] != 'T' {
+		t.Errorf("Clone shares LocalKeyID")
+	}
+}
+
+// pkcs12RoundTrip packs ks as PKCS#12 and parses the result.
+func pkcs12RoundTrip(t *testing.T, ks *jks.Keystore, opts *jks.Options,
+) *jks.Keystore {
+	t.Helper()
+	raw, err := ks.PackPKCS12(opts)
+	if err != nil {
+		t.Fatalf("PackPKCS12: %v", err)
+	}
+	ks, err = jks.ParsePKCS12(raw, opts)
+	if err != nil {
+		t.Fatalf("ParsePKCS12: %v", err)
+	}
+	return ks
+}
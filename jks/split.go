@@ -0,0 +1,71 @@
+package jks
+
+import "strings"
+
+// Split partitions the keystore's entries into multiple keystores. The
+// partition function is called once for each entry's alias and returns the
+// name of the keystore that the entry should be placed into; the returned map
+// is keyed by these names. Entries keep their original relative order within
+// each resulting keystore. Unknown entries, whose aliases cannot be read, are
+// placed into the keystore named by partition("").
+//
+// The entries themselves are not copied, so the resulting keystores share
+// *Cert, *Keypair, *SecretKey and *UnknownEntry pointers with ks. Aliases are
+// not modified, which means that the Options (including per-key passwords in
+// KeyPasswords) used to parse or pack ks remain valid for packing each of the
+// resulting keystores.
+func (ks *Keystore) Split(partition func(alias string) string,
+) map[string]*Keystore {
+	parts := make(map[string]*Keystore)
+	byAlias := make(map[string]*Keystore)
+	part := func(alias string) *Keystore {
+		name := partition(alias)
+		p, ok := parts[name]
+		if !ok {
+			p = new(Keystore)
+			parts[name] = p
+		}
+		byAlias[NormalizeAlias(alias)] = p
+		return p
+	}
+
+	for _, cert := range ks.Certs {
+		p := part(cert.Alias)
+		p.Certs = append(p.Certs, cert)
+	}
+	for _, kp := range ks.Keypairs {
+		p := part(kp.Alias)
+		p.Keypairs = append(p.Keypairs, kp)
+	}
+	for _, sk := range ks.SecretKeys {
+		p := part(sk.Alias)
+		p.SecretKeys = append(p.SecretKeys, sk)
+	}
+	if len(ks.UnknownEntries) != 0 {
+		p := part("")
+		p.UnknownEntries = append(p.UnknownEntries,
+			ks.UnknownEntries...)
+	}
+
+	// each part keeps the file order of its own entries
+	for _, alias := range ks.Order {
+		if p := byAlias[NormalizeAlias(alias)]; p != nil {
+			p.Order = append(p.Order, alias)
+		}
+	}
+	return parts
+}
+
+// SplitByAliasPrefix partitions the keystore's entries by alias prefix. The
+// prefix is everything in the alias before the first occurrence of sep, so
+// with sep "/" the aliases "prod/server" and "prod/ca" would both be placed
+// into the keystore named "prod". Aliases which do not contain sep are placed
+// into the keystore named "" (the empty string). See Split for details.
+func (ks *Keystore) SplitByAliasPrefix(sep string) map[string]*Keystore {
+	return ks.Split(func(alias string) string {
+		if i := strings.Index(alias, sep); i >= 0 {
+			return alias[:i]
+		}
+		return ""
+	})
+}
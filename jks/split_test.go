@@ -0,0 +1,78 @@
+package jks
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSplitByAliasPrefix checks that entries are partitioned on their alias
+// prefix, that relative ordering is kept, and that unprefixed aliases end up in
+// the "" keystore.
+func TestSplitByAliasPrefix(t *testing.T) {
+	ks := &Keystore{
+		Certs: []*Cert{
+			{Alias: "prod/ca"},
+			{Alias: "test/ca"},
+			{Alias: "global-ca"},
+			{Alias: "prod/ca2"},
+		},
+		Keypairs: []*Keypair{
+			{Alias: "test/server"},
+			{Alias: "prod/server"},
+		},
+		SecretKeys: []*SecretKey{
+			{Alias: "test/aes"},
+		},
+		UnknownEntries: []*UnknownEntry{{Tag: 4}},
+	}
+
+	parts := ks.SplitByAliasPrefix("/")
+	exp := map[string][]string{
+		"prod": {"prod/ca", "prod/ca2", "prod/server"},
+		"test": {"test/ca", "test/server", "test/aes"},
+		"":     {"global-ca", "unknown"},
+	}
+
+	got := make(map[string][]string)
+	for name, p := range parts {
+		for _, cert := range p.Certs {
+			got[name] = append(got[name], cert.Alias)
+		}
+		for _, kp := range p.Keypairs {
+			got[name] = append(got[name], kp.Alias)
+		}
+		for _, sk := range p.SecretKeys {
+			got[name] = append(got[name], sk.Alias)
+		}
+		for range p.UnknownEntries {
+			got[name] = append(got[name], "unknown")
+		}
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("split result %v ≠ expected %v", got, exp)
+	}
+}
+
+// TestSplitOrder checks that each part keeps the file order of its entries,
+// interleaved across the entry types.
+func TestSplitOrder(t *testing.T) {
+	ks := &Keystore{
+		Certs:    []*Cert{{Alias: "a/ca"}, {Alias: "b/ca"}},
+		Keypairs: []*Keypair{{Alias: "a/key"}, {Alias: "b/key"}},
+		Order:    []string{"b/key", "a/key", "gone/ca", "a/ca", "b/ca"},
+	}
+
+	got := make(map[string][]string)
+	for name, p := range ks.SplitByAliasPrefix("/") {
+		for e := range p.Entries() {
+			got[name] = append(got[name], e.EntryAlias())
+		}
+	}
+	exp := map[string][]string{
+		"a": {"a/key", "a/ca"},
+		"b": {"b/key", "b/ca"},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("split order %v ≠ expected %v", got, exp)
+	}
+}
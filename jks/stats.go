@@ -0,0 +1,139 @@
+package jks
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// Stats summarises a keystore's content, for dashboards and summary output.
+// Like a Manifest, it never contains key material or passwords.
+type Stats struct {
+	// TrustedCerts, Keypairs, SecretKeys and UnknownEntries count the
+	// entries of each type.
+	TrustedCerts   int `json:"trustedCerts" yaml:"trustedCerts"`
+	Keypairs       int `json:"keypairs" yaml:"keypairs"`
+	SecretKeys     int `json:"secretKeys" yaml:"secretKeys"`
+	UnknownEntries int `json:"unknownEntries" yaml:"unknownEntries"`
+
+	// Certificates counts every certificate held, trusted or in a
+	// keypair's chain; CertErrors counts those that could not be parsed.
+	Certificates int `json:"certificates" yaml:"certificates"`
+	CertErrors   int `json:"certErrors" yaml:"certErrors"`
+
+	// KeyErrors counts the private and secret keys that could not be
+	// decrypted or parsed, including those left encrypted.
+	KeyErrors int `json:"keyErrors" yaml:"keyErrors"`
+
+	// KeyAlgorithms counts the private and secret keys by algorithm and
+	// size, e.g. "RSA 2048", "EC 256" or "AES 128". A private key's
+	// algorithm is taken from its leaf certificate, so that keys left
+	// encrypted are counted too; those whose algorithm cannot be told are
+	// counted as "unknown".
+	KeyAlgorithms map[string]int `json:"keyAlgorithms" yaml:"keyAlgorithms"`
+
+	// Oldest and Newest are the earliest and latest entry timestamps. They
+	// are zero if the keystore has no entries.
+	Oldest time.Time `json:"oldest" yaml:"oldest"`
+	Newest time.Time `json:"newest" yaml:"newest"`
+
+	// EncodedSize estimates the size of the keystore as a JKS or JCEKS
+	// file, in bytes. It is exact but for private keys which have not
+	// been encrypted yet, whose size depends on how they will be
+	// protected.
+	EncodedSize int `json:"encodedSize" yaml:"encodedSize"`
+}
+
+// Stats returns a summary of the keystore's content.
+func (ks *Keystore) Stats() *Stats {
+	s := &Stats{
+		KeyAlgorithms:  make(map[string]int),
+		UnknownEntries: len(ks.UnknownEntries),
+		EncodedSize:    ks.packSizeHint(),
+	}
+	for _, e := range ks.entries() {
+		switch e := e.(type) {
+		case *Cert:
+			s.TrustedCerts++
+			s.countCert(e.Cert)
+			s.timestamp(e.Timestamp)
+		case *Keypair:
+			s.Keypairs++
+			for _, c := range e.CertChain {
+				if c != nil {
+					s.countCert(c.Cert)
+				}
+			}
+			if e.PrivKeyErr != nil {
+				s.KeyErrors++
+			}
+			if e.EncryptedKey != nil {
+				// Refine packSizeHint's guess with the
+				// size of the key as read.
+				s.EncodedSize += len(e.EncryptedKey) -
+					packKeySizeHint
+			}
+			s.KeyAlgorithms[keypairAlgorithm(e)]++
+			s.timestamp(e.Timestamp)
+		case *SecretKey:
+			s.SecretKeys++
+			if e.KeyErr != nil {
+				s.KeyErrors++
+			}
+			algo := "unknown"
+			if e.Algorithm != "" {
+				algo = fmt.Sprintf("%s %d", e.Algorithm,
+					len(e.Key)*8)
+			}
+			s.KeyAlgorithms[algo]++
+			s.timestamp(e.Timestamp)
+			s.EncodedSize += 14 + len(e.Alias) + len(e.SealedKey)
+		}
+	}
+	return s
+}
+
+// countCert counts a certificate, which is nil if it could not be parsed.
+func (s *Stats) countCert(cert *x509.Certificate) {
+	s.Certificates++
+	if cert == nil {
+		s.CertErrors++
+	}
+}
+
+// timestamp updates Oldest and Newest with an entry's timestamp.
+func (s *Stats) timestamp(ts time.Time) {
+	if s.Oldest.IsZero() || ts.Before(s.Oldest) {
+		s.Oldest = ts
+	}
+	if s.Newest.IsZero() || ts.After(s.Newest) {
+		s.Newest = ts
+	}
+}
+
+// keypairAlgorithm returns the algorithm and size of a keypair's key, as
+// counted in Stats.KeyAlgorithms.
+func keypairAlgorithm(kp *Keypair) string {
+	var pub interface{}
+	switch k := kp.PrivateKey.(type) {
+	case nil:
+	case *dsa.PrivateKey:
+		pub = &k.PublicKey
+	case interface{ Public() crypto.PublicKey }:
+		pub = k.Public()
+	}
+	if len(kp.CertChain) != 0 && kp.CertChain[0] != nil &&
+		kp.CertChain[0].Cert != nil {
+		pub = kp.CertChain[0].Cert.PublicKey
+	}
+	if pub == nil {
+		return "unknown"
+	}
+	algo, bits := publicKeyInfo(pub)
+	if bits == 0 {
+		return algo
+	}
+	return fmt.Sprintf("%s %d", algo, bits)
+}
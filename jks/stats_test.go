@@ -0,0 +1,78 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestStats checks the counts Stats gives for a keystore read from a file, and
+// that its size estimate is exact once the private keys have been encrypted.
+func TestStats(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "ca")
+	key := jkstest.ECKey(t, elliptic.P384())
+	leaf := jkstest.Issue(t, key, "leaf", ca, caKey, false)
+	b := jkstest.New(t, "password").
+		Cert("ca", ca).
+		ECKeypair("ec", elliptic.P256()).
+		KeypairWithChain("chain", key, leaf, ca).
+		RSAKeypair("rsa", 1024)
+	raw := b.Bytes()
+	opts := *b.Options()
+	opts.KeyPasswords = map[string]string{"chain": "wrong"}
+	ks, err := jks.Parse(raw, &opts)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	oldest := time.UnixMilli(1e12)
+	newest := time.UnixMilli(2e12)
+	ks.Certs[0].Timestamp = newest
+	ks.Keypairs[1].Timestamp = oldest
+
+	st := ks.Stats()
+	for _, c := range []struct {
+		name     string
+		got, exp int
+	}{
+		{"TrustedCerts", st.TrustedCerts, 1},
+		{"Keypairs", st.Keypairs, 3},
+		{"SecretKeys", st.SecretKeys, 0},
+		{"UnknownEntries", st.UnknownEntries, 0},
+		{"Certificates", st.Certificates, 5},
+		{"CertErrors", st.CertErrors, 0},
+		{"KeyErrors", st.KeyErrors, 1},
+		{"EncodedSize", st.EncodedSize, len(raw)},
+		{"RSA 1024 keys", st.KeyAlgorithms["RSA 1024"], 1},
+		{"EC 256 keys", st.KeyAlgorithms["EC 256"], 1},
+		{"EC 384 keys", st.KeyAlgorithms["EC 384"], 1},
+		{"key algorithms", len(st.KeyAlgorithms), 3},
+	} {
+		if c.got != c.exp {
+			t.Errorf("%s: %d ≠ %d", c.name, c.got, c.exp)
+		}
+	}
+	if !st.Oldest.Equal(oldest) {
+		t.Errorf("Oldest: %v ≠ %v", st.Oldest, oldest)
+	}
+	if !st.Newest.Equal(newest) {
+		t.Errorf("Newest: %v ≠ %v", st.Newest, newest)
+	}
+
+	ks = &jks.Keystore{SecretKeys: []*jks.SecretKey{
+		{Alias: "aes", Algorithm: "AES", Key: make([]byte, 16)},
+		{Alias: "sealed"},
+	}}
+	st = ks.Stats()
+	if st.SecretKeys != 2 || st.KeyAlgorithms["AES 128"] != 1 ||
+		st.KeyAlgorithms["unknown"] != 1 {
+		t.Errorf("secret keys: got %d, %v", st.SecretKeys,
+			st.KeyAlgorithms)
+	}
+	if !(&jks.Keystore{}).Stats().Oldest.IsZero() {
+		t.Errorf("empty keystore has an oldest entry")
+	}
+}
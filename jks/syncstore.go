@@ -0,0 +1,127 @@
+package jks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+)
+
+// SyncKeystore guards a Keystore shared between goroutines, such as one that
+// a long-running server serves certificates from while an administrative
+// endpoint adds entries or a watcher reloads the file. Keystore itself does no
+// locking. Reads, through the methods below or View, may run concurrently;
+// Update, Replace and Reload wait for them to finish and run one at a time.
+// The zero value holds an empty keystore.
+type SyncKeystore struct {
+	mu sync.RWMutex
+	ks *Keystore
+}
+
+// NewSyncKeystore returns a SyncKeystore holding ks, which the caller must not
+// use directly from then on. If ks is nil, the keystore is empty.
+func NewSyncKeystore(ks *Keystore) *SyncKeystore {
+	return &SyncKeystore{ks: ks}
+}
+
+// keystore returns the keystore held; s.mu must be held.
+func (s *SyncKeystore) keystore() *Keystore {
+	if s.ks == nil {
+		s.ks = new(Keystore)
+	}
+	return s.ks
+}
+
+// View calls fn with the keystore under a read lock, returning fn's error. fn
+// must not modify the keystore, nor keep any reference into it once it
+// returns, since a later Update may change what it points to.
+func (s *SyncKeystore) View(fn func(ks *Keystore) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.ks == nil {
+		return fn(new(Keystore))
+	}
+	return fn(s.ks)
+}
+
+// Update calls fn with the keystore under a write lock, returning fn's error.
+// fn may modify the keystore as it likes, but must not keep any reference into
+// it once it returns. Changes made before fn returns an error are kept.
+func (s *SyncKeystore) Update(fn func(ks *Keystore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.keystore())
+}
+
+// Replace swaps in ks, which the caller must not use directly from then on,
+// and returns the keystore it replaces.
+func (s *SyncKeystore) Replace(ks *Keystore) *Keystore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.keystore()
+	s.ks = ks
+	return prev
+}
+
+// Reload parses raw with ParseAny and opts and, if that succeeds, swaps the
+// result in, so that a file which has been rewritten elsewhere can be picked
+// up. Parsing happens without holding the lock, so reads carry on meanwhile.
+// Nothing is done if raw has the ETag of the keystore held. On error, the
+// keystore held is kept.
+func (s *SyncKeystore) Reload(raw []byte, opts *Options) error {
+	tag := ETag(raw)
+	s.mu.RLock()
+	same := s.ks != nil && s.ks.ETag == tag
+	s.mu.RUnlock()
+	if same {
+		return nil
+	}
+
+	ks, _, err := ParseAny(raw, opts)
+	if err != nil {
+		return err
+	}
+	ks.ETag = tag
+	s.Replace(ks)
+	return nil
+}
+
+// TLSCertificate is Keystore.TLSCertificate under a read lock.
+func (s *SyncKeystore) TLSCertificate(alias string) (tls.Certificate, error) {
+	var cert tls.Certificate
+	err := s.View(func(ks *Keystore) (err error) {
+		cert, err = ks.TLSCertificate(alias)
+		return err
+	})
+	return cert, err
+}
+
+// TLSCertificates is Keystore.TLSCertificates under a read lock.
+func (s *SyncKeystore) TLSCertificates() ([]tls.Certificate, error) {
+	var certs []tls.Certificate
+	err := s.View(func(ks *Keystore) (err error) {
+		certs, err = ks.TLSCertificates()
+		return err
+	})
+	return certs, err
+}
+
+// CertPool is Keystore.CertPool under a read lock.
+func (s *SyncKeystore) CertPool(withChains bool) *x509.CertPool {
+	var pool *x509.CertPool
+	s.View(func(ks *Keystore) error {
+		pool = ks.CertPool(withChains)
+		return nil
+	})
+	return pool
+}
+
+// Pack is Keystore.Pack under a read lock, so that a consistent snapshot of the
+// keystore is written.
+func (s *SyncKeystore) Pack(opts *Options) ([]byte, error) {
+	var raw []byte
+	err := s.View(func(ks *Keystore) (err error) {
+		raw, err = ks.Pack(opts)
+		return err
+	})
+	return raw, err
+}
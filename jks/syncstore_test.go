@@ -0,0 +1,78 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestSyncKeystore checks that a SyncKeystore can be read while it is updated
+// and reloaded; run with -race to check its locking.
+func TestSyncKeystore(t *testing.T) {
+	b := jkstest.New(t, "password").ECKeypair("server", elliptic.P256())
+	opts := b.Options()
+	raw := b.Bytes()
+	s := jks.NewSyncKeystore(nil)
+	if err := s.Reload(raw, opts); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := s.TLSCertificate("server")
+				if err != nil {
+					t.Errorf("TLSCertificate: %v", err)
+					return
+				}
+				s.CertPool(true)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		ca := jkstest.New(t, "password").CA(fmt.Sprintf("ca%d", i))
+		err := s.Update(func(ks *jks.Keystore) error {
+			return ks.AddCert(ca.Keystore().Certs[0],
+				jks.CollisionError)
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	if err := s.Reload(raw, opts); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	wg.Wait()
+
+	// the same file is not reloaded, so the added entries stay
+	var certs int
+	s.View(func(ks *jks.Keystore) error {
+		certs = len(ks.Certs)
+		return nil
+	})
+	if certs != 20 {
+		t.Errorf("certificates %d ≠ expected 20", certs)
+	}
+
+	// a different file replaces them
+	if err := s.Reload(b.CA("new").Bytes(), opts); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, err := s.Pack(opts); err != nil {
+		t.Errorf("Pack: %v", err)
+	}
+	s.View(func(ks *jks.Keystore) error {
+		certs = len(ks.Certs)
+		return nil
+	})
+	if certs != 1 {
+		t.Errorf("certificates %d ≠ expected 1", certs)
+	}
+}
@@ -0,0 +1,102 @@
+package jks
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// TLSCertificate returns the keypair entry with the given alias as a
+// tls.Certificate, ready to be used in tls.Config.Certificates. The
+// certificate chain is passed on as is, leaf first, and Leaf is set to the
+// parsed leaf certificate. An error is returned if there is no such keypair,
+// if its private key could not be decrypted, or if the key does not match the
+// leaf certificate.
+func (ks *Keystore) TLSCertificate(alias string) (tls.Certificate, error) {
+	_, kpIdx := ks.findAlias(alias)
+	if kpIdx < 0 {
+		return tls.Certificate{}, errorf(CodeNoSuchAlias, "no keypair "+
+			"with alias %q", alias)
+	}
+	return ks.Keypairs[kpIdx].tlsCertificate()
+}
+
+// TLSCertificates returns every keypair entry as a tls.Certificate, in the
+// order they appear in Keypairs. Archived keypairs (see ArchiveAlias) are
+// skipped, so that a rotated-out certificate is not served. It fails if any
+// other keypair cannot be converted; see TLSCertificate.
+func (ks *Keystore) TLSCertificates() ([]tls.Certificate, error) {
+	certs := make([]tls.Certificate, 0, len(ks.Keypairs))
+	for _, kp := range ks.Keypairs {
+		if _, _, archived := ParseArchivedAlias(kp.Alias); archived {
+			continue
+		}
+		cert, err := kp.tlsCertificate()
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// tlsCertificate converts a keypair to a tls.Certificate, checking that its
+// private key matches its leaf certificate.
+func (kp *Keypair) tlsCertificate() (tls.Certificate, error) {
+	switch {
+	case kp.PrivKeyErr != nil:
+		return tls.Certificate{}, errorf("", "key %q: %v", kp.Alias,
+			kp.PrivKeyErr)
+	case len(kp.CertChain) == 0 || kp.CertChain[0].Cert == nil:
+		return tls.Certificate{}, errorf(CodeMissingData, "key %q "+
+			"has no usable certificate", kp.Alias)
+	}
+
+	leaf := kp.CertChain[0].Cert
+	signer, ok := kp.PrivateKey.(crypto.Signer)
+	if !ok {
+		return tls.Certificate{}, errorf(CodeUnsupportedKeyAlg,
+			"key %q: cannot sign with %T", kp.Alias, kp.PrivateKey)
+	}
+	if match, _ := keyMatches(signer, leaf.PublicKey); !match {
+		return tls.Certificate{}, errorf(CodeKeyMismatch, "key %q "+
+			"does not match its certificate", kp.Alias)
+	}
+
+	cert := tls.Certificate{
+		PrivateKey: signer,
+		Leaf:       leaf,
+	}
+	for _, c := range kp.CertChain {
+		cert.Certificate = append(cert.Certificate, c.DER())
+	}
+	return cert, nil
+}
+
+// CertPool returns a pool holding every trusted certificate entry, for use as
+// tls.Config.RootCAs or ClientCAs. If withChains is set, the certificates in
+// each keypair's chain are added as well, leaf included. Certificates which
+// could not be parsed, and archived entries (see ArchiveAlias), are left out.
+func (ks *Keystore) CertPool(withChains bool) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range ks.Certs {
+		_, _, archived := ParseArchivedAlias(cert.Alias)
+		if cert.Cert != nil && !archived {
+			pool.AddCert(cert.Cert)
+		}
+	}
+	if withChains {
+		for _, kp := range ks.Keypairs {
+			if _, _, archived := ParseArchivedAlias(
+				kp.Alias); archived {
+				continue
+			}
+			for _, c := range kp.CertChain {
+				if c.Cert != nil {
+					pool.AddCert(c.Cert)
+				}
+			}
+		}
+	}
+	return pool
+}
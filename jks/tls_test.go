@@ -0,0 +1,153 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestTLSCertificate checks that a keypair converted with TLSCertificate can
+// serve a TLS handshake to a client trusting its CA, and that unusable
+// keypairs are rejected.
+func TestTLSCertificate(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "ca")
+	key := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, key, "server", ca, caKey, false)
+	b := jkstest.New(t, "password").CA("root").
+		KeypairWithChain("server", key, leaf, ca).
+		KeypairWithChain("mismatch", jkstest.ECKey(t, elliptic.P256()),
+			leaf)
+	ks := b.Keystore()
+
+	cert, err := ks.TLSCertificate("server")
+	switch {
+	case err != nil:
+		t.Fatalf("TLSCertificate: %v", err)
+	case len(cert.Certificate) != 2:
+		t.Errorf("chain length %d ≠ expected 2",
+			len(cert.Certificate))
+	case !cert.Leaf.Equal(leaf):
+		t.Errorf("wrong leaf certificate")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	srvConn, cliConn := net.Pipe()
+	defer cliConn.Close()
+	go func() {
+		defer srvConn.Close()
+		_ = tls.Server(srvConn, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}).Handshake()
+	}()
+	if err = tls.Client(cliConn, &tls.Config{
+		RootCAs:    roots,
+		ServerName: "server",
+	}).Handshake(); err != nil {
+		t.Errorf("handshake: %v", err)
+	}
+
+	_, err = ks.TLSCertificate("mismatch")
+	if code := jks.ErrorCode(err); code != jks.CodeKeyMismatch {
+		t.Errorf("mismatch: error code %s ≠ expected %s (%v)", code,
+			jks.CodeKeyMismatch, err)
+	}
+	_, err = ks.TLSCertificate("root")
+	if code := jks.ErrorCode(err); code != jks.CodeNoSuchAlias {
+		t.Errorf("root: error code %s ≠ expected %s (%v)", code,
+			jks.CodeNoSuchAlias, err)
+	}
+	if _, err = ks.TLSCertificates(); err == nil {
+		t.Errorf("TLSCertificates: expected error for mismatched key")
+	}
+
+	ks.Keypairs = ks.Keypairs[:1]
+	certs, err := ks.TLSCertificates()
+	switch {
+	case err != nil:
+		t.Errorf("TLSCertificates: %v", err)
+	case len(certs) != 1:
+		t.Errorf("got %d certificates ≠ expected 1", len(certs))
+	}
+}
+
+// TestCertPool checks which certificates CertPool trusts, with and without
+// keypair chains.
+func TestCertPool(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "ca")
+	key := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, key, "server", ca, caKey, false)
+	root := jkstest.SelfSigned(t, jkstest.ECKey(t, elliptic.P256()),
+		"root")
+	ks := jkstest.New(t, "password").Cert("root", root).
+		KeypairWithChain("server", key, leaf, ca).Keystore()
+
+	t.Run("certs only", testCertPool(ks.CertPool(false), root, ca,
+		false))
+	t.Run("with chains", testCertPool(ks.CertPool(true), root, ca, true))
+}
+
+func testCertPool(pool *x509.CertPool, trusted, chain *x509.Certificate,
+	expChain bool,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		verify := func(cert *x509.Certificate) bool {
+			_, err := cert.Verify(x509.VerifyOptions{Roots: pool})
+			return err == nil
+		}
+		if !verify(trusted) {
+			t.Errorf("trusted certificate not in pool")
+		}
+		if got := verify(chain); got != expChain {
+			t.Errorf("chain certificate in pool: %t ≠ expected %t",
+				got, expChain)
+		}
+	}
+}
+
+// TestTLSArchived checks that an archived keypair ahead of the live one is
+// neither served by TLSCertificates nor trusted by CertPool.
+func TestTLSArchived(t *testing.T) {
+	caKey := jkstest.ECKey(t, elliptic.P256())
+	ca := jkstest.SelfSigned(t, caKey, "ca")
+	oldCAKey := jkstest.ECKey(t, elliptic.P256())
+	oldCA := jkstest.SelfSigned(t, oldCAKey, "old-ca")
+	oldKey := jkstest.ECKey(t, elliptic.P256())
+	oldLeaf := jkstest.Issue(t, oldKey, "server", oldCA, oldCAKey, false)
+	key := jkstest.ECKey(t, elliptic.P256())
+	leaf := jkstest.Issue(t, key, "server", ca, caKey, false)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ks := jkstest.New(t, "password").
+		Cert(jks.ArchivedAlias("old-ca", at), oldCA).
+		KeypairWithChain(jks.ArchivedAlias("server", at), oldKey,
+			oldLeaf, oldCA).
+		KeypairWithChain("server", key, leaf, ca).Keystore()
+
+	certs, err := ks.TLSCertificates()
+	switch {
+	case err != nil:
+		t.Fatalf("TLSCertificates: %v", err)
+	case len(certs) != 1:
+		t.Fatalf("got %d certificates ≠ expected 1", len(certs))
+	case !certs[0].Leaf.Equal(leaf):
+		t.Errorf("archived certificate served")
+	}
+
+	pool := ks.CertPool(true)
+	if _, err = oldLeaf.Verify(x509.VerifyOptions{
+		Roots: pool,
+	}); err == nil {
+		t.Errorf("CertPool trusts the archived chain")
+	}
+	if _, err = leaf.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Errorf("CertPool: live chain: %v", err)
+	}
+}
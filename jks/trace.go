@@ -0,0 +1,105 @@
+package jks
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EntryTrace records where Parse found an entry in a JKS or JCEKS file, and
+// what went wrong with it, so that a corrupt file can be triaged without a hex
+// dump. Parse records one for each entry it reads in Keystore.Trace if
+// Options.Trace is set, including the entry at which it failed, if it did.
+type EntryTrace struct {
+	// Index is the entry's position in the file, counting from zero.
+	Index int
+
+	// Offset is the position in the file of the entry's type tag, and
+	// Length the number of bytes it occupies, tag included. For an entry
+	// that could not be read, Length covers only the bytes read before
+	// the problem was found.
+	Offset int64
+	Length int64
+
+	// Tag is the entry's type tag: 1 for a keypair, 2 for a trusted
+	// certificate and 3 for a secret key. It is 0 if the tag itself could
+	// not be read.
+	Tag uint32
+
+	// Alias is the entry's alias, if the entry could be read.
+	Alias string
+
+	// Warnings lists the problems with the entry which did not stop the
+	// file from being read: certificates that could not be parsed, and
+	// keys that could not be decrypted or parsed.
+	Warnings []error
+
+	// Err is the error which stopped Parse at this entry, if any.
+	Err error
+
+	entry Entry
+}
+
+// tagNames describes each type tag.
+var tagNames = map[uint32]string{
+	1: "keypair",
+	2: "trusted certificate",
+	3: "secret key",
+}
+
+// String describes the entry on one line, e.g. `entry 37 at offset 0x4A310
+// (1234 bytes): keypair "server": <error>`.
+func (t *EntryTrace) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "entry %d at offset 0x%X (%d bytes)", t.Index,
+		t.Offset, t.Length)
+	switch name, ok := tagNames[t.Tag]; {
+	case ok:
+		fmt.Fprintf(&b, ": %s", name)
+	case t.Tag != 0 || t.Length >= 4:
+		fmt.Fprintf(&b, ": unknown type %d", t.Tag)
+	}
+	if t.Alias != "" {
+		fmt.Fprintf(&b, " %q", t.Alias)
+	}
+	for _, err := range t.Warnings {
+		fmt.Fprintf(&b, "; warning: %v", err)
+	}
+	if t.Err != nil {
+		fmt.Fprintf(&b, "; error: %v", t.Err)
+	}
+	return b.String()
+}
+
+// traceWarnings fills in the Alias and Warnings of each EntryTrace from its
+// entry, once any keys have been decrypted.
+func (ks *Keystore) traceWarnings() {
+	for _, t := range ks.Trace {
+		var warnings []error
+		switch e := t.entry.(type) {
+		case *Cert:
+			if e.CertErr != nil {
+				warnings = append(warnings, e.CertErr)
+			}
+		case *Keypair:
+			if err := e.PrivKeyErr; err != nil &&
+				err != ErrKeyNotDecrypted {
+				warnings = append(warnings, err)
+			}
+			for i, c := range e.CertChain {
+				if c.CertErr != nil {
+					warnings = append(warnings, errorf("",
+						"certificate chain entry #%d: "+
+							"%v", i+1, c.CertErr))
+				}
+			}
+		case *SecretKey:
+			if err := e.KeyErr; err != nil &&
+				err != ErrKeyNotDecrypted {
+				warnings = append(warnings, err)
+			}
+		case nil:
+			continue
+		}
+		t.Alias, t.Warnings = t.entry.EntryAlias(), warnings
+	}
+}
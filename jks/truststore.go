@@ -0,0 +1,175 @@
+package jks
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultCacertsPassword is the password of the cacerts truststore shipped with
+// the JDK, which few installations change.
+const DefaultCacertsPassword = "changeit"
+
+// systemRootFiles lists where Linux distributions and the BSDs keep their
+// bundle of trusted CA certificates, in the order that crypto/x509 searches
+// them.
+var systemRootFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian, Ubuntu
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora, RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // RHEL 7
+	"/etc/ssl/cert.pem",                                 // Alpine, BSDs
+	"/usr/local/share/certs/ca-root-nss.crt",            // FreeBSD
+}
+
+// NewTruststoreFromSystemRoots returns a keystore holding a trusted
+// certificate entry for each CA certificate in the operating system's bundle,
+// the one crypto/x509 and OpenSSL use, so that JVM services may trust the same
+// CAs as everything else on the host. As for crypto/x509, the SSL_CERT_FILE
+// environment variable overrides the bundle's location. The bundle can only
+// be found on Linux and the BSDs; elsewhere an error with CodeUnsupported is
+// returned.
+//
+// Each entry's alias is made from the certificate's subject common name, in
+// the style of the JDK's cacerts (e.g. "isrgrootx1"), with a numeric suffix if
+// needed to keep it unique. Its timestamp is the bundle's modification time.
+func NewTruststoreFromSystemRoots() (*Keystore, error) {
+	files := systemRootFiles
+	if f := os.Getenv("SSL_CERT_FILE"); f != "" {
+		files = []string{f}
+	} else if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return nil, errorf(CodeUnsupported, "system root certificates "+
+			"are not kept in a file on %s", runtime.GOOS)
+	}
+
+	for _, f := range files {
+		raw, err := os.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ts := time.Now()
+		if fi, err := os.Stat(f); err == nil {
+			ts = fi.ModTime()
+		}
+		ks := new(Keystore)
+		if err = ks.addTrustedPEM(raw, ts); err != nil {
+			return nil, errorf("", "%s: %v", f, err)
+		}
+		return ks, nil
+	}
+	return nil, errorf(CodeMissingData, "no system root certificate "+
+		"bundle found (tried %s)", strings.Join(files, ", "))
+}
+
+// addTrustedPEM adds each certificate in a PEM bundle as a trusted certificate
+// entry, with an alias made from its subject by rootAlias. Other PEM blocks are
+// ignored. Certificates which cannot be parsed are kept, as Parse keeps them.
+func (ks *Keystore) addTrustedPEM(bundle []byte, ts time.Time) error {
+	for rest := bundle; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert := &Cert{Timestamp: ts, Raw: block.Bytes}
+		cert.Cert, cert.CertErr = x509.ParseCertificate(block.Bytes)
+		cert.Alias = rootAlias(cert.Cert)
+		if ks.hasAlias(cert.Alias) {
+			cert.Alias = ks.uniqueAlias(cert.Alias)
+		}
+		ks.Certs = append(ks.Certs, cert)
+	}
+	if len(ks.Certs) == 0 {
+		return newError(CodeMissingData, "no certificates found")
+	}
+	return nil
+}
+
+// rootAlias returns an alias for a CA certificate in the style of the JDK's
+// cacerts: the subject common name (or, lacking one, organisation) lowercased,
+// keeping only letters and digits.
+func rootAlias(cert *x509.Certificate) string {
+	var name string
+	if cert != nil {
+		name = cert.Subject.CommonName
+		if name == "" && len(cert.Subject.Organization) != 0 {
+			name = cert.Subject.Organization[0]
+		}
+	}
+	alias := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+	if alias == "" {
+		return "cert"
+	}
+	return alias
+}
+
+// JavaCacertsPath returns the path of the cacerts truststore of the local
+// JDK: lib/security/cacerts (or jre/lib/security/cacerts, for Java 8) under
+// $JAVA_HOME or, if that is not set, under the installation holding the java
+// command found on $PATH. An error with CodeMissingData is returned if no
+// truststore is found.
+func JavaCacertsPath() (string, error) {
+	home := os.Getenv("JAVA_HOME")
+	if home == "" {
+		java, err := exec.LookPath("java")
+		if err != nil {
+			return "", errorf(CodeMissingData, "JAVA_HOME not set "+
+				"and java not found on PATH")
+		}
+		if java, err = filepath.EvalSymlinks(java); err != nil {
+			return "", err
+		}
+		home = filepath.Dir(filepath.Dir(java)) // strip bin/java
+	}
+	for _, dir := range []string{"lib", filepath.Join("jre", "lib")} {
+		path := filepath.Join(home, dir, "security", "cacerts")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errorf(CodeMissingData, "no cacerts truststore found "+
+		"under %s", home)
+}
+
+// LoadJavaCacerts finds the local JDK's cacerts truststore with
+// JavaCacertsPath and parses it, so that it may be extended and written out
+// for JVM services to use. cacerts is a JKS file up to Java 17 and a PKCS#12
+// file after; either is accepted. If opts is nil, the file's digest is
+// verified with DefaultCacertsPassword.
+func LoadJavaCacerts(opts *Options) (*Keystore, error) {
+	path, err := JavaCacertsPath()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = DefaultOptions()
+		opts.Password = DefaultCacertsPassword
+	}
+	ks, _, err := ParseAny(raw, opts)
+	if err != nil {
+		return ks, errorf("", "%s: %v", path, err)
+	}
+	ks.ETag = ETag(raw)
+	return ks, nil
+}
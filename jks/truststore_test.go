@@ -0,0 +1,76 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestNewTruststoreFromSystemRoots checks that the bundle named by
+// SSL_CERT_FILE is read, with aliases made from the subject common names.
+func TestNewTruststoreFromSystemRoots(t *testing.T) {
+	var bundle []byte
+	for _, cn := range []string{"Example Root CA", "Example Root CA",
+		"ISRG Root X1"} {
+		cert := jkstest.SelfSigned(t,
+			jkstest.ECKey(t, elliptic.P256()), cn)
+		bundle = append(bundle, (&jks.Cert{Cert: cert}).ToPEM()...)
+	}
+	path := filepath.Join(t.TempDir(), "bundle.pem")
+	if err := os.WriteFile(path, bundle, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", path)
+
+	ks, err := jks.NewTruststoreFromSystemRoots()
+	if err != nil {
+		t.Fatalf("NewTruststoreFromSystemRoots: %v", err)
+	}
+	expected := []string{"examplerootca", "examplerootca.1", "isrgrootx1"}
+	if len(ks.Certs) != len(expected) {
+		t.Fatalf("got %d certificates ≠ expected %d", len(ks.Certs),
+			len(expected))
+	}
+	for i, cert := range ks.Certs {
+		if cert.Alias != expected[i] || cert.Cert == nil {
+			t.Errorf("certificate %d: alias %q ≠ expected %q", i,
+				cert.Alias, expected[i])
+		}
+	}
+}
+
+// TestLoadJavaCacerts checks that cacerts is found under JAVA_HOME, in both the
+// Java 8 and later layouts, and read with the default password.
+func TestLoadJavaCacerts(t *testing.T) {
+	raw := jkstest.New(t, jks.DefaultCacertsPassword).CA("root").Bytes()
+	for _, dir := range []string{"lib", "jre/lib"} {
+		home := t.TempDir()
+		path := filepath.Join(home, dir, "security", "cacerts")
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, raw, 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("JAVA_HOME", home)
+
+		ks, err := jks.LoadJavaCacerts(nil)
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", dir, err)
+		case len(ks.Certs) != 1 ||
+			ks.Integrity != jks.IntegrityVerified:
+			t.Errorf("%s: truststore not read", dir)
+		}
+	}
+
+	t.Setenv("JAVA_HOME", t.TempDir())
+	_, err := jks.LoadJavaCacerts(nil)
+	if jks.ErrorCode(err) != jks.CodeMissingData {
+		t.Errorf("expected %s but got %v", jks.CodeMissingData, err)
+	}
+}
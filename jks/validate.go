@@ -0,0 +1,316 @@
+package jks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultDisabledAlgorithms is a suggested value for
+// Options.DisabledAlgorithms, matching the digests that recent JDKs refuse in
+// certificate signatures.
+var DefaultDisabledAlgorithms = []string{"MD2", "MD5", "SHA1"}
+
+// ValidationError is returned by Validate, and lists every problem found.
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ErrorCode returns CodeValidation. The individual problems carry their own
+// codes.
+func (e *ValidationError) ErrorCode() string {
+	return CodeValidation
+}
+
+// Validate checks that the keystore can be packed into a file that keytool will
+// load (see checkEntries), checks that each keypair's private key matches its
+// certificate (see Keypair.CheckKeyMatch), checks the certificates in the
+// keystore against the policy set in opts, and checks the structure of keypair
+// certificate chains as described for CheckChains, and if opts.Roots is set,
+// verifies them as described for VerifyChains. It returns a *ValidationError
+// listing all problems found, or nil. Problems that opts asks only to be
+// warned about are passed to opts.Warn instead. Pack calls Validate first if
+// opts.Preflight is set.
+func (ks *Keystore) Validate(opts *Options) error {
+	return ks.validate(opts, time.Time{})
+}
+
+// validate implements Validate, verifying chains against opts.Roots as of the
+// time at, or now if at is zero.
+func (ks *Keystore) validate(opts *Options, at time.Time) error {
+	opts, err := opts.normalize()
+	if err != nil {
+		return err
+	}
+	problems := ks.checkEntries(opts)
+	problems = append(problems, ks.checkKeyMatches()...)
+	problems = append(problems, ks.checkSignatureAlgorithms(opts)...)
+	var verr *ValidationError
+	if errors.As(ks.CheckChains(opts), &verr) {
+		problems = append(problems, verr.Problems...)
+	}
+	if opts.Roots != nil &&
+		errors.As(ks.verifyChains(opts.Roots, at), &verr) {
+		problems = append(problems, verr.Problems...)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// ValidateAt performs the same checks as Validate, and also checks that every
+// certificate in the keystore was within its validity period at the given
+// time; chains verified against opts.Roots are also verified as of that time.
+// This answers questions such as "was this keystore valid on June 3rd?"
+// without changing the clock; pass time.Now() to check the keystore as it
+// stands.
+func (ks *Keystore) ValidateAt(opts *Options, at time.Time) error {
+	var problems []error
+	ks.eachCert(func(where certLocation, cert *x509.Certificate) {
+		switch {
+		case at.Before(cert.NotBefore):
+			problems = append(problems, errorf(CodeValidation,
+				"%s: not valid until %s", where,
+				cert.NotBefore.Format(time.RFC3339)))
+		case at.After(cert.NotAfter):
+			problems = append(problems, errorf(CodeValidation,
+				"%s: expired at %s", where,
+				cert.NotAfter.Format(time.RFC3339)))
+		}
+	})
+
+	var verr *ValidationError
+	if err := ks.validate(opts, at); errors.As(err, &verr) {
+		problems = append(problems, verr.Problems...)
+	} else if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// checkEntries returns the problems with the entries themselves that would make
+// Pack fail part way, or write a keystore that keytool cannot load: nil
+// entries, empty aliases, aliases too long for the file format, aliases which
+// are not unique as Java compares them (unless opts.DuplicateAliases says how
+// Pack should deal with them), keypairs without a certificate chain, and
+// certificates without any data.
+func (ks *Keystore) checkEntries(opts *Options) []error {
+	var problems []error
+	problem := func(code, format string, args ...interface{}) {
+		problems = append(problems, errorf(code, format, args...))
+	}
+	seen := make(map[string]string)
+	checkAlias := func(what, alias string) {
+		switch {
+		case alias == "":
+			problem(CodeInvalidArgument, "%s has an empty alias",
+				what)
+			return
+		case len(encodeModifiedUTF8(alias)) > maxStringLen:
+			problem(CodeInvalidArgument, "%s: alias is too long",
+				what)
+		}
+		if opts.DuplicateAliases != CollisionError {
+			return
+		}
+		if err := seenAlias(seen, alias); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	for i, cert := range ks.Certs {
+		if cert == nil {
+			problem(CodeMissingData, "certificate entry #%d is nil",
+				i+1)
+			continue
+		}
+		checkAlias(fmt.Sprintf("certificate entry #%d", i+1),
+			cert.Alias)
+		if len(cert.DER()) == 0 {
+			problem(CodeMissingData, "certificate %q has no data",
+				cert.Alias)
+		}
+	}
+	for i, kp := range ks.Keypairs {
+		if kp == nil {
+			problem(CodeMissingData, "keypair entry #%d is nil",
+				i+1)
+			continue
+		}
+		checkAlias(fmt.Sprintf("keypair entry #%d", i+1), kp.Alias)
+		if len(kp.CertChain) == 0 {
+			problem(CodeMissingData, "key %q has no certificate "+
+				"chain", kp.Alias)
+		}
+		for j, cert := range kp.CertChain {
+			if cert == nil || len(cert.DER()) == 0 {
+				problem(CodeMissingData, "key %q: certificate "+
+					"chain entry #%d has no data", kp.Alias,
+					j+1)
+			}
+		}
+	}
+	return problems
+}
+
+// checkKeyMatches applies Keypair.CheckKeyMatch to every keypair. It is also
+// called by Pack and PackPKCS12, since a keystore whose key does not match its
+// certificate loads without complaint but fails in the first handshake.
+func (ks *Keystore) checkKeyMatches() []error {
+	var problems []error
+	for _, kp := range ks.Keypairs {
+		if kp == nil {
+			continue
+		}
+		if err := kp.CheckKeyMatch(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// CheckKeyMatch returns an error with CodeKeyMismatch if the keypair's private
+// key is not the one whose public half is in the leaf certificate,
+// CertChain[0]: the RSA moduli and exponents, EC curves and points, or Ed25519
+// keys must be equal. It returns nil if the check cannot be made, because the
+// key has not been decrypted, the leaf could not be parsed, or the key is of a
+// type which crypto/x509 does not support.
+func (kp *Keypair) CheckKeyMatch() error {
+	if kp.PrivateKey == nil || len(kp.CertChain) == 0 ||
+		kp.CertChain[0] == nil || kp.CertChain[0].Cert == nil {
+		return nil
+	}
+	match, ok := keyMatches(kp.PrivateKey, kp.CertChain[0].Cert.PublicKey)
+	if ok && !match {
+		return errorf(CodeKeyMismatch, "key %q does not match its "+
+			"certificate", kp.Alias)
+	}
+	return nil
+}
+
+// keyMatches returns true if pub is the public half of priv. ok is false if
+// priv is not a key whose public half can be compared.
+func keyMatches(priv interface{}, pub crypto.PublicKey) (match, ok bool) {
+	if dsaKey, isDSA := priv.(*dsa.PrivateKey); isDSA {
+		return dsaKeyMatches(dsaKey, pub), true
+	}
+	k, ok := priv.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return false, false
+	}
+	eq, ok := k.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false, false
+	}
+	return eq.Equal(pub), true
+}
+
+// checkSignatureAlgorithms applies checkSignatureAlgorithm to every
+// certificate in the keystore. It is also called by Pack and PackPKCS12, so
+// that a keystore which the JVM would refuse to use is never written.
+func (ks *Keystore) checkSignatureAlgorithms(opts *Options) []error {
+	var problems []error
+	ks.eachCert(func(where certLocation, cert *x509.Certificate) {
+		if err := opts.checkSignatureAlgorithm(cert); err != nil {
+			problems = append(problems,
+				errorf("", "%s: %v", where, err))
+		}
+	})
+	return problems
+}
+
+// eachCert calls fn for each parsed certificate in the keystore, with where the
+// certificate was found. Certificates that could not be parsed, and nil
+// entries, are skipped.
+func (ks *Keystore) eachCert(fn func(where certLocation,
+	cert *x509.Certificate)) {
+	for _, cert := range ks.Certs {
+		if cert != nil && cert.Cert != nil {
+			fn(certLocation{alias: cert.Alias}, cert.Cert)
+		}
+	}
+	for _, kp := range ks.Keypairs {
+		if kp == nil {
+			continue
+		}
+		for i, cert := range kp.CertChain {
+			if cert != nil && cert.Cert != nil {
+				where := certLocation{kp.Alias, i + 1}
+				fn(where, cert.Cert)
+			}
+		}
+	}
+}
+
+// certLocation describes where eachCert found a certificate. It is only
+// formatted into a string if a problem is reported, which saves building a
+// description of every certificate in a large truststore.
+type certLocation struct {
+	alias      string
+	chainEntry int // position in a keypair's chain, from 1; 0 if trusted
+}
+
+func (l certLocation) String() string {
+	if l.chainEntry == 0 {
+		return fmt.Sprintf("certificate %q", l.alias)
+	}
+	return fmt.Sprintf("key %q: certificate chain entry #%d", l.alias,
+		l.chainEntry)
+}
+
+// checkSignatureAlgorithm returns an error if cert is signed with an algorithm
+// listed in opts.DisabledAlgorithms. If opts.WarnDisabledAlgorithms is set, the
+// problem is passed to opts.Warn instead and nil is returned.
+//
+// Self-signed certificates are exempt: like the JDK, we treat them as trust
+// anchors, whose own signatures are never checked.
+func (opts *Options) checkSignatureAlgorithm(cert *x509.Certificate) error {
+	if len(opts.DisabledAlgorithms) == 0 ||
+		bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+		return nil
+	}
+
+	// x509.SignatureAlgorithm names are of the form "SHA1-RSA" or
+	// "ECDSA-SHA256"; match both the whole name and each component
+	// against the disabled list
+	alg := cert.SignatureAlgorithm.String()
+	for _, part := range append(strings.Split(alg, "-"), alg) {
+		for _, disabled := range opts.DisabledAlgorithms {
+			if !strings.EqualFold(part, disabled) {
+				continue
+			}
+			err := errorf(CodeKeyPolicy, "signed with disabled "+
+				"algorithm %s", alg)
+			if opts.WarnDisabledAlgorithms {
+				opts.warn(err)
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// warn passes err to opts.Warn, if set. opts may be nil.
+func (opts *Options) warn(err error) {
+	if opts != nil && opts.Warn != nil {
+		opts.Warn(err)
+	}
+}
@@ -0,0 +1,222 @@
+package jks_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// TestDisabledAlgorithms checks that Pack and Validate reject certificates
+// signed with a disabled algorithm, unless only warnings are requested.
+func TestDisabledAlgorithms(t *testing.T) {
+	caKey := jkstest.RSAKey(t, 2048)
+	ca := jkstest.SelfSigned(t, caKey, "Test CA")
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		Subject:            pkix.Name{CommonName: "legacy"},
+		NotBefore:          now.Add(-time.Hour),
+		NotAfter:           now.Add(time.Hour),
+		SignatureAlgorithm: x509.SHA1WithRSA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca,
+		caKey.Public(), caKey)
+	if err != nil {
+		t.Skipf("cannot create SHA-1 signed certificate: %v", err)
+	}
+	legacy, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha1 := jkstest.New(t, "password").Cert("ca", ca).
+		Cert("legacy", legacy)
+	sha256 := jkstest.New(t, "password").CA("ca").RSAKeypair("rsa", 2048)
+
+	t.Run("no policy", testDisabledAlgorithms(sha1, nil, false, 0, 0))
+	t.Run("SHA-1 disabled", testDisabledAlgorithms(sha1,
+		jks.DefaultDisabledAlgorithms, false, 1, 0))
+	t.Run("case insensitive", testDisabledAlgorithms(sha1,
+		[]string{"sha1"}, false, 1, 0))
+	t.Run("warn only", testDisabledAlgorithms(sha1,
+		jks.DefaultDisabledAlgorithms, true, 0, 1))
+	t.Run("whole algorithm name", testDisabledAlgorithms(sha1,
+		[]string{"SHA1-RSA"}, false, 1, 0))
+	t.Run("other algorithm", testDisabledAlgorithms(sha1,
+		[]string{"MD5", "ECDSA"}, false, 0, 0))
+	t.Run("SHA-256 allowed", testDisabledAlgorithms(sha256,
+		jks.DefaultDisabledAlgorithms, false, 0, 0))
+}
+
+func testDisabledAlgorithms(b *jkstest.Builder, disabled []string,
+	warnOnly bool, expProblems, expWarnings int,
+) func(*testing.T) {
+	return func(t *testing.T) {
+		var warnings int
+		opts := b.Options()
+		opts.DisabledAlgorithms = disabled
+		opts.WarnDisabledAlgorithms = warnOnly
+		opts.Warn = func(error) { warnings++ }
+
+		var problems int
+		var verr *jks.ValidationError
+		err := b.Keystore().Validate(opts)
+		if errors.As(err, &verr) {
+			problems = len(verr.Problems)
+		} else if err != nil {
+			t.Fatalf("unexpected error type: %v", err)
+		}
+		if problems != expProblems {
+			t.Errorf("problems %d ≠ expected %d (%v)", problems,
+				expProblems, err)
+		}
+		if warnings != expWarnings {
+			t.Errorf("warnings %d ≠ expected %d", warnings,
+				expWarnings)
+		}
+
+		_, err = b.Keystore().Pack(opts)
+		switch {
+		case expProblems == 0 && err != nil:
+			t.Errorf("unexpected Pack error: %v", err)
+		case expProblems != 0 && err == nil:
+			t.Error("expected Pack error")
+		}
+	}
+}
+
+// TestValidateAt checks that certificate validity is evaluated as of the
+// given time.
+func TestValidateAt(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").RSAKeypair("server", 2048)
+	ks := b.Keystore()
+	now := time.Now()
+
+	if err := ks.ValidateAt(b.Options(), now); err != nil {
+		t.Errorf("ValidateAt(now): %v", err)
+	}
+	for name, at := range map[string]time.Time{
+		"before": now.Add(-48 * time.Hour),
+		"after":  now.Add(48 * time.Hour),
+	} {
+		var verr *jks.ValidationError
+		err := ks.ValidateAt(b.Options(), at)
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: error %v is not a ValidationError", name,
+				err)
+		} else if len(verr.Problems) != 2 {
+			t.Errorf("%s: got %d problems ≠ expected 2", name,
+				len(verr.Problems))
+		}
+	}
+}
+
+// TestValidateEntries checks that Validate reports every structural problem
+// that would stop keytool loading the keystore, and that Pack refuses such a
+// keystore when Preflight is set.
+func TestValidateEntries(t *testing.T) {
+	b := jkstest.New(t, "password").CA("root").RSAKeypair("server", 2048)
+	ks := b.Keystore()
+	ks.Certs = append(ks.Certs,
+		nil,
+		&jks.Cert{Alias: "", Raw: ks.Certs[0].Raw},
+		&jks.Cert{Alias: "Root", Raw: ks.Certs[0].Raw},
+		&jks.Cert{Alias: string(make([]byte, 0x10000))},
+	)
+	ks.Keypairs = append(ks.Keypairs,
+		&jks.Keypair{Alias: "nochain"},
+		&jks.Keypair{Alias: "badchain", CertChain: []*jks.KeypairCert{
+			nil,
+		}},
+	)
+
+	var verr *jks.ValidationError
+	if err := ks.Validate(b.Options()); !errors.As(err, &verr) {
+		t.Fatalf("Validate: error %v is not a ValidationError", err)
+	}
+	expCodes := []string{
+		jks.CodeMissingData,     // nil certificate
+		jks.CodeInvalidArgument, // empty alias
+		jks.CodeDuplicateAlias,  // Root
+		jks.CodeInvalidArgument, // alias too long
+		jks.CodeMissingData,     // no data
+		jks.CodeMissingData,     // no chain
+		jks.CodeMissingData,     // nil chain entry
+	}
+	if len(verr.Problems) != len(expCodes) {
+		t.Fatalf("got %d problems ≠ expected %d: %v",
+			len(verr.Problems), len(expCodes), verr)
+	}
+	for i, p := range verr.Problems {
+		if code := jks.ErrorCode(p); code != expCodes[i] {
+			t.Errorf("problem %d: code %s ≠ expected %s (%v)", i,
+				code, expCodes[i], p)
+		}
+	}
+
+	opts := b.Options()
+	opts.Preflight = true
+	if _, err := ks.Pack(opts); !errors.As(err, &verr) {
+		t.Errorf("Pack with Preflight: error %v is not a "+
+			"ValidationError", err)
+	}
+}
+
+// TestCheckKeyMatch checks that a private key which does not belong to the
+// leaf certificate is reported by CheckKeyMatch, Validate and Pack.
+func TestCheckKeyMatch(t *testing.T) {
+	_, ed1, _ := ed25519.GenerateKey(rand.Reader)
+	_, ed2, _ := ed25519.GenerateKey(rand.Reader)
+	t.Run("RSA", testCheckKeyMatch(jkstest.RSAKey(t, 2048),
+		jkstest.RSAKey(t, 2048)))
+	t.Run("EC", testCheckKeyMatch(jkstest.ECKey(t, elliptic.P256()),
+		jkstest.ECKey(t, elliptic.P256())))
+	t.Run("EC curve", testCheckKeyMatch(jkstest.ECKey(t, elliptic.P256()),
+		jkstest.ECKey(t, elliptic.P384())))
+	t.Run("Ed25519", testCheckKeyMatch(ed1, ed2))
+	t.Run("RSA and EC", testCheckKeyMatch(jkstest.RSAKey(t, 2048),
+		jkstest.ECKey(t, elliptic.P256())))
+}
+
+func testCheckKeyMatch(key, other crypto.Signer) func(*testing.T) {
+	return func(t *testing.T) {
+		b := jkstest.New(t, "password").Keypair("server", key)
+		kp := b.Keystore().Keypairs[0]
+		if err := kp.CheckKeyMatch(); err != nil {
+			t.Errorf("matching key: %v", err)
+		}
+
+		kp.PrivateKey = other
+		err := kp.CheckKeyMatch()
+		if code := jks.ErrorCode(err); code != jks.CodeKeyMismatch {
+			t.Errorf("CheckKeyMatch: code %q ≠ expected %q (%v)",
+				code, jks.CodeKeyMismatch, err)
+		}
+		if err := b.Keystore().Validate(b.Options()); err == nil {
+			t.Error("Validate: expected error")
+		}
+		if _, err := b.Keystore().Pack(b.Options()); err == nil {
+			t.Error("Pack: expected error")
+		}
+		if _, err := b.Keystore().PackPKCS12(b.Options()); err == nil {
+			t.Error("PackPKCS12: expected error")
+		}
+
+		// a key that was not decrypted cannot be checked
+		kp.PrivateKey = nil
+		if err := kp.CheckKeyMatch(); err != nil {
+			t.Errorf("undecrypted key: %v", err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package jks
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"math/big"
+)
+
+// Destroy zeroes the decrypted private and secret key material held in ks, for
+// callers who do not want it lingering in memory once they are done with it.
+// Each keypair is left as if it had been read with Options.SkipKeyDecryption:
+// its PrivateKey and RawKey are cleared and PrivKeyErr is set to
+// ErrKeyNotDecrypted, but its EncryptedKey is kept, so the keystore can still
+// be packed, and a key can be decrypted again with Keypair.Decrypt. Secret
+// keys lose their Key.
+//
+// Key material is zeroed as far as Go allows: the big integers of RSA and EC
+// keys are cleared in place, but crypto/rsa keeps precomputed values of its own
+// which cannot be reached, and the runtime may have made copies of any buffer
+// while it was in use.
+func (ks *Keystore) Destroy() {
+	for _, kp := range ks.Keypairs {
+		wipePrivateKey(kp.PrivateKey)
+		clear(kp.RawKey)
+		kp.PrivateKey, kp.RawKey = nil, nil
+		kp.PrivKeyErr = ErrKeyNotDecrypted
+	}
+	for _, sk := range ks.SecretKeys {
+		clear(sk.Key)
+		sk.Key = nil
+	}
+}
+
+// wipePrivateKey zeroes the secret parts of a private key of one of the types
+// that MarshalPKCS8 handles.
+func wipePrivateKey(key interface{}) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		wipeInt(key.D)
+		for _, p := range key.Primes {
+			wipeInt(p)
+		}
+		wipeInt(key.Precomputed.Dp)
+		wipeInt(key.Precomputed.Dq)
+		wipeInt(key.Precomputed.Qinv)
+	case *ecdsa.PrivateKey:
+		wipeInt(key.D)
+	case *dsa.PrivateKey:
+		wipeInt(key.X)
+	case ed25519.PrivateKey:
+		clear(key)
+	}
+}
+
+// wipeInt zeroes the words of n in place, and sets it to zero.
+func wipeInt(n *big.Int) {
+	if n != nil {
+		clear(n.Bits())
+		n.SetInt64(0)
+	}
+}
@@ -1,100 +1,318 @@
 package jks
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha1"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/binary"
-	"errors"
-	"fmt"
 	"io"
 	"time"
 )
 
-// Pack writes a JKS file. opts must be specified, and the SkipVerifyDigest
-// option will be ignored. The password will always be taken from opts, and if
-// it is an empty string then an empty string will be used for the password.
-// This function requires that all certificates and private keys are present, so
-// be sure to check this if you have obtained a Keystore using Parse(). Each
-// record should have a unique alias (not checked). If a record's Timestamp is
-// zero then the current system time will be queried and be used.
+// Pack writes a JKS file, in the version given by opts.Version. If opts is
+// nil, DefaultOptions is used. The SkipVerifyDigest option will be ignored.
+// The password will always be taken from opts, and if it is an empty string
+// then an empty string will be used for the password. This function requires
+// that all certificates and private keys are present, so be sure to check this
+// if you have obtained a Keystore using Parse(). The exception is a private key
+// that was never decrypted (for instance because its password was not known),
+// whose EncryptedKey is written out unchanged, still protected by its original
+// password. If a record's Timestamp is zero then opts.Timestamp is written, or
+// failing that the current time from opts.Clock (the Unix epoch if
+// opts.Deterministic is set). Aliases are lowercased, as keytool does, unless
+// opts.PreserveAliasCase is set; either way, two records whose aliases differ
+// only in case, or not at all, are an error (with CodeDuplicateAlias), since
+// Java would load only one of them, unless opts.DuplicateAliases says how to
+// resolve them. Any UnknownEntries are written back unchanged after the other
+// entries, which is where Parse must have found them.
 func (ks *Keystore) Pack(opts *Options) ([]byte, error) {
-	var buf bytes.Buffer
-	writeUint32(&buf, MagicNumber)
-	writeUint32(&buf, 2) // version
-	writeUint32(&buf, uint32(len(ks.Certs)+len(ks.Keypairs)))
+	buf := bytes.NewBuffer(make([]byte, 0, ks.packSizeHint()))
+	if _, err := ks.PackTo(buf, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	for _, cert := range ks.Certs {
-		if err := writeCert(&buf, cert); err != nil {
-			return nil, err
+// PackTo writes a JKS file to w, as Pack does, and returns the number of bytes
+// written. Each record is passed to a buffered writer as soon as it has been
+// encoded, and the digest is computed as the data goes past, so that the file
+// is never held in memory as a whole. The options are checked, and the
+// certificate signatures validated, before anything is written; but if a
+// private key cannot be marshalled, or w returns an error, a partial file will
+// have been written.
+func (ks *Keystore) PackTo(w io.Writer, opts *Options) (int64, error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return 0, err
+	}
+	defer opts.wipePasswords()
+	if len(ks.SecretKeys) != 0 {
+		return 0, errSecretKeys
+	}
+	if opts.Preflight {
+		if err := ks.Validate(opts); err != nil {
+			return 0, err
+		}
+	} else if opts.Roots != nil {
+		if err := ks.VerifyChains(opts.Roots); err != nil {
+			return 0, err
 		}
 	}
-	for _, kp := range ks.Keypairs {
-		if err := writeKeypair(&buf, kp, opts); err != nil {
-			return nil, err
+	entries, err := ks.packEntries(opts)
+	if err != nil {
+		return 0, err
+	}
+	problems := ks.checkSignatureAlgorithms(opts)
+	problems = append(problems, ks.checkKeyMatches()...)
+	if problems != nil {
+		return 0, &ValidationError{Problems: problems}
+	}
+
+	// Each record is encoded into rec, which is reused for the next, and
+	// written in one piece; bw gathers the records into large writes.
+	ew := &errWriter{w: w}
+	bw := bufio.NewWriterSize(ew, packBufferSize)
+	md := newDigest(opts.password(), opts.PasswordEncoding)
+	defer md.wipe()
+	mw := io.MultiWriter(md, bw)
+	rec := make([]byte, 0, packBufferSize)
+	rec = appendUint32(rec, MagicNumber)
+	rec = appendUint32(rec, opts.version())
+	rec = appendUint32(rec, uint32(len(entries)+len(ks.UnknownEntries)))
+	mw.Write(rec)
+
+	for _, entry := range entries {
+		switch entry := entry.(type) {
+		case *Cert:
+			rec, err = appendCert(rec[:0], entry, opts)
+		case *Keypair:
+			rec, err = appendKeypair(rec[:0], entry, opts)
+		}
+		if err != nil {
+			bw.Flush()
+			return ew.n, err
 		}
+		mw.Write(rec)
+		if ew.err != nil {
+			return ew.n, ew.err
+		}
+	}
+	for _, entry := range ks.UnknownEntries {
+		mw.Write(appendUint32(rec[:0], entry.Tag))
+		mw.Write(entry.Raw)
 	}
 
-	digest := ComputeDigest(buf.Bytes(), opts.Password)
-	buf.Write(digest)
-	return buf.Bytes(), nil
+	bw.Write(md.Sum(rec[:0]))
+	bw.Flush()
+	return ew.n, ew.err
 }
 
-// writeCert writes out a certificate record.
-func writeCert(w io.Writer, cert *Cert) error {
-	writeUint32(w, 2) // type = certificate
-	if err := writeStr(w, cert.Alias); err != nil {
-		return fmt.Errorf("failed to write alias (%v): %q",
-			err, cert.Alias)
+// packBufferSize is the size of PackTo's output buffer, and the initial size
+// of its record buffer, which is enough for a typical certificate record.
+const packBufferSize = 8192
+
+// packKeySizeHint is packSizeHint's guess at the size of an encrypted private
+// key.
+const packKeySizeHint = 2048
+
+// packSizeHint returns an estimate of the size of the JKS file that Pack will
+// write, so that its buffer can be allocated once. It is exact for trusted
+// certificates but must guess at the size of encrypted private keys.
+func (ks *Keystore) packSizeHint() int {
+	const (
+		header = 12 // magic, version and entry count
+		entry  = 14 // tag, alias length and timestamp
+		cert   = 2 + len(CertType) + 4
+		key    = packKeySizeHint
+	)
+	n := header + sha1.Size
+	for _, c := range ks.Certs {
+		if c != nil {
+			n += entry + len(c.Alias) + cert + len(c.DER())
+		}
+	}
+	for _, kp := range ks.Keypairs {
+		if kp == nil {
+			continue
+		}
+		n += entry + len(kp.Alias) + 4 + key + 4
+		for _, c := range kp.CertChain {
+			if c != nil {
+				n += cert + len(c.DER())
+			}
+		}
+	}
+	for _, u := range ks.UnknownEntries {
+		n += 4 + len(u.Raw)
 	}
+	return n
+}
+
+// errWriter counts the bytes written to w, and remembers the first error it
+// returns, after which further writes are dropped. This saves checking the
+// error from every small write.
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
 
-	ts := cert.Timestamp
-	if ts.IsZero() {
-		ts = time.Now()
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
 	}
-	writeTimestamp(w, ts)
+	n, err := ew.w.Write(p)
+	ew.n += int64(n)
+	ew.err = err
+	return n, err
+}
+
+// errUnknownEntries is returned when packing a keystore holding unknown
+// entries as PKCS#12.
+var errUnknownEntries = newError(CodeUnsupported, "unrecognised entries "+
+	"cannot be written to a PKCS#12 file")
+
+// errSecretKeys is returned when packing a keystore holding secret keys, which
+// we can read from JCEKS files but not write.
+var errSecretKeys = newError(CodeUnsupported, "secret key entries cannot "+
+	"be written")
 
-	if err := writeStr(w, CertType); err != nil {
-		return fmt.Errorf("failed to write certificate type (%v)", err)
+// appendCert appends a certificate record to b.
+func appendCert(b []byte, cert *Cert, opts *Options) ([]byte, error) {
+	b = appendUint32(b, 2) // type = certificate
+	b, err := appendStr(b, opts.packAlias(cert.Alias))
+	if err != nil {
+		return b, errorf("", "failed to write alias (%v): %q",
+			err, cert.Alias)
 	}
 
-	writeUint32(w, uint32(len(cert.Cert.Raw)))
-	w.Write(cert.Cert.Raw)
+	b = appendTimestamp(b, opts.timestamp(cert.Timestamp))
 
-	return nil
+	if b, err = appendCertType(b, cert.Type, opts); err != nil {
+		return b, errorf("", "certificate %q: %v", cert.Alias, err)
+	}
+
+	der := cert.DER()
+	if len(der) == 0 {
+		return b, errorf(CodeMissingData, "certificate %q has no data",
+			cert.Alias)
+	}
+	b = appendUint32(b, uint32(len(der)))
+	return append(b, der...), nil
 }
 
-// writeKeypair writes out a private key and associated certificate chain.
-func writeKeypair(w io.Writer, kp *Keypair, opts *Options) error {
-	writeUint32(w, 1) // type = private key + cert chain
-	if err := writeStr(w, kp.Alias); err != nil {
-		return fmt.Errorf("failed to write alias (%v): %q",
+// appendKeypair appends a record holding a private key and its certificate
+// chain to b.
+func appendKeypair(b []byte, kp *Keypair, opts *Options) ([]byte, error) {
+	b = appendUint32(b, 1) // type = private key + cert chain
+	b, err := appendStr(b, opts.packAlias(kp.Alias))
+	if err != nil {
+		return b, errorf("", "failed to write alias (%v): %q",
 			err, kp.Alias)
 	}
 
-	// use specific key password if present, fall back to global
-	passwd, ok := opts.KeyPasswords[kp.Alias]
-	if !ok {
-		passwd = opts.Password
+	b = appendTimestamp(b, opts.timestamp(kp.Timestamp))
+
+	// a key that was never decrypted is written out as it was read
+	kp, err = opts.rekey(kp)
+	if err != nil {
+		return b, err
+	}
+	algos := []asn1.ObjectIdentifier{JavaKeyEncryptionOID1}
+	if opts.KeyProtection == KeyProtectionPBES2 {
+		algos = []asn1.ObjectIdentifier{oidPBES2, JavaKeyEncryptionOID1}
+	}
+	raw, err := passThroughKey(kp, opts, algos...)
+	if err != nil {
+		return b, err
+	}
+	if raw == nil {
+		if raw, err = encryptJKSKey(kp, opts); err != nil {
+			return b, err
+		}
+	}
+	b = appendUint32(b, uint32(len(raw)))
+	b = append(b, raw...)
+
+	// write out the certificate chain
+	b = appendUint32(b, uint32(len(kp.CertChain)))
+	for i, cert := range kp.CertChain {
+		if b, err = appendCertType(b, cert.Type, opts); err != nil {
+			return b, errorf("", "key %q: certificate chain entry "+
+				"#%d: %v", kp.Alias, i+1, err)
+		}
+		der := cert.DER()
+		if len(der) == 0 {
+			return b, errorf(CodeMissingData, "key %q: "+
+				"certificate chain entry #%d has no data",
+				kp.Alias, i+1)
+		}
+		b = appendUint32(b, uint32(len(der)))
+		b = append(b, der...)
 	}
 
-	ts := kp.Timestamp
-	if ts.IsZero() {
-		ts = time.Now()
+	return b, nil
+}
+
+// appendCertType appends the type of a certificate to b, which is CertType
+// unless typ is set. Version 1 files do not record the type, so only X.509
+// certificates may be written to them.
+func appendCertType(b []byte, typ string, opts *Options) ([]byte, error) {
+	if typ == "" {
+		typ = CertType
+	}
+	if opts.version() == 1 {
+		if typ != CertType {
+			return b, errorf(CodeIncompatible, "certificate type "+
+				"%q cannot be written to a version 1 file", typ)
+		}
+		return b, nil
+	}
+	b, err := appendStr(b, typ)
+	if err != nil {
+		return b, errorf("", "failed to write certificate type (%v)",
+			err)
 	}
-	writeTimestamp(w, ts)
+	return b, nil
+}
 
-	// marshal the key into ‘raw’
-	raw, err := MarshalPKCS8(kp.PrivateKey)
+// encryptJKSKey marshals kp's private key, encrypts it with the password for
+// its alias, and wraps it into a DER PKCS#8 EncryptedPrivateKeyInfo structure.
+// The key is encrypted with JavaKeyEncryption1, or with PBES2 if
+// opts.KeyProtection asks for it.
+func encryptJKSKey(kp *Keypair, opts *Options) ([]byte, error) {
+	raw, err := marshalKeypairKey(kp, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(raw)
+	passwd, err := opts.packKeyPassword(kp.Alias)
 	if err != nil {
-		return fmt.Errorf("key %q: %v", kp.Alias, err)
+		return nil, err
 	}
+	return protectJKSKey(kp.Alias, raw, passwd, opts)
+}
 
-	// encrypt the marshalled key, then wrap into a PKCS#8
-	// EncryptedPrivateKeyInfo structure
-	ciphertext, err := EncryptJavaKeyEncryption1(raw, passwd)
+// protectJKSKey encrypts a marshalled PrivateKeyInfo for the key with the
+// given alias, as encryptJKSKey does.
+func protectJKSKey(alias string, raw, passwd []byte, opts *Options,
+) ([]byte, error) {
+	if opts.KeyProtection == KeyProtectionPBES2 {
+		keyInfo, err := encryptPBES2(raw, passwd,
+			opts.randReader(passwd, raw))
+		if err != nil {
+			return nil, errorf(CodeCryptoFailure, "key %q: failed "+
+				"to encrypt private key: %v", alias, err)
+		}
+		return keyInfo.Marshal()
+	}
+	ciphertext, err := encryptJavaKeyEncryption1(raw, passwd,
+		opts.PasswordEncoding, opts.randReader(passwd, raw))
 	if err != nil {
-		return fmt.Errorf("failed to marshal private key: %v", err)
+		return nil, errorf("", "failed to marshal private key: %v",
+			err)
 	}
 	keyInfo := EncryptedPrivateKeyInfo{
 		Algo: pkix.AlgorithmIdentifier{
@@ -103,61 +321,96 @@ func writeKeypair(w io.Writer, kp *Keypair, opts *Options) error {
 		},
 		EncryptedData: ciphertext,
 	}
-	raw, err = asn1.Marshal(keyInfo)
+	raw, err = keyInfo.Marshal()
 	if err != nil {
-		return fmt.Errorf("failed to marshal PKCS#8 encrypted "+
+		return nil, errorf("", "failed to marshal PKCS#8 encrypted "+
 			"private key info: %v", err)
 	}
-	writeUint32(w, uint32(len(raw)))
-	w.Write(raw)
+	return raw, nil
+}
 
-	// write out the certificate chain
-	writeUint32(w, uint32(len(kp.CertChain)))
-	for _, cert := range kp.CertChain {
-		if err := writeStr(w, CertType); err != nil {
-			return fmt.Errorf("failed to write certificate "+
-				"type (%v)", err)
+// passThroughKey returns kp's EncryptedKey, to be written out unchanged, if
+// its private key was never decrypted (see Options.SkipKeyDecryption), or the
+// result of its EncryptKeyFunc if that is set. Such a key cannot be checked
+// against the compatibility profile or key policy. algos lists the encryption
+// algorithms that the output format allows, the preferred one first; for a key
+// encrypted with any other, an error with CodeIncompatible is returned. If
+// kp's private key is available, passThroughKey returns nil and the key should
+// be encrypted afresh.
+func passThroughKey(kp *Keypair, opts *Options, algos ...asn1.ObjectIdentifier,
+) ([]byte, error) {
+	raw := kp.EncryptedKey
+	switch {
+	case kp.EncryptKeyFunc != nil:
+		passwd, err := opts.packKeyPassword(kp.Alias)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = kp.EncryptKeyFunc(passwd, algos); err != nil {
+			return nil, errorf("", "key %q: failed to encrypt "+
+				"private key: %v", kp.Alias, err)
 		}
-		writeUint32(w, uint32(len(cert.Cert.Raw)))
-		w.Write(cert.Cert.Raw)
+	case kp.PrivateKey != nil || len(raw) == 0:
+		return nil, nil
 	}
-
-	return nil
+	keyInfo, err := ParseEncryptedPrivateKeyInfo(raw)
+	if err != nil {
+		return nil, errorf("", "key %q: %v", kp.Alias, err)
+	}
+	for _, algo := range algos {
+		if keyInfo.Algo.Algorithm.Equal(algo) {
+			return raw, nil
+		}
+	}
+	return nil, errorf(CodeIncompatible, "key %q: cannot write a key "+
+		"encrypted with %v in this format", kp.Alias,
+		keyInfo.Algo.Algorithm)
 }
 
-// writeUint32 writes a 32-bit unsigned integer in big-endian format.
-func writeUint32(w io.Writer, u uint32) {
-	var raw [4]byte
-	binary.BigEndian.PutUint32(raw[:], u)
-	w.Write(raw[:])
+// marshalKeypairKey checks kp's private key against the compatibility profile
+// and key policy, then marshals it into a PKCS#8 PrivateKeyInfo.
+func marshalKeypairKey(kp *Keypair, opts *Options) ([]byte, error) {
+	err := opts.Compatibility.checkPrivateKey(kp.PrivateKey)
+	if err != nil {
+		return nil, errorf("", "key %q: %v", kp.Alias, err)
+	}
+	if err := opts.checkKeyPolicy(kp.PrivateKey); err != nil {
+		return nil, errorf("", "key %q: %v", kp.Alias, err)
+	}
+	raw, err := MarshalPKCS8(kp.PrivateKey)
+	if err != nil {
+		return nil, errorf("", "key %q: %v", kp.Alias, err)
+	}
+	return raw, nil
 }
 
-// writeUint64 writes a 64-bit unsigned integer in big-endian format.
-func writeUint64(w io.Writer, u uint64) {
-	var raw [8]byte
-	binary.BigEndian.PutUint64(raw[:], u)
-	w.Write(raw[:])
+// appendUint32 appends a 32-bit unsigned integer in big-endian format to b.
+func appendUint32(b []byte, u uint32) []byte {
+	return binary.BigEndian.AppendUint32(b, u)
 }
 
-// writeTimestamp converts the timestamp to a 64-bit unsigned number (ms elapsed
-// since the Unix epoch) and writes it in big-endian format.
-func writeTimestamp(w io.Writer, ts time.Time) {
-	ms := ts.UnixNano() / 1e6
-	writeUint64(w, uint64(ms))
+// appendTimestamp converts the timestamp to a 64-bit unsigned number (ms
+// elapsed since the Unix epoch) and appends it to b in big-endian format.
+func appendTimestamp(b []byte, ts time.Time) []byte {
+	return binary.BigEndian.AppendUint64(b, uint64(ts.UnixMilli()))
 }
 
-// writeStr writes a UTF-8 string. The string is encoded as an octet length
-// (16-bit unsigned big-endian integer) followed by the UTF-8 octets. This
-// function will return an error if there are too many octets to fit into the
-// 16-bit length field.
-func writeStr(w io.Writer, s string) error {
-	if len(s) > 0xFFFF {
-		return errors.New("string too long")
-	}
+// maxStringLen is the length, in bytes of modified UTF-8, of the longest string
+// that the file format can hold.
+const maxStringLen = 0xFFFF
 
-	var raw [2]byte
-	binary.BigEndian.PutUint16(raw[:], uint16(len(s)))
-	w.Write(raw[:])
-	w.Write([]byte(s))
-	return nil
+// appendStr appends a string to b as Java's DataOutputStream.writeUTF writes
+// it: an octet length (16-bit unsigned big-endian integer) followed by the
+// string in Java's modified UTF-8 (see encodeModifiedUTF8). This function will
+// return an error if there are too many octets to fit into the 16-bit length
+// field, leaving b as it was.
+func appendStr(b []byte, s string) ([]byte, error) {
+	start := len(b)
+	b = appendModifiedUTF8(append(b, 0, 0), s)
+	n := len(b) - start - 2
+	if n > maxStringLen {
+		return b[:start], newError(CodeMalformed, "string too long")
+	}
+	binary.BigEndian.PutUint16(b[start:], uint16(n))
+	return b, nil
 }
@@ -0,0 +1,47 @@
+package jks_test
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/jkstest"
+)
+
+// benchmarkTruststore returns a keystore holding n trusted certificates, as a
+// large CA bundle converted for the JVM would.
+func benchmarkTruststore(b *testing.B, n int) (*jks.Keystore, *jks.Options) {
+	ca := jkstest.New(b, "password")
+	key := jkstest.ECKey(b, elliptic.P256())
+	root := jkstest.SelfSigned(b, key, "root")
+	for i := 0; i < n; i++ {
+		ca.Cert(fmt.Sprintf("ca%d", i), root)
+	}
+	return ca.Keystore(), ca.Options()
+}
+
+// BenchmarkPack measures packing a truststore of 2000 certificates.
+func BenchmarkPack(b *testing.B) {
+	ks, opts := benchmarkTruststore(b, 2000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ks.Pack(opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPackTo measures streaming a truststore of 2000 certificates.
+func BenchmarkPackTo(b *testing.B) {
+	ks, opts := benchmarkTruststore(b, 2000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ks.PackTo(io.Discard, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
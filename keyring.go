@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringPassword fetches a password from the platform keyring (Keychain on
+// macOS, the Credential Manager on Windows, or the Secret Service on Linux and
+// other Unix-like systems). ref is given as "service/account"; the account
+// name is everything after the final slash.
+func keyringPassword(ref string) (string, error) {
+	i := strings.LastIndexByte(ref, '/')
+	if i <= 0 || i == len(ref)-1 {
+		return "", fmt.Errorf("invalid keyring reference %q (expected "+
+			"service/account)", ref)
+	}
+	service, account := ref[:i], ref[i+1:]
+
+	passwd, err := keyring.Get(service, account)
+	if err != nil {
+		return "", fmt.Errorf("keyring %q: %v", ref, err)
+	}
+	return passwd, nil
+}
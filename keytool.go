@@ -0,0 +1,587 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+// The commands in this file mirror the everyday subcommands of the JDK's
+// keytool, so that a keystore can be examined and edited in place without a
+// JRE. Each reads the keystore in any format that jks.ParseAny understands,
+// and those that modify it write it back in the same format.
+
+var ListCommand = &cli.Command{
+	Name:      "list",
+	Usage:     "list the entries in a keystore, as keytool -list does",
+	ArgsUsage: "keystore.jks",
+	Action:    List,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage: "print the SHA-1 and SHA-256 fingerprints of " +
+				"every certificate, as keytool -list -v does",
+		},
+		&cli.BoolFlag{
+			Name: "json",
+			Usage: "print the entries and their certificates " +
+				"as JSON",
+		},
+		&cli.BoolFlag{
+			Name: "stats",
+			Usage: "print a summary of the keystore's content " +
+				"instead of its entries",
+		},
+	},
+}
+
+var ExportCertCommand = &cli.Command{
+	Name:      "export-cert",
+	Usage:     "write an entry's certificate in PEM form",
+	ArgsUsage: "keystore.jks",
+	Description: "Writes the certificate of the entry with the given " +
+		"alias (for a keypair, the first certificate of its chain, " +
+		"or the whole chain with --chain) to --out, or to standard " +
+		"output.",
+	Action: ExportCert,
+	Flags: []cli.Flag{
+		keytoolAliasFlag,
+		keytoolOutFlag,
+		&cli.BoolFlag{
+			Name:  "chain",
+			Usage: "write a keypair's whole certificate chain",
+		},
+	},
+}
+
+var ExportKeyCommand = &cli.Command{
+	Name:      "export-key",
+	Usage:     "write a keypair's private key in PEM form",
+	ArgsUsage: "keystore.jks",
+	Description: "Decrypts the private key of the keypair with the " +
+		"given alias and writes it to --out (with mode 0600, " +
+		"even if it exists), or to standard output. With " +
+		"--out-password, the key is written as an encrypted PKCS#8 " +
+		"block (PBES2 with AES-256), which openssl and most " +
+		"servers read; otherwise it is written unencrypted.",
+	Action: ExportKey,
+	Flags: []cli.Flag{
+		keytoolAliasFlag,
+		keytoolOutFlag,
+		&cli.StringFlag{
+			Name:  "out-password",
+			Usage: "password with which to encrypt the written key",
+		},
+	},
+}
+
+var ImportCertCommand = &cli.Command{
+	Name:      "import-cert",
+	Usage:     "add a trusted certificate to a keystore",
+	ArgsUsage: "keystore.jks",
+	Description: "Adds the PEM certificate in --file as a trusted " +
+		"certificate entry with the given alias. The keystore is " +
+		"created if it does not exist. If the alias names a " +
+		"keypair, --file is instead taken to be a CA's reply to a " +
+		"request from cert-req, and replaces the keypair's " +
+		"certificate chain, as keytool -importcert does.",
+	Action: ImportCert,
+	Flags: []cli.Flag{
+		keytoolAliasFlag,
+		keytoolFileFlag,
+		collisionFlag,
+	},
+}
+
+var ImportPEMCommand = &cli.Command{
+	Name:      "import-pem",
+	Usage:     "add a private key and certificate chain to a keystore",
+	ArgsUsage: "keystore.jks",
+	Description: "Adds a keypair entry with the given alias from --file, " +
+		"a PEM file holding a private key (PKCS#1, SEC 1 or PKCS#8) " +
+		"followed by its certificate chain, leaf first. The private " +
+		"key is protected with --key-password for the alias, or " +
+		"--password. The keystore is created if it does not exist.",
+	Action: ImportPEM,
+	Flags: []cli.Flag{
+		keytoolAliasFlag,
+		keytoolFileFlag,
+		collisionFlag,
+	},
+}
+
+var CertReqCommand = &cli.Command{
+	Name:      "cert-req",
+	Usage:     "write a certificate signing request for a keypair",
+	ArgsUsage: "keystore.jks",
+	Description: "Writes a PEM certificate signing request for the " +
+		"keypair with the given alias, signed with its private key, " +
+		"to --out, or to standard output, as keytool -certreq does. " +
+		"The request is for --subject, or the subject of the " +
+		"keypair's certificate.",
+	Action: CertReq,
+	Flags: []cli.Flag{
+		keytoolAliasFlag,
+		keytoolOutFlag,
+		&cli.StringFlag{
+			Name:    "subject",
+			Aliases: []string{"dname"},
+			Usage: "request subject, e.g. \"CN=host,O=Org,C=GB\" " +
+				"(default: the certificate's subject)",
+		},
+		&cli.BoolFlag{
+			Name: "copy-san",
+			Usage: "request the subject alternative names of the " +
+				"keypair's certificate",
+		},
+	},
+}
+
+var DeleteCommand = &cli.Command{
+	Name:      "delete",
+	Usage:     "remove an entry from a keystore",
+	ArgsUsage: "keystore.jks",
+	Action:    Delete,
+	Flags:     []cli.Flag{keytoolAliasFlag},
+}
+
+var (
+	keytoolAliasFlag = &cli.StringFlag{
+		Name:     "alias",
+		Required: true,
+		Usage:    "alias of the entry",
+	}
+	keytoolOutFlag = &cli.StringFlag{
+		Name:  "out",
+		Usage: "file to write (default: standard output)",
+	}
+	keytoolFileFlag = &cli.StringFlag{
+		Name:     "file",
+		Required: true,
+		Usage:    "PEM file to import",
+	}
+)
+
+func init() {
+	for _, cmd := range []*cli.Command{
+		ListCommand, ExportCertCommand, ExportKeyCommand,
+		ImportCertCommand, ImportPEMCommand, CertReqCommand,
+		DeleteCommand,
+	} {
+		cmd.Flags = addJksOptsFlags(cmd.Flags)
+	}
+}
+
+func List(c *cli.Context) error {
+	ks, format, opts, err := keytoolOpen(c, false)
+	if ks == nil {
+		return err
+	}
+	if errors.Is(err, jks.ErrDigestMismatch) {
+		printDigestMismatch(opts, err)
+	}
+
+	if c.Bool("stats") {
+		if werr := printStats(ks.Stats(), format,
+			c.Bool("json")); werr != nil {
+			return werr
+		}
+		return err
+	}
+	if c.Bool("json") {
+		report, rerr := ks.MarshalReport()
+		if rerr != nil {
+			return rerr
+		}
+		if _, werr := os.Stdout.Write(report); werr != nil {
+			return werr
+		}
+		return err
+	}
+	if c.Bool("verbose") {
+		fmt.Printf("Keystore type: %s\n\n", format)
+		if werr := ks.WriteFingerprints(os.Stdout); werr != nil {
+			return werr
+		}
+		return err
+	}
+
+	m := ks.Manifest()
+	fmt.Printf("Keystore type: %s\n", format)
+	fmt.Printf("Keystore contains %d entries\n", len(m.Entries))
+	for _, e := range m.Entries {
+		fmt.Printf("\n%s, %s, %s,\n", e.Alias,
+			e.Timestamp.Format("Jan 2, 2006"), e.Type)
+		if len(e.Certificates) != 0 {
+			fmt.Printf("Certificate fingerprint (SHA-256): %s\n",
+				e.Certificates[0].FingerprintSHA256)
+		}
+		if e.KeyError != "" {
+			fmt.Printf("Key error: %s\n", e.KeyError)
+		}
+	}
+	return err
+}
+
+// printStats prints a keystore's Stats, as JSON or as a summary like the one
+// List prints.
+func printStats(st *jks.Stats, format jks.Format, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	fmt.Printf("Keystore type: %s\n", format)
+	fmt.Printf("Trusted certificates: %d\n", st.TrustedCerts)
+	fmt.Printf("Keypairs: %d\n", st.Keypairs)
+	fmt.Printf("Secret keys: %d\n", st.SecretKeys)
+	if st.UnknownEntries != 0 {
+		fmt.Printf("Unknown entries: %d\n", st.UnknownEntries)
+	}
+	fmt.Printf("Certificates: %d (%d unparseable)\n", st.Certificates,
+		st.CertErrors)
+	if st.KeyErrors != 0 {
+		fmt.Printf("Keys with errors: %d\n", st.KeyErrors)
+	}
+	algos := make([]string, 0, len(st.KeyAlgorithms))
+	for algo := range st.KeyAlgorithms {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	for _, algo := range algos {
+		fmt.Printf("%s keys: %d\n", algo, st.KeyAlgorithms[algo])
+	}
+	if !st.Oldest.IsZero() {
+		fmt.Printf("Oldest entry: %s\n",
+			st.Oldest.Format("Jan 2, 2006"))
+		fmt.Printf("Newest entry: %s\n",
+			st.Newest.Format("Jan 2, 2006"))
+	}
+	fmt.Printf("Estimated size: %d bytes\n", st.EncodedSize)
+	return nil
+}
+
+func ExportCert(c *cli.Context) error {
+	ks, _, _, err := keytoolOpen(c, false)
+	if err != nil {
+		return err
+	}
+
+	alias := c.String("alias")
+	var ders [][]byte
+	cert, kp := ks.Lookup(alias)
+	if cert != nil {
+		ders = append(ders, cert.DER())
+	}
+	if kp != nil {
+		for i, cert := range kp.CertChain {
+			if i == 0 || c.Bool("chain") {
+				ders = append(ders, cert.DER())
+			}
+		}
+	}
+	if len(ders) == 0 {
+		return fmt.Errorf("no certificate with alias %q", alias)
+	}
+
+	var out []byte
+	for _, der := range ders {
+		out = append(out, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: der,
+		})...)
+	}
+	return keytoolWrite(c.String("out"), out, 0644)
+}
+
+func ExportKey(c *cli.Context) error {
+	ks, _, _, err := keytoolOpen(c, false)
+	if err != nil {
+		return err
+	}
+
+	alias := c.String("alias")
+	_, kp := ks.Lookup(alias)
+	if kp == nil {
+		return fmt.Errorf("no keypair with alias %q", alias)
+	}
+	if kp.PrivKeyErr != nil {
+		return fmt.Errorf("%q: %v", alias, kp.PrivKeyErr)
+	}
+	var block *pem.Block
+	if c.IsSet("out-password") {
+		block, err = encryptedKeyPEM(kp.PrivateKey,
+			c.String("out-password"))
+	} else {
+		block, err = privateKeyPEM(kp.PrivateKey)
+	}
+	if err != nil {
+		return fmt.Errorf("%q: %v", alias, err)
+	}
+	return keytoolWrite(c.String("out"), pem.EncodeToMemory(block), 0600)
+}
+
+// encryptedKeyPEM returns a PEM block holding a private key as an encrypted
+// PKCS#8 EncryptedPrivateKeyInfo.
+func encryptedKeyPEM(key interface{}, password string) (*pem.Block, error) {
+	raw, err := jks.MarshalPKCS8(key)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(raw)
+	enc, err := jks.EncryptPKCS8(raw, password)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: enc}, nil
+}
+
+func CertReq(c *cli.Context) error {
+	ks, _, _, err := keytoolOpen(c, false)
+	if err != nil {
+		return err
+	}
+
+	alias := c.String("alias")
+	_, kp := ks.Lookup(alias)
+	if kp == nil {
+		return fmt.Errorf("no keypair with alias %q", alias)
+	}
+	var subject *pkix.Name
+	if c.IsSet("subject") {
+		name, err := parseSubject(c.String("subject"))
+		if err != nil {
+			return fmt.Errorf("--subject: %v", err)
+		}
+		subject = &name
+	}
+	var exts []pkix.Extension
+	if c.Bool("copy-san") && len(kp.CertChain) != 0 &&
+		kp.CertChain[0] != nil && kp.CertChain[0].Cert != nil {
+		for _, ext := range kp.CertChain[0].Cert.Extensions {
+			if ext.Id.Equal(oidSubjectAltName) {
+				exts = append(exts, ext)
+			}
+		}
+	}
+	csr, err := kp.CreateCertificateRequest(subject, exts)
+	if err != nil {
+		return err
+	}
+	return keytoolWrite(c.String("out"), csr, 0644)
+}
+
+// oidSubjectAltName identifies the subject alternative name extension.
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+func ImportCert(c *cli.Context) error {
+	policy, err := jks.ParseCollisionPolicy(c.String("collision"))
+	if err != nil {
+		return err
+	}
+	ks, format, opts, err := keytoolOpen(c, true)
+	if err != nil {
+		return err
+	}
+
+	fname := c.String("file")
+	if _, kp := ks.Lookup(c.String("alias")); kp != nil {
+		return importCertReply(c, ks, format, opts, kp.Alias, fname)
+	}
+	der, cert, certErr, err := packLoadCert(fname)
+	if err != nil {
+		return err
+	}
+	if err = ks.AddCert(&jks.Cert{
+		Alias:   c.String("alias"),
+		Raw:     der,
+		Cert:    cert,
+		CertErr: certErr,
+	}, policy); err != nil {
+		return fmt.Errorf("%s: %v", fname, err)
+	}
+	return keytoolSave(c, ks, format, opts)
+}
+
+// importCertReply replaces the certificate chain of the keypair with the given
+// alias with the certificates in fname.
+func importCertReply(c *cli.Context, ks *jks.Keystore, format jks.Format,
+	opts *jks.Options, alias, fname string,
+) error {
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	entries, err := jks.CertsFromPEM(alias, raw)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fname, err)
+	}
+	var certs []*x509.Certificate
+	for _, e := range entries {
+		certs = append(certs, e.Cert)
+	}
+	if err = ks.ImportCertReply(alias, certs); err != nil {
+		return fmt.Errorf("%s: %v", fname, err)
+	}
+	return keytoolSave(c, ks, format, opts)
+}
+
+func ImportPEM(c *cli.Context) error {
+	policy, err := jks.ParseCollisionPolicy(c.String("collision"))
+	if err != nil {
+		return err
+	}
+	ks, format, opts, err := keytoolOpen(c, true)
+	if err != nil {
+		return err
+	}
+
+	fname := c.String("file")
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	kp := &jks.Keypair{Alias: c.String("alias")}
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			kpc := &jks.KeypairCert{Raw: block.Bytes}
+			kpc.Cert, kpc.CertErr = x509.ParseCertificate(
+				block.Bytes)
+			kp.CertChain = append(kp.CertChain, kpc)
+			continue
+		}
+		if kp.PrivateKey != nil {
+			return fmt.Errorf("%q: more than one private key",
+				fname)
+		}
+		if kp.PrivateKey, err = parsePrivateKeyPEM(block); err != nil {
+			return fmt.Errorf("%q: %v", fname, err)
+		}
+	}
+	switch {
+	case kp.PrivateKey == nil:
+		return fmt.Errorf("%q: no private key found", fname)
+	case len(kp.CertChain) == 0:
+		return fmt.Errorf("%q: no certificates found", fname)
+	}
+
+	if err = ks.AddKeypair(kp, policy); err != nil {
+		return fmt.Errorf("%s: %v", fname, err)
+	}
+	return keytoolSave(c, ks, format, opts)
+}
+
+func Delete(c *cli.Context) error {
+	ks, format, opts, err := keytoolOpen(c, true)
+	if err != nil {
+		return err
+	}
+
+	var cs jks.ChangeSet
+	cs.Delete(c.String("alias"))
+	if err = cs.Apply(ks, opts); err != nil {
+		return err
+	}
+	return keytoolSave(c, ks, format, opts)
+}
+
+// keytoolOpen reads and parses the keystore named by the command's argument,
+// in any format. If write is set, the keystore will be written back, so
+// --password is required; and if the file does not exist then an empty JKS
+// keystore is returned. A partial keystore may be returned along with an error.
+func keytoolOpen(c *cli.Context, write bool,
+) (*jks.Keystore, jks.Format, *jks.Options, error) {
+	if c.NArg() != 1 {
+		cli.ShowSubcommandHelp(c)
+		return nil, 0, nil, errors.New("need name of keystore file")
+	}
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if write && opts.SkipVerifyDigest {
+		return nil, 0, nil, errors.New("need --password to write " +
+			"output")
+	}
+
+	fname := c.Args().First()
+	raw, err := readLocation(fname)
+	if write && os.IsNotExist(err) {
+		return new(jks.Keystore), jks.FormatJKS, opts, nil
+	} else if err != nil {
+		return nil, 0, nil, err
+	}
+	ks, format, err := jks.ParseAny(raw, opts)
+	if err != nil {
+		err = fmt.Errorf("%s: %v", fname, err)
+	}
+	return ks, format, opts, err
+}
+
+// keytoolSave packs ks in the format it was read in, and replaces the keystore
+// named by the command's argument.
+func keytoolSave(c *cli.Context, ks *jks.Keystore, format jks.Format,
+	opts *jks.Options,
+) error {
+	raw, err := keytoolPack(ks, format, opts)
+	if err != nil {
+		return err
+	}
+	return replaceLocation(c.Args().First(), raw)
+}
+
+// keytoolPack packs ks in the given format, which must be one we can write.
+func keytoolPack(ks *jks.Keystore, format jks.Format, opts *jks.Options,
+) ([]byte, error) {
+	switch format {
+	case jks.FormatPKCS12:
+		return ks.PackPKCS12(opts)
+	case jks.FormatJKS:
+		return ks.Pack(opts)
+	}
+	return nil, fmt.Errorf("cannot write %s files", format)
+}
+
+// keytoolWrite writes data to the named file, or to standard output if fname
+// is empty. An existing file is given no permissions beyond perm before data
+// is written to it, since OpenFile applies perm only to new files.
+func keytoolWrite(fname string, data []byte, perm os.FileMode) error {
+	if fname == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.Mode().Perm()&^perm != 0 {
+		err = f.Chmod(fi.Mode().Perm() & perm)
+	}
+	if err != nil {
+		_ = f.Close() // ignore errors; return orig err only
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close() // ignore errors; return orig err only
+		return err
+	}
+	return f.Close()
+}
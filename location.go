@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxRemoteSize bounds how much data we will download for a keystore. Real
+// keystores are nowhere near this large.
+const maxRemoteSize = 64 << 20
+
+// locationHandler reads and writes keystore files held somewhere other than
+// the local filesystem. Handlers are registered in locationHandlers, keyed by
+// URL scheme.
+type locationHandler struct {
+	read  func(u *url.URL) ([]byte, error)
+	write func(u *url.URL, data []byte) error
+}
+
+var locationHandlers = map[string]*locationHandler{
+	"http":  httpLocation,
+	"https": httpLocation,
+}
+
+// lookupLocation returns the handler and parsed URL for loc, or a nil handler
+// if loc is a local path.
+func lookupLocation(loc string) (*locationHandler, *url.URL) {
+	i := strings.Index(loc, "://")
+	if i < 0 {
+		return nil, nil
+	}
+	h := locationHandlers[strings.ToLower(loc[:i])]
+	if h == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil, nil
+	}
+	return h, u
+}
+
+// readLocation returns the content of the keystore file at loc, which may be
+// a local path or a URL with a registered scheme. Encrypted files are
+// decrypted (see unseal).
+func readLocation(loc string) ([]byte, error) {
+	var (
+		raw []byte
+		err error
+	)
+	name := loc
+	if h, u := lookupLocation(loc); h != nil {
+		name = redactURL(u)
+		if raw, err = h.read(u); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	} else if raw, err = ioutil.ReadFile(loc); err != nil {
+		return nil, err
+	}
+
+	if raw, err = unseal(raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return raw, nil
+}
+
+// writeLocation stores keystore data at loc, which may be a local path or a
+// URL with a registered scheme. Local files must not already exist. The data
+// is encrypted first if --encrypt-to was given (see seal).
+func writeLocation(loc string, data []byte, perm os.FileMode) error {
+	data, err := seal(data)
+	if err != nil {
+		return err
+	}
+	if h, u := lookupLocation(loc); h != nil {
+		if err := h.write(u, data); err != nil {
+			return fmt.Errorf("%s: %v", redactURL(u), err)
+		}
+		return nil
+	}
+	return writeNewFile(loc, data, perm)
+}
+
+// redactURL returns u as a string suitable for error messages, without any
+// embedded credentials.
+func redactURL(u *url.URL) string {
+	r := *u
+	r.User = nil
+	return r.String()
+}
+
+var httpLocation = &locationHandler{
+	read:  httpRead,
+	write: httpWrite,
+}
+
+var httpClient = &http.Client{
+	Timeout: 2 * time.Minute,
+}
+
+// httpHeaders applies extra request headers from the environment:
+//   - MINIJKS_HTTP_AUTHORIZATION sets the Authorization header;
+//   - MINIJKS_HTTP_HEADERS holds further "Name: value" headers, one per line.
+func httpHeaders(req *http.Request) error {
+	if auth := os.Getenv("MINIJKS_HTTP_AUTHORIZATION"); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	for _, line := range strings.Split(os.Getenv("MINIJKS_HTTP_HEADERS"),
+		"\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		p := strings.SplitN(line, ":", 2)
+		if len(p) != 2 {
+			return fmt.Errorf("invalid header %q in "+
+				"MINIJKS_HTTP_HEADERS", line)
+		}
+		req.Header.Add(strings.TrimSpace(p[0]), strings.TrimSpace(p[1]))
+	}
+	return nil
+}
+
+// httpDo performs a request, returning the response body if the server
+// responds with a 2xx status code.
+func httpDo(req *http.Request) ([]byte, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRemoteSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("server responded %s", resp.Status)
+	}
+	if len(body) > maxRemoteSize {
+		return nil, fmt.Errorf("response exceeds %d bytes",
+			maxRemoteSize)
+	}
+	return body, nil
+}
+
+func httpRead(u *url.URL) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = httpHeaders(req); err != nil {
+		return nil, err
+	}
+	return httpDo(req)
+}
+
+// httpWrite uploads data with a PUT request, or with the method named by
+// MINIJKS_HTTP_METHOD (e.g. POST) if that is set.
+func httpWrite(u *url.URL, data []byte) error {
+	method := http.MethodPut
+	if m := os.Getenv("MINIJKS_HTTP_METHOD"); m != "" {
+		method = strings.ToUpper(m)
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if err = httpHeaders(req); err != nil {
+		return err
+	}
+	_, err = httpDo(req)
+	return err
+}
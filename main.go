@@ -12,10 +12,41 @@ func main() {
 		Name:    "minijks",
 		Version: "1.1.0",
 		Usage:   "inspect, unpack and pack Java keystore files",
+		Flags:   atRestFlags,
+		Before:  atRestBefore,
 		Commands: []*cli.Command{
 			InspectCommand,
 			UnpackCommand,
 			PackCommand,
+			MergeCommand,
+			SnapshotCommand,
+			RestoreCommand,
+			VerifyCommand,
+			ImportNSSCommand,
+			WatchCommand,
+			PinsCommand,
+			ExporterCommand,
+			ExportEnvCommand,
+			GenKeyCommand,
+			CheckRemoteCommand,
+			SignCSRCommand,
+			RevokeCommand,
+			CRLCommand,
+			CoverageCommand,
+			SplitPassCommand,
+			CombinePassCommand,
+			GetCommand,
+			ArchiveCommand,
+			CopyCommand,
+			ListCommand,
+			ExportCertCommand,
+			ExportKeyCommand,
+			ImportCertCommand,
+			ImportPEMCommand,
+			ImportPEMDirCommand,
+			CertReqCommand,
+			DeleteCommand,
+			ConvertCommand,
 		},
 	}
 	if err := app.Run(os.Args); err != nil {
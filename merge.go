@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var MergeCommand = &cli.Command{
+	Name:      "merge",
+	Usage:     "combine several keystore files into one",
+	ArgsUsage: "out.jks in1.jks [in2.jks …]",
+	Action:    Merge,
+	Flags: []cli.Flag{
+		collisionFlag,
+		compatFlag,
+	},
+}
+
+var collisionFlag = &cli.StringFlag{
+	Name:  "collision",
+	Value: jks.CollisionError.String(),
+	Usage: "how to handle duplicate aliases: error, skip, overwrite, " +
+		"suffix or fingerprint",
+}
+
+func init() {
+	MergeCommand.Flags = addJksOptsFlags(MergeCommand.Flags)
+	MergeCommand.Flags = addKeyPolicyFlags(MergeCommand.Flags)
+}
+
+func Merge(c *cli.Context) error {
+	switch c.NArg() {
+	case 0:
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need output file name and input files")
+
+	case 1:
+		return errors.New("need at least one input file")
+	}
+
+	policy, err := jks.ParseCollisionPolicy(c.String("collision"))
+	if err != nil {
+		return err
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if opts.SkipVerifyDigest {
+		return errors.New("need --password to write output")
+	}
+	opts.Compatibility, err = jks.ParseCompatibility(c.String("compat"))
+	if err != nil {
+		return err
+	}
+	keyPolicyFlags(c, opts)
+
+	args := c.Args().Slice()
+	return merge(opts, policy, args[0], args[1:])
+}
+
+func merge(opts *jks.Options, policy jks.CollisionPolicy, outFn string,
+	inFns []string,
+) error {
+	ks := new(jks.Keystore)
+	for _, fn := range inFns {
+		raw, err := readLocation(fn)
+		if err != nil {
+			return err
+		}
+		in, err := jks.Parse(raw, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fn, err)
+		}
+
+		// we can only write out keys that we managed to decrypt
+		for _, kp := range in.Keypairs {
+			if kp.PrivKeyErr != nil {
+				return fmt.Errorf("%s: keypair %q: %v",
+					fn, kp.Alias, kp.PrivKeyErr)
+			}
+		}
+
+		if err = ks.Merge(in, policy); err != nil {
+			return fmt.Errorf("%s: %v", fn, err)
+		}
+	}
+
+	raw, err := ks.Pack(opts)
+	if err != nil {
+		return err
+	}
+
+	return writeLocation(outFn, raw, 0600)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+// verifyOutput describes how the verify command reports its results.
+type verifyOutput struct {
+	// Format is "text", "nagios" or "prometheus-textfile".
+	Format string
+
+	// WarningDays and CriticalDays are the nagios expiry thresholds.
+	WarningDays, CriticalDays int
+
+	// Out, if set, is the file to which Prometheus metrics are written.
+	Out string
+}
+
+// verifyOutputFlags reads the verify command's output flags.
+func verifyOutputFlags(c *cli.Context) (*verifyOutput, error) {
+	out := &verifyOutput{
+		Format:       c.String("format"),
+		WarningDays:  c.Int("warning-days"),
+		CriticalDays: c.Int("critical-days"),
+		Out:          c.String("out"),
+	}
+	switch out.Format {
+	case "text", "nagios", "prometheus-textfile":
+	default:
+		return nil, fmt.Errorf("unknown output format %q", out.Format)
+	}
+	if out.Out != "" && out.Format != "prometheus-textfile" {
+		return nil, fmt.Errorf("--out is only supported with " +
+			"--format prometheus-textfile")
+	}
+	return out, nil
+}
+
+// Nagios plugin exit codes.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosStatus = []string{
+	nagiosOK:       "OK",
+	nagiosWarning:  "WARNING",
+	nagiosCritical: "CRITICAL",
+	nagiosUnknown:  "UNKNOWN",
+}
+
+// writeNagios writes the report as Nagios plugin output: a status line with
+// the days until each entry expires as performance data, followed by any
+// problems as long output. The command then exits with the matching status
+// code. checkErr is the error, if any, from checkKeystore.
+func (out *verifyOutput) writeNagios(w io.Writer, r *verifyReport,
+	checkErr error,
+) error {
+	if checkErr != nil {
+		fmt.Fprintf(w, "KEYSTORE UNKNOWN - %s: %v\n", r.Filename,
+			checkErr)
+		return cli.Exit("", nagiosUnknown)
+	}
+
+	status := nagiosOK
+	var summary []string
+	if r.Problems > 0 {
+		status = nagiosCritical
+		summary = append(summary, fmt.Sprintf("%d problem(s)",
+			r.Problems))
+	}
+
+	var perf []string
+	var first string
+	for _, alias := range sortedAliases(r.Expiry) {
+		days := expiryDays(r.At, r.Expiry[alias])
+		if first == "" ||
+			r.Expiry[alias].Before(r.Expiry[first]) {
+			first = alias
+		}
+		switch {
+		case days < out.CriticalDays:
+			status = nagiosCritical
+		case days < out.WarningDays && status == nagiosOK:
+			status = nagiosWarning
+		}
+		perf = append(perf, fmt.Sprintf("'%s'=%d;%d;%d",
+			strings.ReplaceAll(alias, "'", "''"), days,
+			out.WarningDays, out.CriticalDays))
+	}
+	if first != "" {
+		summary = append(summary, fmt.Sprintf("%s expires in %d "+
+			"days", first, expiryDays(r.At, r.Expiry[first])))
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "no certificates")
+	}
+
+	fmt.Fprintf(w, "KEYSTORE %s - %s: %s", nagiosStatus[status],
+		r.Filename, strings.Join(summary, ", "))
+	if len(perf) > 0 {
+		fmt.Fprintf(w, " | %s", strings.Join(perf, " "))
+	}
+	fmt.Fprintln(w)
+	for _, l := range r.Lines {
+		if l.Problem {
+			fmt.Fprintln(w, l.Text)
+		}
+	}
+
+	if status != nagiosOK {
+		return cli.Exit("", status)
+	}
+	return nil
+}
+
+// writePrometheus writes the report in the Prometheus text exposition format,
+// as read by node_exporter's textfile collector. checkErr is the error, if
+// any, from checkKeystore; the metrics are still written so that the failure
+// can be alerted on, and then checkErr is returned.
+func (out *verifyOutput) writePrometheus(r *verifyReport,
+	checkErr error,
+) error {
+	var buf bytes.Buffer
+	ks := promEscape(r.Filename)
+	success := 0
+	if checkErr == nil && r.Problems == 0 {
+		success = 1
+	}
+
+	promGauge(&buf, "minijks_verify_success", "Whether the keystore "+
+		"was read and no problems were found.")
+	fmt.Fprintf(&buf, "minijks_verify_success{keystore=\"%s\"} %d\n",
+		ks, success)
+
+	promGauge(&buf, "minijks_verify_problems", "Number of problems "+
+		"found in the keystore.")
+	fmt.Fprintf(&buf, "minijks_verify_problems{keystore=\"%s\"} %d\n",
+		ks, r.Problems)
+
+	promGauge(&buf, "minijks_verify_timestamp_seconds", "When the "+
+		"keystore was last verified.")
+	now := time.Now()
+	fmt.Fprintf(&buf, "minijks_verify_timestamp_seconds{keystore=\"%s\"} "+
+		"%d\n", ks, now.Unix())
+
+	if len(r.Expiry) > 0 {
+		promGauge(&buf, "minijks_entry_expiry_days", "Days until "+
+			"the first of the entry's certificates expires.")
+	}
+	for _, alias := range sortedAliases(r.Expiry) {
+		days := r.Expiry[alias].Sub(r.At).Hours() / 24
+		fmt.Fprintf(&buf, "minijks_entry_expiry_days{keystore=\"%s\","+
+			"alias=\"%s\"} %.3f\n", ks, promEscape(alias), days)
+	}
+
+	var err error
+	if out.Out != "" {
+		err = writeFileAtomic(out.Out, buf.Bytes(), 0644)
+	} else {
+		_, err = os.Stdout.Write(buf.Bytes())
+	}
+	if err != nil {
+		return err
+	}
+	return checkErr
+}
+
+// entryExpiry returns the earliest NotAfter time of each entry's parsed
+// certificates, by alias.
+func entryExpiry(ks *jks.Keystore) map[string]time.Time {
+	expiry := make(map[string]time.Time)
+	add := func(alias string, notAfter time.Time) {
+		if t, ok := expiry[alias]; !ok || notAfter.Before(t) {
+			expiry[alias] = notAfter
+		}
+	}
+	for _, cert := range ks.Certs {
+		if cert.Cert != nil {
+			add(cert.Alias, cert.Cert.NotAfter)
+		}
+	}
+	for _, kp := range ks.Keypairs {
+		for _, cert := range kp.CertChain {
+			if cert.Cert != nil {
+				add(kp.Alias, cert.Cert.NotAfter)
+			}
+		}
+	}
+	return expiry
+}
+
+// expiryDays returns the number of whole days from now until notAfter,
+// rounded down; it is negative if notAfter has passed.
+func expiryDays(now, notAfter time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / 24))
+}
+
+// sortedAliases returns the keys of m in sorted order.
+func sortedAliases(m map[string]time.Time) []string {
+	aliases := make([]string, 0, len(m))
+	for alias := range m {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// promGauge writes the HELP and TYPE lines for a gauge.
+func promGauge(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// promEscape escapes a Prometheus label value.
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).
+		Replace(s)
+}
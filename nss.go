@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/lwithers/minijks/jks/nssdb"
+	"github.com/urfave/cli/v2"
+)
+
+var ImportNSSCommand = &cli.Command{
+	Name:      "import-nss",
+	Usage:     "build a truststore from an NSS certificate database",
+	ArgsUsage: "out.jks nssdb",
+	Description: "Reads the CA certificates trusted for the given " +
+		"purpose from an NSS cert9.db (e.g. /etc/pki/nssdb or a " +
+		"Firefox profile directory) and writes them to a new " +
+		"keystore as trusted certificate entries.",
+	Action: ImportNSS,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "purpose",
+			Value: "serverAuth",
+			Usage: "trust purpose to select: serverAuth, " +
+				"clientAuth, emailProtection or codeSigning",
+		},
+		// import-nss has always kept duplicate nicknames under a
+		// suffix
+		&cli.StringFlag{
+			Name:  collisionFlag.Name,
+			Value: jks.CollisionSuffix.String(),
+			Usage: collisionFlag.Usage,
+		},
+		compatFlag,
+	},
+}
+
+func init() {
+	ImportNSSCommand.Flags = addJksOptsFlags(ImportNSSCommand.Flags)
+}
+
+func ImportNSS(c *cli.Context) error {
+	if c.NArg() != 2 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need output file name and NSS database")
+	}
+
+	purpose, err := nssdb.ParsePurpose(c.String("purpose"))
+	if err != nil {
+		return err
+	}
+
+	policy, err := jks.ParseCollisionPolicy(c.String("collision"))
+	if err != nil {
+		return err
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if opts.SkipVerifyDigest {
+		return errors.New("need --password to write output")
+	}
+	opts.Compatibility, err = jks.ParseCompatibility(c.String("compat"))
+	if err != nil {
+		return err
+	}
+
+	return importNSS(opts, purpose, policy, c.Args().Get(0),
+		c.Args().Get(1))
+}
+
+func importNSS(opts *jks.Options, purpose nssdb.Purpose,
+	policy jks.CollisionPolicy, outFn, dbPath string,
+) error {
+	certs, err := nssdb.ReadFile(dbPath)
+	if err != nil {
+		return err
+	}
+
+	ks := new(jks.Keystore)
+	now := time.Now()
+	for _, c := range certs {
+		if !c.TrustedCA[purpose] {
+			continue
+		}
+		if c.Cert == nil {
+			fmt.Fprintf(os.Stderr, "warning: skipping %q: %v\n",
+				c.Label, c.CertErr)
+			continue
+		}
+		alias := strings.ToLower(c.Label)
+		if alias == "" {
+			alias = strings.ToLower(c.Cert.Subject.CommonName)
+		}
+		// NSS nicknames need not be unique once lower-cased
+		err = ks.AddCert(&jks.Cert{
+			Alias:     alias,
+			Timestamp: now,
+			Raw:       c.Raw,
+			Cert:      c.Cert,
+		}, policy)
+		if err != nil {
+			return fmt.Errorf("%s: %v", dbPath, err)
+		}
+	}
+	if len(ks.Certs) == 0 {
+		return fmt.Errorf("%s: no certificates trusted for %v", dbPath,
+			purpose)
+	}
+	fmt.Printf("%d certificates trusted for %v\n", len(ks.Certs),
+		purpose)
+
+	raw, err := ks.Pack(opts)
+	if err != nil {
+		return err
+	}
+	return writeLocation(outFn, raw, 0644)
+}
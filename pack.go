@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
@@ -9,7 +10,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/lwithers/minijks/jks"
@@ -21,6 +24,102 @@ var PackCommand = &cli.Command{
 	Usage:     "pack a directory into a keystore file",
 	ArgsUsage: "in.d out.jks",
 	Action:    Pack,
+	Flags: []cli.Flag{
+		collisionFlag,
+		compatFlag,
+		&cli.StringFlag{
+			Name:  "storetype",
+			Value: "jks",
+			Usage: "output format: jks or pkcs12",
+		},
+		&cli.StringFlag{
+			Name:  "key-protection",
+			Value: jks.KeyProtectionDefault.String(),
+			Usage: "private key encryption: default (readable by " +
+				"Java) or pbes2 (AES-256, but keytool cannot " +
+				"read it in a JKS file)",
+		},
+		&cli.BoolFlag{
+			Name: "reproducible",
+			Usage: "write the same bytes for the same input, " +
+				"with entries sorted by alias and " +
+				"timestamped from $SOURCE_DATE_EPOCH (or " +
+				"1970-01-01)",
+		},
+	},
+}
+
+var compatFlag = &cli.StringFlag{
+	Name:  "compat",
+	Value: jks.Java8.String(),
+	Usage: "oldest Java runtime that must load the output: java8, " +
+		"java11 or java17",
+}
+
+func init() {
+	PackCommand.Flags = addKeyPolicyFlags(PackCommand.Flags)
+}
+
+// addKeyPolicyFlags adds the flags read by keyPolicyFlags.
+func addKeyPolicyFlags(in []cli.Flag) []cli.Flag {
+	return append(in,
+		&cli.IntFlag{
+			Name:  "min-rsa-bits",
+			Usage: "refuse to write RSA keys smaller than this",
+		},
+		&cli.IntFlag{
+			Name:  "min-ec-bits",
+			Usage: "refuse to write EC keys smaller than this",
+		},
+		&cli.StringSliceFlag{
+			Name: "allowed-curve",
+			Usage: "only write EC keys on this curve (e.g. " +
+				"P-256); may be repeated",
+		},
+		disabledAlgorithmFlag,
+		warnDisabledAlgorithmsFlag,
+	)
+}
+
+var disabledAlgorithmFlag = &cli.StringSliceFlag{
+	Name: "disabled-algorithm",
+	Usage: "reject certificates signed using this digest or " +
+		"algorithm (e.g. SHA1), or \"default\" for " +
+		strings.Join(jks.DefaultDisabledAlgorithms, ", ") +
+		"; may be repeated",
+}
+
+var warnDisabledAlgorithmsFlag = &cli.BoolFlag{
+	Name:  "warn-disabled-algorithms",
+	Usage: "only warn about certificates using disabled algorithms",
+}
+
+// keyPolicyFlags copies the key policy flags into opts.
+func keyPolicyFlags(c *cli.Context, opts *jks.Options) {
+	opts.MinRSABits = c.Int("min-rsa-bits")
+	opts.MinECBits = c.Int("min-ec-bits")
+	opts.AllowedCurves = c.StringSlice("allowed-curve")
+	disabledAlgorithmFlags(c, opts)
+}
+
+// disabledAlgorithmFlags copies the signature algorithm policy flags into
+// opts, expanding "default" to jks.DefaultDisabledAlgorithms. Warnings are
+// printed to stderr.
+func disabledAlgorithmFlags(c *cli.Context, opts *jks.Options) {
+	opts.DisabledAlgorithms = nil
+	for _, alg := range c.StringSlice("disabled-algorithm") {
+		if alg == "default" {
+			opts.DisabledAlgorithms = append(
+				opts.DisabledAlgorithms,
+				jks.DefaultDisabledAlgorithms...)
+			continue
+		}
+		opts.DisabledAlgorithms = append(opts.DisabledAlgorithms, alg)
+	}
+	opts.WarnDisabledAlgorithms = c.Bool("warn-disabled-algorithms")
+	opts.Warn = func(err error) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
 }
 
 func Pack(c *cli.Context) error {
@@ -39,6 +138,15 @@ func Pack(c *cli.Context) error {
 	inDir := c.Args().Get(0)
 	outFn := c.Args().Get(1)
 
+	compat, err := jks.ParseCompatibility(c.String("compat"))
+	if err != nil {
+		return err
+	}
+	policy, err := jks.ParseCollisionPolicy(c.String("collision"))
+	if err != nil {
+		return err
+	}
+
 	st, err := os.Stat(inDir)
 	if err != nil {
 		return err
@@ -46,43 +154,62 @@ func Pack(c *cli.Context) error {
 		return fmt.Errorf("%q must be a directory", inDir)
 	}
 
-	f, err := os.OpenFile(outFn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
-	if err != nil {
-		return err
+	storeType := c.String("storetype")
+	switch storeType {
+	case "jks", "pkcs12":
+	default:
+		return fmt.Errorf("unknown store type %q", storeType)
 	}
 
-	if err = pack(f, inDir); err != nil {
-		_ = f.Close()
-		_ = os.Remove(outFn)
+	protection, err := jks.ParseKeyProtection(c.String("key-protection"))
+	if err != nil {
 		return err
 	}
 
-	if err = f.Close(); err != nil {
-		_ = os.Remove(outFn)
+	var buf bytes.Buffer
+	opts := &jks.Options{
+		Compatibility: compat,
+		KeyProtection: protection,
+		Deterministic: c.Bool("reproducible"),
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" &&
+		opts.Deterministic {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SOURCE_DATE_EPOCH: %v", err)
+		}
+		opts.Timestamp = time.Unix(secs, 0)
+	}
+	keyPolicyFlags(c, opts)
+	err = pack(&buf, inDir, opts, policy, storeType)
+	if err != nil {
 		return err
 	}
-
-	return nil
+	return writeLocation(outFn, buf.Bytes(), 0600)
 }
 
-func pack(out io.Writer, inDir string) error {
+// pack writes the keystore described by inDir to out. The password is read
+// from inDir; other settings are taken from baseOpts.
+func pack(out io.Writer, inDir string, baseOpts *jks.Options,
+	policy jks.CollisionPolicy, storeType string,
+) error {
 	certDir := filepath.Join(inDir, "certs")
 	keyDir := filepath.Join(inDir, "keys")
 
 	var (
 		err  error
 		ks   jks.Keystore
-		opts = jks.Options{
-			KeyPasswords: make(map[string]string),
-		}
+		opts = *baseOpts
 	)
+	opts.KeyPasswords = make(map[string]string)
 	opts.Password, err = packPassword(inDir)
 	if err != nil {
 		return err
 	}
 
 	if _, err = os.Stat(certDir); err == nil {
-		if err = packCerts(&opts, &ks, certDir); err != nil {
+		err = packCerts(&opts, &ks, policy, certDir)
+		if err != nil {
 			return err
 		}
 	}
@@ -104,15 +231,46 @@ func pack(out io.Writer, inDir string) error {
 				filepath.Join(keyDir, fi.Name()))
 		}
 		for _, d := range keyDirs {
-			kp, err := packKeypair(&opts, d)
+			kp, err := packKeypair(d)
 			if err != nil {
 				return err
 			}
-			ks.Keypairs = append(ks.Keypairs, kp)
+			alias, err := packAddKeypair(&ks, kp, policy)
+			switch {
+			case err != nil:
+				return fmt.Errorf("%s: %v", d, err)
+			case alias == "":
+				continue // skipped
+			}
+
+			// key the password by the alias the policy chose
+			fname := filepath.Join(d, "password")
+			if _, err = os.Stat(fname); err == nil {
+				opts.KeyPasswords[alias], err = packPassword(d)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if opts.Deterministic {
+		// file modification times vary from one checkout to the
+		// next, so use opts.Timestamp instead
+		for _, cert := range ks.Certs {
+			cert.Timestamp = time.Time{}
+		}
+		for _, kp := range ks.Keypairs {
+			kp.Timestamp = time.Time{}
 		}
 	}
 
-	raw, err := ks.Pack(&opts)
+	var raw []byte
+	if storeType == "pkcs12" {
+		raw, err = ks.PackPKCS12(&opts)
+	} else {
+		raw, err = ks.Pack(&opts)
+	}
 	if err != nil {
 		return err
 	}
@@ -140,7 +298,9 @@ func packPassword(dirname string) (string, error) {
 	return string(p), nil
 }
 
-func packCerts(opts *jks.Options, ks *jks.Keystore, certDir string) error {
+func packCerts(opts *jks.Options, ks *jks.Keystore,
+	policy jks.CollisionPolicy, certDir string,
+) error {
 	f, err := ioutil.ReadDir(certDir)
 	if err != nil {
 		return err
@@ -154,57 +314,67 @@ func packCerts(opts *jks.Options, ks *jks.Keystore, certDir string) error {
 			continue
 		}
 
-		cert, err := packLoadCert(filepath.Join(certDir, fi.Name()))
+		der, cert, certErr, err := packLoadCert(
+			filepath.Join(certDir, fi.Name()))
 		if err != nil {
 			return err
 		}
 
 		alias := filepath.Base(fi.Name())
 		alias = alias[:len(alias)-4] // strip ".pem"
-		ks.Certs = append(ks.Certs, &jks.Cert{
+		err = ks.AddCert(&jks.Cert{
 			Alias:     alias,
 			Timestamp: fi.ModTime(),
+			Raw:       der,
+			CertErr:   certErr,
 			Cert:      cert,
-		})
+		}, policy)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fi.Name(), err)
+		}
 	}
 	return nil
 }
 
-func packKeypair(opts *jks.Options, dir string) (*jks.Keypair, error) {
+// packAddKeypair adds kp to ks, resolving any alias collision according to
+// policy, and returns the alias it was added under, or "" if it was skipped.
+func packAddKeypair(ks *jks.Keystore, kp *jks.Keypair,
+	policy jks.CollisionPolicy,
+) (string, error) {
+	cert, other := ks.Lookup(kp.Alias)
+	collides := cert != nil || other != nil
+	if err := ks.AddKeypair(kp, policy); err != nil {
+		return "", err
+	}
+	switch {
+	case !collides || policy == jks.CollisionOverwrite:
+		return kp.Alias, nil
+	case policy == jks.CollisionSkip:
+		return "", nil
+	}
+	// renamed; the copy was appended
+	return ks.Keypairs[len(ks.Keypairs)-1].Alias, nil
+}
+
+func packKeypair(dir string) (*jks.Keypair, error) {
 	kp := &jks.Keypair{
 		Alias: filepath.Base(dir),
 	}
 
-	fname := filepath.Join(dir, "password")
+	fname := filepath.Join(dir, "privkey.pem")
 	fi, err := os.Stat(fname)
-	if err == nil {
-		opts.KeyPasswords[kp.Alias], err = packPassword(dir)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	fname = filepath.Join(dir, "privkey.pem")
-	if fi, err = os.Stat(fname); err != nil {
+	if err != nil {
 		return nil, err
 	}
 	kp.Timestamp = fi.ModTime()
 
 	block, err := packLoadPem(fname)
-	switch block.Type {
-	case "RSA PRIVATE KEY":
-		kp.PrivateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
-
-	case "EC PRIVATE KEY":
-		kp.PrivateKey, err = x509.ParseECPrivateKey(block.Bytes)
-
-	default:
-		err = fmt.Errorf("%q: unknown private key type %q",
-			fname, block.Type)
-	}
 	if err != nil {
 		return nil, err
 	}
+	if kp.PrivateKey, err = parsePrivateKeyPEM(block); err != nil {
+		return nil, fmt.Errorf("%q: %v", fname, err)
+	}
 
 	f, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -223,19 +393,37 @@ func packKeypair(opts *jks.Options, dir string) (*jks.Keypair, error) {
 			continue
 		}
 
-		cert, err := packLoadCert(fname)
+		der, cert, certErr, err := packLoadCert(fname)
 		if err != nil {
 			return nil, err
 		}
 
 		kp.CertChain = append(kp.CertChain, &jks.KeypairCert{
-			Cert: cert,
+			Raw:     der,
+			Cert:    cert,
+			CertErr: certErr,
 		})
 	}
 
 	return kp, nil
 }
 
+// parsePrivateKeyPEM parses a PEM block holding an unencrypted private key, in
+// PKCS#1, SEC 1 or PKCS#8 form.
+func parsePrivateKeyPEM(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return jks.ParsePKCS8(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unknown private key type %q",
+			block.Type)
+	}
+}
+
 func packLoadPem(fname string) (*pem.Block, error) {
 	pemraw, err := ioutil.ReadFile(fname)
 	if err != nil {
@@ -251,19 +439,24 @@ func packLoadPem(fname string) (*pem.Block, error) {
 	return block, nil
 }
 
-func packLoadCert(fname string) (*x509.Certificate, error) {
+// packLoadCert loads a PEM-encoded certificate. If the certificate cannot be
+// parsed then a warning is printed and its raw DER form is returned along with
+// the parse error in certErr; the JVM may be able to handle certificates that
+// crypto/x509 rejects, so we still pack them.
+func packLoadCert(fname string,
+) (der []byte, cert *x509.Certificate, certErr, err error) {
 	block, err := packLoadPem(fname)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	if block.Type != "CERTIFICATE" {
-		return nil, fmt.Errorf("%q: expected CERTIFICATE but found %q",
-			fname, block.Type)
+		return nil, nil, nil, fmt.Errorf("%q: expected CERTIFICATE "+
+			"but found %q", fname, block.Type)
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("%q: %v", fname, err)
+	cert, certErr = x509.ParseCertificate(block.Bytes)
+	if certErr != nil {
+		fmt.Fprintf(os.Stderr, "warning: %q: %v\n", fname, certErr)
 	}
-	return cert, nil
+	return block.Bytes, cert, certErr, nil
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var PinsCommand = &cli.Command{
+	Name:      "pins",
+	Usage:     "export SPKI (pin-sha256) pins for each keystore entry",
+	ArgsUsage: "keystore.jks",
+	Description: "Prints the base64 SHA-256 SubjectPublicKeyInfo pin of " +
+		"each trusted certificate and of each certificate in each " +
+		"keypair's chain, grouped by alias, for generating mobile " +
+		"or HPKP-style pinning configuration.",
+	Action: Pins,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "output format: text (one HPKP-style line " +
+				"per alias), json or yaml",
+		},
+	},
+}
+
+func init() {
+	PinsCommand.Flags = addJksOptsFlags(PinsCommand.Flags)
+}
+
+func Pins(c *cli.Context) error {
+	switch c.NArg() {
+	case 0:
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need name of keystore file")
+
+	case 1:
+		// OK
+
+	default:
+		return errors.New("can only export pins from one file")
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+
+	format := c.String("format")
+	switch format {
+	case "text", "json", "yaml":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	raw, err := readLocation(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	ks, err := jks.Parse(raw, opts)
+	if err != nil {
+		return err
+	}
+	return writePins(os.Stdout, ks.PinSets(), format)
+}
+
+// writePins writes pin sets in the given format.
+func writePins(w io.Writer, sets []*jks.PinSet, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sets)
+
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(sets); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+
+	for _, ps := range sets {
+		directives := make([]string, len(ps.Pins))
+		for i, pin := range ps.Pins {
+			directives[i] = fmt.Sprintf("pin-sha256=%q", pin)
+		}
+		_, err := fmt.Fprintf(w, "%s: %s\n", ps.Alias,
+			strings.Join(directives, "; "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var RevokeCommand = &cli.Command{
+	Name:      "revoke",
+	Usage:     "record the revocation of a certificate issued by sign-csr",
+	ArgsUsage: "serial|cert.pem",
+	Description: "Adds a certificate to the revocation list kept " +
+		"alongside the CA keystore, identified either by its serial " +
+		"number (decimal, or hex with a 0x prefix) or by a PEM file " +
+		"holding it. Use the crl command to publish the list.",
+	Action: Revoke,
+	Flags: []cli.Flag{
+		revocationsKeystoreFlag,
+		revocationsFlag,
+		&cli.StringFlag{
+			Name:  "reason",
+			Value: jks.ReasonUnspecified.String(),
+			Usage: "RFC 5280 revocation reason, e.g. " +
+				"keyCompromise or superseded",
+		},
+	},
+}
+
+var CRLCommand = &cli.Command{
+	Name:  "crl",
+	Usage: "generate a CRL signed by a CA keypair in a keystore",
+	Description: "Writes a PEM-encoded X.509 CRL listing the " +
+		"certificates in the revocation list kept alongside the CA " +
+		"keystore (see revoke), signed by the CA keypair entry " +
+		"given by --ca-alias. The list's CRL number is incremented.",
+	Action: CRL,
+	Flags: []cli.Flag{
+		revocationsKeystoreFlag,
+		revocationsFlag,
+		&cli.StringFlag{
+			Name:     "ca-alias",
+			Required: true,
+			Usage:    "alias of the CA keypair entry",
+		},
+		&cli.IntFlag{
+			Name:  "days",
+			Value: 7,
+			Usage: "number of days until the next CRL is due",
+		},
+		&cli.StringFlag{
+			Name: "out",
+			Usage: "write the CRL to this new file (default: " +
+				"stdout)",
+		},
+	},
+}
+
+var revocationsKeystoreFlag = &cli.StringFlag{
+	Name:     "keystore",
+	Required: true,
+	Usage:    "keystore holding the CA keypair",
+}
+
+var revocationsFlag = &cli.StringFlag{
+	Name: "revocations",
+	Usage: "revocation list file (default: keystore name + " +
+		"\".revocations.json\")",
+}
+
+func init() {
+	CRLCommand.Flags = addJksOptsFlags(CRLCommand.Flags)
+}
+
+func Revoke(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need serial number or certificate file")
+	}
+	serial, err := revokeSerial(c.Args().First())
+	if err != nil {
+		return err
+	}
+	reason, err := jks.ParseRevocationReason(c.String("reason"))
+	if err != nil {
+		return err
+	}
+
+	fn, err := revocationsFile(c)
+	if err != nil {
+		return err
+	}
+	rl, err := loadRevocations(fn)
+	if err != nil {
+		return err
+	}
+	if err = rl.Revoke(serial, reason, time.Now()); err != nil {
+		return err
+	}
+	return saveRevocations(fn, rl)
+}
+
+func CRL(c *cli.Context) error {
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if c.Int("days") <= 0 {
+		return errors.New("--days must be positive")
+	}
+
+	fn, err := revocationsFile(c)
+	if err != nil {
+		return err
+	}
+	rl, err := loadRevocations(fn)
+	if err != nil {
+		return err
+	}
+
+	filename := c.String("keystore")
+	raw, err := readLocation(filename)
+	if err != nil {
+		return err
+	}
+	ks, err := jks.Parse(raw, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	der, err := ks.GenerateCRL(c.String("ca-alias"), rl,
+		time.Duration(c.Int("days"))*24*time.Hour)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	out := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	if outFn := c.String("out"); outFn != "" {
+		err = writeNewFile(outFn, out, 0644)
+	} else {
+		_, err = os.Stdout.Write(out)
+	}
+	if err != nil {
+		return err
+	}
+	// only record the new CRL number once the CRL has been written
+	return saveRevocations(fn, rl)
+}
+
+// revocationsFile returns the name of the revocation list file given by
+// --revocations, or derived from --keystore.
+func revocationsFile(c *cli.Context) (string, error) {
+	if fn := c.String("revocations"); fn != "" {
+		return fn, nil
+	}
+	keystore := c.String("keystore")
+	if h, _ := lookupLocation(keystore); h != nil {
+		return "", errors.New("need --revocations for a remote " +
+			"keystore")
+	}
+	return keystore + ".revocations.json", nil
+}
+
+// loadRevocations reads a revocation list file. A missing file is treated as
+// an empty list.
+func loadRevocations(fn string) (*jks.RevocationList, error) {
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return new(jks.RevocationList), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rl, err := jks.ReadRevocationList(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return rl, nil
+}
+
+// saveRevocations atomically replaces a revocation list file.
+func saveRevocations(fn string, rl *jks.RevocationList) error {
+	var buf bytes.Buffer
+	if err := rl.WriteJSON(&buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(fn, buf.Bytes(), 0644)
+}
+
+// revokeSerial interprets the argument to revoke: the name of a PEM file
+// holding a certificate, or a serial number.
+func revokeSerial(arg string) (*big.Int, error) {
+	raw, err := ioutil.ReadFile(arg)
+	if err == nil {
+		block, _ := pem.Decode(raw)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s: no PEM certificate found",
+				arg)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", arg, err)
+		}
+		return cert.SerialNumber, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	serial, ok := new(big.Int).SetString(strings.ToLower(arg), 0)
+	if !ok || serial.Sign() <= 0 {
+		return nil, fmt.Errorf("%q is neither a certificate file nor "+
+			"a serial number", arg)
+	}
+	return serial, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var SplitPassCommand = &cli.Command{
+	Name:  "split-pass",
+	Usage: "split a keystore password into Shamir secret shares",
+	Description: "Splits the keystore password into --shares shares, any " +
+		"--threshold of which can be combined with combine-pass to " +
+		"recover it, while fewer reveal nothing about it. Each share " +
+		"is printed on its own line, to be handed to a different " +
+		"holder, so that a high-value keystore can only be opened " +
+		"with a quorum. Give --keystore to check the password " +
+		"before splitting it.",
+	Action: SplitPass,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:     "shares",
+			Required: true,
+			Usage:    "number of shares to produce (at most 255)",
+		},
+		&cli.IntFlag{
+			Name:     "threshold",
+			Required: true,
+			Usage: "number of shares needed to recover the " +
+				"password",
+		},
+		&cli.StringFlag{
+			Name:  "password",
+			Usage: "keystore password",
+		},
+		&cli.StringFlag{
+			Name: "storepass-keyring",
+			Usage: "read keystore password from the OS keyring, " +
+				"as 'service/account'",
+		},
+		sharePassKeystoreFlag,
+	},
+}
+
+var CombinePassCommand = &cli.Command{
+	Name:      "combine-pass",
+	Usage:     "recover a keystore password from Shamir secret shares",
+	ArgsUsage: "[share...]",
+	Description: "Combines shares produced by split-pass, given as " +
+		"arguments or one per line on stdin, and prints the " +
+		"recovered password. Give --keystore to check the password " +
+		"against the keystore's digest, since combining the wrong " +
+		"shares cannot otherwise be detected.",
+	Action: CombinePass,
+	Flags: []cli.Flag{
+		sharePassKeystoreFlag,
+	},
+}
+
+var sharePassKeystoreFlag = &cli.StringFlag{
+	Name:  "keystore",
+	Usage: "keystore to check the password against",
+}
+
+func SplitPass(c *cli.Context) error {
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if opts.SkipVerifyDigest {
+		return errors.New("need --password or --storepass-keyring")
+	}
+	if err := checkSharePassword(c, opts.Password); err != nil {
+		return err
+	}
+
+	k := c.Int("threshold")
+	shares, err := jks.SplitSecret([]byte(opts.Password), c.Int("shares"),
+		k)
+	if err != nil {
+		return err
+	}
+	for _, share := range shares {
+		fmt.Println(formatShare(k, share))
+	}
+	return nil
+}
+
+func CombinePass(c *cli.Context) error {
+	args := c.Args().Slice()
+	if len(args) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				args = append(args, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	var (
+		k      int
+		shares [][]byte
+	)
+	for i, arg := range args {
+		sk, share, err := parseShare(arg)
+		if err != nil {
+			return fmt.Errorf("share #%d: %v", i+1, err)
+		}
+		if i > 0 && sk != k {
+			return fmt.Errorf("share #%d: threshold %d does not "+
+				"match %d", i+1, sk, k)
+		}
+		k = sk
+		shares = append(shares, share)
+	}
+	if len(shares) < k || len(shares) == 0 {
+		return fmt.Errorf("need %d shares, but only %d given", k,
+			len(shares))
+	}
+
+	password, err := jks.CombineShares(shares)
+	if err != nil {
+		return err
+	}
+	if err := checkSharePassword(c, string(password)); err != nil {
+		return err
+	}
+	fmt.Println(string(password))
+	return nil
+}
+
+// checkSharePassword verifies the password against the digest of the
+// keystore given by --keystore, if any.
+func checkSharePassword(c *cli.Context, password string) error {
+	filename := c.String("keystore")
+	if filename == "" {
+		return nil
+	}
+	raw, err := readLocation(filename)
+	if err != nil {
+		return err
+	}
+	err = jks.Verify(raw, password)
+	if errors.Is(err, jks.ErrBadStorePassword) {
+		return fmt.Errorf("%s: password does not match", filename)
+	} else if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// formatShare encodes a share as "threshold-index-hex", e.g. "3-1-8f0a…".
+func formatShare(k int, share []byte) string {
+	return fmt.Sprintf("%d-%d-%s", k, share[0],
+		hex.EncodeToString(share[1:]))
+}
+
+// parseShare decodes a share encoded by formatShare, returning the threshold
+// and the share in the form expected by jks.CombineShares.
+func parseShare(s string) (int, []byte, error) {
+	p := strings.SplitN(s, "-", 3)
+	if len(p) != 3 {
+		return 0, nil, errors.New("invalid share (expected " +
+			"threshold-index-hex)")
+	}
+	k, err := strconv.Atoi(p[0])
+	if err != nil || k < 2 {
+		return 0, nil, fmt.Errorf("invalid threshold %q", p[0])
+	}
+	x, err := strconv.ParseUint(p[1], 10, 8)
+	if err != nil || x == 0 {
+		return 0, nil, fmt.Errorf("invalid index %q", p[1])
+	}
+	y, err := hex.DecodeString(p[2])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid share data: %v", err)
+	}
+	return k, append([]byte{byte(x)}, y...), nil
+}
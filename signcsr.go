@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var SignCSRCommand = &cli.Command{
+	Name:      "sign-csr",
+	Usage:     "issue a certificate using a CA keypair in a keystore",
+	ArgsUsage: "request.csr",
+	Description: "Issues a certificate for a PKCS#10 request (PEM or " +
+		"DER), signed by the CA keypair entry given by --ca-alias. " +
+		"The subject and subject alternative names are taken from " +
+		"the request, and the rest from --profile. The new " +
+		"certificate is written in PEM form, followed by the CA's " +
+		"chain. A CA keypair can be created with \"genkey --ca\".",
+	Action: SignCSR,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "keystore",
+			Required: true,
+			Usage:    "keystore holding the CA keypair",
+		},
+		&cli.StringFlag{
+			Name:     "ca-alias",
+			Required: true,
+			Usage:    "alias of the CA keypair entry",
+		},
+		&cli.StringFlag{
+			Name:  "profile",
+			Value: "tls-server",
+			Usage: "certificate profile: " + jks.CertProfileNames(),
+		},
+		&cli.IntFlag{
+			Name:  "days",
+			Value: int(jks.DefaultValidity / (24 * time.Hour)),
+			Usage: "number of days the certificate is valid for",
+		},
+		&cli.StringSliceFlag{
+			Name: "eku",
+			Usage: "extended key usage, replacing the profile's; " +
+				"may be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "serial",
+			Value: jks.SerialRandom.String(),
+			Usage: "serial number strategy: random or timestamp",
+		},
+		&cli.StringFlag{
+			Name: "out",
+			Usage: "write certificates to this new file " +
+				"(default: stdout)",
+		},
+	},
+}
+
+func init() {
+	SignCSRCommand.Flags = addJksOptsFlags(SignCSRCommand.Flags)
+}
+
+func SignCSR(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need certificate request file")
+	}
+	csrFn := c.Args().First()
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	profile, err := certProfileFlags(c)
+	if err != nil {
+		return err
+	}
+
+	csr, err := loadCSR(csrFn)
+	if err != nil {
+		return err
+	}
+
+	filename := c.String("keystore")
+	raw, err := readLocation(filename)
+	if err != nil {
+		return err
+	}
+	ks, err := jks.Parse(raw, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	chain, err := ks.SignCSR(c.String("ca-alias"), csr, profile)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	var out bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&out, &pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		})
+	}
+	if outFn := c.String("out"); outFn != "" {
+		return writeNewFile(outFn, out.Bytes(), 0644)
+	}
+	_, err = os.Stdout.Write(out.Bytes())
+	return err
+}
+
+// certProfileFlags returns a copy of the profile named by --profile, with the
+// other flags applied.
+func certProfileFlags(c *cli.Context) (*jks.CertProfile, error) {
+	named, ok := jks.CertProfiles[c.String("profile")]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q (expected one of "+
+			"%s)", c.String("profile"), jks.CertProfileNames())
+	}
+	profile := *named
+
+	profile.Validity = time.Duration(c.Int("days")) * 24 * time.Hour
+	if profile.Validity <= 0 {
+		return nil, errors.New("--days must be positive")
+	}
+	var err error
+	profile.Serial, err = jks.ParseSerialStrategy(c.String("serial"))
+	if err != nil {
+		return nil, err
+	}
+	if c.IsSet("eku") {
+		profile.ExtKeyUsage = nil
+		for _, name := range c.StringSlice("eku") {
+			eku, err := jks.ParseExtKeyUsage(name)
+			if err != nil {
+				return nil, err
+			}
+			profile.ExtKeyUsage = append(profile.ExtKeyUsage, eku)
+		}
+	}
+	return &profile, nil
+}
+
+// loadCSR reads a PKCS#10 certificate request in PEM or DER form.
+func loadCSR(fn string) (*x509.CertificateRequest, error) {
+	raw, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(raw); block != nil {
+		switch block.Type {
+		case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+			raw = block.Bytes
+		default:
+			return nil, fmt.Errorf("%s: unexpected PEM block type "+
+				"%q", fn, block.Type)
+		}
+	}
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return csr, nil
+}
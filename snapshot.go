@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// snapshotTimeFormat is used to name snapshots. It avoids characters that are
+// awkward on Windows. Snapshots taken before microseconds were recorded are
+// named with snapshotTimeFormatSec, and are still recognised.
+const (
+	snapshotTimeFormat    = "20060102T150405.000000Z"
+	snapshotTimeFormatSec = "20060102T150405Z"
+)
+
+var snapshotDirFlag = &cli.StringFlag{
+	Name:  "dir",
+	Usage: "directory holding snapshots (default: alongside the keystore)",
+}
+
+var SnapshotCommand = &cli.Command{
+	Name:      "snapshot",
+	Usage:     "take a timestamped, checksummed backup of a keystore file",
+	ArgsUsage: "keystore.jks",
+	Action:    Snapshot,
+	Flags: []cli.Flag{
+		snapshotDirFlag,
+	},
+}
+
+var RestoreCommand = &cli.Command{
+	Name:      "restore",
+	Usage:     "roll a keystore file back to a snapshot",
+	ArgsUsage: "keystore.jks [snapshot]",
+	Action:    Restore,
+	Flags: []cli.Flag{
+		snapshotDirFlag,
+		&cli.BoolFlag{
+			Name:  "list",
+			Usage: "list available snapshots instead of restoring",
+		},
+	},
+}
+
+func Snapshot(c *cli.Context) error {
+	switch c.NArg() {
+	case 0:
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need name of file to snapshot")
+
+	case 1:
+		// OK
+
+	default:
+		return errors.New("can only snapshot one file")
+	}
+
+	fn := c.Args().Get(0)
+	snap, err := snapshot(fn, snapshotDir(c, fn))
+	if err != nil {
+		return err
+	}
+	fmt.Println(snap)
+	return nil
+}
+
+func Restore(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need name of file to restore")
+	}
+
+	fn := c.Args().Get(0)
+	dir := snapshotDir(c, fn)
+	snaps, err := listSnapshots(fn, dir)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("list") {
+		for _, snap := range snaps {
+			fmt.Println(snap)
+		}
+		return nil
+	}
+
+	var snap string
+	switch {
+	case c.NArg() == 2:
+		snap = c.Args().Get(1)
+	case len(snaps) == 0:
+		return fmt.Errorf("no snapshots of %q found in %q", fn, dir)
+	default:
+		snap = snaps[len(snaps)-1]
+	}
+
+	return restore(fn, snap, dir)
+}
+
+// snapshotDir returns the directory holding snapshots of fn.
+func snapshotDir(c *cli.Context, fn string) string {
+	if dir := c.String("dir"); dir != "" {
+		return dir
+	}
+	return filepath.Dir(fn)
+}
+
+// snapshot copies fn into dir under a timestamped name, writing a SHA-256
+// checksum file (in the format used by sha256sum) alongside it. It returns the
+// name of the snapshot.
+func snapshot(fn, dir string) (string, error) {
+	raw, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	// should a snapshot with the same time already exist, add a counter
+	// rather than fail
+	var (
+		stamp = time.Now().UTC().Format(snapshotTimeFormat)
+		snap  string
+	)
+	for n := 0; ; n++ {
+		name := stamp
+		if n != 0 {
+			name += "-" + strconv.Itoa(n)
+		}
+		snap = filepath.Join(dir, filepath.Base(fn)+"."+name+
+			".snapshot")
+		err = writeNewFile(snap, raw, 0600)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(raw)
+	line := fmt.Sprintf("%x  %s\n", sum, filepath.Base(snap))
+	if err = writeNewFile(snap+".sha256", []byte(line), 0600); err != nil {
+		_ = os.Remove(snap)
+		return "", err
+	}
+	return snap, nil
+}
+
+// listSnapshots returns the snapshots of fn found in dir, oldest first.
+func listSnapshots(fn, dir string) ([]string, error) {
+	f, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type found struct {
+		name string
+		at   time.Time
+		n    int
+	}
+	var snaps []found
+	for _, fi := range f {
+		if fi.IsDir() {
+			continue
+		}
+		if at, n, ok := parseSnapshotName(fn, fi.Name()); ok {
+			snaps = append(snaps, found{fi.Name(), at, n})
+		}
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		if !snaps[i].at.Equal(snaps[j].at) {
+			return snaps[i].at.Before(snaps[j].at)
+		}
+		return snaps[i].n < snaps[j].n
+	})
+
+	names := make([]string, len(snaps))
+	for i, snap := range snaps {
+		names[i] = filepath.Join(dir, snap.name)
+	}
+	return names, nil
+}
+
+// parseSnapshotName reports whether name is that of a snapshot of fn, as
+// written by snapshot, and if so returns its time and collision counter. The
+// time must be exactly as snapshot formats it, so that the snapshots of e.g.
+// "ks.jks.old" are not taken for those of "ks.jks".
+func parseSnapshotName(fn, name string) (at time.Time, n int, ok bool) {
+	stamp, ok := strings.CutPrefix(name, filepath.Base(fn)+".")
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	if stamp, ok = strings.CutSuffix(stamp, ".snapshot"); !ok {
+		return time.Time{}, 0, false
+	}
+	if i := strings.IndexByte(stamp, '-'); i >= 0 {
+		var err error
+		n, err = strconv.Atoi(stamp[i+1:])
+		if err != nil || n < 1 || strconv.Itoa(n) != stamp[i+1:] {
+			return time.Time{}, 0, false
+		}
+		stamp = stamp[:i]
+	}
+	for _, layout := range []string{
+		snapshotTimeFormat, snapshotTimeFormatSec,
+	} {
+		at, err := time.Parse(layout, stamp)
+		if err == nil && at.Format(layout) == stamp {
+			return at, n, true
+		}
+	}
+	return time.Time{}, 0, false
+}
+
+// restore verifies the checksum of snap and then atomically replaces fn with
+// it. The current content of fn (if any) is snapshotted first, so that the
+// restore itself can be undone.
+func restore(fn, snap, dir string) error {
+	raw, err := ioutil.ReadFile(snap)
+	if err != nil {
+		return err
+	}
+	if err = verifySnapshot(snap, raw); err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(fn); err == nil {
+		prev, err := snapshot(fn, dir)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot current file: %v",
+				err)
+		}
+		fmt.Fprintf(os.Stderr, "previous content saved as %s\n", prev)
+	}
+
+	return writeFileAtomic(fn, raw, 0600)
+}
+
+// verifySnapshot checks raw against the checksum file written by snapshot.
+func verifySnapshot(snap string, raw []byte) error {
+	line, err := ioutil.ReadFile(snap + ".sha256")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return fmt.Errorf("%s.sha256: malformed checksum file", snap)
+	}
+	exp, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return fmt.Errorf("%s.sha256: malformed checksum file", snap)
+	}
+
+	sum := sha256.Sum256(raw)
+	if !bytes.Equal(sum[:], exp) {
+		return fmt.Errorf("%s: checksum mismatch; refusing to "+
+			"restore", snap)
+	}
+	return nil
+}
+
+// writeNewFile writes data to a file which must not already exist.
+func writeNewFile(fn string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(fn)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(fn)
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic replaces fn with data by writing to a temporary file in the
+// same directory and renaming it into place, so that readers never see a
+// partially-written file.
+func writeFileAtomic(fn string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(fn), "."+filepath.Base(fn)+".")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, fn)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -10,7 +12,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/lwithers/minijks/jks"
@@ -22,6 +27,30 @@ var UnpackCommand = &cli.Command{
 	Usage:     "unpack a keystore file into a directory",
 	ArgsUsage: "keystore.jks",
 	Action:    Unpack,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "output directory (default: input name + \".d\")",
+		},
+		certsOnMismatchFlag,
+		&cli.StringSliceFlag{
+			Name: "try-password",
+			Usage: "candidate keystore password; may be given " +
+				"several times, and the first that matches " +
+				"the digest is used",
+		},
+		&cli.BoolFlag{
+			Name: "trace",
+			Usage: "print each entry's offset, length, type and " +
+				"problems to standard error, to triage a " +
+				"corrupt file",
+		},
+		&cli.IntFlag{
+			Name:  "parallelism",
+			Value: runtime.GOMAXPROCS(0),
+			Usage: "number of private keys to decrypt at once",
+		},
+	},
 }
 
 func init() {
@@ -44,25 +73,70 @@ func Unpack(c *cli.Context) error {
 	out := c.String("out")
 	if out == "" {
 		out = c.Args().Get(0) + ".d"
+		if _, u := lookupLocation(c.Args().Get(0)); u != nil {
+			// a URL with no object key (or one ending in a
+			// slash) names no file to derive the directory from
+			name := path.Base(u.Path)
+			if name == "/" || name == "." || name == ".." ||
+				strings.HasSuffix(u.Path, "/") {
+				return fmt.Errorf("%s: no file name in URL; "+
+					"use --out", c.Args().Get(0))
+			}
+			out = name + ".d"
+		}
 	}
 
 	opts, err := jksOptsFlags(c)
 	if err != nil {
 		return err
 	}
-	return unpack(opts, c.Args().Get(0), out)
+	opts.CertsOnDigestMismatch = c.Bool("certs-on-digest-mismatch")
+	opts.Parallelism = c.Int("parallelism")
+	opts.Trace = c.Bool("trace")
+	candidates := c.StringSlice("try-password")
+	if len(candidates) != 0 && !opts.SkipVerifyDigest {
+		return errors.New("cannot use --try-password with another " +
+			"keystore password")
+	}
+	return unpack(opts, candidates, c.Args().Get(0), out)
 }
 
-func unpack(opts *jks.Options, filename, outdir string) error {
-	raw, err := ioutil.ReadFile(filename)
+// unpack unpacks filename into outdir. If candidates is not empty, the first
+// candidate password which matches the digest is used.
+func unpack(opts *jks.Options, candidates []string, filename, outdir string,
+) error {
+	raw, err := readLocation(filename)
 	if err != nil {
 		return err
 	}
+	if len(candidates) != 0 {
+		i, err := jks.FindPassword(raw, candidates,
+			opts.PasswordEncoding)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		fmt.Fprintf(os.Stderr, "keystore password is candidate #%d\n",
+			i+1)
+		opts.Password, opts.SkipVerifyDigest = candidates[i], false
+	}
 	ks, err := jks.Parse(raw, opts)
 	// any error will be returned below, after unpacking ks
 
 	if ks != nil {
-		if err = os.MkdirAll(outdir, 0700); err != nil {
+		for _, t := range ks.Trace {
+			fmt.Fprintln(os.Stderr, t)
+		}
+	}
+	if errors.Is(err, jks.ErrDigestMismatch) {
+		printDigestMismatch(opts, err)
+	}
+	if ks != nil && opts.SkipVerifyDigest {
+		fmt.Fprintln(os.Stderr, "warning: no keystore password "+
+			"given, so the keystore's integrity has not been "+
+			"verified")
+	}
+	if ks != nil {
+		if err := os.MkdirAll(outdir, 0700); err != nil {
 			return err
 		}
 
@@ -79,7 +153,7 @@ func unpack(opts *jks.Options, filename, outdir string) error {
 			return err
 		}
 	}
-	return err
+	return err // error from jks.Parse
 }
 
 func unpackInto(opts *jks.Options, ks *jks.Keystore, outdir string) error {
@@ -107,12 +181,12 @@ func unpackInto(opts *jks.Options, ks *jks.Keystore, outdir string) error {
 	usedFilenames := make(map[string]int)
 	for _, cert := range ks.Certs {
 		if cert.CertErr != nil {
-			reportErr(fmt.Errorf("certificate %q: %v",
-				cert.Alias, cert.CertErr))
-			continue
+			// still unpack it; the JVM may be happy with it
+			fmt.Fprintf(os.Stderr, "warning: certificate %q: %v\n",
+				cert.Alias, cert.CertErr)
 		}
 		n := uniqueName(cert.Alias, usedFilenames)
-		fn, err := unpackCertificate(cert.Raw,
+		fn, err := unpackCertificate(cert.DER(),
 			outdir, "certs", n+".pem")
 		reportErr(err)
 		_ = os.Chtimes(fn, time.Now(), cert.Timestamp) // errors ignored
@@ -144,7 +218,12 @@ func unpackInto(opts *jks.Options, ks *jks.Keystore, outdir string) error {
 
 		// save the certificate chain
 		for i, cert := range kp.CertChain {
-			_, err = unpackCertificate(cert.Raw, outdir, "keys", n,
+			if cert.CertErr != nil {
+				fmt.Fprintf(os.Stderr, "warning: keypair %q "+
+					"certificate #%d: %v\n", kp.Alias,
+					i+1, cert.CertErr)
+			}
+			_, err = unpackCertificate(cert.DER(), outdir, "keys", n,
 				fmt.Sprintf("cert-%04d.pem", i+1))
 			reportErr(err)
 		}
@@ -191,29 +270,16 @@ func unpackCertificate(der []byte, pathParts ...string) (string, error) {
 }
 
 func unpackPrivateKey(key interface{}, pathParts ...string) (string, error) {
-	var (
-		err   error
-		block pem.Block
-	)
-	switch key := key.(type) {
-	case *rsa.PrivateKey:
-		block.Type = "RSA PRIVATE KEY"
-		block.Bytes = x509.MarshalPKCS1PrivateKey(key)
-	case *ecdsa.PrivateKey:
-		block.Type = "EC PRIVATE KEY"
-		block.Bytes, err = x509.MarshalECPrivateKey(key)
-		if err != nil {
-			return "", err
-		}
-	default:
-		return "", fmt.Errorf("unknown private key type %T", key)
+	block, err := privateKeyPEM(key)
+	if err != nil {
+		return "", err
 	}
 
 	fn, f, err := unpackOpen(0600, pathParts...)
 	if err != nil {
 		return "", err
 	}
-	if err = pem.Encode(f, &block); err != nil {
+	if err = pem.Encode(f, block); err != nil {
 		_ = f.Close() // ignore errors; return orig err only
 		_ = os.Remove(fn)
 		return "", err
@@ -225,6 +291,35 @@ func unpackPrivateKey(key interface{}, pathParts ...string) (string, error) {
 	return fn, nil
 }
 
+// privateKeyPEM returns a PEM block holding an unencrypted private key: PKCS#1
+// for RSA keys, SEC 1 for EC keys and PKCS#8 for Ed25519 and DSA keys.
+func privateKeyPEM(key interface{}) (*pem.Block, error) {
+	var (
+		err   error
+		block pem.Block
+	)
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		block.Type = "RSA PRIVATE KEY"
+		block.Bytes = x509.MarshalPKCS1PrivateKey(key)
+	case *ecdsa.PrivateKey:
+		block.Type = "EC PRIVATE KEY"
+		block.Bytes, err = x509.MarshalECPrivateKey(key)
+	case ed25519.PrivateKey:
+		block.Type = "PRIVATE KEY"
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(key)
+	case *dsa.PrivateKey:
+		block.Type = "PRIVATE KEY"
+		block.Bytes, err = jks.MarshalPKCS8(key)
+	default:
+		err = fmt.Errorf("unknown private key type %T", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
 func uniqueName(in string, used map[string]int) string {
 	// only allow alphanumeric and a couple of specific punctuation chars
 	var b bytes.Buffer
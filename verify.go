@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var VerifyCommand = &cli.Command{
+	Name:      "verify",
+	Usage:     "check a keystore for problems",
+	ArgsUsage: "keystore.jks",
+	Description: "Checks the keystore's integrity digest (if the " +
+		"password is given), that private keys can be decrypted, " +
+		"that certificates are currently valid (or were valid at " +
+		"the time given by --at), that keypair " +
+		"certificate chains are correctly structured and signed, " +
+		"and that no certificate uses a disabled signature " +
+		"algorithm. Exits with an error if any problem is found. " +
+		"The nagios and prometheus-textfile formats report the " +
+		"results, along with the days until each entry expires, " +
+		"for monitoring agents.",
+	Action: Verify,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "output format: text, nagios or " +
+				"prometheus-textfile",
+		},
+		&cli.IntFlag{
+			Name:  "warning-days",
+			Value: 30,
+			Usage: "with --format nagios, warn if any entry " +
+				"expires within this many days",
+		},
+		&cli.IntFlag{
+			Name:  "critical-days",
+			Value: 7,
+			Usage: "with --format nagios, report critical if any " +
+				"entry expires within this many days",
+		},
+		&cli.StringFlag{
+			Name: "out",
+			Usage: "with --format prometheus-textfile, " +
+				"atomically write the metrics to this file " +
+				"rather than stdout",
+		},
+		&cli.StringFlag{
+			Name: "ct",
+			Usage: "also check that leaf certificates appear in " +
+				"Certificate Transparency logs, using the " +
+				"named client (" + ctClientNames() + ")",
+		},
+		&cli.StringFlag{
+			Name:  "ct-endpoint",
+			Usage: "override the URL of the CT client's service",
+		},
+		&cli.IntFlag{
+			Name: "max-chain-length",
+			Usage: "report keypair certificate chains longer " +
+				"than this",
+		},
+		&cli.StringFlag{
+			Name: "at",
+			Usage: "check validity as of this time (RFC 3339, or " +
+				"a date such as 2006-01-02) rather than now",
+		},
+		disabledAlgorithmFlag,
+		warnDisabledAlgorithmsFlag,
+	},
+}
+
+func init() {
+	VerifyCommand.Flags = addJksOptsFlags(VerifyCommand.Flags)
+}
+
+func Verify(c *cli.Context) error {
+	switch c.NArg() {
+	case 0:
+		cli.ShowSubcommandHelp(c)
+		return errors.New("need name of file to verify")
+
+	case 1:
+		// OK
+
+	default:
+		return errors.New("can only verify one file")
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	disabledAlgorithmFlags(c, opts)
+	opts.MaxChainLength = c.Int("max-chain-length")
+
+	var ct ctClient
+	if name := c.String("ct"); name != "" {
+		newClient := ctClients[name]
+		if newClient == nil {
+			return fmt.Errorf("unknown CT client %q (expected "+
+				"one of %s)", name, ctClientNames())
+		}
+		ct = newClient(c.String("ct-endpoint"))
+	}
+
+	out, err := verifyOutputFlags(c)
+	if err != nil {
+		return err
+	}
+
+	at := time.Now()
+	if s := c.String("at"); s != "" {
+		if at, err = parseTime(s); err != nil {
+			return fmt.Errorf("--at: %v", err)
+		}
+	}
+
+	return verify(c.Context, opts, c.Args().Get(0), ct, at, out)
+}
+
+// parseTime parses a time given on the command line, either in RFC 3339
+// format or as a date (which is taken as midnight UTC).
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// verifyReport holds the results of checking a keystore.
+type verifyReport struct {
+	// Filename is the keystore that was checked.
+	Filename string
+
+	// At is the time as of which certificate validity was checked.
+	At time.Time
+
+	// Lines holds the messages to print in text mode, in order.
+	Lines []verifyLine
+
+	// Problems counts the lines which report a problem.
+	Problems int
+
+	// Expiry holds the earliest NotAfter time of each entry's
+	// certificates, by alias. Entries with no parseable certificate are
+	// omitted.
+	Expiry map[string]time.Time
+}
+
+// verifyLine is a single message in a verifyReport.
+type verifyLine struct {
+	Problem bool
+	Text    string
+}
+
+func (r *verifyReport) note(format string, args ...interface{}) {
+	r.Lines = append(r.Lines, verifyLine{Text: fmt.Sprintf(format,
+		args...)})
+}
+
+func (r *verifyReport) problem(format string, args ...interface{}) {
+	r.Lines = append(r.Lines, verifyLine{
+		Problem: true,
+		Text:    fmt.Sprintf(format, args...),
+	})
+	r.Problems++
+}
+
+// verify checks the keystore at filename as of the time at, writing the
+// results as described by out. If ct is not nil, leaf certificates are also
+// looked up in CT logs.
+func verify(ctx context.Context, opts *jks.Options, filename string,
+	ct ctClient, at time.Time, out *verifyOutput,
+) error {
+	r, err := checkKeystore(ctx, opts, filename, ct, at)
+	switch out.Format {
+	case "nagios":
+		return out.writeNagios(os.Stdout, r, err)
+	case "prometheus-textfile":
+		return out.writePrometheus(r, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, l := range r.Lines {
+		if l.Problem {
+			fmt.Print("problem: ")
+		}
+		fmt.Println(l.Text)
+	}
+	if r.Problems > 0 {
+		return fmt.Errorf("%s: %d problem(s) found", filename,
+			r.Problems)
+	}
+	fmt.Printf("%s: OK\n", filename)
+	return nil
+}
+
+// checkKeystore checks the keystore at filename, with certificate validity
+// checked as of the time at, returning a report of any problems found. An
+// error is returned only if the keystore cannot be read or parsed at all.
+func checkKeystore(ctx context.Context, opts *jks.Options, filename string,
+	ct ctClient, at time.Time,
+) (*verifyReport, error) {
+	r := &verifyReport{Filename: filename, At: at}
+	raw, err := readLocation(filename)
+	if err != nil {
+		return r, err
+	}
+	ks, err := jks.Parse(raw, opts)
+	if err != nil {
+		return r, err
+	}
+	r.Expiry = entryExpiry(ks)
+	if opts.SkipVerifyDigest {
+		r.note("note: no password given; integrity digest and " +
+			"private keys not checked")
+	}
+
+	for _, cert := range ks.Certs {
+		if cert.Cert == nil {
+			r.problem("%s: cannot parse certificate: %v",
+				cert.Alias, cert.CertErr)
+		}
+	}
+
+	for _, kp := range ks.Keypairs {
+		if !opts.SkipVerifyDigest && kp.PrivKeyErr != nil {
+			r.problem("%s: cannot decrypt private key: %v",
+				kp.Alias, kp.PrivKeyErr)
+		}
+		if len(kp.CertChain) == 0 {
+			r.problem("%s: no certificate chain", kp.Alias)
+			continue
+		}
+		for i, cert := range kp.CertChain {
+			if cert.Cert == nil {
+				r.problem("%s: chain[%d]: cannot parse "+
+					"certificate: %v", kp.Alias, i,
+					cert.CertErr)
+			}
+		}
+
+		leaf := kp.CertChain[0].Cert
+		if ct == nil || leaf == nil {
+			continue
+		}
+		logged, err := ct.Logged(ctx, leaf)
+		switch {
+		case err != nil:
+			r.problem("%s: CT lookup failed: %v", kp.Alias, err)
+		case !logged:
+			r.problem("%s: leaf certificate not found in CT logs",
+				kp.Alias)
+		default:
+			r.note("%s: leaf certificate found in CT logs",
+				kp.Alias)
+		}
+	}
+
+	var verr *jks.ValidationError
+	if err := ks.ValidateAt(opts, at); errors.As(err, &verr) {
+		for _, p := range verr.Problems {
+			r.problem("%v", p)
+		}
+	} else if err != nil {
+		r.problem("%v", err)
+	}
+
+	return r, nil
+}
+
+// ctClientNames returns the names of the registered CT clients.
+func ctClientNames() string {
+	var names []string
+	for name := range ctClients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/lwithers/minijks/jks"
+	"github.com/urfave/cli/v2"
+)
+
+var WatchCommand = &cli.Command{
+	Name:  "watch",
+	Usage: "regenerate a keystore whenever PEM inputs change",
+	Description: "Builds the output keystore from a PEM certificate " +
+		"chain and private key (and optionally a CA bundle), then " +
+		"watches the inputs and rebuilds the keystore each time " +
+		"they change. Intended to run as a sidecar alongside Java " +
+		"workloads whose certificates are rotated on disk, e.g. by " +
+		"cert-manager.",
+	Action: Watch,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "cert",
+			Usage: "PEM certificate chain, leaf first",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "PEM private key",
+		},
+		&cli.StringFlag{
+			Name:  "ca",
+			Usage: "PEM CA bundle to add as trusted certificates",
+		},
+		&cli.StringFlag{
+			Name: "secret-dir",
+			Usage: "mounted Kubernetes TLS secret holding " +
+				"tls.crt, tls.key and optionally ca.crt " +
+				"(instead of --cert, --key and --ca)",
+		},
+		&cli.StringFlag{
+			Name:     "out",
+			Usage:    "keystore file to write",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "alias",
+			Value: "tls",
+			Usage: "alias of the keypair entry",
+		},
+		&cli.DurationFlag{
+			Name:  "debounce",
+			Value: time.Second,
+			Usage: "wait this long after a change before " +
+				"rebuilding",
+		},
+		&cli.BoolFlag{
+			Name:  "once",
+			Usage: "build the keystore once and exit",
+		},
+		compatFlag,
+	},
+}
+
+func init() {
+	WatchCommand.Flags = addJksOptsFlags(WatchCommand.Flags)
+	WatchCommand.Flags = addKeyPolicyFlags(WatchCommand.Flags)
+}
+
+// watchConfig holds the settings for the watch command.
+type watchConfig struct {
+	opts          *jks.Options
+	certFn, keyFn string
+	caFn          string
+	secretDir     string
+	outFn         string
+	alias         string
+	debounce      time.Duration
+
+	// lastSum is a checksum over the inputs of the last successful
+	// build, so that unrelated changes (including our own writes, if the
+	// output is alongside the inputs) do not cause a rebuild.
+	lastSum []byte
+}
+
+func Watch(c *cli.Context) error {
+	if c.NArg() != 0 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("unexpected arguments")
+	}
+
+	opts, err := jksOptsFlags(c)
+	if err != nil {
+		return err
+	}
+	if opts.SkipVerifyDigest {
+		return errors.New("need --password to write output")
+	}
+	opts.Compatibility, err = jks.ParseCompatibility(c.String("compat"))
+	if err != nil {
+		return err
+	}
+	keyPolicyFlags(c, opts)
+
+	w := &watchConfig{
+		opts:      opts,
+		certFn:    c.String("cert"),
+		keyFn:     c.String("key"),
+		caFn:      c.String("ca"),
+		secretDir: c.String("secret-dir"),
+		outFn:     c.String("out"),
+		alias:     c.String("alias"),
+		debounce:  c.Duration("debounce"),
+	}
+	switch {
+	case w.secretDir != "" && (w.certFn != "" || w.keyFn != "" ||
+		w.caFn != ""):
+		return errors.New("cannot use --secret-dir with --cert, " +
+			"--key or --ca")
+	case w.secretDir == "" && (w.certFn == "" || w.keyFn == ""):
+		return errors.New("need --cert and --key, or --secret-dir")
+	}
+	if err = w.rebuild(); err != nil || c.Bool("once") {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return w.watch(ctx)
+}
+
+// watch rebuilds the keystore each time an input changes, until ctx is
+// cancelled. We watch the directories holding the inputs rather than the
+// files themselves, since Kubernetes updates mounted secrets by swapping a
+// symlink, which would not be seen by a watch on the old file.
+func (w *watchConfig) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := make(map[string]bool)
+	for _, fn := range []string{w.certFn, w.keyFn, w.caFn} {
+		if fn == "" {
+			continue
+		}
+		dirs[filepath.Dir(fn)] = true
+	}
+	if w.secretDir != "" {
+		dirs[w.secretDir] = true
+	}
+	for dir := range dirs {
+		if err = watcher.Add(dir); err != nil {
+			return fmt.Errorf("%s: %v", dir, err)
+		}
+	}
+
+	timer := time.NewTimer(0)
+	<-timer.C
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			timer.Reset(w.debounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("watch: %v", err)
+
+		case <-timer.C:
+			if err := w.rebuild(); err != nil {
+				// keep the previous keystore; the inputs may
+				// be part-way through being rotated
+				log.Printf("not rebuilding %s: %v",
+					w.outFn, err)
+			}
+		}
+	}
+}
+
+// rebuild regenerates the output keystore if the inputs have changed since
+// the last successful build.
+func (w *watchConfig) rebuild() error {
+	ks, err := w.load()
+	if err != nil {
+		return err
+	}
+
+	// checksum the content rather than the input files, so that a
+	// secret update that changes nothing does not cause a rewrite
+	h := sha256.New()
+	for _, kp := range ks.Keypairs {
+		der, err := x509.MarshalPKCS8PrivateKey(kp.PrivateKey)
+		if err != nil {
+			return err
+		}
+		h.Write(der)
+		for _, cert := range kp.CertChain {
+			h.Write(cert.Raw)
+		}
+	}
+	for _, cert := range ks.Certs {
+		h.Write(cert.Raw)
+	}
+	sum := h.Sum(nil)
+	if bytes.Equal(sum, w.lastSum) {
+		return nil
+	}
+
+	raw, err := ks.Pack(w.opts)
+	if err == nil {
+		raw, err = seal(raw)
+	}
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(w.outFn, raw, 0600); err != nil {
+		return err
+	}
+
+	w.lastSum = sum
+	leaf := ks.Keypairs[0].CertChain[0].Cert
+	log.Printf("wrote %s (%s, expires %s)", w.outFn, leaf.Subject,
+		leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// load builds a keystore holding a single keypair and any CA certificates
+// from the inputs.
+func (w *watchConfig) load() (*jks.Keystore, error) {
+	if w.secretDir != "" {
+		sd, err := jks.LoadSecretDir(w.secretDir, w.alias)
+		if err != nil {
+			return nil, err
+		}
+		if sd.Keystore == nil {
+			return nil, fmt.Errorf("%s: no %s", w.secretDir,
+				jks.SecretCertFile)
+		}
+		if sd.Truststore != nil {
+			sd.Keystore.Certs = sd.Truststore.Certs
+		}
+		return sd.Keystore, nil
+	}
+
+	certPEM, err := ioutil.ReadFile(w.certFn)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(w.keyFn)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := jks.KeypairFromPEM(w.alias, certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	ks := &jks.Keystore{Keypairs: []*jks.Keypair{kp}}
+
+	if w.caFn != "" {
+		caPEM, err := ioutil.ReadFile(w.caFn)
+		if err != nil {
+			return nil, err
+		}
+		ks.Certs, err = jks.CertsFromPEM("ca", caPEM)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", w.caFn, err)
+		}
+	}
+	return ks, nil
+}